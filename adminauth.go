@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AdminKey is a named static credential for the admin API. The name
+// identifies the holder in logs and audit events.
+type AdminKey struct {
+	Name string
+	Key  string
+}
+
+// parseAdminKeys parses "name:key" entries from ADMIN_API_KEYS.
+func parseAdminKeys(entries []string) ([]AdminKey, error) {
+	keys := make([]AdminKey, 0, len(entries))
+	for _, e := range entries {
+		name, key, ok := strings.Cut(e, ":")
+		if !ok || name == "" || len(key) < 16 {
+			return nil, fmt.Errorf("ADMIN_API_KEYS entries must be name:key with a key of at least 16 characters")
+		}
+		keys = append(keys, AdminKey{Name: name, Key: key})
+	}
+	return keys, nil
+}
+
+// APIKeyAuth authenticates admin callers by a static key sent as
+// "Authorization: ApiKey <key>". A matching key yields a service principal
+// holding only the admin scope.
+func APIKeyAuth(keys []AdminKey) func(http.HandlerFunc) http.HandlerFunc {
+	// Compare fixed-length digests so timing reveals neither content nor length.
+	digests := make([][32]byte, len(keys))
+	for i, k := range keys {
+		digests[i] = sha256.Sum256([]byte(k.Key))
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			scheme, key, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "ApiKey") || key == "" {
+				deny(w, r, http.StatusUnauthorized, "missing admin API key")
+				return
+			}
+
+			got := sha256.Sum256([]byte(key))
+			match := -1
+			for i := range digests {
+				if subtle.ConstantTimeCompare(got[:], digests[i][:]) == 1 {
+					match = i
+				}
+			}
+			if match < 0 {
+				deny(w, r, http.StatusUnauthorized, "invalid admin API key")
+				return
+			}
+
+			ctx := contextWithClaims(r.Context(), Claims{
+				Subject: "apikey:" + keys[match].Name,
+				Kind:    PrincipalService,
+				Scopes:  []string{ScopeAdmin},
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// newAdminAuth returns the authentication for admin routes, which is
+// independent of the user-facing configuration when ADMIN_API_KEYS or
+// ADMIN_AUDIENCES is set. Admin routes always require the admin scope, so
+// ordinary user tokens never reach them.
+func newAdminAuth(cfg Config) func(http.HandlerFunc) http.HandlerFunc {
+	var auth func(http.HandlerFunc) http.HandlerFunc
+	switch {
+	case len(cfg.AdminAPIKeys) > 0:
+		auth = APIKeyAuth(cfg.AdminAPIKeys)
+	case len(cfg.AdminAudiences) > 0:
+		opts := jwtOptions(cfg)
+		opts.Audiences = AudiencePolicy{Service: cfg.AdminAudiences}
+		opts.DevBypass = false
+		opts.Actors = nil
+		auth = JWTAuth(opts)
+	default:
+		auth = newAuth(cfg)
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return auth(RequireScope(ScopeAdmin)(next))
+	}
+}
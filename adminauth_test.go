@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	keys, err := parseAdminKeys([]string{"ops:0123456789abcdef0123"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got Claims
+	handler := APIKeyAuth(keys)(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ClaimsFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		header string
+		want   int
+	}{
+		{"ApiKey 0123456789abcdef0123", http.StatusOK},
+		{"ApiKey wrong-key-wrong-key", http.StatusUnauthorized},
+		{"Bearer 0123456789abcdef0123", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/api/v1/admin/corrections", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%q: expected %d, got %d", tc.header, tc.want, w.Code)
+		}
+	}
+	if got.Subject != "apikey:ops" || !got.HasScope(ScopeAdmin) {
+		t.Fatalf("unexpected claims: %+v", got)
+	}
+
+	if _, err := parseAdminKeys([]string{"ops:short"}); err == nil {
+		t.Fatal("expected error for short key")
+	}
+}
+
+func TestNewRouter_AdminSurface(t *testing.T) {
+	prefs := NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{})
+	hs := Handlers{Prefs: prefs, Corrections: NewCorrectionsHandler(prefs, newMockCorrectionStore())}
+	keys, _ := parseAdminKeys([]string{"ops:0123456789abcdef0123"})
+	cfg := Config{AuthMode: AuthModeJWT, JWTSecret: testSecret, AdminAPIKeys: keys}
+
+	send := func(h http.Handler, auth string) int {
+		req := httptest.NewRequest("GET", "/api/v1/admin/corrections", nil)
+		req.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	userToken := "Bearer " + makeToken("user1", testSecret, jwt.SigningMethodHS256)
+
+	router := NewRouter(hs, cfg, testLogger())
+	if code := send(router, userToken); code != http.StatusUnauthorized {
+		t.Fatalf("expected user token rejected by admin auth, got %d", code)
+	}
+	if code := send(router, "ApiKey 0123456789abcdef0123"); code != http.StatusOK {
+		t.Fatalf("expected admin key accepted, got %d", code)
+	}
+
+	cfg.AdminPort = "9090"
+	router = NewRouter(hs, cfg, testLogger())
+	if code := send(router, "ApiKey 0123456789abcdef0123"); code != http.StatusNotFound {
+		t.Fatalf("expected admin routes absent from public router, got %d", code)
+	}
+	if code := send(NewAdminRouter(hs, cfg, testLogger()), "ApiKey 0123456789abcdef0123"); code != http.StatusOK {
+		t.Fatalf("expected admin routes on admin router, got %d", code)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// Deprecated keys are declared in the preference schema; one with
+// ReplacedBy is an alias of the key that replaced it. Reads of an alias
+// return the replacement's value once it is set, and writes are mirrored to
+// the replacement (or rejected with RejectDeprecatedWrites), so old clients
+// keep working while new ones move to the new key. Responses touching a
+// deprecated key carry Deprecation and Warning headers.
+
+// deprecated returns the definition of key if the schema declares it
+// deprecated.
+func (h *PreferencesHandler) deprecated(key string) (KeyDef, bool) {
+	schema := h.opts.Schema.Get()
+	if schema == nil {
+		return KeyDef{}, false
+	}
+	def, ok := schema.Lookup(key)
+	return def, ok && def.Deprecated
+}
+
+// aliasTarget returns the key replacing key, if any.
+func (h *PreferencesHandler) aliasTarget(key string) (string, bool) {
+	def, ok := h.deprecated(key)
+	return def.ReplacedBy, ok && def.ReplacedBy != ""
+}
+
+// aliasReadKeys extends a ?keys= filter with the replacements of any
+// aliases it names, so they can be resolved.
+func (h *PreferencesHandler) aliasReadKeys(keys []string) []string {
+	out := keys
+	for _, k := range keys {
+		if target, ok := h.aliasTarget(k); ok && !slices.Contains(out, target) {
+			out = append(slices.Clip(out), target)
+		}
+	}
+	return out
+}
+
+// resolveAliases returns prefs with each alias given its replacement's
+// value, where that is set. When keys is non-empty, only those keys are
+// returned. prefs is not modified.
+func (h *PreferencesHandler) resolveAliases(prefs map[string]any, keys []string) map[string]any {
+	schema := h.opts.Schema.Get()
+	if schema == nil {
+		return prefs
+	}
+	out := maps.Clone(prefs)
+	for _, def := range schema.Keys {
+		if def.ReplacedBy == "" {
+			continue
+		}
+		if v, ok := prefs[def.ReplacedBy]; ok {
+			if out == nil {
+				out = make(map[string]any)
+			}
+			out[def.Name] = v
+		}
+	}
+	if len(keys) > 0 {
+		maps.DeleteFunc(out, func(k string, _ any) bool { return !slices.Contains(keys, k) })
+	}
+	return out
+}
+
+// mirrorAliases copies each alias set in prefs to its replacement, unless
+// prefs sets the replacement itself, and adds the replacements of removed
+// aliases to remove. It returns the new removal list.
+func (h *PreferencesHandler) mirrorAliases(prefs map[string]any, remove []string) []string {
+	if h.opts.RejectDeprecatedWrites {
+		return remove
+	}
+	for k, v := range prefs {
+		if target, ok := h.aliasTarget(k); ok {
+			if _, explicit := prefs[target]; !explicit {
+				prefs[target] = v
+			}
+		}
+	}
+	for _, k := range remove {
+		if target, ok := h.aliasTarget(k); ok && !slices.Contains(remove, target) {
+			if _, set := prefs[target]; !set {
+				remove = append(remove, target)
+			}
+		}
+	}
+	return remove
+}
+
+// deprecationViolation returns why a write to key is rejected, or "".
+func (h *PreferencesHandler) deprecationViolation(key string) string {
+	if !h.opts.RejectDeprecatedWrites {
+		return ""
+	}
+	def, ok := h.deprecated(key)
+	if !ok {
+		return ""
+	}
+	if def.ReplacedBy != "" {
+		return "key is deprecated; use " + def.ReplacedBy
+	}
+	return "key is deprecated"
+}
+
+// warnDeprecated adds Deprecation and Warning headers naming the deprecated
+// keys among keys.
+func (h *PreferencesHandler) warnDeprecated(w http.ResponseWriter, keys []string) {
+	for _, k := range slices.Sorted(slices.Values(keys)) {
+		def, ok := h.deprecated(k)
+		if !ok {
+			continue
+		}
+		msg := k + " is deprecated"
+		if def.ReplacedBy != "" {
+			msg += "; use " + def.ReplacedBy
+		}
+		w.Header().Set("Deprecation", "true")
+		w.Header().Add("Warning", fmt.Sprintf("299 - %q", msg))
+	}
+}
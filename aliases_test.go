@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// staticSchema implements SchemaSource for testing.
+type staticSchema PreferenceSchema
+
+func (s staticSchema) LoadSchema(context.Context) (*PreferenceSchema, error) {
+	schema := PreferenceSchema(s)
+	return &schema, schema.Validate()
+}
+
+func aliasHandler(t *testing.T, store *mockStore, reject bool) *http.ServeMux {
+	t.Helper()
+	registry := NewSchemaRegistry(staticSchema{Keys: []KeyDef{
+		{Name: "theme", Type: TypeString},
+		{Name: "color_scheme", Type: TypeString, ReplacedBy: "theme"},
+		{Name: "legacy_flag", Type: TypeBoolean, Deprecated: true},
+	}}, 0, testLogger())
+	if err := registry.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Schema: registry, RejectDeprecatedWrites: reject})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+	mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", h.PatchPrefs)
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/{key}", h.GetOne)
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences/{key}", h.SetOne)
+	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences/{key}", h.DeleteOne)
+	return mux
+}
+
+func sendAs(mux *http.ServeMux, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	return w
+}
+
+func TestAliases_Mirror(t *testing.T) {
+	store := newMockStore()
+	mux := aliasHandler(t, store, false)
+
+	w := sendAs(mux, "PUT", "/api/v1/users/user1/preferences/color_scheme", `{"value":"dark"}`)
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" {
+		t.Fatalf("expected 200 with a deprecation header, got %d %v", w.Code, w.Header())
+	}
+	if store.prefs["user1"]["theme"] != "dark" || store.prefs["user1"]["color_scheme"] != "dark" {
+		t.Fatalf("expected the write mirrored to theme, got %v", store.prefs["user1"])
+	}
+
+	// A new client moves on; old clients read the new value through the
+	// alias.
+	sendAs(mux, "PUT", "/api/v1/users/user1/preferences/theme", `{"value":"light"}`)
+	w = sendAs(mux, "GET", "/api/v1/users/user1/preferences/color_scheme", "")
+	var one SinglePrefResponse
+	json.NewDecoder(w.Body).Decode(&one)
+	if one.Value != "light" || w.Header().Get("Warning") != `299 - "color_scheme is deprecated; use theme"` {
+		t.Fatalf("expected the alias to resolve, got %+v %v", one, w.Header())
+	}
+
+	w = sendAs(mux, "GET", "/api/v1/users/user1/preferences?keys=color_scheme", "")
+	var all PreferencesResponse
+	json.NewDecoder(w.Body).Decode(&all)
+	if len(all.Preferences) != 1 || all.Preferences["color_scheme"] != "light" {
+		t.Fatalf("expected only the resolved alias, got %v", all.Preferences)
+	}
+
+	// Setting both keys keeps the explicit value of each.
+	sendAs(mux, "PATCH", "/api/v1/users/user1/preferences", `{"color_scheme":"dark","theme":"system"}`)
+	if store.prefs["user1"]["theme"] != "system" {
+		t.Fatalf("expected the explicit theme to win, got %v", store.prefs["user1"])
+	}
+
+	if w := sendAs(mux, "DELETE", "/api/v1/users/user1/preferences/color_scheme", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if len(store.prefs["user1"]) != 0 {
+		t.Fatalf("expected the delete mirrored to theme, got %v", store.prefs["user1"])
+	}
+}
+
+func TestAliases_Reject(t *testing.T) {
+	store := newMockStore()
+	mux := aliasHandler(t, store, true)
+
+	w := sendAs(mux, "PATCH", "/api/v1/users/user1/preferences", `{"color_scheme":"dark","legacy_flag":true,"theme":"dark"}`)
+	var resp APIError
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusUnprocessableEntity || len(resp.Violations) != 2 {
+		t.Fatalf("expected 422 with two violations, got %d %+v", w.Code, resp)
+	}
+	if resp.Violations[0].Reason != "key is deprecated; use theme" {
+		t.Fatalf("unexpected violation %+v", resp.Violations[0])
+	}
+	if w := sendAs(mux, "PATCH", "/api/v1/users/user1/preferences", `{"theme":"dark"}`); w.Code != http.StatusOK || w.Header().Get("Deprecation") != "" {
+		t.Fatalf("expected a clean 200 for the new key, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestSchemaValidate_ReplacedBy(t *testing.T) {
+	for _, keys := range [][]KeyDef{
+		{{Name: "a", ReplacedBy: "a"}},
+		{{Name: "a", ReplacedBy: "b..c"}},
+		{{Name: "a", ReplacedBy: "b"}, {Name: "b", ReplacedBy: "c"}},
+	} {
+		s := &PreferenceSchema{Keys: keys}
+		if err := s.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", keys)
+		}
+	}
+}
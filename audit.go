@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// AuditLog is the sink for security audit events. It is kept separate from
+// the request log so denials can be retained and shipped on their own.
+type AuditLog struct {
+	logger *slog.Logger
+}
+
+// NewAuditLog writes JSON audit events to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{logger: slog.New(slog.NewJSONHandler(w, nil))}
+}
+
+// OpenAuditLog opens the audit sink at path, appending to the file, or uses
+// stderr when path is empty.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if path == "" {
+		return NewAuditLog(os.Stderr), nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return NewAuditLog(f), nil
+}
+
+// denied records a 401 or 403. subject and actor are empty when the caller
+// could not be authenticated; remote identifies them instead.
+func (a *AuditLog) denied(r *http.Request, status int, reason, subject, actor string) {
+	a.deny(status, reason, subject, actor, r.PathValue("userId"),
+		"method", r.Method,
+		"route", r.Pattern,
+		"path", r.URL.Path,
+		"remote", r.RemoteAddr,
+	)
+}
+
+// deniedCall records a gRPC call denied with the HTTP status equivalent to
+// its code, identifying the call by its full method name.
+func (a *AuditLog) deniedCall(method, remote string, status int, reason, subject, actor, userID string) {
+	a.deny(status, reason, subject, actor, userID,
+		"rpc", method,
+		"remote", remote,
+	)
+}
+
+// deny writes a denial event, with where locating the request.
+func (a *AuditLog) deny(status int, reason, subject, actor, userID string, where ...any) {
+	attrs := append([]any{"status", status, "reason", reason}, where...)
+	if subject != "" {
+		attrs = append(attrs, "subject", subject)
+	}
+	if actor != "" {
+		attrs = append(attrs, "actor", actor)
+	}
+	if userID != "" {
+		attrs = append(attrs, "targetUserId", userID)
+	}
+	a.logger.Warn("access denied", attrs...)
+}
+
+// adminAction records a privileged change made through the admin API.
+func (a *AuditLog) adminAction(r *http.Request, action string, attrs ...any) {
+	claims, _ := ClaimsFromContext(r.Context())
+	attrs = append([]any{
+		"action", action,
+		"method", r.Method,
+		"route", r.Pattern,
+		"remote", r.RemoteAddr,
+		"subject", claims.Subject,
+	}, attrs...)
+	if claims.Actor != "" {
+		attrs = append(attrs, "actor", claims.Actor)
+	}
+	if userID := r.PathValue("userId"); userID != "" {
+		attrs = append(attrs, "targetUserId", userID)
+	}
+	a.logger.Info("admin action", attrs...)
+}
+
+// Audit makes the audit sink available to auth middleware and handlers
+// further down the chain.
+func Audit(a *AuditLog) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), auditKey, a)))
+		})
+	}
+}
+
+// deny writes an auth error response and records it in the audit log,
+// attributing it to the authenticated caller if there is one.
+func deny(w http.ResponseWriter, r *http.Request, status int, reason string) {
+	claims, _ := ClaimsFromContext(r.Context())
+	denyPrincipal(w, r, status, reason, claims.Subject, claims.Actor)
+}
+
+// denyPrincipal is deny for callers whose identity is known but not yet in
+// the request context, such as a client certificate that is not allowed.
+func denyPrincipal(w http.ResponseWriter, r *http.Request, status int, reason, subject, actor string) {
+	recordDenial(r, status, reason, subject, actor)
+	writeError(w, status, reason)
+}
+
+// recordAdminAction writes an admin action to the audit log, if there is
+// one.
+func recordAdminAction(r *http.Request, action string, attrs ...any) {
+	if a, ok := r.Context().Value(auditKey).(*AuditLog); ok {
+		a.adminAction(r, action, attrs...)
+	}
+}
+
+// recordDenial writes an audit event without responding, for callers that
+// report the denial to the client differently.
+func recordDenial(r *http.Request, status int, reason, subject, actor string) {
+	if a, ok := r.Context().Value(auditKey).(*AuditLog); ok {
+		a.denied(r, status, reason, subject, actor)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAudit_RecordsDenials(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", JWTAuth(JWTOptions{Secret: testSecret})(h.GetAll))
+	handler := Audit(NewAuditLog(&buf))(mux)
+
+	// 401: bad signature.
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req.Header.Set("Authorization", "Bearer "+makeToken("user1", "wrong", jwt.SigningMethodHS256))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// 403: valid token for another user.
+	req = httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req.Header.Set("Authorization", "Bearer "+makeToken("other-user", testSecret, jwt.SigningMethodHS256))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	dec := json.NewDecoder(&buf)
+	var events []map[string]any
+	for dec.More() {
+		var ev map[string]any
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("decoding audit event: %v", err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 audit events, got %d: %s", len(events), buf.String())
+	}
+
+	if events[0]["status"] != float64(401) || events[0]["subject"] != nil {
+		t.Fatalf("unexpected 401 event: %v", events[0])
+	}
+	want := map[string]any{
+		"status":       float64(403),
+		"reason":       "access denied",
+		"subject":      "other-user",
+		"targetUserId": "user1",
+		"route":        "GET /api/v1/users/{userId}/preferences",
+	}
+	for k, v := range want {
+		if events[1][k] != v {
+			t.Fatalf("403 event: expected %s=%v, got %v", k, v, events[1][k])
+		}
+	}
+}
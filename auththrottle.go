@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ThrottleOptions configures failed-authentication throttling.
+type ThrottleOptions struct {
+	// MaxFailures is the number of 401s within Window that triggers a block;
+	// zero disables throttling.
+	MaxFailures int
+	Window      time.Duration
+	// BaseBlock is the first block duration; each further block for the same
+	// key doubles it, up to MaxBlock.
+	BaseBlock time.Duration
+	MaxBlock  time.Duration
+	// CookieName is read for the token when there is no Authorization header.
+	CookieName string
+	// TrustedProxies are the load balancers and proxies in front of the
+	// service. Requests from them are attributed to the client address they
+	// report in X-Forwarded-For rather than to the proxy itself.
+	TrustedProxies []netip.Prefix
+}
+
+// failureRecord tracks recent authentication failures for one key.
+type failureRecord struct {
+	count        int
+	windowStart  time.Time
+	strikes      int
+	blockedUntil time.Time
+}
+
+// throttleRecordsSize bounds the tracked keys; past it the least recently
+// failing are forgotten, so forged subjects sent from many addresses cannot
+// grow the records without limit.
+const throttleRecordsSize = 100000
+
+type authThrottle struct {
+	opts ThrottleOptions
+	// mu serializes updates to the records, which the cache hands out by
+	// pointer.
+	mu      sync.Mutex
+	records *lruCache[*failureRecord]
+}
+
+func newAuthThrottle(opts ThrottleOptions) *authThrottle {
+	return &authThrottle{opts: opts, records: newLRUCache[*failureRecord](throttleRecordsSize)}
+}
+
+// maxSubjectDelay caps the delay applied to requests whose claimed subject is
+// blocked. Subjects are delayed rather than rejected so that forged tokens
+// naming a real user cannot lock that user out.
+const maxSubjectDelay = 2 * time.Second
+
+// blocked returns how long the block on key has left, or zero.
+func (t *authThrottle) blocked(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if rec, ok := t.records.get(key, now); ok && now.Before(rec.blockedUntil) {
+		return rec.blockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// fail records a 401 against each key, blocking keys that cross the limit.
+func (t *authThrottle) fail(keys []string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// A key is forgotten once it has been quiet, and unblocked, for longer
+	// than the maximum block, which also resets its escalation.
+	idle := max(t.opts.MaxBlock, t.opts.Window)
+	for _, k := range keys {
+		rec, ok := t.records.get(k, now)
+		if !ok {
+			rec = &failureRecord{}
+		}
+		if now.Sub(rec.windowStart) > t.opts.Window {
+			rec.count = 0
+			rec.windowStart = now
+		}
+		rec.count++
+		if rec.count >= t.opts.MaxFailures {
+			block := min(t.opts.BaseBlock<<rec.strikes, t.opts.MaxBlock)
+			// Strikes stop counting at the cap, so the shift cannot
+			// overflow into a zero or negative block.
+			if block < t.opts.MaxBlock {
+				rec.strikes++
+			}
+			rec.blockedUntil = now.Add(block)
+			rec.count = 0
+		}
+		expires := now.Add(idle)
+		if rec.blockedUntil.After(now) {
+			expires = rec.blockedUntil.Add(idle)
+		}
+		t.records.put(k, rec, expires)
+	}
+}
+
+// AuthThrottle slows down brute-forcing of the HS256 secret by tracking 401s
+// per source IP and per (unverified) token subject. A source IP that keeps
+// failing is blocked with 429; requests claiming a subject that keeps failing
+// are delayed. Both escalate for repeat offenders.
+func AuthThrottle(opts ThrottleOptions) func(http.Handler) http.Handler {
+	t := newAuthThrottle(opts)
+
+	return func(next http.Handler) http.Handler {
+		if opts.MaxFailures <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ipKey, subKey := throttleKeys(r, opts.CookieName, opts.TrustedProxies)
+			wait, err := t.check(r.Context(), ipKey, subKey)
+			if err != nil {
+				return
+			}
+			if wait > 0 {
+				throttled(w, wait)
+				return
+			}
+
+			rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(rw, r)
+
+			if rw.statusCode == http.StatusUnauthorized {
+				t.failed(ipKey, subKey)
+			}
+		})
+	}
+}
+
+// check applies the blocks on a caller's keys before it authenticates. It
+// returns how long a blocked client address must wait, and delays a caller
+// claiming a blocked subject, returning ctx's error if the caller gives up
+// meanwhile.
+func (t *authThrottle) check(ctx context.Context, ipKey, subKey string) (time.Duration, error) {
+	if wait := t.blocked(ipKey, time.Now()); wait > 0 {
+		return wait, nil
+	}
+	if subKey != "" {
+		if wait := t.blocked(subKey, time.Now()); wait > 0 {
+			select {
+			case <-time.After(min(wait, maxSubjectDelay)):
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+	}
+	return 0, nil
+}
+
+// failed records a failed authentication against a caller's keys.
+func (t *authThrottle) failed(ipKey, subKey string) {
+	keys := []string{ipKey}
+	if subKey != "" {
+		keys = append(keys, subKey)
+	}
+	t.fail(keys, time.Now())
+}
+
+// throttleKeys identifies the caller by client IP and, when the request
+// carries a parseable token, by its claimed subject. The subject is not
+// verified, which is why subject blocks only delay requests.
+func throttleKeys(r *http.Request, cookieName string, trusted []netip.Prefix) (ipKey, subKey string) {
+	return "ip:" + clientIP(r, trusted), subjectKey(httpCredentials(r), cookieName)
+}
+
+// subjectKey is the throttle key for the subject claimed by the caller's
+// token, or "" without a parseable token.
+func subjectKey(c Credentials, cookieName string) string {
+	raw, err := c.bearer(cookieName)
+	if err != nil {
+		return ""
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(raw, jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+	if sub, _ := token.Claims.GetSubject(); sub != "" {
+		return "sub:" + sub
+	}
+	return ""
+}
+
+// clientIP returns the address of the client behind any trusted proxies:
+// the source address, unless that is a trusted proxy, in which case the
+// X-Forwarded-For entries are read from the right, skipping further trusted
+// proxies. Entries left of the first untrusted one may be forged by the
+// client and are ignored.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	isTrusted := func(ip netip.Addr) bool {
+		return slices.ContainsFunc(trusted, func(p netip.Prefix) bool { return p.Contains(ip) })
+	}
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	ip := addr.Addr().Unmap()
+	if !isTrusted(ip) {
+		return ip.String()
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if ip = hop.Unmap(); !isTrusted(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// throttled rejects a request from a blocked client.
+func throttled(w http.ResponseWriter, wait time.Duration) {
+	secs := int(wait.Round(time.Second) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+	writeError(w, http.StatusTooManyRequests, "too many failed authentication attempts")
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// backupFormat and backupVersion identify a backup's header line.
+const (
+	backupFormat  = "user-prefs-backup"
+	backupVersion = 1
+)
+
+// backupBatchSize is the most items one BatchWriteItem call takes.
+const backupBatchSize = 25
+
+// backupMaxRetries bounds the retries of items BatchWriteItem leaves
+// unprocessed; the wait before each starts at backupRetryBase and doubles.
+const backupMaxRetries = 8
+
+var backupRetryBase = 100 * time.Millisecond
+
+// dynamoDataAPI is the part of the DynamoDB client backup and restore use.
+type dynamoDataAPI interface {
+	Scan(ctx context.Context, in *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	BatchWriteItem(ctx context.Context, in *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// BackupHeader is the first line of a backup.
+type BackupHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Table     string    `json:"table"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// BackupEnd is the last line of a complete backup.
+type BackupEnd struct {
+	Items       int64     `json:"items"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// backupLine is one line of a backup file: the header, an item, or the end
+// marker. Items are in DynamoDB JSON under "Item", as DynamoDB's own
+// exports to S3 write them, so either can be restored.
+type backupLine struct {
+	Backup *BackupHeader   `json:"backup,omitempty"`
+	Item   json.RawMessage `json:"Item,omitempty"`
+	End    *BackupEnd      `json:"end,omitempty"`
+}
+
+// BackupOptions configures a backup or a restore.
+type BackupOptions struct {
+	Table string
+	// Rate caps the items read or written per second; zero is unlimited.
+	Rate float64
+	// Resume continues from the checkpoint an interrupted run left.
+	Resume bool
+}
+
+// limiter returns a token bucket holding a second's worth of items, or
+// nil when the rate is unlimited.
+func (o BackupOptions) limiter() *tokenBucket {
+	if o.Rate <= 0 {
+		return nil
+	}
+	return newTokenBucket(o.Rate, int(o.Rate))
+}
+
+// backupCheckpoint is saved beside a backup file after each page: the file
+// is cut back to Offset and the scan restarted after LastKey to resume.
+type backupCheckpoint struct {
+	Table     string          `json:"table"`
+	StartedAt time.Time       `json:"startedAt"`
+	Offset    int64           `json:"offset"`
+	Items     int64           `json:"items"`
+	LastKey   json.RawMessage `json:"lastKey"`
+}
+
+// restoreCheckpoint records how many of a backup's items a restore has
+// written.
+type restoreCheckpoint struct {
+	Table string `json:"table"`
+	Items int64  `json:"items"`
+}
+
+// Backup streams every item of a table, of every kind, to the file at path
+// as JSON lines: a header, one line per item, and an end marker with the
+// item count. A path ending in .gz is gzipped, one gzip member per scan
+// page, which readers decompress as one stream. After each page is synced
+// a checkpoint is saved to path+".checkpoint", so an interrupted backup
+// resumes from its last whole page; the checkpoint is removed once the end
+// marker is written. The scan is eventually consistent: items written
+// while it runs may or may not be included.
+func Backup(ctx context.Context, client dynamoDataAPI, path string, opts BackupOptions, progress io.Writer) (int64, error) {
+	cpPath := path + ".checkpoint"
+	cp := backupCheckpoint{Table: opts.Table, StartedAt: time.Now().UTC()}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if opts.Resume {
+		if err := readJSONFile(cpPath, &cp); err != nil {
+			return 0, fmt.Errorf("resume: %w", err)
+		}
+		if cp.Table != opts.Table {
+			return 0, fmt.Errorf("resume: the checkpoint is for table %s", cp.Table)
+		}
+		flags = os.O_WRONLY
+	} else if err := os.Remove(cpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(path, flags, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err := f.Truncate(cp.Offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	gz := strings.HasSuffix(path, ".gz")
+	write := func(lines ...[]byte) error {
+		var buf bytes.Buffer
+		var w io.Writer = &buf
+		var zw *gzip.Writer
+		if gz {
+			zw = gzip.NewWriter(&buf)
+			w = zw
+		}
+		for _, line := range lines {
+			w.Write(line)
+			w.Write([]byte{'\n'})
+		}
+		if zw != nil {
+			zw.Close()
+		}
+		if _, err := f.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		return f.Sync()
+	}
+
+	input := &dynamodb.ScanInput{TableName: aws.String(opts.Table)}
+	if opts.Rate > 0 {
+		input.Limit = aws.Int32(int32(min(max(opts.Rate, 1), 1000)))
+	}
+	if opts.Resume {
+		if input.ExclusiveStartKey, err = unmarshalDynamoJSON(cp.LastKey); err != nil {
+			return 0, fmt.Errorf("resume: checkpoint key: %w", err)
+		}
+		fmt.Fprintf(progress, "resuming after %d item(s)\n", cp.Items)
+	} else {
+		header, _ := json.Marshal(backupLine{Backup: &BackupHeader{Format: backupFormat, Version: backupVersion, Table: opts.Table, StartedAt: cp.StartedAt}})
+		if err := write(header); err != nil {
+			return 0, err
+		}
+	}
+
+	limiter := opts.limiter()
+	for {
+		out, err := client.Scan(ctx, input)
+		if err != nil {
+			return cp.Items, fmt.Errorf("Scan: %w", err)
+		}
+		lines := make([][]byte, 0, len(out.Items)+1)
+		for _, item := range out.Items {
+			data, err := marshalDynamoJSON(item)
+			if err != nil {
+				return cp.Items, err
+			}
+			line, _ := json.Marshal(backupLine{Item: data})
+			lines = append(lines, line)
+		}
+		total := cp.Items + int64(len(out.Items))
+		if len(out.LastEvaluatedKey) == 0 {
+			end, _ := json.Marshal(backupLine{End: &BackupEnd{Items: total, CompletedAt: time.Now().UTC()}})
+			if err := write(append(lines, end)...); err != nil {
+				return cp.Items, err
+			}
+			cp.Items = total
+			break
+		}
+		if err := write(lines...); err != nil {
+			return cp.Items, err
+		}
+		cp.Items = total
+
+		if cp.LastKey, err = marshalDynamoJSON(out.LastEvaluatedKey); err != nil {
+			return cp.Items, err
+		}
+		if cp.Offset, err = f.Seek(0, io.SeekCurrent); err != nil {
+			return cp.Items, err
+		}
+		if err := writeJSONFile(cpPath, cp); err != nil {
+			return cp.Items, err
+		}
+		fmt.Fprintf(progress, "%d item(s) backed up\n", cp.Items)
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+		if err := limiter.wait(ctx, len(out.Items)); err != nil {
+			return cp.Items, err
+		}
+	}
+	if err := os.Remove(cpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cp.Items, err
+	}
+	return cp.Items, nil
+}
+
+// Restore puts the items of a backup made by Backup, or of a DynamoDB
+// export in DynamoDB JSON, gzipped or not, into a table in batches,
+// retrying items DynamoDB leaves unprocessed. Items with the same key are
+// replaced and nothing else in the table is touched, so a restore can be
+// repeated. After each batch the count written is saved to
+// path+".restore-checkpoint", and Resume skips that many items. A backup
+// with a header but no matching end marker was cut short: what it holds is
+// restored and an error returned.
+func Restore(ctx context.Context, client dynamoDataAPI, path string, opts BackupOptions, progress io.Writer) (int64, error) {
+	cpPath := path + ".restore-checkpoint"
+	cp := restoreCheckpoint{Table: opts.Table}
+	if opts.Resume {
+		if err := readJSONFile(cpPath, &cp); err != nil {
+			return 0, fmt.Errorf("resume: %w", err)
+		}
+		if cp.Table != opts.Table {
+			return 0, fmt.Errorf("resume: the checkpoint is for table %s", cp.Table)
+		}
+		fmt.Fprintf(progress, "resuming after %d item(s)\n", cp.Items)
+	}
+	skip := cp.Items
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		r = bufio.NewReader(zr)
+	}
+
+	limiter := opts.limiter()
+	var (
+		seen   int64
+		header *BackupHeader
+		end    *BackupEnd
+		batch  []types.WriteRequest
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := limiter.wait(ctx, len(batch)); err != nil {
+			return err
+		}
+		if err := batchWrite(ctx, client, opts.Table, batch); err != nil {
+			return err
+		}
+		if seen/1000 != cp.Items/1000 {
+			fmt.Fprintf(progress, "%d item(s) restored\n", seen)
+		}
+		cp.Items = seen
+		batch = nil
+		return writeJSONFile(cpPath, cp)
+	}
+
+	for n := 1; ; n++ {
+		data, readErr := r.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return cp.Items - skip, readErr
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			var line backupLine
+			if err := json.Unmarshal(data, &line); err != nil {
+				return cp.Items - skip, fmt.Errorf("line %d: %w", n, err)
+			}
+			switch {
+			case line.Backup != nil:
+				if line.Backup.Format != backupFormat || line.Backup.Version != backupVersion {
+					return 0, fmt.Errorf("line %d: not a %s version %d file", n, backupFormat, backupVersion)
+				}
+				header = line.Backup
+			case line.End != nil:
+				end = line.End
+			case line.Item != nil:
+				if seen++; seen <= skip {
+					continue
+				}
+				item, err := unmarshalDynamoJSON(line.Item)
+				if err != nil {
+					return cp.Items - skip, fmt.Errorf("line %d: %w", n, err)
+				}
+				batch = append(batch, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+				if len(batch) == backupBatchSize {
+					if err := flush(); err != nil {
+						return cp.Items - skip, err
+					}
+				}
+			default:
+				return cp.Items - skip, fmt.Errorf("line %d: expected an item", n)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if err := flush(); err != nil {
+		return cp.Items - skip, err
+	}
+	if err := os.Remove(cpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cp.Items - skip, err
+	}
+	if header != nil && (end == nil || end.Items != seen) {
+		return cp.Items - skip, fmt.Errorf("the backup is incomplete: %d item(s) read and no end marker to match", seen)
+	}
+	return cp.Items - skip, nil
+}
+
+// batchWrite puts requests into table, retrying unprocessed items with
+// exponential backoff.
+func batchWrite(ctx context.Context, client dynamoDataAPI, table string, reqs []types.WriteRequest) error {
+	wait := backupRetryBase
+	for attempt := 0; ; attempt++ {
+		out, err := client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{table: reqs},
+		})
+		if err != nil {
+			return fmt.Errorf("BatchWriteItem: %w", err)
+		}
+		if reqs = out.UnprocessedItems[table]; len(reqs) == 0 {
+			return nil
+		}
+		if attempt == backupMaxRetries {
+			return fmt.Errorf("BatchWriteItem: %d item(s) still unprocessed after %d retries", len(reqs), backupMaxRetries)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// marshalDynamoJSON renders an item in DynamoDB JSON, the typed form the
+// AWS CLI and DynamoDB exports use: {"PK": {"S": "USER#1"}, ...}.
+func marshalDynamoJSON(item map[string]types.AttributeValue) (json.RawMessage, error) {
+	m, err := dynamoJSONMap(item)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+func dynamoJSONMap(item map[string]types.AttributeValue) (map[string]any, error) {
+	m := make(map[string]any, len(item))
+	for k, av := range item {
+		v, err := dynamoJSONValue(av)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", k, err)
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+func dynamoJSONValue(av types.AttributeValue) (any, error) {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return map[string]any{"S": v.Value}, nil
+	case *types.AttributeValueMemberN:
+		return map[string]any{"N": v.Value}, nil
+	case *types.AttributeValueMemberB:
+		return map[string]any{"B": v.Value}, nil
+	case *types.AttributeValueMemberBOOL:
+		return map[string]any{"BOOL": v.Value}, nil
+	case *types.AttributeValueMemberNULL:
+		return map[string]any{"NULL": true}, nil
+	case *types.AttributeValueMemberSS:
+		return map[string]any{"SS": v.Value}, nil
+	case *types.AttributeValueMemberNS:
+		return map[string]any{"NS": v.Value}, nil
+	case *types.AttributeValueMemberBS:
+		return map[string]any{"BS": v.Value}, nil
+	case *types.AttributeValueMemberL:
+		l := make([]any, len(v.Value))
+		for i, e := range v.Value {
+			var err error
+			if l[i], err = dynamoJSONValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]any{"L": l}, nil
+	case *types.AttributeValueMemberM:
+		m, err := dynamoJSONMap(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"M": m}, nil
+	}
+	return nil, fmt.Errorf("unsupported attribute type %T", av)
+}
+
+// unmarshalDynamoJSON parses an item in DynamoDB JSON.
+func unmarshalDynamoJSON(data json.RawMessage) (map[string]types.AttributeValue, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	item := make(map[string]types.AttributeValue, len(raw))
+	for k, v := range raw {
+		av, err := dynamoJSONAttribute(v)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", k, err)
+		}
+		item[k] = av
+	}
+	return item, nil
+}
+
+func dynamoJSONAttribute(data json.RawMessage) (types.AttributeValue, error) {
+	var typed map[string]json.RawMessage
+	if err := json.Unmarshal(data, &typed); err != nil || len(typed) != 1 {
+		return nil, errors.New("expected an object with one type, such as {\"S\": \"...\"}")
+	}
+	for t, v := range typed {
+		var err error
+		switch t {
+		case "S":
+			var s string
+			err = json.Unmarshal(v, &s)
+			return &types.AttributeValueMemberS{Value: s}, err
+		case "N":
+			var s string
+			err = json.Unmarshal(v, &s)
+			return &types.AttributeValueMemberN{Value: s}, err
+		case "B":
+			var b []byte
+			err = json.Unmarshal(v, &b)
+			return &types.AttributeValueMemberB{Value: b}, err
+		case "BOOL":
+			var b bool
+			err = json.Unmarshal(v, &b)
+			return &types.AttributeValueMemberBOOL{Value: b}, err
+		case "NULL":
+			return &types.AttributeValueMemberNULL{Value: true}, nil
+		case "SS":
+			var ss []string
+			err = json.Unmarshal(v, &ss)
+			return &types.AttributeValueMemberSS{Value: ss}, err
+		case "NS":
+			var ns []string
+			err = json.Unmarshal(v, &ns)
+			return &types.AttributeValueMemberNS{Value: ns}, err
+		case "BS":
+			var bs [][]byte
+			err = json.Unmarshal(v, &bs)
+			return &types.AttributeValueMemberBS{Value: bs}, err
+		case "L":
+			var raw []json.RawMessage
+			if err = json.Unmarshal(v, &raw); err != nil {
+				return nil, err
+			}
+			l := make([]types.AttributeValue, len(raw))
+			for i, e := range raw {
+				if l[i], err = dynamoJSONAttribute(e); err != nil {
+					return nil, err
+				}
+			}
+			return &types.AttributeValueMemberL{Value: l}, nil
+		case "M":
+			m, err := unmarshalDynamoJSON(v)
+			if err != nil {
+				return nil, err
+			}
+			return &types.AttributeValueMemberM{Value: m}, nil
+		}
+		return nil, fmt.Errorf("unknown attribute type %q", t)
+	}
+	return nil, nil
+}
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile replaces the file at path through a rename, so it is never
+// seen half written.
+func writeJSONFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runBackup implements "user-prefs backup" and "user-prefs restore".
+func runBackup(restore bool, args []string, stdout, stderr io.Writer) int {
+	name, fileFlag, fileUsage, checkpoint := "backup", "out", "file to write (.gz to compress)", ".checkpoint"
+	if restore {
+		name, fileFlag, fileUsage, checkpoint = "restore", "in", "backup file to read", ".restore-checkpoint"
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	table := fs.String("table", envOrDefault("DYNAMODB_TABLE_NAME", "user-preferences"), "DynamoDB table")
+	path := fs.String(fileFlag, "", fileUsage)
+	rate := fs.Float64("rate", 0, "items per second at most (default unlimited)")
+	resume := fs.Bool("resume", false, "continue an interrupted run from its checkpoint")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		fmt.Fprintf(stderr, "%s: -%s is required\n", name, fileFlag)
+		return 2
+	}
+	if *rate < 0 {
+		fmt.Fprintf(stderr, "%s: -rate must not be negative\n", name)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	store, err := NewDynamoStore(ctx, Config{AWSRegion: envOrDefault("AWS_REGION", "us-east-1"), DynamoEndpoint: os.Getenv("DYNAMODB_ENDPOINT")})
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return 1
+	}
+
+	opts := BackupOptions{Table: *table, Rate: *rate, Resume: *resume}
+	run, done := Backup, "Backed up %d item(s) from %s.\n"
+	if restore {
+		run, done = Restore, "Restored %d item(s) to %s.\n"
+	}
+	n, err := run(ctx, store.client, *path, opts, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		if _, statErr := os.Stat(*path + checkpoint); statErr == nil {
+			fmt.Fprintf(stderr, "%d item(s) done; run again with -resume to continue.\n", n)
+		}
+		return 1
+	}
+	fmt.Fprintf(stdout, done, n, *table)
+	return 0
+}
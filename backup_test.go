@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeDynamoData is a table keyed on PK. Scans return pageSize items in key
+// order; failScan and failWrite fail the call with that number (from 1).
+type fakeDynamoData struct {
+	items     map[string]map[string]types.AttributeValue
+	pageSize  int
+	scans     int
+	failScan  int
+	writes    int
+	failWrite int
+	// throttle leaves the last item of the next batch unprocessed once.
+	throttle bool
+}
+
+func newFakeDynamoData() *fakeDynamoData {
+	return &fakeDynamoData{items: map[string]map[string]types.AttributeValue{}, pageSize: 2}
+}
+
+func pkOf(item map[string]types.AttributeValue) string {
+	return item["PK"].(*types.AttributeValueMemberS).Value
+}
+
+func (f *fakeDynamoData) Scan(_ context.Context, in *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if f.scans++; f.scans == f.failScan {
+		return nil, errors.New("throughput exceeded")
+	}
+	var keys []string
+	for pk := range f.items {
+		if in.ExclusiveStartKey == nil || pk > pkOf(in.ExclusiveStartKey) {
+			keys = append(keys, pk)
+		}
+	}
+	slices.Sort(keys)
+	out := &dynamodb.ScanOutput{}
+	for _, pk := range keys[:min(len(keys), f.pageSize)] {
+		out.Items = append(out.Items, f.items[pk])
+	}
+	if len(keys) > f.pageSize {
+		out.LastEvaluatedKey = map[string]types.AttributeValue{"PK": f.items[keys[f.pageSize-1]]["PK"]}
+	}
+	return out, nil
+}
+
+func (f *fakeDynamoData) BatchWriteItem(_ context.Context, in *dynamodb.BatchWriteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	if f.writes++; f.writes == f.failWrite {
+		return nil, errors.New("throughput exceeded")
+	}
+	out := &dynamodb.BatchWriteItemOutput{}
+	for table, reqs := range in.RequestItems {
+		if f.throttle {
+			f.throttle = false
+			out.UnprocessedItems = map[string][]types.WriteRequest{table: reqs[len(reqs)-1:]}
+			reqs = reqs[:len(reqs)-1]
+		}
+		for _, r := range reqs {
+			f.items[pkOf(r.PutRequest.Item)] = r.PutRequest.Item
+		}
+	}
+	return out, nil
+}
+
+// backupItems returns n user items covering every attribute type.
+func backupItems(n int) map[string]map[string]types.AttributeValue {
+	items := map[string]map[string]types.AttributeValue{}
+	for i := range n {
+		pk := "USER#u" + string(rune('a'+i))
+		items[pk] = map[string]types.AttributeValue{
+			"PK":      &types.AttributeValueMemberS{Value: pk},
+			"version": &types.AttributeValueMemberN{Value: "3"},
+			"preferences": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"theme": &types.AttributeValueMemberS{Value: "dark"},
+				"beta":  &types.AttributeValueMemberBOOL{Value: true},
+				"tabs":  &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberN{Value: "1.5"}, &types.AttributeValueMemberNULL{Value: true}}},
+				"empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+			}},
+			"secret": &types.AttributeValueMemberB{Value: []byte{0, 1, 2}},
+			"tags":   &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+			"nums":   &types.AttributeValueMemberNS{Value: []string{"1", "2"}},
+			"blobs":  &types.AttributeValueMemberBS{Value: [][]byte{{9}}},
+		}
+	}
+	return items
+}
+
+func TestBackupRestore(t *testing.T) {
+	defer func(d time.Duration) { backupRetryBase = d }(backupRetryBase)
+	backupRetryBase = time.Millisecond
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "prefs.jsonl.gz")
+	var progress bytes.Buffer
+
+	src := newFakeDynamoData()
+	src.items = backupItems(5)
+	src.failScan = 2
+	opts := BackupOptions{Table: "prefs"}
+	if n, err := Backup(ctx, src, path, opts, &progress); err == nil || n != 2 {
+		t.Fatalf("expected the second page to fail after 2 items, got %d %v", n, err)
+	}
+	if _, err := os.Stat(path + ".checkpoint"); err != nil {
+		t.Fatalf("expected a checkpoint: %v", err)
+	}
+	if _, err := Backup(ctx, src, path, BackupOptions{Table: "other", Resume: true}, &progress); err == nil {
+		t.Fatal("expected a checkpoint for another table refused")
+	}
+	opts.Resume = true
+	if n, err := Backup(ctx, src, path, opts, &progress); err != nil || n != 5 {
+		t.Fatalf("expected the backup resumed to 5 items, got %d %v", n, err)
+	}
+	if _, err := os.Stat(path + ".checkpoint"); !os.IsNotExist(err) {
+		t.Fatalf("expected the checkpoint removed, got %v", err)
+	}
+
+	dst := newFakeDynamoData()
+	dst.failWrite, dst.throttle = 1, true
+	opts = BackupOptions{Table: "prefs-copy", Rate: 1000}
+	if _, err := Restore(ctx, dst, path, opts, &progress); err == nil {
+		t.Fatal("expected the failed write reported")
+	}
+	if n, err := Restore(ctx, dst, path, opts, &progress); err != nil || n != 5 || dst.throttle {
+		t.Fatalf("expected 5 items restored, retrying the unprocessed one, got %d %v", n, err)
+	}
+	if !reflect.DeepEqual(dst.items, src.items) {
+		t.Fatalf("restored items differ:\n%#v\n%#v", dst.items, src.items)
+	}
+}
+
+func TestRestore_Resume(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "prefs.jsonl")
+	src := newFakeDynamoData()
+	src.items, src.pageSize = backupItems(26), 10
+	if _, err := Backup(ctx, src, path, BackupOptions{Table: "prefs"}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newFakeDynamoData()
+	dst.failWrite = 2
+	if n, err := Restore(ctx, dst, path, BackupOptions{Table: "prefs"}, &bytes.Buffer{}); err == nil || n != 25 {
+		t.Fatalf("expected the second batch to fail after 25 items, got %d %v", n, err)
+	}
+	dst.items = map[string]map[string]types.AttributeValue{}
+	if n, err := Restore(ctx, dst, path, BackupOptions{Table: "prefs", Resume: true}, &bytes.Buffer{}); err != nil || n != 1 || len(dst.items) != 1 {
+		t.Fatalf("expected only the last item written on resume, got %d %v %d", n, err, len(dst.items))
+	}
+
+	// A backup cut short is restored as far as it goes, and reported.
+	data, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	os.WriteFile(path, []byte(strings.Join(lines[:len(lines)-1], "")), 0o600)
+	dst = newFakeDynamoData()
+	if _, err := Restore(ctx, dst, path, BackupOptions{Table: "prefs"}, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "incomplete") || len(dst.items) != 26 {
+		t.Fatalf("expected an incomplete backup reported, got %v with %d items", err, len(dst.items))
+	}
+
+	// A DynamoDB export has no header to check.
+	os.WriteFile(path, []byte(`{"Item":{"PK":{"S":"USER#x"},"version":{"N":"1"}}}`+"\n"), 0o600)
+	dst = newFakeDynamoData()
+	if n, err := Restore(ctx, dst, path, BackupOptions{Table: "prefs"}, &bytes.Buffer{}); err != nil || n != 1 || dst.items["USER#x"] == nil {
+		t.Fatalf("expected the export restored, got %d %v", n, err)
+	}
+}
+
+func TestRunBackup_Usage(t *testing.T) {
+	for _, tc := range []struct {
+		restore bool
+		args    []string
+	}{
+		{false, nil},
+		{true, []string{"-table", "t"}},
+		{false, []string{"-out", "b.jsonl", "-rate", "-1"}},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := runBackup(tc.restore, tc.args, &stdout, &stderr); code != 2 {
+			t.Errorf("%v: expected usage error, got %d (%s)", tc.args, code, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// defaultMaxBatchUsers bounds batch requests when no limit is configured.
+const defaultMaxBatchUsers = 100
+
+// batchSetConcurrency bounds the store writes one batch set or delete runs
+// at once.
+const batchSetConcurrency = 10
+
+// BatchGetRequest is the body of a batch read.
+type BatchGetRequest struct {
+	UserIDs []string `json:"userIds"`
+}
+
+// BatchGetResponse lists preferences in request order; users without stored
+// preferences get an empty map.
+type BatchGetResponse struct {
+	Users []PreferencesResponse `json:"users"`
+}
+
+// BatchGet returns the preferences of several users in one request, for
+// internal services. It is mounted behind RequireScope(ScopeRead), so only
+// service principals reach it.
+func (h *PreferencesHandler) BatchGet(w http.ResponseWriter, r *http.Request) {
+	var req BatchGetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+
+	if !h.checkBatchUsers(w, req.UserIDs) {
+		return
+	}
+
+	// BatchGetItem rejects duplicate keys.
+	ids := slices.Compact(slices.Sorted(slices.Values(req.UserIDs)))
+	recs, err := h.store.BatchGet(r.Context(), ids)
+	if err != nil {
+		h.logger.Error("store.BatchGet failed", "error", err, "users", len(ids))
+		writeError(w, http.StatusInternalServerError, "failed to retrieve preferences")
+		return
+	}
+
+	resp := BatchGetResponse{Users: make([]PreferencesResponse, len(req.UserIDs))}
+	for i, id := range req.UserIDs {
+		prefs := recs[id].Prefs
+		if prefs == nil {
+			prefs = make(map[string]any)
+		}
+		resp.Users[i] = newPreferencesResponse(id, prefs, recs[id])
+	}
+	writeResponse(w, r, http.StatusOK, resp)
+}
+
+// checkBatchUsers validates the userIds of a batch request, writing the
+// error response if they are unusable.
+func (h *PreferencesHandler) checkBatchUsers(w http.ResponseWriter, userIDs []string) bool {
+	limit := h.opts.MaxBatchUsers
+	if limit <= 0 {
+		limit = defaultMaxBatchUsers
+	}
+	if len(userIDs) == 0 || len(userIDs) > limit {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("userIds must list 1 to %d users", limit))
+		return false
+	}
+	if slices.Contains(userIDs, "") {
+		writeError(w, http.StatusBadRequest, "userIds must not be empty")
+		return false
+	}
+	return true
+}
+
+// BatchSetRequest sets one key for many users. With OnlyIfUnset, users that
+// already have the key keep their value.
+type BatchSetRequest struct {
+	UserIDs     []string        `json:"userIds"`
+	Key         string          `json:"key"`
+	Value       json.RawMessage `json:"value"`
+	OnlyIfUnset bool            `json:"onlyIfUnset,omitempty"`
+}
+
+// Per-item outcomes of a bulk operation.
+const (
+	BatchUpdated = "updated"
+	BatchSkipped = "skipped"
+	// BatchInvalid items failed validation; resending them unchanged will
+	// fail again.
+	BatchInvalid = "invalid"
+	// BatchFailed items hit a store error and can be retried.
+	BatchFailed = "failed"
+)
+
+// BatchResult reports the outcome for one item of a bulk operation: a user,
+// or for imports a key.
+type BatchResult struct {
+	UserID string `json:"userId,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// ErrorCode is set with Error; see APIError.
+	ErrorCode  string      `json:"errorCode,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// BatchResponse lists one result per distinct user, in request order, with
+// totals per status.
+type BatchResponse struct {
+	Results []BatchResult  `json:"results"`
+	Counts  map[string]int `json:"counts"`
+}
+
+// batchStatus is 200 when every item was updated or skipped, and 207 Multi-
+// Status when some were not, so callers know to look for items to fix or
+// retry.
+func batchStatus(results []BatchResult) int {
+	for _, res := range results {
+		if res.Status == BatchInvalid || res.Status == BatchFailed {
+			return http.StatusMultiStatus
+		}
+	}
+	return http.StatusOK
+}
+
+// BatchSet writes one key across many users, for internal migrations such
+// as rolling out a new default. Users are written independently, so some may
+// fail while others succeed; the response reports each. It is mounted behind
+// RequireScope(ScopeWrite).
+func (h *PreferencesHandler) BatchSet(w http.ResponseWriter, r *http.Request) {
+	var req BatchSetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if req.Key == "" || len(req.Value) == 0 {
+		writeError(w, http.StatusBadRequest, "key and value are required")
+		return
+	}
+	var value any
+	if err := decodeJSON(bytes.NewReader(req.Value), &value); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	prefs := map[string]any{req.Key: value}
+	h.mirrorAliases(prefs, nil)
+	if !h.validatePrefs(w, prefs) || !h.checkBatchUsers(w, req.UserIDs) {
+		return
+	}
+
+	var cond Precondition
+	if req.OnlyIfUnset {
+		cond.Absent = []string{req.Key}
+	}
+	resp := h.forEachUser(req.UserIDs, func(res *BatchResult) {
+		v, err := h.quotaViolations(r.Context(), res.UserID, prefs, nil, false)
+		if err == nil && len(v) > 0 {
+			res.Status = BatchInvalid
+			res.Error = "preference quota exceeded"
+			res.ErrorCode = ErrCodeQuotaExceeded
+			res.Violations = v
+			return
+		}
+		if err == nil {
+			_, err = h.store.Update(r.Context(), res.UserID, prefs, nil, cond)
+		}
+		switch {
+		case errors.Is(err, ErrConflict):
+			res.Status = BatchSkipped
+		case err != nil:
+			h.logger.Error("store.Update failed", "error", err, "userId", res.UserID, "key", req.Key)
+			res.Status = BatchFailed
+			res.Error = "failed to update preferences"
+			res.ErrorCode = ErrCodeInternal
+		}
+	})
+	h.logger.Info("batch set", "key", req.Key, "users", len(resp.Results), "updated", resp.Counts[BatchUpdated],
+		"skipped", resp.Counts[BatchSkipped], "invalid", resp.Counts[BatchInvalid], "failed", resp.Counts[BatchFailed])
+	writeResponse(w, r, batchStatus(resp.Results), resp)
+}
+
+// BatchDeleteRequest removes keys from many users.
+type BatchDeleteRequest struct {
+	UserIDs []string `json:"userIds"`
+	Keys    []string `json:"keys"`
+}
+
+// BatchDelete removes keys across many users, the counterpart of BatchSet
+// for retiring a key. Users without stored preferences are skipped. It is
+// mounted behind RequireScope(ScopeWrite).
+func (h *PreferencesHandler) BatchDelete(w http.ResponseWriter, r *http.Request) {
+	var req BatchDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	keys := slices.Compact(slices.Sorted(slices.Values(req.Keys)))
+	if len(keys) == 0 || len(keys) > maxFilterKeys || slices.Contains(keys, "") {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("keys must list 1 to %d keys", maxFilterKeys))
+		return
+	}
+	if !h.checkBatchUsers(w, req.UserIDs) {
+		return
+	}
+	keys = h.mirrorAliases(nil, keys)
+
+	resp := h.forEachUser(req.UserIDs, func(res *BatchResult) {
+		_, err := h.store.Update(r.Context(), res.UserID, nil, keys, Precondition{MustExist: true})
+		switch {
+		case errors.Is(err, ErrPreconditionFailed):
+			res.Status = BatchSkipped
+		case err != nil:
+			h.logger.Error("store.Update failed", "error", err, "userId", res.UserID, "keys", keys)
+			res.Status = BatchFailed
+			res.Error = "failed to delete preferences"
+			res.ErrorCode = ErrCodeInternal
+		}
+	})
+	h.logger.Info("batch delete", "keys", keys, "users", len(resp.Results), "updated", resp.Counts[BatchUpdated],
+		"skipped", resp.Counts[BatchSkipped], "failed", resp.Counts[BatchFailed])
+	writeResponse(w, r, batchStatus(resp.Results), resp)
+}
+
+// forEachUser runs fn once per distinct user, batchSetConcurrency at a time,
+// collecting the results in request order. Each result starts out updated.
+func (h *PreferencesHandler) forEachUser(userIDs []string, fn func(res *BatchResult)) BatchResponse {
+	var ids []string
+	for _, id := range userIDs {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	results := make([]BatchResult, len(ids))
+	sem := make(chan struct{}, batchSetConcurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = BatchResult{UserID: id, Status: BatchUpdated}
+			fn(&results[i])
+		}()
+	}
+	wg.Wait()
+
+	resp := BatchResponse{Results: results, Counts: map[string]int{}}
+	for _, res := range results {
+		resp.Counts[res.Status]++
+	}
+	return resp
+}
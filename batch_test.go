@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBatchGet(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	store.prefs["user2"] = map[string]any{"lang": "en"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{MaxBatchUsers: 4})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/internal/preferences:batchGet", RequireScope(ScopeRead)(h.BatchGet))
+
+	post := func(claims Claims, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/internal/preferences:batchGet", bytes.NewBufferString(body))
+		req = req.WithContext(context.WithValue(req.Context(), claimsKey, claims))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	svc := Claims{Subject: "notifier", Kind: PrincipalService, Scopes: []string{ScopeRead}}
+
+	w := post(svc, `{"userIds":["user2","nobody","user1","user2"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp BatchGetResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Users) != 4 {
+		t.Fatalf("expected one entry per requested user, got %+v", resp.Users)
+	}
+	if resp.Users[0].UserID != "user2" || resp.Users[0].Preferences["lang"] != "en" {
+		t.Fatalf("unexpected first entry %+v", resp.Users[0])
+	}
+	if resp.Users[1].UserID != "nobody" || resp.Users[1].Preferences == nil || len(resp.Users[1].Preferences) != 0 {
+		t.Fatalf("expected empty map for unknown user, got %+v", resp.Users[1])
+	}
+	if resp.Users[2].Preferences["theme"] != "dark" {
+		t.Fatalf("unexpected third entry %+v", resp.Users[2])
+	}
+
+	if w := post(svc, `{"userIds":["a","b","c","d","e"]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 over the limit, got %d", w.Code)
+	}
+	if w := post(Claims{Subject: "user1"}, `{"userIds":["user1"]}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a browser token, got %d", w.Code)
+	}
+}
+
+func TestBatchSet(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	store.prefs["user2"] = map[string]any{"lang": "en"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/internal/preferences:batchSet", RequireScope(ScopeWrite)(h.BatchSet))
+
+	svc := Claims{Subject: "migrator", Kind: PrincipalService, Scopes: []string{ScopeWrite}}
+	body := `{"userIds":["user1","user2","user3","user1"],"key":"theme","value":"light","onlyIfUnset":true}`
+	req := httptest.NewRequest("POST", "/api/v1/internal/preferences:batchSet", bytes.NewBufferString(body))
+	req = req.WithContext(context.WithValue(req.Context(), claimsKey, svc))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp BatchResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Results) != 3 {
+		t.Fatalf("expected one result per distinct user, got %+v", resp.Results)
+	}
+	want := map[string]any{"user1": BatchSkipped, "user2": BatchUpdated, "user3": BatchUpdated}
+	for _, res := range resp.Results {
+		if res.Status != want[res.UserID] {
+			t.Fatalf("%s: expected %s, got %s", res.UserID, want[res.UserID], res.Status)
+		}
+	}
+	if resp.Counts[BatchUpdated] != 2 || resp.Counts[BatchSkipped] != 1 {
+		t.Fatalf("unexpected counts %v", resp.Counts)
+	}
+	if store.prefs["user1"]["theme"] != "dark" || store.prefs["user2"]["theme"] != "light" {
+		t.Fatalf("unexpected stored prefs %v", store.prefs)
+	}
+}
+
+func TestBatchSet_PartialFailure(t *testing.T) {
+	store := &failingStore{mockStore: newMockStore(), fail: "user2"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	req := httptest.NewRequest("POST", "/api/v1/internal/preferences:batchSet",
+		bytes.NewBufferString(`{"userIds":["user1","user2"],"key":"theme","value":"light"}`))
+	w := httptest.NewRecorder()
+	h.BatchSet(w, req)
+
+	var resp BatchResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d", w.Code)
+	}
+	if resp.Results[0].Status != BatchUpdated || resp.Results[1].Status != BatchFailed || resp.Results[1].Error == "" {
+		t.Fatalf("expected user2 to fail alone, got %+v", resp.Results)
+	}
+}
+
+func TestBatchSet_QuotaInvalid(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user2"] = map[string]any{"a": 1, "b": 2}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Quota: Quota{MaxKeys: 2}})
+
+	req := httptest.NewRequest("POST", "/api/v1/internal/preferences:batchSet",
+		bytes.NewBufferString(`{"userIds":["user1","user2"],"key":"theme","value":"light"}`))
+	w := httptest.NewRecorder()
+	h.BatchSet(w, req)
+
+	var resp BatchResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusMultiStatus || resp.Counts[BatchInvalid] != 1 {
+		t.Fatalf("expected one invalid user, got %d %+v", w.Code, resp)
+	}
+	if res := resp.Results[1]; res.Status != BatchInvalid || res.ErrorCode != ErrCodeQuotaExceeded || len(res.Violations) == 0 {
+		t.Fatalf("expected user2 over quota, got %+v", res)
+	}
+}
+
+func TestBatchDelete(t *testing.T) {
+	store := &failingStore{mockStore: newMockStore(), fail: "user3"}
+	store.prefs["user1"] = map[string]any{"theme": "dark", "legacy": true}
+	store.prefs["user3"] = map[string]any{"legacy": true}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/internal/preferences:batchDelete", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		h.BatchDelete(w, req)
+		return w
+	}
+
+	w := post(`{"userIds":["user1","user2"],"keys":["legacy"]}`)
+	var resp BatchResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Results[0].Status != BatchUpdated || resp.Results[1].Status != BatchSkipped {
+		t.Fatalf("expected user1 updated and user2 skipped, got %d %+v", w.Code, resp)
+	}
+	if got := store.prefs["user1"]; len(got) != 1 || got["theme"] != "dark" {
+		t.Fatalf("unexpected prefs %v", got)
+	}
+	if _, ok := store.prefs["user2"]; ok {
+		t.Fatal("expected no record created for user2")
+	}
+
+	w = post(`{"userIds":["user1","user3"],"keys":["theme"]}`)
+	resp = BatchResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusMultiStatus || resp.Results[1].Status != BatchFailed || resp.Counts[BatchUpdated] != 1 {
+		t.Fatalf("expected user3 to fail alone, got %d %+v", w.Code, resp)
+	}
+
+	if w := post(`{"userIds":["user1"],"keys":[]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without keys, got %d", w.Code)
+	}
+}
+
+// failingStore fails writes for one user.
+type failingStore struct {
+	*mockStore
+	fail string
+}
+
+func (s *failingStore) Update(ctx context.Context, userID string, prefs map[string]any, remove []string, cond Precondition) (Record, error) {
+	if userID == s.fail {
+		return Record{}, errors.New("throttled")
+	}
+	return s.mockStore.Update(ctx, userID, prefs, remove, cond)
+}
@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// BootstrapConfig declares the DynamoDB resources the service needs (see
+// bootstrap.example.json).
+type BootstrapConfig struct {
+	Tables []TableSpec `json:"tables"`
+	// DAX, when set, is validated but not provisioned.
+	DAX *DAXSpec `json:"dax,omitempty"`
+}
+
+// TableSpec declares a table. A table whose name is empty after expanding
+// environment variables is left out, so optional tables follow their env
+// var.
+type TableSpec struct {
+	Name         string        `json:"name"`
+	PartitionKey KeyAttribute  `json:"partitionKey"`
+	SortKey      *KeyAttribute `json:"sortKey,omitempty"`
+	// BillingMode is PAY_PER_REQUEST (the default) or PROVISIONED, which
+	// needs ReadCapacity and WriteCapacity.
+	BillingMode   string `json:"billingMode,omitempty"`
+	ReadCapacity  int64  `json:"readCapacity,omitempty"`
+	WriteCapacity int64  `json:"writeCapacity,omitempty"`
+	TTLAttribute  string `json:"ttlAttribute,omitempty"`
+	// StreamViewType enables the table's stream; the stream worker needs
+	// NEW_AND_OLD_IMAGES.
+	StreamViewType         string      `json:"streamViewType,omitempty"`
+	GlobalSecondaryIndexes []IndexSpec `json:"globalSecondaryIndexes,omitempty"`
+}
+
+// KeyAttribute is a key attribute and its type: S (the default), N or B.
+type KeyAttribute struct {
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+}
+
+// IndexSpec declares a global secondary index. Its capacity defaults to the
+// table's on a PROVISIONED table.
+type IndexSpec struct {
+	Name         string        `json:"name"`
+	PartitionKey KeyAttribute  `json:"partitionKey"`
+	SortKey      *KeyAttribute `json:"sortKey,omitempty"`
+	// Projection is ALL (the default), KEYS_ONLY or INCLUDE, which projects
+	// NonKeyAttributes.
+	Projection       string   `json:"projection,omitempty"`
+	NonKeyAttributes []string `json:"nonKeyAttributes,omitempty"`
+	ReadCapacity     int64    `json:"readCapacity,omitempty"`
+	WriteCapacity    int64    `json:"writeCapacity,omitempty"`
+}
+
+// DAXSpec holds the parameters of a DAX cluster in front of the table, for
+// validation before someone provisions it.
+type DAXSpec struct {
+	// Endpoint is the cluster's discovery endpoint, daxs:// or dax://.
+	Endpoint          string `json:"endpoint"`
+	NodeType          string `json:"nodeType"`
+	ReplicationFactor int    `json:"replicationFactor"`
+	// ItemTTL and QueryTTL are the parameter group's record and query
+	// cache TTLs, as Go durations.
+	ItemTTL  string `json:"itemTTL"`
+	QueryTTL string `json:"queryTTL"`
+}
+
+// LoadBootstrapConfig reads a bootstrap config file, expanding $VAR,
+// ${VAR} and ${VAR:-default}, and validates it.
+func LoadBootstrapConfig(path string) (*BootstrapConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading bootstrap config: %w", err)
+	}
+	data = []byte(os.Expand(string(data), func(v string) string {
+		name, fallback, _ := strings.Cut(v, ":-")
+		return envOrDefault(name, fallback)
+	}))
+
+	var cfg BootstrapConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parsing bootstrap config: %w", err)
+	}
+	cfg.Tables = slices.DeleteFunc(cfg.Tables, func(t TableSpec) bool { return t.Name == "" })
+	for i := range cfg.Tables {
+		if err := cfg.Tables[i].normalize(); err != nil {
+			return nil, fmt.Errorf("table %q: %w", cfg.Tables[i].Name, err)
+		}
+	}
+	return &cfg, nil
+}
+
+// normalize fills in defaults and checks the spec is one DynamoDB accepts.
+func (t *TableSpec) normalize() error {
+	if t.BillingMode == "" {
+		t.BillingMode = string(types.BillingModePayPerRequest)
+	}
+	provisioned := t.BillingMode == string(types.BillingModeProvisioned)
+	switch {
+	case !provisioned && t.BillingMode != string(types.BillingModePayPerRequest):
+		return fmt.Errorf("unknown billingMode %q", t.BillingMode)
+	case provisioned && (t.ReadCapacity < 1 || t.WriteCapacity < 1):
+		return errors.New("PROVISIONED needs readCapacity and writeCapacity")
+	case !provisioned && (t.ReadCapacity != 0 || t.WriteCapacity != 0):
+		return errors.New("capacity is only set for PROVISIONED")
+	}
+	if t.StreamViewType != "" && !slices.Contains(types.StreamViewType("").Values(), types.StreamViewType(t.StreamViewType)) {
+		return fmt.Errorf("unknown streamViewType %q", t.StreamViewType)
+	}
+
+	// Every key attribute, of the table and its indexes, has one type.
+	attrs := map[string]string{}
+	key := func(k *KeyAttribute, what string) error {
+		if k.Name == "" {
+			return fmt.Errorf("%s: missing name", what)
+		}
+		if k.Type == "" {
+			k.Type = "S"
+		}
+		if !slices.Contains([]string{"S", "N", "B"}, k.Type) {
+			return fmt.Errorf("%s %s: type must be S, N or B", what, k.Name)
+		}
+		if typ, ok := attrs[k.Name]; ok && typ != k.Type {
+			return fmt.Errorf("%s %s: declared as both %s and %s", what, k.Name, typ, k.Type)
+		}
+		attrs[k.Name] = k.Type
+		return nil
+	}
+	if err := key(&t.PartitionKey, "partitionKey"); err != nil {
+		return err
+	}
+	if t.SortKey != nil {
+		if err := key(t.SortKey, "sortKey"); err != nil {
+			return err
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := range t.GlobalSecondaryIndexes {
+		ix := &t.GlobalSecondaryIndexes[i]
+		if ix.Name == "" {
+			return fmt.Errorf("index %d: missing name", i)
+		}
+		if seen[ix.Name] {
+			return fmt.Errorf("index %q: declared twice", ix.Name)
+		}
+		seen[ix.Name] = true
+		if err := key(&ix.PartitionKey, "index "+ix.Name+" partitionKey"); err != nil {
+			return err
+		}
+		if ix.SortKey != nil {
+			if err := key(ix.SortKey, "index "+ix.Name+" sortKey"); err != nil {
+				return err
+			}
+		}
+		if ix.Projection == "" {
+			ix.Projection = string(types.ProjectionTypeAll)
+		}
+		if !slices.Contains(types.ProjectionType("").Values(), types.ProjectionType(ix.Projection)) {
+			return fmt.Errorf("index %q: unknown projection %q", ix.Name, ix.Projection)
+		}
+		if (ix.Projection == string(types.ProjectionTypeInclude)) != (len(ix.NonKeyAttributes) > 0) {
+			return fmt.Errorf("index %q: nonKeyAttributes go with, and only with, INCLUDE", ix.Name)
+		}
+		if provisioned {
+			if ix.ReadCapacity == 0 {
+				ix.ReadCapacity = t.ReadCapacity
+			}
+			if ix.WriteCapacity == 0 {
+				ix.WriteCapacity = t.WriteCapacity
+			}
+		} else if ix.ReadCapacity != 0 || ix.WriteCapacity != 0 {
+			return fmt.Errorf("index %q: capacity is only set for PROVISIONED", ix.Name)
+		}
+	}
+	return nil
+}
+
+// dynamoAdminAPI is the subset of the DynamoDB client bootstrap uses, for
+// testing.
+type dynamoAdminAPI interface {
+	DescribeTable(ctx context.Context, in *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	CreateTable(ctx context.Context, in *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	UpdateTable(ctx context.Context, in *dynamodb.UpdateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error)
+	DescribeTimeToLive(ctx context.Context, in *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error)
+	UpdateTimeToLive(ctx context.Context, in *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error)
+}
+
+// bootstrapPollInterval is how often apply checks whether a table or index
+// has become ACTIVE.
+var bootstrapPollInterval = 2 * time.Second
+
+// bootstrapChange is one step of a plan.
+type bootstrapChange struct {
+	desc  string
+	apply func(ctx context.Context) error
+}
+
+// BootstrapPlan is what bootstrap would change to match the config, in
+// order, and notes on what it leaves alone.
+type BootstrapPlan struct {
+	changes []bootstrapChange
+	notes   []string
+}
+
+func (p *BootstrapPlan) change(desc string, apply func(ctx context.Context) error) {
+	p.changes = append(p.changes, bootstrapChange{desc: desc, apply: apply})
+}
+
+func (p *BootstrapPlan) note(format string, args ...any) {
+	p.notes = append(p.notes, fmt.Sprintf(format, args...))
+}
+
+// Print writes the plan for review.
+func (p *BootstrapPlan) Print(w io.Writer) {
+	for _, c := range p.changes {
+		fmt.Fprintf(w, "  + %s\n", c.desc)
+	}
+	for _, n := range p.notes {
+		fmt.Fprintf(w, "  = %s\n", n)
+	}
+	if len(p.changes) == 0 {
+		fmt.Fprintln(w, "No changes.")
+		return
+	}
+	fmt.Fprintf(w, "Plan: %d change(s).\n", len(p.changes))
+}
+
+// Apply makes the plan's changes in order, waiting for each table or index
+// change to finish before the next.
+func (p *BootstrapPlan) Apply(ctx context.Context, w io.Writer) error {
+	for _, c := range p.changes {
+		fmt.Fprintf(w, "applying: %s\n", c.desc)
+		if err := c.apply(ctx); err != nil {
+			return fmt.Errorf("%s: %w", c.desc, err)
+		}
+	}
+	return nil
+}
+
+// PlanBootstrap compares the tables with cfg and plans the changes that
+// would make them match: creating missing tables and indexes, enabling
+// streams and TTL, and changing billing. It never deletes; undeclared
+// indexes and streams are noted and left in place, and changes DynamoDB
+// cannot make in place (a different key schema, TTL on another attribute)
+// are errors. region is the region the DAX endpoint must be in.
+func PlanBootstrap(ctx context.Context, client dynamoAdminAPI, cfg *BootstrapConfig, region string) (*BootstrapPlan, error) {
+	plan := &BootstrapPlan{}
+	for i := range cfg.Tables {
+		if err := planTable(ctx, client, &cfg.Tables[i], plan); err != nil {
+			return nil, fmt.Errorf("table %s: %w", cfg.Tables[i].Name, err)
+		}
+	}
+	if cfg.DAX != nil {
+		if err := validateDAX(cfg.DAX, region, plan); err != nil {
+			return nil, fmt.Errorf("dax: %w", err)
+		}
+	}
+	return plan, nil
+}
+
+func planTable(ctx context.Context, client dynamoAdminAPI, t *TableSpec, plan *BootstrapPlan) error {
+	out, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &t.Name})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		desc := fmt.Sprintf("create table %s: key %s, %s", t.Name, keyString(t.PartitionKey, t.SortKey), t.BillingMode)
+		if t.StreamViewType != "" {
+			desc += ", stream " + t.StreamViewType
+		}
+		for _, ix := range t.GlobalSecondaryIndexes {
+			desc += ", index " + ix.Name
+		}
+		plan.change(desc, func(ctx context.Context) error {
+			if _, err := client.CreateTable(ctx, t.createInput()); err != nil {
+				return err
+			}
+			return waitTableActive(ctx, client, t.Name)
+		})
+		if t.TTLAttribute != "" {
+			plan.change(fmt.Sprintf("enable TTL on %s.%s", t.Name, t.TTLAttribute), t.enableTTL(client))
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("DescribeTable: %w", err)
+	}
+	table := out.Table
+	planned := len(plan.changes)
+
+	if !sameKeys(table.KeySchema, t.PartitionKey, t.SortKey) {
+		return fmt.Errorf("key schema is %s, not %s; DynamoDB cannot change it in place", describedKeys(table.KeySchema), keyString(t.PartitionKey, t.SortKey))
+	}
+
+	billing := types.BillingModeProvisioned
+	if table.BillingModeSummary != nil && table.BillingModeSummary.BillingMode != "" {
+		billing = table.BillingModeSummary.BillingMode
+	}
+	switch {
+	case string(billing) != t.BillingMode:
+		plan.change(fmt.Sprintf("change %s billing from %s to %s", t.Name, billing, t.BillingMode), t.updateBilling(client, table.GlobalSecondaryIndexes))
+	case billing == types.BillingModeProvisioned && table.ProvisionedThroughput != nil &&
+		(aws.ToInt64(table.ProvisionedThroughput.ReadCapacityUnits) != t.ReadCapacity || aws.ToInt64(table.ProvisionedThroughput.WriteCapacityUnits) != t.WriteCapacity):
+		plan.change(fmt.Sprintf("change %s capacity to %d read, %d write", t.Name, t.ReadCapacity, t.WriteCapacity), t.updateBilling(client, nil))
+	}
+
+	var streamView types.StreamViewType
+	if s := table.StreamSpecification; s != nil && aws.ToBool(s.StreamEnabled) {
+		streamView = s.StreamViewType
+	}
+	switch {
+	case t.StreamViewType == "" && streamView != "":
+		plan.note("%s has a %s stream that is not declared; left in place", t.Name, streamView)
+	case t.StreamViewType != "" && streamView == "":
+		plan.change(fmt.Sprintf("enable %s stream on %s", t.StreamViewType, t.Name), t.setStream(client, true))
+	case t.StreamViewType != "" && string(streamView) != t.StreamViewType:
+		// A stream's view type cannot change; it is replaced, and the new
+		// stream starts empty.
+		plan.change(fmt.Sprintf("disable %s stream on %s", streamView, t.Name), t.setStream(client, false))
+		plan.change(fmt.Sprintf("enable %s stream on %s", t.StreamViewType, t.Name), t.setStream(client, true))
+	}
+
+	existing := make(map[string]types.GlobalSecondaryIndexDescription, len(table.GlobalSecondaryIndexes))
+	for _, ix := range table.GlobalSecondaryIndexes {
+		existing[aws.ToString(ix.IndexName)] = ix
+	}
+	for _, ix := range t.GlobalSecondaryIndexes {
+		cur, ok := existing[ix.Name]
+		delete(existing, ix.Name)
+		if !ok {
+			plan.change(fmt.Sprintf("create index %s on %s: key %s, projection %s", ix.Name, t.Name, keyString(ix.PartitionKey, ix.SortKey), ix.Projection), t.createIndex(client, ix))
+			continue
+		}
+		if !sameKeys(cur.KeySchema, ix.PartitionKey, ix.SortKey) {
+			return fmt.Errorf("index %s key schema is %s, not %s; delete and recreate it by hand", ix.Name, describedKeys(cur.KeySchema), keyString(ix.PartitionKey, ix.SortKey))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(existing)) {
+		plan.note("index %s on %s is not declared; left in place", name, t.Name)
+	}
+
+	if t.TTLAttribute != "" {
+		ttl, err := client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: &t.Name})
+		if err != nil {
+			return fmt.Errorf("DescribeTimeToLive: %w", err)
+		}
+		var status types.TimeToLiveStatus
+		var attr string
+		if d := ttl.TimeToLiveDescription; d != nil {
+			status, attr = d.TimeToLiveStatus, aws.ToString(d.AttributeName)
+		}
+		switch status {
+		case types.TimeToLiveStatusEnabled, types.TimeToLiveStatusEnabling:
+			if attr != t.TTLAttribute {
+				return fmt.Errorf("TTL is enabled on %s, not %s; disable it by hand first (DynamoDB allows one TTL change an hour)", attr, t.TTLAttribute)
+			}
+		case types.TimeToLiveStatusDisabling:
+			return errors.New("TTL is being disabled; try again once it is")
+		default:
+			plan.change(fmt.Sprintf("enable TTL on %s.%s", t.Name, t.TTLAttribute), t.enableTTL(client))
+		}
+	}
+
+	if len(plan.changes) == planned {
+		plan.note("table %s is up to date", t.Name)
+	}
+	return nil
+}
+
+func (t *TableSpec) createInput() *dynamodb.CreateTableInput {
+	in := &dynamodb.CreateTableInput{
+		TableName:            &t.Name,
+		KeySchema:            keySchema(t.PartitionKey, t.SortKey),
+		AttributeDefinitions: t.attributeDefinitions(),
+		BillingMode:          types.BillingMode(t.BillingMode),
+	}
+	if t.BillingMode == string(types.BillingModeProvisioned) {
+		in.ProvisionedThroughput = throughput(t.ReadCapacity, t.WriteCapacity)
+	}
+	if t.StreamViewType != "" {
+		in.StreamSpecification = &types.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: types.StreamViewType(t.StreamViewType)}
+	}
+	for _, ix := range t.GlobalSecondaryIndexes {
+		in.GlobalSecondaryIndexes = append(in.GlobalSecondaryIndexes, ix.definition())
+	}
+	return in
+}
+
+// attributeDefinitions lists the key attributes of the table and its
+// indexes, each once.
+func (t *TableSpec) attributeDefinitions() []types.AttributeDefinition {
+	var defs []types.AttributeDefinition
+	add := func(k *KeyAttribute) {
+		if k == nil || slices.ContainsFunc(defs, func(d types.AttributeDefinition) bool { return aws.ToString(d.AttributeName) == k.Name }) {
+			return
+		}
+		defs = append(defs, types.AttributeDefinition{AttributeName: aws.String(k.Name), AttributeType: types.ScalarAttributeType(k.Type)})
+	}
+	add(&t.PartitionKey)
+	add(t.SortKey)
+	for i := range t.GlobalSecondaryIndexes {
+		add(&t.GlobalSecondaryIndexes[i].PartitionKey)
+		add(t.GlobalSecondaryIndexes[i].SortKey)
+	}
+	return defs
+}
+
+func (ix IndexSpec) definition() types.GlobalSecondaryIndex {
+	def := types.GlobalSecondaryIndex{
+		IndexName:  aws.String(ix.Name),
+		KeySchema:  keySchema(ix.PartitionKey, ix.SortKey),
+		Projection: &types.Projection{ProjectionType: types.ProjectionType(ix.Projection), NonKeyAttributes: ix.NonKeyAttributes},
+	}
+	if ix.ReadCapacity != 0 {
+		def.ProvisionedThroughput = throughput(ix.ReadCapacity, ix.WriteCapacity)
+	}
+	return def
+}
+
+func (t *TableSpec) updateBilling(client dynamoAdminAPI, indexes []types.GlobalSecondaryIndexDescription) func(context.Context) error {
+	return func(ctx context.Context) error {
+		in := &dynamodb.UpdateTableInput{TableName: &t.Name, BillingMode: types.BillingMode(t.BillingMode)}
+		if t.BillingMode == string(types.BillingModeProvisioned) {
+			in.ProvisionedThroughput = throughput(t.ReadCapacity, t.WriteCapacity)
+			// Switching to PROVISIONED sets every index's capacity too.
+			for _, cur := range indexes {
+				name := aws.ToString(cur.IndexName)
+				read, write := t.ReadCapacity, t.WriteCapacity
+				if i := slices.IndexFunc(t.GlobalSecondaryIndexes, func(ix IndexSpec) bool { return ix.Name == name }); i >= 0 {
+					read, write = t.GlobalSecondaryIndexes[i].ReadCapacity, t.GlobalSecondaryIndexes[i].WriteCapacity
+				}
+				in.GlobalSecondaryIndexUpdates = append(in.GlobalSecondaryIndexUpdates, types.GlobalSecondaryIndexUpdate{
+					Update: &types.UpdateGlobalSecondaryIndexAction{IndexName: aws.String(name), ProvisionedThroughput: throughput(read, write)},
+				})
+			}
+		}
+		if _, err := client.UpdateTable(ctx, in); err != nil {
+			return err
+		}
+		return waitTableActive(ctx, client, t.Name)
+	}
+}
+
+func (t *TableSpec) setStream(client dynamoAdminAPI, enabled bool) func(context.Context) error {
+	return func(ctx context.Context) error {
+		spec := &types.StreamSpecification{StreamEnabled: aws.Bool(enabled)}
+		if enabled {
+			spec.StreamViewType = types.StreamViewType(t.StreamViewType)
+		}
+		if _, err := client.UpdateTable(ctx, &dynamodb.UpdateTableInput{TableName: &t.Name, StreamSpecification: spec}); err != nil {
+			return err
+		}
+		return waitTableActive(ctx, client, t.Name)
+	}
+}
+
+// createIndex adds one index; DynamoDB creates one per UpdateTable.
+func (t *TableSpec) createIndex(client dynamoAdminAPI, ix IndexSpec) func(context.Context) error {
+	return func(ctx context.Context) error {
+		def := ix.definition()
+		_, err := client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:            &t.Name,
+			AttributeDefinitions: t.attributeDefinitions(),
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{Create: &types.CreateGlobalSecondaryIndexAction{
+				IndexName:             def.IndexName,
+				KeySchema:             def.KeySchema,
+				Projection:            def.Projection,
+				ProvisionedThroughput: def.ProvisionedThroughput,
+			}}},
+		})
+		if err != nil {
+			return err
+		}
+		return waitTableActive(ctx, client, t.Name)
+	}
+}
+
+func (t *TableSpec) enableTTL(client dynamoAdminAPI) func(context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+			TableName:               &t.Name,
+			TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: &t.TTLAttribute, Enabled: aws.Bool(true)},
+		})
+		return err
+	}
+}
+
+// waitTableActive polls until the table and all its indexes are ACTIVE.
+func waitTableActive(ctx context.Context, client dynamoAdminAPI, name string) error {
+	for {
+		out, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &name})
+		if err != nil {
+			return fmt.Errorf("DescribeTable: %w", err)
+		}
+		active := out.Table.TableStatus == types.TableStatusActive
+		for _, ix := range out.Table.GlobalSecondaryIndexes {
+			active = active && ix.IndexStatus == types.IndexStatusActive
+		}
+		if active {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s to become ACTIVE: %w", name, ctx.Err())
+		case <-time.After(bootstrapPollInterval):
+		}
+	}
+}
+
+func keySchema(pk KeyAttribute, sk *KeyAttribute) []types.KeySchemaElement {
+	ks := []types.KeySchemaElement{{AttributeName: aws.String(pk.Name), KeyType: types.KeyTypeHash}}
+	if sk != nil {
+		ks = append(ks, types.KeySchemaElement{AttributeName: aws.String(sk.Name), KeyType: types.KeyTypeRange})
+	}
+	return ks
+}
+
+func sameKeys(cur []types.KeySchemaElement, pk KeyAttribute, sk *KeyAttribute) bool {
+	return describedKeys(cur) == describedKeys(keySchema(pk, sk))
+}
+
+func describedKeys(ks []types.KeySchemaElement) string {
+	parts := make([]string, len(ks))
+	for i, k := range ks {
+		parts[i] = aws.ToString(k.AttributeName) + " " + string(k.KeyType)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func keyString(pk KeyAttribute, sk *KeyAttribute) string {
+	s := pk.Name + " (" + pk.Type + ")"
+	if sk != nil {
+		s += " + " + sk.Name + " (" + sk.Type + ")"
+	}
+	return s
+}
+
+func throughput(read, write int64) *types.ProvisionedThroughput {
+	return &types.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(read), WriteCapacityUnits: aws.Int64(write)}
+}
+
+// validateDAX checks a DAX cluster's parameters, noting those that are
+// valid but worth a second look.
+func validateDAX(d *DAXSpec, region string, plan *BootstrapPlan) error {
+	var errs []error
+	u, err := url.Parse(d.Endpoint)
+	switch {
+	case d.Endpoint == "":
+		errs = append(errs, errors.New("endpoint is required"))
+	case err != nil || (u.Scheme != "dax" && u.Scheme != "daxs") || u.Hostname() == "":
+		errs = append(errs, fmt.Errorf("endpoint %q is not a dax:// or daxs:// URL", d.Endpoint))
+	default:
+		if u.Scheme == "dax" {
+			plan.note("DAX endpoint is unencrypted; clusters with encryption in transit use daxs://")
+		}
+		if _, rest, ok := strings.Cut(u.Hostname(), ".dax-clusters."); ok {
+			if r, _, _ := strings.Cut(rest, "."); r != region {
+				errs = append(errs, fmt.Errorf("endpoint is in %s, not %s", r, region))
+			}
+		}
+	}
+	if !strings.HasPrefix(d.NodeType, "dax.") {
+		errs = append(errs, fmt.Errorf("nodeType %q is not a DAX node type (dax.*)", d.NodeType))
+	}
+	switch {
+	case d.ReplicationFactor < 1 || d.ReplicationFactor > 11:
+		errs = append(errs, fmt.Errorf("replicationFactor %d is not between 1 and 11", d.ReplicationFactor))
+	case d.ReplicationFactor < 3:
+		plan.note("DAX cluster of %d node(s) does not survive an Availability Zone outage; use 3 or more", d.ReplicationFactor)
+	}
+	ttl := func(name, v string) time.Duration {
+		dur, err := time.ParseDuration(v)
+		if err != nil || dur < 0 || dur%time.Millisecond != 0 {
+			errs = append(errs, fmt.Errorf("%s %q is not a non-negative duration in whole milliseconds", name, v))
+		}
+		return dur
+	}
+	// The service writes to DynamoDB directly, so cached items only
+	// catch up with its writes when they expire.
+	if ttl("itemTTL", d.ItemTTL) == 0 && d.ItemTTL != "" {
+		errs = append(errs, errors.New("itemTTL 0 never expires cached items, which would then miss writes made past the cluster"))
+	}
+	ttl("queryTTL", d.QueryTTL)
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	plan.note("DAX parameters are valid; bootstrap does not provision the cluster")
+	return nil
+}
+
+// runBootstrap implements "user-prefs bootstrap": it prints the plan for
+// the config's tables and, with -apply, makes it.
+func runBootstrap(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	path := fs.String("config", "", "bootstrap config file (see bootstrap.example.json)")
+	apply := fs.Bool("apply", false, "make the planned changes (default: print the plan only)")
+	timeout := fs.Duration("timeout", 30*time.Minute, "how long to wait for tables and indexes to become ACTIVE")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		fmt.Fprintln(stderr, "bootstrap: -config is required")
+		return 2
+	}
+
+	cfg, err := LoadBootstrapConfig(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "bootstrap: %v\n", err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	region := envOrDefault("AWS_REGION", "us-east-1")
+	store, err := NewDynamoStore(ctx, Config{AWSRegion: region, DynamoEndpoint: os.Getenv("DYNAMODB_ENDPOINT")})
+	if err != nil {
+		fmt.Fprintf(stderr, "bootstrap: %v\n", err)
+		return 1
+	}
+	plan, err := PlanBootstrap(ctx, store.client, cfg, region)
+	if err != nil {
+		fmt.Fprintf(stderr, "bootstrap: %v\n", err)
+		return 1
+	}
+
+	plan.Print(stdout)
+	if len(plan.changes) == 0 {
+		return 0
+	}
+	if !*apply {
+		fmt.Fprintln(stdout, "Run again with -apply to make these changes.")
+		return 0
+	}
+	if err := plan.Apply(ctx, stdout); err != nil {
+		fmt.Fprintf(stderr, "bootstrap: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, "Done.")
+	return 0
+}
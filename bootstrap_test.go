@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeDynamoAdmin keeps table descriptions in memory. New tables and
+// indexes report CREATING once before they are ACTIVE.
+type fakeDynamoAdmin struct {
+	tables map[string]*types.TableDescription
+	ttl    map[string]*types.TimeToLiveDescription
+	calls  []string
+}
+
+func newFakeDynamoAdmin() *fakeDynamoAdmin {
+	return &fakeDynamoAdmin{tables: map[string]*types.TableDescription{}, ttl: map[string]*types.TimeToLiveDescription{}}
+}
+
+func (f *fakeDynamoAdmin) DescribeTable(_ context.Context, in *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	t, ok := f.tables[*in.TableName]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("not found")}
+	}
+	out := *t
+	out.GlobalSecondaryIndexes = append([]types.GlobalSecondaryIndexDescription(nil), t.GlobalSecondaryIndexes...)
+	t.TableStatus = types.TableStatusActive
+	for i := range t.GlobalSecondaryIndexes {
+		t.GlobalSecondaryIndexes[i].IndexStatus = types.IndexStatusActive
+	}
+	return &dynamodb.DescribeTableOutput{Table: &out}, nil
+}
+
+func (f *fakeDynamoAdmin) CreateTable(_ context.Context, in *dynamodb.CreateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	f.calls = append(f.calls, "CreateTable "+*in.TableName)
+	t := &types.TableDescription{
+		TableName:           in.TableName,
+		TableStatus:         types.TableStatusCreating,
+		KeySchema:           in.KeySchema,
+		BillingModeSummary:  &types.BillingModeSummary{BillingMode: in.BillingMode},
+		StreamSpecification: in.StreamSpecification,
+	}
+	for _, ix := range in.GlobalSecondaryIndexes {
+		t.GlobalSecondaryIndexes = append(t.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{IndexName: ix.IndexName, KeySchema: ix.KeySchema, IndexStatus: types.IndexStatusCreating})
+	}
+	f.tables[*in.TableName] = t
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (f *fakeDynamoAdmin) UpdateTable(_ context.Context, in *dynamodb.UpdateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error) {
+	t := f.tables[*in.TableName]
+	t.TableStatus = types.TableStatusUpdating
+	switch {
+	case in.StreamSpecification != nil:
+		f.calls = append(f.calls, "UpdateTable stream "+string(in.StreamSpecification.StreamViewType))
+		t.StreamSpecification = in.StreamSpecification
+	case in.BillingMode != "":
+		f.calls = append(f.calls, "UpdateTable billing "+string(in.BillingMode))
+		t.BillingModeSummary = &types.BillingModeSummary{BillingMode: in.BillingMode}
+	default:
+		for _, u := range in.GlobalSecondaryIndexUpdates {
+			f.calls = append(f.calls, "UpdateTable index "+*u.Create.IndexName)
+			t.GlobalSecondaryIndexes = append(t.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{IndexName: u.Create.IndexName, KeySchema: u.Create.KeySchema, IndexStatus: types.IndexStatusCreating})
+		}
+	}
+	return &dynamodb.UpdateTableOutput{}, nil
+}
+
+func (f *fakeDynamoAdmin) DescribeTimeToLive(_ context.Context, in *dynamodb.DescribeTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	d := f.ttl[*in.TableName]
+	if d == nil {
+		d = &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusDisabled}
+	}
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: d}, nil
+}
+
+func (f *fakeDynamoAdmin) UpdateTimeToLive(_ context.Context, in *dynamodb.UpdateTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	f.calls = append(f.calls, "UpdateTimeToLive "+*in.TimeToLiveSpecification.AttributeName)
+	f.ttl[*in.TableName] = &types.TimeToLiveDescription{AttributeName: in.TimeToLiveSpecification.AttributeName, TimeToLiveStatus: types.TimeToLiveStatusEnabled}
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func writeBootstrapConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bootstrap.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadBootstrapConfig(t *testing.T) {
+	t.Setenv("DYNAMODB_TABLE_NAME", "prefs-test")
+	t.Setenv("HISTORY_TABLE_NAME", "")
+	cfg, err := LoadBootstrapConfig("bootstrap.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Tables) != 1 || cfg.Tables[0].Name != "prefs-test" || cfg.Tables[0].PartitionKey.Type != "S" || cfg.Tables[0].BillingMode != "PAY_PER_REQUEST" {
+		t.Fatalf("expected the history table left out and defaults filled in, got %+v", cfg.Tables)
+	}
+
+	for name, body := range map[string]string{
+		"unknown field":    `{"tables":[{"name":"t","partitionKey":{"name":"PK"},"ttl":"expiresAt"}]}`,
+		"capacity":         `{"tables":[{"name":"t","partitionKey":{"name":"PK"},"readCapacity":5}]}`,
+		"provisioned":      `{"tables":[{"name":"t","partitionKey":{"name":"PK"},"billingMode":"PROVISIONED"}]}`,
+		"stream view":      `{"tables":[{"name":"t","partitionKey":{"name":"PK"},"streamViewType":"NEW"}]}`,
+		"attribute types":  `{"tables":[{"name":"t","partitionKey":{"name":"PK"},"globalSecondaryIndexes":[{"name":"i","partitionKey":{"name":"PK","type":"N"}}]}]}`,
+		"include":          `{"tables":[{"name":"t","partitionKey":{"name":"PK"},"globalSecondaryIndexes":[{"name":"i","partitionKey":{"name":"GSI1PK"},"projection":"INCLUDE"}]}]}`,
+		"duplicate index":  `{"tables":[{"name":"t","partitionKey":{"name":"PK"},"globalSecondaryIndexes":[{"name":"i","partitionKey":{"name":"A"}},{"name":"i","partitionKey":{"name":"B"}}]}]}`,
+		"missing key name": `{"tables":[{"name":"t","partitionKey":{}}]}`,
+	} {
+		if _, err := LoadBootstrapConfig(writeBootstrapConfig(t, body)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPlanBootstrap(t *testing.T) {
+	defer func(d time.Duration) { bootstrapPollInterval = d }(bootstrapPollInterval)
+	bootstrapPollInterval = time.Millisecond
+	cfg, err := LoadBootstrapConfig(writeBootstrapConfig(t, `{"tables":[
+		{"name":"prefs","partitionKey":{"name":"PK"},"ttlAttribute":"expiresAt","streamViewType":"NEW_AND_OLD_IMAGES",
+		 "globalSecondaryIndexes":[{"name":"byEmail","partitionKey":{"name":"email"}}]},
+		{"name":"history","partitionKey":{"name":"PK"},"sortKey":{"name":"SK"},"ttlAttribute":"expiresAt"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	client := newFakeDynamoAdmin()
+
+	plan, err := PlanBootstrap(ctx, client, cfg, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	plan.Print(&out)
+	if len(client.calls) != 0 || !strings.Contains(out.String(), "+ create table prefs: key PK (S), PAY_PER_REQUEST, stream NEW_AND_OLD_IMAGES, index byEmail") ||
+		!strings.Contains(out.String(), "Plan: 4 change(s).") {
+		t.Fatalf("unexpected plan (calls %v):\n%s", client.calls, out.String())
+	}
+	if err := plan.Apply(ctx, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"CreateTable prefs", "UpdateTimeToLive expiresAt", "CreateTable history", "UpdateTimeToLive expiresAt"}
+	if strings.Join(client.calls, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected %v, got %v", want, client.calls)
+	}
+
+	plan, err = PlanBootstrap(ctx, client, cfg, "us-east-1")
+	if err != nil || len(plan.changes) != 0 {
+		t.Fatalf("expected no changes once applied, got %v %v", plan, err)
+	}
+
+	// An existing table gains what it lacks; what is undeclared stays.
+	client = newFakeDynamoAdmin()
+	client.tables["prefs"] = &types.TableDescription{
+		TableName:           aws.String("prefs"),
+		TableStatus:         types.TableStatusActive,
+		KeySchema:           keySchema(KeyAttribute{Name: "PK"}, nil),
+		BillingModeSummary:  &types.BillingModeSummary{BillingMode: types.BillingModePayPerRequest},
+		StreamSpecification: &types.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: types.StreamViewTypeKeysOnly},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndexDescription{
+			{IndexName: aws.String("legacy"), KeySchema: keySchema(KeyAttribute{Name: "old"}, nil), IndexStatus: types.IndexStatusActive},
+		},
+	}
+	cfg.Tables = cfg.Tables[:1]
+	plan, err = PlanBootstrap(ctx, client, cfg, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	plan.Print(&out)
+	if !strings.Contains(out.String(), "index legacy on prefs is not declared; left in place") {
+		t.Fatalf("expected the undeclared index noted:\n%s", out.String())
+	}
+	if err := plan.Apply(ctx, &out); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"UpdateTable stream ", "UpdateTable stream NEW_AND_OLD_IMAGES", "UpdateTable index byEmail", "UpdateTimeToLive expiresAt"}
+	if strings.Join(client.calls, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected %v, got %v", want, client.calls)
+	}
+
+	// Changes DynamoDB cannot make in place are refused.
+	client.tables["prefs"].KeySchema = keySchema(KeyAttribute{Name: "PK"}, &KeyAttribute{Name: "SK"})
+	if _, err := PlanBootstrap(ctx, client, cfg, "us-east-1"); err == nil || !strings.Contains(err.Error(), "cannot change it in place") {
+		t.Fatalf("expected a key schema error, got %v", err)
+	}
+	client.tables["prefs"].KeySchema = keySchema(KeyAttribute{Name: "PK"}, nil)
+	client.ttl["prefs"] = &types.TimeToLiveDescription{AttributeName: aws.String("ttl"), TimeToLiveStatus: types.TimeToLiveStatusEnabled}
+	if _, err := PlanBootstrap(ctx, client, cfg, "us-east-1"); err == nil || !strings.Contains(err.Error(), "TTL is enabled on ttl") {
+		t.Fatalf("expected a TTL error, got %v", err)
+	}
+}
+
+func TestValidateDAX(t *testing.T) {
+	valid := DAXSpec{
+		Endpoint:          "daxs://prefs.abc123.dax-clusters.us-east-1.amazonaws.com",
+		NodeType:          "dax.r5.large",
+		ReplicationFactor: 3,
+		ItemTTL:           "5m",
+		QueryTTL:          "0s",
+	}
+	plan := &BootstrapPlan{}
+	if err := validateDAX(&valid, "us-east-1", plan); err != nil || len(plan.notes) != 1 {
+		t.Fatalf("expected valid parameters, got %v %v", err, plan.notes)
+	}
+
+	for name, mutate := range map[string]func(d *DAXSpec){
+		"scheme":      func(d *DAXSpec) { d.Endpoint = "https://prefs.abc123.dax-clusters.us-east-1.amazonaws.com" },
+		"region":      func(d *DAXSpec) { d.Endpoint = "daxs://prefs.abc123.dax-clusters.eu-west-1.amazonaws.com" },
+		"node type":   func(d *DAXSpec) { d.NodeType = "r5.large" },
+		"nodes":       func(d *DAXSpec) { d.ReplicationFactor = 12 },
+		"item TTL":    func(d *DAXSpec) { d.ItemTTL = "0s" },
+		"query TTL":   func(d *DAXSpec) { d.QueryTTL = "1.5ms" },
+		"missing TTL": func(d *DAXSpec) { d.ItemTTL = "" },
+	} {
+		d := valid
+		mutate(&d)
+		if err := validateDAX(&d, "us-east-1", &BootstrapPlan{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	d := valid
+	d.Endpoint, d.ReplicationFactor = "dax://localhost:8111", 1
+	plan = &BootstrapPlan{}
+	if err := validateDAX(&d, "us-east-1", plan); err != nil || len(plan.notes) != 3 {
+		t.Fatalf("expected notes on encryption and fault tolerance, got %v %v", err, plan.notes)
+	}
+}
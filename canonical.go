@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// CanonicalJSON re-encodes JSON responses in the JSON Canonicalization
+// Scheme (RFC 8785): object members sorted by key at every level, no
+// insignificant whitespace, numbers and strings in one fixed form. Equal
+// documents then have equal bytes, for caching proxies, diffing and
+// signatures. It applies to every response when always is set, and
+// otherwise to requests with ?canonical=true, which as part of the URL
+// keeps caches from mixing the two forms. Event streams pass through
+// unbuffered.
+func CanonicalJSON(always bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if (!always && r.URL.Query().Get("canonical") != "true") || longLived(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			bw := &bufferingWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(bw, r)
+			body := bw.body.Bytes()
+			if isJSONMediaType(w.Header().Get("Content-Type")) && len(body) > 0 {
+				if canon, err := canonicalJSON(body); err == nil {
+					body = canon
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				}
+			}
+			w.WriteHeader(bw.status)
+			w.Write(body)
+		})
+	}
+}
+
+// isJSONMediaType reports whether a Content-Type is JSON, including +json
+// types such as application/problem+json.
+func isJSONMediaType(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// canonicalJSON re-encodes a JSON document per RFC 8785. Integers are
+// written as given rather than through float64, so those beyond 2^53, which
+// the scheme cannot represent exactly, keep their value.
+func canonicalJSON(body []byte) ([]byte, error) {
+	var v any
+	if err := decodeJSON(bytes.NewReader(body), &v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		writeCanonicalString(buf, v)
+	case json.Number:
+		n, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(n)
+	case []any:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		// Members sort by their keys' UTF-16 code units.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.SortFunc(keys, func(a, b string) int {
+			return slices.Compare(utf16.Encode([]rune(a)), utf16.Encode([]rune(b)))
+		})
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, k)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON value %T", v)
+	}
+	return nil
+}
+
+// canonicalNumber formats a number as ECMAScript's Number.prototype.toString
+// does, except for integers (see canonicalJSON).
+func canonicalNumber(n json.Number) (string, error) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if s == "-0" {
+			return "0", nil
+		}
+		return s, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", err
+	}
+	if f == 0 {
+		return "0", nil
+	}
+	if abs := max(f, -f); abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+	// Go pads exponents to two digits; ECMAScript does not.
+	mant, exp, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+	sign, digits := exp[:1], strings.TrimLeft(exp[1:], "0")
+	return mant + "e" + sign + cmp.Or(digits, "0"), nil
+}
+
+// writeCanonicalString escapes only what JSON requires: quotes, backslashes
+// and control characters, the latter as \b, \t, \n, \f, \r or lower-case
+// \u00xx.
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	for in, want := range map[string]string{
+		`{"b": 1, "a": [true, null, "x"]}`:        `{"a":[true,null,"x"],"b":1}`,
+		`{"n": [1.0, 1e2, 0.000001, 1e-7, -0.0]}`: `{"n":[1,100,0.000001,1e-7,0]}`,
+		`{"n": [1e21, 123456789012345678901]}`:    `{"n":[1e+21,123456789012345678901]}`,
+		`{"s": "<a&b> é\u0001\n\"\\"}`:            `{"s":"<a&b>` + " é" + `\u0001\n\"\\"}`,
+		// U+FB01 sorts before U+1D11E by code point but after it in UTF-16.
+		`{"\uFB01": 1, "\uD834\uDD1E": 2, "a": 3}`: "{\"a\":3,\"\U0001D11E\":2,\"\uFB01\":1}",
+	} {
+		got, err := canonicalJSON([]byte(in))
+		if err != nil || string(got) != want {
+			t.Errorf("canonicalJSON(%s) = %s, %v; want %s", in, got, err, want)
+		}
+	}
+}
+
+func TestCanonicalJSON_Middleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusCreated, map[string]any{"z": 1, "a": "<b>"})
+	})
+	serve := func(always bool, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		CanonicalJSON(always)(next).ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	if w := serve(false, "/"); w.Body.String() != "{\"a\":\"\\u003cb\\u003e\",\"z\":1}\n" {
+		t.Fatalf("expected the response unchanged, got %q", w.Body.String())
+	}
+	for _, w := range []*httptest.ResponseRecorder{serve(false, "/?canonical=true"), serve(true, "/")} {
+		if w.Code != http.StatusCreated || w.Body.String() != `{"a":"<b>","z":1}` || w.Header().Get("Content-Length") != "17" {
+			t.Fatalf("expected canonical output, got %d %q %v", w.Code, w.Body.String(), w.Header())
+		}
+	}
+}
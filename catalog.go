@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// PreferenceKeyInfo describes one well-known key in the catalog.
+type PreferenceKeyInfo struct {
+	Name string   `json:"name"`
+	Type string   `json:"type"`
+	Enum []string `json:"enum,omitempty"`
+	// Pattern, Minimum and Maximum constrain values as in KeyDef.
+	Pattern string   `json:"pattern,omitempty"`
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+	// Default is the value the effective view shows when the key is unset:
+	// the operator default if there is one, else the schema's.
+	Default     any    `json:"default,omitempty"`
+	Description string `json:"description,omitempty"`
+	Deprecated  bool   `json:"deprecated,omitempty"`
+	ReplacedBy  string `json:"replacedBy,omitempty"`
+}
+
+// PreferenceKeysResponse is returned by GET /preference-keys.
+type PreferenceKeysResponse struct {
+	Keys []PreferenceKeyInfo `json:"keys"`
+}
+
+// Catalog lists the keys the preference schema declares, in schema order,
+// so clients can build settings screens from it. Any authenticated caller
+// may read it; it is empty when no schema is loaded. The ETag is a hash of
+// the body, since the schema and defaults change apart from any record.
+func (h *PreferencesHandler) Catalog(w http.ResponseWriter, r *http.Request) {
+	resp := PreferenceKeysResponse{Keys: []PreferenceKeyInfo{}}
+	defaults, _ := h.opts.Defaults.Get()
+	if schema := h.opts.Schema.Get(); schema != nil {
+		for _, k := range schema.Keys {
+			info := PreferenceKeyInfo{
+				Name:        k.Name,
+				Type:        k.Type,
+				Enum:        k.Enum,
+				Pattern:     k.Pattern,
+				Minimum:     k.Minimum,
+				Maximum:     k.Maximum,
+				Description: k.Description,
+				Deprecated:  k.Deprecated,
+				ReplacedBy:  k.ReplacedBy,
+			}
+			if v, ok := defaults[k.Name]; ok {
+				info.Default = v
+			} else if k.Default != "" {
+				info.Default = defaultValue(k)
+			}
+			resp.Keys = append(resp.Keys, info)
+		}
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		h.logger.Error("catalog encoding failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list preference keys")
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && noneMatchHits(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	registry := NewSchemaRegistry(staticSchema{Keys: []KeyDef{
+		{Name: "theme", Type: TypeString, Enum: []string{"light", "dark"}, Default: "light", Description: "Colour theme"},
+		{Name: "items_per_page", Type: TypeInteger, Default: "20"},
+		{Name: "color_scheme", Type: TypeString, ReplacedBy: "theme"},
+	}}, 0, testLogger())
+	if err := registry.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defaults := NewDefaults(staticDefaults{"items_per_page": 25}, 0, testLogger())
+	defaults.Refresh(context.Background())
+	h := NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{Schema: registry, Defaults: defaults})
+
+	get := func(inm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/preference-keys", nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		w := httptest.NewRecorder()
+		h.Catalog(w, withClaims(req, "user1"))
+		return w
+	}
+
+	w := get("")
+	var resp PreferenceKeysResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Keys) != 3 {
+		t.Fatalf("unexpected response %d %+v", w.Code, resp)
+	}
+	if k := resp.Keys[0]; k.Name != "theme" || k.Default != "light" || len(k.Enum) != 2 || k.Description != "Colour theme" {
+		t.Fatalf("unexpected theme entry %+v", k)
+	}
+	// The operator default wins over the schema's.
+	if k := resp.Keys[1]; k.Type != TypeInteger || k.Default != float64(25) {
+		t.Fatalf("unexpected items_per_page entry %+v", k)
+	}
+	if k := resp.Keys[2]; !k.Deprecated || k.ReplacedBy != "theme" || k.Default != nil {
+		t.Fatalf("unexpected color_scheme entry %+v", k)
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag")
+	}
+	if w := get(etag); w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", w.Code)
+	}
+
+	// Without a schema the catalog is empty.
+	w = httptest.NewRecorder()
+	NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{}).Catalog(w, httptest.NewRequest("GET", "/api/v1/preference-keys", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "{\"keys\":[]}\n" {
+		t.Fatalf("expected an empty catalog, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ChangeEvent describes one write that changed a user's preferences.
+type ChangeEvent struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	UserID  string    `json:"userId"`
+	Version int64     `json:"version"`
+	At      time.Time `json:"at"`
+	By      string    `json:"by,omitempty"`
+	// Changes maps each changed key to its new value, or null when it was
+	// removed, as in a JSON Merge Patch.
+	Changes map[string]any `json:"changes"`
+	// Correction is the request a correction event is about.
+	Correction *CorrectionRequest `json:"correction,omitempty"`
+
+	// Previous holds the earlier values of changed keys that were set, for
+	// sinks that report them; Sensitive lists changed sensitive keys, whose
+	// values are never published. Neither is part of the event's JSON.
+	Previous  map[string]any `json:"-"`
+	Sensitive []string       `json:"-"`
+}
+
+// ChangeSink receives change events. PublishChange must not block the
+// write for long; sinks that deliver over the network do so in the
+// background. Listening reports whether the sink wants events at all, so
+// writes can skip reading the map beforehand when none does.
+type ChangeSink interface {
+	Listening() bool
+	PublishChange(ctx context.Context, e ChangeEvent)
+}
+
+// sensitiveSink is implemented by sinks that report changes to sensitive
+// keys (by name only); other sinks are not sent events changing nothing
+// but sensitive keys.
+type sensitiveSink interface {
+	ReportsSensitive() bool
+}
+
+// reportsSensitive reports whether v wants events for sensitive keys.
+func reportsSensitive(v any) bool {
+	s, ok := v.(sensitiveSink)
+	return ok && s.ReportsSensitive()
+}
+
+// ChangePublisher is a Store decorator that publishes a ChangeEvent to its
+// sinks for every write that changed something. Like HistoryRecorder it
+// reads the affected keys before writing. Values of excluded (sensitive)
+// keys are never published.
+type ChangePublisher struct {
+	next    Store
+	sinks   []ChangeSink
+	exclude []string
+	logger  *slog.Logger
+}
+
+// NewChangePublisher wraps next, publishing its changes to sinks.
+func NewChangePublisher(next Store, exclude []string, logger *slog.Logger, sinks ...ChangeSink) *ChangePublisher {
+	return &ChangePublisher{next: next, sinks: sinks, exclude: exclude, logger: logger}
+}
+
+func (s *ChangePublisher) GetAll(ctx context.Context, userID string) (Record, error) {
+	return s.next.GetAll(ctx, userID)
+}
+
+func (s *ChangePublisher) Get(ctx context.Context, userID string, key string) (any, bool, Record, error) {
+	return s.next.Get(ctx, userID, key)
+}
+
+func (s *ChangePublisher) GetKeys(ctx context.Context, userID string, keys []string) (Record, error) {
+	return s.next.GetKeys(ctx, userID, keys)
+}
+
+func (s *ChangePublisher) Stat(ctx context.Context, userID string, key string) (Record, bool, error) {
+	return s.next.Stat(ctx, userID, key)
+}
+
+func (s *ChangePublisher) BatchGet(ctx context.Context, userIDs []string) (map[string]Record, error) {
+	return s.next.BatchGet(ctx, userIDs)
+}
+
+func (s *ChangePublisher) ReplaceAll(ctx context.Context, userID string, prefs map[string]any, cond Precondition) (Record, error) {
+	if !s.listening() {
+		return s.next.ReplaceAll(ctx, userID, prefs, cond)
+	}
+	before, err := s.next.GetAll(ctx, userID)
+	if err != nil {
+		return Record{}, err
+	}
+	rec, err := s.next.ReplaceAll(ctx, userID, prefs, cond)
+	if err != nil {
+		return Record{}, err
+	}
+	s.publish(ctx, userID, EventPreferencesUpdated, before.Prefs, rec.Prefs, rec.Version)
+	return rec, nil
+}
+
+func (s *ChangePublisher) Update(ctx context.Context, userID string, prefs map[string]any, remove []string, cond Precondition) (Record, error) {
+	if !s.listening() {
+		return s.next.Update(ctx, userID, prefs, remove, cond)
+	}
+	keys := append(slices.Collect(maps.Keys(prefs)), remove...)
+	slices.Sort(keys)
+	before, err := s.next.GetKeys(ctx, userID, slices.Compact(keys))
+	if err != nil {
+		return Record{}, err
+	}
+	rec, err := s.next.Update(ctx, userID, prefs, remove, cond)
+	if err != nil {
+		return Record{}, err
+	}
+	old := maps.Clone(rec.Prefs)
+	for _, k := range keys {
+		delete(old, k)
+	}
+	maps.Copy(old, before.Prefs)
+	s.publish(ctx, userID, EventPreferencesUpdated, old, rec.Prefs, rec.Version)
+	return rec, nil
+}
+
+func (s *ChangePublisher) DeleteAll(ctx context.Context, userID string) error {
+	if !s.listening() {
+		return s.next.DeleteAll(ctx, userID)
+	}
+	before, err := s.next.GetAll(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.next.DeleteAll(ctx, userID); err != nil {
+		return err
+	}
+	s.publish(ctx, userID, EventPreferencesDeleted, before.Prefs, nil, 0)
+	return nil
+}
+
+func (s *ChangePublisher) Delete(ctx context.Context, userID string, key string) error {
+	if !s.listening() {
+		return s.next.Delete(ctx, userID, key)
+	}
+	before, err := s.next.GetKeys(ctx, userID, []string{key})
+	if err != nil {
+		return err
+	}
+	if err := s.next.Delete(ctx, userID, key); err != nil {
+		return err
+	}
+	s.publish(ctx, userID, EventPreferencesUpdated, before.Prefs, nil, before.Version+1)
+	return nil
+}
+
+func (s *ChangePublisher) listening() bool {
+	return slices.ContainsFunc(s.sinks, ChangeSink.Listening)
+}
+
+// publish sends the event for a write that took the map from old to cur.
+// Writes that changed nothing are not published.
+func (s *ChangePublisher) publish(ctx context.Context, userID, typ string, old, cur map[string]any, version int64) {
+	e, ok := newChangeEvent(typ, userID, old, cur, s.exclude)
+	if !ok {
+		return
+	}
+	e.ID, e.Version, e.At, e.By = newID(), version, time.Now().UTC(), writerFrom(ctx)
+	// The request may finish before the sinks are done with the event.
+	publishChange(context.WithoutCancel(ctx), s.sinks, e)
+}
+
+// newChangeEvent builds the event, less ID, version, time and writer, for a
+// change of userID's map from old to cur, reporting false if no key changed.
+// exclude lists the sensitive keys, whose values are left out.
+func newChangeEvent(typ, userID string, old, cur map[string]any, exclude []string) (ChangeEvent, bool) {
+	before, after := changes(old, cur)
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			after[k] = nil
+		}
+	}
+	var sensitive []string
+	for _, k := range exclude {
+		if _, ok := after[k]; ok {
+			sensitive = append(sensitive, k)
+		}
+		delete(before, k)
+		delete(after, k)
+	}
+	if len(after) == 0 && len(sensitive) == 0 {
+		return ChangeEvent{}, false
+	}
+	return ChangeEvent{Type: typ, UserID: userID, Changes: after, Previous: before, Sensitive: sensitive}, true
+}
+
+// publishChange hands e to the listening sinks. Events changing only
+// sensitive keys go only to sinks reporting them.
+func publishChange(ctx context.Context, sinks []ChangeSink, e ChangeEvent) {
+	for _, sink := range sinks {
+		if sink.Listening() && (len(e.Changes) > 0 || reportsSensitive(sink)) {
+			sink.PublishChange(ctx, e)
+		}
+	}
+}
+
+// keyMatches reports whether a key filter selects key. Each filter names a
+// key or, ending in ".", a namespace; an empty filter selects every key.
+func keyMatches(filter []string, key string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(filter, func(f string) bool {
+		return f == key || (strings.HasSuffix(f, ".") && strings.HasPrefix(key, f))
+	})
+}
+
+// filterChanges returns the changes a key filter selects.
+func filterChanges(filter []string, changes map[string]any) map[string]any {
+	if len(filter) == 0 {
+		return changes
+	}
+	out := make(map[string]any)
+	for k, v := range changes {
+		if keyMatches(filter, k) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// parseKeyFilter validates a key filter, normalizing "ns.*" to "ns.".
+func parseKeyFilter(keys []string) ([]string, error) {
+	var filter []string
+	for _, k := range keys {
+		k = strings.TrimSuffix(k, "*")
+		if reason := keyViolation(strings.TrimSuffix(k, "."), nil); reason != "" {
+			return nil, fmt.Errorf("invalid key %q: %s", k, reason)
+		}
+		filter = append(filter, k)
+	}
+	return filter, nil
+}
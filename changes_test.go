@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordingSink collects published change events.
+type recordingSink struct {
+	events []ChangeEvent
+}
+
+func (s *recordingSink) Listening() bool { return true }
+
+func (s *recordingSink) PublishChange(_ context.Context, e ChangeEvent) {
+	s.events = append(s.events, e)
+}
+
+func TestChangePublisher(t *testing.T) {
+	sink := &recordingSink{}
+	store := NewChangePublisher(newMockStore(), []string{"ssn"}, testLogger(), sink)
+	ctx := context.Background()
+
+	store.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark", "lang": "en", "ssn": "123"}, Precondition{})
+	store.Update(ctx, "user1", map[string]any{"theme": "light", "lang": "en"}, []string{"ssn"}, Precondition{})
+	store.Update(ctx, "user1", map[string]any{"lang": "en"}, nil, Precondition{})
+	store.Delete(ctx, "user1", "lang")
+	store.DeleteAll(ctx, "user1")
+
+	if len(sink.events) != 4 {
+		t.Fatalf("expected an event per changing write, got %+v", sink.events)
+	}
+	if e := sink.events[0]; e.UserID != "user1" || e.Type != EventPreferencesUpdated || len(e.Changes) != 2 || e.Changes["theme"] != "dark" {
+		t.Fatalf("unexpected replace event %+v", e)
+	}
+	if e := sink.events[1]; len(e.Changes) != 1 || e.Changes["theme"] != "light" {
+		t.Fatalf("expected only the changed, non-sensitive key, got %+v", e)
+	}
+	if v, ok := sink.events[2].Changes["lang"]; !ok || v != nil {
+		t.Fatalf("expected the deleted key as null, got %+v", sink.events[2])
+	}
+	if e := sink.events[3]; e.Type != EventPreferencesDeleted || len(e.Changes) != 1 || e.Changes["theme"] != nil {
+		t.Fatalf("unexpected delete event %+v", e)
+	}
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	received := make(chan ChangeEvent, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ce CloudEvent
+		json.NewDecoder(r.Body).Decode(&ce)
+		e := ce.Data
+		if r.Header.Get("Content-Type") != cloudEventsContentType || ce.SpecVersion != "1.0" || ce.Type != e.Type || ce.Subject != e.UserID {
+			t.Errorf("expected a CloudEvent, got %+v", ce)
+		}
+		if r.Header.Get("X-Webhook-Event") != e.Type {
+			t.Errorf("expected the event type header, got %q", r.Header.Get("X-Webhook-Event"))
+		}
+		received <- e
+	}))
+	defer srv.Close()
+
+	hooks := newMockWebhookStore()
+	hooks.items["a"] = Webhook{ID: "a", URL: srv.URL, Keys: []string{"notifications."}}
+	hooks.items["b"] = Webhook{ID: "b", URL: srv.URL, Events: []string{EventPreferencesDeleted}}
+	d := NewWebhookDispatcher(hooks, 0, "user-prefs", testLogger())
+	d.client = srv.Client()
+	if d.Listening() {
+		t.Fatal("expected no subscriptions before Refresh")
+	}
+	if err := d.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	d.PublishChange(context.Background(), ChangeEvent{ID: "1", Type: EventPreferencesUpdated, UserID: "user1",
+		Changes: map[string]any{"theme": "dark", "notifications.email": false}})
+	select {
+	case e := <-received:
+		if len(e.Changes) != 1 || e.Changes["notifications.email"] != false {
+			t.Fatalf("expected only the namespace's keys, got %+v", e.Changes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a delivery")
+	}
+
+	// Neither subscription wants this one.
+	d.PublishChange(context.Background(), ChangeEvent{ID: "2", Type: EventPreferencesUpdated, UserID: "user1",
+		Changes: map[string]any{"theme": "light"}})
+	select {
+	case e := <-received:
+		t.Fatalf("unexpected delivery %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestKeyMatches(t *testing.T) {
+	for _, tc := range []struct {
+		filter []string
+		key    string
+		want   bool
+	}{
+		{nil, "theme", true},
+		{[]string{"theme"}, "theme", true},
+		{[]string{"theme"}, "theme.dark", false},
+		{[]string{"notifications."}, "notifications.email", true},
+		{[]string{"notifications."}, "notifications", false},
+	} {
+		if got := keyMatches(tc.filter, tc.key); got != tc.want {
+			t.Errorf("keyMatches(%v, %q) = %v, want %v", tc.filter, tc.key, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+//go:generate go run . gen client -out clients/ts/src/index.ts
+
+// oaSchema, oaOperation and oaDocument are the parts of an OpenAPI document
+// genClient reads.
+type oaSchema struct {
+	Ref                  string               `json:"$ref"`
+	Type                 string               `json:"type"`
+	Items                *oaSchema            `json:"items"`
+	Properties           map[string]*oaSchema `json:"properties"`
+	AdditionalProperties *oaSchema            `json:"additionalProperties"`
+	Required             []string             `json:"required"`
+}
+
+type oaContent map[string]struct {
+	Schema *oaSchema `json:"schema"`
+}
+
+type oaOperation struct {
+	OperationID string `json:"operationId"`
+	Summary     string `json:"summary"`
+	Parameters  []struct {
+		Name string `json:"name"`
+		In   string `json:"in"`
+	} `json:"parameters"`
+	RequestBody *struct {
+		Content oaContent `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content oaContent `json:"content"`
+	} `json:"responses"`
+}
+
+type oaDocument struct {
+	Paths      map[string]map[string]*oaOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*oaSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// clientMethods orders a path's operations in the generated client.
+var clientMethods = []string{"get", "put", "post", "patch", "delete"}
+
+// genClient renders a TypeScript client for every operation in an OpenAPI
+// document (buildOpenAPI's, unless given another): an interface per
+// component schema, and a fetch-based client with a method per operation,
+// named by its operationId and typed by its JSON request and success
+// response. Non-2xx responses are thrown as UserPrefsError. Unlike genTS,
+// it does not depend on the preference schema, so web and mobile teams
+// can share one published package.
+func genClient(spec []byte) ([]byte, error) {
+	var doc oaDocument
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI document: %w", err)
+	}
+	if doc.Components.Schemas["APIError"] == nil || doc.Components.Schemas["Problem"] == nil {
+		return nil, errors.New("the document has no APIError or Problem schema")
+	}
+
+	var b strings.Builder
+	b.WriteString("// Code generated by user-prefs gen client; DO NOT EDIT.\n\n")
+	b.WriteString("/** Any JSON value. */\nexport type JsonValue = string | number | boolean | null | JsonValue[] | { [key: string]: JsonValue };\n")
+
+	for _, name := range slices.Sorted(maps.Keys(doc.Components.Schemas)) {
+		fmt.Fprintf(&b, "\nexport interface %s %s\n", name, tsObject(doc.Components.Schemas[name], ""))
+	}
+
+	b.WriteString(clientPrologue)
+	for _, path := range slices.Sorted(maps.Keys(doc.Paths)) {
+		for _, method := range clientMethods {
+			op := doc.Paths[path][method]
+			if op == nil {
+				continue
+			}
+			if streams(op) {
+				continue
+			}
+			if op.OperationID == "" {
+				return nil, fmt.Errorf("%s %s has no operationId", strings.ToUpper(method), path)
+			}
+			writeClientMethod(&b, method, path, op)
+		}
+	}
+	b.WriteString(clientEpilogue)
+	return []byte(b.String()), nil
+}
+
+// streams reports whether an operation answers with a stream, Server-Sent
+// Events or a WebSocket upgrade, which a request-response client cannot
+// serve.
+func streams(op *oaOperation) bool {
+	for status, resp := range op.Responses {
+		if status == "101" {
+			return true
+		}
+		if strings.HasPrefix(status, "2") && resp.Content["text/event-stream"].Schema != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func writeClientMethod(b *strings.Builder, method, path string, op *oaOperation) {
+	var params, query []string
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "path":
+			params = append(params, p.Name+": string")
+		case "query":
+			query = append(query, tsProperty(p.Name)+"?: string")
+		}
+	}
+	body := "undefined"
+	if op.RequestBody != nil {
+		// DELETE bodies are optional (DELETE /preferences without one
+		// deletes every key).
+		if method == "delete" {
+			params = append(params, "body?: "+contentType(op.RequestBody.Content))
+		} else {
+			params = append(params, "body: "+contentType(op.RequestBody.Content))
+		}
+		body = "body"
+	}
+	queryArg := "undefined"
+	if len(query) > 0 {
+		params = append(params, "query?: { "+strings.Join(query, "; ")+" }")
+		queryArg = "query"
+	}
+	params = append(params, "options?: RequestOptions")
+
+	result := "void"
+	for status, resp := range op.Responses {
+		if strings.HasPrefix(status, "2") && resp.Content["application/json"].Schema != nil {
+			result = contentType(resp.Content)
+		}
+	}
+
+	urlPath := pathParam.ReplaceAllStringFunc(path, func(p string) string {
+		return "${encodeURIComponent(" + strings.Trim(p, "{}") + ")}"
+	})
+	fmt.Fprintf(b, "\n  /**\n   * %s.\n   * %s %s\n   */\n", strings.ReplaceAll(op.Summary, "*/", "*\\/"), strings.ToUpper(method), path)
+	fmt.Fprintf(b, "  %s(%s): Promise<%s> {\n", op.OperationID, strings.Join(params, ", "), result)
+	fmt.Fprintf(b, "    return this.request(%q, `%s`, %s, %s, options);\n  }\n", strings.ToUpper(method), urlPath, body, queryArg)
+}
+
+// contentType is the TypeScript type of a JSON request or response body.
+func contentType(c oaContent) string {
+	if s := c["application/json"].Schema; s != nil {
+		return tsSchemaType(s, "  ")
+	}
+	return "unknown"
+}
+
+func tsSchemaType(s *oaSchema, indent string) string {
+	switch {
+	case s.Ref != "":
+		return s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+	case s.Type == "string":
+		return "string"
+	case s.Type == "integer", s.Type == "number":
+		return "number"
+	case s.Type == "boolean":
+		return "boolean"
+	case s.Type == "array" && s.Items != nil:
+		elem := tsSchemaType(s.Items, indent)
+		if strings.ContainsAny(elem, " |") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case s.Type == "object" && s.Properties != nil:
+		return tsObject(s, indent)
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		return "Record<string, " + tsSchemaType(s.AdditionalProperties, indent) + ">"
+	case s.Type == "object":
+		return "Record<string, JsonValue>"
+	}
+	return "JsonValue"
+}
+
+// tsObject renders an object schema's properties, required ones without
+// the optional marker.
+func tsObject(s *oaSchema, indent string) string {
+	if len(s.Properties) == 0 {
+		return "{}"
+	}
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, name := range slices.Sorted(maps.Keys(s.Properties)) {
+		opt := "?"
+		if slices.Contains(s.Required, name) {
+			opt = ""
+		}
+		fmt.Fprintf(&b, "%s  %s%s: %s;\n", indent, tsProperty(name), opt, tsSchemaType(s.Properties[name], indent+"  "))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func tsProperty(name string) string {
+	if tsIdentifier.MatchString(name) {
+		return name
+	}
+	return tsString(name)
+}
+
+const clientPrologue = `
+/** Thrown for any non-2xx response, with its error body when it has one. */
+export class UserPrefsError extends Error {
+  readonly status: number;
+  readonly body: APIError | Problem | undefined;
+
+  constructor(status: number, body: APIError | Problem | undefined) {
+    super(errorMessage(status, body));
+    this.name = "UserPrefsError";
+    this.status = status;
+    this.body = body;
+  }
+
+  /** The error's stable code, as documented in docs/errors.md. */
+  get errorCode(): string | undefined {
+    if (!this.body) return undefined;
+    return "errorCode" in this.body ? this.body.errorCode : this.body.code;
+  }
+
+  /** Each reason a write was rejected (422). */
+  get violations(): Violation[] {
+    return this.body?.violations ?? [];
+  }
+}
+
+function errorMessage(status: number, body: APIError | Problem | undefined): string {
+  if (!body) return ` + "`request failed with status ${status}`" + `;
+  return "error" in body ? body.error : body.detail ?? body.title;
+}
+
+export interface ClientOptions {
+  /** Base URL of the service, e.g. "https://prefs.example.com". */
+  baseUrl: string;
+  /** Returns a bearer token; omit when authenticating with a cookie. */
+  token?: () => string | Promise<string>;
+  /** Sent with every request, e.g. { Authorization: "ApiKey <key>" } for the admin API. */
+  headers?: Record<string, string>;
+  fetch?: typeof fetch;
+}
+
+export interface RequestOptions {
+  /** Sent with this request, e.g. If-Match or Idempotency-Key. */
+  headers?: Record<string, string>;
+  signal?: AbortSignal;
+}
+
+type Query = Record<string, string | undefined>;
+
+/** Client for every route of the user-prefs API; methods are named by operationId. */
+export class UserPrefsClient {
+  private readonly opts: ClientOptions;
+  private readonly fetchImpl: typeof fetch;
+
+  constructor(opts: ClientOptions) {
+    this.opts = opts;
+    this.fetchImpl = opts.fetch ?? fetch.bind(globalThis);
+  }
+`
+
+const clientEpilogue = `
+  private async request<T>(method: string, path: string, body: unknown, query: Query | undefined, options: RequestOptions | undefined): Promise<T> {
+    const headers: Record<string, string> = { Accept: "application/json", ...this.opts.headers, ...options?.headers };
+    if (body !== undefined) headers["Content-Type"] = "application/json";
+    if (this.opts.token) headers.Authorization = ` + "`Bearer ${await this.opts.token()}`" + `;
+
+    let url = this.opts.baseUrl + path;
+    const params = new URLSearchParams();
+    for (const [k, v] of Object.entries(query ?? {})) {
+      if (v !== undefined) params.set(k, v);
+    }
+    if (params.toString() !== "") url += "?" + params.toString();
+
+    const res = await this.fetchImpl(url, {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+      credentials: "include",
+      signal: options?.signal,
+    });
+
+    if (!res.ok) {
+      const err = (await res.json().catch(() => undefined)) as APIError | Problem | undefined;
+      throw new UserPrefsError(res.status, err);
+    }
+    if (res.status === 204) return undefined as T;
+    return (await res.json()) as T;
+  }
+}
+`
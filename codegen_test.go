@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testSchema() *PreferenceSchema {
+	return &PreferenceSchema{Keys: []KeyDef{
+		{Name: "theme", Type: TypeString, Enum: []string{"light", "dark"}, Default: "light"},
+		{Name: "items_per_page", Type: TypeInteger, Default: "25"},
+		{Name: "notifications.email", Type: TypeBoolean},
+	}}
+}
+
+func TestGenGo(t *testing.T) {
+	src, err := genGo(testSchema(), "prefkeys")
+	if err != nil {
+		t.Fatalf("genGo: %v", err)
+	}
+
+	if _, err := parser.ParseFile(token.NewFileSet(), "prefkeys.go", src, 0); err != nil {
+		t.Fatalf("generated code does not parse: %v\n%s", err, src)
+	}
+
+	for _, want := range []string{
+		`KeyTheme              Key = "theme"`,
+		`ThemeDark  ThemeValue = "dark"`,
+		`func GetItemsPerPage(prefs map[string]any) int64 {`,
+		`prefs[string(KeyItemsPerPage)].(json.Number)`,
+		`return 25`,
+		`func SetNotificationsEmail(prefs map[string]any, v bool) {`,
+		`if v, ok := prefs[string(KeyNotificationsEmail)].(bool); ok {`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestGoIdent(t *testing.T) {
+	cases := map[string]string{
+		"theme":               "Theme",
+		"items_per_page":      "ItemsPerPage",
+		"notifications.email": "NotificationsEmail",
+		"dark-blue":           "DarkBlue",
+		"24h":                 "X24h",
+	}
+	for in, want := range cases {
+		if got := goIdent(in); got != want {
+			t.Errorf("goIdent(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestGenTS(t *testing.T) {
+	src, err := genTS(testSchema())
+	if err != nil {
+		t.Fatalf("genTS: %v", err)
+	}
+
+	for _, want := range []string{
+		`| "notifications.email";`,
+		`export type ThemeValue = "light" | "dark";`,
+		`"theme"?: ThemeValue;`,
+		`"items_per_page"?: number;`,
+		`"notifications.email"?: boolean;`,
+		`export const preferenceSchema = [`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated TypeScript missing %q", want)
+		}
+	}
+	if strings.Contains(string(src), "fetch(") {
+		t.Error("generated TypeScript includes a fetch client; API calls belong to clients/ts")
+	}
+}
+
+func TestGenClient(t *testing.T) {
+	spec, _ := json.Marshal(buildOpenAPI())
+	src, err := genClient(spec)
+	if err != nil {
+		t.Fatalf("genClient: %v", err)
+	}
+
+	for _, want := range []string{
+		"export interface BatchGetRequest {\n  userIds: string[];\n}",
+		"  createdAt?: string;\n",
+		"putUsersPreferencesByKey(userId: string, key: string, body: SinglePrefRequest, options?: RequestOptions): Promise<SinglePrefResponse> {",
+		"`/api/v1/users/${encodeURIComponent(userId)}/preferences/${encodeURIComponent(key)}`",
+		"getAdminCorrections(query?: { userId?: string; status?: string }, options?: RequestOptions): Promise<CorrectionsResponse> {",
+		"deleteUsersPreferencesByKey(userId: string, key: string, options?: RequestOptions): Promise<void> {",
+		"export class UserPrefsError extends Error {",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated client missing %q", want)
+		}
+	}
+	if strings.Contains(string(src), "getUsersPreferencesStream(") {
+		t.Error("expected streaming routes left out")
+	}
+
+	if _, err := genClient([]byte(`{"paths":{}}`)); err == nil {
+		t.Error("expected a document without error schemas rejected")
+	}
+}
+
+// TestGenClient_UpToDate fails when the checked-in TypeScript client no
+// longer matches the OpenAPI document; go generate ./... updates it.
+func TestGenClient_UpToDate(t *testing.T) {
+	spec, _ := json.Marshal(buildOpenAPI())
+	src, err := genClient(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkedIn, err := os.ReadFile("clients/ts/src/index.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, checkedIn) {
+		t.Fatal("clients/ts/src/index.ts is out of date; run go generate ./...")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// genTS renders TypeScript types for the keys in schema, so web settings UIs
+// share the server's key names and allowed values. It emits types only; API
+// calls go through the generated client (genClient), whose preference maps
+// callers narrow to Preferences.
+func genTS(schema *PreferenceSchema) ([]byte, error) {
+	var b strings.Builder
+
+	b.WriteString("// Code generated by user-prefs gen ts; DO NOT EDIT.\n\n")
+
+	b.WriteString("/** A registered preference key. */\nexport type PreferenceKey =\n")
+	if len(schema.Keys) == 0 {
+		b.WriteString("  never;\n")
+	}
+	for i, k := range schema.Keys {
+		sep := "\n"
+		if i == len(schema.Keys)-1 {
+			sep = ";\n"
+		}
+		fmt.Fprintf(&b, "  | %s%s", tsString(k.Name), sep)
+	}
+
+	for _, k := range schema.Keys {
+		if len(k.Enum) == 0 {
+			continue
+		}
+		vals := make([]string, len(k.Enum))
+		for i, v := range k.Enum {
+			vals[i] = tsString(v)
+		}
+		fmt.Fprintf(&b, "\n/** Allowed values of the %s preference. */\nexport type %sValue = %s;\n", k.Name, goIdent(k.Name), strings.Join(vals, " | "))
+	}
+
+	b.WriteString("\n/** Registered preferences as sent on the wire. */\nexport interface KnownPreferences {\n")
+	for _, k := range schema.Keys {
+		if k.Description != "" || k.Deprecated {
+			b.WriteString("  /**")
+			if k.Description != "" {
+				b.WriteString(" " + k.Description)
+			}
+			if k.Deprecated {
+				b.WriteString(" @deprecated")
+			}
+			if k.ReplacedBy != "" {
+				b.WriteString(" Use " + tsString(k.ReplacedBy) + ".")
+			}
+			b.WriteString(" */\n")
+		}
+		fmt.Fprintf(&b, "  %s?: %s;\n", tsString(k.Name), tsType(k))
+	}
+	b.WriteString("}\n\n/** Any JSON value; ad-hoc keys may hold typed values. */\nexport type PreferenceValue = string | number | boolean | null | PreferenceValue[] | { [key: string]: PreferenceValue };\n")
+	b.WriteString("\n/** A user's preference map: registered keys plus any ad-hoc keys. */\nexport type Preferences = KnownPreferences & Record<string, PreferenceValue>;\n")
+
+	defs, err := json.MarshalIndent(schema.Keys, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding schema: %w", err)
+	}
+	b.WriteString("\n/** Key definitions for building settings UIs and client-side validation. */\n")
+	fmt.Fprintf(&b, "export const preferenceSchema = %s as const;\n", defs)
+	return []byte(b.String()), nil
+}
+
+// tsType is the wire type of a key, matching the JSON types KeyDef.check
+// accepts.
+func tsType(k KeyDef) string {
+	switch {
+	case len(k.Enum) > 0:
+		return goIdent(k.Name) + "Value"
+	case k.Type == TypeBoolean:
+		return "boolean"
+	case k.Type == TypeInteger, k.Type == TypeNumber:
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+func tsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: user-prefs [command]
+
+Commands:
+  serve            run the HTTP API (default)
+  stream-worker    publish change events from the table's DynamoDB stream and replicate the standby
+  bootstrap        plan and apply the DynamoDB tables, streams and TTL (see bootstrap -h)
+  export-all       snapshot all users' preferences to S3 as partitioned JSONL
+  backup           dump the whole table to a file (resumable, rate-limited)
+  restore          load a backup file into a table
+  reindex          backfill secondary index keys on items written before the index
+  resync-standby   copy every user's preferences to the standby table and clear its divergence
+  ctl              support CLI for any user's preferences (prefsctl; see ctl -h)
+  gen go           generate a Go package of typed preference keys
+  gen ts           generate TypeScript types for the preference schema
+  gen client       generate the TypeScript API client from the OpenAPI document
+`
+
+// runCommand dispatches CLI subcommands and returns the process exit code.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	switch args[0] {
+	case "gen":
+		return runGen(args[1:], stdout, stderr)
+	case "stream-worker":
+		return runStreamWorker(args[1:], stdout, stderr)
+	case "bootstrap":
+		return runBootstrap(args[1:], stdout, stderr)
+	case "export-all":
+		return runExportAll(args[1:], stdout, stderr)
+	case "backup":
+		return runBackup(false, args[1:], stdout, stderr)
+	case "restore":
+		return runBackup(true, args[1:], stdout, stderr)
+	case "reindex":
+		return runReindex(args[1:], stdout, stderr)
+	case "resync-standby":
+		return runResyncStandby(args[1:], stdout, stderr)
+	case "ctl":
+		return runCtl(args[1:], os.Stdin, stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+// runGen implements "user-prefs gen <target>".
+func runGen(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	target := args[0]
+
+	fs := flag.NewFlagSet("gen "+target, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	schemaPath := fs.String("schema", os.Getenv("PREFERENCE_SCHEMA_FILE"), "path to the preference schema file")
+	out := fs.String("out", "", "output file (default stdout)")
+	pkg := fs.String("package", "prefkeys", "package name for generated Go code (go only)")
+	specPath := fs.String("spec", "", "OpenAPI document to generate from (client only; default this build's)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if target == "client" {
+		spec, err := json.Marshal(buildOpenAPI())
+		if *specPath != "" {
+			spec, err = os.ReadFile(*specPath)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "gen: %v\n", err)
+			return 1
+		}
+		src, err := genClient(spec)
+		if err != nil {
+			fmt.Fprintf(stderr, "gen client: %v\n", err)
+			return 1
+		}
+		return writeGenerated(src, *out, stdout, stderr)
+	}
+
+	if *schemaPath == "" {
+		fmt.Fprintln(stderr, "gen: -schema or PREFERENCE_SCHEMA_FILE is required")
+		return 2
+	}
+	schema, err := LoadSchema(*schemaPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "gen: %v\n", err)
+		return 1
+	}
+
+	var src []byte
+	switch target {
+	case "go":
+		src, err = genGo(schema, *pkg)
+	case "ts":
+		src, err = genTS(schema)
+	default:
+		fmt.Fprintf(stderr, "gen: unknown target %q\n", target)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "gen %s: %v\n", target, err)
+		return 1
+	}
+
+	return writeGenerated(src, *out, stdout, stderr)
+}
+
+// writeGenerated writes generated source to out, or stdout when out is
+// empty.
+func writeGenerated(src []byte, out string, stdout, stderr io.Writer) int {
+	if out == "" {
+		stdout.Write(src)
+		return 0
+	}
+	if err := os.WriteFile(out, src, 0o644); err != nil {
+		fmt.Fprintf(stderr, "gen: writing %s: %v\n", out, err)
+		return 1
+	}
+	return 0
+}
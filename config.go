@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto"
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -12,17 +18,290 @@ type Config struct {
 	DynamoEndpoint  string
 	DynamoTableName string
 	JWTSecret       string
+	JWTAlgorithms   []string
+	JWTPublicKeys   []crypto.PublicKey
+	JWKSURL         string
+	JWKSRefresh     time.Duration
+	// JWKS caches keys from JWKSURL; main sets it up when JWKSURL is set.
+	JWKS            *JWKSCache
 	JWTIssuer       string
+	JWTAudiences    AudiencePolicy
+	JWTCookieName   string
+	CSRFCookieName  string
 	AWSRegion       string
 	CORSAllowOrigin string
 	LogLevel        slog.Level
 	DevBypassAuth   bool
+	DevBypassNets   []netip.Prefix
+
+	// CanonicalJSON writes every JSON response in canonical form (RFC
+	// 8785), not only those requested with ?canonical=true.
+	CanonicalJSON bool
+
+	// JWTPreflight controls the startup auth check: "off", "warn", or
+	// "strict" (refuse to start on failure).
+	JWTPreflight      string
+	JWTPreflightToken string
+
+	// JWTAllowedActors lists agents that may present delegated ("act")
+	// tokens on behalf of users; "*" allows any.
+	JWTAllowedActors []string
+
+	// AuthMode selects how callers authenticate: "jwt", "mtls", or
+	// "introspection".
+	AuthMode          string
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSClientCAFile   string
+	MTLSAllowedIdents []string
+	MTLSScopes        []string
+
+	IntrospectionURL          string
+	IntrospectionClientID     string
+	IntrospectionClientSecret string
+	IntrospectionCacheTTL     time.Duration
+
+	// SigV4 (AWS IAM) authentication is layered in front of AuthMode when
+	// at least one principal is allowed.
+	SigV4AllowedPrincipals []string
+	SigV4Scopes            []string
+	SigV4Audience          string
+
+	// Standby store for read failover; enabled when StandbyTableName is set.
+	StandbyTableName  string
+	StandbyEndpoint   string
+	StandbyRegion     string
+	FailoverAuto      bool
+	FailoverThreshold int
+	FailoverRetry     time.Duration
+
+	// Global load limits; zero disables each.
+	RateLimitRPS   float64
+	RateLimitBurst int
+	MaxInFlight    int
+	ShedLatency    time.Duration
+
+	// ConcealForbidden reports cross-user access as 404 rather than 403.
+	ConcealForbidden bool
+
+	// RequireIfMatch rejects unconditional PUT/POST/PATCH writes and
+	// DELETEs of single or listed keys with 428.
+	RequireIfMatch bool
+
+	// SensitiveKeys are encrypted with the KMS key KMSKeyID before storage,
+	// as are webhook secrets whenever KMSKeyID is set.
+	SensitiveKeys []string
+	KMSKeyID      string
+
+	// Admin API credentials and listener. AdminAPIKeys, else
+	// AdminAudiences, replaces the user-facing auth for admin routes;
+	// AdminPort moves them to a separate listener.
+	AdminAPIKeys   []AdminKey
+	AdminAudiences []string
+	AdminPort      string
+
+	// GRPCPort serves the preferences CRUD API over gRPC on a separate
+	// listener; empty disables it.
+	GRPCPort string
+
+	// AuditLogFile receives auth denial audit events; empty means stderr.
+	AuditLogFile string
+
+	// Failed-auth throttling; AuthThrottleMaxFailures of zero disables it.
+	AuthThrottleMaxFailures int
+	AuthThrottleWindow      time.Duration
+	AuthThrottleBlock       time.Duration
+	AuthThrottleMaxBlock    time.Duration
+	// TrustedProxies are the proxies whose X-Forwarded-For is believed when
+	// throttling by client IP.
+	TrustedProxies []netip.Prefix
+
+	// MaxResponseBytes caps GetAll response bodies; 0 disables the limit.
+	MaxResponseBytes int
+
+	// ReservedKeyPrefixes may not start keys written through the API.
+	ReservedKeyPrefixes []string
+	// ValueSchemaFile names a JSON file of per-key and per-namespace JSON
+	// Schemas that written values must match.
+	ValueSchemaFile string
+	// SchemaFile names the preference schema (see schema.go) whose key
+	// definitions written values must satisfy. SchemaFromTable instead
+	// enforces the schema managed through the admin API, reloaded every
+	// SchemaRefresh.
+	SchemaFile      string
+	SchemaFromTable bool
+	SchemaRefresh   time.Duration
+	// RejectDeprecatedWrites rejects writes to deprecated keys instead of
+	// mirroring aliases to their replacements.
+	RejectDeprecatedWrites bool
+	// AllowedKeys, when set, restricts writes to the listed keys and
+	// namespaces; DropUnknownKeys drops other keys instead of rejecting.
+	AllowedKeys     []string
+	DropUnknownKeys bool
+	// UnknownUsersNotFound makes GET of the map 404 for users who have
+	// never stored preferences, instead of returning an empty map.
+	UnknownUsersNotFound bool
+
+	// Per-user storage quota; see Quota. Zero disables a limit.
+	MaxKeysPerUser int
+	MaxKeyLength   int
+	MaxValueBytes  int
+	MaxItemBytes   int
+
+	// BatchMaxUsers caps the userIds in one internal batch request.
+	BatchMaxUsers int
+	// PatchMaxKeys caps the keys one PATCH may set or remove.
+	PatchMaxKeys int
+
+	// Preference change history; enabled when HistoryTableName is set.
+	// Entries expire after HistoryRetention.
+	HistoryTableName string
+	HistoryRetention time.Duration
+
+	// SoftDeleteRetention, when positive, keeps preference maps removed by
+	// DELETE /preferences restorable for that long; zero deletes outright.
+	SoftDeleteRetention time.Duration
+
+	// UndoWindow, when positive, returns an Undo-Token from DeleteAll and
+	// ReplaceAll that restores the previous map for that long.
+	UndoWindow time.Duration
+
+	// Default preferences for the effective view, from a JSON file or the
+	// DEFAULTS#global table item (not both). DefaultsRefresh is how often
+	// the table item is reloaded.
+	DefaultsFile      string
+	DefaultsFromTable bool
+	DefaultsRefresh   time.Duration
+
+	// ExperimentsFile names a JSON file of experiments (see
+	// LoadExperiments); empty disables experiment assignment.
+	ExperimentsFile string
+	// TemplatesFile names a JSON file of preference templates for
+	// provisioning (see LoadTemplates).
+	TemplatesFile string
+
+	// WebhookRefresh is how often each instance reloads the webhook
+	// subscriptions it delivers change events to.
+	WebhookRefresh time.Duration
+
+	// ChangeEvents is where change events for webhooks and brokers come
+	// from: ChangeEventsAPI, published by the API on each write, or
+	// ChangeEventsStream, left to the stream worker. DynamoStreamARN names
+	// the table's stream for the worker (empty: looked up), and
+	// StreamStart where it starts reading shards it has no checkpoint for.
+	ChangeEvents    string
+	DynamoStreamARN string
+	StreamStart     string
+
+	// EventSource is the CloudEvents source of change events sent to
+	// brokers and webhooks.
+	EventSource string
+
+	// SNSTopicARN is the SNS topic change events are published to; empty
+	// disables publishing.
+	SNSTopicARN string
+	// EventBridgeBusName is the EventBridge bus change events are put on,
+	// from EventBridgeSource; empty disables it. With
+	// EventBridgeRedactSensitive, changes to sensitive keys are included
+	// with redacted values instead of left out.
+	EventBridgeBusName         string
+	EventBridgeSource          string
+	EventBridgeRedactSensitive bool
+	// KafkaBrokers and KafkaTopic are where change events are produced;
+	// no brokers disables it. KafkaSASLMechanism (PLAIN, SCRAM-SHA-256 or
+	// SCRAM-SHA-512) enables SASL with KafkaSASLUsername and
+	// KafkaSASLPassword; KafkaTLS encrypts connections.
+	KafkaBrokers       []string
+	KafkaTopic         string
+	KafkaSASLMechanism string
+	KafkaSASLUsername  string
+	KafkaSASLPassword  string
+	KafkaTLS           bool
+	// SQSQueueURL is the SQS queue change events are enqueued on; empty
+	// disables it. Events that cannot be enqueued go to SQSDeadLetterURL,
+	// if set.
+	SQSQueueURL      string
+	SQSDeadLetterURL string
+	// NATSURL lists the NATS servers change events are published to, on
+	// subjects NATSSubjectPrefix.<userId>; empty disables it.
+	// NATSCredsFile holds user credentials (JWT and NKey seed).
+	NATSURL           string
+	NATSSubjectPrefix string
+	NATSCredsFile     string
+
+	// IdempotencyTTL is how long results of writes sent with an
+	// Idempotency-Key are replayed; zero disables the header.
+	IdempotencyTTL time.Duration
+
+	// Secrets loaded from Secrets Manager or SSM instead of plaintext env
+	// vars; nil when the plain variable is used. SecretsRefresh is how often
+	// they are re-fetched.
+	JWTSecretRef           *SecretRef
+	IntrospectionSecretRef *SecretRef
+	KafkaPasswordRef       *SecretRef
+	SecretsRefresh         time.Duration
+}
+
+// secretRefs returns the configured secret refs.
+func (c Config) secretRefs() []*SecretRef {
+	var refs []*SecretRef
+	for _, ref := range []*SecretRef{c.JWTSecretRef, c.IntrospectionSecretRef, c.KafkaPasswordRef} {
+		if ref != nil {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
 }
 
 func LoadConfig() (Config, error) {
+	authMode := strings.ToLower(envOrDefault("AUTH_MODE", AuthModeJWT))
 	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return Config{}, fmt.Errorf("JWT_SECRET environment variable is required")
+	secretARN := os.Getenv("JWT_SECRET_ARN")
+
+	algorithms := splitList(envOrDefault("JWT_ALGORITHMS", AlgHS256))
+	var publicKeys []crypto.PublicKey
+
+	switch authMode {
+	case AuthModeJWT:
+		for _, alg := range algorithms {
+			if !slices.Contains(supportedAlgorithms, alg) {
+				return Config{}, fmt.Errorf("unsupported JWT_ALGORITHMS entry %q", alg)
+			}
+		}
+		if usesSecret(algorithms) && secret == "" && secretARN == "" {
+			return Config{}, fmt.Errorf("JWT_SECRET or JWT_SECRET_ARN environment variable is required")
+		}
+		if usesPublicKeys(algorithms) {
+			path := os.Getenv("JWT_PUBLIC_KEY_FILE")
+			if path == "" && os.Getenv("JWT_JWKS_URL") == "" {
+				return Config{}, fmt.Errorf("JWT_PUBLIC_KEY_FILE or JWT_JWKS_URL is required for JWT_ALGORITHMS %v", algorithms)
+			}
+			if path != "" {
+				var err error
+				if publicKeys, err = LoadPublicKeys(path); err != nil {
+					return Config{}, err
+				}
+			}
+		}
+	case AuthModeMTLS:
+		for _, key := range []string{"TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_CLIENT_CA_FILE"} {
+			if os.Getenv(key) == "" {
+				return Config{}, fmt.Errorf("%s is required when AUTH_MODE=mtls", key)
+			}
+		}
+	case AuthModeIntrospection:
+		if os.Getenv("INTROSPECTION_URL") == "" {
+			return Config{}, fmt.Errorf("INTROSPECTION_URL is required when AUTH_MODE=introspection")
+		}
+	default:
+		return Config{}, fmt.Errorf("unknown AUTH_MODE %q", authMode)
+	}
+
+	preflight := strings.ToLower(envOrDefault("JWT_PREFLIGHT", PreflightWarn))
+	switch preflight {
+	case PreflightOff, PreflightWarn, PreflightStrict:
+	default:
+		return Config{}, fmt.Errorf("unknown JWT_PREFLIGHT %q", preflight)
 	}
 
 	cfg := Config{
@@ -30,11 +309,299 @@ func LoadConfig() (Config, error) {
 		DynamoEndpoint:  os.Getenv("DYNAMODB_ENDPOINT"),
 		DynamoTableName: envOrDefault("DYNAMODB_TABLE_NAME", "user-preferences"),
 		JWTSecret:       secret,
+		JWTAlgorithms:   algorithms,
+		JWTPublicKeys:   publicKeys,
+		JWKSURL:         os.Getenv("JWT_JWKS_URL"),
 		JWTIssuer:       os.Getenv("JWT_ISSUER"),
+		JWTAudiences: AudiencePolicy{
+			Browser: splitList(os.Getenv("JWT_BROWSER_AUDIENCES")),
+			Service: splitList(os.Getenv("JWT_SERVICE_AUDIENCES")),
+		},
+		JWTCookieName:   os.Getenv("JWT_COOKIE_NAME"),
+		CSRFCookieName:  envOrDefault("CSRF_COOKIE_NAME", defaultCSRFCookie),
 		AWSRegion:       envOrDefault("AWS_REGION", "us-east-1"),
 		CORSAllowOrigin: envOrDefault("CORS_ALLOW_ORIGIN", "*"),
+		CanonicalJSON:   strings.EqualFold(os.Getenv("CANONICAL_JSON"), "true"),
 		LogLevel:        parseLogLevel(os.Getenv("LOG_LEVEL")),
 		DevBypassAuth:   strings.EqualFold(os.Getenv("DEV_BYPASS_AUTH"), "true"),
+
+		JWTPreflight:      preflight,
+		JWTPreflightToken: os.Getenv("JWT_PREFLIGHT_TOKEN"),
+		JWTAllowedActors:  splitList(os.Getenv("JWT_ALLOWED_ACTORS")),
+		AuditLogFile:      os.Getenv("AUDIT_LOG_FILE"),
+		AdminAudiences:    splitList(os.Getenv("ADMIN_AUDIENCES")),
+		SensitiveKeys:     splitList(os.Getenv("SENSITIVE_KEYS")),
+		ConcealForbidden:  strings.EqualFold(os.Getenv("CONCEAL_FORBIDDEN"), "true"),
+		RequireIfMatch:    strings.EqualFold(os.Getenv("REQUIRE_IF_MATCH"), "true"),
+		KMSKeyID:          os.Getenv("KMS_KEY_ID"),
+		SNSTopicARN:       os.Getenv("SNS_TOPIC_ARN"),
+		AdminPort:         os.Getenv("ADMIN_PORT"),
+		GRPCPort:          os.Getenv("GRPC_PORT"),
+
+		DynamoStreamARN:            os.Getenv("DYNAMODB_STREAM_ARN"),
+		EventSource:                envOrDefault("EVENT_SOURCE", "user-prefs"),
+		EventBridgeBusName:         os.Getenv("EVENTBRIDGE_BUS_NAME"),
+		EventBridgeSource:          envOrDefault("EVENTBRIDGE_SOURCE", "user-prefs"),
+		EventBridgeRedactSensitive: strings.EqualFold(os.Getenv("EVENTBRIDGE_REDACT_SENSITIVE"), "true"),
+		KafkaBrokers:               splitList(os.Getenv("KAFKA_BROKERS")),
+		KafkaTopic:                 envOrDefault("KAFKA_TOPIC", "preference-changes"),
+		KafkaSASLUsername:          os.Getenv("KAFKA_SASL_USERNAME"),
+		KafkaSASLPassword:          os.Getenv("KAFKA_SASL_PASSWORD"),
+		KafkaTLS:                   strings.EqualFold(os.Getenv("KAFKA_TLS"), "true"),
+		SQSQueueURL:                os.Getenv("SQS_QUEUE_URL"),
+		SQSDeadLetterURL:           os.Getenv("SQS_DLQ_URL"),
+		NATSURL:                    os.Getenv("NATS_URL"),
+		NATSSubjectPrefix:          envOrDefault("NATS_SUBJECT_PREFIX", "prefs.changed"),
+		NATSCredsFile:              os.Getenv("NATS_CREDS_FILE"),
+
+		AuthMode:          authMode,
+		TLSCertFile:       os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:        os.Getenv("TLS_KEY_FILE"),
+		TLSClientCAFile:   os.Getenv("TLS_CLIENT_CA_FILE"),
+		MTLSAllowedIdents: splitList(os.Getenv("MTLS_ALLOWED_IDENTITIES")),
+		MTLSScopes:        strings.Fields(envOrDefault("MTLS_SCOPES", ScopeRead+" "+ScopeWrite)),
+
+		IntrospectionURL:          os.Getenv("INTROSPECTION_URL"),
+		IntrospectionClientID:     os.Getenv("INTROSPECTION_CLIENT_ID"),
+		IntrospectionClientSecret: os.Getenv("INTROSPECTION_CLIENT_SECRET"),
+
+		SigV4AllowedPrincipals: splitList(os.Getenv("SIGV4_ALLOWED_PRINCIPALS")),
+		SigV4Scopes:            strings.Fields(envOrDefault("SIGV4_SCOPES", ScopeRead+" "+ScopeWrite)),
+		SigV4Audience:          os.Getenv("SIGV4_AUDIENCE"),
+
+		StandbyTableName: os.Getenv("STANDBY_DYNAMODB_TABLE_NAME"),
+		StandbyEndpoint:  os.Getenv("STANDBY_DYNAMODB_ENDPOINT"),
+		StandbyRegion:    envOrDefault("STANDBY_AWS_REGION", envOrDefault("AWS_REGION", "us-east-1")),
+		FailoverAuto:     strings.EqualFold(os.Getenv("FAILOVER_AUTO"), "true"),
+
+		HistoryTableName: os.Getenv("HISTORY_TABLE_NAME"),
+
+		ReservedKeyPrefixes: splitList(envOrDefault("RESERVED_KEY_PREFIXES", "system.,internal.")),
+		ValueSchemaFile:     os.Getenv("VALUE_SCHEMA_FILE"),
+		SchemaFile:          os.Getenv("PREFERENCE_SCHEMA_FILE"),
+		SchemaFromTable:     strings.EqualFold(os.Getenv("SCHEMA_FROM_TABLE"), "true"),
+		AllowedKeys:         splitList(os.Getenv("ALLOWED_KEYS")),
+
+		DefaultsFile:      os.Getenv("DEFAULTS_FILE"),
+		DefaultsFromTable: strings.EqualFold(os.Getenv("DEFAULTS_FROM_TABLE"), "true"),
+
+		ExperimentsFile: os.Getenv("EXPERIMENTS_FILE"),
+		TemplatesFile:   os.Getenv("TEMPLATES_FILE"),
+	}
+
+	var err error
+	if cfg.MaxResponseBytes, err = envInt("MAX_RESPONSE_BYTES", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxKeysPerUser, err = envInt("MAX_KEYS_PER_USER", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxKeyLength, err = envInt("MAX_KEY_LENGTH", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxValueBytes, err = envInt("MAX_VALUE_BYTES", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxItemBytes, err = envInt("MAX_ITEM_BYTES", 300000); err != nil {
+		return Config{}, err
+	}
+	if cfg.BatchMaxUsers, err = envInt("BATCH_MAX_USERS", defaultMaxBatchUsers); err != nil {
+		return Config{}, err
+	}
+	if cfg.PatchMaxKeys, err = envInt("PATCH_MAX_KEYS", defaultMaxPatchKeys); err != nil {
+		return Config{}, err
+	}
+	if cfg.PatchMaxKeys <= 0 {
+		return Config{}, fmt.Errorf("PATCH_MAX_KEYS must be positive")
+	}
+	if cfg.HistoryRetention, err = envDuration("HISTORY_RETENTION", 90*24*time.Hour); err != nil {
+		return Config{}, err
+	}
+	if cfg.HistoryRetention <= 0 {
+		return Config{}, fmt.Errorf("HISTORY_RETENTION must be positive")
+	}
+	if cfg.SoftDeleteRetention, err = envDuration("SOFT_DELETE_RETENTION", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.SoftDeleteRetention < 0 {
+		return Config{}, fmt.Errorf("SOFT_DELETE_RETENTION must not be negative")
+	}
+	if cfg.UndoWindow, err = envDuration("UNDO_WINDOW", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.UndoWindow < 0 {
+		return Config{}, fmt.Errorf("UNDO_WINDOW must not be negative")
+	}
+	if cfg.WebhookRefresh, err = envDuration("WEBHOOK_REFRESH", time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.WebhookRefresh <= 0 {
+		return Config{}, fmt.Errorf("WEBHOOK_REFRESH must be positive")
+	}
+	if cfg.DefaultsFile != "" && cfg.DefaultsFromTable {
+		return Config{}, fmt.Errorf("DEFAULTS_FILE and DEFAULTS_FROM_TABLE are mutually exclusive")
+	}
+	if cfg.DefaultsRefresh, err = envDuration("DEFAULTS_REFRESH", time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.DefaultsFromTable && cfg.DefaultsRefresh <= 0 {
+		return Config{}, fmt.Errorf("DEFAULTS_REFRESH must be positive")
+	}
+	if cfg.SchemaFile != "" && cfg.SchemaFromTable {
+		return Config{}, fmt.Errorf("PREFERENCE_SCHEMA_FILE and SCHEMA_FROM_TABLE are mutually exclusive")
+	}
+	if cfg.SchemaRefresh, err = envDuration("SCHEMA_REFRESH", time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.SchemaFromTable && cfg.SchemaRefresh <= 0 {
+		return Config{}, fmt.Errorf("SCHEMA_REFRESH must be positive")
+	}
+	switch writes := strings.ToLower(envOrDefault("DEPRECATED_KEY_WRITES", "mirror")); writes {
+	case "mirror":
+	case "reject":
+		cfg.RejectDeprecatedWrites = true
+	default:
+		return Config{}, fmt.Errorf("unknown DEPRECATED_KEY_WRITES %q", writes)
+	}
+	if cfg.IdempotencyTTL, err = envDuration("IDEMPOTENCY_TTL", 24*time.Hour); err != nil {
+		return Config{}, err
+	}
+	if cfg.IdempotencyTTL < 0 {
+		return Config{}, fmt.Errorf("IDEMPOTENCY_TTL must not be negative")
+	}
+	switch unknown := strings.ToLower(envOrDefault("UNKNOWN_KEYS", "reject")); unknown {
+	case "reject":
+	case "drop":
+		cfg.DropUnknownKeys = true
+	default:
+		return Config{}, fmt.Errorf("unknown UNKNOWN_KEYS %q", unknown)
+	}
+	switch cfg.ChangeEvents = strings.ToLower(envOrDefault("CHANGE_EVENTS", ChangeEventsAPI)); cfg.ChangeEvents {
+	case ChangeEventsAPI, ChangeEventsStream:
+	default:
+		return Config{}, fmt.Errorf("unknown CHANGE_EVENTS %q", cfg.ChangeEvents)
+	}
+	switch cfg.StreamStart = strings.ToLower(envOrDefault("STREAM_START", StreamStartLatest)); cfg.StreamStart {
+	case StreamStartLatest, StreamStartTrimHorizon:
+	default:
+		return Config{}, fmt.Errorf("unknown STREAM_START %q", cfg.StreamStart)
+	}
+	switch unknown := strings.ToLower(envOrDefault("UNKNOWN_USERS", "empty")); unknown {
+	case "empty":
+	case "not_found":
+		cfg.UnknownUsersNotFound = true
+	default:
+		return Config{}, fmt.Errorf("unknown UNKNOWN_USERS %q", unknown)
+	}
+	if cfg.IntrospectionCacheTTL, err = envDuration("INTROSPECTION_CACHE_TTL", time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.RateLimitRPS, err = envFloat("RATE_LIMIT_RPS", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.RateLimitBurst, err = envInt("RATE_LIMIT_BURST", int(cfg.RateLimitRPS)); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxInFlight, err = envInt("MAX_IN_FLIGHT", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.ShedLatency, err = envDuration("SHED_LATENCY_TARGET", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.AuthThrottleMaxFailures, err = envInt("AUTH_THROTTLE_MAX_FAILURES", 10); err != nil {
+		return Config{}, err
+	}
+	if cfg.AuthThrottleWindow, err = envDuration("AUTH_THROTTLE_WINDOW", time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.AuthThrottleBlock, err = envDuration("AUTH_THROTTLE_BLOCK", time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.AuthThrottleMaxBlock, err = envDuration("AUTH_THROTTLE_MAX_BLOCK", time.Hour); err != nil {
+		return Config{}, err
+	}
+	for _, cidr := range splitList(os.Getenv("TRUSTED_PROXY_CIDRS")) {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid TRUSTED_PROXY_CIDRS entry %q: %w", cidr, err)
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, p)
+	}
+	if len(cfg.SensitiveKeys) > 0 && cfg.KMSKeyID == "" {
+		return Config{}, fmt.Errorf("KMS_KEY_ID is required when SENSITIVE_KEYS is set")
+	}
+	if cfg.AdminAPIKeys, err = parseAdminKeys(splitList(os.Getenv("ADMIN_API_KEYS"))); err != nil {
+		return Config{}, err
+	}
+	if len(cfg.AdminAudiences) > 0 && cfg.AuthMode != AuthModeJWT {
+		return Config{}, fmt.Errorf("ADMIN_AUDIENCES requires AUTH_MODE=jwt; use ADMIN_API_KEYS instead")
+	}
+	if cfg.AdminPort != "" && cfg.AdminPort == cfg.ServerPort {
+		return Config{}, fmt.Errorf("ADMIN_PORT must differ from SERVER_PORT")
+	}
+	if cfg.GRPCPort != "" && (cfg.GRPCPort == cfg.ServerPort || cfg.GRPCPort == cfg.AdminPort) {
+		return Config{}, fmt.Errorf("GRPC_PORT must differ from SERVER_PORT and ADMIN_PORT")
+	}
+	if cfg.SQSDeadLetterURL != "" && cfg.SQSQueueURL == "" {
+		return Config{}, fmt.Errorf("SQS_DLQ_URL requires SQS_QUEUE_URL")
+	}
+	if p := cfg.NATSSubjectPrefix; p == "" || strings.ContainsAny(p, "*> \t") || strings.HasPrefix(p, ".") || strings.HasSuffix(p, ".") {
+		return Config{}, fmt.Errorf("NATS_SUBJECT_PREFIX must be a subject without wildcards")
+	}
+	if cfg.KafkaSASLMechanism, err = parseKafkaSASL(os.Getenv("KAFKA_SASL_MECHANISM")); err != nil {
+		return Config{}, err
+	}
+	if cfg.KafkaSASLMechanism != "" && (cfg.KafkaSASLUsername == "" || cfg.KafkaSASLPassword == "" && os.Getenv("KAFKA_SASL_PASSWORD_ARN") == "") {
+		return Config{}, fmt.Errorf("KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD or KAFKA_SASL_PASSWORD_ARN are required when KAFKA_SASL_MECHANISM is set")
+	}
+	for _, cidr := range splitList(os.Getenv("DEV_BYPASS_ALLOWED_CIDRS")) {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid DEV_BYPASS_ALLOWED_CIDRS entry %q: %w", cidr, err)
+		}
+		cfg.DevBypassNets = append(cfg.DevBypassNets, p)
+	}
+	if cfg.JWKSRefresh, err = envDuration("JWT_JWKS_REFRESH", 5*time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.SecretsRefresh, err = envDuration("SECRETS_REFRESH_INTERVAL", 5*time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.FailoverThreshold, err = envInt("FAILOVER_THRESHOLD", 5); err != nil {
+		return Config{}, err
+	}
+	if cfg.FailoverRetry, err = envDuration("FAILOVER_RETRY_AFTER", 30*time.Second); err != nil {
+		return Config{}, err
+	}
+
+	if secretARN != "" {
+		cfg.JWTSecretRef = &SecretRef{ARN: secretARN}
+	}
+	if arn := os.Getenv("INTROSPECTION_CLIENT_SECRET_ARN"); arn != "" {
+		cfg.IntrospectionSecretRef = &SecretRef{ARN: arn}
+	}
+	if arn := os.Getenv("KAFKA_SASL_PASSWORD_ARN"); arn != "" {
+		cfg.KafkaPasswordRef = &SecretRef{ARN: arn}
+	}
+	if refs := cfg.secretRefs(); len(refs) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		f, err := newAWSSecrets(ctx, cfg.AWSRegion)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := loadSecrets(ctx, f, refs); err != nil {
+			return Config{}, fmt.Errorf("loading secrets: %w", err)
+		}
+		if cfg.JWTSecretRef != nil {
+			cfg.JWTSecret = cfg.JWTSecretRef.Value()
+		}
+		if cfg.IntrospectionSecretRef != nil {
+			cfg.IntrospectionClientSecret = cfg.IntrospectionSecretRef.Value()
+		}
+		if cfg.KafkaPasswordRef != nil {
+			cfg.KafkaSASLPassword = cfg.KafkaPasswordRef.Value()
+		}
 	}
 
 	return cfg, nil
@@ -47,6 +614,56 @@ func envOrDefault(key, fallback string) string {
 	return fallback
 }
 
+// envInt parses an integer env var, returning fallback when it is unset.
+func envInt(key string, fallback int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", key, err)
+	}
+	return n, nil
+}
+
+// envFloat parses a float env var, returning fallback when it is unset.
+func envFloat(key string, fallback float64) (float64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number: %w", key, err)
+	}
+	return f, nil
+}
+
+// envDuration parses a time.Duration env var, returning fallback when unset.
+func envDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration: %w", key, err)
+	}
+	return d, nil
+}
+
+// splitList parses a comma-separated env value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
 func parseLogLevel(s string) slog.Level {
 	switch strings.ToLower(s) {
 	case "debug":
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// CopyRequest is the body of the admin copy endpoint.
+type CopyRequest struct {
+	TargetUserID string `json:"targetUserId"`
+	// Overwrite replaces the target's values for keys both users have;
+	// otherwise the target's values are kept.
+	Overwrite bool `json:"overwrite"`
+	// DryRun reports the outcome without writing.
+	DryRun bool `json:"dryRun"`
+}
+
+// CopyResponse reports a copy. Copied lists the keys written to the target,
+// Kept the keys whose differing target value was left in place. Preferences
+// is the target's resulting map, with sensitive values redacted.
+type CopyResponse struct {
+	SourceUserID string         `json:"sourceUserId"`
+	TargetUserID string         `json:"targetUserId"`
+	DryRun       bool           `json:"dryRun"`
+	Copied       []string       `json:"copied"`
+	Kept         []string       `json:"kept"`
+	Preferences  map[string]any `json:"preferences"`
+}
+
+// AdminCopy copies one user's preferences into another's, for merging
+// duplicate accounts. The source is left unchanged. The target is written in
+// one update guarded by the version that was read, so a concurrent change to
+// it fails the copy with 409 rather than being overwritten.
+func (h *PreferencesHandler) AdminCopy(w http.ResponseWriter, r *http.Request) {
+	claims, _ := ClaimsFromContext(r.Context())
+	srcID := r.PathValue("userId")
+
+	var req CopyRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if req.TargetUserID == "" || req.TargetUserID == srcID {
+		writeError(w, http.StatusBadRequest, "targetUserId must name another user")
+		return
+	}
+
+	src, err := h.store.GetAll(r.Context(), srcID)
+	if err != nil {
+		h.logger.Error("store.GetAll failed", "error", err, "userId", srcID)
+		writeError(w, http.StatusInternalServerError, "failed to copy preferences")
+		return
+	}
+	if len(src.Prefs) == 0 {
+		writeError(w, http.StatusNotFound, "source user has no preferences")
+		return
+	}
+	dst, err := h.store.GetAll(r.Context(), req.TargetUserID)
+	if err != nil {
+		h.logger.Error("store.GetAll failed", "error", err, "userId", req.TargetUserID)
+		writeError(w, http.StatusInternalServerError, "failed to copy preferences")
+		return
+	}
+
+	resp := CopyResponse{
+		SourceUserID: srcID,
+		TargetUserID: req.TargetUserID,
+		DryRun:       req.DryRun,
+		Copied:       []string{},
+		Kept:         []string{},
+	}
+	writes := make(map[string]any)
+	for _, k := range slices.Sorted(maps.Keys(src.Prefs)) {
+		v := src.Prefs[k]
+		cur, exists := dst.Prefs[k]
+		switch {
+		case exists && sameValue(cur, v):
+		case exists && !req.Overwrite:
+			resp.Kept = append(resp.Kept, k)
+		default:
+			writes[k] = v
+			resp.Copied = append(resp.Copied, k)
+		}
+	}
+
+	result := maps.Clone(dst.Prefs)
+	if result == nil {
+		result = make(map[string]any)
+	}
+	maps.Copy(result, writes)
+
+	// A dry run reports the quota failure the copy would hit.
+	if len(writes) > 0 && !h.checkQuota(w, r, req.TargetUserID, writes, nil, false) {
+		return
+	}
+	if !req.DryRun && len(writes) > 0 {
+		// A target with no record must still have none, or a first write
+		// made since would be merged with the copy unseen.
+		cond := Precondition{MustNotExist: true}
+		if dst.Prefs != nil {
+			cond = Precondition{Versions: []int64{dst.Version}, Generation: dst.Generation}
+		}
+		rec, err := h.store.Update(r.Context(), req.TargetUserID, writes, nil, cond)
+		if errors.Is(err, ErrPreconditionFailed) {
+			writeError(w, http.StatusConflict, "target preferences changed during copy; retry")
+			return
+		}
+		if err != nil {
+			h.logger.Error("store.Update failed", "error", err, "userId", req.TargetUserID)
+			writeError(w, http.StatusInternalServerError, "failed to copy preferences")
+			return
+		}
+		result = rec.Prefs
+		h.logger.Info("preferences copied", "sub", claims.Subject, "from", srcID, "to", req.TargetUserID, "keys", len(writes))
+	}
+
+	resp.Preferences = make(map[string]any, len(result))
+	for k, v := range result {
+		resp.Preferences[k] = h.opts.redact(k, v)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestAdminCopy(t *testing.T) {
+	store := newMockStore()
+	store.prefs["src"] = map[string]any{"theme": "dark", "lang": "en", "secret": "s1", "tz": "UTC"}
+	store.prefs["dst"] = map[string]any{"theme": "light", "tz": "UTC"}
+	store.versions["dst"] = 4
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{SensitiveKeys: []string{"secret"}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/admin/users/{userId}/preferences:copyTo", h.AdminCopy)
+	copyTo := func(src, body string) (int, CopyResponse) {
+		req := httptest.NewRequest("POST", "/api/v1/admin/users/"+src+"/preferences:copyTo", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "apikey:support"))
+		var resp CopyResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := copyTo("src", `{"targetUserId":"dst","dryRun":true}`)
+	if code != http.StatusOK || !resp.DryRun {
+		t.Fatalf("unexpected dry run %d %+v", code, resp)
+	}
+	if !slices.Equal(resp.Copied, []string{"lang", "secret"}) || !slices.Equal(resp.Kept, []string{"theme"}) {
+		t.Fatalf("unexpected plan copied=%v kept=%v", resp.Copied, resp.Kept)
+	}
+	if resp.Preferences["secret"] != redactedValue || resp.Preferences["theme"] != "light" {
+		t.Fatalf("unexpected resulting map %v", resp.Preferences)
+	}
+	if len(store.prefs["dst"]) != 2 || store.versions["dst"] != 4 {
+		t.Fatal("expected a dry run not to write")
+	}
+
+	code, resp = copyTo("src", `{"targetUserId":"dst","overwrite":true}`)
+	if code != http.StatusOK || !slices.Equal(resp.Copied, []string{"lang", "secret", "theme"}) || len(resp.Kept) != 0 {
+		t.Fatalf("unexpected copy %d %+v", code, resp)
+	}
+	if got := store.prefs["dst"]; got["theme"] != "dark" || got["secret"] != "s1" || len(got) != 4 {
+		t.Fatalf("unexpected target %v", got)
+	}
+	if len(store.prefs["src"]) != 4 {
+		t.Fatal("expected the source to be unchanged")
+	}
+
+	for _, tt := range []struct {
+		src, body string
+		want      int
+	}{
+		{"src", `{"targetUserId":"src"}`, http.StatusBadRequest},
+		{"src", `{}`, http.StatusBadRequest},
+		{"nobody", `{"targetUserId":"dst"}`, http.StatusNotFound},
+	} {
+		if code, _ := copyTo(tt.src, tt.body); code != tt.want {
+			t.Fatalf("%s %s: expected %d, got %d", tt.src, tt.body, tt.want, code)
+		}
+	}
+}
+
+func TestAdminCopy_Checks(t *testing.T) {
+	store := &getAllRacingStore{mockStore: newMockStore()}
+	store.prefs["src"] = map[string]any{"theme": "dark", "volume": json.Number("3")}
+	store.prefs["dst"] = map[string]any{"volume": 3.0}
+	store.versions["dst"] = 1
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Quota: Quota{MaxKeys: 1}})
+	copyTo := func(target string, dryRun bool) (int, CopyResponse) {
+		body, _ := json.Marshal(CopyRequest{TargetUserID: target, DryRun: dryRun})
+		r := withClaims(httptest.NewRequest("POST", "/api/v1/admin/users/src/preferences:copyTo", bytes.NewReader(body)), "apikey:support")
+		r.SetPathValue("userId", "src")
+		w := httptest.NewRecorder()
+		h.AdminCopy(w, r)
+		var resp CopyResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	// The same number decoded differently is not a difference, and a dry
+	// run fails the quota the copy would.
+	if code, resp := copyTo("dst", true); code != http.StatusUnprocessableEntity || resp.DryRun {
+		t.Fatalf("expected the dry run to fail the quota, got %d %+v", code, resp)
+	}
+	h.opts.Quota = Quota{}
+	if code, resp := copyTo("dst", true); code != http.StatusOK || !slices.Equal(resp.Copied, []string{"theme"}) || len(resp.Kept) != 0 {
+		t.Fatalf("expected only theme to differ, got %d %+v", code, resp)
+	}
+
+	// A first write to a new target during the copy fails it.
+	if code, _ := copyTo("new", false); code != http.StatusConflict || !store.raced {
+		t.Fatalf("expected 409 when the target is created during the copy, got %d", code)
+	}
+	if got := store.prefs["new"]; len(got) != 1 || got["lang"] != "fr" {
+		t.Fatalf("expected the first write kept, got %v", got)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Correction request statuses.
+const (
+	CorrectionOpen     = "open"
+	CorrectionResolved = "resolved"
+	CorrectionRejected = "rejected"
+)
+
+// CorrectionRequest is a user report that a preference value is wrong or
+// stale, typically for keys the user cannot edit themselves.
+type CorrectionRequest struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"userId"`
+	Key            string    `json:"key"`
+	CurrentValue   any       `json:"currentValue"`
+	SuggestedValue string    `json:"suggestedValue,omitempty"`
+	Reason         string    `json:"reason"`
+	Status         string    `json:"status"`
+	Resolution     string    `json:"resolution,omitempty"`
+	ResolvedBy     string    `json:"resolvedBy,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// CorrectionStore persists correction requests.
+type CorrectionStore interface {
+	CreateCorrection(ctx context.Context, c CorrectionRequest) error
+	ListCorrections(ctx context.Context, userID string, status string) ([]CorrectionRequest, error)
+	// ResolveCorrection closes an open request. It returns ErrNotFound if the
+	// request does not exist and ErrConflict if it is no longer open.
+	ResolveCorrection(ctx context.Context, id string, status string, resolution string, resolvedBy string) (CorrectionRequest, error)
+	// DeleteCorrection removes a request; deleting a missing one succeeds.
+	DeleteCorrection(ctx context.Context, id string) error
+}
+
+// CorrectionNotifier is told about correction workflow transitions so admins
+// and users can be alerted.
+type CorrectionNotifier interface {
+	CorrectionCreated(ctx context.Context, c CorrectionRequest)
+	CorrectionResolved(ctx context.Context, c CorrectionRequest)
+}
+
+// logNotifier writes notifications to the application log for pickup by
+// log-based alerting.
+type logNotifier struct {
+	logger *slog.Logger
+}
+
+func (n logNotifier) CorrectionCreated(_ context.Context, c CorrectionRequest) {
+	n.logger.Info("correction requested", "id", c.ID, "userId", c.UserID, "key", c.Key)
+}
+
+func (n logNotifier) CorrectionResolved(_ context.Context, c CorrectionRequest) {
+	n.logger.Info("correction resolved", "id", c.ID, "userId", c.UserID, "key", c.Key, "status", c.Status)
+}
+
+// sinkNotifier is the CorrectionNotifier handlers use: it logs each
+// transition and publishes it to its sinks as a correction event carrying
+// the request. The event's changes hold the flagged key and its current
+// value, so webhook key filters apply to it.
+type sinkNotifier struct {
+	logNotifier
+	sinks []ChangeSink
+}
+
+func (n sinkNotifier) CorrectionCreated(ctx context.Context, c CorrectionRequest) {
+	n.logNotifier.CorrectionCreated(ctx, c)
+	n.publish(ctx, EventCorrectionCreated, c.UserID, c)
+}
+
+func (n sinkNotifier) CorrectionResolved(ctx context.Context, c CorrectionRequest) {
+	n.logNotifier.CorrectionResolved(ctx, c)
+	n.publish(ctx, EventCorrectionResolved, c.ResolvedBy, c)
+}
+
+func (n sinkNotifier) publish(ctx context.Context, typ, by string, c CorrectionRequest) {
+	e := ChangeEvent{
+		ID:         newID(),
+		Type:       typ,
+		UserID:     c.UserID,
+		At:         c.UpdatedAt,
+		By:         by,
+		Changes:    map[string]any{c.Key: c.CurrentValue},
+		Correction: &c,
+	}
+	for _, s := range n.sinks {
+		if s.Listening() {
+			s.PublishChange(ctx, e)
+		}
+	}
+}
+
+// CorrectionsHandler serves the user and admin sides of the correction workflow.
+type CorrectionsHandler struct {
+	prefs    *PreferencesHandler
+	store    CorrectionStore
+	notifier CorrectionNotifier
+}
+
+// NewCorrectionsHandler creates a handler that reuses the preferences
+// handler's authorization, store, and logger, and notifies sinks (such as
+// the WebhookDispatcher) of new and resolved requests.
+func NewCorrectionsHandler(prefs *PreferencesHandler, store CorrectionStore, sinks ...ChangeSink) *CorrectionsHandler {
+	return &CorrectionsHandler{
+		prefs:    prefs,
+		store:    store,
+		notifier: sinkNotifier{logNotifier: logNotifier{logger: prefs.logger}, sinks: sinks},
+	}
+}
+
+type createCorrectionRequest struct {
+	Key            string `json:"key"`
+	Reason         string `json:"reason"`
+	SuggestedValue string `json:"suggestedValue"`
+}
+
+type resolveCorrectionRequest struct {
+	Status     string `json:"status"`
+	Resolution string `json:"resolution"`
+}
+
+// CorrectionsResponse wraps a list of correction requests.
+type CorrectionsResponse struct {
+	Corrections []CorrectionRequest `json:"corrections"`
+}
+
+// Create flags a user's preference value as incorrect.
+func (h *CorrectionsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	var body createCorrectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	key := body.Key
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "missing key")
+		return
+	}
+	if body.Reason == "" {
+		writeError(w, http.StatusBadRequest, "missing reason")
+		return
+	}
+
+	value, found, _, err := h.prefs.store.Get(r.Context(), userID, key)
+	if err != nil {
+		h.prefs.logger.Error("store.Get failed", "error", err, "userId", userID, "key", key)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve preference")
+		return
+	}
+	if !found {
+		writeErrorCode(w, http.StatusNotFound, ErrCodePrefNotFound, "preference not found")
+		return
+	}
+
+	now := time.Now().UTC()
+	c := CorrectionRequest{
+		ID:             newID(),
+		UserID:         userID,
+		Key:            key,
+		CurrentValue:   h.prefs.opts.redact(key, value),
+		SuggestedValue: body.SuggestedValue,
+		Reason:         body.Reason,
+		Status:         CorrectionOpen,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	if err := h.store.CreateCorrection(r.Context(), c); err != nil {
+		h.prefs.logger.Error("store.CreateCorrection failed", "error", err, "userId", userID, "key", key)
+		writeError(w, http.StatusInternalServerError, "failed to create correction request")
+		return
+	}
+
+	h.notifier.CorrectionCreated(r.Context(), c)
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// ListOwn returns the correction requests a user has filed.
+func (h *CorrectionsHandler) ListOwn(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	h.list(w, r, userID, r.URL.Query().Get("status"))
+}
+
+// AdminList returns correction requests across all users, optionally
+// filtered by ?status= and ?userId=.
+func (h *CorrectionsHandler) AdminList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	h.list(w, r, q.Get("userId"), q.Get("status"))
+}
+
+func (h *CorrectionsHandler) list(w http.ResponseWriter, r *http.Request, userID, status string) {
+	if status != "" && !validCorrectionStatus(status) {
+		writeError(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+
+	list, err := h.store.ListCorrections(r.Context(), userID, status)
+	if err != nil {
+		h.prefs.logger.Error("store.ListCorrections failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to list correction requests")
+		return
+	}
+	if list == nil {
+		list = []CorrectionRequest{}
+	}
+
+	writeJSON(w, http.StatusOK, CorrectionsResponse{Corrections: list})
+}
+
+// AdminResolve closes an open correction request as resolved or rejected.
+func (h *CorrectionsHandler) AdminResolve(w http.ResponseWriter, r *http.Request) {
+	claims, _ := ClaimsFromContext(r.Context())
+	id := r.PathValue("id")
+
+	var body resolveCorrectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if body.Status != CorrectionResolved && body.Status != CorrectionRejected {
+		writeError(w, http.StatusBadRequest, "status must be resolved or rejected")
+		return
+	}
+
+	c, err := h.store.ResolveCorrection(r.Context(), id, body.Status, body.Resolution, claims.Subject)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "correction request not found")
+		return
+	case errors.Is(err, ErrConflict):
+		writeError(w, http.StatusConflict, "correction request already closed")
+		return
+	case err != nil:
+		h.prefs.logger.Error("store.ResolveCorrection failed", "error", err, "id", id)
+		writeError(w, http.StatusInternalServerError, "failed to resolve correction request")
+		return
+	}
+
+	h.notifier.CorrectionResolved(r.Context(), c)
+	writeJSON(w, http.StatusOK, c)
+}
+
+func validCorrectionStatus(s string) bool {
+	return s == CorrectionOpen || s == CorrectionResolved || s == CorrectionRejected
+}
+
+// newID returns a random 128-bit hex identifier.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// mockCorrectionStore implements CorrectionStore for testing.
+type mockCorrectionStore struct {
+	items map[string]CorrectionRequest
+}
+
+func newMockCorrectionStore() *mockCorrectionStore {
+	return &mockCorrectionStore{items: make(map[string]CorrectionRequest)}
+}
+
+func (m *mockCorrectionStore) CreateCorrection(_ context.Context, c CorrectionRequest) error {
+	m.items[c.ID] = c
+	return nil
+}
+
+func (m *mockCorrectionStore) ListCorrections(_ context.Context, userID, status string) ([]CorrectionRequest, error) {
+	var out []CorrectionRequest
+	for _, c := range m.items {
+		if (userID == "" || c.UserID == userID) && (status == "" || c.Status == status) {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockCorrectionStore) ResolveCorrection(_ context.Context, id, status, resolution, resolvedBy string) (CorrectionRequest, error) {
+	c, ok := m.items[id]
+	if !ok {
+		return CorrectionRequest{}, ErrNotFound
+	}
+	if c.Status != CorrectionOpen {
+		return CorrectionRequest{}, ErrConflict
+	}
+	c.Status, c.Resolution, c.ResolvedBy, c.UpdatedAt = status, resolution, resolvedBy, time.Now()
+	m.items[id] = c
+	return c, nil
+}
+
+func (m *mockCorrectionStore) DeleteCorrection(_ context.Context, id string) error {
+	delete(m.items, id)
+	return nil
+}
+
+func TestCorrections_CreateAndResolve(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"plan": "free"}
+	cs := newMockCorrectionStore()
+	sink := &recordingSink{}
+	h := NewCorrectionsHandler(NewPreferencesHandler(store, testLogger(), HandlerOptions{}), cs, sink)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/users/{userId}/corrections", h.Create)
+	mux.HandleFunc("POST /api/v1/admin/corrections/{id}/resolve", h.AdminResolve)
+
+	body := bytes.NewBufferString(`{"key":"plan","reason":"I upgraded last week","suggestedValue":"pro"}`)
+	req := httptest.NewRequest("POST", "/api/v1/users/user1/corrections", body)
+	req = withClaims(req, "user1")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", w.Code)
+	}
+	var created CorrectionRequest
+	json.NewDecoder(w.Body).Decode(&created)
+	if created.CurrentValue != "free" || created.Status != CorrectionOpen {
+		t.Fatalf("unexpected correction: %+v", created)
+	}
+
+	admin := Claims{Subject: "support-tool", Kind: PrincipalService, Scopes: []string{ScopeAdmin}}
+	resolve := func() int {
+		body := bytes.NewBufferString(`{"status":"resolved","resolution":"plan fixed"}`)
+		req := httptest.NewRequest("POST", "/api/v1/admin/corrections/"+created.ID+"/resolve", body)
+		req = req.WithContext(context.WithValue(req.Context(), claimsKey, admin))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := resolve(); code != http.StatusOK {
+		t.Fatalf("resolve: expected 200, got %d", code)
+	}
+	if cs.items[created.ID].ResolvedBy != "support-tool" {
+		t.Fatalf("expected resolvedBy=support-tool, got %q", cs.items[created.ID].ResolvedBy)
+	}
+	if code := resolve(); code != http.StatusConflict {
+		t.Fatalf("second resolve: expected 409, got %d", code)
+	}
+
+	if len(sink.events) != 2 {
+		t.Fatalf("expected an event per transition, got %+v", sink.events)
+	}
+	if e := sink.events[0]; e.Type != EventCorrectionCreated || e.UserID != "user1" || e.Changes["plan"] != "free" || e.Correction.ID != created.ID {
+		t.Fatalf("unexpected created event %+v", e)
+	}
+	if e := sink.events[1]; e.Type != EventCorrectionResolved || e.By != "support-tool" || e.Correction.Status != CorrectionResolved {
+		t.Fatalf("unexpected resolved event %+v", e)
+	}
+}
+
+func TestCorrections_MissingPreference(t *testing.T) {
+	h := NewCorrectionsHandler(NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{}), newMockCorrectionStore())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/users/{userId}/corrections", h.Create)
+
+	body := bytes.NewBufferString(`{"key":"plan","reason":"wrong"}`)
+	req := httptest.NewRequest("POST", "/api/v1/users/user1/corrections", body)
+	req = withClaims(req, "user1")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestCorrections_RedactsSensitiveValues(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"notification_email": "a@example.com"}
+	cs := newMockCorrectionStore()
+	opts := HandlerOptions{SensitiveKeys: []string{"notification_email"}}
+	h := NewCorrectionsHandler(NewPreferencesHandler(store, testLogger(), opts), cs)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/users/{userId}/corrections", h.Create)
+
+	body := bytes.NewBufferString(`{"key":"notification_email","reason":"typo"}`)
+	req := httptest.NewRequest("POST", "/api/v1/users/user1/corrections", body)
+	req = withClaims(req, "user1")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	for _, c := range cs.items {
+		if c.CurrentValue != redactedValue {
+			t.Fatalf("expected current value redacted, got %q", c.CurrentValue)
+		}
+	}
+}
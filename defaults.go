@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultsSource supplies operator-defined default preferences.
+type DefaultsSource interface {
+	LoadDefaults(ctx context.Context) (map[string]any, error)
+}
+
+// FileDefaults reads defaults from a JSON object file (DEFAULTS_FILE).
+type FileDefaults struct {
+	Path string
+}
+
+func (f FileDefaults) LoadDefaults(context.Context) (map[string]any, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("reading defaults: %w", err)
+	}
+	defer file.Close()
+
+	var prefs map[string]any
+	if err := decodeJSON(file, &prefs); err != nil {
+		return nil, fmt.Errorf("parsing defaults: %w", err)
+	}
+	return prefs, nil
+}
+
+// Defaults caches the default preferences from a DefaultsSource, so the
+// effective view does not read them on every request.
+type Defaults struct {
+	source  DefaultsSource
+	refresh time.Duration
+	logger  *slog.Logger
+
+	mu    sync.RWMutex
+	prefs map[string]any
+	hash  string
+}
+
+// NewDefaults creates an empty cache; call Refresh before serving.
+// refresh is how often Run reloads the source; zero loads it only once.
+func NewDefaults(source DefaultsSource, refresh time.Duration, logger *slog.Logger) *Defaults {
+	return &Defaults{source: source, refresh: refresh, logger: logger, prefs: map[string]any{}}
+}
+
+// Refresh reloads the defaults. On failure the cached defaults are left
+// untouched.
+func (d *Defaults) Refresh(ctx context.Context) error {
+	prefs, err := d.source.LoadDefaults(ctx)
+	if err != nil {
+		return err
+	}
+	if prefs == nil {
+		prefs = map[string]any{}
+	}
+	// Map keys marshal sorted, so equal defaults hash equally on every
+	// instance.
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return fmt.Errorf("hashing defaults: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	d.mu.Lock()
+	d.prefs, d.hash = prefs, hex.EncodeToString(sum[:8])
+	d.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the defaults until ctx is cancelled.
+func (d *Defaults) Run(ctx context.Context) {
+	if d.refresh <= 0 {
+		return
+	}
+	ticker := time.NewTicker(d.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.Refresh(ctx); err != nil {
+				d.logger.Warn("defaults refresh failed; serving cached defaults", "error", err)
+			}
+		}
+	}
+}
+
+// Get returns the current defaults and a hash identifying them. The map is
+// shared and must not be modified.
+func (d *Defaults) Get() (map[string]any, string) {
+	if d == nil {
+		return nil, ""
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.prefs, d.hash
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// staticDefaults implements DefaultsSource for testing.
+type staticDefaults map[string]any
+
+func (s staticDefaults) LoadDefaults(context.Context) (map[string]any, error) {
+	return s, nil
+}
+
+func TestFileDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "defaults.json")
+	os.WriteFile(path, []byte(`{"theme":"system","items_per_page":25}`), 0o600)
+
+	d := NewDefaults(FileDefaults{Path: path}, 0, testLogger())
+	if err := d.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	prefs, hash := d.Get()
+	if prefs["theme"] != "system" || prefs["items_per_page"] != json.Number("25") || hash == "" {
+		t.Fatalf("unexpected defaults %v %q", prefs, hash)
+	}
+
+	os.WriteFile(path, []byte(`["theme"]`), 0o600)
+	if err := d.Refresh(context.Background()); err == nil {
+		t.Fatal("expected an error for a non-object file")
+	}
+	if prefs, _ := d.Get(); prefs["theme"] != "system" {
+		t.Fatal("expected a failed refresh to keep the cached defaults")
+	}
+}
+
+func TestGetAll_EffectiveView(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	store.versions["user1"] = 2
+	defaults := NewDefaults(staticDefaults{"theme": "system", "lang": "en"}, 0, testLogger())
+	defaults.Refresh(context.Background())
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Defaults: defaults})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+	get := func(user, query, inm string) (*httptest.ResponseRecorder, PreferencesResponse) {
+		req := httptest.NewRequest("GET", "/api/v1/users/"+user+"/preferences"+query, nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, user))
+		var resp PreferencesResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	w, resp := get("user1", "?view=effective&include=metadata", "")
+	if w.Code != http.StatusOK || resp.Preferences["theme"] != "dark" || resp.Preferences["lang"] != "en" {
+		t.Fatalf("unexpected effective view %d %+v", w.Code, resp)
+	}
+	if resp.Metadata["theme"].Source != SourceUser || resp.Metadata["lang"].Source != SourceDefault {
+		t.Fatalf("unexpected metadata %+v", resp.Metadata)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == `"2"` || etag == "" {
+		t.Fatalf("expected an effective view tag, got %q", etag)
+	}
+	if w, _ := get("user1", "?view=effective", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for the effective tag, got %d", w.Code)
+	}
+	if w, _ := get("user1", "?view=effective", `"2"`); w.Code != http.StatusOK {
+		t.Fatalf("expected the stored tag not to match the effective view, got %d", w.Code)
+	}
+
+	if _, resp := get("user1", "", ""); len(resp.Preferences) != 1 {
+		t.Fatalf("expected the stored view by default, got %v", resp.Preferences)
+	}
+	if _, resp := get("user2", "?view=effective", ""); len(resp.Preferences) != 2 || resp.Preferences["theme"] != "system" {
+		t.Fatalf("expected defaults for a user with no record, got %v", resp.Preferences)
+	}
+	if _, resp := get("user1", "?view=effective&keys=lang", ""); len(resp.Preferences) != 1 || resp.Preferences["lang"] != "en" {
+		t.Fatalf("expected defaults filtered by keys, got %v", resp.Preferences)
+	}
+	if w, _ := get("user1", "?view=merged", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown view, got %d", w.Code)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultDeltaLimit and maxDeltaLimit bound ?limit= on delta sync, in
+// history entries.
+const (
+	defaultDeltaLimit = 100
+	maxDeltaLimit     = 1000
+)
+
+// deltaCursorMargin is how far behind the clock a caught-up cursor is held.
+// Entry IDs are taken from each instance's clock after its write, so an
+// entry can land with an ID below one already handed out; holding cursors
+// back allows for writes in flight and clock skew between instances.
+const deltaCursorMargin = time.Minute
+
+// DeltaResponse is the result of a delta sync. Changes is a JSON Merge
+// Patch: applying it to the state the client held at since (or to an empty
+// map when Full is set) yields the state at Cursor. Pass Cursor as since on
+// the next call; while HasMore is set, there are further changes to fetch
+// right away. A caught-up cursor is held back by deltaCursorMargin, so the
+// last changes may be sent again; applying them twice is harmless.
+type DeltaResponse struct {
+	UserID  string         `json:"userId"`
+	Changes map[string]any `json:"changes"`
+	Full    bool           `json:"full,omitempty"`
+	Version int64          `json:"version,omitempty"`
+	Cursor  string         `json:"cursor"`
+	HasMore bool           `json:"hasMore,omitempty"`
+}
+
+// Changes serves delta sync for offline-capable clients:
+// GET .../preferences/changes?since=. since is a cursor from an earlier
+// response or an RFC 3339 time; without it the whole map is returned as a
+// full sync. Changes come from preference history, so sensitive keys are
+// never included, and a since older than the history retention is a 410
+// CURSOR_EXPIRED: the client must sync in full again.
+func (h *HistoryHandler) Changes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	limit := defaultDeltaLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxDeltaLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be 1 to %d", maxDeltaLimit))
+			return
+		}
+		limit = n
+	}
+
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		h.fullSync(w, r, userID)
+		return
+	}
+	after, at, ok := parseSince(since)
+	if !ok {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidCursor, "since must be a cursor or an RFC 3339 time")
+		return
+	}
+	if h.retention > 0 && time.Since(at) > h.retention {
+		writeErrorCode(w, http.StatusGone, ErrCodeCursorExpired, "changes since then are no longer kept; sync in full")
+		return
+	}
+
+	// One extra entry tells whether there are more.
+	entries, err := h.store.ListHistorySince(r.Context(), userID, after, limit+1)
+	if err != nil {
+		h.prefs.logger.Error("history.ListHistorySince failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve changes")
+		return
+	}
+	// Once caught up, the cursor is the margin, even if that is behind since
+	// or the last entry: an entry still landing may sort below either. It
+	// also moves quiet users' cursors forward, so they do not expire.
+	resp := DeltaResponse{UserID: userID, Changes: make(map[string]any), Cursor: encodeCursor(quietCursor())}
+	if len(entries) > limit {
+		entries, resp.HasMore = entries[:limit], true
+	}
+	for _, e := range entries {
+		for k := range e.Before {
+			if _, ok := e.After[k]; !ok {
+				resp.Changes[k] = nil
+			}
+		}
+		maps.Copy(resp.Changes, e.After)
+		resp.Version = e.Version
+	}
+	// A page with more to come must move past its entries; the final page
+	// resets the cursor to the margin.
+	if resp.HasMore {
+		resp.Cursor = encodeCursor(entries[len(entries)-1].ID)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// fullSync answers a delta sync without since with the whole map, less
+// sensitive keys, and a cursor for the next call. The cursor is taken
+// first and held back by the margin, so a write racing the read is at
+// worst sent again.
+func (h *HistoryHandler) fullSync(w http.ResponseWriter, r *http.Request, userID string) {
+	cursor := quietCursor()
+	rec, err := h.prefs.store.GetAll(r.Context(), userID)
+	if err != nil {
+		h.prefs.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve changes")
+		return
+	}
+	prefs := maps.Clone(rec.Prefs)
+	if prefs == nil {
+		prefs = make(map[string]any)
+	}
+	for _, k := range h.prefs.opts.SensitiveKeys {
+		delete(prefs, k)
+	}
+	writeJSON(w, http.StatusOK, DeltaResponse{
+		UserID:  userID,
+		Changes: prefs,
+		Full:    true,
+		Version: rec.Version,
+		Cursor:  encodeCursor(cursor),
+	})
+}
+
+// quietCursor is the cursor for a caught-up client: deltaCursorMargin
+// behind the clock.
+func quietCursor() string {
+	return newHistoryID(time.Now().Add(-deltaCursorMargin))
+}
+
+// parseSince reads since as an RFC 3339 time or a cursor, returning the
+// history entry ID to continue after and the time it stands for.
+func parseSince(since string) (after string, at time.Time, ok bool) {
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		return newHistoryID(t), t, true
+	}
+	id, err := decodeCursor(since)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	// History IDs are nanosecond timestamps (newHistoryID).
+	if len(id) < 20 {
+		return "", time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(id[:20], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return id, time.Unix(0, nanos), true
+}
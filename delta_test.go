@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHistoryHandler_Changes(t *testing.T) {
+	hist := newMemHistory()
+	store := NewHistoryRecorder(newMockStore(), hist, []string{"secret"})
+	prefs := NewPreferencesHandler(store, testLogger(), HandlerOptions{SensitiveKeys: []string{"secret"}})
+	h := NewHistoryHandler(prefs, hist, time.Hour)
+	ctx := context.Background()
+	sync := func(query string) (int, DeltaResponse) {
+		req := withClaims(httptest.NewRequest("GET", "/api/v1/users/user1/preferences/changes?"+query, nil), "user1")
+		req.SetPathValue("userId", "user1")
+		w := httptest.NewRecorder()
+		h.Changes(w, req)
+		var resp DeltaResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	// A user with no history starts from a cursor that still works later.
+	code, full := sync("")
+	if code != http.StatusOK || !full.Full || len(full.Changes) != 0 || full.Cursor == "" {
+		t.Fatalf("unexpected empty full sync %d %+v", code, full)
+	}
+	store.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark", "lang": "en", "secret": "x"}, Precondition{})
+	_, delta := sync("since=" + full.Cursor)
+	if len(delta.Changes) != 2 || delta.Changes["theme"] != "dark" || delta.Version != 1 {
+		t.Fatalf("expected the first write, got %+v", delta)
+	}
+
+	code, full = sync("")
+	if code != http.StatusOK || len(full.Changes) != 2 || full.Version != 1 {
+		t.Fatalf("expected the map without sensitive keys, got %d %+v", code, full)
+	}
+	store.Update(ctx, "user1", map[string]any{"theme": "light"}, []string{"lang"}, Precondition{})
+	store.Update(ctx, "user1", map[string]any{"volume": 3}, nil, Precondition{})
+
+	// The full sync's cursor is held back, so its write comes again before
+	// the newer ones; pages with more to come move past their entries.
+	_, delta = sync("since=" + full.Cursor + "&limit=1")
+	if !delta.HasMore || len(delta.Changes) != 2 || delta.Changes["theme"] != "dark" || delta.Version != 1 {
+		t.Fatalf("expected the full sync's entry again with more to come, got %+v", delta)
+	}
+	_, delta = sync("since=" + delta.Cursor + "&limit=1")
+	if !delta.HasMore || len(delta.Changes) != 2 || delta.Changes["theme"] != "light" || delta.Changes["lang"] != nil {
+		t.Fatalf("expected one entry with more to come, got %+v", delta)
+	}
+	_, delta = sync("since=" + delta.Cursor)
+	if delta.HasMore || len(delta.Changes) != 1 || delta.Changes["volume"] != 3.0 || delta.Version != 3 {
+		t.Fatalf("expected the remaining entry, got %+v", delta)
+	}
+
+	// Once caught up, the cursor sits the margin behind the clock, where an
+	// entry still landing cannot sort below it; recent changes come again.
+	_, at, _ := parseSince(delta.Cursor)
+	if time.Since(at) < deltaCursorMargin {
+		t.Fatalf("expected the cursor held back by the margin, got %v", at)
+	}
+	_, caughtUp := sync("since=" + delta.Cursor)
+	if caughtUp.HasMore || len(caughtUp.Changes) != 3 || caughtUp.Changes["theme"] != "light" || caughtUp.Changes["lang"] != nil || caughtUp.Version != 3 {
+		t.Fatalf("expected the recent changes again, got %+v", caughtUp)
+	}
+
+	// A time works as since too.
+	_, delta = sync("since=" + url.QueryEscape(time.Now().Add(-time.Minute).Format(time.RFC3339Nano)))
+	if len(delta.Changes) != 3 || delta.Changes["lang"] != nil {
+		t.Fatalf("expected the net changes of the last minute, got %+v", delta)
+	}
+
+	if code, _ := sync("since=" + url.QueryEscape(time.Now().Add(-2*time.Hour).Format(time.RFC3339))); code != http.StatusGone {
+		t.Fatalf("expected 410 beyond the retention, got %d", code)
+	}
+	if code, _ := sync("since=nonsense"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad cursor, got %d", code)
+	}
+}
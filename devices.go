@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// LayerDevice is the layer of device-scoped preferences, above the user's
+// own.
+const LayerDevice = "device"
+
+// DevicePreferencesResponse is returned by the device preference endpoints.
+type DevicePreferencesResponse struct {
+	UserID      string         `json:"userId"`
+	DeviceID    string         `json:"deviceId"`
+	Preferences map[string]any `json:"preferences"`
+	UpdatedAt   *time.Time     `json:"updatedAt,omitempty"`
+}
+
+// Device is one entry of a user's device list.
+type Device struct {
+	DeviceID  string     `json:"deviceId"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// DevicesResponse lists a user's devices with stored preferences.
+type DevicesResponse struct {
+	UserID  string   `json:"userId"`
+	Devices []Device `json:"devices"`
+}
+
+// DeviceResolveResponse is ResolveResponse with the device layer applied.
+type DeviceResolveResponse struct {
+	ResolveResponse
+	DeviceID string `json:"deviceId"`
+}
+
+// DevicesHandler serves per-device preferences, such as push notification
+// toggles, kept apart from the account-level map. Each device's map is a
+// preference item of its own, keyed by deviceKey; index keeps a map of the
+// user's device IDs so they can be listed and erased.
+type DevicesHandler struct {
+	prefs *PreferencesHandler
+	// device is prefs over the device store, so that quota and validation
+	// apply to each device's map as they do to the account's.
+	device *PreferencesHandler
+	index  Store
+	// layers adds the org and team layers to Resolve; nil leaves them out.
+	layers LayerStore
+}
+
+// NewDevicesHandler creates a devices handler sharing prefs' options.
+// devices holds the maps, index the per-user device lists; layers may be
+// nil.
+func NewDevicesHandler(prefs *PreferencesHandler, devices, index Store, layers LayerStore) *DevicesHandler {
+	device := *prefs
+	device.store = devices
+	return &DevicesHandler{prefs: prefs, device: &device, index: index, layers: layers}
+}
+
+// deviceKey is the item key of a device's map. Device IDs are valid
+// preference keys, which cannot contain "#", so keys are unambiguous.
+func deviceKey(userID, deviceID string) string {
+	return userID + "#" + deviceID
+}
+
+// authorize checks access as for the user's own preferences and reads the
+// device ID, writing the error response on failure.
+func (h *DevicesHandler) authorize(w http.ResponseWriter, r *http.Request) (userID, deviceID string, ok bool) {
+	if userID, ok = h.prefs.authorize(w, r); !ok {
+		return "", "", false
+	}
+	deviceID = r.PathValue("deviceId")
+	if reason := keyViolation(deviceID, nil); reason != "" {
+		writeError(w, http.StatusBadRequest, "invalid deviceId: "+reason)
+		return "", "", false
+	}
+	return userID, deviceID, true
+}
+
+// List returns the user's devices that have stored preferences, in ID
+// order.
+func (h *DevicesHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	rec, err := h.index.GetAll(r.Context(), userID)
+	if err != nil {
+		h.prefs.logger.Error("device index GetAll failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to list devices")
+		return
+	}
+	resp := DevicesResponse{UserID: userID, Devices: []Device{}}
+	for _, id := range slices.Sorted(maps.Keys(rec.Prefs)) {
+		d := Device{DeviceID: id}
+		if m, ok := rec.Meta[id]; ok && !m.UpdatedAt.IsZero() {
+			d.UpdatedAt = &m.UpdatedAt
+		}
+		resp.Devices = append(resp.Devices, d)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Get returns a device's preferences; an unknown device has an empty map.
+func (h *DevicesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, deviceID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	rec, err := h.device.store.GetAll(r.Context(), deviceKey(userID, deviceID))
+	if err != nil {
+		h.prefs.logger.Error("device GetAll failed", "error", err, "userId", userID, "deviceId", deviceID)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve preferences")
+		return
+	}
+	setValidators(w, rec)
+	if notModified(r, rec) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.writeDevice(w, userID, deviceID, rec)
+}
+
+// Replace replaces a device's preferences. It honors If-Match.
+func (h *DevicesHandler) Replace(w http.ResponseWriter, r *http.Request) {
+	userID, deviceID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	cond, ok := h.prefs.precondition(w, r)
+	if !ok {
+		return
+	}
+	var prefs map[string]any
+	if err := decodeJSON(r.Body, &prefs); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if prefs == nil {
+		prefs = map[string]any{}
+	}
+	key := deviceKey(userID, deviceID)
+	h.prefs.dropUnknownKeys(prefs)
+	h.prefs.mirrorAliases(prefs, nil)
+	if !h.prefs.validatePrefs(w, prefs) || !h.device.checkQuota(w, r, key, prefs, nil, true) || !h.register(w, r, userID, deviceID) {
+		return
+	}
+
+	rec, err := h.device.store.ReplaceAll(r.Context(), key, prefs, cond)
+	h.writeResult(w, userID, deviceID, rec, err)
+}
+
+// Patch merges into a device's preferences. With a merge patch
+// Content-Type, a null value deletes that key. It honors If-Match.
+func (h *DevicesHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	userID, deviceID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	cond, ok := h.prefs.precondition(w, r)
+	if !ok {
+		return
+	}
+	var patch map[string]any
+	if err := decodeJSON(r.Body, &patch); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	prefs := make(map[string]any, len(patch))
+	var remove []string
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for k, v := range patch {
+		if v == nil && mt == mergePatchType {
+			remove = append(remove, k)
+		} else {
+			prefs[k] = v
+		}
+	}
+	if !h.prefs.checkPatchSize(w, len(prefs)+len(remove)) {
+		return
+	}
+	h.prefs.dropUnknownKeys(prefs)
+	if len(prefs) == 0 && len(remove) == 0 {
+		writeError(w, http.StatusBadRequest, "empty preferences")
+		return
+	}
+	key := deviceKey(userID, deviceID)
+	remove = h.prefs.mirrorAliases(prefs, remove)
+	if !h.prefs.validatePrefs(w, prefs) || !h.device.checkQuota(w, r, key, prefs, remove, false) || !h.register(w, r, userID, deviceID) {
+		return
+	}
+
+	rec, err := h.device.store.Update(r.Context(), key, prefs, remove, cond)
+	h.writeResult(w, userID, deviceID, rec, err)
+}
+
+// Delete removes a device's preferences and drops it from the device list.
+func (h *DevicesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, deviceID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	if err := h.device.store.DeleteAll(r.Context(), deviceKey(userID, deviceID)); err != nil {
+		h.prefs.logger.Error("device DeleteAll failed", "error", err, "userId", userID, "deviceId", deviceID)
+		writeError(w, http.StatusInternalServerError, "failed to delete preferences")
+		return
+	}
+	if err := h.index.Delete(r.Context(), userID, deviceID); err != nil {
+		h.prefs.logger.Error("device index Delete failed", "error", err, "userId", userID, "deviceId", deviceID)
+		writeError(w, http.StatusInternalServerError, "failed to delete preferences")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Resolve merges the user's layers (see LayersHandler.Resolve) with the
+// device's preferences on top, reporting the layer that supplied each key.
+func (h *DevicesHandler) Resolve(w http.ResponseWriter, r *http.Request) {
+	userID, deviceID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	resp, err := resolveLayers(r.Context(), h.prefs, h.layers, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to resolve preferences")
+		return
+	}
+	rec, err := h.device.store.GetAll(r.Context(), deviceKey(userID, deviceID))
+	if err != nil {
+		h.prefs.logger.Error("device GetAll failed", "error", err, "userId", userID, "deviceId", deviceID)
+		writeError(w, http.StatusInternalServerError, "failed to resolve preferences")
+		return
+	}
+	for k, v := range rec.Prefs {
+		resp.Preferences[k] = ResolvedPreference{Value: v, Layer: LayerDevice}
+	}
+	writeJSON(w, http.StatusOK, DeviceResolveResponse{ResolveResponse: resp, DeviceID: deviceID})
+}
+
+// EraseUser removes all of a user's device preferences and their device
+// list, returning the number of devices removed.
+func (h *DevicesHandler) EraseUser(ctx context.Context, userID string) (int, error) {
+	rec, err := h.index.GetAll(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	for id := range rec.Prefs {
+		if err := h.device.store.DeleteAll(ctx, deviceKey(userID, id)); err != nil {
+			return 0, err
+		}
+	}
+	return len(rec.Prefs), h.index.DeleteAll(ctx, userID)
+}
+
+// register adds a device to the user's list before its first write, so
+// that a device with preferences is always listed (and erased).
+func (h *DevicesHandler) register(w http.ResponseWriter, r *http.Request, userID, deviceID string) bool {
+	if _, err := h.index.Update(r.Context(), userID, map[string]any{deviceID: true}, nil, Precondition{}); err != nil {
+		h.prefs.logger.Error("device index Update failed", "error", err, "userId", userID, "deviceId", deviceID)
+		writeError(w, http.StatusInternalServerError, "failed to save preferences")
+		return false
+	}
+	return true
+}
+
+func (h *DevicesHandler) writeResult(w http.ResponseWriter, userID, deviceID string, rec Record, err error) {
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, http.StatusPreconditionFailed, "preferences have been modified")
+		return
+	}
+	if err != nil {
+		h.prefs.logger.Error("device write failed", "error", err, "userId", userID, "deviceId", deviceID)
+		writeError(w, http.StatusInternalServerError, "failed to update preferences")
+		return
+	}
+	setValidators(w, rec)
+	h.writeDevice(w, userID, deviceID, rec)
+}
+
+func (h *DevicesHandler) writeDevice(w http.ResponseWriter, userID, deviceID string, rec Record) {
+	resp := DevicePreferencesResponse{UserID: userID, DeviceID: deviceID, Preferences: rec.Prefs}
+	if resp.Preferences == nil {
+		resp.Preferences = map[string]any{}
+	}
+	if !rec.UpdatedAt.IsZero() {
+		resp.UpdatedAt = &rec.UpdatedAt
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDevicesHandler(t *testing.T) {
+	store, devices, index, layers := newMockStore(), newMockStore(), newMockStore(), newMemLayers()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "notifications": true}
+	layers.stores[LayerOrg].prefs["acme"] = map[string]any{"lang": "en"}
+	layers.memberships["user1"] = Membership{OrgID: "acme"}
+	h := NewDevicesHandler(NewPreferencesHandler(store, testLogger(), HandlerOptions{}), devices, index, layers)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/devices", h.List)
+	mux.HandleFunc("GET /api/v1/users/{userId}/devices/{deviceId}/preferences", h.Get)
+	mux.HandleFunc("PUT /api/v1/users/{userId}/devices/{deviceId}/preferences", h.Replace)
+	mux.HandleFunc("PATCH /api/v1/users/{userId}/devices/{deviceId}/preferences", h.Patch)
+	mux.HandleFunc("DELETE /api/v1/users/{userId}/devices/{deviceId}/preferences", h.Delete)
+	mux.HandleFunc("GET /api/v1/users/{userId}/devices/{deviceId}/preferences:resolve", h.Resolve)
+	send := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	if w := send("PUT", "/api/v1/users/user1/devices/phone/preferences", "", `{"notifications":false,"fontSize":14}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w := send("PATCH", "/api/v1/users/user1/devices/phone/preferences", mergePatchType, `{"fontSize":null,"layout":"compact"}`)
+	var resp DevicePreferencesResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.DeviceID != "phone" || len(resp.Preferences) != 2 || resp.Preferences["layout"] != "compact" {
+		t.Fatalf("unexpected patch result %d %+v", w.Code, resp)
+	}
+	if store.prefs["user1"]["notifications"] != true {
+		t.Fatal("expected account preferences untouched")
+	}
+	send("PUT", "/api/v1/users/user1/devices/laptop/preferences", "", `{"layout":"wide"}`)
+
+	w = send("GET", "/api/v1/users/user1/devices", "", "")
+	var list DevicesResponse
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Devices) != 2 || list.Devices[0].DeviceID != "laptop" || list.Devices[1].DeviceID != "phone" {
+		t.Fatalf("unexpected device list %+v", list)
+	}
+
+	w = send("GET", "/api/v1/users/user1/devices/phone/preferences:resolve", "", "")
+	var resolved DeviceResolveResponse
+	json.NewDecoder(w.Body).Decode(&resolved)
+	want := map[string]ResolvedPreference{
+		"theme":         {"dark", LayerUser},
+		"notifications": {false, LayerDevice},
+		"layout":        {"compact", LayerDevice},
+		"lang":          {"en", LayerOrg},
+	}
+	if w.Code != http.StatusOK || resolved.DeviceID != "phone" || len(resolved.Preferences) != len(want) {
+		t.Fatalf("unexpected resolve result %d %+v", w.Code, resolved)
+	}
+	for k, v := range want {
+		if resolved.Preferences[k] != v {
+			t.Fatalf("%s: expected %+v, got %+v", k, v, resolved.Preferences[k])
+		}
+	}
+
+	if w := send("DELETE", "/api/v1/users/user1/devices/laptop/preferences", "", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if _, ok := index.prefs["user1"]["laptop"]; ok {
+		t.Fatal("expected the device dropped from the list")
+	}
+	if w := send("GET", "/api/v1/users/user1/devices/a%23b/preferences", "", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid device ID, got %d", w.Code)
+	}
+
+	n, err := h.EraseUser(context.Background(), "user1")
+	if err != nil || n != 1 {
+		t.Fatalf("expected one device erased, got %d %v", n, err)
+	}
+	if len(devices.prefs) != 0 || len(index.prefs) != 0 {
+		t.Fatalf("expected device data erased, got %v %v", devices.prefs, index.prefs)
+	}
+}
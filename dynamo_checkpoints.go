@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Stream worker checkpoints share the preferences table under
+// PK = STREAMSHARD#{shardId}. A stream keeps records for 24 hours, so a
+// checkpoint is useless two days after its last write; expiresAt (epoch
+// seconds, the table's TTL attribute) lets DynamoDB delete it then.
+const (
+	streamShardPrefix   = "STREAMSHARD#"
+	streamCheckpointTTL = 48 * time.Hour
+)
+
+func (s *DynamoStore) GetCheckpoint(ctx context.Context, shardID string) (ShardCheckpoint, bool, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: streamShardPrefix + shardID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return ShardCheckpoint{}, false, fmt.Errorf("GetItem (stream checkpoint): %w", err)
+	}
+	if out.Item == nil {
+		return ShardCheckpoint{}, false, nil
+	}
+	var cp ShardCheckpoint
+	if v, ok := out.Item["sequence"].(*types.AttributeValueMemberS); ok {
+		cp.Sequence = v.Value
+	}
+	if v, ok := out.Item["done"].(*types.AttributeValueMemberBOOL); ok {
+		cp.Done = v.Value
+	}
+	return cp, true, nil
+}
+
+func (s *DynamoStore) PutCheckpoint(ctx context.Context, shardID string, cp ShardCheckpoint) error {
+	item := map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: streamShardPrefix + shardID},
+		"done":      &types.AttributeValueMemberBOOL{Value: cp.Done},
+		"expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(streamCheckpointTTL).Unix(), 10)},
+	}
+	if cp.Sequence != "" {
+		item["sequence"] = &types.AttributeValueMemberS{Value: cp.Sequence}
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &s.tableName,
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("PutItem (stream checkpoint): %w", err)
+	}
+	return nil
+}
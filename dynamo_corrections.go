@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const correctionPrefix = "CORRECTION#"
+
+func (s *DynamoStore) CreateCorrection(ctx context.Context, c CorrectionRequest) error {
+	current, err := marshalValue(c.CurrentValue)
+	if err != nil {
+		return fmt.Errorf("currentValue: %w", err)
+	}
+	item := map[string]types.AttributeValue{
+		"PK":             &types.AttributeValueMemberS{Value: correctionPrefix + c.ID},
+		"id":             &types.AttributeValueMemberS{Value: c.ID},
+		"userId":         &types.AttributeValueMemberS{Value: c.UserID},
+		"key":            &types.AttributeValueMemberS{Value: c.Key},
+		"currentValue":   current,
+		"suggestedValue": &types.AttributeValueMemberS{Value: c.SuggestedValue},
+		"reason":         &types.AttributeValueMemberS{Value: c.Reason},
+		"status":         &types.AttributeValueMemberS{Value: c.Status},
+		"createdAt":      &types.AttributeValueMemberS{Value: c.CreatedAt.Format(time.RFC3339)},
+		"updatedAt":      &types.AttributeValueMemberS{Value: c.UpdatedAt.Format(time.RFC3339)},
+	}
+	withIndexKeys(item)
+
+	cond := "attribute_not_exists(PK)"
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &s.tableName,
+		Item:                item,
+		ConditionExpression: &cond,
+	})
+	if err != nil {
+		return fmt.Errorf("PutItem (correction): %w", err)
+	}
+
+	return nil
+}
+
+// ListCorrections queries a user's requests in listIndex, or those in a
+// status in queueIndex. Listing every request takes a query per status.
+func (s *DynamoStore) ListCorrections(ctx context.Context, userID string, status string) ([]CorrectionRequest, error) {
+	if userID != "" {
+		input := &dynamodb.QueryInput{
+			TableName:                &s.tableName,
+			IndexName:                aws.String(listIndex),
+			KeyConditionExpression:   aws.String("#pk = :pk"),
+			ExpressionAttributeNames: map[string]string{"#pk": listIndexPK},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: correctionUserPartition + userID},
+			},
+		}
+		if status != "" {
+			input.FilterExpression = aws.String("#status = :status")
+			input.ExpressionAttributeNames["#status"] = "status"
+			input.ExpressionAttributeValues[":status"] = &types.AttributeValueMemberS{Value: status}
+		}
+		return s.queryCorrections(ctx, input)
+	}
+
+	statuses := []string{status}
+	if status == "" {
+		statuses = []string{CorrectionOpen, CorrectionResolved, CorrectionRejected}
+	}
+	var out []CorrectionRequest
+	for _, st := range statuses {
+		page, err := s.queryCorrections(ctx, &dynamodb.QueryInput{
+			TableName:                &s.tableName,
+			IndexName:                aws.String(queueIndex),
+			KeyConditionExpression:   aws.String("#pk = :pk"),
+			ExpressionAttributeNames: map[string]string{"#pk": queueIndexPK},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: correctionQueuePartition + st},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, page...)
+	}
+	if len(statuses) > 1 {
+		slices.SortStableFunc(out, func(a, b CorrectionRequest) int {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		})
+	}
+	return out, nil
+}
+
+// queryCorrections returns every correction in, oldest first.
+func (s *DynamoStore) queryCorrections(ctx context.Context, in *dynamodb.QueryInput) ([]CorrectionRequest, error) {
+	var out []CorrectionRequest
+	paginator := dynamodb.NewQueryPaginator(s.client, in)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Query (corrections): %w", err)
+		}
+		for _, item := range page.Items {
+			out = append(out, unmarshalCorrection(item))
+		}
+	}
+	return out, nil
+}
+
+func (s *DynamoStore) DeleteCorrection(ctx context.Context, id string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: correctionPrefix + id},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("DeleteItem (correction): %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoStore) ResolveCorrection(ctx context.Context, id string, status string, resolution string, resolvedBy string) (CorrectionRequest, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	// The request moves to its new status's queueIndex partition.
+	updateExpr := "SET #status = :status, resolution = :resolution, resolvedBy = :resolvedBy, updatedAt = :now, #queue = :queue"
+	cond := "attribute_exists(PK) AND #status = :open"
+
+	out, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: correctionPrefix + id},
+		},
+		UpdateExpression:    &updateExpr,
+		ConditionExpression: &cond,
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+			"#queue":  queueIndexPK,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":     &types.AttributeValueMemberS{Value: status},
+			":resolution": &types.AttributeValueMemberS{Value: resolution},
+			":resolvedBy": &types.AttributeValueMemberS{Value: resolvedBy},
+			":now":        &types.AttributeValueMemberS{Value: now},
+			":open":       &types.AttributeValueMemberS{Value: CorrectionOpen},
+			":queue":      &types.AttributeValueMemberS{Value: correctionQueuePartition + status},
+		},
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			if len(ccf.Item) == 0 {
+				return CorrectionRequest{}, ErrNotFound
+			}
+			return CorrectionRequest{}, ErrConflict
+		}
+		return CorrectionRequest{}, fmt.Errorf("UpdateItem (resolve correction): %w", err)
+	}
+
+	return unmarshalCorrection(out.Attributes), nil
+}
+
+// unmarshalCorrection converts a correction item back into its model.
+func unmarshalCorrection(item map[string]types.AttributeValue) CorrectionRequest {
+	str := func(name string) string {
+		if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	created, _ := time.Parse(time.RFC3339, str("createdAt"))
+	updated, _ := time.Parse(time.RFC3339, str("updatedAt"))
+	current, _ := unmarshalValue(item["currentValue"])
+
+	return CorrectionRequest{
+		ID:             str("id"),
+		UserID:         str("userId"),
+		Key:            str("key"),
+		CurrentValue:   current,
+		SuggestedValue: str("suggestedValue"),
+		Reason:         str("reason"),
+		Status:         str("status"),
+		Resolution:     str("resolution"),
+		ResolvedBy:     str("resolvedBy"),
+		CreatedAt:      created,
+		UpdatedAt:      updated,
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// defaultsPK is the item holding operator-defined default preferences, in
+// the same preferences map layout as a user item.
+const defaultsPK = "DEFAULTS#global"
+
+// LoadDefaults reads the defaults item; a missing item means no defaults.
+func (s *DynamoStore) LoadDefaults(ctx context.Context) (map[string]any, error) {
+	projection := "preferences"
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: defaultsPK},
+		},
+		ProjectionExpression: &projection,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetItem (defaults): %w", err)
+	}
+	return unmarshalPrefs(out.Item)
+}
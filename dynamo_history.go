@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoHistory implements HistoryStore in its own table, keyed by PK =
+// USER#{userId} and SK = entry ID. Entries carry an expiresAt epoch-seconds
+// attribute for DynamoDB TTL; since TTL deletion lags, expired entries are
+// also filtered out of reads.
+type DynamoHistory struct {
+	client    *dynamodb.Client
+	tableName string
+	retention time.Duration
+}
+
+// NewDynamoHistory returns a history store sharing store's client.
+func NewDynamoHistory(store *DynamoStore, tableName string, retention time.Duration) *DynamoHistory {
+	return &DynamoHistory{client: store.client, tableName: tableName, retention: retention}
+}
+
+func (h *DynamoHistory) AppendHistory(ctx context.Context, userID string, e HistoryEntry) error {
+	before, err := marshalValue(e.Before)
+	if err != nil {
+		return fmt.Errorf("before: %w", err)
+	}
+	after, err := marshalValue(e.After)
+	if err != nil {
+		return fmt.Errorf("after: %w", err)
+	}
+	snapshot, err := marshalValue(e.Snapshot)
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	item := map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: "USER#" + userID},
+		"SK":        &types.AttributeValueMemberS{Value: e.ID},
+		"version":   &types.AttributeValueMemberN{Value: strconv.FormatInt(e.Version, 10)},
+		"op":        &types.AttributeValueMemberS{Value: e.Op},
+		"at":        &types.AttributeValueMemberS{Value: e.At.Format(time.RFC3339Nano)},
+		"before":    before,
+		"after":     after,
+		"snapshot":  snapshot,
+		"expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(e.At.Add(h.retention).Unix(), 10)},
+	}
+	if e.By != "" {
+		item["by"] = &types.AttributeValueMemberS{Value: e.By}
+	}
+
+	if _, err := h.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: &h.tableName, Item: item}); err != nil {
+		return fmt.Errorf("PutItem (history): %w", err)
+	}
+	return nil
+}
+
+func (h *DynamoHistory) ListHistory(ctx context.Context, userID string, limit int, before string) ([]HistoryEntry, error) {
+	values := map[string]types.AttributeValue{
+		":pk":  &types.AttributeValueMemberS{Value: "USER#" + userID},
+		":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	keyCond := "PK = :pk"
+	if before != "" {
+		keyCond += " AND SK < :before"
+		values[":before"] = &types.AttributeValueMemberS{Value: before}
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 &h.tableName,
+		KeyConditionExpression:    &keyCond,
+		FilterExpression:          aws.String("expiresAt > :now"),
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(int32(limit)),
+	}
+
+	// The filter is applied after Limit, so page until enough entries pass it.
+	var out []HistoryEntry
+	paginator := dynamodb.NewQueryPaginator(h.client, input)
+	for paginator.HasMorePages() && len(out) < limit {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Query (history): %w", err)
+		}
+		for _, item := range page.Items {
+			out = append(out, unmarshalHistoryEntry(item))
+		}
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (h *DynamoHistory) ListHistorySince(ctx context.Context, userID string, after string, limit int) ([]HistoryEntry, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              &h.tableName,
+		KeyConditionExpression: aws.String("PK = :pk AND SK > :after"),
+		FilterExpression:       aws.String("expiresAt > :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":    &types.AttributeValueMemberS{Value: "USER#" + userID},
+			":after": &types.AttributeValueMemberS{Value: after},
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+		ScanIndexForward: aws.Bool(true),
+		Limit:            aws.Int32(int32(limit)),
+	}
+
+	// As in ListHistory, page until enough entries pass the filter.
+	var out []HistoryEntry
+	paginator := dynamodb.NewQueryPaginator(h.client, input)
+	for paginator.HasMorePages() && len(out) < limit {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Query (history): %w", err)
+		}
+		for _, item := range page.Items {
+			out = append(out, unmarshalHistoryEntry(item))
+		}
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (h *DynamoHistory) GetHistory(ctx context.Context, userID string, id string) (HistoryEntry, error) {
+	out, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &h.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "USER#" + userID},
+			"SK": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return HistoryEntry{}, fmt.Errorf("GetItem (history): %w", err)
+	}
+	if out.Item == nil {
+		return HistoryEntry{}, ErrNotFound
+	}
+	if exp, ok := out.Item["expiresAt"].(*types.AttributeValueMemberN); ok {
+		if n, _ := strconv.ParseInt(exp.Value, 10, 64); n <= time.Now().Unix() {
+			return HistoryEntry{}, ErrNotFound
+		}
+	}
+	return unmarshalHistoryEntry(out.Item), nil
+}
+
+// DeleteHistory deletes the user's entries in batches, expired or not.
+func (h *DynamoHistory) DeleteHistory(ctx context.Context, userID string) (int, error) {
+	pk := &types.AttributeValueMemberS{Value: "USER#" + userID}
+	input := &dynamodb.QueryInput{
+		TableName:                 &h.tableName,
+		KeyConditionExpression:    aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": pk},
+		ProjectionExpression:      aws.String("SK"),
+	}
+
+	var requests []types.WriteRequest
+	paginator := dynamodb.NewQueryPaginator(h.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("Query (history): %w", err)
+		}
+		for _, item := range page.Items {
+			requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{
+				Key: map[string]types.AttributeValue{"PK": pk, "SK": item["SK"]},
+			}})
+		}
+	}
+
+	for batch := range slices.Chunk(requests, 25) {
+		pending := map[string][]types.WriteRequest{h.tableName: batch}
+		for backoff := 50 * time.Millisecond; ; backoff *= 2 {
+			out, err := h.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return 0, fmt.Errorf("BatchWriteItem (history): %w", err)
+			}
+			if pending = out.UnprocessedItems; len(pending) == 0 {
+				break
+			}
+			// Unprocessed items mean the table is throttling.
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+	}
+	return len(requests), nil
+}
+
+// unmarshalHistoryEntry converts a history item back into its model.
+func unmarshalHistoryEntry(item map[string]types.AttributeValue) HistoryEntry {
+	str := func(name string) string {
+		if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	prefs := func(name string) map[string]any {
+		v, _ := unmarshalValue(item[name])
+		m, _ := v.(map[string]any)
+		if m == nil {
+			m = map[string]any{}
+		}
+		return m
+	}
+
+	e := HistoryEntry{
+		ID:       str("SK"),
+		Op:       str("op"),
+		By:       str("by"),
+		Before:   prefs("before"),
+		After:    prefs("after"),
+		Snapshot: prefs("snapshot"),
+	}
+	e.At, _ = time.Parse(time.RFC3339Nano, str("at"))
+	if v, ok := item["version"].(*types.AttributeValueMemberN); ok {
+		e.Version, _ = strconv.ParseInt(v.Value, 10, 64)
+	}
+	return e
+}
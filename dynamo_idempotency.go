@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Idempotency results share the preferences table under
+// PK = IDEMPOTENCY#{sub}#{key}. expiresAt (epoch seconds) is the table's TTL
+// attribute; since TTL deletion lags, expired items are treated as absent.
+// Completed results are in their user's userIndex partition.
+const idempotencyPrefix = "IDEMPOTENCY#"
+
+func (s *DynamoStore) ClaimIdempotencyKey(ctx context.Context, key, fingerprint string, lockUntil time.Time) (IdempotentResult, bool, error) {
+	cond := "attribute_not_exists(PK) OR expiresAt < :now"
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &s.tableName,
+		Item: map[string]types.AttributeValue{
+			"PK":          &types.AttributeValueMemberS{Value: idempotencyPrefix + key},
+			"fingerprint": &types.AttributeValueMemberS{Value: fingerprint},
+			"expiresAt":   &types.AttributeValueMemberN{Value: strconv.FormatInt(lockUntil.Unix(), 10)},
+		},
+		ConditionExpression: &cond,
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	if err == nil {
+		return IdempotentResult{}, true, nil
+	}
+	var ccf *types.ConditionalCheckFailedException
+	if !errors.As(err, &ccf) {
+		return IdempotentResult{}, false, fmt.Errorf("PutItem (idempotency): %w", err)
+	}
+	return unmarshalIdempotentResult(ccf.Item), false, nil
+}
+
+func (s *DynamoStore) CompleteIdempotencyKey(ctx context.Context, key string, res IdempotentResult, expires time.Time) error {
+	header := make(map[string]types.AttributeValue, len(res.Header))
+	for k, v := range res.Header {
+		l := make([]types.AttributeValue, len(v))
+		for i, e := range v {
+			l[i] = &types.AttributeValueMemberS{Value: e}
+		}
+		header[k] = &types.AttributeValueMemberL{Value: l}
+	}
+	item := map[string]types.AttributeValue{
+		"PK":          &types.AttributeValueMemberS{Value: idempotencyPrefix + key},
+		"fingerprint": &types.AttributeValueMemberS{Value: res.Fingerprint},
+		"status":      &types.AttributeValueMemberN{Value: strconv.Itoa(res.Status)},
+		"header":      &types.AttributeValueMemberM{Value: header},
+		"expiresAt":   &types.AttributeValueMemberN{Value: strconv.FormatInt(expires.Unix(), 10)},
+	}
+	if len(res.Body) > 0 {
+		item["body"] = &types.AttributeValueMemberB{Value: res.Body}
+	}
+	if res.UserID != "" {
+		item["userId"] = &types.AttributeValueMemberS{Value: res.UserID}
+	}
+
+	if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: &s.tableName, Item: withIndexKeys(item)}); err != nil {
+		return fmt.Errorf("PutItem (idempotency): %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: idempotencyPrefix + key},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("DeleteItem (idempotency): %w", err)
+	}
+	return nil
+}
+
+// unmarshalIdempotentResult converts an idempotency item back into its
+// model. Items without a status are claims still in progress.
+func unmarshalIdempotentResult(item map[string]types.AttributeValue) IdempotentResult {
+	var res IdempotentResult
+	if v, ok := item["fingerprint"].(*types.AttributeValueMemberS); ok {
+		res.Fingerprint = v.Value
+	}
+	if v, ok := item["status"].(*types.AttributeValueMemberN); ok {
+		res.Status, _ = strconv.Atoi(v.Value)
+		res.Done = true
+	}
+	if v, ok := item["header"].(*types.AttributeValueMemberM); ok {
+		res.Header = http.Header{}
+		for k, av := range v.Value {
+			l, _ := av.(*types.AttributeValueMemberL)
+			if l == nil {
+				continue
+			}
+			for _, e := range l.Value {
+				if s, ok := e.(*types.AttributeValueMemberS); ok {
+					res.Header[k] = append(res.Header[k], s.Value)
+				}
+			}
+		}
+	}
+	if v, ok := item["body"].(*types.AttributeValueMemberB); ok {
+		res.Body = v.Value
+	}
+	if v, ok := item["userId"].(*types.AttributeValueMemberS); ok {
+		res.UserID = v.Value
+	}
+	return res
+}
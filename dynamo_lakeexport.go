@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ScanRecords reads one segment of a parallel scan over the user items.
+// Items of other kinds sharing the table are filtered out by the scan.
+func (s *DynamoStore) ScanRecords(ctx context.Context, segment, segments int, fn func(userID string, rec Record) error) error {
+	input := &dynamodb.ScanInput{
+		TableName:        &s.tableName,
+		FilterExpression: aws.String("begins_with(PK, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: s.prefix},
+		},
+		Segment:       aws.Int32(int32(segment)),
+		TotalSegments: aws.Int32(int32(segments)),
+	}
+
+	paginator := dynamodb.NewScanPaginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("Scan (lake export): %w", err)
+		}
+		for _, item := range page.Items {
+			pk, _ := item["PK"].(*types.AttributeValueMemberS)
+			if pk == nil {
+				continue
+			}
+			rec, err := unmarshalRecord(item)
+			if err != nil {
+				return fmt.Errorf("item %s: %w", pk.Value, err)
+			}
+			if err := fn(strings.TrimPrefix(pk.Value, s.prefix), rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
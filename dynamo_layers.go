@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Org and team layers share the table as preference items under
+// PK = ORG#{id} and TEAM#{id}; memberships live under MEMBERSHIP#{userId}.
+// The stored preference schema uses the same layout under SCHEMA#global,
+// soft-deleted maps under DELETED#{userId}, undo snapshots under
+// UNDO#{userId}, device preferences under DEVICE#{userId}#{deviceId} and
+// each user's device list under DEVICES#{userId}.
+const membershipPrefix = "MEMBERSHIP#"
+
+// LayerPreferences returns a store for the org or team layer, sharing s's
+// client and table.
+func (s *DynamoStore) LayerPreferences(kind string) Store {
+	layer := *s
+	layer.prefix = strings.ToUpper(kind) + "#"
+	return &layer
+}
+
+// SchemaPreferences returns a store for the preference schema managed
+// through the admin API, kept as a preference item under SCHEMA#global.
+func (s *DynamoStore) SchemaPreferences() Store {
+	schema := *s
+	schema.prefix = "SCHEMA#"
+	return &schema
+}
+
+// TrashPreferences returns a store for preference maps removed by a soft
+// DeleteAll, kept under DELETED#{userId} and expired by the table's TTL
+// after retention.
+func (s *DynamoStore) TrashPreferences(retention time.Duration) Store {
+	trash := *s
+	trash.prefix = "DELETED#"
+	trash.ttl = retention
+	return &trash
+}
+
+// UndoSnapshots returns a store for the maps replaced by each user's last
+// DeleteAll or ReplaceAll, kept under UNDO#{userId} and expired by the
+// table's TTL after window.
+func (s *DynamoStore) UndoSnapshots(window time.Duration) Store {
+	undo := *s
+	undo.prefix = "UNDO#"
+	undo.ttl = window
+	return &undo
+}
+
+// DevicePreferences returns a store for device-scoped preferences, keyed by
+// deviceKey.
+func (s *DynamoStore) DevicePreferences() Store {
+	devices := *s
+	devices.prefix = "DEVICE#"
+	return &devices
+}
+
+// DeviceIndex returns a store for each user's device list, a preference
+// map whose keys are device IDs.
+func (s *DynamoStore) DeviceIndex() Store {
+	index := *s
+	index.prefix = "DEVICES#"
+	return &index
+}
+
+func (s *DynamoStore) GetMembership(ctx context.Context, userID string) (Membership, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: membershipPrefix + userID},
+		},
+	})
+	if err != nil {
+		return Membership{}, fmt.Errorf("GetItem (membership): %w", err)
+	}
+	var m Membership
+	if v, ok := out.Item["orgId"].(*types.AttributeValueMemberS); ok {
+		m.OrgID = v.Value
+	}
+	if v, ok := out.Item["teamId"].(*types.AttributeValueMemberS); ok {
+		m.TeamID = v.Value
+	}
+	return m, nil
+}
+
+func (s *DynamoStore) PutMembership(ctx context.Context, userID string, m Membership) error {
+	if m == (Membership{}) {
+		return s.DeleteMembership(ctx, userID)
+	}
+
+	item := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: membershipPrefix + userID},
+	}
+	if m.OrgID != "" {
+		item["orgId"] = &types.AttributeValueMemberS{Value: m.OrgID}
+	}
+	if m.TeamID != "" {
+		item["teamId"] = &types.AttributeValueMemberS{Value: m.TeamID}
+	}
+	if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: &s.tableName, Item: item}); err != nil {
+		return fmt.Errorf("PutItem (membership): %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoStore) DeleteMembership(ctx context.Context, userID string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: membershipPrefix + userID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("DeleteItem (membership): %w", err)
+	}
+	return nil
+}
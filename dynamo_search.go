@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxSearchPages bounds the scan pages read for one search request, so a
+// selective query over a large table returns a partial page with a cursor
+// rather than outliving the request timeout.
+const maxSearchPages = 10
+
+// SearchUsers scans the table with a filter on the key; there is no index on
+// preference values. The cursor is the partition key the scan stopped at,
+// which, the table having no sort key, is a valid ExclusiveStartKey.
+func (s *DynamoStore) SearchUsers(ctx context.Context, q SearchQuery, limit int, after string) ([]SearchMatch, string, error) {
+	exprValues := map[string]types.AttributeValue{
+		":prefix": &types.AttributeValueMemberS{Value: s.prefix},
+	}
+	filter := "begins_with(PK, :prefix)"
+	projection := "PK"
+	// DynamoDB rejects unused expression attribute names.
+	var exprNames map[string]string
+	if q.Key != "" {
+		exprNames = map[string]string{"#k": q.Key}
+		filter += " AND attribute_exists(preferences.#k)"
+		projection += ", preferences.#k"
+	}
+	if len(q.Values) > 0 {
+		var alts []string
+		for i, v := range q.Values {
+			av, err := marshalValue(v)
+			if err != nil {
+				return nil, "", fmt.Errorf("search value: %w", err)
+			}
+			name := fmt.Sprintf(":v%d", i)
+			exprValues[name] = av
+			alts = append(alts, "preferences.#k = "+name)
+		}
+		filter += " AND (" + strings.Join(alts, " OR ") + ")"
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:                 &s.tableName,
+		FilterExpression:          &filter,
+		ProjectionExpression:      &projection,
+		ExpressionAttributeNames:  exprNames,
+		ExpressionAttributeValues: exprValues,
+	}
+	if after != "" {
+		input.ExclusiveStartKey = map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: after},
+		}
+	}
+
+	var matches []SearchMatch
+	for range maxSearchPages {
+		out, err := s.client.Scan(ctx, input)
+		if err != nil {
+			return nil, "", fmt.Errorf("Scan (search): %w", err)
+		}
+		for _, item := range out.Items {
+			pk, _ := item["PK"].(*types.AttributeValueMemberS)
+			if pk == nil {
+				continue
+			}
+			match := SearchMatch{UserID: strings.TrimPrefix(pk.Value, s.prefix)}
+			if q.Key != "" {
+				prefs, err := unmarshalPrefs(item)
+				if err != nil {
+					return nil, "", err
+				}
+				match.Value = prefs[q.Key]
+			}
+			matches = append(matches, match)
+			if len(matches) == limit {
+				return matches, pk.Value, nil
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return matches, "", nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+	var next string
+	if pk, ok := input.ExclusiveStartKey["PK"].(*types.AttributeValueMemberS); ok {
+		next = pk.Value
+	}
+	return matches, next, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// PreferenceStats scans every user item. Items of other kinds sharing the
+// table are filtered out by the scan.
+func (s *DynamoStore) PreferenceStats(ctx context.Context) (PreferenceStats, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            &s.tableName,
+		FilterExpression:     aws.String("begins_with(PK, :prefix)"),
+		ProjectionExpression: aws.String("PK, preferences"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: s.prefix},
+		},
+	}
+
+	var stats PreferenceStats
+	paginator := dynamodb.NewScanPaginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return PreferenceStats{}, fmt.Errorf("Scan (stats): %w", err)
+		}
+		for _, item := range page.Items {
+			pk, _ := item["PK"].(*types.AttributeValueMemberS)
+			prefs, err := unmarshalPrefs(item)
+			if pk == nil || err != nil {
+				continue
+			}
+			stats.add(strings.TrimPrefix(pk.Value, s.prefix), prefs)
+		}
+	}
+	return stats, nil
+}
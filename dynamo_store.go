@@ -2,18 +2,30 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
 )
 
 // DynamoStore implements Store using DynamoDB.
 type DynamoStore struct {
 	client    *dynamodb.Client
 	tableName string
+	// prefix starts every partition key; "USER#" except for the org and
+	// team layer stores (see LayerPreferences).
+	prefix string
+	// ttl, when set, makes ReplaceAll set expiresAt so DynamoDB deletes the
+	// item; see TrashPreferences.
+	ttl time.Duration
 }
 
 // NewDynamoStore creates a DynamoDB client and returns a DynamoStore.
@@ -35,14 +47,15 @@ func NewDynamoStore(ctx context.Context, cfg Config) (*DynamoStore, error) {
 	return &DynamoStore{
 		client:    client,
 		tableName: cfg.DynamoTableName,
+		prefix:    "USER#",
 	}, nil
 }
 
 func (s *DynamoStore) pk(userID string) string {
-	return "USER#" + userID
+	return s.prefix + userID
 }
 
-func (s *DynamoStore) GetAll(ctx context.Context, userID string) (map[string]string, error) {
+func (s *DynamoStore) GetAll(ctx context.Context, userID string) (Record, error) {
 	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: &s.tableName,
 		Key: map[string]types.AttributeValue{
@@ -50,59 +63,253 @@ func (s *DynamoStore) GetAll(ctx context.Context, userID string) (map[string]str
 		},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("GetItem: %w", err)
+		return Record{}, fmt.Errorf("GetItem: %w", err)
 	}
 
 	if out.Item == nil {
-		return nil, nil
+		return Record{}, nil
 	}
 
-	return unmarshalPrefs(out.Item)
+	return unmarshalRecord(out.Item)
 }
 
-func (s *DynamoStore) Get(ctx context.Context, userID string, key string) (string, bool, error) {
-	prefs, err := s.GetAll(ctx, userID)
+// Get fetches a single preference with a projection expression so only that
+// map entry and the validators are read off the wire, then pulls the value
+// out without building the full preferences map or the per-key metadata.
+func (s *DynamoStore) Get(ctx context.Context, userID string, key string) (any, bool, Record, error) {
+	projection := "preferences.#k, #ver, gen, updatedAt"
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: s.pk(userID)},
+		},
+		ProjectionExpression:     &projection,
+		ExpressionAttributeNames: map[string]string{"#k": key, "#ver": "version"},
+	})
 	if err != nil {
-		return "", false, err
+		return nil, false, Record{}, fmt.Errorf("GetItem (projection): %w", err)
 	}
 
-	val, found := prefs[key]
-	return val, found, nil
+	prefsMap, ok := out.Item["preferences"].(*types.AttributeValueMemberM)
+	if !ok {
+		return nil, false, Record{}, nil
+	}
+	v, ok := unmarshalValue(prefsMap.Value[key])
+	if !ok {
+		return nil, false, Record{}, nil
+	}
+	var rec Record
+	if nv, ok := out.Item["version"].(*types.AttributeValueMemberN); ok {
+		if rec.Version, err = strconv.ParseInt(nv.Value, 10, 64); err != nil {
+			return nil, false, Record{}, fmt.Errorf("invalid version attribute: %w", err)
+		}
+	}
+	if sv, ok := out.Item["gen"].(*types.AttributeValueMemberS); ok {
+		rec.Generation = sv.Value
+	}
+	if sv, ok := out.Item["updatedAt"].(*types.AttributeValueMemberS); ok {
+		if rec.UpdatedAt, err = time.Parse(time.RFC3339, sv.Value); err != nil {
+			return nil, false, Record{}, fmt.Errorf("invalid updatedAt attribute: %w", err)
+		}
+	}
+	return v, true, rec, nil
 }
 
-func (s *DynamoStore) ReplaceAll(ctx context.Context, userID string, prefs map[string]string) error {
+// GetKeys projects just the requested map entries and their per-key
+// metadata, plus the version and timestamps.
+func (s *DynamoStore) GetKeys(ctx context.Context, userID string, keys []string) (Record, error) {
+	names := map[string]string{"#ver": "version"}
+	projection := "PK, #ver, gen, createdAt, updatedAt"
+	for i, k := range keys {
+		ph := fmt.Sprintf("#k%d", i)
+		names[ph] = k
+		projection += ", preferences." + ph + ", meta." + ph
+	}
+
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: s.pk(userID)},
+		},
+		ProjectionExpression:     &projection,
+		ExpressionAttributeNames: names,
+	})
+	if err != nil {
+		return Record{}, fmt.Errorf("GetItem (projection): %w", err)
+	}
+
+	if out.Item == nil {
+		return Record{}, nil
+	}
+
+	return unmarshalRecord(out.Item)
+}
+
+// Stat projects the version and timestamps, plus the key's entry when one is
+// given, since DynamoDB cannot test a map entry for existence without
+// reading it.
+func (s *DynamoStore) Stat(ctx context.Context, userID string, key string) (Record, bool, error) {
+	names := map[string]string{"#ver": "version"}
+	projection := "PK, #ver, gen, createdAt, updatedAt"
+	if key != "" {
+		names["#k"] = key
+		projection += ", preferences.#k"
+	}
+
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: s.pk(userID)},
+		},
+		ProjectionExpression:     &projection,
+		ExpressionAttributeNames: names,
+	})
+	if err != nil {
+		return Record{}, false, fmt.Errorf("GetItem (projection): %w", err)
+	}
+	if out.Item == nil {
+		return Record{}, false, nil
+	}
+
+	rec, err := unmarshalRecord(out.Item)
+	if err != nil {
+		return Record{}, false, err
+	}
+	found := key == ""
+	if !found {
+		_, found = rec.Prefs[key]
+	}
+	rec.Prefs = map[string]any{}
+	rec.Meta = nil
+	return rec, found, nil
+}
+
+// batchGetLimit is the most keys one BatchGetItem request may carry.
+const batchGetLimit = 100
+
+// BatchGet issues BatchGetItem in chunks of batchGetLimit, retrying keys
+// DynamoDB leaves unprocessed (under throttling) with backoff.
+func (s *DynamoStore) BatchGet(ctx context.Context, userIDs []string) (map[string]Record, error) {
+	recs := make(map[string]Record, len(userIDs))
+	for chunk := range slices.Chunk(userIDs, batchGetLimit) {
+		keys := make([]map[string]types.AttributeValue, len(chunk))
+		for i, id := range chunk {
+			keys[i] = map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: s.pk(id)},
+			}
+		}
+
+		request := map[string]types.KeysAndAttributes{s.tableName: {Keys: keys}}
+		backoff := 50 * time.Millisecond
+		for attempt := 0; len(request) > 0; attempt++ {
+			if attempt > 0 {
+				if attempt == 5 {
+					return nil, fmt.Errorf("BatchGetItem: %d keys still unprocessed", len(request[s.tableName].Keys))
+				}
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+			}
+
+			out, err := s.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, fmt.Errorf("BatchGetItem: %w", err)
+			}
+			for _, item := range out.Responses[s.tableName] {
+				pk, _ := item["PK"].(*types.AttributeValueMemberS)
+				if pk == nil {
+					continue
+				}
+				rec, err := unmarshalRecord(item)
+				if err != nil {
+					return nil, err
+				}
+				recs[strings.TrimPrefix(pk.Value, s.prefix)] = rec
+			}
+			request = out.UnprocessedKeys
+		}
+	}
+	return recs, nil
+}
+
+// ReplaceAll overwrites the preferences map. It is an UpdateItem rather than
+// a PutItem so the version can be incremented atomically and createdAt kept.
+func (s *DynamoStore) ReplaceAll(ctx context.Context, userID string, prefs map[string]any, cond Precondition) (Record, error) {
 	now := time.Now().UTC().Format(time.RFC3339)
 
+	keyMeta := marshalKeyMeta(now, writerFrom(ctx))
 	prefsMap := make(map[string]types.AttributeValue, len(prefs))
+	metaMap := make(map[string]types.AttributeValue, len(prefs))
 	for k, v := range prefs {
-		prefsMap[k] = &types.AttributeValueMemberS{Value: v}
+		av, err := marshalValue(v)
+		if err != nil {
+			return Record{}, fmt.Errorf("key %q: %w", k, err)
+		}
+		prefsMap[k] = av
+		metaMap[k] = keyMeta
 	}
 
-	item := map[string]types.AttributeValue{
-		"PK":          &types.AttributeValueMemberS{Value: s.pk(userID)},
-		"preferences": &types.AttributeValueMemberM{Value: prefsMap},
-		"updatedAt":   &types.AttributeValueMemberS{Value: now},
-		"createdAt":   &types.AttributeValueMemberS{Value: now},
+	exprNames := map[string]string{"#ver": "version"}
+	exprValues := map[string]types.AttributeValue{
+		":p":    &types.AttributeValueMemberM{Value: prefsMap},
+		":meta": &types.AttributeValueMemberM{Value: metaMap},
+		":now":  &types.AttributeValueMemberS{Value: now},
+		":one":  &types.AttributeValueMemberN{Value: "1"},
+		":gen":  &types.AttributeValueMemberS{Value: newGeneration()},
 	}
+	updateExpr := "SET preferences = :p, meta = :meta, updatedAt = :now, createdAt = if_not_exists(createdAt, :now), gen = if_not_exists(gen, :gen)"
+	if s.ttl > 0 {
+		exprValues[":exp"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(s.ttl).Unix(), 10)}
+		updateExpr += ", expiresAt = :exp"
+	}
+	updateExpr += " ADD #ver :one"
 
-	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+	out, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName: &s.tableName,
-		Item:      item,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: s.pk(userID)},
+		},
+		UpdateExpression:                    &updateExpr,
+		ConditionExpression:                 writeCondition(cond, exprNames, exprValues),
+		ExpressionAttributeNames:            exprNames,
+		ExpressionAttributeValues:           exprValues,
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
 	})
 	if err != nil {
-		return fmt.Errorf("PutItem: %w", err)
+		return Record{}, conditionalErr("UpdateItem (replace)", err, cond)
 	}
 
-	return nil
+	return createdRecord(out.Attributes)
+}
+
+// createdRecord unmarshals the item a write returned. Every write adds one
+// to the version, so version 1 means the write created the item (or gave a
+// version to an item from before versions were kept).
+func createdRecord(item map[string]types.AttributeValue) (Record, error) {
+	rec, err := unmarshalRecord(item)
+	rec.Created = err == nil && rec.Version == 1
+	return rec, err
 }
 
-func (s *DynamoStore) Update(ctx context.Context, userID string, prefs map[string]string) (map[string]string, error) {
+// newGeneration returns a random 64-bit hex generation for an item a write
+// may create. Writes keep the generation of an existing item.
+func newGeneration() string {
+	return newID()[:16]
+}
+
+func (s *DynamoStore) Update(ctx context.Context, userID string, prefs map[string]any, remove []string, cond Precondition) (Record, error) {
 	now := time.Now().UTC().Format(time.RFC3339)
 
 	// Build the update expression dynamically:
-	// SET preferences.#k1 = :v1, preferences.#k2 = :v2, ..., updatedAt = :now
-	exprNames := make(map[string]string, len(prefs))
-	exprValues := make(map[string]types.AttributeValue, len(prefs)+1)
+	// SET preferences.#k1 = :v1, meta.#k1 = :meta, ..., updatedAt = :now,
+	// createdAt = if_not_exists(createdAt, :now), gen = if_not_exists(gen, :gen)
+	// REMOVE preferences.#r1, meta.#r1, ... ADD #ver :one
+	exprNames := make(map[string]string, len(prefs)+len(remove)+1)
+	exprValues := make(map[string]types.AttributeValue, len(prefs)+4)
 
 	updateExpr := "SET "
 	i := 0
@@ -110,34 +317,55 @@ func (s *DynamoStore) Update(ctx context.Context, userID string, prefs map[strin
 		nameKey := fmt.Sprintf("#k%d", i)
 		valKey := fmt.Sprintf(":v%d", i)
 
+		av, err := marshalValue(v)
+		if err != nil {
+			return Record{}, fmt.Errorf("key %q: %w", k, err)
+		}
 		exprNames[nameKey] = k
-		exprValues[valKey] = &types.AttributeValueMemberS{Value: v}
+		exprValues[valKey] = av
 
-		if i > 0 {
+		updateExpr += fmt.Sprintf("preferences.%s = %s, meta.%s = :meta, ", nameKey, valKey, nameKey)
+		i++
+	}
+	updateExpr += "updatedAt = :now, createdAt = if_not_exists(createdAt, :now), gen = if_not_exists(gen, :gen)"
+
+	for j, k := range remove {
+		nameKey := fmt.Sprintf("#r%d", j)
+		exprNames[nameKey] = k
+		if j == 0 {
+			updateExpr += " REMOVE "
+		} else {
 			updateExpr += ", "
 		}
-		updateExpr += fmt.Sprintf("preferences.%s = %s", nameKey, valKey)
-		i++
+		updateExpr += "preferences." + nameKey + ", meta." + nameKey
 	}
 
-	updateExpr += ", updatedAt = :now"
+	updateExpr += " ADD #ver :one"
+	exprNames["#ver"] = "version"
 	exprValues[":now"] = &types.AttributeValueMemberS{Value: now}
+	exprValues[":one"] = &types.AttributeValueMemberN{Value: "1"}
+	exprValues[":gen"] = &types.AttributeValueMemberS{Value: newGeneration()}
+	if len(prefs) > 0 {
+		exprValues[":meta"] = marshalKeyMeta(now, writerFrom(ctx))
+	}
 
-	out, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+	out, err := s.updateNested(ctx, userID, cond, &dynamodb.UpdateItemInput{
 		TableName: &s.tableName,
 		Key: map[string]types.AttributeValue{
 			"PK": &types.AttributeValueMemberS{Value: s.pk(userID)},
 		},
-		UpdateExpression:          &updateExpr,
-		ExpressionAttributeNames:  exprNames,
-		ExpressionAttributeValues: exprValues,
-		ReturnValues:              types.ReturnValueAllNew,
+		UpdateExpression:                    &updateExpr,
+		ConditionExpression:                 writeCondition(cond, exprNames, exprValues),
+		ExpressionAttributeNames:            exprNames,
+		ExpressionAttributeValues:           exprValues,
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("UpdateItem: %w", err)
+		return Record{}, conditionalErr("UpdateItem", err, cond)
 	}
 
-	return unmarshalPrefs(out.Attributes)
+	return createdRecord(out.Attributes)
 }
 
 func (s *DynamoStore) DeleteAll(ctx context.Context, userID string) error {
@@ -154,27 +382,203 @@ func (s *DynamoStore) DeleteAll(ctx context.Context, userID string) error {
 	return nil
 }
 
+// Delete removes one key and bumps the version. Deleting from a user with no
+// record is a no-op rather than creating an empty item.
 func (s *DynamoStore) Delete(ctx context.Context, userID string, key string) error {
-	exprNames := map[string]string{"#key": key}
-	updateExpr := "REMOVE preferences.#key"
+	exprNames := map[string]string{"#key": key, "#ver": "version"}
+	exprValues := map[string]types.AttributeValue{
+		":now": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		":one": &types.AttributeValueMemberN{Value: "1"},
+	}
+	updateExpr := "REMOVE preferences.#key, meta.#key SET updatedAt = :now ADD #ver :one"
+	condExpr := "attribute_exists(PK)"
 
-	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+	_, err := s.updateNested(ctx, userID, Precondition{MustExist: true}, &dynamodb.UpdateItemInput{
 		TableName: &s.tableName,
 		Key: map[string]types.AttributeValue{
 			"PK": &types.AttributeValueMemberS{Value: s.pk(userID)},
 		},
-		UpdateExpression:         &updateExpr,
-		ExpressionAttributeNames: exprNames,
+		UpdateExpression:          &updateExpr,
+		ConditionExpression:       &condExpr,
+		ExpressionAttributeNames:  exprNames,
+		ExpressionAttributeValues: exprValues,
 	})
 	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return nil
+		}
 		return fmt.Errorf("UpdateItem (REMOVE): %w", err)
 	}
 
 	return nil
 }
 
+// updateNested runs an UpdateItem whose expression addresses entries of the
+// preferences and meta maps. DynamoDB rejects such paths when the map
+// attribute itself is missing, as it is for new users and for items written
+// before per-key metadata, so on that error the maps are created empty and
+// the update retried once. When cond requires an existing record, the maps
+// are only created on one.
+func (s *DynamoStore) updateNested(ctx context.Context, userID string, cond Precondition, in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	out, err := s.client.UpdateItem(ctx, in)
+	if !isMissingPath(err) {
+		return out, err
+	}
+
+	init := &dynamodb.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: s.pk(userID)},
+		},
+		UpdateExpression: aws.String("SET preferences = if_not_exists(preferences, :empty), meta = if_not_exists(meta, :empty)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+		},
+	}
+	if cond.MustExist || len(cond.Versions) > 0 {
+		init.ConditionExpression = aws.String("attribute_exists(PK)")
+	}
+	if _, err := s.client.UpdateItem(ctx, init); err != nil {
+		// A failed existence check surfaces as the caller's own
+		// ConditionalCheckFailedException.
+		return nil, fmt.Errorf("UpdateItem (init maps): %w", err)
+	}
+	return s.client.UpdateItem(ctx, in)
+}
+
+// isMissingPath reports whether err is DynamoDB rejecting an update path
+// whose parent map does not exist.
+func isMissingPath(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationException" &&
+		strings.Contains(apiErr.ErrorMessage(), "document path")
+}
+
+// marshalKeyMeta encodes the metadata recorded for each key a write sets.
+func marshalKeyMeta(now, by string) types.AttributeValue {
+	m := map[string]types.AttributeValue{
+		"updatedAt": &types.AttributeValueMemberS{Value: now},
+	}
+	if by != "" {
+		m["updatedBy"] = &types.AttributeValueMemberS{Value: by}
+	}
+	return &types.AttributeValueMemberM{Value: m}
+}
+
+// writeCondition renders a Precondition as a condition expression, adding
+// its placeholder names and values. It expects "#ver" to name the version
+// attribute. Items written before versioning have no version attribute and
+// match version 0; those written before generations match none.
+func writeCondition(cond Precondition, names map[string]string, values map[string]types.AttributeValue) *string {
+	var clauses []string
+	for i, k := range cond.Absent {
+		ph := fmt.Sprintf("#a%d", i)
+		names[ph] = k
+		clauses = append(clauses, "attribute_not_exists(preferences."+ph+")")
+	}
+	if cond.MustExist {
+		clauses = append(clauses, "attribute_exists(PK)")
+	}
+	if cond.MustNotExist {
+		clauses = append(clauses, "attribute_not_exists(PK)")
+	}
+	if len(cond.Versions) > 0 {
+		alts := make([]string, 0, len(cond.Versions))
+		for i, v := range cond.Versions {
+			if v == 0 {
+				alts = append(alts, "(attribute_exists(PK) AND attribute_not_exists(#ver))")
+				continue
+			}
+			ph := fmt.Sprintf(":c%d", i)
+			values[ph] = &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+			alts = append(alts, "#ver = "+ph)
+		}
+		clauses = append(clauses, "("+strings.Join(alts, " OR ")+")")
+		if cond.Generation != "" {
+			names["#gen"] = "gen"
+			values[":cgen"] = &types.AttributeValueMemberS{Value: cond.Generation}
+			clauses = append(clauses, "#gen = :cgen")
+		}
+	}
+	if len(clauses) == 0 {
+		return nil
+	}
+	expr := strings.Join(clauses, " AND ")
+	return &expr
+}
+
+// conditionalErr maps a failed condition check to ErrConflict when one of
+// cond's Absent keys is set in the returned item, and otherwise to
+// ErrPreconditionFailed.
+func conditionalErr(op string, err error, cond Precondition) error {
+	var ccf *types.ConditionalCheckFailedException
+	if !errors.As(err, &ccf) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if len(cond.Absent) > 0 && ccf.Item != nil {
+		prefs, _ := unmarshalPrefs(ccf.Item)
+		for _, k := range cond.Absent {
+			if _, ok := prefs[k]; ok {
+				return ErrConflict
+			}
+		}
+	}
+	return ErrPreconditionFailed
+}
+
+// unmarshalRecord extracts the preferences, version, timestamps and per-key
+// metadata from a DynamoDB item.
+func unmarshalRecord(item map[string]types.AttributeValue) (Record, error) {
+	prefs, err := unmarshalPrefs(item)
+	if err != nil {
+		return Record{}, err
+	}
+	if prefs == nil {
+		prefs = make(map[string]any)
+	}
+
+	rec := Record{Prefs: prefs}
+	if sv, ok := item["gen"].(*types.AttributeValueMemberS); ok {
+		rec.Generation = sv.Value
+	}
+	if nv, ok := item["version"].(*types.AttributeValueMemberN); ok {
+		if rec.Version, err = strconv.ParseInt(nv.Value, 10, 64); err != nil {
+			return Record{}, fmt.Errorf("invalid version attribute: %w", err)
+		}
+	}
+	if sv, ok := item["createdAt"].(*types.AttributeValueMemberS); ok {
+		if rec.CreatedAt, err = time.Parse(time.RFC3339, sv.Value); err != nil {
+			return Record{}, fmt.Errorf("invalid createdAt attribute: %w", err)
+		}
+	}
+	if sv, ok := item["updatedAt"].(*types.AttributeValueMemberS); ok {
+		if rec.UpdatedAt, err = time.Parse(time.RFC3339, sv.Value); err != nil {
+			return Record{}, fmt.Errorf("invalid updatedAt attribute: %w", err)
+		}
+	}
+	if mv, ok := item["meta"].(*types.AttributeValueMemberM); ok {
+		rec.Meta = make(map[string]KeyMeta, len(mv.Value))
+		for k, av := range mv.Value {
+			entry, ok := av.(*types.AttributeValueMemberM)
+			if !ok {
+				continue
+			}
+			var km KeyMeta
+			if sv, ok := entry.Value["updatedAt"].(*types.AttributeValueMemberS); ok {
+				km.UpdatedAt, _ = time.Parse(time.RFC3339, sv.Value)
+			}
+			if sv, ok := entry.Value["updatedBy"].(*types.AttributeValueMemberS); ok {
+				km.UpdatedBy = sv.Value
+			}
+			rec.Meta[k] = km
+		}
+	}
+	return rec, nil
+}
+
 // unmarshalPrefs extracts the preferences map from a DynamoDB item.
-func unmarshalPrefs(item map[string]types.AttributeValue) (map[string]string, error) {
+func unmarshalPrefs(item map[string]types.AttributeValue) (map[string]any, error) {
 	prefsAttr, ok := item["preferences"]
 	if !ok {
 		return nil, nil
@@ -185,13 +589,11 @@ func unmarshalPrefs(item map[string]types.AttributeValue) (map[string]string, er
 		return nil, fmt.Errorf("preferences attribute is not a map")
 	}
 
-	result := make(map[string]string, len(prefsMap.Value))
-	for k, v := range prefsMap.Value {
-		sv, ok := v.(*types.AttributeValueMemberS)
-		if !ok {
-			continue
+	result := make(map[string]any, len(prefsMap.Value))
+	for k, av := range prefsMap.Value {
+		if v, ok := unmarshalValue(av); ok {
+			result[k] = v
 		}
-		result[k] = sv.Value
 	}
 
 	return result, nil
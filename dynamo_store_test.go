@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"strconv"
 	"testing"
 )
 
@@ -44,27 +46,83 @@ func TestIntegration_PutAndGetAll(t *testing.T) {
 	defer store.DeleteAll(ctx, userID)
 
 	// Initially empty
-	prefs, err := store.GetAll(ctx, userID)
+	rec, err := store.GetAll(ctx, userID)
 	if err != nil {
 		t.Fatalf("GetAll: %v", err)
 	}
-	if prefs != nil {
-		t.Fatalf("expected nil prefs for new user, got %v", prefs)
+	if rec.Prefs != nil {
+		t.Fatalf("expected nil prefs for new user, got %v", rec.Prefs)
 	}
 
 	// ReplaceAll
-	err = store.ReplaceAll(ctx, userID, map[string]string{"theme": "dark", "lang": "en"})
+	rec, err = store.ReplaceAll(ctx, userID, map[string]any{"theme": "dark", "lang": "en"}, Precondition{})
 	if err != nil {
 		t.Fatalf("ReplaceAll: %v", err)
 	}
+	if !rec.Created {
+		t.Fatal("expected the first write to report creating the item")
+	}
+	if rec, err = store.ReplaceAll(ctx, userID, map[string]any{"theme": "dark", "lang": "en"}, Precondition{}); err != nil || rec.Created {
+		t.Fatalf("expected a rewrite not to be a create, got %+v %v", rec, err)
+	}
 
 	// GetAll
-	prefs, err = store.GetAll(ctx, userID)
+	rec, err = store.GetAll(ctx, userID)
 	if err != nil {
 		t.Fatalf("GetAll: %v", err)
 	}
-	if prefs["theme"] != "dark" || prefs["lang"] != "en" {
-		t.Fatalf("unexpected prefs: %v", prefs)
+	if rec.Prefs["theme"] != "dark" || rec.Prefs["lang"] != "en" {
+		t.Fatalf("unexpected prefs: %v", rec.Prefs)
+	}
+}
+
+func TestIntegration_ConditionalWrite(t *testing.T) {
+	skipIfNoEndpoint(t)
+	store := testStore(t)
+	ctx := context.Background()
+	userID := "integration-test-user-6"
+
+	defer store.DeleteAll(ctx, userID)
+
+	if _, err := store.Update(ctx, userID, map[string]any{"theme": "dark"}, nil, Precondition{MustExist: true}); err != ErrPreconditionFailed {
+		t.Fatalf("expected ErrPreconditionFailed for missing user, got %v", err)
+	}
+
+	rec, err := store.ReplaceAll(ctx, userID, map[string]any{"theme": "dark"}, Precondition{})
+	if err != nil {
+		t.Fatalf("ReplaceAll: %v", err)
+	}
+
+	if _, err := store.ReplaceAll(ctx, userID, map[string]any{"theme": "light"}, Precondition{MustNotExist: true}); err != ErrPreconditionFailed {
+		t.Fatalf("expected ErrPreconditionFailed for existing user, got %v", err)
+	}
+
+	next, err := store.Update(ctx, userID, map[string]any{"lang": "fr"}, nil, Precondition{Versions: []int64{rec.Version}})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if next.Version <= rec.Version {
+		t.Fatalf("expected version to increase, got %d then %d", rec.Version, next.Version)
+	}
+
+	if _, err := store.Update(ctx, userID, map[string]any{"lang": "de"}, nil, Precondition{Versions: []int64{rec.Version}}); err != ErrPreconditionFailed {
+		t.Fatalf("expected ErrPreconditionFailed for stale version, got %v", err)
+	}
+
+	if _, err := store.Update(ctx, userID, map[string]any{"lang": "de"}, nil, Precondition{Absent: []string{"lang"}}); err != ErrConflict {
+		t.Fatalf("expected ErrConflict for existing key, got %v", err)
+	}
+
+	// A recreated item restarts at the same version under a new generation.
+	if err := store.DeleteAll(ctx, userID); err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	again, err := store.ReplaceAll(ctx, userID, map[string]any{"theme": "light"}, Precondition{})
+	if err != nil || again.Version != rec.Version || again.Generation == rec.Generation {
+		t.Fatalf("expected version %d under a new generation, got %+v %v", rec.Version, again, err)
+	}
+	if _, err := store.Update(ctx, userID, map[string]any{"lang": "de"}, nil, Precondition{Versions: []int64{rec.Version}, Generation: rec.Generation}); err != ErrPreconditionFailed {
+		t.Fatalf("expected ErrPreconditionFailed for the earlier generation, got %v", err)
 	}
 }
 
@@ -76,17 +134,20 @@ func TestIntegration_GetSingle(t *testing.T) {
 
 	defer store.DeleteAll(ctx, userID)
 
-	store.ReplaceAll(ctx, userID, map[string]string{"theme": "light"})
+	written, _ := store.ReplaceAll(ctx, userID, map[string]any{"theme": "light"}, Precondition{})
 
-	val, found, err := store.Get(ctx, userID, "theme")
+	val, found, rec, err := store.Get(ctx, userID, "theme")
 	if err != nil {
 		t.Fatalf("Get: %v", err)
 	}
 	if !found || val != "light" {
 		t.Fatalf("expected theme=light found=true, got val=%s found=%v", val, found)
 	}
+	if formatETag(rec) != formatETag(written) || !rec.UpdatedAt.Equal(written.UpdatedAt) {
+		t.Fatalf("expected the record's validators, got %+v", rec)
+	}
 
-	_, found, err = store.Get(ctx, userID, "missing")
+	_, found, _, err = store.Get(ctx, userID, "missing")
 	if err != nil {
 		t.Fatalf("Get missing: %v", err)
 	}
@@ -95,6 +156,55 @@ func TestIntegration_GetSingle(t *testing.T) {
 	}
 }
 
+func TestIntegration_GetKeys(t *testing.T) {
+	skipIfNoEndpoint(t)
+	store := testStore(t)
+	ctx := context.Background()
+	userID := "integration-test-user-7"
+
+	defer store.DeleteAll(ctx, userID)
+
+	store.ReplaceAll(ctx, userID, map[string]any{"theme": "dark", "lang": "en", "tz": "UTC"}, Precondition{})
+
+	rec, err := store.GetKeys(ctx, userID, []string{"theme", "tz", "missing"})
+	if err != nil {
+		t.Fatalf("GetKeys: %v", err)
+	}
+	if len(rec.Prefs) != 2 || rec.Prefs["theme"] != "dark" || rec.Prefs["tz"] != "UTC" {
+		t.Fatalf("unexpected prefs: %v", rec.Prefs)
+	}
+	if rec.Version == 0 {
+		t.Fatal("expected version in projection")
+	}
+}
+
+func TestIntegration_BatchGet(t *testing.T) {
+	skipIfNoEndpoint(t)
+	store := testStore(t)
+	ctx := context.Background()
+
+	var ids []string
+	for i := range 120 {
+		id := fmt.Sprintf("integration-batch-%d", i)
+		ids = append(ids, id)
+		if i%2 == 0 {
+			store.ReplaceAll(ctx, id, map[string]any{"n": strconv.Itoa(i)}, Precondition{})
+			defer store.DeleteAll(ctx, id)
+		}
+	}
+
+	recs, err := store.BatchGet(ctx, ids)
+	if err != nil {
+		t.Fatalf("BatchGet: %v", err)
+	}
+	if len(recs) != 60 {
+		t.Fatalf("expected 60 users with preferences, got %d", len(recs))
+	}
+	if recs["integration-batch-100"].Prefs["n"] != "100" {
+		t.Fatalf("unexpected record %+v", recs["integration-batch-100"])
+	}
+}
+
 func TestIntegration_Update(t *testing.T) {
 	skipIfNoEndpoint(t)
 	store := testStore(t)
@@ -103,14 +213,63 @@ func TestIntegration_Update(t *testing.T) {
 
 	defer store.DeleteAll(ctx, userID)
 
-	store.ReplaceAll(ctx, userID, map[string]string{"theme": "dark"})
+	store.ReplaceAll(ctx, userID, map[string]any{"theme": "dark"}, Precondition{})
+
+	merged, err := store.Update(ctx, userID, map[string]any{"lang": "fr"}, nil, Precondition{})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if merged.Prefs["theme"] != "dark" || merged.Prefs["lang"] != "fr" {
+		t.Fatalf("unexpected merged: %v", merged.Prefs)
+	}
+}
+
+func TestIntegration_DottedKeys(t *testing.T) {
+	skipIfNoEndpoint(t)
+	store := testStore(t)
+	ctx := context.Background()
+	userID := "integration-test-user-dotted"
+
+	defer store.DeleteAll(ctx, userID)
+
+	if _, err := store.Update(ctx, userID, map[string]any{"notifications.email": true}, nil, Precondition{}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	value, found, _, err := store.Get(ctx, userID, "notifications.email")
+	if err != nil || !found || value != true {
+		t.Fatalf("expected the dotted key stored as one entry, got %v %v %v", value, found, err)
+	}
+	if err := store.Delete(ctx, userID, "notifications.email"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if rec, _ := store.GetAll(ctx, userID); len(rec.Prefs) != 0 {
+		t.Fatalf("expected no keys left, got %v", rec.Prefs)
+	}
+}
+
+func TestIntegration_UpdateNewUserRecordsMeta(t *testing.T) {
+	skipIfNoEndpoint(t)
+	store := testStore(t)
+	ctx := context.WithValue(context.Background(), claimsKey, Claims{Subject: "integration-test-user-8"})
+	userID := "integration-test-user-8"
+
+	defer store.DeleteAll(ctx, userID)
 
-	merged, err := store.Update(ctx, userID, map[string]string{"lang": "fr"})
+	// The item does not exist yet, so the nested paths need creating first.
+	rec, err := store.Update(ctx, userID, map[string]any{"theme": "dark"}, nil, Precondition{})
 	if err != nil {
 		t.Fatalf("Update: %v", err)
 	}
-	if merged["theme"] != "dark" || merged["lang"] != "fr" {
-		t.Fatalf("unexpected merged: %v", merged)
+	if rec.Prefs["theme"] != "dark" || rec.Meta["theme"].UpdatedBy != userID || rec.Meta["theme"].UpdatedAt.IsZero() {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+
+	if err := store.Delete(ctx, userID, "theme"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	rec, _ = store.GetAll(ctx, userID)
+	if _, ok := rec.Meta["theme"]; ok {
+		t.Fatalf("expected metadata removed with the key, got %+v", rec.Meta)
 	}
 }
 
@@ -122,18 +281,18 @@ func TestIntegration_DeleteKey(t *testing.T) {
 
 	defer store.DeleteAll(ctx, userID)
 
-	store.ReplaceAll(ctx, userID, map[string]string{"theme": "dark", "lang": "en"})
+	store.ReplaceAll(ctx, userID, map[string]any{"theme": "dark", "lang": "en"}, Precondition{})
 
 	err := store.Delete(ctx, userID, "theme")
 	if err != nil {
 		t.Fatalf("Delete: %v", err)
 	}
 
-	prefs, _ := store.GetAll(ctx, userID)
-	if _, exists := prefs["theme"]; exists {
+	rec, _ := store.GetAll(ctx, userID)
+	if _, exists := rec.Prefs["theme"]; exists {
 		t.Fatal("expected theme to be deleted")
 	}
-	if prefs["lang"] != "en" {
+	if rec.Prefs["lang"] != "en" {
 		t.Fatal("expected lang to remain")
 	}
 }
@@ -144,15 +303,47 @@ func TestIntegration_DeleteAll(t *testing.T) {
 	ctx := context.Background()
 	userID := "integration-test-user-5"
 
-	store.ReplaceAll(ctx, userID, map[string]string{"theme": "dark"})
+	store.ReplaceAll(ctx, userID, map[string]any{"theme": "dark"}, Precondition{})
 
 	err := store.DeleteAll(ctx, userID)
 	if err != nil {
 		t.Fatalf("DeleteAll: %v", err)
 	}
 
-	prefs, _ := store.GetAll(ctx, userID)
-	if prefs != nil {
-		t.Fatalf("expected nil after DeleteAll, got %v", prefs)
+	rec, _ := store.GetAll(ctx, userID)
+	if rec.Prefs != nil {
+		t.Fatalf("expected nil after DeleteAll, got %v", rec.Prefs)
+	}
+}
+
+func TestIntegration_SearchUsers(t *testing.T) {
+	skipIfNoEndpoint(t)
+	store := testStore(t)
+	ctx := context.Background()
+	key := "search_test_flag"
+
+	for i, v := range []any{true, "true", false} {
+		userID := fmt.Sprintf("integration-test-user-search-%d", i)
+		defer store.DeleteAll(ctx, userID)
+		if _, err := store.Update(ctx, userID, map[string]any{key: v}, nil, Precondition{}); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+
+	var found []SearchMatch
+	after := ""
+	for {
+		matches, next, err := store.SearchUsers(ctx, SearchQuery{Key: key, Values: []any{true, "true"}}, 1, after)
+		if err != nil {
+			t.Fatalf("SearchUsers: %v", err)
+		}
+		found = append(found, matches...)
+		if next == "" {
+			break
+		}
+		after = next
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected both true and \"true\" to match, got %+v", found)
 	}
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DeleteUserData deletes the items in the user's userIndex partition:
+// webhook delivery records and Idempotency-Key results holding their
+// values. The index is eventually consistent, so an item written moments
+// before may be missed.
+func (s *DynamoStore) DeleteUserData(ctx context.Context, userID string) (int, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                &s.tableName,
+		IndexName:                aws.String(userIndex),
+		KeyConditionExpression:   aws.String("#pk = :pk"),
+		ExpressionAttributeNames: map[string]string{"#pk": userIndexPK},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: userDataPartition + userID},
+		},
+		ProjectionExpression: aws.String("PK"),
+	}
+
+	var requests []types.WriteRequest
+	paginator := dynamodb.NewQueryPaginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("Query (user data): %w", err)
+		}
+		for _, item := range page.Items {
+			requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{
+				Key: map[string]types.AttributeValue{"PK": item["PK"]},
+			}})
+		}
+	}
+
+	for batch := range slices.Chunk(requests, 25) {
+		pending := map[string][]types.WriteRequest{s.tableName: batch}
+		for backoff := 50 * time.Millisecond; ; backoff *= 2 {
+			out, err := s.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return 0, fmt.Errorf("BatchWriteItem (user data): %w", err)
+			}
+			if pending = out.UnprocessedItems; len(pending) == 0 {
+				break
+			}
+			// Unprocessed items mean the table is throttling.
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+	}
+	return len(requests), nil
+}
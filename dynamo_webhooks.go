@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const webhookPrefix = "WEBHOOK#"
+
+func (s *DynamoStore) CreateWebhook(ctx context.Context, wh Webhook) error {
+	cond := "attribute_not_exists(PK)"
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &s.tableName,
+		Item:                marshalWebhook(wh),
+		ConditionExpression: &cond,
+	})
+	if err != nil {
+		return fmt.Errorf("PutItem (webhook): %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoStore) GetWebhook(ctx context.Context, id string) (Webhook, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: webhookPrefix + id},
+		},
+	})
+	if err != nil {
+		return Webhook{}, fmt.Errorf("GetItem (webhook): %w", err)
+	}
+	if out.Item == nil {
+		return Webhook{}, ErrNotFound
+	}
+	return unmarshalWebhook(out.Item), nil
+}
+
+// ListWebhooks queries the webhooks partition of listIndex, oldest first.
+// The index is eventually consistent, so a subscription created a moment
+// ago may be missing.
+func (s *DynamoStore) ListWebhooks(ctx context.Context, owner string) ([]Webhook, error) {
+	exprNames := map[string]string{"#pk": listIndexPK}
+	exprValues := map[string]types.AttributeValue{
+		":pk": &types.AttributeValueMemberS{Value: webhooksPartition},
+	}
+	input := &dynamodb.QueryInput{
+		TableName:              &s.tableName,
+		IndexName:              aws.String(listIndex),
+		KeyConditionExpression: aws.String("#pk = :pk"),
+	}
+	if owner != "" {
+		input.FilterExpression = aws.String("#owner = :owner")
+		exprNames["#owner"] = "owner"
+		exprValues[":owner"] = &types.AttributeValueMemberS{Value: owner}
+	}
+	input.ExpressionAttributeNames, input.ExpressionAttributeValues = exprNames, exprValues
+
+	var out []Webhook
+	paginator := dynamodb.NewQueryPaginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Query (webhooks): %w", err)
+		}
+		for _, item := range page.Items {
+			out = append(out, unmarshalWebhook(item))
+		}
+	}
+	return out, nil
+}
+
+func (s *DynamoStore) UpdateWebhook(ctx context.Context, wh Webhook) error {
+	cond := "attribute_exists(PK)"
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &s.tableName,
+		Item:                marshalWebhook(wh),
+		ConditionExpression: &cond,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("PutItem (webhook): %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoStore) DeleteWebhook(ctx context.Context, id string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: webhookPrefix + id},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("DeleteItem (webhook): %w", err)
+	}
+	return nil
+}
+
+// marshalWebhook converts a subscription into its item.
+func marshalWebhook(wh Webhook) map[string]types.AttributeValue {
+	list := func(values []string) types.AttributeValue {
+		l := make([]types.AttributeValue, len(values))
+		for i, v := range values {
+			l[i] = &types.AttributeValueMemberS{Value: v}
+		}
+		return &types.AttributeValueMemberL{Value: l}
+	}
+	return withIndexKeys(map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: webhookPrefix + wh.ID},
+		"id":        &types.AttributeValueMemberS{Value: wh.ID},
+		"owner":     &types.AttributeValueMemberS{Value: wh.Owner},
+		"url":       &types.AttributeValueMemberS{Value: wh.URL},
+		"secret":    &types.AttributeValueMemberS{Value: wh.Secret},
+		"events":    list(wh.Events),
+		"keys":      list(wh.Keys),
+		"createdAt": &types.AttributeValueMemberS{Value: wh.CreatedAt.Format(time.RFC3339)},
+		"updatedAt": &types.AttributeValueMemberS{Value: wh.UpdatedAt.Format(time.RFC3339)},
+	})
+}
+
+// unmarshalWebhook converts a webhook item back into its model.
+func unmarshalWebhook(item map[string]types.AttributeValue) Webhook {
+	str := func(name string) string {
+		if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	list := func(name string) []string {
+		l, _ := item[name].(*types.AttributeValueMemberL)
+		if l == nil || len(l.Value) == 0 {
+			return nil
+		}
+		out := make([]string, 0, len(l.Value))
+		for _, v := range l.Value {
+			if s, ok := v.(*types.AttributeValueMemberS); ok {
+				out = append(out, s.Value)
+			}
+		}
+		return out
+	}
+	created, _ := time.Parse(time.RFC3339, str("createdAt"))
+	updated, _ := time.Parse(time.RFC3339, str("updatedAt"))
+
+	return Webhook{
+		ID:        str("id"),
+		Owner:     str("owner"),
+		URL:       str("url"),
+		Secret:    str("secret"),
+		Events:    list("events"),
+		Keys:      list("keys"),
+		CreatedAt: created,
+		UpdatedAt: updated,
+	}
+}
+
+// Delivery records live under PK = WEBHOOKDELIVERY#{id} and expire via the
+// table's TTL after webhookDeliveryRetention. They are listed per
+// subscription from listIndex, and pending ones are also queued by due
+// time in queueIndex (see indexKeys).
+const webhookDeliveryPrefix = "WEBHOOKDELIVERY#"
+
+func (s *DynamoStore) PutWebhookDelivery(ctx context.Context, del WebhookDelivery) error {
+	event, err := json.Marshal(del.Event)
+	if err != nil {
+		return fmt.Errorf("encoding delivery event: %w", err)
+	}
+	item := map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: webhookDeliveryPrefix + del.ID},
+		"id":        &types.AttributeValueMemberS{Value: del.ID},
+		"webhookId": &types.AttributeValueMemberS{Value: del.WebhookID},
+		"eventId":   &types.AttributeValueMemberS{Value: del.EventID},
+		"eventType": &types.AttributeValueMemberS{Value: del.EventType},
+		"status":    &types.AttributeValueMemberS{Value: del.Status},
+		"attempts":  &types.AttributeValueMemberN{Value: strconv.Itoa(del.Attempts)},
+		"event":     &types.AttributeValueMemberS{Value: string(event)},
+		"createdAt": &types.AttributeValueMemberS{Value: del.CreatedAt.Format(time.RFC3339Nano)},
+		"updatedAt": &types.AttributeValueMemberS{Value: del.UpdatedAt.Format(time.RFC3339Nano)},
+		"expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(del.CreatedAt.Add(webhookDeliveryRetention).Unix(), 10)},
+	}
+	if del.LastStatus != 0 {
+		item["lastStatus"] = &types.AttributeValueMemberN{Value: strconv.Itoa(del.LastStatus)}
+	}
+	if del.LastError != "" {
+		item["lastError"] = &types.AttributeValueMemberS{Value: del.LastError}
+	}
+	if del.RoundStart != 0 {
+		item["roundStart"] = &types.AttributeValueMemberN{Value: strconv.Itoa(del.RoundStart)}
+	}
+	if del.Status == DeliveryPending {
+		item["nextAttemptAt"] = &types.AttributeValueMemberS{Value: del.NextAttemptAt.Format(time.RFC3339Nano)}
+	}
+	input := &dynamodb.PutItemInput{
+		TableName: &s.tableName,
+		Item:      withIndexKeys(item),
+	}
+	if del.Attempts > 0 {
+		input.ConditionExpression = aws.String("attribute_exists(PK)")
+	}
+	_, err = s.client.PutItem(ctx, input)
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("PutItem (webhook delivery): %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoStore) GetWebhookDelivery(ctx context.Context, id string) (WebhookDelivery, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: webhookDeliveryPrefix + id},
+		},
+	})
+	if err != nil {
+		return WebhookDelivery{}, fmt.Errorf("GetItem (webhook delivery): %w", err)
+	}
+	if out.Item == nil {
+		return WebhookDelivery{}, ErrNotFound
+	}
+	del := unmarshalWebhookDelivery(out.Item)
+	// TTL deletion lags.
+	if time.Since(del.CreatedAt) > webhookDeliveryRetention {
+		return WebhookDelivery{}, ErrNotFound
+	}
+	return del, nil
+}
+
+// ListWebhookDeliveries queries the subscription's partition of listIndex
+// newest first, stopping at maxWebhookDeliveries records.
+func (s *DynamoStore) ListWebhookDeliveries(ctx context.Context, webhookID, status string) ([]WebhookDelivery, error) {
+	filter := "expiresAt > :now"
+	exprNames := map[string]string{"#pk": listIndexPK}
+	exprValues := map[string]types.AttributeValue{
+		":pk":  &types.AttributeValueMemberS{Value: webhookDeliveryPartition + webhookID},
+		":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	if status != "" {
+		filter += " AND #status = :status"
+		exprNames["#status"] = "status"
+		exprValues[":status"] = &types.AttributeValueMemberS{Value: status}
+	}
+	return s.queryWebhookDeliveries(ctx, &dynamodb.QueryInput{
+		TableName:                 &s.tableName,
+		IndexName:                 aws.String(listIndex),
+		KeyConditionExpression:    aws.String("#pk = :pk"),
+		FilterExpression:          &filter,
+		ExpressionAttributeNames:  exprNames,
+		ExpressionAttributeValues: exprValues,
+		ScanIndexForward:          aws.Bool(false),
+	}, maxWebhookDeliveries)
+}
+
+// ListDueWebhookDeliveries queries the subscription's pending partition of
+// queueIndex up to now, oldest due first.
+func (s *DynamoStore) ListDueWebhookDeliveries(ctx context.Context, webhookID string, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return s.queryWebhookDeliveries(ctx, &dynamodb.QueryInput{
+		TableName:                &s.tableName,
+		IndexName:                aws.String(queueIndex),
+		KeyConditionExpression:   aws.String("#pk = :pk AND #sk <= :due"),
+		FilterExpression:         aws.String("expiresAt > :now"),
+		ExpressionAttributeNames: map[string]string{"#pk": queueIndexPK, "#sk": queueIndexSK},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: webhookPendingPartition + webhookID},
+			// IDs are hex, so "~" sorts after every key due at now.
+			":due": &types.AttributeValueMemberS{Value: indexTime(now) + "#~"},
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	}, limit)
+}
+
+// queryWebhookDeliveries pages through a delivery query until it has
+// limit records.
+func (s *DynamoStore) queryWebhookDeliveries(ctx context.Context, in *dynamodb.QueryInput, limit int) ([]WebhookDelivery, error) {
+	var out []WebhookDelivery
+	paginator := dynamodb.NewQueryPaginator(s.client, in)
+	for paginator.HasMorePages() && len(out) < limit {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Query (webhook deliveries): %w", err)
+		}
+		for _, item := range page.Items {
+			out = append(out, unmarshalWebhookDelivery(item))
+		}
+	}
+	return out[:min(len(out), limit)], nil
+}
+
+// ClaimWebhookDelivery moves a pending delivery's due time to until, on
+// condition that it is still pending and due when del says.
+func (s *DynamoStore) ClaimWebhookDelivery(ctx context.Context, del WebhookDelivery, until time.Time) (WebhookDelivery, error) {
+	out, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: webhookDeliveryPrefix + del.ID},
+		},
+		UpdateExpression:         aws.String("SET nextAttemptAt = :until, #sk = :newKey"),
+		ConditionExpression:      aws.String("#status = :pending AND #sk = :oldKey"),
+		ExpressionAttributeNames: map[string]string{"#status": "status", "#sk": queueIndexSK},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":until":   &types.AttributeValueMemberS{Value: until.UTC().Format(time.RFC3339Nano)},
+			":newKey":  &types.AttributeValueMemberS{Value: indexTime(until) + "#" + del.ID},
+			":oldKey":  &types.AttributeValueMemberS{Value: indexTime(del.NextAttemptAt) + "#" + del.ID},
+			":pending": &types.AttributeValueMemberS{Value: DeliveryPending},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return WebhookDelivery{}, ErrPreconditionFailed
+	}
+	if err != nil {
+		return WebhookDelivery{}, fmt.Errorf("UpdateItem (webhook delivery claim): %w", err)
+	}
+	return unmarshalWebhookDelivery(out.Attributes), nil
+}
+
+// unmarshalWebhookDelivery converts a delivery item back into its model.
+func unmarshalWebhookDelivery(item map[string]types.AttributeValue) WebhookDelivery {
+	str := func(name string) string {
+		if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	num := func(name string) int {
+		if v, ok := item[name].(*types.AttributeValueMemberN); ok {
+			n, _ := strconv.Atoi(v.Value)
+			return n
+		}
+		return 0
+	}
+	created, _ := time.Parse(time.RFC3339Nano, str("createdAt"))
+	updated, _ := time.Parse(time.RFC3339Nano, str("updatedAt"))
+	next, _ := time.Parse(time.RFC3339Nano, str("nextAttemptAt"))
+	var event ChangeEvent
+	json.Unmarshal([]byte(str("event")), &event)
+
+	return WebhookDelivery{
+		ID:            str("id"),
+		WebhookID:     str("webhookId"),
+		EventID:       str("eventId"),
+		EventType:     str("eventType"),
+		Status:        str("status"),
+		Attempts:      num("attempts"),
+		RoundStart:    num("roundStart"),
+		LastStatus:    num("lastStatus"),
+		LastError:     str("lastError"),
+		CreatedAt:     created,
+		UpdatedAt:     updated,
+		Event:         event,
+		NextAttemptAt: next,
+	}
+}
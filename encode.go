@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// bufPool recycles response buffers for the hand-encoded hot paths.
+var bufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 256)
+		return &b
+	},
+}
+
+// writeSinglePref writes a SinglePrefResponse without going through
+// encoding/json for string values. GetOne is the busiest endpoint and its
+// body is tiny, so skipping reflection and the encoder allocation is
+// measurable. The output is byte-for-byte what writeJSON would produce.
+func writeSinglePref(w http.ResponseWriter, key string, value any) {
+	bp := bufPool.Get().(*[]byte)
+	b := append((*bp)[:0], `{"key":`...)
+	b = appendJSONString(b, key)
+	b = append(b, `,"value":`...)
+	if s, ok := value.(string); ok {
+		b = appendJSONString(b, s)
+	} else {
+		vb, err := json.Marshal(value)
+		if err != nil {
+			bufPool.Put(bp)
+			writeError(w, http.StatusInternalServerError, "failed to encode preference")
+			return
+		}
+		b = append(b, vb...)
+	}
+	b = append(b, "}\n"...)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+
+	*bp = b
+	bufPool.Put(bp)
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a JSON string literal using the same escaping
+// as encoding/json (including HTML-safe escapes and U+2028/U+2029).
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// Prefixes marking stored values that are KMS ciphertext. v1 ciphertext is a
+// string value; v2, used for every other value type, is its JSON encoding.
+const (
+	encryptedPrefix     = "enc:v1:"
+	encryptedJSONPrefix = "enc:v2:"
+)
+
+// kmsAPI is the subset of the KMS client used for field encryption.
+type kmsAPI interface {
+	Encrypt(ctx context.Context, in *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error)
+	Decrypt(ctx context.Context, in *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// EncryptingStore wraps a Store so that values of sensitive keys are
+// encrypted with KMS before they are stored and decrypted on read. Each
+// ciphertext is bound to its user and key through the KMS encryption
+// context, so a value copied to another user or key will not decrypt.
+type EncryptingStore struct {
+	next      Store
+	kms       kmsAPI
+	keyID     string
+	sensitive map[string]bool
+}
+
+// NewEncryptingStore encrypts the given keys with the KMS key keyID.
+func NewEncryptingStore(next Store, client kmsAPI, keyID string, sensitiveKeys []string) *EncryptingStore {
+	sensitive := make(map[string]bool, len(sensitiveKeys))
+	for _, k := range sensitiveKeys {
+		sensitive[k] = true
+	}
+	return &EncryptingStore{next: next, kms: client, keyID: keyID, sensitive: sensitive}
+}
+
+// NewKMSClient creates a KMS client for the configured region.
+func NewKMSClient(ctx context.Context, cfg Config) (*kms.Client, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.AWSRegion))
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return kms.NewFromConfig(awsCfg), nil
+}
+
+func encryptionContext(userID, key string) map[string]string {
+	return map[string]string{"userId": userID, "key": key}
+}
+
+func (s *EncryptingStore) encrypt(ctx context.Context, userID, key string, value any) (string, error) {
+	prefix := encryptedPrefix
+	plaintext, isString := value.(string)
+	if !isString {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return "", fmt.Errorf("encoding %s: %w", key, err)
+		}
+		prefix, plaintext = encryptedJSONPrefix, string(b)
+	}
+	out, err := s.kms.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(s.keyID),
+		Plaintext:         []byte(plaintext),
+		EncryptionContext: encryptionContext(userID, key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("encrypting %s: %w", key, err)
+	}
+	return prefix + base64.StdEncoding.EncodeToString(out.CiphertextBlob), nil
+}
+
+// decrypt returns stored values that were never encrypted (written before
+// the key was marked sensitive) unchanged.
+func (s *EncryptingStore) decrypt(ctx context.Context, userID, key string, stored any) (any, error) {
+	str, _ := stored.(string)
+	b64, isJSON := strings.CutPrefix(str, encryptedJSONPrefix)
+	if !isJSON {
+		var ok bool
+		if b64, ok = strings.CutPrefix(str, encryptedPrefix); !ok {
+			return stored, nil
+		}
+	}
+	blob, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("decoding ciphertext for %s: %w", key, err)
+	}
+	out, err := s.kms.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob:    blob,
+		KeyId:             aws.String(s.keyID),
+		EncryptionContext: encryptionContext(userID, key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %w", key, err)
+	}
+	if !isJSON {
+		return string(out.Plaintext), nil
+	}
+	var v any
+	if err := decodeJSON(bytes.NewReader(out.Plaintext), &v); err != nil {
+		return nil, fmt.Errorf("decoding plaintext for %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// sealAll returns a copy of prefs with sensitive values encrypted.
+func (s *EncryptingStore) sealAll(ctx context.Context, userID string, prefs map[string]any) (map[string]any, error) {
+	sealed := maps.Clone(prefs)
+	for k, v := range prefs {
+		if !s.sensitive[k] {
+			continue
+		}
+		enc, err := s.encrypt(ctx, userID, k, v)
+		if err != nil {
+			return nil, err
+		}
+		sealed[k] = enc
+	}
+	return sealed, nil
+}
+
+// openAll returns a copy of prefs with sensitive values decrypted. The
+// underlying store's map is never modified.
+func (s *EncryptingStore) openAll(ctx context.Context, userID string, prefs map[string]any) (map[string]any, error) {
+	opened := maps.Clone(prefs)
+	for k, v := range prefs {
+		if !s.sensitive[k] {
+			continue
+		}
+		dec, err := s.decrypt(ctx, userID, k, v)
+		if err != nil {
+			return nil, err
+		}
+		opened[k] = dec
+	}
+	return opened, nil
+}
+
+// openRecord decrypts a record's sensitive values, keeping its metadata.
+func (s *EncryptingStore) openRecord(ctx context.Context, userID string, rec Record) (Record, error) {
+	if rec.Prefs == nil {
+		return rec, nil
+	}
+	prefs, err := s.openAll(ctx, userID, rec.Prefs)
+	if err != nil {
+		return Record{}, err
+	}
+	rec.Prefs = prefs
+	return rec, nil
+}
+
+func (s *EncryptingStore) GetAll(ctx context.Context, userID string) (Record, error) {
+	rec, err := s.next.GetAll(ctx, userID)
+	if err != nil {
+		return Record{}, err
+	}
+	return s.openRecord(ctx, userID, rec)
+}
+
+func (s *EncryptingStore) GetKeys(ctx context.Context, userID string, keys []string) (Record, error) {
+	rec, err := s.next.GetKeys(ctx, userID, keys)
+	if err != nil {
+		return Record{}, err
+	}
+	return s.openRecord(ctx, userID, rec)
+}
+
+func (s *EncryptingStore) BatchGet(ctx context.Context, userIDs []string) (map[string]Record, error) {
+	recs, err := s.next.BatchGet(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for userID, rec := range recs {
+		if recs[userID], err = s.openRecord(ctx, userID, rec); err != nil {
+			return nil, err
+		}
+	}
+	return recs, nil
+}
+
+// Stat reads no values, so there is nothing to decrypt.
+func (s *EncryptingStore) Stat(ctx context.Context, userID string, key string) (Record, bool, error) {
+	return s.next.Stat(ctx, userID, key)
+}
+
+func (s *EncryptingStore) Get(ctx context.Context, userID string, key string) (any, bool, Record, error) {
+	value, found, rec, err := s.next.Get(ctx, userID, key)
+	if err != nil || !found || !s.sensitive[key] {
+		return value, found, rec, err
+	}
+	value, err = s.decrypt(ctx, userID, key, value)
+	if err != nil {
+		return nil, false, Record{}, err
+	}
+	return value, true, rec, nil
+}
+
+func (s *EncryptingStore) ReplaceAll(ctx context.Context, userID string, prefs map[string]any, cond Precondition) (Record, error) {
+	sealed, err := s.sealAll(ctx, userID, prefs)
+	if err != nil {
+		return Record{}, err
+	}
+	rec, err := s.next.ReplaceAll(ctx, userID, sealed, cond)
+	if err != nil {
+		return Record{}, err
+	}
+	return s.openRecord(ctx, userID, rec)
+}
+
+func (s *EncryptingStore) Update(ctx context.Context, userID string, prefs map[string]any, remove []string, cond Precondition) (Record, error) {
+	sealed, err := s.sealAll(ctx, userID, prefs)
+	if err != nil {
+		return Record{}, err
+	}
+	merged, err := s.next.Update(ctx, userID, sealed, remove, cond)
+	if err != nil {
+		return Record{}, err
+	}
+	return s.openRecord(ctx, userID, merged)
+}
+
+func (s *EncryptingStore) DeleteAll(ctx context.Context, userID string) error {
+	return s.next.DeleteAll(ctx, userID)
+}
+
+func (s *EncryptingStore) Delete(ctx context.Context, userID string, key string) error {
+	return s.next.Delete(ctx, userID, key)
+}
+
+// EncryptingWebhookStore wraps a WebhookStore so that subscription signing
+// secrets are encrypted with KMS at rest, each bound to its subscription
+// through the encryption context. Secrets stored before encryption was
+// enabled are read as they are, and sealed on the next update.
+type EncryptingWebhookStore struct {
+	WebhookStore
+	kms   kmsAPI
+	keyID string
+
+	mu sync.Mutex
+	// opened caches decrypted secrets by ciphertext, so the dispatcher's
+	// periodic reloads do not call KMS for every subscription. Each full
+	// listing keeps only the secrets still in use.
+	opened map[string]string
+}
+
+// NewEncryptingWebhookStore encrypts webhook secrets with the KMS key keyID.
+func NewEncryptingWebhookStore(next WebhookStore, client kmsAPI, keyID string) *EncryptingWebhookStore {
+	return &EncryptingWebhookStore{WebhookStore: next, kms: client, keyID: keyID, opened: map[string]string{}}
+}
+
+func webhookEncryptionContext(id string) map[string]string {
+	return map[string]string{"webhookId": id}
+}
+
+func (s *EncryptingWebhookStore) seal(ctx context.Context, wh Webhook) (Webhook, error) {
+	if wh.Secret == "" || strings.HasPrefix(wh.Secret, encryptedPrefix) {
+		return wh, nil
+	}
+	out, err := s.kms.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(s.keyID),
+		Plaintext:         []byte(wh.Secret),
+		EncryptionContext: webhookEncryptionContext(wh.ID),
+	})
+	if err != nil {
+		return Webhook{}, fmt.Errorf("encrypting webhook secret: %w", err)
+	}
+	wh.Secret = encryptedPrefix + base64.StdEncoding.EncodeToString(out.CiphertextBlob)
+	return wh, nil
+}
+
+// open decrypts wh's secret, recording it in opened when that is non-nil.
+func (s *EncryptingWebhookStore) open(ctx context.Context, wh Webhook, opened map[string]string) (Webhook, error) {
+	sealed := wh.Secret
+	b64, ok := strings.CutPrefix(sealed, encryptedPrefix)
+	if !ok {
+		return wh, nil
+	}
+	s.mu.Lock()
+	plain, cached := s.opened[sealed]
+	s.mu.Unlock()
+	if !cached {
+		blob, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return Webhook{}, fmt.Errorf("decoding webhook secret of %s: %w", wh.ID, err)
+		}
+		out, err := s.kms.Decrypt(ctx, &kms.DecryptInput{
+			CiphertextBlob:    blob,
+			KeyId:             aws.String(s.keyID),
+			EncryptionContext: webhookEncryptionContext(wh.ID),
+		})
+		if err != nil {
+			return Webhook{}, fmt.Errorf("decrypting webhook secret of %s: %w", wh.ID, err)
+		}
+		plain = string(out.Plaintext)
+		s.mu.Lock()
+		s.opened[sealed] = plain
+		s.mu.Unlock()
+	}
+	if opened != nil {
+		opened[sealed] = plain
+	}
+	wh.Secret = plain
+	return wh, nil
+}
+
+func (s *EncryptingWebhookStore) CreateWebhook(ctx context.Context, wh Webhook) error {
+	sealed, err := s.seal(ctx, wh)
+	if err != nil {
+		return err
+	}
+	return s.WebhookStore.CreateWebhook(ctx, sealed)
+}
+
+func (s *EncryptingWebhookStore) UpdateWebhook(ctx context.Context, wh Webhook) error {
+	sealed, err := s.seal(ctx, wh)
+	if err != nil {
+		return err
+	}
+	return s.WebhookStore.UpdateWebhook(ctx, sealed)
+}
+
+func (s *EncryptingWebhookStore) GetWebhook(ctx context.Context, id string) (Webhook, error) {
+	wh, err := s.WebhookStore.GetWebhook(ctx, id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return s.open(ctx, wh, nil)
+}
+
+func (s *EncryptingWebhookStore) ListWebhooks(ctx context.Context, owner string) ([]Webhook, error) {
+	list, err := s.WebhookStore.ListWebhooks(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	var opened map[string]string
+	if owner == "" {
+		opened = make(map[string]string, len(list))
+	}
+	for i := range list {
+		if list[i], err = s.open(ctx, list[i], opened); err != nil {
+			return nil, err
+		}
+	}
+	if opened != nil {
+		s.mu.Lock()
+		s.opened = opened
+		s.mu.Unlock()
+	}
+	return list, nil
+}
+
+// NewWebhookStore returns store as the WebhookStore, encrypting secrets
+// when a KMS key is configured.
+func NewWebhookStore(ctx context.Context, cfg Config, store *DynamoStore) (WebhookStore, error) {
+	if cfg.KMSKeyID == "" {
+		return store, nil
+	}
+	client, err := NewKMSClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptingWebhookStore(store, client, cfg.KMSKeyID), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// fakeKMS "encrypts" by prefixing the plaintext with its encryption context,
+// which is enough to check that the context is enforced on decrypt.
+type fakeKMS struct{}
+
+func contextTag(ec map[string]string) []byte {
+	return []byte(ec["userId"] + "/" + ec["key"] + ec["webhookId"] + "|")
+}
+
+func (fakeKMS) Encrypt(_ context.Context, in *kms.EncryptInput, _ ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	return &kms.EncryptOutput{CiphertextBlob: append(contextTag(in.EncryptionContext), in.Plaintext...)}, nil
+}
+
+func (fakeKMS) Decrypt(_ context.Context, in *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	plain, ok := bytes.CutPrefix(in.CiphertextBlob, contextTag(in.EncryptionContext))
+	if !ok {
+		return nil, errors.New("InvalidCiphertextException")
+	}
+	return &kms.DecryptOutput{Plaintext: plain}, nil
+}
+
+func TestEncryptingStore(t *testing.T) {
+	ctx := context.Background()
+	inner := newMockStore()
+	s := NewEncryptingStore(inner, fakeKMS{}, "alias/prefs", []string{"notification_email"})
+
+	if _, err := s.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark", "notification_email": "a@example.com"}, Precondition{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stored := inner.prefs["user1"]
+	if stored["theme"] != "dark" {
+		t.Fatalf("expected non-sensitive value stored as-is, got %q", stored["theme"])
+	}
+	sealed, _ := stored["notification_email"].(string)
+	if !strings.HasPrefix(sealed, encryptedPrefix) || strings.Contains(sealed, "a@example.com") {
+		t.Fatalf("expected sensitive value encrypted at rest, got %q", stored["notification_email"])
+	}
+	raw := maps.Clone(stored)
+
+	all, err := s.GetAll(ctx, "user1")
+	if err != nil || all.Prefs["notification_email"] != "a@example.com" {
+		t.Fatalf("expected decrypted GetAll, got %v (%v)", all.Prefs, err)
+	}
+	if !maps.Equal(inner.prefs["user1"], raw) {
+		t.Fatal("expected reads not to modify stored values")
+	}
+
+	v, found, _, err := s.Get(ctx, "user1", "notification_email")
+	if err != nil || !found || v != "a@example.com" {
+		t.Fatalf("expected decrypted Get, got %q %v %v", v, found, err)
+	}
+
+	merged, err := s.Update(ctx, "user1", map[string]any{"notification_email": "b@example.com"}, nil, Precondition{})
+	if err != nil || merged.Prefs["notification_email"] != "b@example.com" {
+		t.Fatalf("expected decrypted merge result, got %v (%v)", merged.Prefs, err)
+	}
+
+	// Ciphertext copied to another user must not decrypt.
+	inner.prefs["user2"] = map[string]any{"notification_email": inner.prefs["user1"]["notification_email"]}
+	if _, _, _, err := s.Get(ctx, "user2", "notification_email"); err == nil {
+		t.Fatal("expected ciphertext bound to user1 to fail for user2")
+	}
+}
+
+func TestEncryptingStore_TypedValues(t *testing.T) {
+	ctx := context.Background()
+	inner := newMockStore()
+	s := NewEncryptingStore(inner, fakeKMS{}, "alias/prefs", []string{"recovery_codes"})
+
+	codes := []any{"a1", "b2"}
+	if _, err := s.ReplaceAll(ctx, "user1", map[string]any{"recovery_codes": codes, "font_size": json.Number("14")}, Precondition{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sealed, _ := inner.prefs["user1"]["recovery_codes"].(string)
+	if !strings.HasPrefix(sealed, encryptedJSONPrefix) {
+		t.Fatalf("expected non-string sensitive value sealed as JSON, got %v", inner.prefs["user1"]["recovery_codes"])
+	}
+	if inner.prefs["user1"]["font_size"] != json.Number("14") {
+		t.Fatalf("expected non-sensitive value stored as-is, got %v", inner.prefs["user1"]["font_size"])
+	}
+
+	v, found, _, err := s.Get(ctx, "user1", "recovery_codes")
+	if err != nil || !found || !reflect.DeepEqual(v, codes) {
+		t.Fatalf("expected decrypted array, got %v %v %v", v, found, err)
+	}
+}
+
+func TestEncryptingWebhookStore(t *testing.T) {
+	ctx := context.Background()
+	inner := newMockWebhookStore()
+	inner.items["old"] = Webhook{ID: "old", Secret: "plaintext-secret-1"}
+	s := NewEncryptingWebhookStore(inner, fakeKMS{}, "alias/prefs")
+
+	if err := s.CreateWebhook(ctx, Webhook{ID: "a", Owner: "crm", Secret: "s3cret-s3cret-s3"}); err != nil {
+		t.Fatal(err)
+	}
+	sealed := inner.items["a"].Secret
+	if !strings.HasPrefix(sealed, encryptedPrefix) || strings.Contains(sealed, "s3cret") {
+		t.Fatalf("expected the secret encrypted at rest, got %q", sealed)
+	}
+	if wh, err := s.GetWebhook(ctx, "a"); err != nil || wh.Secret != "s3cret-s3cret-s3" {
+		t.Fatalf("expected the secret decrypted, got %+v %v", wh, err)
+	}
+	list, err := s.ListWebhooks(ctx, "")
+	if err != nil || len(list) != 2 {
+		t.Fatalf("expected both webhooks, got %+v %v", list, err)
+	}
+	for _, wh := range list {
+		if wh.Secret != map[string]string{"a": "s3cret-s3cret-s3", "old": "plaintext-secret-1"}[wh.ID] {
+			t.Fatalf("unexpected secret for %s: %q", wh.ID, wh.Secret)
+		}
+	}
+
+	// A secret moved to another subscription does not decrypt.
+	inner.items["b"] = Webhook{ID: "b", Secret: sealed}
+	s = NewEncryptingWebhookStore(inner, fakeKMS{}, "alias/prefs")
+	if _, err := s.GetWebhook(ctx, "b"); err == nil {
+		t.Fatal("expected a copied secret to fail decryption")
+	}
+
+	old := inner.items["old"]
+	if err := s.UpdateWebhook(ctx, old); err != nil || !strings.HasPrefix(inner.items["old"].Secret, encryptedPrefix) {
+		t.Fatalf("expected a plaintext secret sealed on update, got %q %v", inner.items["old"].Secret, err)
+	}
+}
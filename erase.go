@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// ErasureResponse reports what was removed for a user.
+type ErasureResponse struct {
+	UserID         string `json:"userId"`
+	HistoryEntries int    `json:"historyEntries"`
+	Corrections    int    `json:"corrections"`
+	Devices        int    `json:"devices"`
+	// Records counts webhook delivery records and stored Idempotency-Key
+	// responses.
+	Records int `json:"records"`
+}
+
+// UserDataStore deletes the records kept apart from a user's preferences
+// that hold their values: webhook delivery records, whose events carry
+// changed values, and stored Idempotency-Key responses.
+type UserDataStore interface {
+	DeleteUserData(ctx context.Context, userID string) (int, error)
+}
+
+// EraseHandler deletes everything held about a user, for the
+// account-deletion pipeline.
+type EraseHandler struct {
+	prefs       *PreferencesHandler
+	corrections CorrectionStore
+	layers      LayerStore
+	userData    UserDataStore
+	// history is nil when preference history is disabled.
+	history HistoryStore
+	// devices is nil when device preferences are disabled.
+	devices *DevicesHandler
+	// standby is the failover standby's store, or nil.
+	standby Store
+}
+
+// NewEraseHandler creates an erase handler; history, devices and standby
+// may be nil.
+func NewEraseHandler(prefs *PreferencesHandler, corrections CorrectionStore, layers LayerStore, userData UserDataStore, history HistoryStore, devices *DevicesHandler, standby Store) *EraseHandler {
+	return &EraseHandler{prefs: prefs, corrections: corrections, layers: layers, userData: userData, history: history, devices: devices, standby: standby}
+}
+
+// AdminDeleteUser removes a user's preferences (including soft-deleted ones,
+// undo snapshots, device preferences and the standby's copy), history,
+// correction requests, webhook delivery records and Idempotency-Key
+// results, and org/team membership, and records the erasure in the audit
+// log. Every step is idempotent, so a failed call is retried as a whole.
+func (h *EraseHandler) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userId")
+	ctx := r.Context()
+	fail := func(op string, err error) {
+		h.prefs.logger.Error(op+" failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to delete user")
+	}
+
+	// Preferences first: with history enabled, deleting them appends an
+	// entry, which the history deletion below then removes.
+	if err := h.prefs.store.DeleteAll(ctx, userID); err != nil {
+		fail("store.DeleteAll", err)
+		return
+	}
+	// Directly, rather than through replication, so the call fails while
+	// the standby still holds them.
+	if h.standby != nil {
+		if err := h.standby.DeleteAll(ctx, userID); err != nil {
+			fail("standby.DeleteAll", err)
+			return
+		}
+	}
+	if trash := h.prefs.opts.Trash; trash != nil {
+		if err := trash.DeleteAll(ctx, userID); err != nil {
+			fail("trash.DeleteAll", err)
+			return
+		}
+	}
+	if undo := h.prefs.opts.Undo; undo != nil {
+		if err := undo.DeleteAll(ctx, userID); err != nil {
+			fail("undo.DeleteAll", err)
+			return
+		}
+	}
+	resp := ErasureResponse{UserID: userID}
+	if h.devices != nil {
+		n, err := h.devices.EraseUser(ctx, userID)
+		if err != nil {
+			fail("devices.EraseUser", err)
+			return
+		}
+		resp.Devices = n
+	}
+	if h.history != nil {
+		n, err := h.history.DeleteHistory(ctx, userID)
+		if err != nil {
+			fail("history.DeleteHistory", err)
+			return
+		}
+		resp.HistoryEntries = n
+	}
+
+	corrections, err := h.corrections.ListCorrections(ctx, userID, "")
+	if err != nil {
+		fail("corrections.ListCorrections", err)
+		return
+	}
+	for _, c := range corrections {
+		if err := h.corrections.DeleteCorrection(ctx, c.ID); err != nil {
+			fail("corrections.DeleteCorrection", err)
+			return
+		}
+	}
+	resp.Corrections = len(corrections)
+
+	// After the deletions above, which may have queued webhook deliveries.
+	n, err := h.userData.DeleteUserData(ctx, userID)
+	if err != nil {
+		fail("userData.DeleteUserData", err)
+		return
+	}
+	resp.Records = n
+
+	if err := h.layers.DeleteMembership(ctx, userID); err != nil {
+		fail("layers.DeleteMembership", err)
+		return
+	}
+
+	recordAdminAction(r, "user.erase", "historyEntries", resp.HistoryEntries, "corrections", resp.Corrections, "records", resp.Records)
+	claims, _ := ClaimsFromContext(ctx)
+	h.prefs.logger.Info("user erased", "sub", claims.Subject, "userId", userID)
+	writeJSON(w, http.StatusOK, resp)
+}
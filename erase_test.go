@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memUserData implements UserDataStore for testing: the number of records
+// held for each user.
+type memUserData map[string]int
+
+func (m memUserData) DeleteUserData(_ context.Context, userID string) (int, error) {
+	n := m[userID]
+	delete(m, userID)
+	return n, nil
+}
+
+func TestAdminDeleteUser(t *testing.T) {
+	inner, hist := newMockStore(), newMemHistory()
+	store := NewHistoryRecorder(inner, hist, nil)
+	corrections, layers := newMockCorrectionStore(), newMemLayers()
+
+	inner.prefs["user1"] = map[string]any{"theme": "dark"}
+	inner.prefs["user2"] = map[string]any{"theme": "light"}
+	hist.entries["user1"] = []HistoryEntry{{ID: "1"}, {ID: "2"}}
+	corrections.items["c1"] = CorrectionRequest{ID: "c1", UserID: "user1", CreatedAt: time.Now()}
+	corrections.items["c2"] = CorrectionRequest{ID: "c2", UserID: "user2", CreatedAt: time.Now()}
+	layers.memberships["user1"] = Membership{OrgID: "acme"}
+	trash := newMockStore()
+	trash.prefs["user1"] = map[string]any{"theme": "light"}
+	undo := newMockStore()
+	undo.prefs["user1"] = map[string]any{"theme": "blue"}
+	standby := newMockStore()
+	standby.prefs["user1"] = map[string]any{"theme": "dark"}
+	userData := memUserData{"user1": 3, "user2": 1}
+
+	h := NewEraseHandler(NewPreferencesHandler(store, testLogger(), HandlerOptions{Trash: trash, TrashRetention: time.Hour, Undo: undo, UndoWindow: time.Minute}), corrections, layers, userData, hist, nil, standby)
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api/v1/admin/users/{userId}", h.AdminDeleteUser)
+	var audit bytes.Buffer
+	handler := Audit(NewAuditLog(&audit))(mux)
+
+	req := httptest.NewRequest("DELETE", "/api/v1/admin/users/user1", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, withClaims(req, "admin"))
+
+	var resp ErasureResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	// The two entries plus the one recording the deletion itself.
+	if w.Code != http.StatusOK || resp.HistoryEntries != 3 || resp.Corrections != 1 || resp.Records != 3 {
+		t.Fatalf("unexpected response %d %+v", w.Code, resp)
+	}
+	if _, ok := inner.prefs["user1"]; ok {
+		t.Fatal("expected preferences deleted")
+	}
+	if _, ok := trash.prefs["user1"]; ok {
+		t.Fatal("expected soft-deleted preferences erased")
+	}
+	if _, ok := undo.prefs["user1"]; ok {
+		t.Fatal("expected the undo snapshot erased")
+	}
+	if _, ok := standby.prefs["user1"]; ok {
+		t.Fatal("expected the standby's copy erased")
+	}
+	if _, ok := userData["user2"]; !ok || len(userData) != 1 {
+		t.Fatalf("expected only user1's records deleted, got %v", userData)
+	}
+	if len(hist.entries["user1"]) != 0 || len(corrections.items) != 1 || len(layers.memberships) != 0 {
+		t.Fatalf("expected derived data deleted, got %v %v %v", hist.entries["user1"], corrections.items, layers.memberships)
+	}
+	if inner.prefs["user2"]["theme"] != "light" {
+		t.Fatal("expected other users untouched")
+	}
+	if !strings.Contains(audit.String(), `"action":"user.erase"`) || !strings.Contains(audit.String(), `"targetUserId":"user1"`) {
+		t.Fatalf("expected an audit record, got %s", audit.String())
+	}
+
+	// Repeating the call succeeds with nothing left to remove.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, withClaims(httptest.NewRequest("DELETE", "/api/v1/admin/users/user1", nil), "admin"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected a repeat to succeed, got %d", w.Code)
+	}
+}
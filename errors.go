@@ -3,12 +3,102 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 )
 
-// APIError represents a structured error response.
+// APIError represents a structured error response. Code is the HTTP status,
+// kept for existing clients; ErrorCode is the stable, machine-readable code
+// clients should branch on, documented at DocURL.
 type APIError struct {
-	Error string `json:"error"`
-	Code  int    `json:"code"`
+	Error     string `json:"error"`
+	Code      int    `json:"code"`
+	ErrorCode string `json:"errorCode"`
+	DocURL    string `json:"docUrl,omitempty"`
+	// Violations lists each problem with a rejected write (422).
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// Violation is one reason a write was rejected. Key is empty for problems
+// with the map as a whole.
+type Violation struct {
+	Key    string `json:"key,omitempty"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// Error codes. They are part of the API: never change or reuse one. Codes
+// without a more specific meaning follow the status (statusErrorCode).
+const (
+	ErrCodeBadRequest           = "BAD_REQUEST"
+	ErrCodeInvalidBody          = "INVALID_BODY"
+	ErrCodeInvalidCursor        = "INVALID_CURSOR"
+	ErrCodeCursorExpired        = "CURSOR_EXPIRED"
+	ErrCodeUnauthenticated      = "UNAUTHENTICATED"
+	ErrCodeForbidden            = "FORBIDDEN"
+	ErrCodeCSRFRejected         = "CSRF_REJECTED"
+	ErrCodeNotFound             = "NOT_FOUND"
+	ErrCodePrefNotFound         = "PREF_NOT_FOUND"
+	ErrCodeUserNotFound         = "USER_NOT_FOUND"
+	ErrCodeNothingToRestore     = "NOTHING_TO_RESTORE"
+	ErrCodeUndoUnavailable      = "UNDO_UNAVAILABLE"
+	ErrCodeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	ErrCodeConflict             = "CONFLICT"
+	ErrCodePrefExists           = "PREF_EXISTS"
+	ErrCodeIdempotencyConflict  = "IDEMPOTENCY_KEY_IN_PROGRESS"
+	ErrCodePreconditionFailed   = "PRECONDITION_FAILED"
+	ErrCodeValidationFailed     = "VALIDATION_FAILED"
+	ErrCodeQuotaExceeded        = "QUOTA_EXCEEDED"
+	ErrCodeTooManyKeys          = "TOO_MANY_KEYS"
+	ErrCodeIdempotencyReused    = "IDEMPOTENCY_KEY_REUSED"
+	ErrCodePreconditionRequired = "PRECONDITION_REQUIRED"
+	ErrCodeTooManyRequests      = "TOO_MANY_REQUESTS"
+	ErrCodeInternal             = "INTERNAL"
+	ErrCodeUnavailable          = "UNAVAILABLE"
+
+	// Violation codes.
+	ErrCodeKeyInvalid    = "PREF_KEY_INVALID"
+	ErrCodeKeyNotAllowed = "PREF_KEY_NOT_ALLOWED"
+	ErrCodeKeyDeprecated = "PREF_KEY_DEPRECATED"
+	ErrCodeValueInvalid  = "PREF_VALUE_INVALID"
+	ErrCodeSchemaInvalid = "SCHEMA_INVALID"
+)
+
+// errorDocsURL documents every error code, under an anchor of its own.
+const errorDocsURL = "https://github.com/wozniakbe/user-prefs/blob/main/docs/errors.md"
+
+// errorDocURL returns the documentation link for an error code.
+func errorDocURL(code string) string {
+	return errorDocsURL + "#" + strings.ToLower(code)
+}
+
+// statusErrorCode is the code of errors with nothing more specific to say
+// than their status.
+func statusErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthenticated
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrCodeMethodNotAllowed
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusPreconditionFailed:
+		return ErrCodePreconditionFailed
+	case http.StatusUnprocessableEntity:
+		return ErrCodeValidationFailed
+	case http.StatusPreconditionRequired:
+		return ErrCodePreconditionRequired
+	case http.StatusTooManyRequests:
+		return ErrCodeTooManyRequests
+	case http.StatusServiceUnavailable:
+		return ErrCodeUnavailable
+	}
+	return ErrCodeInternal
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -17,6 +107,23 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	json.NewEncoder(w).Encode(v)
 }
 
+// writeError writes an error whose code follows from its status.
 func writeError(w http.ResponseWriter, status int, msg string) {
-	writeJSON(w, status, APIError{Error: msg, Code: status})
+	writeErrorCode(w, status, statusErrorCode(status), msg)
+}
+
+// writeErrorCode writes an error with a specific code.
+func writeErrorCode(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, APIError{Error: msg, Code: status, ErrorCode: code, DocURL: errorDocURL(code)})
+}
+
+// writeViolations rejects a write with 422, listing each violation.
+func writeViolations(w http.ResponseWriter, code, msg string, v []Violation) {
+	writeJSON(w, http.StatusUnprocessableEntity, APIError{
+		Error:      msg,
+		Code:       http.StatusUnprocessableEntity,
+		ErrorCode:  code,
+		DocURL:     errorDocURL(code),
+		Violations: v,
+	})
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestErrorCodesDocumented checks that every ErrCode constant has an entry
+// in docs/errors.md, where its docUrl points.
+func TestErrorCodesDocumented(t *testing.T) {
+	doc, err := os.ReadFile("docs/errors.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	ast.Inspect(f, func(node ast.Node) bool {
+		spec, ok := node.(*ast.ValueSpec)
+		if !ok || !strings.HasPrefix(spec.Names[0].Name, "ErrCode") {
+			return true
+		}
+		code, _ := strconv.Unquote(spec.Values[0].(*ast.BasicLit).Value)
+		if !strings.Contains(string(doc), "\n### "+code+"\n") {
+			t.Errorf("%s is not documented", code)
+		}
+		n++
+		return true
+	})
+	if n == 0 {
+		t.Fatal("found no error codes")
+	}
+}
+
+func TestStatusErrorCode(t *testing.T) {
+	for status, want := range map[int]string{400: ErrCodeBadRequest, 412: ErrCodePreconditionFailed, 418: ErrCodeInternal, 503: ErrCodeUnavailable} {
+		if got := statusErrorCode(status); got != want {
+			t.Errorf("statusErrorCode(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
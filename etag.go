@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatETag returns the strong entity tag for a record: its version and,
+// when it has one, its generation, as "<version>.<generation>". Versions
+// restart when an item is deleted and recreated; the generation keeps the
+// tags of the two items apart.
+func formatETag(rec Record) string {
+	return `"` + versionTag(rec) + `"`
+}
+
+func versionTag(rec Record) string {
+	tag := strconv.FormatInt(rec.Version, 10)
+	if rec.Generation != "" {
+		tag += "." + rec.Generation
+	}
+	return tag
+}
+
+// effectiveETag tags the effective view, which changes with the defaults as
+// well as the record. It is not a version, so If-Match never accepts it.
+func effectiveETag(rec Record, defaultsHash string) string {
+	return `"` + versionTag(rec) + "-" + defaultsHash + `"`
+}
+
+// setValidators sets the ETag and Last-Modified headers for rec, if the user
+// has a stored record.
+func setValidators(w http.ResponseWriter, rec Record) {
+	if rec.Prefs != nil {
+		writeValidators(w, rec)
+	}
+}
+
+// writeValidators sets the ETag and Last-Modified headers for rec, which the
+// caller knows to be stored.
+func writeValidators(w http.ResponseWriter, rec Record) {
+	w.Header().Set("ETag", formatETag(rec))
+	if !rec.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", rec.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified reports whether a conditional GET can be answered with 304.
+// As in RFC 9110, If-Modified-Since is ignored when If-None-Match is sent.
+func notModified(r *http.Request, rec Record) bool {
+	return rec.Prefs != nil && unchanged(r, rec)
+}
+
+// unchanged is notModified for a record the caller knows to be stored.
+func unchanged(r *http.Request, rec Record) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return noneMatchHits(inm, formatETag(rec))
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || rec.UpdatedAt.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have one-second resolution.
+	return !rec.UpdatedAt.Truncate(time.Second).After(since)
+}
+
+// noneMatchHits reports whether an If-None-Match header value matches etag,
+// using weak comparison.
+func noneMatchHits(inm, etag string) bool {
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIfMatch turns an If-Match header into a store precondition. present
+// is false when the header is absent. Tags this server never issued (weak
+// tags, or anything that is not a version) cannot match, so ok is false and
+// the request should fail with 412. A record has one generation, so tags of
+// a generation other than the first listed cannot match either and are
+// dropped; tags without one are from before the item had a generation.
+func parseIfMatch(r *http.Request) (cond Precondition, present, ok bool) {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		return Precondition{}, false, true
+	}
+	generation := ""
+	for _, v := range values {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" {
+				continue
+			}
+			if tag == "*" {
+				cond.MustExist = true
+				continue
+			}
+			unquoted, found := strings.CutPrefix(tag, `"`)
+			unquoted, closed := strings.CutSuffix(unquoted, `"`)
+			if !found || !closed {
+				continue
+			}
+			version, gen, _ := strings.Cut(unquoted, ".")
+			n, err := strconv.ParseInt(version, 10, 64)
+			// Effective view tags carry a "-" suffix and are never versions.
+			if err != nil || n < 0 || strings.Contains(gen, "-") {
+				continue
+			}
+			if generation == "" {
+				generation = gen
+			} else if gen != "" && gen != generation {
+				continue
+			}
+			cond.Versions = append(cond.Versions, n)
+		}
+	}
+	if !cond.MustExist && len(cond.Versions) == 0 {
+		return Precondition{}, true, false
+	}
+	if cond.MustExist {
+		// "*" matches any current record, whatever else is listed.
+		cond.Versions = nil
+		return cond, true, true
+	}
+	cond.Generation = generation
+	return cond, true, true
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// EventBridge detail-types of change events (docs/events.md).
+const (
+	DetailTypePreferencesUpdated = "Preferences Updated"
+	DetailTypePreferencesDeleted = "Preferences Deleted"
+)
+
+// eventBridgeAPI is the subset of the EventBridge client used, for testing.
+type eventBridgeAPI interface {
+	PutEvents(ctx context.Context, in *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// PreferenceChangeDetail is the detail of an EventBridge change event; its
+// schema is documented in docs/events.md.
+type PreferenceChangeDetail struct {
+	ID      string                     `json:"id"`
+	UserID  string                     `json:"userId"`
+	Version int64                      `json:"version"`
+	At      time.Time                  `json:"at"`
+	By      string                     `json:"by,omitempty"`
+	Changes map[string]PreferenceDelta `json:"changes"`
+}
+
+// PreferenceDelta is one key's change: Old is null for a key that was not
+// set, New for a key that was removed. Both are redactedValue for sensitive
+// keys.
+type PreferenceDelta struct {
+	Old      any  `json:"old"`
+	New      any  `json:"new"`
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// EventBridgePublisher puts change events on an EventBridge bus, with the
+// old and new value of each changed key. With redactSensitive set, changes
+// to sensitive keys are included with redacted values; otherwise they are
+// left out, as by every other sink.
+type EventBridgePublisher struct {
+	client          eventBridgeAPI
+	bus             string
+	source          string
+	redactSensitive bool
+}
+
+// NewEventBridgePublisher puts events from source on the bus named bus.
+func NewEventBridgePublisher(client eventBridgeAPI, bus, source string, redactSensitive bool) *EventBridgePublisher {
+	return &EventBridgePublisher{client: client, bus: bus, source: source, redactSensitive: redactSensitive}
+}
+
+// NewEventBridgeClient creates an EventBridge client for the configured
+// region.
+func NewEventBridgeClient(ctx context.Context, cfg Config) (*eventbridge.Client, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.AWSRegion))
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return eventbridge.NewFromConfig(awsCfg), nil
+}
+
+// ReportsSensitive is true when sensitive keys are reported redacted.
+func (p *EventBridgePublisher) ReportsSensitive() bool { return p.redactSensitive }
+
+func (p *EventBridgePublisher) Publish(ctx context.Context, e ChangeEvent) error {
+	detail, err := json.Marshal(p.detail(e))
+	if err != nil {
+		return err
+	}
+	detailType := DetailTypePreferencesUpdated
+	if e.Type == EventPreferencesDeleted {
+		detailType = DetailTypePreferencesDeleted
+	}
+	out, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{{
+			EventBusName: aws.String(p.bus),
+			Source:       aws.String(p.source),
+			DetailType:   aws.String(detailType),
+			Detail:       aws.String(string(detail)),
+			Time:         aws.Time(e.At),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("PutEvents: %w", err)
+	}
+	if out.FailedEntryCount > 0 && len(out.Entries) > 0 {
+		entry := out.Entries[0]
+		return fmt.Errorf("PutEvents: %s: %s", aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+	}
+	return nil
+}
+
+// detail builds the event detail for e.
+func (p *EventBridgePublisher) detail(e ChangeEvent) PreferenceChangeDetail {
+	d := PreferenceChangeDetail{
+		ID:      e.ID,
+		UserID:  e.UserID,
+		Version: e.Version,
+		At:      e.At,
+		By:      e.By,
+		Changes: make(map[string]PreferenceDelta, len(e.Changes)),
+	}
+	for k, v := range e.Changes {
+		d.Changes[k] = PreferenceDelta{Old: e.Previous[k], New: v}
+	}
+	if p.redactSensitive {
+		for _, k := range e.Sensitive {
+			d.Changes[k] = PreferenceDelta{Old: redactedValue, New: redactedValue, Redacted: true}
+		}
+	}
+	return d
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+)
+
+// fakeEventBridge records put events.
+type fakeEventBridge struct {
+	entries []*eventbridge.PutEventsInput
+}
+
+func (f *fakeEventBridge) PutEvents(_ context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.entries = append(f.entries, in)
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+func TestEventBridgePublisher(t *testing.T) {
+	for _, redact := range []bool{false, true} {
+		client := &fakeEventBridge{}
+		sink := NewAsyncSink("eventbridge", NewEventBridgePublisher(client, "prefs-bus", "user-prefs", redact), testLogger())
+		store := NewChangePublisher(newMockStore(), []string{"ssn"}, testLogger(), sink)
+		ctx := context.Background()
+		store.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark", "lang": "en"}, Precondition{})
+		store.Update(ctx, "user1", map[string]any{"theme": "light", "ssn": "123"}, []string{"lang"}, Precondition{})
+		store.Update(ctx, "user1", map[string]any{"ssn": "456"}, nil, Precondition{})
+		store.DeleteAll(ctx, "user1")
+		sink.Close(ctx)
+
+		want := 3
+		if redact {
+			want = 4
+		}
+		if len(client.entries) != want {
+			t.Fatalf("redact=%v: expected %d events, got %d", redact, want, len(client.entries))
+		}
+		entry := client.entries[1].Entries[0]
+		if *entry.EventBusName != "prefs-bus" || *entry.Source != "user-prefs" || *entry.DetailType != DetailTypePreferencesUpdated {
+			t.Fatalf("unexpected entry %+v", entry)
+		}
+		var d PreferenceChangeDetail
+		if err := json.Unmarshal([]byte(*entry.Detail), &d); err != nil {
+			t.Fatal(err)
+		}
+		if d.UserID != "user1" || d.Version != 2 || d.Changes["theme"] != (PreferenceDelta{Old: "dark", New: "light"}) ||
+			d.Changes["lang"] != (PreferenceDelta{Old: "en", New: nil}) {
+			t.Fatalf("unexpected detail %+v", d)
+		}
+		ssn, ok := d.Changes["ssn"]
+		if redact != ok || (ok && ssn != (PreferenceDelta{Old: redactedValue, New: redactedValue, Redacted: true})) {
+			t.Fatalf("redact=%v: unexpected sensitive change %+v", redact, d.Changes)
+		}
+		if last := client.entries[len(client.entries)-1].Entries[0]; *last.DetailType != DetailTypePreferencesDeleted {
+			t.Fatalf("expected a delete last, got %s", *last.DetailType)
+		}
+	}
+}
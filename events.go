@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// EventPublisher sends change events to a message broker.
+type EventPublisher interface {
+	Publish(ctx context.Context, e ChangeEvent) error
+}
+
+// BatchPublisher is implemented by publishers that send several events per
+// call. AsyncSink hands them up to MaxBatch queued events at once;
+// PublishBatch returns the events it failed to send, with an error, so only
+// those are retried.
+type BatchPublisher interface {
+	EventPublisher
+	MaxBatch() int
+	PublishBatch(ctx context.Context, events []ChangeEvent) (failed []ChangeEvent, err error)
+}
+
+// DeadLetterer is implemented by publishers that can set aside events
+// AsyncSink has given up on, rather than have them dropped.
+type DeadLetterer interface {
+	DeadLetter(ctx context.Context, events []ChangeEvent, cause error) error
+}
+
+// Tuning for AsyncSink: queued events beyond asyncQueueSize are dropped;
+// each event is tried asyncMaxAttempts times, waiting asyncBackoff after
+// the first failure and doubling it after each further one.
+const (
+	asyncQueueSize   = 10000
+	asyncMaxAttempts = 5
+	asyncTimeout     = 10 * time.Second
+)
+
+var asyncBackoff = 200 * time.Millisecond
+
+// AsyncSink is a ChangeSink that hands events to an EventPublisher in the
+// background, so broker latency and outages never slow writes. One worker
+// publishes in order, in batches for a BatchPublisher, retrying failures
+// with exponential backoff; an event that still fails is dead-lettered by a
+// DeadLetterer, and otherwise, like one arriving while the queue is full,
+// logged and dropped.
+type AsyncSink struct {
+	name   string
+	pub    EventPublisher
+	logger *slog.Logger
+	queue  chan ChangeEvent
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsyncSink creates a sink for pub, named in logs by name, and starts its
+// worker. Call Close when shutting down.
+func NewAsyncSink(name string, pub EventPublisher, logger *slog.Logger) *AsyncSink {
+	s := &AsyncSink{
+		name:   name,
+		pub:    pub,
+		logger: logger,
+		queue:  make(chan ChangeEvent, asyncQueueSize),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Listening is always true: brokers want every event.
+func (s *AsyncSink) Listening() bool { return true }
+
+// ReportsSensitive passes on whether the publisher reports sensitive keys.
+func (s *AsyncSink) ReportsSensitive() bool { return reportsSensitive(s.pub) }
+
+// PublishChange queues e without blocking.
+func (s *AsyncSink) PublishChange(_ context.Context, e ChangeEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- e:
+	default:
+		s.logger.Error("event queue full; dropping event", "sink", s.name, "eventId", e.ID, "userId", e.UserID)
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are
+// published, and the publisher closed if it is an io.Closer, or ctx is
+// done.
+func (s *AsyncSink) Close(ctx context.Context) {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.logger.Warn("shutdown before queued events were published", "sink", s.name, "pending", len(s.queue))
+	}
+}
+
+func (s *AsyncSink) run() {
+	defer close(s.done)
+	size := 1
+	if b, ok := s.pub.(BatchPublisher); ok {
+		size = b.MaxBatch()
+	}
+	for e := range s.queue {
+		// Whatever else is already queued goes in the same batch.
+		batch := []ChangeEvent{e}
+	fill:
+		for len(batch) < size {
+			select {
+			case e, ok := <-s.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, e)
+			default:
+				break fill
+			}
+		}
+		s.deliver(batch)
+	}
+	if c, ok := s.pub.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			s.logger.Error("closing event publisher failed", "sink", s.name, "error", err)
+		}
+	}
+}
+
+// deliver publishes events, retrying those that failed with backoff.
+// Events still failing are dead-lettered if the publisher can, else
+// dropped.
+func (s *AsyncSink) deliver(events []ChangeEvent) {
+	wait := asyncBackoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), asyncTimeout)
+		failed, err := s.publish(ctx, events)
+		cancel()
+		if err == nil {
+			return
+		}
+		events = failed
+		if attempt == asyncMaxAttempts {
+			s.giveUp(events, err, attempt)
+			return
+		}
+		s.logger.Warn("event publish failed; retrying", "sink", s.name, "error", err, "events", len(events), "attempt", attempt)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// publish sends events, returning those not sent.
+func (s *AsyncSink) publish(ctx context.Context, events []ChangeEvent) ([]ChangeEvent, error) {
+	if b, ok := s.pub.(BatchPublisher); ok {
+		return b.PublishBatch(ctx, events)
+	}
+	for i, e := range events {
+		if err := s.pub.Publish(ctx, e); err != nil {
+			return events[i:], err
+		}
+	}
+	return nil, nil
+}
+
+// giveUp dead-letters or drops events that failed every attempt with err.
+func (s *AsyncSink) giveUp(events []ChangeEvent, err error, attempts int) {
+	if d, ok := s.pub.(DeadLetterer); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), asyncTimeout)
+		dlErr := d.DeadLetter(ctx, events, err)
+		cancel()
+		if dlErr == nil {
+			s.logger.Error("event publish failed; dead-lettered", "sink", s.name, "error", err, "events", len(events), "attempts", attempts)
+			return
+		}
+		s.logger.Error("dead-lettering failed", "sink", s.name, "error", dlErr)
+	}
+	for _, e := range events {
+		s.logger.Error("event publish failed; dropping event", "sink", s.name, "error", err, "eventId", e.ID, "userId", e.UserID, "attempts", attempts)
+	}
+}
+
+// NewBrokerSinks creates a sink for each configured message broker. Close
+// them on shutdown.
+func NewBrokerSinks(ctx context.Context, cfg Config, logger *slog.Logger) ([]*AsyncSink, error) {
+	var sinks []*AsyncSink
+	if cfg.SNSTopicARN != "" {
+		client, err := NewSNSClient(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating SNS client: %w", err)
+		}
+		sinks = append(sinks, NewAsyncSink("sns", NewSNSPublisher(client, cfg.SNSTopicARN, cfg.EventSource), logger))
+		logger.Info("publishing change events to SNS", "topic", cfg.SNSTopicARN)
+	}
+	if cfg.EventBridgeBusName != "" {
+		client, err := NewEventBridgeClient(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating EventBridge client: %w", err)
+		}
+		pub := NewEventBridgePublisher(client, cfg.EventBridgeBusName, cfg.EventBridgeSource, cfg.EventBridgeRedactSensitive)
+		sinks = append(sinks, NewAsyncSink("eventbridge", pub, logger))
+		logger.Info("publishing change events to EventBridge", "bus", cfg.EventBridgeBusName, "source", cfg.EventBridgeSource)
+	}
+	if cfg.SQSQueueURL != "" {
+		client, err := NewSQSClient(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating SQS client: %w", err)
+		}
+		sinks = append(sinks, NewAsyncSink("sqs", NewSQSPublisher(client, cfg.SQSQueueURL, cfg.SQSDeadLetterURL, cfg.EventSource), logger))
+		logger.Info("enqueueing change events on SQS", "queue", cfg.SQSQueueURL, "deadLetterQueue", cfg.SQSDeadLetterURL)
+	}
+	if len(cfg.KafkaBrokers) > 0 {
+		password := func() string { return cfg.KafkaSASLPassword }
+		if cfg.KafkaPasswordRef != nil {
+			password = cfg.KafkaPasswordRef.Value
+		}
+		sinks = append(sinks, NewAsyncSink("kafka", NewKafkaPublisher(NewKafkaWriter(cfg, password), cfg.EventSource), logger))
+		logger.Info("producing change events to Kafka", "brokers", cfg.KafkaBrokers, "topic", cfg.KafkaTopic, "sasl", cfg.KafkaSASLMechanism)
+	}
+	if cfg.NATSURL != "" {
+		nc, err := NewNATSConn(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, NewAsyncSink("nats", NewNATSPublisher(nc, cfg.NATSSubjectPrefix, cfg.EventSource), logger))
+		logger.Info("publishing change events to NATS", "subjects", cfg.NATSSubjectPrefix+".*")
+	}
+	return sinks, nil
+}
+
+// CloudEvent is the CloudEvents 1.0 envelope, in structured JSON mode, in
+// which change events are sent to brokers and webhooks. Data is the
+// ChangeEvent itself; the subject is the user ID.
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            ChangeEvent `json:"data"`
+}
+
+// cloudEventsContentType is the media type of an encoded CloudEvent.
+const cloudEventsContentType = "application/cloudevents+json"
+
+// encodeEvent is the message body brokers and webhooks carry for a change
+// event: a CloudEvent from source.
+func encodeEvent(e ChangeEvent, source string) ([]byte, error) {
+	return json.Marshal(CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              e.ID,
+		Source:          source,
+		Type:            e.Type,
+		Subject:         e.UserID,
+		Time:            e.At,
+		DataContentType: "application/json",
+		Data:            e,
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// experimentKeyPrefix namespaces stored assignments. It falls under the
+// default reserved "system." prefix, so clients cannot rewrite their own
+// bucket.
+const experimentKeyPrefix = "system.experiments."
+
+// Experiment is an A/B test whose buckets users are spread across in
+// proportion to their weights.
+type Experiment struct {
+	Name    string
+	Buckets []string
+	Weights []int
+}
+
+// LoadExperiments reads an experiments file (EXPERIMENTS_FILE): a JSON
+// object mapping each experiment name to an object of bucket names and
+// positive integer weights, such as {"checkout-v2": {"control": 1,
+// "treatment": 1}}. Experiments and buckets are returned in name order, so
+// assignment does not depend on the file's layout.
+func LoadExperiments(path string) ([]Experiment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading experiments: %w", err)
+	}
+	var raw map[string]map[string]int
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing experiments: %w", err)
+	}
+
+	experiments := make([]Experiment, 0, len(raw))
+	for _, name := range slices.Sorted(maps.Keys(raw)) {
+		if reason := keyViolation(experimentKeyPrefix+name, nil); reason != "" {
+			return nil, fmt.Errorf("experiment %q: %s", name, reason)
+		}
+		if len(raw[name]) == 0 {
+			return nil, fmt.Errorf("experiment %q has no buckets", name)
+		}
+		e := Experiment{Name: name}
+		for _, bucket := range slices.Sorted(maps.Keys(raw[name])) {
+			if raw[name][bucket] <= 0 {
+				return nil, fmt.Errorf("experiment %q: bucket %q must have a positive weight", name, bucket)
+			}
+			e.Buckets = append(e.Buckets, bucket)
+			e.Weights = append(e.Weights, raw[name][bucket])
+		}
+		experiments = append(experiments, e)
+	}
+	return experiments, nil
+}
+
+// assign picks the user's bucket from a hash of the experiment name and
+// user ID, so every instance assigns a user alike and users are spread
+// independently across experiments.
+func (e Experiment) assign(userID string) string {
+	sum := sha256.Sum256([]byte(e.Name + "\x00" + userID))
+	total := 0
+	for _, w := range e.Weights {
+		total += w
+	}
+	n := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for i, w := range e.Weights {
+		if n < w {
+			return e.Buckets[i]
+		}
+		n -= w
+	}
+	return e.Buckets[len(e.Buckets)-1]
+}
+
+// ExperimentsResponse is returned by GET /users/{userId}/experiments.
+type ExperimentsResponse struct {
+	UserID      string            `json:"userId"`
+	Assignments map[string]string `json:"assignments"`
+}
+
+// ExperimentResponse is returned by GET /users/{userId}/experiments/{experiment}.
+type ExperimentResponse struct {
+	UserID     string `json:"userId"`
+	Experiment string `json:"experiment"`
+	Bucket     string `json:"bucket"`
+}
+
+// ExperimentsHandler serves experiment assignments. A user is assigned on
+// the first read and the bucket is stored under experimentKeyPrefix, where
+// it stays stable if weights later change and can be found with the admin
+// search.
+type ExperimentsHandler struct {
+	prefs       *PreferencesHandler
+	experiments []Experiment
+}
+
+// NewExperimentsHandler creates an experiments handler sharing prefs'
+// options.
+func NewExperimentsHandler(prefs *PreferencesHandler, experiments []Experiment) *ExperimentsHandler {
+	return &ExperimentsHandler{prefs: prefs, experiments: experiments}
+}
+
+// List returns the user's bucket in every configured experiment.
+func (h *ExperimentsHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	assignments, err := h.assignments(r.Context(), userID, h.experiments)
+	if err != nil {
+		h.prefs.logger.Error("experiment assignment failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to assign experiments")
+		return
+	}
+	writeJSON(w, http.StatusOK, ExperimentsResponse{UserID: userID, Assignments: assignments})
+}
+
+// Get returns the user's bucket in one experiment.
+func (h *ExperimentsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	name := r.PathValue("experiment")
+	i := slices.IndexFunc(h.experiments, func(e Experiment) bool { return e.Name == name })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "experiment not found")
+		return
+	}
+	assignments, err := h.assignments(r.Context(), userID, h.experiments[i:i+1])
+	if err != nil {
+		h.prefs.logger.Error("experiment assignment failed", "error", err, "userId", userID, "experiment", name)
+		writeError(w, http.StatusInternalServerError, "failed to assign experiments")
+		return
+	}
+	writeJSON(w, http.StatusOK, ExperimentResponse{UserID: userID, Experiment: name, Bucket: assignments[name]})
+}
+
+// assignments returns the user's stored bucket in each experiment, storing
+// a new assignment where there is none or the stored bucket has since been
+// removed from the experiment.
+func (h *ExperimentsHandler) assignments(ctx context.Context, userID string, experiments []Experiment) (map[string]string, error) {
+	rec, err := h.prefs.store.GetAll(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(experiments))
+	assigned := map[string]any{}
+	for _, e := range experiments {
+		if bucket, ok := rec.Prefs[experimentKeyPrefix+e.Name].(string); ok && slices.Contains(e.Buckets, bucket) {
+			out[e.Name] = bucket
+			continue
+		}
+		out[e.Name] = e.assign(userID)
+		assigned[experimentKeyPrefix+e.Name] = out[e.Name]
+	}
+	if len(assigned) > 0 {
+		if _, err := h.prefs.store.Update(ctx, userID, assigned, nil, Precondition{}); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadExperiments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "experiments.json")
+	os.WriteFile(path, []byte(`{"search-v2":{"on":1,"off":3},"checkout":{"control":1}}`), 0o600)
+	experiments, err := LoadExperiments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(experiments) != 2 || experiments[0].Name != "checkout" || experiments[1].Buckets[0] != "off" || experiments[1].Weights[0] != 3 {
+		t.Fatalf("unexpected experiments %+v", experiments)
+	}
+
+	for _, bad := range []string{`{"a#b":{"on":1}}`, `{"empty":{}}`, `{"zero":{"on":0}}`, `["checkout"]`} {
+		os.WriteFile(path, []byte(bad), 0o600)
+		if _, err := LoadExperiments(path); err == nil {
+			t.Fatalf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestExperiment_Assign(t *testing.T) {
+	e := Experiment{Name: "checkout", Buckets: []string{"control", "treatment"}, Weights: []int{1, 3}}
+	counts := map[string]int{}
+	for i := range 4000 {
+		counts[e.assign(fmt.Sprintf("user%d", i))]++
+	}
+	if counts["treatment"] < 2800 || counts["treatment"] > 3200 {
+		t.Fatalf("expected about 3000 users in treatment, got %v", counts)
+	}
+	if e.assign("user1") != e.assign("user1") {
+		t.Fatal("expected a stable assignment")
+	}
+}
+
+func TestExperimentsHandler(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user2"] = map[string]any{experimentKeyPrefix + "checkout": "treatment", experimentKeyPrefix + "search": "retired"}
+	h := NewExperimentsHandler(NewPreferencesHandler(store, testLogger(), HandlerOptions{}), []Experiment{
+		{Name: "checkout", Buckets: []string{"control", "treatment"}, Weights: []int{1, 1}},
+		{Name: "search", Buckets: []string{"off", "on"}, Weights: []int{1, 1}},
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/experiments", h.List)
+	mux.HandleFunc("GET /api/v1/users/{userId}/experiments/{experiment}", h.Get)
+	get := func(user, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(httptest.NewRequest("GET", path, nil), user))
+		return w
+	}
+
+	w := get("user1", "/api/v1/users/user1/experiments")
+	var resp ExperimentsResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Assignments) != 2 {
+		t.Fatalf("unexpected response %d %+v", w.Code, resp)
+	}
+	if store.prefs["user1"][experimentKeyPrefix+"checkout"] != resp.Assignments["checkout"] {
+		t.Fatalf("expected the assignment stored, got %v", store.prefs["user1"])
+	}
+
+	// A stored bucket is kept; one no longer in the experiment is replaced.
+	w = get("user2", "/api/v1/users/user2/experiments")
+	resp = ExperimentsResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Assignments["checkout"] != "treatment" || (resp.Assignments["search"] != "off" && resp.Assignments["search"] != "on") {
+		t.Fatalf("unexpected assignments %+v", resp.Assignments)
+	}
+
+	w = get("user2", "/api/v1/users/user2/experiments/checkout")
+	var one ExperimentResponse
+	json.NewDecoder(w.Body).Decode(&one)
+	if w.Code != http.StatusOK || one.Experiment != "checkout" || one.Bucket != "treatment" {
+		t.Fatalf("unexpected response %d %+v", w.Code, one)
+	}
+	if w := get("user2", "/api/v1/users/user2/experiments/pricing"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown experiment, got %d", w.Code)
+	}
+	if w := get("user1", "/api/v1/users/user2/experiments"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another user, got %d", w.Code)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// exportFormat identifies preference export documents.
+const exportFormat = "user-prefs-export"
+
+// exportVersion is the current export document version.
+const exportVersion = 1
+
+// ExportDocument is the downloadable form of a user's preferences.
+type ExportDocument struct {
+	Format      string         `json:"format"`
+	Version     int            `json:"version"`
+	UserID      string         `json:"userId"`
+	ExportedAt  time.Time      `json:"exportedAt"`
+	UpdatedAt   *time.Time     `json:"updatedAt,omitempty"`
+	Preferences map[string]any `json:"preferences"`
+}
+
+// Export returns a user's preferences as a file download: a JSON
+// ExportDocument, or key/value CSV with ?format=csv. CSV values are strings
+// as stored, and JSON for other value types.
+func (h *PreferencesHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+
+	rec, err := h.store.GetAll(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve preferences")
+		return
+	}
+	prefs := rec.Prefs
+	if prefs == nil {
+		prefs = make(map[string]any)
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="preferences.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"key", "value"})
+		for _, k := range slices.Sorted(maps.Keys(prefs)) {
+			v, ok := prefs[k].(string)
+			if !ok {
+				b, _ := json.Marshal(prefs[k])
+				v = string(b)
+			}
+			cw.Write([]string{k, v})
+		}
+		cw.Flush()
+		return
+	}
+
+	doc := ExportDocument{
+		Format:      exportFormat,
+		Version:     exportVersion,
+		UserID:      userID,
+		ExportedAt:  time.Now().UTC(),
+		Preferences: prefs,
+	}
+	if !rec.UpdatedAt.IsZero() {
+		doc.UpdatedAt = &rec.UpdatedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="preferences.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(doc)
+}
+
+// ImportResponse is the imported map, with per-key results for
+// ?partial=true imports.
+type ImportResponse struct {
+	PreferencesResponse
+	Results []BatchResult `json:"results,omitempty"`
+}
+
+// Import restores preferences from an ExportDocument. ?mode=merge (the
+// default) keeps keys the document does not mention; ?mode=replace makes the
+// stored map match the document exactly. The document's userId is not
+// checked, so preferences can be moved between accounts and environments.
+//
+// An import is rejected as a whole if any key is invalid, unless
+// ?partial=true: then invalid keys are left out, as if absent from the
+// document, and the response lists each key's result, with 207 Multi-Status
+// if some were left out. Quota and store errors still fail the whole import.
+func (h *PreferencesHandler) Import(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "merge"
+	}
+	if mode != "merge" && mode != "replace" {
+		writeError(w, http.StatusBadRequest, "mode must be merge or replace")
+		return
+	}
+
+	cond, ok := h.precondition(w, r)
+	if !ok {
+		return
+	}
+
+	var doc ExportDocument
+	if err := decodeJSON(r.Body, &doc); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid export document")
+		return
+	}
+	if msg := doc.validate(); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	h.dropUnknownKeys(doc.Preferences)
+	h.mirrorAliases(doc.Preferences, nil)
+	var results []BatchResult
+	if r.URL.Query().Get("partial") == "true" {
+		results = h.partialImport(doc.Preferences)
+	} else if !h.validatePrefs(w, doc.Preferences) {
+		return
+	}
+	if !h.checkQuota(w, r, userID, doc.Preferences, nil, mode == "replace") {
+		return
+	}
+
+	var rec Record
+	var err error
+	if mode == "replace" {
+		rec, err = h.store.ReplaceAll(r.Context(), userID, doc.Preferences, cond)
+	} else {
+		rec, err = h.store.Update(r.Context(), userID, doc.Preferences, nil, cond)
+	}
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, http.StatusPreconditionFailed, "preferences have been modified")
+		return
+	}
+	if err != nil {
+		h.logger.Error("import failed", "error", err, "userId", userID, "mode", mode)
+		writeError(w, http.StatusInternalServerError, "failed to import preferences")
+		return
+	}
+	setValidators(w, rec)
+
+	resp := ImportResponse{PreferencesResponse: newPreferencesResponse(userID, rec.Prefs, rec), Results: results}
+	writeJSON(w, batchStatus(results), resp)
+}
+
+// partialImport removes the invalid keys from prefs and returns a result
+// per key, in key order.
+func (h *PreferencesHandler) partialImport(prefs map[string]any) []BatchResult {
+	byKey := make(map[string][]Violation)
+	for _, v := range h.prefViolations(prefs) {
+		byKey[v.Key] = append(byKey[v.Key], v)
+	}
+	results := make([]BatchResult, 0, len(prefs))
+	for _, k := range slices.Sorted(maps.Keys(prefs)) {
+		res := BatchResult{Key: k, Status: BatchUpdated}
+		if v := byKey[k]; len(v) > 0 {
+			delete(prefs, k)
+			res.Status = BatchInvalid
+			res.Error = "invalid preference"
+			res.ErrorCode = ErrCodeValidationFailed
+			res.Violations = v
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// validate returns why doc cannot be imported, or "" if it can.
+func (doc ExportDocument) validate() string {
+	switch {
+	case doc.Format != exportFormat:
+		return "not a preferences export document"
+	case doc.Version < 1 || doc.Version > exportVersion:
+		return fmt.Sprintf("unsupported export version %d", doc.Version)
+	case doc.Preferences == nil:
+		return "export document has no preferences"
+	}
+	if _, ok := doc.Preferences[""]; ok {
+		return "preference keys must not be empty"
+	}
+	return ""
+}
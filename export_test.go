@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "lang": "en"}
+	store.updated["user1"] = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/export", h.Export)
+
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences/export", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("expected attachment, got %q", w.Header().Get("Content-Disposition"))
+	}
+	var doc ExportDocument
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if doc.Format != exportFormat || doc.UserID != "user1" || doc.Preferences["theme"] != "dark" {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+	if doc.UpdatedAt == nil || !doc.UpdatedAt.Equal(store.updated["user1"]) {
+		t.Fatalf("expected updatedAt metadata, got %v", doc.UpdatedAt)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/users/user1/preferences/export?format=csv", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if got, want := w.Body.String(), "key,value\nlang,en\ntheme,dark\n"; got != want {
+		t.Fatalf("expected CSV %q, got %q", want, got)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/users/user1/preferences/export", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "other-user"))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another user, got %d", w.Code)
+	}
+}
+
+func TestImport(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "tz": "UTC"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/users/{userId}/preferences/import", h.Import)
+
+	post := func(query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/users/user1/preferences/import"+query, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+	doc := `{"format":"user-prefs-export","version":1,"userId":"old-account","preferences":{"theme":"light","lang":"en"}}`
+
+	if w := post("", doc); w.Code != http.StatusOK {
+		t.Fatalf("merge: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := store.prefs["user1"]; len(got) != 3 || got["theme"] != "light" || got["tz"] != "UTC" {
+		t.Fatalf("merge: unexpected prefs %v", got)
+	}
+
+	if w := post("?mode=replace", doc); w.Code != http.StatusOK {
+		t.Fatalf("replace: expected 200, got %d", w.Code)
+	}
+	if got := store.prefs["user1"]; len(got) != 2 || got["lang"] != "en" {
+		t.Fatalf("replace: unexpected prefs %v", got)
+	}
+
+	for _, bad := range []string{
+		`{"theme":"dark"}`,
+		`{"format":"user-prefs-export","version":2,"preferences":{}}`,
+		`{"format":"user-prefs-export","version":1,"preferences":["theme"]}`,
+		`{"format":"user-prefs-export","version":1,"preferences":{"":"x"}}`,
+	} {
+		if w := post("", bad); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", bad, w.Code)
+		}
+	}
+	if w := post("?mode=append", doc); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown mode, got %d", w.Code)
+	}
+}
+
+func TestImport_Partial(t *testing.T) {
+	store := newMockStore()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{ReservedKeyPrefixes: []string{"sys."}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/users/{userId}/preferences/import", h.Import)
+	post := func(query string) *httptest.ResponseRecorder {
+		doc := `{"format":"user-prefs-export","version":1,"preferences":{"theme":"light","sys.flag":true}}`
+		req := httptest.NewRequest("POST", "/api/v1/users/user1/preferences/import"+query, strings.NewReader(doc))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	if w := post(""); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected the whole import rejected, got %d", w.Code)
+	}
+
+	w := post("?partial=true")
+	var resp ImportResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusMultiStatus || len(resp.Results) != 2 {
+		t.Fatalf("expected 207 with a result per key, got %d %+v", w.Code, resp)
+	}
+	if res := resp.Results[0]; res.Key != "sys.flag" || res.Status != BatchInvalid || res.Violations[0].Code != ErrCodeKeyInvalid {
+		t.Fatalf("expected sys.flag invalid, got %+v", res)
+	}
+	if res := resp.Results[1]; res.Key != "theme" || res.Status != BatchUpdated {
+		t.Fatalf("expected theme imported, got %+v", res)
+	}
+	if got := store.prefs["user1"]; len(got) != 1 || got["theme"] != "light" || resp.Preferences["theme"] != "light" {
+		t.Fatalf("expected only theme stored, got %v", got)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// failoverStatusRefresh is how often the replication status is read from
+// the standby table.
+var failoverStatusRefresh = 10 * time.Second
+
+// replicationStatusSource reports how far the standby is up to date; the
+// StandbyReplicator reads what the stream worker recorded.
+type replicationStatusSource interface {
+	ReplicationStatus(ctx context.Context) (ReplicationStatus, error)
+}
+
+// FailoverPolicy controls automatic read failover to the standby store.
+type FailoverPolicy struct {
+	// Auto enables automatic failover after Threshold consecutive primary
+	// read failures. Operators can always fail over manually.
+	Auto      bool
+	Threshold int
+	// RetryPrimaryAfter is how long an automatic failover lasts before reads
+	// probe the primary again.
+	RetryPrimaryAfter time.Duration
+}
+
+// FailoverStatus describes which backend is serving reads.
+type FailoverStatus struct {
+	Serving string `json:"serving"`
+	Manual  bool   `json:"manual"`
+	// ReplicatedAt is when the standby last held every change, unset until
+	// the stream worker has reported; LagSeconds is the time since.
+	ReplicatedAt   *time.Time `json:"replicatedAt,omitempty"`
+	LagSeconds     float64    `json:"lagSeconds"`
+	Diverged       bool       `json:"diverged"`
+	ConsecutiveErr int        `json:"consecutivePrimaryErrors"`
+}
+
+// FailoverStore wraps a primary Store with a warm standby. Writes go to the
+// primary only: the stream worker replicates them to the standby from the
+// table's stream (see StandbyReplicator). Reads can fail over to the
+// standby, in which case callers are told how stale it may be.
+type FailoverStore struct {
+	primary     Store
+	standby     Store
+	replication replicationStatusSource
+	policy      FailoverPolicy
+	logger      *slog.Logger
+
+	mu         sync.Mutex
+	onStandby  bool
+	manual     bool
+	failures   int
+	failedAt   time.Time
+	replicated ReplicationStatus
+}
+
+// NewFailoverStore creates a FailoverStore. Call Run to keep its
+// replication status current.
+func NewFailoverStore(primary, standby Store, replication replicationStatusSource, policy FailoverPolicy, logger *slog.Logger) *FailoverStore {
+	return &FailoverStore{
+		primary:     primary,
+		standby:     standby,
+		replication: replication,
+		policy:      policy,
+		logger:      logger,
+	}
+}
+
+// Run reads the replication status every failoverStatusRefresh until ctx
+// is cancelled.
+func (f *FailoverStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(failoverStatusRefresh)
+	defer ticker.Stop()
+	for {
+		st, err := f.replication.ReplicationStatus(ctx)
+		if err != nil && ctx.Err() == nil {
+			f.logger.Warn("reading standby replication status failed", "error", err)
+		}
+		if err == nil {
+			f.mu.Lock()
+			f.replicated = st
+			f.mu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status reports the current failover state.
+func (f *FailoverStore) Status() FailoverStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	st := FailoverStatus{
+		Serving:        "primary",
+		Manual:         f.manual,
+		Diverged:       f.replicated.Diverged,
+		ConsecutiveErr: f.failures,
+	}
+	if f.onStandby {
+		st.Serving = "standby"
+	}
+	if at := f.replicated.SyncedAt; !at.IsZero() {
+		st.ReplicatedAt = &at
+		st.LagSeconds = max(time.Since(at).Seconds(), 0)
+	}
+	return st
+}
+
+// SetMode forces reads to "primary" or "standby", or returns control to the
+// automatic policy with "auto".
+func (f *FailoverStore) SetMode(mode string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch mode {
+	case "primary":
+		f.onStandby, f.manual = false, true
+	case "standby":
+		f.onStandby, f.manual = true, true
+	case "auto":
+		f.onStandby, f.manual, f.failures = false, false, 0
+	default:
+		return false
+	}
+	f.logger.Warn("failover mode changed", "mode", mode)
+	return true
+}
+
+// readFrom picks the store for a read, probing the primary again once an
+// automatic failover has aged past RetryPrimaryAfter.
+func (f *FailoverStore) readFrom() (Store, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.onStandby && !f.manual && time.Since(f.failedAt) > f.policy.RetryPrimaryAfter {
+		f.onStandby = false
+		f.failures = 0
+	}
+	if f.onStandby {
+		return f.standby, true
+	}
+	return f.primary, false
+}
+
+// observe records the outcome of a primary read for the automatic policy.
+func (f *FailoverStore) observe(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		f.failures = 0
+		return
+	}
+	f.failures++
+	if f.policy.Auto && !f.manual && !f.onStandby && f.failures >= f.policy.Threshold {
+		f.onStandby = true
+		f.failedAt = time.Now()
+		f.logger.Error("primary store failing; reads failed over to standby", "failures", f.failures, "error", err)
+	}
+}
+
+func (f *FailoverStore) GetAll(ctx context.Context, userID string) (Record, error) {
+	s, standby := f.readFrom()
+	rec, err := s.GetAll(ctx, userID)
+	if standby {
+		return rec, err
+	}
+	f.observe(err)
+	if err != nil && f.Status().Serving == "standby" {
+		return f.standby.GetAll(ctx, userID)
+	}
+	return rec, err
+}
+
+func (f *FailoverStore) Get(ctx context.Context, userID string, key string) (any, bool, Record, error) {
+	s, standby := f.readFrom()
+	value, found, rec, err := s.Get(ctx, userID, key)
+	if standby {
+		return value, found, rec, err
+	}
+	f.observe(err)
+	if err != nil && f.Status().Serving == "standby" {
+		return f.standby.Get(ctx, userID, key)
+	}
+	return value, found, rec, err
+}
+
+func (f *FailoverStore) Stat(ctx context.Context, userID string, key string) (Record, bool, error) {
+	s, standby := f.readFrom()
+	rec, found, err := s.Stat(ctx, userID, key)
+	if standby {
+		return rec, found, err
+	}
+	f.observe(err)
+	if err != nil && f.Status().Serving == "standby" {
+		return f.standby.Stat(ctx, userID, key)
+	}
+	return rec, found, err
+}
+
+func (f *FailoverStore) GetKeys(ctx context.Context, userID string, keys []string) (Record, error) {
+	s, standby := f.readFrom()
+	rec, err := s.GetKeys(ctx, userID, keys)
+	if standby {
+		return rec, err
+	}
+	f.observe(err)
+	if err != nil && f.Status().Serving == "standby" {
+		return f.standby.GetKeys(ctx, userID, keys)
+	}
+	return rec, err
+}
+
+func (f *FailoverStore) BatchGet(ctx context.Context, userIDs []string) (map[string]Record, error) {
+	s, standby := f.readFrom()
+	recs, err := s.BatchGet(ctx, userIDs)
+	if standby {
+		return recs, err
+	}
+	f.observe(err)
+	if err != nil && f.Status().Serving == "standby" {
+		return f.standby.BatchGet(ctx, userIDs)
+	}
+	return recs, err
+}
+
+func (f *FailoverStore) ReplaceAll(ctx context.Context, userID string, prefs map[string]any, cond Precondition) (Record, error) {
+	return f.primary.ReplaceAll(ctx, userID, prefs, cond)
+}
+
+func (f *FailoverStore) Update(ctx context.Context, userID string, prefs map[string]any, remove []string, cond Precondition) (Record, error) {
+	return f.primary.Update(ctx, userID, prefs, remove, cond)
+}
+
+func (f *FailoverStore) DeleteAll(ctx context.Context, userID string) error {
+	return f.primary.DeleteAll(ctx, userID)
+}
+
+func (f *FailoverStore) Delete(ctx context.Context, userID string, key string) error {
+	return f.primary.Delete(ctx, userID, key)
+}
+
+// StalenessHeaders marks responses served while reads are failed over so
+// clients know the data may lag behind the primary.
+func StalenessHeaders(f *FailoverStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if st := f.Status(); st.Serving == "standby" {
+				w.Header().Set("X-Preferences-Source", "standby")
+				w.Header().Set("X-Preferences-Lag-Seconds", strconv.FormatFloat(st.LagSeconds, 'f', 0, 64))
+				w.Header().Set("Warning", `110 - "Response is Stale"`)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// FailoverHandler exposes failover status and manual control to operators.
+type FailoverHandler struct {
+	store *FailoverStore
+}
+
+// NewFailoverHandler creates a FailoverHandler.
+func NewFailoverHandler(store *FailoverStore) *FailoverHandler {
+	return &FailoverHandler{store: store}
+}
+
+// Status returns the current failover state.
+func (h *FailoverHandler) Status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.store.Status())
+}
+
+// SetMode switches reads between primary and standby.
+func (h *FailoverHandler) SetMode(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if !h.store.SetMode(body.Mode) {
+		writeError(w, http.StatusBadRequest, "mode must be primary, standby, or auto")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.store.Status())
+}
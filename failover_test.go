@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// staticReplication reports a fixed replication status.
+type staticReplication struct{ st ReplicationStatus }
+
+func (s staticReplication) ReplicationStatus(context.Context) (ReplicationStatus, error) {
+	return s.st, nil
+}
+
+func TestFailoverStore_WritesPrimaryAndReportsReplication(t *testing.T) {
+	primary, standby := newMockStore(), newMockStore()
+	synced := time.Now().Add(-time.Minute)
+	f := NewFailoverStore(primary, standby, staticReplication{ReplicationStatus{SyncedAt: synced, Diverged: true}}, FailoverPolicy{}, testLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	f.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark"}, Precondition{})
+	f.Update(ctx, "user1", map[string]any{"lang": "en"}, nil, Precondition{})
+	if len(primary.prefs["user1"]) != 2 || len(standby.prefs) != 0 {
+		t.Fatalf("expected writes to reach the primary only, got %v and %v", primary.prefs, standby.prefs)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for f.Status().ReplicatedAt == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("replication status was never read")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	st := f.Status()
+	if !st.ReplicatedAt.Equal(synced) || st.LagSeconds < 60 || !st.Diverged {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestFailoverStore_AutomaticReadFailover(t *testing.T) {
+	primary, standby := newMockStore(), newMockStore()
+	standby.prefs["user1"] = map[string]any{"theme": "dark"}
+	f := NewFailoverStore(primary, standby, staticReplication{}, FailoverPolicy{
+		Auto:              true,
+		Threshold:         2,
+		RetryPrimaryAfter: time.Hour,
+	}, testLogger())
+	ctx := context.Background()
+
+	primary.err = fmt.Errorf("dynamodb unavailable")
+	if _, err := f.GetAll(ctx, "user1"); err == nil {
+		t.Fatal("expected first failure to surface before threshold")
+	}
+
+	rec, err := f.GetAll(ctx, "user1")
+	if err != nil {
+		t.Fatalf("expected failover read to succeed, got %v", err)
+	}
+	if rec.Prefs["theme"] != "dark" {
+		t.Fatalf("expected standby data, got %v", rec.Prefs)
+	}
+	if f.Status().Serving != "standby" {
+		t.Fatal("expected reads to be served by standby")
+	}
+
+	// Operators can force reads back to the primary.
+	f.SetMode("primary")
+	primary.err = nil
+	if _, err := f.GetAll(ctx, "user1"); err != nil {
+		t.Fatalf("expected primary read after manual failback, got %v", err)
+	}
+}
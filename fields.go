@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// requestedFields reads ?fields=, a comma-separated list of the top-level
+// response fields to return, checking each is a field of the response type
+// of zero. A nil result means the whole response.
+func requestedFields(r *http.Request, zero any) ([]string, error) {
+	if !r.URL.Query().Has("fields") {
+		return nil, nil
+	}
+	fields := splitList(r.URL.Query().Get("fields"))
+	if len(fields) == 0 {
+		return nil, errors.New("fields must not be empty")
+	}
+	known := jsonFields(reflect.TypeOf(zero))
+	for _, f := range fields {
+		if !slices.Contains(known, f) {
+			return nil, errors.New("unknown field " + f + "; fields are " + strings.Join(known, ", "))
+		}
+	}
+	return fields, nil
+}
+
+// parseFields is requestedFields for handlers, writing a 400 and reporting
+// false for invalid lists.
+func parseFields(w http.ResponseWriter, r *http.Request, zero any) ([]string, bool) {
+	fields, err := requestedFields(r, zero)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return fields, true
+}
+
+// jsonFields lists the JSON names of a struct type's fields.
+func jsonFields(t reflect.Type) []string {
+	var out []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.IsExported() && name != "-" && name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// selectFields returns v with only the given top-level fields, or v itself
+// when fields is nil.
+func selectFields(v any, fields []string) (any, error) {
+	if fields == nil {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	out := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if raw, ok := all[f]; ok {
+			out[f] = raw
+		}
+	}
+	return out, nil
+}
+
+// writeFields writes v trimmed to fields.
+func writeFields(w http.ResponseWriter, status int, v any, fields []string) {
+	out, err := selectFields(v, fields)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode response")
+		return
+	}
+	writeJSON(w, status, out)
+}
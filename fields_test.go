@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetAll_Fields(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	store.updated["user1"] = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences"+query, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	w := get("?fields=preferences,updatedAt")
+	var body map[string]any
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusOK || len(body) != 2 || body["updatedAt"] != "2026-03-01T12:00:00Z" || body["preferences"] == nil {
+		t.Fatalf("expected only the requested fields, got %d %v", w.Code, body)
+	}
+
+	for _, q := range []string{"?fields=", "?fields=version"} {
+		if w := get(q); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
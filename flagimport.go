@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Feature-flag systems prefsctl import-flags reads.
+const (
+	FlagSourceLaunchDarkly = "launchdarkly"
+	FlagSourceUnleash      = "unleash"
+)
+
+// FlagImport is the per-user flag values read from a feature-flag export,
+// as preference keys, and what was left out.
+type FlagImport struct {
+	// Prefs maps user ID to preference key to value.
+	Prefs map[string]map[string]any
+	// Users counts the users each key is set for.
+	Users map[string]int
+	Flags int
+	// Skipped explains each flag, or part of one, not imported.
+	Skipped []string
+}
+
+func newFlagImport() *FlagImport {
+	return &FlagImport{Prefs: map[string]map[string]any{}, Users: map[string]int{}}
+}
+
+func (fi *FlagImport) skip(format string, args ...any) {
+	fi.Skipped = append(fi.Skipped, fmt.Sprintf(format, args...))
+}
+
+// set records value for a user under key, unless a value was already
+// recorded for them from another target of the same flag.
+func (fi *FlagImport) set(flag, key, user string, value any, conflicted map[string]bool) {
+	if conflicted[user] {
+		return
+	}
+	prefs := fi.Prefs[user]
+	if prefs == nil {
+		prefs = map[string]any{}
+		fi.Prefs[user] = prefs
+	}
+	if _, ok := prefs[key]; ok {
+		conflicted[user] = true
+		delete(prefs, key)
+		fi.Users[key]--
+		fi.skip("%s: user %s is targeted more than once; left out", flag, user)
+		return
+	}
+	prefs[key] = value
+	fi.Users[key]++
+}
+
+// flagKey maps a flag to its preference key under namespace, reporting
+// flags whose key would be invalid.
+func (fi *FlagImport) flagKey(namespace, flag string) (string, bool) {
+	key := namespace + flag
+	if reason := keyViolation(key, nil); reason != "" {
+		fi.skip("%s: %s; left out", flag, reason)
+		return "", false
+	}
+	return key, true
+}
+
+// ParseFlagExport reads a LaunchDarkly or Unleash export and maps the
+// values it sets for individual users in env to preference keys under
+// namespace. Only per-user assignments are imported: LaunchDarkly
+// individual targets (including user context targets), and Unleash
+// userWithId strategies and strategies constrained to userId IN a list.
+// Rules, rollouts and segments apply to users by attribute or at random,
+// so they are reported as skipped, as are flags switched off in env, for
+// which no target is served.
+func ParseFlagExport(source string, data []byte, env, namespace string) (*FlagImport, error) {
+	if !strings.HasSuffix(namespace, ".") || keyViolation(strings.TrimSuffix(namespace, "."), nil) != "" {
+		return nil, fmt.Errorf("namespace %q must be a key prefix ending in \".\"", namespace)
+	}
+	switch source {
+	case FlagSourceLaunchDarkly:
+		return parseLaunchDarkly(data, env, namespace)
+	case FlagSourceUnleash:
+		return parseUnleash(data, env, namespace)
+	}
+	return nil, fmt.Errorf("source must be %s or %s", FlagSourceLaunchDarkly, FlagSourceUnleash)
+}
+
+// ldFlag is a flag as the LaunchDarkly REST API returns it.
+type ldFlag struct {
+	Key        string `json:"key"`
+	Variations []struct {
+		Value any `json:"value"`
+	} `json:"variations"`
+	Environments map[string]struct {
+		On             bool              `json:"on"`
+		Targets        []ldTarget        `json:"targets"`
+		ContextTargets []ldTarget        `json:"contextTargets"`
+		Rules          []json.RawMessage `json:"rules"`
+	} `json:"environments"`
+}
+
+type ldTarget struct {
+	Values      []string `json:"values"`
+	Variation   int      `json:"variation"`
+	ContextKind string   `json:"contextKind"`
+}
+
+// parseLaunchDarkly reads a flag list ({"items": [...]}, as from GET
+// /api/v2/flags/{project}), an array of flags, or one flag.
+func parseLaunchDarkly(data []byte, env, namespace string) (*FlagImport, error) {
+	var flags []ldFlag
+	var list struct {
+		Items []ldFlag `json:"items"`
+	}
+	var one ldFlag
+	switch {
+	case decodeJSON(bytes.NewReader(data), &flags) == nil:
+	case decodeJSON(bytes.NewReader(data), &list) == nil && list.Items != nil:
+		flags = list.Items
+	case decodeJSON(bytes.NewReader(data), &one) == nil && one.Key != "":
+		flags = []ldFlag{one}
+	default:
+		return nil, errors.New("not a LaunchDarkly flag export: expected a flag, an array of flags or {\"items\": [...]}")
+	}
+
+	fi := newFlagImport()
+	for _, f := range flags {
+		fi.Flags++
+		e, ok := f.Environments[env]
+		switch {
+		case !ok:
+			fi.skip("%s: no %s environment", f.Key, env)
+			continue
+		case !e.On:
+			fi.skip("%s: off in %s, so no target is served", f.Key, env)
+			continue
+		}
+		if len(e.Rules) > 0 {
+			fi.skip("%s: %d targeting rule(s) are not per-user; only individual targets imported", f.Key, len(e.Rules))
+		}
+		key, ok := fi.flagKey(namespace, f.Key)
+		if !ok {
+			continue
+		}
+		conflicted := map[string]bool{}
+		// User context targets list no values of their own; theirs stay in
+		// targets.
+		targets := e.Targets
+		for _, t := range e.ContextTargets {
+			if t.ContextKind != "" && t.ContextKind != "user" {
+				fi.skip("%s: %s context targets are not users", f.Key, t.ContextKind)
+				continue
+			}
+			targets = append(targets, t)
+		}
+		for _, t := range targets {
+			if t.Variation < 0 || t.Variation >= len(f.Variations) {
+				fi.skip("%s: target variation %d does not exist", f.Key, t.Variation)
+				continue
+			}
+			for _, user := range t.Values {
+				fi.set(f.Key, key, user, f.Variations[t.Variation].Value, conflicted)
+			}
+		}
+	}
+	return fi, nil
+}
+
+// unleashExport is the parts of an Unleash feature export
+// (/api/admin/features-batch/export or the older state export) read.
+type unleashExport struct {
+	Features []struct {
+		Name string `json:"name"`
+	} `json:"features"`
+	FeatureStrategies []struct {
+		FeatureName string              `json:"featureName"`
+		Environment string              `json:"environment"`
+		Name        string              `json:"name"`
+		Parameters  map[string]any      `json:"parameters"`
+		Disabled    bool                `json:"disabled"`
+		Constraints []unleashConstraint `json:"constraints"`
+	} `json:"featureStrategies"`
+	FeatureEnvironments []struct {
+		FeatureName string `json:"featureName"`
+		Environment string `json:"environment"`
+		Enabled     bool   `json:"enabled"`
+	} `json:"featureEnvironments"`
+}
+
+type unleashConstraint struct {
+	ContextName string   `json:"contextName"`
+	Operator    string   `json:"operator"`
+	Values      []string `json:"values"`
+	Inverted    bool     `json:"inverted"`
+}
+
+// parseUnleash imports the users a flag is enabled for by ID, as true.
+func parseUnleash(data []byte, env, namespace string) (*FlagImport, error) {
+	var export unleashExport
+	if err := decodeJSON(bytes.NewReader(data), &export); err != nil || export.Features == nil {
+		return nil, errors.New("not an Unleash feature export: expected features and featureStrategies")
+	}
+
+	enabled := map[string]bool{}
+	for _, fe := range export.FeatureEnvironments {
+		if fe.Environment == env {
+			enabled[fe.FeatureName] = fe.Enabled
+		}
+	}
+
+	fi := newFlagImport()
+	for _, f := range export.Features {
+		fi.Flags++
+		if on, ok := enabled[f.Name]; !ok || !on {
+			fi.skip("%s: not enabled in %s, so no strategy applies", f.Name, env)
+			continue
+		}
+		key, ok := fi.flagKey(namespace, f.Name)
+		if !ok {
+			continue
+		}
+		conflicted := map[string]bool{}
+		for _, s := range export.FeatureStrategies {
+			if s.FeatureName != f.Name || s.Environment != env || s.Disabled {
+				continue
+			}
+			users, ok := unleashUsers(s.Name, s.Parameters, s.Constraints)
+			if !ok {
+				fi.skip("%s: %s strategy is not per-user", f.Name, s.Name)
+				continue
+			}
+			for _, user := range users {
+				// Several strategies may enable the same user.
+				if fi.Prefs[user][key] == true {
+					continue
+				}
+				fi.set(f.Name, key, user, true, conflicted)
+			}
+		}
+	}
+	return fi, nil
+}
+
+// unleashUsers returns the users a strategy enables by ID: a userWithId
+// strategy's userIds, or the userId IN values of a default or 100%
+// flexibleRollout strategy constrained on nothing else.
+func unleashUsers(name string, params map[string]any, constraints []unleashConstraint) ([]string, bool) {
+	if name == "userWithId" && len(constraints) == 0 {
+		ids, _ := params["userIds"].(string)
+		var users []string
+		for id := range strings.SplitSeq(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				users = append(users, id)
+			}
+		}
+		return users, true
+	}
+	if len(constraints) == 0 || (name != "default" && (name != "flexibleRollout" || fmt.Sprint(params["rollout"]) != "100")) {
+		return nil, false
+	}
+	var users []string
+	for _, c := range constraints {
+		if c.ContextName != "userId" || c.Operator != "IN" || c.Inverted {
+			return nil, false
+		}
+		users = append(users, c.Values...)
+	}
+	return users, true
+}
+
+// Report writes what the import sets and skips.
+func (fi *FlagImport) Report(w io.Writer) {
+	n := 0
+	for _, prefs := range fi.Prefs {
+		n += len(prefs)
+	}
+	fmt.Fprintf(w, "%d flag(s) read; %d preference(s) for %d user(s)\n", fi.Flags, n, len(fi.users()))
+	for _, key := range slices.Sorted(maps.Keys(fi.Users)) {
+		if fi.Users[key] > 0 {
+			fmt.Fprintf(w, "  %s: %d user(s)\n", key, fi.Users[key])
+		}
+	}
+	if len(fi.Skipped) > 0 {
+		fmt.Fprintln(w, "Skipped:")
+		for _, s := range fi.Skipped {
+			fmt.Fprintf(w, "  %s\n", s)
+		}
+	}
+}
+
+// users lists the users with something to import, sorted.
+func (fi *FlagImport) users() []string {
+	var users []string
+	for user, prefs := range fi.Prefs {
+		if len(prefs) > 0 {
+			users = append(users, user)
+		}
+	}
+	slices.Sort(users)
+	return users
+}
+
+// importFlags merges each user's imported keys into their preferences
+// with one PATCH, carrying on past failures, which it reports at the end.
+func (c *ctlClient) importFlags(ctx context.Context, fi *FlagImport, stdout, stderr io.Writer) error {
+	failed := 0
+	users := fi.users()
+	for _, user := range users {
+		body, err := json.Marshal(fi.Prefs[user])
+		if err == nil {
+			var resp *http.Response
+			if resp, err = c.do(ctx, http.MethodPatch, "/api/v1/admin/users/"+url.PathEscape(user)+"/preferences", body); err == nil {
+				resp.Body.Close()
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(stderr, "user %s: %v\n", user, err)
+			failed++
+		}
+	}
+	fmt.Fprintf(stdout, "Imported %d of %d user(s).\n", len(users)-failed, len(users))
+	if failed > 0 {
+		return fmt.Errorf("%d user(s) failed", failed)
+	}
+	return nil
+}
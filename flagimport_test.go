@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const ldExport = `{"items": [
+	{"key": "new-nav", "variations": [{"value": true}, {"value": false}],
+	 "environments": {"production": {"on": true,
+		"targets": [{"values": ["user1", "user2"], "variation": 0}, {"values": ["user3", "user2"], "variation": 1}],
+		"contextTargets": [{"contextKind": "user", "values": [], "variation": 0}, {"contextKind": "org", "values": ["acme"], "variation": 0}],
+		"rules": [{"clauses": []}]}}},
+	{"key": "theme", "variations": [{"value": "classic"}, {"value": {"name": "dusk", "contrast": 2}}],
+	 "environments": {"production": {"on": true, "targets": [{"values": ["user1"], "variation": 1}]}}},
+	{"key": "retired", "variations": [{"value": true}],
+	 "environments": {"production": {"on": false, "targets": [{"values": ["user1"], "variation": 0}]}}},
+	{"key": "staging-only", "environments": {"staging": {"on": true}}}
+]}`
+
+const unleashExportJSON = `{
+	"features": [{"name": "beta-search"}, {"name": "dark-launch"}, {"name": "rollout"}],
+	"featureStrategies": [
+		{"featureName": "beta-search", "environment": "production", "name": "userWithId", "parameters": {"userIds": "user1, user2,"}},
+		{"featureName": "beta-search", "environment": "production", "name": "flexibleRollout", "parameters": {"rollout": "100"},
+		 "constraints": [{"contextName": "userId", "operator": "IN", "values": ["user2", "user4"]}]},
+		{"featureName": "beta-search", "environment": "development", "name": "userWithId", "parameters": {"userIds": "user9"}},
+		{"featureName": "rollout", "environment": "production", "name": "flexibleRollout", "parameters": {"rollout": "25"}}
+	],
+	"featureEnvironments": [
+		{"featureName": "beta-search", "environment": "production", "enabled": true},
+		{"featureName": "dark-launch", "environment": "production", "enabled": false},
+		{"featureName": "rollout", "environment": "production", "enabled": true}
+	]
+}`
+
+func TestParseFlagExport_LaunchDarkly(t *testing.T) {
+	fi, err := ParseFlagExport(FlagSourceLaunchDarkly, []byte(ldExport), "production", "flags.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Flags != 4 || fi.Prefs["user1"]["flags.new-nav"] != true || fi.Prefs["user3"]["flags.new-nav"] != false {
+		t.Fatalf("unexpected import %+v", fi.Prefs)
+	}
+	if _, ok := fi.Prefs["user2"]["flags.new-nav"]; ok {
+		t.Fatalf("expected a user targeted by two variations left out, got %+v", fi.Prefs["user2"])
+	}
+	if theme, _ := json.Marshal(fi.Prefs["user1"]["flags.theme"]); string(theme) != `{"contrast":2,"name":"dusk"}` {
+		t.Fatalf("expected the JSON variation value, got %s", theme)
+	}
+	if _, ok := fi.Prefs["user1"]["flags.retired"]; ok || fi.Users["flags.new-nav"] != 2 {
+		t.Fatalf("expected off flags skipped, got %+v %+v", fi.Prefs["user1"], fi.Users)
+	}
+
+	var out bytes.Buffer
+	fi.Report(&out)
+	for _, want := range []string{
+		"4 flag(s) read; 3 preference(s) for 2 user(s)",
+		"flags.new-nav: 2 user(s)",
+		"new-nav: user user2 is targeted more than once",
+		"new-nav: 1 targeting rule(s) are not per-user",
+		"new-nav: org context targets are not users",
+		"retired: off in production",
+		"staging-only: no production environment",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	if fi, err := ParseFlagExport(FlagSourceLaunchDarkly, []byte(`{"key": "solo", "variations": [{"value": 1}], "environments": {"production": {"on": true, "targets": [{"values": ["u"], "variation": 0}]}}}`), "production", "ff."); err != nil || fi.Prefs["u"]["ff.solo"] == nil {
+		t.Fatalf("expected a single flag read, got %v %+v", err, fi)
+	}
+}
+
+func TestParseFlagExport_Unleash(t *testing.T) {
+	fi, err := ParseFlagExport(FlagSourceUnleash, []byte(unleashExportJSON), "production", "flags.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Users["flags.beta-search"] != 3 || fi.Prefs["user4"]["flags.beta-search"] != true || fi.Prefs["user9"] != nil {
+		t.Fatalf("unexpected import %+v", fi.Prefs)
+	}
+	var out bytes.Buffer
+	fi.Report(&out)
+	if !strings.Contains(out.String(), "dark-launch: not enabled in production") || !strings.Contains(out.String(), "rollout: flexibleRollout strategy is not per-user") {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+
+	for _, tc := range []struct{ source, data, namespace string }{
+		{FlagSourceUnleash, ldExport, "flags."},
+		{FlagSourceLaunchDarkly, `"flags"`, "flags."},
+		{"split", unleashExportJSON, "flags."},
+		{FlagSourceUnleash, unleashExportJSON, "flags"},
+		{FlagSourceUnleash, unleashExportJSON, ".flags."},
+	} {
+		if _, err := ParseFlagExport(tc.source, []byte(tc.data), "production", tc.namespace); err == nil {
+			t.Errorf("%s %q: expected an error", tc.source, tc.namespace)
+		}
+	}
+}
+
+func TestRunCtl_ImportFlags(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	prefs := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+	keys, _ := parseAdminKeys([]string{"support:0123456789abcdef0123"})
+	srv := httptest.NewServer(NewRouter(Handlers{Prefs: prefs, Corrections: NewCorrectionsHandler(prefs, newMockCorrectionStore())},
+		Config{AuthMode: AuthModeJWT, JWTSecret: testSecret, AdminAPIKeys: keys}, testLogger()))
+	defer srv.Close()
+
+	ctl := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runCtl(append([]string{"-url", srv.URL, "-token", "0123456789abcdef0123", "import-flags"}, args...), strings.NewReader(unleashExportJSON), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	if code, out, errOut := ctl("-source", "unleash", "-dry-run"); code != 0 || !strings.Contains(out, "Dry run: nothing written.") || len(store.prefs) != 1 {
+		t.Fatalf("dry run: %d %s %s %+v", code, out, errOut, store.prefs)
+	}
+	code, out, errOut := ctl("-source", "unleash", "-namespace", "legacy.")
+	if code != 0 || !strings.Contains(out, "Imported 3 of 3 user(s).") {
+		t.Fatalf("import: %d %s %s", code, out, errOut)
+	}
+	if store.prefs["user1"]["theme"] != "dark" || store.prefs["user1"]["legacy.beta-search"] != true || store.prefs["user4"]["legacy.beta-search"] != true {
+		t.Fatalf("expected the flags merged into preferences, got %+v", store.prefs)
+	}
+	if code, _, _ := ctl(); code != 2 {
+		t.Fatalf("expected -source required, got %d", code)
+	}
+}
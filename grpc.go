@@ -0,0 +1,695 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"slices"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	userprefsv1 "github.com/wozniakbe/user-prefs/proto/userprefs/v1"
+)
+
+// grpcReads lists the methods that only read, which need the read scope and
+// are shed last.
+var grpcReads = map[string]bool{
+	userprefsv1.PreferencesService_GetPreferences_FullMethodName: true,
+	userprefsv1.PreferencesService_GetPreference_FullMethodName:  true,
+}
+
+// GRPCServer serves the preferences CRUD API over gRPC (see
+// proto/userprefs/v1/prefs.proto). Calls use the preferences handler's
+// store, access check and validation directly, so only the wire format
+// differs from the REST API. Its interceptor sheds load, throttles failed
+// authentication and authenticates with the REST Authenticator: bearer
+// tokens are read from the "authorization" metadata, and in mTLS mode the
+// client certificate of the gRPC connection is used.
+type GRPCServer struct {
+	userprefsv1.UnimplementedPreferencesServiceServer
+	prefs  *PreferencesHandler
+	auth   Authenticator
+	logger *slog.Logger
+	// throttle is nil when the auth throttle is disabled.
+	throttle *authThrottle
+	shedder  *loadShedder
+	// audit is nil when auditing is disabled.
+	audit *AuditLog
+}
+
+// NewGRPCServer creates a gRPC service for hs.Prefs, authenticating and
+// limiting calls as NewRouter does requests.
+func NewGRPCServer(hs Handlers, cfg Config, logger *slog.Logger) *GRPCServer {
+	s := &GRPCServer{
+		prefs:  hs.Prefs,
+		auth:   newAuthenticator(cfg),
+		logger: logger,
+		shedder: newLoadShedder(LimitOptions{
+			RPS:           cfg.RateLimitRPS,
+			Burst:         cfg.RateLimitBurst,
+			MaxInFlight:   cfg.MaxInFlight,
+			LatencyTarget: cfg.ShedLatency,
+		}),
+		audit: hs.Audit,
+	}
+	if cfg.AuthThrottleMaxFailures > 0 {
+		s.throttle = newAuthThrottle(ThrottleOptions{
+			MaxFailures: cfg.AuthThrottleMaxFailures,
+			Window:      cfg.AuthThrottleWindow,
+			BaseBlock:   cfg.AuthThrottleBlock,
+			MaxBlock:    cfg.AuthThrottleMaxBlock,
+		})
+	}
+	return s
+}
+
+// NewGRPCListener returns a grpc.Server with the service and its interceptor
+// registered, using the REST listener's TLS config when it has one.
+func NewGRPCListener(s *GRPCServer, tlsCfg *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(s.intercept)}
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	srv := grpc.NewServer(opts...)
+	userprefsv1.RegisterPreferencesServiceServer(srv, s)
+	return srv
+}
+
+// userRequest is implemented by every request message.
+type userRequest interface {
+	GetUserId() string
+}
+
+// intercept does for a call what the REST middleware and authorize do for a
+// request: it recovers panics, sheds load, authenticates the caller and
+// checks their access to the request's user, then logs the call.
+func (s *GRPCServer) intercept(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	start := time.Now()
+	identity := new(Claims)
+	defer func() {
+		if p := recover(); p != nil {
+			s.logger.Error("panic recovered", "error", p, "rpc", info.FullMethod)
+			err = grpcError(http.StatusInternalServerError, "internal server error")
+		}
+		attrs := []any{
+			"rpc", info.FullMethod,
+			"code", status.Code(err).String(),
+			"duration", time.Since(start).String(),
+		}
+		if identity.Subject != "" {
+			attrs = append(attrs, "subject", identity.Subject)
+		}
+		if identity.Actor != "" {
+			attrs = append(attrs, "actor", identity.Actor)
+		}
+		s.logger.Info("call", attrs...)
+	}()
+	ctx = context.WithValue(ctx, identityKey, identity)
+
+	read := grpcReads[info.FullMethod]
+	release, err := s.admit(read)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	userID := ""
+	if r, ok := req.(userRequest); ok {
+		userID = r.GetUserId()
+	}
+	if ctx, err = s.authenticate(ctx, info.FullMethod, userID, !read); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// admit applies the LoadLimit checks to a call, returning the function to
+// call once it completes.
+func (s *GRPCServer) admit(read bool) (func(), error) {
+	if s.shedder.bucket != nil {
+		if ok, wait := s.shedder.bucket.allow(time.Now()); !ok {
+			return nil, grpcOverloaded(wait)
+		}
+	}
+	if !s.shedder.tracking() {
+		return func() {}, nil
+	}
+	p := priorityNormal
+	if read {
+		p = priorityHigh
+	}
+	if !s.shedder.admit(p, time.Now()) {
+		return nil, grpcOverloaded(retryAfter[p])
+	}
+	start := time.Now()
+	return func() { s.shedder.done(time.Since(start), time.Now()) }, nil
+}
+
+// authenticate authenticates a call and checks its access to userID, as
+// the auth middleware and authorize do, returning ctx with the caller's
+// claims. Failed authentication counts against the auth throttle, and
+// denials are audited.
+func (s *GRPCServer) authenticate(ctx context.Context, method, userID string, write bool) (context.Context, error) {
+	c := grpcCredentials(ctx, userID)
+	ipKey, subKey := "ip:"+peerIP(c.RemoteAddr), subjectKey(c, "")
+	if s.throttle != nil {
+		wait, err := s.throttle.check(ctx, ipKey, subKey)
+		if err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		if wait > 0 {
+			return nil, grpcRetry(http.StatusTooManyRequests, "too many failed authentication attempts", wait)
+		}
+	}
+
+	claims, err := s.auth(ctx, c)
+	var denied *authError
+	if errors.As(err, &denied) {
+		if denied.Status == http.StatusUnauthorized && s.throttle != nil {
+			s.throttle.failed(ipKey, subKey)
+		}
+		s.deny(method, c.RemoteAddr, denied.Status, denied.Reason, denied.Subject, denied.Actor, userID)
+		return nil, grpcError(denied.Status, denied.Reason)
+	}
+	if err != nil {
+		return nil, grpcError(http.StatusServiceUnavailable, err.Error())
+	}
+	ctx = contextWithClaims(ctx, claims)
+
+	if userID == "" {
+		return nil, grpcError(http.StatusBadRequest, "missing userId")
+	}
+	if denied := s.prefs.access(claims, userID, write); denied != nil {
+		if denied.Status == http.StatusNotFound {
+			s.deny(method, c.RemoteAddr, http.StatusForbidden, denied.Reason, claims.Subject, claims.Actor, userID)
+			return nil, grpcError(http.StatusNotFound, "not found")
+		}
+		s.deny(method, c.RemoteAddr, denied.Status, denied.Reason, claims.Subject, claims.Actor, userID)
+		return nil, grpcError(denied.Status, denied.Reason)
+	}
+	return ctx, nil
+}
+
+// deny records a denied call in the audit log, if there is one.
+func (s *GRPCServer) deny(method, remote string, status int, reason, subject, actor, userID string) {
+	if s.audit != nil {
+		s.audit.deniedCall(method, remote, status, reason, subject, actor, userID)
+	}
+}
+
+// grpcCredentials reads a call's credentials: the "authorization" metadata
+// and the peer's address and TLS state.
+func grpcCredentials(ctx context.Context, userID string) Credentials {
+	c := Credentials{UserID: userID}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			c.Authorization = v[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		c.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			c.TLS = &info.State
+		}
+	}
+	return c
+}
+
+// peerIP returns the IP of a gRPC peer, or remoteAddr itself when it has
+// none.
+func peerIP(remoteAddr string) string {
+	addr, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return addr.Addr().Unmap().String()
+}
+
+func (s *GRPCServer) GetPreferences(ctx context.Context, req *userprefsv1.GetPreferencesRequest) (*userprefsv1.Preferences, error) {
+	h, userID := s.prefs, req.GetUserId()
+
+	var rec Record
+	var keys []string
+	var err error
+	op := "store.GetAll"
+	if len(req.GetKeys()) > 0 {
+		keys = slices.Compact(slices.Sorted(slices.Values(req.GetKeys())))
+		if keys[0] == "" {
+			keys = keys[1:]
+		}
+		if len(keys) == 0 || len(keys) > maxFilterKeys {
+			return nil, grpcError(http.StatusBadRequest, fmt.Sprintf("keys must list 1 to %d keys", maxFilterKeys))
+		}
+		op = "store.GetKeys"
+		rec, err = h.store.GetKeys(ctx, userID, h.aliasReadKeys(keys))
+	} else {
+		rec, err = h.store.GetAll(ctx, userID)
+	}
+	if err != nil {
+		s.logger.Error(op+" failed", "error", err, "userId", userID)
+		return nil, grpcError(http.StatusInternalServerError, "failed to retrieve preferences")
+	}
+	if h.opts.UnknownUsersNotFound && rec.Prefs == nil {
+		return nil, grpcErrorCode(http.StatusNotFound, ErrCodeUserNotFound, "user has no stored preferences")
+	}
+	return preferencesMessage(userID, h.resolveAliases(rec.Prefs, keys), rec)
+}
+
+func (s *GRPCServer) ReplacePreferences(ctx context.Context, req *userprefsv1.ReplacePreferencesRequest) (*userprefsv1.Preferences, error) {
+	h, userID := s.prefs, req.GetUserId()
+
+	cond, err := s.precondition(req.IfVersion)
+	if err != nil {
+		return nil, err
+	}
+	prefs, err := fromStruct(req.GetPreferences())
+	if err != nil {
+		return nil, grpcErrorCode(http.StatusBadRequest, ErrCodeInvalidBody, "invalid preferences")
+	}
+	h.dropUnknownKeys(prefs)
+	h.mirrorAliases(prefs, nil)
+	if err := s.validate(ctx, userID, prefs, nil, true); err != nil {
+		return nil, err
+	}
+
+	rec, err := h.store.ReplaceAll(ctx, userID, prefs, cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return nil, grpcError(http.StatusPreconditionFailed, "preferences have been modified")
+	}
+	if err != nil {
+		s.logger.Error("store.ReplaceAll failed", "error", err, "userId", userID)
+		return nil, grpcError(http.StatusInternalServerError, "failed to save preferences")
+	}
+	return preferencesMessage(userID, prefs, rec)
+}
+
+// UpdatePreferences merges preferences into the map, removing the keys in
+// remove and any set to null, as a merge patch does.
+func (s *GRPCServer) UpdatePreferences(ctx context.Context, req *userprefsv1.UpdatePreferencesRequest) (*userprefsv1.Preferences, error) {
+	h, userID := s.prefs, req.GetUserId()
+
+	cond, err := s.precondition(req.IfVersion)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := fromStruct(req.GetPreferences())
+	if err != nil {
+		return nil, grpcErrorCode(http.StatusBadRequest, ErrCodeInvalidBody, "invalid preferences")
+	}
+	prefs := make(map[string]any, len(patch))
+	var remove []string
+	for k, v := range patch {
+		if v == nil {
+			remove = append(remove, k)
+		} else {
+			prefs[k] = v
+		}
+	}
+	for _, k := range req.GetRemove() {
+		if _, ok := prefs[k]; ok {
+			return nil, grpcError(http.StatusBadRequest, fmt.Sprintf("key %q is both set and removed", k))
+		}
+		if !slices.Contains(remove, k) {
+			remove = append(remove, k)
+		}
+	}
+	if msg, v := h.patchSizeViolation(len(prefs) + len(remove)); v != nil {
+		return nil, grpcViolations(ErrCodeTooManyKeys, msg, v)
+	}
+
+	h.dropUnknownKeys(prefs)
+	if len(prefs) == 0 && len(remove) == 0 {
+		return nil, grpcError(http.StatusBadRequest, "empty preferences")
+	}
+	remove = h.mirrorAliases(prefs, remove)
+	if err := s.validate(ctx, userID, prefs, remove, false); err != nil {
+		return nil, err
+	}
+
+	merged, err := h.store.Update(ctx, userID, prefs, remove, cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return nil, grpcError(http.StatusPreconditionFailed, "preferences have been modified")
+	}
+	if err != nil {
+		s.logger.Error("store.Update failed", "error", err, "userId", userID)
+		return nil, grpcError(http.StatusInternalServerError, "failed to update preferences")
+	}
+	return preferencesMessage(userID, merged.Prefs, merged)
+}
+
+// DeletePreferences removes all of a user's preferences, moving them to the
+// trash when soft delete is enabled. Undo is not offered, since a call has
+// no Undo-Token to return.
+func (s *GRPCServer) DeletePreferences(ctx context.Context, req *userprefsv1.DeletePreferencesRequest) (*emptypb.Empty, error) {
+	h, userID := s.prefs, req.GetUserId()
+
+	if h.opts.Trash != nil {
+		prev, err := h.store.GetAll(ctx, userID)
+		if err != nil {
+			s.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+			return nil, grpcError(http.StatusInternalServerError, "failed to delete preferences")
+		}
+		if err := h.trash(ctx, userID, prev.Prefs); err != nil {
+			return nil, grpcError(http.StatusInternalServerError, "failed to delete preferences")
+		}
+	}
+	if err := h.store.DeleteAll(ctx, userID); err != nil {
+		s.logger.Error("store.DeleteAll failed", "error", err, "userId", userID)
+		return nil, grpcError(http.StatusInternalServerError, "failed to delete preferences")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GRPCServer) GetPreference(ctx context.Context, req *userprefsv1.GetPreferenceRequest) (*userprefsv1.Preference, error) {
+	h, userID, key := s.prefs, req.GetUserId(), req.GetKey()
+	if key == "" {
+		return nil, grpcError(http.StatusBadRequest, "missing key")
+	}
+
+	var value any
+	var found bool
+	var err error
+	op := "store.Get"
+	if target, aliased := h.aliasTarget(key); aliased {
+		op = "store.GetKeys"
+		var rec Record
+		if rec, err = h.store.GetKeys(ctx, userID, []string{key, target}); err == nil {
+			if value, found = rec.Prefs[target]; !found {
+				value, found = rec.Prefs[key]
+			}
+		}
+	} else {
+		value, found, _, err = h.store.Get(ctx, userID, key)
+	}
+	if err != nil {
+		s.logger.Error(op+" failed", "error", err, "userId", userID, "key", key)
+		return nil, grpcError(http.StatusInternalServerError, "failed to retrieve preference")
+	}
+	if !found {
+		return nil, grpcErrorCode(http.StatusNotFound, ErrCodePrefNotFound, "preference not found")
+	}
+
+	v, err := toValue(value)
+	if err != nil {
+		return nil, grpcError(http.StatusInternalServerError, "failed to encode preference")
+	}
+	return &userprefsv1.Preference{UserId: userID, Key: key, Value: v}, nil
+}
+
+func (s *GRPCServer) SetPreference(ctx context.Context, req *userprefsv1.SetPreferenceRequest) (*userprefsv1.Preference, error) {
+	h, userID, key := s.prefs, req.GetUserId(), req.GetKey()
+	if key == "" {
+		return nil, grpcError(http.StatusBadRequest, "missing key")
+	}
+
+	cond, err := s.precondition(nil)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetValue() == nil {
+		return nil, grpcError(http.StatusBadRequest, "missing value")
+	}
+	value, err := fromValue(req.GetValue())
+	if err != nil {
+		return nil, grpcErrorCode(http.StatusBadRequest, ErrCodeInvalidBody, "invalid value")
+	}
+	prefs := map[string]any{key: value}
+	h.mirrorAliases(prefs, nil)
+	if err := s.validate(ctx, userID, prefs, nil, false); err != nil {
+		return nil, err
+	}
+
+	_, err = h.store.Update(ctx, userID, prefs, nil, cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return nil, grpcError(http.StatusPreconditionFailed, "preferences have been modified")
+	}
+	if err != nil {
+		s.logger.Error("store.Update failed", "error", err, "userId", userID, "key", key)
+		return nil, grpcError(http.StatusInternalServerError, "failed to save preference")
+	}
+	return &userprefsv1.Preference{UserId: userID, Key: key, Value: req.GetValue()}, nil
+}
+
+func (s *GRPCServer) DeletePreference(ctx context.Context, req *userprefsv1.DeletePreferenceRequest) (*emptypb.Empty, error) {
+	h, userID, key := s.prefs, req.GetUserId(), req.GetKey()
+	if key == "" {
+		return nil, grpcError(http.StatusBadRequest, "missing key")
+	}
+
+	if _, err := s.precondition(nil); err != nil {
+		return nil, err
+	}
+	var err error
+	if remove := h.mirrorAliases(nil, []string{key}); len(remove) > 1 {
+		// The alias and its replacement go in one write; a user with no
+		// record has nothing to delete.
+		_, err = h.store.Update(ctx, userID, nil, remove, Precondition{MustExist: true})
+		if errors.Is(err, ErrPreconditionFailed) {
+			err = nil
+		}
+	} else {
+		err = h.store.Delete(ctx, userID, key)
+	}
+	if err != nil {
+		s.logger.Error("store.Delete failed", "error", err, "userId", userID, "key", key)
+		return nil, grpcError(http.StatusInternalServerError, "failed to delete preference")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// precondition builds the write precondition for an optional if_version,
+// as precondition does from If-Match.
+func (s *GRPCServer) precondition(version *int64) (Precondition, error) {
+	if version == nil {
+		if s.prefs.opts.RequireIfMatch {
+			return Precondition{}, grpcError(http.StatusPreconditionRequired, "if_version required")
+		}
+		return Precondition{}, nil
+	}
+	if *version < 0 {
+		return Precondition{}, grpcError(http.StatusPreconditionFailed, "preferences have been modified")
+	}
+	return Precondition{Versions: []int64{*version}}, nil
+}
+
+// validate applies the REST write checks to prefs: the key and value rules,
+// then the quota.
+func (s *GRPCServer) validate(ctx context.Context, userID string, prefs map[string]any, remove []string, replace bool) error {
+	if v := s.prefs.prefViolations(prefs); len(v) > 0 {
+		return grpcViolations(ErrCodeValidationFailed, "invalid preferences", v)
+	}
+	v, err := s.prefs.quotaViolations(ctx, userID, prefs, remove, replace)
+	if err != nil {
+		s.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+		return grpcError(http.StatusInternalServerError, "failed to save preferences")
+	}
+	if len(v) > 0 {
+		return grpcViolations(ErrCodeQuotaExceeded, "preference quota exceeded", v)
+	}
+	return nil
+}
+
+// preferencesMessage builds a Preferences response for a user's map as of
+// rec.
+func preferencesMessage(userID string, prefs map[string]any, rec Record) (*userprefsv1.Preferences, error) {
+	st, err := toStruct(prefs)
+	if err != nil {
+		return nil, grpcError(http.StatusInternalServerError, "failed to encode preferences")
+	}
+	return &userprefsv1.Preferences{UserId: userID, Preferences: st, Version: rec.Version}, nil
+}
+
+// fromStruct converts a Struct to a preference map holding what decodeJSON
+// decodes from its JSON, with numbers as json.Number, so values sent over
+// gRPC are validated and stored exactly as REST bodies are.
+func fromStruct(s *structpb.Struct) (map[string]any, error) {
+	out := make(map[string]any, len(s.GetFields()))
+	for k, v := range s.GetFields() {
+		val, err := fromValue(v)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = val
+	}
+	return out, nil
+}
+
+func fromValue(v *structpb.Value) (any, error) {
+	switch kind := v.GetKind().(type) {
+	case *structpb.Value_NullValue:
+		return nil, nil
+	case *structpb.Value_NumberValue:
+		// NaN and the infinities have no JSON form, so json.Marshal
+		// rejects them.
+		b, err := json.Marshal(kind.NumberValue)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(b), nil
+	case *structpb.Value_StringValue:
+		return kind.StringValue, nil
+	case *structpb.Value_BoolValue:
+		return kind.BoolValue, nil
+	case *structpb.Value_StructValue:
+		return fromStruct(kind.StructValue)
+	case *structpb.Value_ListValue:
+		out := make([]any, len(kind.ListValue.GetValues()))
+		for i, e := range kind.ListValue.GetValues() {
+			val, err := fromValue(e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = val
+		}
+		return out, nil
+	}
+	return nil, errors.New("value has no kind")
+}
+
+// toStruct converts a stored preference map to a Struct.
+func toStruct(prefs map[string]any) (*structpb.Struct, error) {
+	out := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(prefs))}
+	for k, v := range prefs {
+		val, err := toValue(v)
+		if err != nil {
+			return nil, err
+		}
+		out.Fields[k] = val
+	}
+	return out, nil
+}
+
+// toValue converts a stored value to a Value. Numbers become float64, the
+// only number a Value holds.
+func toValue(v any) (*structpb.Value, error) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewNumberValue(f), nil
+	case map[string]any:
+		st, err := toStruct(v)
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewStructValue(st), nil
+	case []any:
+		list := &structpb.ListValue{Values: make([]*structpb.Value, len(v))}
+		for i, e := range v {
+			val, err := toValue(e)
+			if err != nil {
+				return nil, err
+			}
+			list.Values[i] = val
+		}
+		return structpb.NewListValue(list), nil
+	}
+	return structpb.NewValue(v)
+}
+
+// grpcError returns the status for an error, as writeError would write it.
+func grpcError(code int, msg string) error {
+	return grpcErrorCode(code, statusErrorCode(code), msg)
+}
+
+// grpcErrorCode returns the status for an error with a specific code.
+func grpcErrorCode(code int, errorCode, msg string) error {
+	return grpcStatus(code, APIError{Error: msg, Code: code, ErrorCode: errorCode, DocURL: errorDocURL(errorCode)})
+}
+
+// grpcViolations rejects a write as writeViolations does, listing each
+// violation.
+func grpcViolations(errorCode, msg string, v []Violation) error {
+	return grpcStatus(http.StatusUnprocessableEntity, APIError{
+		Error:      msg,
+		Code:       http.StatusUnprocessableEntity,
+		ErrorCode:  errorCode,
+		DocURL:     errorDocURL(errorCode),
+		Violations: v,
+	})
+}
+
+// grpcRetry returns the status for an error the caller should retry after
+// wait, the Retry-After of the REST response, carried in a RetryInfo.
+func grpcRetry(code int, msg string, wait time.Duration) error {
+	errorCode := statusErrorCode(code)
+	return grpcStatus(code, APIError{Error: msg, Code: code, ErrorCode: errorCode, DocURL: errorDocURL(errorCode)},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+}
+
+// grpcOverloaded sheds a call, as shed does a request.
+func grpcOverloaded(retryAfter time.Duration) error {
+	return grpcRetry(http.StatusServiceUnavailable, "server overloaded, retry later",
+		time.Duration(math.Ceil(retryAfter.Seconds()))*time.Second)
+}
+
+// grpcStatus converts an API error to a gRPC status, carrying the error
+// code in an ErrorInfo and any violations in a BadRequest detail.
+func grpcStatus(code int, apiErr APIError, extra ...protoadapt.MessageV1) error {
+	st := status.New(grpcCode(code), apiErr.Error)
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason:   apiErr.ErrorCode,
+		Domain:   "user-prefs",
+		Metadata: map[string]string{"docUrl": apiErr.DocURL},
+	}}
+	if len(apiErr.Violations) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, v := range apiErr.Violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Key,
+				Description: v.Reason,
+				Reason:      v.Code,
+			})
+		}
+		details = append(details, br)
+	}
+	details = append(details, extra...)
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// grpcCode maps an HTTP status to the closest gRPC code.
+func grpcCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.Internal
+}
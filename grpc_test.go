@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	userprefsv1 "github.com/wozniakbe/user-prefs/proto/userprefs/v1"
+)
+
+func TestGRPCServer(t *testing.T) {
+	store := newMockStore()
+	prefs := NewPreferencesHandler(store, testLogger(), HandlerOptions{ReservedKeyPrefixes: []string{"sys."}})
+	cfg := Config{AuthMode: AuthModeJWT, JWTSecret: testSecret}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := NewGRPCListener(NewGRPCServer(Handlers{Prefs: prefs}, cfg, testLogger()), nil)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := userprefsv1.NewPreferencesServiceClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"authorization", "Bearer "+makeToken("user1", testSecret, jwt.SigningMethodHS256))
+
+	if _, err := client.GetPreferences(context.Background(), &userprefsv1.GetPreferencesRequest{UserId: "user1"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a token, got %v", err)
+	}
+	if _, err := client.GetPreferences(ctx, &userprefsv1.GetPreferencesRequest{UserId: "user2"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for another user, got %v", err)
+	}
+
+	initial, _ := structpb.NewStruct(map[string]any{"theme": "dark", "lang": "en", "layout": map[string]any{"columns": 3}})
+	got, err := client.ReplacePreferences(ctx, &userprefsv1.ReplacePreferencesRequest{UserId: "user1", Preferences: initial})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 1 || !proto.Equal(got.Preferences, initial) {
+		t.Fatalf("unexpected replace result %v", got)
+	}
+
+	merge, _ := structpb.NewStruct(map[string]any{"theme": "light"})
+	stale := int64(0)
+	_, err = client.UpdatePreferences(ctx, &userprefsv1.UpdatePreferencesRequest{UserId: "user1", Preferences: merge, IfVersion: &stale})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for a stale version, got %v", err)
+	}
+	got, err = client.UpdatePreferences(ctx, &userprefsv1.UpdatePreferencesRequest{UserId: "user1", Preferences: merge, Remove: []string{"lang"}, IfVersion: &got.Version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 2 || len(got.Preferences.Fields) != 2 || got.Preferences.Fields["theme"].GetStringValue() != "light" {
+		t.Fatalf("unexpected update result %v", got)
+	}
+
+	got, err = client.GetPreferences(ctx, &userprefsv1.GetPreferencesRequest{UserId: "user1", Keys: []string{"layout"}})
+	if err != nil || len(got.Preferences.Fields) != 1 || got.Preferences.Fields["layout"].GetStructValue().Fields["columns"].GetNumberValue() != 3 {
+		t.Fatalf("expected only the requested key, got %v %v", got, err)
+	}
+
+	one, err := client.SetPreference(ctx, &userprefsv1.SetPreferenceRequest{UserId: "user1", Key: "volume", Value: structpb.NewNumberValue(7)})
+	if err != nil || one.Key != "volume" || one.Value.GetNumberValue() != 7 {
+		t.Fatalf("unexpected set result %v %v", one, err)
+	}
+	one, err = client.GetPreference(ctx, &userprefsv1.GetPreferenceRequest{UserId: "user1", Key: "volume"})
+	if err != nil || one.Value.GetNumberValue() != 7 {
+		t.Fatalf("unexpected get result %v %v", one, err)
+	}
+	// Numbers are stored as REST bodies decode them.
+	if rec, _ := store.GetAll(context.Background(), "user1"); rec.Prefs["volume"] != json.Number("7") {
+		t.Fatalf("expected volume stored as json.Number, got %#v", rec.Prefs["volume"])
+	}
+	if _, err := client.SetPreference(ctx, &userprefsv1.SetPreferenceRequest{UserId: "user1", Key: "volume", Value: structpb.NewNumberValue(math.NaN())}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for NaN, got %v", err)
+	}
+
+	// Validation errors carry the API error code.
+	_, err = client.SetPreference(ctx, &userprefsv1.SetPreferenceRequest{UserId: "user1", Key: "sys.flag", Value: structpb.NewBoolValue(true)})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a reserved key, got %v", err)
+	}
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		if d, ok := d.(*errdetails.ErrorInfo); ok {
+			info = d
+		}
+	}
+	if info == nil || info.Reason == "" {
+		t.Fatalf("expected an ErrorInfo detail, got %v", st.Details())
+	}
+
+	if _, err := client.DeletePreference(ctx, &userprefsv1.DeletePreferenceRequest{UserId: "user1", Key: "volume"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetPreference(ctx, &userprefsv1.GetPreferenceRequest{UserId: "user1", Key: "volume"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound after delete, got %v", err)
+	}
+	if _, err := client.DeletePreferences(ctx, &userprefsv1.DeletePreferencesRequest{UserId: "user1"}); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := store.GetAll(context.Background(), "user1"); rec.Prefs != nil {
+		t.Fatalf("expected the preferences deleted, got %v", rec.Prefs)
+	}
+}
@@ -1,23 +1,105 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"mime"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 )
 
+// HandlerOptions holds tunables for the preference handlers.
+type HandlerOptions struct {
+	// MaxResponseBytes caps the size of GetAll responses. Larger maps are
+	// returned a page at a time with a continuation cursor. Zero disables.
+	MaxResponseBytes int
+	// SensitiveKeys are encrypted at rest; their values are redacted
+	// wherever they would be copied out of the store, such as correction
+	// requests.
+	SensitiveKeys []string
+	// ConcealForbidden answers cross-user access with 404 instead of 403,
+	// so callers cannot tell which user IDs exist. The audit log still
+	// records the denial.
+	ConcealForbidden bool
+	// RequireIfMatch rejects PUT, POST and PATCH requests, and DELETEs of
+	// single or listed keys, that carry no If-Match header with 428, so
+	// clients cannot overwrite concurrent edits by accident.
+	RequireIfMatch bool
+	// MaxBatchUsers caps the userIds in one batch request; zero means
+	// defaultMaxBatchUsers.
+	MaxBatchUsers int
+	// MaxPatchKeys caps the keys one PATCH may set or remove; zero means
+	// defaultMaxPatchKeys.
+	MaxPatchKeys int
+	// ReservedKeyPrefixes may not start keys written by clients.
+	ReservedKeyPrefixes []string
+	// Quota limits each user's stored preferences.
+	Quota Quota
+	// Defaults backs GET ?view=effective; nil means no defaults.
+	Defaults *Defaults
+	// AllowedKeys, when set, are the only keys clients may write; entries
+	// ending in "." allow a namespace. Other keys are rejected, or dropped
+	// from multi-key writes with DropUnknownKeys.
+	AllowedKeys     []string
+	DropUnknownKeys bool
+	// Schema declares well-known keys whose values must match their type
+	// and constraints; nil checks none.
+	Schema *SchemaRegistry
+	// RejectDeprecatedWrites rejects writes to keys the schema deprecates,
+	// instead of mirroring aliases to the keys replacing them.
+	RejectDeprecatedWrites bool
+	// ValueSchemas validates written values; nil accepts any value.
+	ValueSchemas *ValueSchemas
+	// Trash keeps the maps removed by DeleteAll restorable for
+	// TrashRetention; nil deletes them outright.
+	Trash          Store
+	TrashRetention time.Duration
+	// Undo keeps the map each DeleteAll or ReplaceAll replaces, so the
+	// write can be undone within UndoWindow; nil disables undo.
+	Undo       Store
+	UndoWindow time.Duration
+	// UnknownUsersNotFound answers reads of the map of users who have
+	// never stored preferences with 404 instead of an empty map. Requests
+	// can override it with Prefer: unknown-user=not-found|empty.
+	UnknownUsersNotFound bool
+	// Templates are the named preference maps ApplyTemplate seeds new
+	// users with; none disables the endpoint.
+	Templates map[string]map[string]any
+}
+
+// redactedValue replaces sensitive values outside the preferences store.
+const redactedValue = "[redacted]"
+
+// redact returns value, or a placeholder when key is sensitive.
+func (o HandlerOptions) redact(key string, value any) any {
+	if slices.Contains(o.SensitiveKeys, key) {
+		return redactedValue
+	}
+	return value
+}
+
 // PreferencesHandler holds dependencies for preference CRUD handlers.
 type PreferencesHandler struct {
 	store  Store
 	logger *slog.Logger
+	opts   HandlerOptions
 }
 
-// NewPreferencesHandler creates a new handler with the given store and logger.
-func NewPreferencesHandler(store Store, logger *slog.Logger) *PreferencesHandler {
-	return &PreferencesHandler{store: store, logger: logger}
+// NewPreferencesHandler creates a new handler with the given store, logger,
+// and options.
+func NewPreferencesHandler(store Store, logger *slog.Logger, opts HandlerOptions) *PreferencesHandler {
+	return &PreferencesHandler{store: store, logger: logger, opts: opts}
 }
 
-// authorize checks that the JWT subject matches the requested userId.
+// authorize checks that the JWT subject matches the requested userId. Service
+// principals may act on any user but need the read or write scope.
 func (h *PreferencesHandler) authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
 	userID := r.PathValue("userId")
 	if userID == "" {
@@ -27,43 +109,241 @@ func (h *PreferencesHandler) authorize(w http.ResponseWriter, r *http.Request) (
 
 	claims, ok := ClaimsFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "missing claims")
+		deny(w, r, http.StatusUnauthorized, "missing claims")
 		return "", false
 	}
 
-	if claims.Subject != userID {
-		writeError(w, http.StatusForbidden, "access denied")
+	write := r.Method != http.MethodGet && r.Method != http.MethodHead
+	if denied := h.access(claims, userID, write); denied != nil {
+		if denied.Status == http.StatusNotFound {
+			recordDenial(r, http.StatusForbidden, denied.Reason, claims.Subject, claims.Actor)
+			writeError(w, http.StatusNotFound, "not found")
+			return "", false
+		}
+		deny(w, r, denied.Status, denied.Reason)
 		return "", false
 	}
-
 	return userID, true
 }
 
-// GetAll returns all preferences for a user.
+// access decides whether claims may read userID's preferences, or change
+// them when write is set, returning the denial if not. With
+// ConcealForbidden, another user's preferences are denied as 404, so their
+// existence is not revealed.
+func (h *PreferencesHandler) access(claims Claims, userID string, write bool) *authError {
+	if claims.Kind == PrincipalService {
+		scope := ScopeRead
+		if write {
+			scope = ScopeWrite
+		}
+		if !claims.HasScope(scope) {
+			return &authError{Status: http.StatusForbidden, Reason: "insufficient scope"}
+		}
+		return nil
+	}
+
+	if claims.Subject != userID {
+		if h.opts.ConcealForbidden {
+			return &authError{Status: http.StatusNotFound, Reason: "access denied (reported as 404)"}
+		}
+		return &authError{Status: http.StatusForbidden, Reason: "access denied"}
+	}
+	return nil
+}
+
+// defaultMaxPatchKeys bounds PATCH bodies when no limit is configured. Each
+// key adds two SET or REMOVE clauses to the DynamoDB update expression,
+// which may not exceed 4 KB.
+const defaultMaxPatchKeys = 100
+
+// maxFilterKeys caps the ?keys= list, keeping the store projection small.
+const maxFilterKeys = 100
+
+// GetAll returns all preferences for a user, or only those named in
+// ?keys=a,b or starting with ?prefix= (e.g. "notifications."). If the map
+// would exceed the response size limit, or the client passes ?cursor=, a
+// page of keys is returned instead; truncated pages use 206 and carry a
+// nextCursor. Clients holding a current copy (If-None-Match /
+// If-Modified-Since) get 304. ?include=metadata adds per-key metadata for the
+// keys in the response. ?view=effective merges the configured defaults under
+// the stored values. ?fields=preferences,updatedAt trims the response to the
+// listed fields. HEAD requests are answered from Stat.
 func (h *PreferencesHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.authorize(w, r)
 	if !ok {
 		return
 	}
+	if r.Method == http.MethodHead {
+		h.head(w, r, userID, "")
+		return
+	}
+
+	withMeta := false
+	for _, inc := range splitList(r.URL.Query().Get("include")) {
+		if inc != "metadata" {
+			writeError(w, http.StatusBadRequest, "include must be metadata")
+			return
+		}
+		withMeta = true
+	}
+
+	fields, ok := parseFields(w, r, PreferencesResponse{})
+	if !ok {
+		return
+	}
 
-	prefs, err := h.store.GetAll(r.Context(), userID)
+	var effective bool
+	switch r.URL.Query().Get("view") {
+	case "", "stored":
+	case "effective":
+		effective = true
+	default:
+		writeError(w, http.StatusBadRequest, "view must be stored or effective")
+		return
+	}
+
+	var after string
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		var err error
+		if after, err = decodeCursor(cursor); err != nil {
+			writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidCursor, "invalid cursor")
+			return
+		}
+	}
+
+	var rec Record
+	var keys []string
+	var err error
+	op := "store.GetAll"
+	if r.URL.Query().Has("keys") {
+		keys = slices.Compact(slices.Sorted(slices.Values(splitList(r.URL.Query().Get("keys")))))
+		if len(keys) == 0 || len(keys) > maxFilterKeys {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("keys must list 1 to %d keys", maxFilterKeys))
+			return
+		}
+		op = "store.GetKeys"
+		rec, err = h.store.GetKeys(r.Context(), userID, h.aliasReadKeys(keys))
+	} else {
+		rec, err = h.store.GetAll(r.Context(), userID)
+	}
 	if err != nil {
-		h.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+		h.logger.Error(op+" failed", "error", err, "userId", userID)
 		writeError(w, http.StatusInternalServerError, "failed to retrieve preferences")
 		return
 	}
+	// The effective view always has the defaults to show.
+	if !effective && h.unknownUserNotFound(w, r) && rec.Prefs == nil {
+		writeErrorCode(w, http.StatusNotFound, ErrCodeUserNotFound, "user has no stored preferences")
+		return
+	}
 
+	prefs := rec.Prefs
+	defaults, defaultsHash := h.opts.Defaults.Get()
+	if effective && defaultsHash != "" {
+		// Defaults change independently of the record, so the view gets its
+		// own tag and no Last-Modified.
+		etag := effectiveETag(rec, defaultsHash)
+		w.Header().Set("ETag", etag)
+		if inm := r.Header.Get("If-None-Match"); inm != "" && noneMatchHits(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		prefs = effectivePrefs(defaults, rec.Prefs, keys)
+	} else {
+		setValidators(w, rec)
+		if notModified(r, rec) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	prefs = h.resolveAliases(prefs, keys)
+	if prefix := r.URL.Query().Get("prefix"); prefix != "" {
+		// DynamoDB cannot project map entries by prefix, so the whole map
+		// is read and filtered here.
+		prefs = filterPrefix(prefs, prefix)
+	}
 	if prefs == nil {
-		prefs = make(map[string]string)
+		prefs = make(map[string]any)
 	}
+	h.warnDeprecated(w, slices.Collect(maps.Keys(prefs)))
+
+	if h.opts.MaxResponseBytes <= 0 && after == "" {
+		resp := newPreferencesResponse(userID, prefs, rec)
+		if withMeta {
+			resp.Metadata = metadataFor(prefs, rec)
+		}
+		writeFields(w, http.StatusOK, resp, fields)
+		return
+	}
+
+	// Metadata is not counted against the page budget.
+	resp := newPreferencesResponse(userID, nil, rec)
+	page, next := pagePrefs(prefs, after, pageBudget(h.opts.MaxResponseBytes, resp, prefs))
+	status := http.StatusOK
+	if next != "" {
+		w.Header().Set(truncatedHeader, "true")
+		status = http.StatusPartialContent
+	}
+
+	resp.Preferences = page
+	resp.NextCursor = next
+	if withMeta {
+		resp.Metadata = metadataFor(page, rec)
+	}
+	writeFields(w, status, resp, fields)
+}
 
-	writeJSON(w, http.StatusOK, PreferencesResponse{
-		UserID:      userID,
-		Preferences: prefs,
-	})
+// metadataFor builds the response metadata for the keys of prefs. Keys not
+// stored in rec come from the defaults.
+func metadataFor(prefs map[string]any, rec Record) map[string]PrefMetadata {
+	out := make(map[string]PrefMetadata, len(prefs))
+	for k := range prefs {
+		if _, stored := rec.Prefs[k]; !stored {
+			out[k] = PrefMetadata{Source: SourceDefault}
+			continue
+		}
+		md := PrefMetadata{Source: SourceUser}
+		if km, ok := rec.Meta[k]; ok {
+			if !km.UpdatedAt.IsZero() {
+				md.UpdatedAt = &km.UpdatedAt
+			}
+			md.LastUpdatedBy = km.UpdatedBy
+		}
+		out[k] = md
+	}
+	return out
 }
 
-// GetOne returns a single preference by key.
+// effectivePrefs overlays stored on defaults. When keys is non-empty, only
+// those defaults are included, matching the stored keys read.
+func effectivePrefs(defaults, stored map[string]any, keys []string) map[string]any {
+	out := make(map[string]any, len(defaults)+len(stored))
+	for k, v := range defaults {
+		if len(keys) == 0 || slices.Contains(keys, k) {
+			out[k] = v
+		}
+	}
+	maps.Copy(out, stored)
+	return out
+}
+
+// filterPrefix returns the entries of prefs whose keys start with prefix.
+func filterPrefix(prefs map[string]any, prefix string) map[string]any {
+	out := make(map[string]any)
+	for k, v := range prefs {
+		if strings.HasPrefix(k, prefix) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// GetOne returns a single preference by key. It reads the key with the
+// record's validators, so clients holding a current copy (If-None-Match /
+// If-Modified-Since) get 304 as for the whole map. It is the hot path, so
+// unaliased keys are read with the projected Get, which builds no maps. HEAD
+// requests are answered from Stat.
 func (h *PreferencesHandler) GetOne(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.authorize(w, r)
 	if !ok {
@@ -75,95 +355,415 @@ func (h *PreferencesHandler) GetOne(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "missing key")
 		return
 	}
+	if r.Method == http.MethodHead {
+		h.head(w, r, userID, key)
+		return
+	}
 
-	value, found, err := h.store.Get(r.Context(), userID, key)
+	var value any
+	var found bool
+	var rec Record
+	var err error
+	op := "store.Get"
+	if target, aliased := h.aliasTarget(key); aliased {
+		op = "store.GetKeys"
+		if rec, err = h.store.GetKeys(r.Context(), userID, []string{key, target}); err == nil {
+			if value, found = rec.Prefs[target]; !found {
+				value, found = rec.Prefs[key]
+			}
+		}
+	} else {
+		value, found, rec, err = h.store.Get(r.Context(), userID, key)
+	}
 	if err != nil {
-		h.logger.Error("store.Get failed", "error", err, "userId", userID, "key", key)
+		h.logger.Error(op+" failed", "error", err, "userId", userID, "key", key)
 		writeError(w, http.StatusInternalServerError, "failed to retrieve preference")
 		return
 	}
-
 	if !found {
-		writeError(w, http.StatusNotFound, "preference not found")
+		writeErrorCode(w, http.StatusNotFound, ErrCodePrefNotFound, "preference not found")
+		return
+	}
+
+	writeValidators(w, rec)
+	if unchanged(r, rec) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.warnDeprecated(w, []string{key})
+	writeSinglePref(w, key, value)
+}
+
+// head answers a HEAD request with the record's validators and no body,
+// without reading any values. With key "", it mirrors GetAll and succeeds
+// for users with no record; otherwise it is 404 when the key is not set.
+func (h *PreferencesHandler) head(w http.ResponseWriter, r *http.Request, userID, key string) {
+	rec, found, err := h.store.Stat(r.Context(), userID, key)
+	if err != nil {
+		h.logger.Error("store.Stat failed", "error", err, "userId", userID, "key", key)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if key != "" && !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if key == "" && h.unknownUserNotFound(w, r) && !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	setValidators(w, rec)
+	if notModified(r, rec) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
 
-	writeJSON(w, http.StatusOK, SinglePrefResponse{Key: key, Value: value})
+// precondition reads the write precondition from If-Match, writing the
+// error response when the request cannot proceed.
+func (h *PreferencesHandler) precondition(w http.ResponseWriter, r *http.Request) (Precondition, bool) {
+	cond, present, ok := parseIfMatch(r)
+	if !present && h.opts.RequireIfMatch {
+		writeError(w, http.StatusPreconditionRequired, "If-Match header required")
+		return Precondition{}, false
+	}
+	if !ok {
+		writeError(w, http.StatusPreconditionFailed, "preferences have been modified")
+		return Precondition{}, false
+	}
+	return cond, true
 }
 
-// ReplaceAll replaces all preferences for a user (PUT and POST).
+// ReplaceAll replaces all preferences for a user (PUT and POST), returning
+// an Undo-Token when undo is enabled (see Undo). It answers 201 with a
+// Location header when the user had no preferences item before.
 func (h *PreferencesHandler) ReplaceAll(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.authorize(w, r)
 	if !ok {
 		return
 	}
 
-	var prefs map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON body")
+	cond, ok := h.precondition(w, r)
+	if !ok {
 		return
 	}
 
-	if err := h.store.ReplaceAll(r.Context(), userID, prefs); err != nil {
+	var prefs map[string]any
+	if err := decodeJSON(r.Body, &prefs); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	h.dropUnknownKeys(prefs)
+	h.mirrorAliases(prefs, nil)
+	if !h.validatePrefs(w, prefs) || !h.checkQuota(w, r, userID, prefs, nil, true) {
+		return
+	}
+	h.warnDeprecated(w, slices.Collect(maps.Keys(prefs)))
+
+	var prev Record
+	if h.opts.Undo != nil {
+		var err error
+		if prev, err = h.store.GetAll(r.Context(), userID); err != nil {
+			h.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+			writeError(w, http.StatusInternalServerError, "failed to save preferences")
+			return
+		}
+	}
+
+	rec, err := h.store.ReplaceAll(r.Context(), userID, prefs, cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, http.StatusPreconditionFailed, "preferences have been modified")
+		return
+	}
+	if err != nil {
 		h.logger.Error("store.ReplaceAll failed", "error", err, "userId", userID)
 		writeError(w, http.StatusInternalServerError, "failed to save preferences")
 		return
 	}
+	if h.opts.Undo != nil {
+		h.stashUndo(w, r, userID, prev.Prefs, rec)
+	}
+	setValidators(w, rec)
+
+	status := http.StatusOK
+	if rec.Created {
+		w.Header().Set("Location", r.URL.Path)
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, newPreferencesResponse(userID, prefs, rec))
+}
+
+// SetOne sets a single preference by key, leaving the others unchanged.
+func (h *PreferencesHandler) SetOne(w http.ResponseWriter, r *http.Request) {
+	h.writeOne(w, r, false)
+}
+
+// CreateOne sets a single preference only if the key is not already set,
+// answering 409 otherwise, so clients can store a default without racing a
+// concurrent write.
+func (h *PreferencesHandler) CreateOne(w http.ResponseWriter, r *http.Request) {
+	h.writeOne(w, r, true)
+}
+
+func (h *PreferencesHandler) writeOne(w http.ResponseWriter, r *http.Request, create bool) {
+	userID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "missing key")
+		return
+	}
 
-	writeJSON(w, http.StatusOK, PreferencesResponse{
-		UserID:      userID,
-		Preferences: prefs,
-	})
+	cond, ok := h.precondition(w, r)
+	if !ok {
+		return
+	}
+	if create {
+		cond.Absent = []string{key}
+	}
+
+	var req SinglePrefRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if len(req.Value) == 0 {
+		writeError(w, http.StatusBadRequest, "missing value")
+		return
+	}
+	var value any
+	if err := decodeJSON(bytes.NewReader(req.Value), &value); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	prefs := map[string]any{key: value}
+	h.mirrorAliases(prefs, nil)
+	if !h.validatePrefs(w, prefs) || !h.checkQuota(w, r, userID, prefs, nil, false) {
+		return
+	}
+	h.warnDeprecated(w, []string{key})
+
+	rec, err := h.store.Update(r.Context(), userID, prefs, nil, cond)
+	if errors.Is(err, ErrConflict) {
+		writeErrorCode(w, http.StatusConflict, ErrCodePrefExists, "preference already exists")
+		return
+	}
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, http.StatusPreconditionFailed, "preferences have been modified")
+		return
+	}
+	if err != nil {
+		h.logger.Error("store.Update failed", "error", err, "userId", userID, "key", key)
+		writeError(w, http.StatusInternalServerError, "failed to save preference")
+		return
+	}
+	setValidators(w, rec)
+
+	if create {
+		w.Header().Set("Location", r.URL.Path)
+		writeJSON(w, http.StatusCreated, SinglePrefResponse{Key: key, Value: value})
+		return
+	}
+	writeSinglePref(w, key, value)
 }
 
-// PatchPrefs partially updates preferences (merge).
+// mergePatchType selects RFC 7386 JSON Merge Patch semantics for PATCH.
+const mergePatchType = "application/merge-patch+json"
+
+// PatchPrefs partially updates preferences (merge). With a merge patch
+// Content-Type, a null value deletes that key.
 func (h *PreferencesHandler) PatchPrefs(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.authorize(w, r)
 	if !ok {
 		return
 	}
 
-	var prefs map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON body")
+	cond, ok := h.precondition(w, r)
+	if !ok {
 		return
 	}
 
-	if len(prefs) == 0 {
+	var prefs map[string]any
+	var remove []string
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == mergePatchType {
+		var patch map[string]any
+		if err := decodeJSON(r.Body, &patch); err != nil {
+			writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid merge patch")
+			return
+		}
+		prefs = make(map[string]any, len(patch))
+		for k, v := range patch {
+			if v == nil {
+				remove = append(remove, k)
+			} else {
+				prefs[k] = v
+			}
+		}
+	} else if err := decodeJSON(r.Body, &prefs); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if !h.checkPatchSize(w, len(prefs)+len(remove)) {
+		return
+	}
+
+	h.dropUnknownKeys(prefs)
+	if len(prefs) == 0 && len(remove) == 0 {
 		writeError(w, http.StatusBadRequest, "empty preferences")
 		return
 	}
+	if prefs == nil {
+		prefs = make(map[string]any)
+	}
+	remove = h.mirrorAliases(prefs, remove)
+	if !h.validatePrefs(w, prefs) || !h.checkQuota(w, r, userID, prefs, remove, false) {
+		return
+	}
+	h.warnDeprecated(w, append(slices.Collect(maps.Keys(prefs)), remove...))
 
-	merged, err := h.store.Update(r.Context(), userID, prefs)
+	merged, err := h.store.Update(r.Context(), userID, prefs, remove, cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, http.StatusPreconditionFailed, "preferences have been modified")
+		return
+	}
 	if err != nil {
 		h.logger.Error("store.Update failed", "error", err, "userId", userID)
 		writeError(w, http.StatusInternalServerError, "failed to update preferences")
 		return
 	}
+	setValidators(w, merged)
 
-	writeJSON(w, http.StatusOK, PreferencesResponse{
-		UserID:      userID,
-		Preferences: merged,
-	})
+	writeJSON(w, http.StatusOK, newPreferencesResponse(userID, merged.Prefs, merged))
 }
 
-// DeleteAll removes all preferences for a user.
+// checkPatchSize rejects a PATCH of more than MaxPatchKeys keys with 422,
+// stating the limit.
+func (h *PreferencesHandler) checkPatchSize(w http.ResponseWriter, n int) bool {
+	if msg, v := h.patchSizeViolation(n); v != nil {
+		writeViolations(w, ErrCodeTooManyKeys, msg, v)
+		return false
+	}
+	return true
+}
+
+// patchSizeViolation returns the error message and violation for a patch
+// of n keys, or a nil violation when it is within MaxPatchKeys.
+func (h *PreferencesHandler) patchSizeViolation(n int) (string, []Violation) {
+	limit := h.opts.MaxPatchKeys
+	if limit <= 0 {
+		limit = defaultMaxPatchKeys
+	}
+	if n <= limit {
+		return "", nil
+	}
+	return fmt.Sprintf("PATCH may set or remove at most %d keys", limit), []Violation{{
+		Code:   ErrCodeTooManyKeys,
+		Reason: fmt.Sprintf("patch has %d keys; the limit is %d, so split it into several requests", n, limit),
+	}}
+}
+
+// DeleteAll removes all preferences for a user, keeping them restorable
+// when soft delete is enabled (see RestoreDeleted) and returning an
+// Undo-Token when undo is (see Undo). Given ?keys=a,b or a
+// DeleteKeysRequest body, it instead removes just those keys in one write and
+// returns the remaining map.
 func (h *PreferencesHandler) DeleteAll(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.authorize(w, r)
 	if !ok {
 		return
 	}
 
+	var keys []string
+	if r.URL.Query().Has("keys") {
+		keys = append([]string{}, splitList(r.URL.Query().Get("keys"))...)
+	} else {
+		var req DeleteKeysRequest
+		switch err := decodeJSON(r.Body, &req); {
+		case errors.Is(err, io.EOF):
+			// No body: delete everything.
+		case err != nil:
+			writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+			return
+		default:
+			keys = append([]string{}, req.Keys...)
+		}
+	}
+	if keys != nil {
+		h.deleteKeys(w, r, userID, keys)
+		return
+	}
+
+	var prev Record
+	if h.opts.Trash != nil || h.opts.Undo != nil {
+		var err error
+		if prev, err = h.store.GetAll(r.Context(), userID); err != nil {
+			h.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+			writeError(w, http.StatusInternalServerError, "failed to delete preferences")
+			return
+		}
+	}
+	if h.opts.Trash != nil {
+		if err := h.trash(r.Context(), userID, prev.Prefs); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to delete preferences")
+			return
+		}
+	}
 	if err := h.store.DeleteAll(r.Context(), userID); err != nil {
 		h.logger.Error("store.DeleteAll failed", "error", err, "userId", userID)
 		writeError(w, http.StatusInternalServerError, "failed to delete preferences")
 		return
 	}
+	// Deleting nothing leaves nothing to undo.
+	if h.opts.Undo != nil && prev.Prefs != nil {
+		h.stashUndo(w, r, userID, prev.Prefs, Record{})
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// DeleteOne removes a single preference by key.
+// deleteKeys removes keys from a user's map in one store write.
+func (h *PreferencesHandler) deleteKeys(w http.ResponseWriter, r *http.Request, userID string, keys []string) {
+	keys = slices.Compact(slices.Sorted(slices.Values(keys)))
+	if len(keys) == 0 || len(keys) > maxFilterKeys || slices.Contains(keys, "") {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("keys must list 1 to %d keys", maxFilterKeys))
+		return
+	}
+
+	cond, ok := h.precondition(w, r)
+	if !ok {
+		return
+	}
+	// Without If-Match, require a record so a user with none is not given an
+	// empty one.
+	internalCond := !cond.MustExist && len(cond.Versions) == 0
+	cond.MustExist = true
+
+	rec, err := h.store.Update(r.Context(), userID, nil, keys, cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		if internalCond {
+			writeJSON(w, http.StatusOK, newPreferencesResponse(userID, map[string]any{}, Record{}))
+			return
+		}
+		writeError(w, http.StatusPreconditionFailed, "preferences have been modified")
+		return
+	}
+	if err != nil {
+		h.logger.Error("store.Update failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to delete preferences")
+		return
+	}
+	setValidators(w, rec)
+
+	writeJSON(w, http.StatusOK, newPreferencesResponse(userID, rec.Prefs, rec))
+}
+
+// DeleteOne removes a single preference by key, honoring If-Match like the
+// other writes.
 func (h *PreferencesHandler) DeleteOne(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.authorize(w, r)
 	if !ok {
@@ -176,11 +776,40 @@ func (h *PreferencesHandler) DeleteOne(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.store.Delete(r.Context(), userID, key); err != nil {
+	cond, ok := h.precondition(w, r)
+	if !ok {
+		return
+	}
+	conditional := cond.MustExist || len(cond.Versions) > 0
+
+	var err error
+	remove := h.mirrorAliases(nil, []string{key})
+	if conditional || len(remove) > 1 {
+		// The alias and its replacement go in one write; without If-Match,
+		// a user with no record has nothing to delete.
+		if !conditional {
+			cond.MustExist = true
+		}
+		var rec Record
+		rec, err = h.store.Update(r.Context(), userID, nil, remove, cond)
+		switch {
+		case errors.Is(err, ErrPreconditionFailed) && conditional:
+			writeError(w, http.StatusPreconditionFailed, "preferences have been modified")
+			return
+		case errors.Is(err, ErrPreconditionFailed):
+			err = nil
+		case err == nil:
+			setValidators(w, rec)
+		}
+	} else {
+		err = h.store.Delete(r.Context(), userID, key)
+	}
+	if err != nil {
 		h.logger.Error("store.Delete failed", "error", err, "userId", userID, "key", key)
 		writeError(w, http.StatusInternalServerError, "failed to delete preference")
 		return
 	}
 
+	h.warnDeprecated(w, []string{key})
 	w.WriteHeader(http.StatusNoContent)
 }
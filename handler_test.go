@@ -5,78 +5,238 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // mockStore implements Store for testing.
 type mockStore struct {
-	prefs map[string]map[string]string // userID -> prefs
-	err   error
+	mu       sync.Mutex
+	prefs    map[string]map[string]any     // userID -> prefs
+	versions map[string]int64              // userID -> version; absent means 0
+	created  map[string]time.Time          // userID -> first write
+	updated  map[string]time.Time          // userID -> last write
+	meta     map[string]map[string]KeyMeta // userID -> key -> last write
+	gens     map[string]string             // userID -> generation
+	lastGen  int
+	err      error
 }
 
 func newMockStore() *mockStore {
-	return &mockStore{prefs: make(map[string]map[string]string)}
+	return &mockStore{
+		prefs:    make(map[string]map[string]any),
+		versions: make(map[string]int64),
+		created:  make(map[string]time.Time),
+		updated:  make(map[string]time.Time),
+		meta:     make(map[string]map[string]KeyMeta),
+		gens:     make(map[string]string),
+	}
+}
+
+func (m *mockStore) GetAll(_ context.Context, userID string) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return Record{}, m.err
+	}
+	return m.record(userID), nil
+}
+
+func (m *mockStore) Get(_ context.Context, userID, key string) (any, bool, Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, false, Record{}, m.err
+	}
+	v, ok := m.prefs[userID][key]
+	if !ok {
+		return nil, false, Record{}, nil
+	}
+	return v, true, Record{Version: m.versions[userID], Generation: m.gens[userID], UpdatedAt: m.updated[userID]}, nil
+}
+
+func (m *mockStore) record(userID string) Record {
+	return Record{Prefs: m.prefs[userID], Version: m.versions[userID], Generation: m.gens[userID], CreatedAt: m.created[userID], UpdatedAt: m.updated[userID], Meta: m.meta[userID]}
+}
+
+// touch records a write to the user's preferences, setting metadata for the
+// given keys.
+func (m *mockStore) touch(ctx context.Context, userID string, keys ...string) {
+	m.versions[userID]++
+	if m.gens[userID] == "" {
+		m.lastGen++
+		m.gens[userID] = fmt.Sprintf("g%d", m.lastGen)
+	}
+	m.updated[userID] = time.Now().UTC().Truncate(time.Second)
+	if _, ok := m.created[userID]; !ok {
+		m.created[userID] = m.updated[userID]
+	}
+	if m.meta[userID] == nil {
+		m.meta[userID] = make(map[string]KeyMeta)
+	}
+	for _, k := range keys {
+		m.meta[userID][k] = KeyMeta{UpdatedAt: m.updated[userID], UpdatedBy: writerFrom(ctx)}
+	}
+}
+
+func (m *mockStore) GetKeys(_ context.Context, userID string, keys []string) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return Record{}, m.err
+	}
+	rec := m.record(userID)
+	if rec.Prefs == nil {
+		return rec, nil
+	}
+	subset := make(map[string]any, len(keys))
+	for _, k := range keys {
+		if v, ok := rec.Prefs[k]; ok {
+			subset[k] = v
+		}
+	}
+	rec.Prefs = subset
+	return rec, nil
 }
 
-func (m *mockStore) GetAll(_ context.Context, userID string) (map[string]string, error) {
+func (m *mockStore) BatchGet(_ context.Context, userIDs []string) (map[string]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.err != nil {
 		return nil, m.err
 	}
-	return m.prefs[userID], nil
+	recs := make(map[string]Record)
+	for _, id := range userIDs {
+		if rec := m.record(id); rec.Prefs != nil {
+			recs[id] = rec
+		}
+	}
+	return recs, nil
 }
 
-func (m *mockStore) Get(_ context.Context, userID, key string) (string, bool, error) {
+func (m *mockStore) Stat(_ context.Context, userID, key string) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.err != nil {
-		return "", false, m.err
+		return Record{}, false, m.err
+	}
+	rec := m.record(userID)
+	if rec.Prefs == nil {
+		return Record{}, false, nil
+	}
+	found := key == ""
+	if !found {
+		_, found = rec.Prefs[key]
+	}
+	rec.Prefs, rec.Meta = map[string]any{}, nil
+	return rec, found, nil
+}
+
+// check returns the error a write under cond fails with, if any.
+func (m *mockStore) check(userID string, cond Precondition) error {
+	p, exists := m.prefs[userID]
+	for _, k := range cond.Absent {
+		if _, ok := p[k]; ok {
+			return ErrConflict
+		}
 	}
-	p := m.prefs[userID]
-	if p == nil {
-		return "", false, nil
+	if (cond.MustExist || len(cond.Versions) > 0) && !exists {
+		return ErrPreconditionFailed
 	}
-	v, ok := p[key]
-	return v, ok, nil
+	if len(cond.Versions) > 0 && !slices.Contains(cond.Versions, m.versions[userID]) {
+		return ErrPreconditionFailed
+	}
+	if len(cond.Versions) > 0 && cond.Generation != "" && cond.Generation != m.gens[userID] {
+		return ErrPreconditionFailed
+	}
+	if cond.MustNotExist && exists {
+		return ErrPreconditionFailed
+	}
+	return nil
 }
 
-func (m *mockStore) ReplaceAll(_ context.Context, userID string, prefs map[string]string) error {
+func (m *mockStore) ReplaceAll(ctx context.Context, userID string, prefs map[string]any, cond Precondition) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.err != nil {
-		return m.err
+		return Record{}, m.err
+	}
+	if err := m.check(userID, cond); err != nil {
+		return Record{}, err
 	}
+	if prefs == nil {
+		prefs = make(map[string]any)
+	}
+	_, existed := m.prefs[userID]
 	m.prefs[userID] = prefs
-	return nil
+	delete(m.meta, userID)
+	m.touch(ctx, userID, slices.Collect(maps.Keys(prefs))...)
+	rec := m.record(userID)
+	rec.Created = !existed
+	return rec, nil
 }
 
-func (m *mockStore) Update(_ context.Context, userID string, prefs map[string]string) (map[string]string, error) {
+func (m *mockStore) Update(ctx context.Context, userID string, prefs map[string]any, remove []string, cond Precondition) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.err != nil {
-		return nil, m.err
+		return Record{}, m.err
+	}
+	if err := m.check(userID, cond); err != nil {
+		return Record{}, err
 	}
 	existing := m.prefs[userID]
-	if existing == nil {
-		existing = make(map[string]string)
+	created := existing == nil
+	if created {
+		existing = make(map[string]any)
 	}
 	for k, v := range prefs {
 		existing[k] = v
 	}
+	for _, k := range remove {
+		delete(existing, k)
+		delete(m.meta[userID], k)
+	}
 	m.prefs[userID] = existing
-	return existing, nil
+	m.touch(ctx, userID, slices.Collect(maps.Keys(prefs))...)
+	rec := m.record(userID)
+	rec.Created = created
+	return rec, nil
 }
 
 func (m *mockStore) DeleteAll(_ context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.err != nil {
 		return m.err
 	}
 	delete(m.prefs, userID)
+	delete(m.versions, userID)
+	delete(m.created, userID)
+	delete(m.updated, userID)
+	delete(m.meta, userID)
+	delete(m.gens, userID)
 	return nil
 }
 
-func (m *mockStore) Delete(_ context.Context, userID, key string) error {
+func (m *mockStore) Delete(ctx context.Context, userID, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.err != nil {
 		return m.err
 	}
 	if p := m.prefs[userID]; p != nil {
 		delete(p, key)
+		delete(m.meta[userID], key)
+		m.touch(ctx, userID)
 	}
 	return nil
 }
@@ -93,7 +253,7 @@ func withClaims(r *http.Request, sub string) *http.Request {
 
 func TestGetAll_Empty(t *testing.T) {
 	store := newMockStore()
-	h := NewPreferencesHandler(store, testLogger())
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
@@ -118,9 +278,201 @@ func TestGetAll_Empty(t *testing.T) {
 	}
 }
 
+func TestGetAll_TruncatedPages(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{
+		"a": "0123456789",
+		"b": "0123456789",
+		"c": "0123456789",
+	}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{MaxResponseBytes: 80})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+
+	seen := make(map[string]any)
+	url := "/api/v1/users/user1/preferences"
+	for range 5 {
+		req := httptest.NewRequest("GET", url, nil)
+		req = withClaims(req, "user1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		var resp PreferencesResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		for k, v := range resp.Preferences {
+			seen[k] = v
+		}
+
+		if resp.NextCursor == "" {
+			if w.Code != http.StatusOK {
+				t.Fatalf("final page: expected 200, got %d", w.Code)
+			}
+			break
+		}
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("expected 206, got %d", w.Code)
+		}
+		if w.Header().Get(truncatedHeader) != "true" {
+			t.Fatal("expected truncation header on partial page")
+		}
+		if w.Body.Len() > 80 {
+			t.Fatalf("page exceeds limit: %d bytes", w.Body.Len())
+		}
+		url = "/api/v1/users/user1/preferences?cursor=" + resp.NextCursor
+	}
+
+	if len(seen) != 3 {
+		t.Fatalf("expected all 3 keys across pages, got %v", seen)
+	}
+}
+
+func TestGetAll_KeysFilter(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "lang": "en", "tz": "UTC"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences?keys=theme,%20lang,missing", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp PreferencesResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Preferences) != 2 || resp.Preferences["theme"] != "dark" || resp.Preferences["lang"] != "en" {
+		t.Fatalf("expected theme and lang only, got %v", resp.Preferences)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/users/user1/preferences?keys=", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty keys, got %d", w.Code)
+	}
+}
+
+func TestGetAll_PrefixFilter(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{
+		"notifications.email": "on",
+		"notifications.push":  "off",
+		"notificationsound":   "chime",
+		"theme":               "dark",
+	}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences?prefix=notifications.", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp PreferencesResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Preferences) != 2 || resp.Preferences["notifications.email"] != "on" || resp.Preferences["notifications.push"] != "off" {
+		t.Fatalf("expected notifications.* only, got %v", resp.Preferences)
+	}
+}
+
+func TestGetAll_Metadata(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"lang": "en"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences/{key}", h.SetOne)
+
+	req := httptest.NewRequest("PUT", "/api/v1/users/user1/preferences/theme", bytes.NewBufferString(`{"value":"dark"}`))
+	ctx := context.WithValue(req.Context(), claimsKey, Claims{Subject: "user1", Actor: "support-agent"})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req.WithContext(ctx))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: expected 200, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if strings.Contains(w.Body.String(), "metadata") {
+		t.Fatalf("expected no metadata by default, got %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/users/user1/preferences?include=metadata", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp PreferencesResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	theme := resp.Metadata["theme"]
+	if theme.Source != SourceUser || theme.LastUpdatedBy != "support-agent" || theme.UpdatedAt == nil {
+		t.Fatalf("unexpected theme metadata %+v", theme)
+	}
+	// Written before metadata was recorded.
+	if lang := resp.Metadata["lang"]; lang.Source != SourceUser || lang.UpdatedAt != nil || lang.LastUpdatedBy != "" {
+		t.Fatalf("unexpected lang metadata %+v", lang)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/users/user1/preferences?include=history", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown include, got %d", w.Code)
+	}
+}
+
+func TestGetAll_Timestamps(t *testing.T) {
+	store := newMockStore()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+	mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", h.PatchPrefs)
+
+	get := func() PreferencesResponse {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		var resp PreferencesResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	if resp := get(); resp.CreatedAt != nil || resp.UpdatedAt != nil {
+		t.Fatalf("expected no timestamps before the first write, got %+v", resp)
+	}
+
+	req := httptest.NewRequest("PATCH", "/api/v1/users/user1/preferences", bytes.NewBufferString(`{"theme":"dark"}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	var patched PreferencesResponse
+	json.NewDecoder(w.Body).Decode(&patched)
+	if patched.CreatedAt == nil || patched.UpdatedAt == nil {
+		t.Fatalf("expected timestamps in write response, got %s", w.Body.String())
+	}
+
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.created["user1"] = created
+	resp := get()
+	if resp.CreatedAt == nil || !resp.CreatedAt.Equal(created) || resp.UpdatedAt == nil || !resp.UpdatedAt.Equal(store.updated["user1"]) {
+		t.Fatalf("unexpected timestamps %v %v", resp.CreatedAt, resp.UpdatedAt)
+	}
+}
+
 func TestReplaceAllAndGetAll(t *testing.T) {
 	store := newMockStore()
-	h := NewPreferencesHandler(store, testLogger())
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", h.ReplaceAll)
@@ -133,8 +485,8 @@ func TestReplaceAllAndGetAll(t *testing.T) {
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
-	if w.Code != http.StatusOK {
-		t.Fatalf("PUT: expected 200, got %d", w.Code)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/api/v1/users/user1/preferences" {
+		t.Fatalf("PUT: expected 201 with Location, got %d %v", w.Code, w.Header())
 	}
 
 	// GET preferences
@@ -151,12 +503,20 @@ func TestReplaceAllAndGetAll(t *testing.T) {
 	if resp.Preferences["lang"] != "en" {
 		t.Fatalf("expected lang=en, got %s", resp.Preferences["lang"])
 	}
+
+	// Later replaces update the existing item.
+	req = httptest.NewRequest("PUT", "/api/v1/users/user1/preferences", bytes.NewBufferString(`{"theme":"light"}`))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Fatalf("PUT: expected 200 without Location, got %d %v", w.Code, w.Header())
+	}
 }
 
 func TestGetOne(t *testing.T) {
 	store := newMockStore()
-	store.prefs["user1"] = map[string]string{"theme": "dark"}
-	h := NewPreferencesHandler(store, testLogger())
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/{key}", h.GetOne)
@@ -177,9 +537,117 @@ func TestGetOne(t *testing.T) {
 	}
 }
 
+func TestSetOne(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "lang": "en"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences/{key}", h.SetOne)
+
+	req := httptest.NewRequest("PUT", "/api/v1/users/user1/preferences/theme", bytes.NewBufferString(`{"value":"light"}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp SinglePrefResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Key != "theme" || resp.Value != "light" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if store.prefs["user1"]["theme"] != "light" || store.prefs["user1"]["lang"] != "en" {
+		t.Fatalf("expected only theme to change, got %v", store.prefs["user1"])
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v1/users/user1/preferences/pinned", bytes.NewBufferString(`{"value":["inbox",3]}`))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"key":"pinned","value":["inbox",3]}` {
+		t.Fatalf("expected typed value echoed, got %d %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v1/users/user1/preferences/theme", bytes.NewBufferString(`{}`))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without value, got %d", w.Code)
+	}
+}
+
+func TestCreateOne(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/users/{userId}/preferences/{key}", h.CreateOne)
+
+	create := func(key, value string) *httptest.ResponseRecorder {
+		body := bytes.NewBufferString(`{"value":"` + value + `"}`)
+		req := httptest.NewRequest("POST", "/api/v1/users/user1/preferences/"+key, body)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	if w := create("lang", "en"); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	if w := create("theme", "light"); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for existing key, got %d", w.Code)
+	}
+	if got := store.prefs["user1"]; got["theme"] != "dark" || got["lang"] != "en" {
+		t.Fatalf("unexpected prefs %v", got)
+	}
+}
+
+func TestWriteSinglePref_MatchesEncodingJSON(t *testing.T) {
+	cases := [][2]string{
+		{"theme", "dark"},
+		{"quote\"back\\slash", "tab\tnew\nline\r"},
+		{"html", "<script>&</script>"},
+		{"ctrl", "\x00\x1f"},
+		{"unicode", "héllo \u2028 \u2029 😀"},
+		{"invalid", "\xff\xfe"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		writeSinglePref(w, c[0], c[1])
+
+		var want bytes.Buffer
+		json.NewEncoder(&want).Encode(SinglePrefResponse{Key: c[0], Value: c[1]})
+		if w.Body.String() != want.String() {
+			t.Errorf("writeSinglePref(%q, %q) = %s, want %s", c[0], c[1], w.Body.String(), want.String())
+		}
+	}
+}
+
+// BenchmarkGetOne exercises the GetOne handler end to end against the mock
+// store; run with -benchmem to watch allocations on the hot path.
+func BenchmarkGetOne(b *testing.B) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "lang": "en"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/{key}", h.GetOne)
+	req := withClaims(httptest.NewRequest("GET", "/api/v1/users/user1/preferences/theme", nil), "user1")
+
+	b.ReportAllocs()
+	for b.Loop() {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("expected 200, got %d", w.Code)
+		}
+	}
+}
+
 func TestGetOne_NotFound(t *testing.T) {
 	store := newMockStore()
-	h := NewPreferencesHandler(store, testLogger())
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/{key}", h.GetOne)
@@ -196,8 +664,8 @@ func TestGetOne_NotFound(t *testing.T) {
 
 func TestPatchPrefs(t *testing.T) {
 	store := newMockStore()
-	store.prefs["user1"] = map[string]string{"theme": "dark"}
-	h := NewPreferencesHandler(store, testLogger())
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", h.PatchPrefs)
@@ -222,10 +690,92 @@ func TestPatchPrefs(t *testing.T) {
 	}
 }
 
+func TestPatchPrefs_MergePatch(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "lang": "en"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", h.PatchPrefs)
+
+	body := bytes.NewBufferString(`{"lang":null,"tz":"UTC"}`)
+	req := httptest.NewRequest("PATCH", "/api/v1/users/user1/preferences", body)
+	req.Header.Set("Content-Type", "application/merge-patch+json; charset=utf-8")
+	req = withClaims(req, "user1")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var resp PreferencesResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if _, ok := resp.Preferences["lang"]; ok {
+		t.Fatalf("expected lang to be deleted, got %v", resp.Preferences)
+	}
+	if resp.Preferences["theme"] != "dark" || resp.Preferences["tz"] != "UTC" {
+		t.Fatalf("unexpected prefs after merge patch: %v", resp.Preferences)
+	}
+
+	// Typed values are stored as sent.
+	req = httptest.NewRequest("PATCH", "/api/v1/users/user1/preferences", bytes.NewBufferString(`{"font_size":14,"layout":{"sidebar":true}}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for typed values, got %d", w.Code)
+	}
+	if got := store.prefs["user1"]["font_size"]; got != json.Number("14") {
+		t.Fatalf("expected font_size stored as a number, got %#v", got)
+	}
+
+	// A merge patch must be an object.
+	req = httptest.NewRequest("PATCH", "/api/v1/users/user1/preferences", bytes.NewBufferString(`["theme"]`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for non-object patch, got %d", w.Code)
+	}
+}
+
+func TestPatchPrefs_TooManyKeys(t *testing.T) {
+	store := newMockStore()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{MaxPatchKeys: 2})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", h.PatchPrefs)
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/v1/users/user1/preferences", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	if w := patch(`{"a":1,"b":null}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 at the limit, got %d", w.Code)
+	}
+	// Removals count too.
+	w := patch(`{"a":1,"b":2,"c":null}`)
+	var apiErr APIError
+	json.NewDecoder(w.Body).Decode(&apiErr)
+	if w.Code != http.StatusUnprocessableEntity || apiErr.ErrorCode != ErrCodeTooManyKeys || !strings.Contains(apiErr.Error, "at most 2 keys") {
+		t.Fatalf("expected 422 stating the limit, got %d %+v", w.Code, apiErr)
+	}
+	if len(apiErr.Violations) != 1 || apiErr.Violations[0].Code != ErrCodeTooManyKeys {
+		t.Fatalf("unexpected violations %+v", apiErr.Violations)
+	}
+	if _, ok := store.prefs["user1"]["c"]; ok || len(store.prefs["user1"]) != 1 {
+		t.Fatalf("expected nothing written, got %v", store.prefs["user1"])
+	}
+}
+
 func TestDeleteAll(t *testing.T) {
 	store := newMockStore()
-	store.prefs["user1"] = map[string]string{"theme": "dark"}
-	h := NewPreferencesHandler(store, testLogger())
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences", h.DeleteAll)
@@ -244,10 +794,58 @@ func TestDeleteAll(t *testing.T) {
 	}
 }
 
+func TestDeleteAll_Keys(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "lang": "en", "tz": "UTC", "font": "mono"}
+	store.versions["user1"] = 1
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences", h.DeleteAll)
+
+	del := func(user, query, body string) (int, PreferencesResponse) {
+		var r io.Reader
+		if body != "" {
+			r = bytes.NewBufferString(body)
+		}
+		req := httptest.NewRequest("DELETE", "/api/v1/users/"+user+"/preferences"+query, r)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, user))
+		var resp PreferencesResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := del("user1", "?keys=theme,missing", "")
+	if code != http.StatusOK || len(resp.Preferences) != 3 || resp.Preferences["theme"] != nil {
+		t.Fatalf("unexpected response %d %+v", code, resp)
+	}
+	code, resp = del("user1", "", `{"keys":["lang","tz"]}`)
+	if code != http.StatusOK || len(resp.Preferences) != 1 || resp.Preferences["font"] != "mono" {
+		t.Fatalf("unexpected response %d %+v", code, resp)
+	}
+	if got := store.prefs["user1"]; len(got) != 1 || store.versions["user1"] != 3 {
+		t.Fatalf("expected one write per request, got %v v%d", got, store.versions["user1"])
+	}
+
+	if code, resp := del("user2", "?keys=theme", ""); code != http.StatusOK || len(resp.Preferences) != 0 {
+		t.Fatalf("expected an empty map for a user with no record, got %d %+v", code, resp)
+	}
+	if _, ok := store.prefs["user2"]; ok {
+		t.Fatal("expected no record to be created")
+	}
+
+	for _, bad := range []struct{ query, body string }{{"?keys=", ""}, {"", `{"keys":[]}`}, {"", `{"keys":`}} {
+		if code, _ := del("user1", bad.query, bad.body); code != http.StatusBadRequest {
+			t.Fatalf("%q %q: expected 400, got %d", bad.query, bad.body, code)
+		}
+	}
+}
+
 func TestDeleteOne(t *testing.T) {
 	store := newMockStore()
-	store.prefs["user1"] = map[string]string{"theme": "dark", "lang": "en"}
-	h := NewPreferencesHandler(store, testLogger())
+	store.prefs["user1"] = map[string]any{"theme": "dark", "lang": "en"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences/{key}", h.DeleteOne)
@@ -269,9 +867,48 @@ func TestDeleteOne(t *testing.T) {
 	}
 }
 
+func TestDeleteOne_IfMatch(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "lang": "en"}
+	store.versions["user1"] = 3
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{RequireIfMatch: true})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences/{key}", h.DeleteOne)
+
+	do := func(method, path, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	etag := do("GET", "/api/v1/users/user1/preferences", "").Header().Get("ETag")
+	if w := do("DELETE", "/api/v1/users/user1/preferences/theme", ""); w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("missing If-Match: expected 428, got %d", w.Code)
+	}
+	if w := do("DELETE", "/api/v1/users/user1/preferences/theme", `"2"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: expected 412, got %d", w.Code)
+	}
+	if _, exists := store.prefs["user1"]["theme"]; !exists {
+		t.Fatal("expected a rejected delete not to be applied")
+	}
+	w := do("DELETE", "/api/v1/users/user1/preferences/theme", etag)
+	if w.Code != http.StatusNoContent || w.Header().Get("ETag") == etag {
+		t.Fatalf("expected 204 with a new ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	if _, exists := store.prefs["user1"]["theme"]; exists {
+		t.Fatal("expected theme to be deleted")
+	}
+}
+
 func TestAuthorize_Forbidden(t *testing.T) {
 	store := newMockStore()
-	h := NewPreferencesHandler(store, testLogger())
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
@@ -286,10 +923,294 @@ func TestAuthorize_Forbidden(t *testing.T) {
 	}
 }
 
+func TestAuthorize_ConcealForbidden(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{ConcealForbidden: true})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/{key}", h.GetOne)
+
+	for _, path := range []string{"/api/v1/users/user1/preferences/theme", "/api/v1/users/nobody/preferences/theme"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req = withClaims(req, "other-user")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestETag_IfMatch(t *testing.T) {
+	store := newMockStore()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", h.ReplaceAll)
+	mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", h.PatchPrefs)
+
+	do := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/users/user1/preferences", bytes.NewBufferString(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	if w := do("GET", "", ""); w.Header().Get("ETag") != "" {
+		t.Fatalf("expected no ETag for a user without preferences, got %q", w.Header().Get("ETag"))
+	}
+	if w := do("PATCH", `{"theme":"dark"}`, "*"); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match * on missing user: expected 412, got %d", w.Code)
+	}
+
+	w := do("PUT", `{"theme":"dark"}`, "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusCreated || etag == "" {
+		t.Fatalf("expected 201 with ETag, got %d %q", w.Code, etag)
+	}
+	if got := do("GET", "", "").Header().Get("ETag"); got != etag {
+		t.Fatalf("expected GET ETag %q, got %q", etag, got)
+	}
+
+	// The first editor wins; the second, holding the same ETag, gets 412.
+	w = do("PATCH", `{"lang":"en"}`, etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("expected 200 with a new ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w := do("PUT", `{"theme":"light"}`, etag); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: expected 412, got %d", w.Code)
+	}
+	if w := do("PUT", `{"theme":"light"}`, "W/"+w.Header().Get("ETag")); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("weak If-Match: expected 412, got %d", w.Code)
+	}
+	if store.prefs["user1"]["theme"] != "dark" {
+		t.Fatalf("expected rejected writes not to be applied, got %v", store.prefs["user1"])
+	}
+	if w := do("PUT", `{"theme":"light"}`, `"999", `+w.Header().Get("ETag")); w.Code != http.StatusOK {
+		t.Fatalf("If-Match list: expected 200, got %d", w.Code)
+	}
+}
+
+func TestETag_IfMatchAfterRecreate(t *testing.T) {
+	store := newMockStore()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", h.ReplaceAll)
+	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences", h.DeleteAll)
+
+	do := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/users/user1/preferences", bytes.NewBufferString(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	stale := do("PUT", `{"theme":"dark"}`, "").Header().Get("ETag")
+	do("DELETE", "", "")
+	w := do("PUT", `{"lang":"en"}`, "")
+	if w.Code != http.StatusCreated || w.Header().Get("ETag") == stale {
+		t.Fatalf("expected a recreated item with a new ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w := do("PUT", `{"theme":"light"}`, stale); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match of the deleted item: expected 412, got %d", w.Code)
+	}
+	if store.prefs["user1"]["lang"] != "en" {
+		t.Fatalf("expected the recreated map kept, got %v", store.prefs["user1"])
+	}
+}
+
+func TestGetAll_ConditionalGet(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	store.versions["user1"] = 3
+	store.updated["user1"] = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	w := get("", "")
+	if got := w.Header().Get("Last-Modified"); got != "Sun, 01 Mar 2026 12:00:00 GMT" {
+		t.Fatalf("unexpected Last-Modified %q", got)
+	}
+
+	tests := []struct {
+		header, value string
+		want          int
+	}{
+		{"If-Modified-Since", "Sun, 01 Mar 2026 12:00:00 GMT", http.StatusNotModified},
+		{"If-Modified-Since", "Sun, 01 Mar 2026 11:59:59 GMT", http.StatusOK},
+		{"If-Modified-Since", "not a date", http.StatusOK},
+		{"If-None-Match", `"3"`, http.StatusNotModified},
+		{"If-None-Match", `W/"3"`, http.StatusNotModified},
+		{"If-None-Match", `"2"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := get(tt.header, tt.value)
+		if w.Code != tt.want {
+			t.Fatalf("%s: %s: expected %d, got %d", tt.header, tt.value, tt.want, w.Code)
+		}
+		if tt.want == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Fatalf("expected empty 304 body, got %q", w.Body.String())
+		}
+	}
+}
+
+func TestGetOne_ConditionalGet(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	store.versions["user1"] = 3
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/{key}", h.GetOne)
+
+	get := func(key, inm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences/"+key, nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	if w := get("theme", ""); w.Code != http.StatusOK || w.Header().Get("ETag") != `"3"` {
+		t.Fatalf("expected 200 with ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w := get("theme", `"3"`); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("expected empty 304, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get("theme", `"2"`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a stale tag, got %d", w.Code)
+	}
+	if w := get("lang", `"3"`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unset key, got %d", w.Code)
+	}
+}
+
+func TestHead(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	store.versions["user1"] = 3
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/{key}", h.GetOne)
+
+	head := func(user, path, inm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("HEAD", "/api/v1/users/"+user+"/preferences"+path, nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, user))
+		return w
+	}
+
+	tests := []struct {
+		user, path, inm string
+		want            int
+		etag            string
+	}{
+		{"user1", "", "", http.StatusOK, `"3"`},
+		{"user1", "/theme", "", http.StatusOK, `"3"`},
+		{"user1", "/theme", `"3"`, http.StatusNotModified, `"3"`},
+		{"user1", "/lang", "", http.StatusNotFound, ""},
+		{"user2", "", "", http.StatusOK, ""},
+		{"user2", "/theme", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := head(tt.user, tt.path, tt.inm)
+		if w.Code != tt.want || w.Header().Get("ETag") != tt.etag {
+			t.Fatalf("%s%s: expected %d with ETag %q, got %d with %q", tt.user, tt.path, tt.want, tt.etag, w.Code, w.Header().Get("ETag"))
+		}
+		if w.Body.Len() != 0 {
+			t.Fatalf("%s%s: expected empty body, got %q", tt.user, tt.path, w.Body.String())
+		}
+	}
+}
+
+func TestETag_RequireIfMatch(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{RequireIfMatch: true})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", h.ReplaceAll)
+
+	req := httptest.NewRequest("PUT", "/api/v1/users/user1/preferences", bytes.NewBufferString(`{"theme":"light"}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected 428, got %d", w.Code)
+	}
+
+	// Items written before versioning carry version 0.
+	req = httptest.NewRequest("PUT", "/api/v1/users/user1/preferences", bytes.NewBufferString(`{"theme":"light"}`))
+	req.Header.Set("If-Match", `"0"`)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+}
+
+func TestAuthorize_ServiceScope(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences", h.DeleteAll)
+
+	svc := Claims{Subject: "notifier", Kind: PrincipalService, Scopes: []string{ScopeRead}}
+
+	// Read scope allows reading another user's preferences.
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req = req.WithContext(context.WithValue(req.Context(), claimsKey, svc))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET: expected 200, got %d", w.Code)
+	}
+
+	// Without the write scope, mutations are forbidden.
+	req = httptest.NewRequest("DELETE", "/api/v1/users/user1/preferences", nil)
+	req = req.WithContext(context.WithValue(req.Context(), claimsKey, svc))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE: expected 403, got %d", w.Code)
+	}
+}
+
 func TestStoreError(t *testing.T) {
 	store := newMockStore()
 	store.err = fmt.Errorf("database unavailable")
-	h := NewPreferencesHandler(store, testLogger())
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
@@ -306,7 +1227,7 @@ func TestStoreError(t *testing.T) {
 
 func TestReplaceAll_InvalidJSON(t *testing.T) {
 	store := newMockStore()
-	h := NewPreferencesHandler(store, testLogger())
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", h.ReplaceAll)
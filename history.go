@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// History operations.
+const (
+	HistoryReplace   = "replace"
+	HistoryUpdate    = "update"
+	HistoryDelete    = "delete"
+	HistoryDeleteAll = "deleteAll"
+)
+
+// HistoryEntry records one mutation of a user's preferences. Before and After
+// hold only the keys the write changed: a key missing from Before was added,
+// one missing from After was removed. Snapshot is the full map after the
+// write; it is stored but not returned by the history API.
+type HistoryEntry struct {
+	ID       string         `json:"id"`
+	Version  int64          `json:"version"`
+	Op       string         `json:"op"`
+	At       time.Time      `json:"at"`
+	By       string         `json:"by,omitempty"`
+	Before   map[string]any `json:"before"`
+	After    map[string]any `json:"after"`
+	Snapshot map[string]any `json:"-"`
+}
+
+// HistoryStore persists preference history.
+type HistoryStore interface {
+	AppendHistory(ctx context.Context, userID string, e HistoryEntry) error
+	// ListHistory returns up to limit entries, newest first, starting after
+	// the entry with ID before ("" for the newest).
+	ListHistory(ctx context.Context, userID string, limit int, before string) ([]HistoryEntry, error)
+	// ListHistorySince returns up to limit entries written after the entry
+	// with ID after, oldest first.
+	ListHistorySince(ctx context.Context, userID string, after string, limit int) ([]HistoryEntry, error)
+	// GetHistory returns one entry, or ErrNotFound if it does not exist or
+	// has expired.
+	GetHistory(ctx context.Context, userID string, id string) (HistoryEntry, error)
+	// DeleteHistory removes all of a user's entries and returns how many
+	// there were.
+	DeleteHistory(ctx context.Context, userID string) (int, error)
+}
+
+// newHistoryID returns a time-ordered entry ID. IDs sort in write order for
+// a user and stay unique when a DeleteAll resets the record version.
+func newHistoryID(at time.Time) string {
+	return fmt.Sprintf("%020d", at.UnixNano())
+}
+
+// HistoryRecorder is a Store decorator that appends a HistoryEntry for every
+// successful write. It reads the affected keys before writing, so entries
+// are accurate unless two writes to the same user race. A failure to record
+// history fails the call even though the write has been applied: delta sync
+// reads history, so an unrecorded write would never reach offline clients,
+// and the caller must know to retry. Excluded keys (the sensitive ones) are
+// never recorded.
+type HistoryRecorder struct {
+	next    Store
+	history HistoryStore
+	exclude []string
+}
+
+// NewHistoryRecorder wraps next, recording its writes to history.
+func NewHistoryRecorder(next Store, history HistoryStore, exclude []string) *HistoryRecorder {
+	return &HistoryRecorder{next: next, history: history, exclude: exclude}
+}
+
+func (s *HistoryRecorder) GetAll(ctx context.Context, userID string) (Record, error) {
+	return s.next.GetAll(ctx, userID)
+}
+
+func (s *HistoryRecorder) Get(ctx context.Context, userID string, key string) (any, bool, Record, error) {
+	return s.next.Get(ctx, userID, key)
+}
+
+func (s *HistoryRecorder) GetKeys(ctx context.Context, userID string, keys []string) (Record, error) {
+	return s.next.GetKeys(ctx, userID, keys)
+}
+
+func (s *HistoryRecorder) Stat(ctx context.Context, userID string, key string) (Record, bool, error) {
+	return s.next.Stat(ctx, userID, key)
+}
+
+func (s *HistoryRecorder) BatchGet(ctx context.Context, userIDs []string) (map[string]Record, error) {
+	return s.next.BatchGet(ctx, userIDs)
+}
+
+func (s *HistoryRecorder) ReplaceAll(ctx context.Context, userID string, prefs map[string]any, cond Precondition) (Record, error) {
+	before, err := s.next.GetAll(ctx, userID)
+	if err != nil {
+		return Record{}, err
+	}
+	rec, err := s.next.ReplaceAll(ctx, userID, prefs, cond)
+	if err != nil {
+		return Record{}, err
+	}
+	if err := s.record(ctx, userID, HistoryReplace, before.Prefs, rec.Prefs, rec.Version); err != nil {
+		return Record{}, err
+	}
+	return rec, nil
+}
+
+func (s *HistoryRecorder) Update(ctx context.Context, userID string, prefs map[string]any, remove []string, cond Precondition) (Record, error) {
+	keys := append(slices.Collect(maps.Keys(prefs)), remove...)
+	slices.Sort(keys)
+	before, err := s.next.GetKeys(ctx, userID, slices.Compact(keys))
+	if err != nil {
+		return Record{}, err
+	}
+	rec, err := s.next.Update(ctx, userID, prefs, remove, cond)
+	if err != nil {
+		return Record{}, err
+	}
+	// Keys the write did not touch are the same on both sides.
+	old := maps.Clone(rec.Prefs)
+	for _, k := range keys {
+		delete(old, k)
+	}
+	maps.Copy(old, before.Prefs)
+	if err := s.record(ctx, userID, HistoryUpdate, old, rec.Prefs, rec.Version); err != nil {
+		return Record{}, err
+	}
+	return rec, nil
+}
+
+func (s *HistoryRecorder) DeleteAll(ctx context.Context, userID string) error {
+	before, err := s.next.GetAll(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.next.DeleteAll(ctx, userID); err != nil {
+		return err
+	}
+	return s.record(ctx, userID, HistoryDeleteAll, before.Prefs, nil, 0)
+}
+
+func (s *HistoryRecorder) Delete(ctx context.Context, userID string, key string) error {
+	before, err := s.next.GetAll(ctx, userID)
+	if err != nil {
+		return err
+	}
+	old := maps.Clone(before.Prefs)
+	if err := s.next.Delete(ctx, userID, key); err != nil {
+		return err
+	}
+	after := maps.Clone(old)
+	delete(after, key)
+	return s.record(ctx, userID, HistoryDelete, old, after, before.Version+1)
+}
+
+// record appends the entry for a write that took the map from old to cur.
+// Writes that changed nothing are not recorded.
+func (s *HistoryRecorder) record(ctx context.Context, userID, op string, old, cur map[string]any, version int64) error {
+	old, cur = s.strip(old), s.strip(cur)
+	before, after := changes(old, cur)
+	if len(before) == 0 && len(after) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	e := HistoryEntry{
+		ID:       newHistoryID(now),
+		Version:  version,
+		Op:       op,
+		At:       now,
+		By:       writerFrom(ctx),
+		Before:   before,
+		After:    after,
+		Snapshot: maps.Clone(cur),
+	}
+	if e.Snapshot == nil {
+		e.Snapshot = map[string]any{}
+	}
+	// The request may already be finished; the entry should still land.
+	if err := s.history.AppendHistory(context.WithoutCancel(ctx), userID, e); err != nil {
+		return fmt.Errorf("recording %s history: %w", op, err)
+	}
+	return nil
+}
+
+// strip returns prefs without the excluded keys.
+func (s *HistoryRecorder) strip(prefs map[string]any) map[string]any {
+	if prefs == nil || len(s.exclude) == 0 {
+		return prefs
+	}
+	out := maps.Clone(prefs)
+	for _, k := range s.exclude {
+		delete(out, k)
+	}
+	return out
+}
+
+// changes returns the entries of old and cur for the keys whose values
+// differ between them.
+func changes(old, cur map[string]any) (before, after map[string]any) {
+	before, after = map[string]any{}, map[string]any{}
+	for k, v := range old {
+		if nv, ok := cur[k]; !ok || !reflect.DeepEqual(v, nv) {
+			before[k] = v
+		}
+	}
+	for k, v := range cur {
+		if ov, ok := old[k]; !ok || !reflect.DeepEqual(v, ov) {
+			after[k] = v
+		}
+	}
+	return before, after
+}
+
+// defaultHistoryLimit and maxHistoryLimit bound ?limit= on history listings.
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// HistoryResponse is a page of history entries, newest first.
+type HistoryResponse struct {
+	UserID     string         `json:"userId"`
+	Entries    []HistoryEntry `json:"entries"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// HistoryHandler serves preference change history to users and admins.
+type HistoryHandler struct {
+	prefs *PreferencesHandler
+	store HistoryStore
+	// retention is how long entries are kept; delta syncs from further
+	// back cannot be served. Zero means forever.
+	retention time.Duration
+}
+
+// NewHistoryHandler creates a handler that reuses the preferences handler's
+// authorization and logger.
+func NewHistoryHandler(prefs *PreferencesHandler, store HistoryStore, retention time.Duration) *HistoryHandler {
+	return &HistoryHandler{prefs: prefs, store: store, retention: retention}
+}
+
+// List returns a page of the user's history, with ?limit= and ?cursor=.
+func (h *HistoryHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	h.list(w, r, userID)
+}
+
+// AdminList returns any user's history, for support.
+func (h *HistoryHandler) AdminList(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, r.PathValue("userId"))
+}
+
+func (h *HistoryHandler) list(w http.ResponseWriter, r *http.Request, userID string) {
+	limit := defaultHistoryLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxHistoryLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be 1 to %d", maxHistoryLimit))
+			return
+		}
+		limit = n
+	}
+
+	var before string
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		var err error
+		if before, err = decodeCursor(cursor); err != nil {
+			writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidCursor, "invalid cursor")
+			return
+		}
+	}
+
+	// One extra entry tells whether there is another page.
+	entries, err := h.store.ListHistory(r.Context(), userID, limit+1, before)
+	if err != nil {
+		h.prefs.logger.Error("history.ListHistory failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve history")
+		return
+	}
+	resp := HistoryResponse{UserID: userID, Entries: entries}
+	if len(entries) > limit {
+		resp.Entries = entries[:limit]
+		resp.NextCursor = encodeCursor(resp.Entries[limit-1].ID)
+	}
+	if resp.Entries == nil {
+		resp.Entries = []HistoryEntry{}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Restore replaces the user's preferences with the snapshot recorded by a
+// history entry: POST .../preferences/versions/{id}:restore. Sensitive keys
+// are not in snapshots and keep their current values. The restored map is
+// validated against the current key rules, as for PUT; the write honors
+// If-Match and is itself recorded in history.
+func (h *HistoryHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	id, ok := strings.CutSuffix(r.PathValue("version"), ":restore")
+	if !ok || id == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	cond, ok := h.prefs.precondition(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := h.store.GetHistory(r.Context(), userID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "version not found")
+		return
+	}
+	if err != nil {
+		h.prefs.logger.Error("history.GetHistory failed", "error", err, "userId", userID, "version", id)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve version")
+		return
+	}
+
+	prefs := maps.Clone(entry.Snapshot)
+	if prefs == nil {
+		prefs = map[string]any{}
+	}
+	internalCond := false
+	if sensitive := h.prefs.opts.SensitiveKeys; len(sensitive) > 0 {
+		cur, err := h.prefs.store.GetKeys(r.Context(), userID, sensitive)
+		if err != nil {
+			h.prefs.logger.Error("store.GetKeys failed", "error", err, "userId", userID)
+			writeError(w, http.StatusInternalServerError, "failed to restore preferences")
+			return
+		}
+		maps.Copy(prefs, cur.Prefs)
+		// Keep the carried-over values from being lost to a concurrent write.
+		if cur.Prefs != nil && !cond.MustExist && len(cond.Versions) == 0 {
+			cond.Versions, cond.Generation = []int64{cur.Version}, cur.Generation
+			internalCond = true
+		}
+	}
+
+	// The snapshot predates the current key rules, so it is normalized and
+	// validated like a PUT of the map.
+	h.prefs.dropUnknownKeys(prefs)
+	h.prefs.mirrorAliases(prefs, nil)
+	if !h.prefs.validatePrefs(w, prefs) || !h.prefs.checkQuota(w, r, userID, prefs, nil, true) {
+		return
+	}
+	h.prefs.warnDeprecated(w, slices.Collect(maps.Keys(prefs)))
+
+	rec, err := h.prefs.store.ReplaceAll(r.Context(), userID, prefs, cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		if internalCond {
+			writeError(w, http.StatusConflict, "preferences changed during restore; retry")
+			return
+		}
+		writeError(w, http.StatusPreconditionFailed, "preferences have been modified")
+		return
+	}
+	if err != nil {
+		h.prefs.logger.Error("store.ReplaceAll failed", "error", err, "userId", userID, "version", id)
+		writeError(w, http.StatusInternalServerError, "failed to restore preferences")
+		return
+	}
+	h.prefs.logger.Info("preferences restored", "userId", userID, "version", id)
+	setValidators(w, rec)
+
+	writeJSON(w, http.StatusOK, newPreferencesResponse(userID, rec.Prefs, rec))
+}
+
+// currentVersion names the live preferences in a diff.
+const currentVersion = "current"
+
+// ValueChange is a key whose value differs between two versions.
+type ValueChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// DiffResponse lists what changed from version From to version To.
+type DiffResponse struct {
+	UserID  string                 `json:"userId"`
+	From    string                 `json:"from"`
+	To      string                 `json:"to"`
+	Added   map[string]any         `json:"added"`
+	Removed map[string]any         `json:"removed"`
+	Changed map[string]ValueChange `json:"changed"`
+}
+
+// Diff compares the snapshots of two history entries:
+// GET .../preferences/versions/{a}/diff/{b}. Either side may be "current"
+// for the live preferences. Sensitive keys are left out.
+func (h *HistoryHandler) Diff(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	h.diff(w, r, userID)
+}
+
+// AdminDiff is Diff for any user, for support.
+func (h *HistoryHandler) AdminDiff(w http.ResponseWriter, r *http.Request) {
+	h.diff(w, r, r.PathValue("userId"))
+}
+
+func (h *HistoryHandler) diff(w http.ResponseWriter, r *http.Request, userID string) {
+	from, to := r.PathValue("a"), r.PathValue("b")
+	a, ok := h.snapshot(w, r, userID, from)
+	if !ok {
+		return
+	}
+	b, ok := h.snapshot(w, r, userID, to)
+	if !ok {
+		return
+	}
+
+	resp := DiffResponse{
+		UserID:  userID,
+		From:    from,
+		To:      to,
+		Added:   map[string]any{},
+		Removed: map[string]any{},
+		Changed: map[string]ValueChange{},
+	}
+	before, after := changes(a, b)
+	for k, v := range after {
+		if old, ok := before[k]; ok {
+			resp.Changed[k] = ValueChange{From: old, To: v}
+		} else {
+			resp.Added[k] = v
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			resp.Removed[k] = v
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// snapshot returns the preference map at version id, writing the error
+// response if it cannot.
+func (h *HistoryHandler) snapshot(w http.ResponseWriter, r *http.Request, userID, id string) (map[string]any, bool) {
+	if id == currentVersion {
+		rec, err := h.prefs.store.GetAll(r.Context(), userID)
+		if err != nil {
+			h.prefs.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+			writeError(w, http.StatusInternalServerError, "failed to retrieve preferences")
+			return nil, false
+		}
+		prefs := maps.Clone(rec.Prefs)
+		for _, k := range h.prefs.opts.SensitiveKeys {
+			delete(prefs, k)
+		}
+		return prefs, true
+	}
+
+	entry, err := h.store.GetHistory(r.Context(), userID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("version %s not found", id))
+		return nil, false
+	}
+	if err != nil {
+		h.prefs.logger.Error("history.GetHistory failed", "error", err, "userId", userID, "version", id)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve version")
+		return nil, false
+	}
+	return entry.Snapshot, true
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// memHistory implements HistoryStore for testing.
+type memHistory struct {
+	mu      sync.Mutex
+	entries map[string][]HistoryEntry // userID -> entries, oldest first
+}
+
+func newMemHistory() *memHistory {
+	return &memHistory{entries: make(map[string][]HistoryEntry)}
+}
+
+func (m *memHistory) AppendHistory(_ context.Context, userID string, e HistoryEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Entries written in the same clock tick would share an ID.
+	if n := len(m.entries[userID]); n > 0 && e.ID <= m.entries[userID][n-1].ID {
+		e.ID = m.entries[userID][n-1].ID + "+"
+	}
+	m.entries[userID] = append(m.entries[userID], e)
+	return nil
+}
+
+func (m *memHistory) ListHistory(_ context.Context, userID string, limit int, before string) ([]HistoryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []HistoryEntry
+	for _, e := range slices.Backward(m.entries[userID]) {
+		if before != "" && e.ID >= before {
+			continue
+		}
+		if len(out) == limit {
+			break
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func (m *memHistory) ListHistorySince(_ context.Context, userID string, after string, limit int) ([]HistoryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []HistoryEntry
+	for _, e := range m.entries[userID] {
+		if e.ID > after && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (m *memHistory) GetHistory(_ context.Context, userID string, id string) (HistoryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries[userID] {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return HistoryEntry{}, ErrNotFound
+}
+
+func (m *memHistory) DeleteHistory(_ context.Context, userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := len(m.entries[userID])
+	delete(m.entries, userID)
+	return n, nil
+}
+
+func TestHistoryRecorder(t *testing.T) {
+	inner, hist := newMockStore(), newMemHistory()
+	s := NewHistoryRecorder(inner, hist, []string{"secret"})
+	ctx := context.WithValue(context.Background(), claimsKey, Claims{Subject: "user1"})
+
+	s.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark", "lang": "en", "secret": "x"}, Precondition{})
+	s.Update(ctx, "user1", map[string]any{"theme": "light", "lang": "en"}, []string{"missing"}, Precondition{})
+	s.Delete(ctx, "user1", "lang")
+	s.Delete(ctx, "user1", "lang") // no change, not recorded
+	s.DeleteAll(ctx, "user1")
+
+	got := hist.entries["user1"]
+	ops := make([]string, len(got))
+	for i, e := range got {
+		ops[i] = e.Op
+	}
+	if !slices.Equal(ops, []string{HistoryReplace, HistoryUpdate, HistoryDelete, HistoryDeleteAll}) {
+		t.Fatalf("unexpected ops %v", ops)
+	}
+
+	replace := got[0]
+	if len(replace.Before) != 0 || len(replace.After) != 2 || replace.After["theme"] != "dark" || replace.By != "user1" || replace.Version != 1 {
+		t.Fatalf("unexpected replace entry %+v", replace)
+	}
+	if _, ok := replace.Snapshot["secret"]; ok {
+		t.Fatal("expected excluded key not to be recorded")
+	}
+
+	update := got[1]
+	if update.Before["theme"] != "dark" || update.After["theme"] != "light" || len(update.Before) != 1 || len(update.After) != 1 {
+		t.Fatalf("expected only theme in update entry, got %+v", update)
+	}
+	if update.Snapshot["lang"] != "en" || update.Snapshot["theme"] != "light" {
+		t.Fatalf("unexpected update snapshot %v", update.Snapshot)
+	}
+
+	del := got[2]
+	if del.Before["lang"] != "en" || len(del.After) != 0 || del.Version != 3 {
+		t.Fatalf("unexpected delete entry %+v", del)
+	}
+
+	if all := got[3]; all.Before["theme"] != "light" || len(all.Snapshot) != 0 {
+		t.Fatalf("unexpected deleteAll entry %+v", all)
+	}
+}
+
+// failingHistory is a HistoryStore whose appends fail.
+type failingHistory struct{ *memHistory }
+
+func (failingHistory) AppendHistory(context.Context, string, HistoryEntry) error {
+	return errors.New("history unavailable")
+}
+
+func TestHistoryRecorder_AppendFails(t *testing.T) {
+	s := NewHistoryRecorder(newMockStore(), failingHistory{newMemHistory()}, nil)
+	ctx := context.Background()
+
+	if _, err := s.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark"}, Precondition{}); err == nil {
+		t.Fatal("expected ReplaceAll to fail when history cannot be recorded")
+	}
+	if _, err := s.Update(ctx, "user1", map[string]any{"theme": "light"}, nil, Precondition{}); err == nil {
+		t.Fatal("expected Update to fail when history cannot be recorded")
+	}
+	if err := s.Delete(ctx, "user1", "theme"); err == nil {
+		t.Fatal("expected Delete to fail when history cannot be recorded")
+	}
+	// A write that changes nothing records nothing, so cannot fail to.
+	if err := s.DeleteAll(ctx, "user1"); err != nil {
+		t.Fatalf("expected a no-op DeleteAll to succeed, got %v", err)
+	}
+}
+
+func TestHistoryHandler_List(t *testing.T) {
+	hist := newMemHistory()
+	for _, id := range []string{"1", "2", "3"} {
+		hist.AppendHistory(context.Background(), "user1", HistoryEntry{ID: id, Op: HistoryUpdate})
+	}
+	h := NewHistoryHandler(NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{}), hist, 0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/history", h.List)
+
+	list := func(query string) (int, HistoryResponse) {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences/history"+query, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		var resp HistoryResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, page := list("?limit=2")
+	if code != http.StatusOK || len(page.Entries) != 2 || page.Entries[0].ID != "3" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %d %+v", code, page)
+	}
+	code, page = list("?limit=2&cursor=" + page.NextCursor)
+	if code != http.StatusOK || len(page.Entries) != 1 || page.Entries[0].ID != "1" || page.NextCursor != "" {
+		t.Fatalf("unexpected last page %d %+v", code, page)
+	}
+
+	for _, bad := range []string{"?limit=0", "?limit=101", "?cursor=!!!"} {
+		if code, _ := list(bad); code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", bad, code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/users/user2/preferences/history", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another user's history, got %d", w.Code)
+	}
+}
+
+func TestHistoryHandler_Restore(t *testing.T) {
+	inner, hist := newMockStore(), newMemHistory()
+	store := NewHistoryRecorder(inner, hist, []string{"secret"})
+	prefs := NewPreferencesHandler(store, testLogger(), HandlerOptions{SensitiveKeys: []string{"secret"}})
+	h := NewHistoryHandler(prefs, hist, 0)
+
+	ctx := context.WithValue(context.Background(), claimsKey, Claims{Subject: "user1"})
+	store.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark", "secret": "old"}, Precondition{})
+	store.ReplaceAll(ctx, "user1", map[string]any{"theme": "light", "lang": "fr", "secret": "new"}, Precondition{})
+	first := hist.entries["user1"][0].ID
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/users/{userId}/preferences/versions/{version}", h.Restore)
+	restore := func(version, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/users/user1/preferences/versions/"+version, nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	if w := restore(first+":restore", `"1"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for a stale If-Match, got %d", w.Code)
+	}
+
+	w := restore(first+":restore", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	got := inner.prefs["user1"]
+	if len(got) != 2 || got["theme"] != "dark" || got["secret"] != "new" {
+		t.Fatalf("expected snapshot restored with current secret, got %v", got)
+	}
+	if w.Header().Get("ETag") != `"3.g1"` {
+		t.Fatalf("expected ETag of the restored record, got %q", w.Header().Get("ETag"))
+	}
+	if n := len(hist.entries["user1"]); n != 3 {
+		t.Fatalf("expected the restore to be recorded, got %d entries", n)
+	}
+
+	if w := restore("nope:restore", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown version, got %d", w.Code)
+	}
+	if w := restore(first, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without :restore, got %d", w.Code)
+	}
+}
+
+func TestHistoryHandler_RestoreValidates(t *testing.T) {
+	inner, hist := newMockStore(), newMemHistory()
+	store := NewHistoryRecorder(inner, hist, nil)
+	store.ReplaceAll(context.Background(), "user1", map[string]any{"theme": "dark", "beta": "on"}, Precondition{})
+	store.ReplaceAll(context.Background(), "user1", map[string]any{"theme": "light"}, Precondition{})
+	first := hist.entries["user1"][0].ID
+
+	restore := func(opts HandlerOptions) *httptest.ResponseRecorder {
+		h := NewHistoryHandler(NewPreferencesHandler(store, testLogger(), opts), hist, 0)
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /api/v1/users/{userId}/preferences/versions/{version}", h.Restore)
+		req := httptest.NewRequest("POST", "/api/v1/users/user1/preferences/versions/"+first+":restore", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	// The key "beta" has since been taken off the allowed list.
+	if w := restore(HandlerOptions{AllowedKeys: []string{"theme"}}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a key no longer allowed, got %d", w.Code)
+	}
+	if got := inner.prefs["user1"]; len(got) != 1 || got["theme"] != "light" {
+		t.Fatalf("expected a rejected restore not to be applied, got %v", got)
+	}
+	if w := restore(HandlerOptions{AllowedKeys: []string{"theme"}, DropUnknownKeys: true}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 when unknown keys are dropped, got %d", w.Code)
+	}
+	if got := inner.prefs["user1"]; len(got) != 1 || got["theme"] != "dark" {
+		t.Fatalf("expected the snapshot restored without the dropped key, got %v", got)
+	}
+}
+
+func TestHistoryHandler_Diff(t *testing.T) {
+	inner, hist := newMockStore(), newMemHistory()
+	store := NewHistoryRecorder(inner, hist, nil)
+	h := NewHistoryHandler(NewPreferencesHandler(store, testLogger(), HandlerOptions{SensitiveKeys: []string{"secret"}}), hist, 0)
+
+	ctx := context.Background()
+	store.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark", "lang": "en"}, Precondition{})
+	store.ReplaceAll(ctx, "user1", map[string]any{"theme": "light", "tz": "UTC"}, Precondition{})
+	store.Update(ctx, "user1", map[string]any{"secret": "x", "font": json.Number("12")}, nil, Precondition{})
+	a, b := hist.entries["user1"][0].ID, hist.entries["user1"][1].ID
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/versions/{a}/diff/{b}", h.Diff)
+	diff := func(from, to string) (int, DiffResponse) {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences/versions/"+from+"/diff/"+to, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		var resp DiffResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := diff(a, b)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if resp.Added["tz"] != "UTC" || resp.Removed["lang"] != "en" || len(resp.Added) != 1 || len(resp.Removed) != 1 {
+		t.Fatalf("unexpected added/removed %+v", resp)
+	}
+	if c := resp.Changed["theme"]; c.From != "dark" || c.To != "light" || len(resp.Changed) != 1 {
+		t.Fatalf("unexpected changed %+v", resp.Changed)
+	}
+
+	// The live map, minus sensitive keys.
+	_, resp = diff(b, currentVersion)
+	if len(resp.Added) != 1 || resp.Added["font"] != 12.0 {
+		t.Fatalf("expected only font added against current, got %+v", resp)
+	}
+
+	if code, _ := diff(a, "nope"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown version, got %d", code)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// IdempotentResult is the stored outcome of a write sent with an
+// Idempotency-Key. Done is false while the first request is still running.
+// UserID is the user whose preferences the write was to, since Body holds
+// their values.
+type IdempotentResult struct {
+	Fingerprint string
+	Done        bool
+	Status      int
+	Header      http.Header
+	Body        []byte
+	UserID      string
+}
+
+// IdempotencyStore persists the results of idempotent writes. Keys are
+// already scoped to the caller.
+type IdempotencyStore interface {
+	// ClaimIdempotencyKey reserves key for a request with the given
+	// fingerprint until lockUntil. If key is already held, it returns the
+	// existing result and claimed is false.
+	ClaimIdempotencyKey(ctx context.Context, key, fingerprint string, lockUntil time.Time) (prev IdempotentResult, claimed bool, err error)
+	// CompleteIdempotencyKey records the result of the claiming request,
+	// kept until expires.
+	CompleteIdempotencyKey(ctx context.Context, key string, res IdempotentResult, expires time.Time) error
+	// ReleaseIdempotencyKey drops a claim so the request can be retried.
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// IdempotencyOptions configures Idempotency-Key handling.
+type IdempotencyOptions struct {
+	Store IdempotencyStore
+	// TTL is how long a completed result is replayed.
+	TTL time.Duration
+	// SensitiveKeys are redacted from stored responses, as everywhere
+	// outside the preferences store; replays show the placeholder.
+	SensitiveKeys []string
+	Logger        *slog.Logger
+}
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	replayedHeader       = "Idempotent-Replayed"
+	maxIdempotencyKeyLen = 255
+	// idempotencyLockTimeout bounds how long a claim blocks retries when
+	// the instance handling it dies; it is well above the write timeout.
+	idempotencyLockTimeout = time.Minute
+)
+
+// replayHeaders are the response headers stored with a result.
+var replayHeaders = []string{"Content-Type", "ETag", "Last-Modified", "Location", undoTokenHeader}
+
+// Idempotency makes writes sent with an Idempotency-Key header safe to
+// retry: the first request's response is stored and replayed for repeats of
+// the same key by the same principal within the TTL. Reusing a key for a
+// different request is rejected with 422, and a repeat that arrives while the
+// first is still running gets 409. 5xx responses are not stored, so the
+// request can be retried. Requests without the header pass through. It must
+// run after authentication.
+func Idempotency(opts IdempotencyOptions) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			idemKey := r.Header.Get(idempotencyKeyHeader)
+			if idemKey == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(idemKey) > maxIdempotencyKeyLen || !printableASCII(idemKey) {
+				writeError(w, http.StatusBadRequest, "invalid Idempotency-Key")
+				return
+			}
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				deny(w, r, http.StatusUnauthorized, "missing claims")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key := claims.Subject + "#" + idemKey
+			fp := requestFingerprint(r, body)
+			ctx := context.WithoutCancel(r.Context())
+
+			prev, claimed, err := opts.Store.ClaimIdempotencyKey(ctx, key, fp, time.Now().Add(idempotencyLockTimeout))
+			if err != nil {
+				opts.Logger.Error("idempotency claim failed", "error", err, "sub", claims.Subject)
+				writeError(w, http.StatusInternalServerError, "failed to process Idempotency-Key")
+				return
+			}
+			if !claimed {
+				switch {
+				case prev.Fingerprint != fp:
+					writeErrorCode(w, http.StatusUnprocessableEntity, ErrCodeIdempotencyReused, "Idempotency-Key was used for a different request")
+				case !prev.Done:
+					writeErrorCode(w, http.StatusConflict, ErrCodeIdempotencyConflict, "a request with this Idempotency-Key is in progress")
+				default:
+					for k, v := range prev.Header {
+						w.Header()[k] = v
+					}
+					w.Header().Set(replayedHeader, "true")
+					w.WriteHeader(prev.Status)
+					w.Write(prev.Body)
+				}
+				return
+			}
+
+			rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			if rec.status >= 500 {
+				if err := opts.Store.ReleaseIdempotencyKey(ctx, key); err != nil {
+					opts.Logger.Error("idempotency release failed", "error", err, "sub", claims.Subject)
+				}
+				return
+			}
+			res := IdempotentResult{
+				Fingerprint: fp,
+				Done:        true,
+				Status:      rec.status,
+				Header:      http.Header{},
+				Body:        redactResponse(rec.body.Bytes(), opts.SensitiveKeys),
+				UserID:      r.PathValue("userId"),
+			}
+			for _, h := range replayHeaders {
+				if v := w.Header().Values(h); len(v) > 0 {
+					res.Header[http.CanonicalHeaderKey(h)] = v
+				}
+			}
+			if err := opts.Store.CompleteIdempotencyKey(ctx, key, res, time.Now().Add(opts.TTL)); err != nil {
+				// The write succeeded; a retry will see the claim until it
+				// times out.
+				opts.Logger.Error("idempotency complete failed", "error", err, "sub", claims.Subject)
+			}
+		}
+	}
+}
+
+// requestFingerprint identifies a request by method, target and body, so a
+// reused key can be told apart from a retry.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func printableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// redactResponse replaces sensitive values in a preferences or single
+// preference response body. Other bodies are returned unchanged.
+func redactResponse(body []byte, sensitive []string) []byte {
+	if len(sensitive) == 0 {
+		return body
+	}
+	var doc map[string]any
+	if err := decodeJSON(bytes.NewReader(body), &doc); err != nil {
+		return body
+	}
+	if prefs, ok := doc["preferences"].(map[string]any); ok {
+		for k := range prefs {
+			if slices.Contains(sensitive, k) {
+				prefs[k] = redactedValue
+			}
+		}
+	}
+	if key, ok := doc["key"].(string); ok && slices.Contains(sensitive, key) {
+		doc["value"] = redactedValue
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return append(out, '\n')
+}
+
+// recordingWriter passes a response through while keeping a copy.
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
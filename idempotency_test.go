@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memIdempotency implements IdempotencyStore for testing.
+type memIdempotency struct {
+	mu      sync.Mutex
+	results map[string]IdempotentResult
+}
+
+func newMemIdempotency() *memIdempotency {
+	return &memIdempotency{results: make(map[string]IdempotentResult)}
+}
+
+func (m *memIdempotency) ClaimIdempotencyKey(_ context.Context, key, fingerprint string, _ time.Time) (IdempotentResult, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if prev, ok := m.results[key]; ok {
+		return prev, false, nil
+	}
+	m.results[key] = IdempotentResult{Fingerprint: fingerprint}
+	return IdempotentResult{}, true, nil
+}
+
+func (m *memIdempotency) CompleteIdempotencyKey(_ context.Context, key string, res IdempotentResult, _ time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[key] = res
+	return nil
+}
+
+func (m *memIdempotency) ReleaseIdempotencyKey(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.results, key)
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	store, idem := newMockStore(), newMemIdempotency()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+	mw := Idempotency(IdempotencyOptions{Store: idem, TTL: time.Hour, SensitiveKeys: []string{"secret"}, Logger: testLogger()})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", mw(h.ReplaceAll))
+
+	put := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/users/user1/preferences", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	first := put("k1", `{"theme":"dark","secret":"x"}`)
+	if first.Code != http.StatusCreated || first.Header().Get(replayedHeader) != "" {
+		t.Fatalf("expected 201 on first request, got %d", first.Code)
+	}
+	if !strings.Contains(first.Body.String(), `"secret":"x"`) {
+		t.Fatalf("expected the live response unredacted, got %s", first.Body.String())
+	}
+
+	// A later write must not be undone by the retry.
+	put("", `{"theme":"light"}`)
+
+	retry := put("k1", `{"theme":"dark","secret":"x"}`)
+	if retry.Code != http.StatusCreated || retry.Header().Get(replayedHeader) != "true" {
+		t.Fatalf("expected replayed 201, got %d %v", retry.Code, retry.Header())
+	}
+	if retry.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Fatalf("expected replayed ETag %q, got %q", first.Header().Get("ETag"), retry.Header().Get("ETag"))
+	}
+	var resp PreferencesResponse
+	json.NewDecoder(retry.Body).Decode(&resp)
+	if resp.Preferences["theme"] != "dark" || resp.Preferences["secret"] != redactedValue {
+		t.Fatalf("unexpected replayed body %+v", resp)
+	}
+	if store.prefs["user1"]["theme"] != "light" || store.versions["user1"] != 2 {
+		t.Fatalf("expected the retry not to write, got %v v%d", store.prefs["user1"], store.versions["user1"])
+	}
+	if got := idem.results["user1#k1"].UserID; got != "user1" {
+		t.Fatalf("expected the result to name its user for erasure, got %q", got)
+	}
+
+	if w := put("k1", `{"theme":"blue"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a reused key, got %d", w.Code)
+	}
+
+	idem.results["user1#k2"] = IdempotentResult{Fingerprint: "pending"}
+	if w := put("k2", `{"theme":"blue"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a different in-flight request, got %d", w.Code)
+	}
+
+	if w := put("bad\x01key", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid key, got %d", w.Code)
+	}
+
+	store.err = errors.New("throttled")
+	if w := put("k3", `{"theme":"blue"}`); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	if _, ok := idem.results["user1#k3"]; ok {
+		t.Fatal("expected a failed request to release its key")
+	}
+}
+
+func TestIdempotency_InProgress(t *testing.T) {
+	idem := newMemIdempotency()
+	mw := Idempotency(IdempotencyOptions{Store: idem, TTL: time.Hour, Logger: testLogger()})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := mw(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", "/api/v1/users/user1/preferences", nil)
+		req.Header.Set(idempotencyKeyHeader, "k1")
+		w := httptest.NewRecorder()
+		handler(w, withClaims(req, "user1"))
+		return w
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send() }()
+	<-started
+	if w := send(); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 while the first request runs, got %d", w.Code)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if w := send(); w.Code != http.StatusNoContent || w.Header().Get(replayedHeader) != "true" {
+		t.Fatalf("expected replayed 204, got %d", w.Code)
+	}
+}
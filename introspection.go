@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuthModeIntrospection validates opaque tokens against an RFC 7662 endpoint.
+const AuthModeIntrospection = "introspection"
+
+// IntrospectionOptions configures the IntrospectionAuth middleware.
+type IntrospectionOptions struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	// ClientSecretFunc, if set, is used in place of ClientSecret so a
+	// rotated secret takes effect immediately.
+	ClientSecretFunc func() string
+	// CacheTTL bounds how long an introspection result is reused. Active
+	// results are never cached past the token's own expiry, nor inactive
+	// ones past introspectionInactiveTTL.
+	CacheTTL   time.Duration
+	Audiences  AudiencePolicy
+	CookieName string
+	HTTPClient *http.Client
+}
+
+// introspectionResponse is the subset of RFC 7662 fields we use.
+type introspectionResponse struct {
+	Active   bool             `json:"active"`
+	Subject  string           `json:"sub"`
+	ClientID string           `json:"client_id"`
+	Scope    string           `json:"scope"`
+	Audience jwt.ClaimStrings `json:"aud"`
+	Exp      int64            `json:"exp"`
+}
+
+// introspectionCacheSize bounds the cached results; past it the least
+// recently used are evicted. introspectionInactiveTTL caps how long an
+// inactive result is reused, so a flood of bad tokens churns the cache
+// without pinning it, and a token that becomes active is noticed soon.
+const (
+	introspectionCacheSize   = 10000
+	introspectionInactiveTTL = 10 * time.Second
+)
+
+// Introspector calls the introspection endpoint and caches results by token hash.
+type Introspector struct {
+	opts  IntrospectionOptions
+	cache *lruCache[introspectionResponse]
+}
+
+// NewIntrospector creates an Introspector for the given options.
+func NewIntrospector(opts IntrospectionOptions) *Introspector {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &Introspector{opts: opts, cache: newLRUCache[introspectionResponse](introspectionCacheSize)}
+}
+
+// Introspect returns the introspection result for token, from cache if fresh.
+func (in *Introspector) Introspect(ctx context.Context, token string) (introspectionResponse, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	if resp, ok := in.cache.get(key, now); ok {
+		return resp, nil
+	}
+
+	resp, err := in.call(ctx, token)
+	if err != nil {
+		return introspectionResponse{}, err
+	}
+
+	ttl := in.opts.CacheTTL
+	if !resp.Active {
+		ttl = min(ttl, introspectionInactiveTTL)
+	}
+	expires := now.Add(ttl)
+	if resp.Active && resp.Exp > 0 {
+		if exp := time.Unix(resp.Exp, 0); exp.Before(expires) {
+			expires = exp
+		}
+	}
+	in.cache.put(key, resp, expires)
+
+	return resp, nil
+}
+
+func (in *Introspector) call(ctx context.Context, token string) (introspectionResponse, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, in.opts.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return introspectionResponse{}, fmt.Errorf("building introspection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if in.opts.ClientID != "" {
+		secret := in.opts.ClientSecret
+		if in.opts.ClientSecretFunc != nil {
+			secret = in.opts.ClientSecretFunc()
+		}
+		req.SetBasicAuth(in.opts.ClientID, secret)
+	}
+
+	res, err := in.opts.HTTPClient.Do(req)
+	if err != nil {
+		return introspectionResponse{}, fmt.Errorf("calling introspection endpoint: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return introspectionResponse{}, fmt.Errorf("introspection endpoint returned %d", res.StatusCode)
+	}
+
+	var out introspectionResponse
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return introspectionResponse{}, fmt.Errorf("decoding introspection response: %w", err)
+	}
+	return out, nil
+}
+
+// IntrospectionAuth validates opaque bearer tokens via the Introspector and
+// stores the resulting claims in context, applying the same audience policy
+// as JWTAuth.
+func IntrospectionAuth(in *Introspector) func(http.HandlerFunc) http.HandlerFunc {
+	return authenticate(in.authenticate)
+}
+
+// errIntrospectionUnavailable is returned when the introspection endpoint
+// cannot be reached, so tokens cannot be checked.
+var errIntrospectionUnavailable = errors.New("token introspection unavailable")
+
+// authenticate is the Authenticator behind IntrospectionAuth.
+func (in *Introspector) authenticate(ctx context.Context, c Credentials) (Claims, error) {
+	tokenStr, err := c.bearer(in.opts.CookieName)
+	if err != nil {
+		return Claims{}, unauthenticated(err.Error())
+	}
+
+	resp, err := in.Introspect(ctx, tokenStr)
+	if err != nil {
+		return Claims{}, errIntrospectionUnavailable
+	}
+	if !resp.Active {
+		return Claims{}, unauthenticated("invalid or expired token")
+	}
+
+	sub := resp.Subject
+	if sub == "" {
+		sub = resp.ClientID
+	}
+	if sub == "" {
+		return Claims{}, unauthenticated("token missing subject claim")
+	}
+
+	kind, ok := in.opts.Audiences.classify(resp.Audience)
+	if !ok {
+		return Claims{}, unauthenticated("token audience not accepted")
+	}
+
+	claims := Claims{Subject: sub, Kind: kind}
+	if kind == PrincipalService {
+		claims.Scopes = strings.Fields(resp.Scope)
+	}
+	return claims, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minJWKSRefetch rate-limits refreshes triggered by tokens with an unknown
+// key ID, so a stream of bogus kids cannot hammer the IdP.
+const minJWKSRefetch = 30 * time.Second
+
+// JWKSOptions configures a JWKSCache.
+type JWKSOptions struct {
+	URL string
+	// RefreshInterval is how often keys are re-fetched in the background.
+	RefreshInterval time.Duration
+	HTTPClient      *http.Client
+	Logger          *slog.Logger
+}
+
+// JWKSCache holds the IdP's signing keys fetched from a JWKS endpoint. Keys
+// are refreshed in the background and on demand when a token names an
+// unknown key; if the IdP is unreachable the last good key set keeps being
+// served, so a brief IdP outage does not fail authentication.
+type JWKSCache struct {
+	opts JWKSOptions
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	unnamed   []crypto.PublicKey // keys without a kid
+	fetchedAt time.Time
+
+	refreshing  atomic.Bool
+	lastAttempt atomic.Int64 // unix nanos
+}
+
+// NewJWKSCache creates an empty cache; call Refresh before serving.
+func NewJWKSCache(opts JWKSOptions) *JWKSCache {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = 5 * time.Minute
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &JWKSCache{opts: opts, keys: map[string]crypto.PublicKey{}}
+}
+
+// Refresh fetches the key set and replaces the cached keys. On failure the
+// cached keys are left untouched.
+func (c *JWKSCache) Refresh(ctx context.Context) error {
+	c.lastAttempt.Store(time.Now().UnixNano())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.opts.URL, nil)
+	if err != nil {
+		return fmt.Errorf("building JWKS request: %w", err)
+	}
+	res, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching JWKS: status %d", res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	var unnamed []crypto.PublicKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			c.opts.Logger.Warn("skipping unusable JWKS key", "kid", k.Kid, "error", err)
+			continue
+		}
+		if k.Kid == "" {
+			unnamed = append(unnamed, pub)
+		} else {
+			keys[k.Kid] = pub
+		}
+	}
+	if len(keys) == 0 && len(unnamed) == 0 {
+		return errors.New("JWKS contains no usable signing keys")
+	}
+
+	c.mu.Lock()
+	c.keys, c.unnamed, c.fetchedAt = keys, unnamed, time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the keys every RefreshInterval until ctx is cancelled.
+func (c *JWKSCache) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refreshLogged(ctx)
+		}
+	}
+}
+
+func (c *JWKSCache) refreshLogged(ctx context.Context) {
+	if err := c.Refresh(ctx); err != nil {
+		c.mu.RLock()
+		age := time.Since(c.fetchedAt)
+		c.mu.RUnlock()
+		c.opts.Logger.Warn("JWKS refresh failed; serving cached keys", "error", err, "cacheAge", age.Round(time.Second).String())
+	}
+}
+
+// refreshSoon starts an asynchronous refresh unless one is running or one
+// was attempted recently.
+func (c *JWKSCache) refreshSoon() {
+	if time.Since(time.Unix(0, c.lastAttempt.Load())) < minJWKSRefetch {
+		return
+	}
+	if !c.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer c.refreshing.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		c.refreshLogged(ctx)
+	}()
+}
+
+// candidates returns the keys that may have signed a token with the given
+// kid. An unknown kid usually means the IdP rotated keys, so a refresh is
+// kicked off; the token itself is rejected rather than waiting on the IdP.
+func (c *JWKSCache) candidates(kid string) []crypto.PublicKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if kid == "" {
+		out := slices.Clone(c.unnamed)
+		for _, k := range c.keys {
+			out = append(out, k)
+		}
+		return out
+	}
+	if k, ok := c.keys[kid]; ok {
+		return []crypto.PublicKey{k}
+	}
+	c.refreshSoon()
+	return slices.Clone(c.unnamed)
+}
+
+// jwk is a single JSON Web Key (RFC 7517) with the members needed for EC,
+// OKP (Ed25519), and RSA public keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := b64(k.X)
+		y, errY := b64(k.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid EC coordinates")
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC coordinate length")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "RSA":
+		n, errN := b64(k.N)
+		e, errE := b64(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA key")
+		}
+		exp := new(big.Int).SetBytes(e)
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
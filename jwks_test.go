@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func ecJWK(kid string, pub *ecdsa.PublicKey) map[string]string {
+	raw, _ := pub.Bytes() // 0x04 || X || Y
+	size := (len(raw) - 1) / 2
+	return map[string]string{
+		"kty": "EC", "kid": kid, "use": "sig", "crv": "P-256",
+		"x": base64.RawURLEncoding.EncodeToString(raw[1 : 1+size]),
+		"y": base64.RawURLEncoding.EncodeToString(raw[1+size:]),
+	}
+}
+
+func TestJWKSCache_ServesStaleKeysWhenIdPDown(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var down atomic.Bool
+	var fetches atomic.Int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []any{ecJWK("k1", &key.PublicKey)}})
+	}))
+	defer idp.Close()
+
+	cache := NewJWKSCache(JWKSOptions{URL: idp.URL, HTTPClient: idp.Client(), Logger: testLogger()})
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatalf("initial refresh: %v", err)
+	}
+
+	mux := jwtTestMux(JWTAuth(JWTOptions{Algorithms: []string{AlgES256}, JWKS: cache}), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	send := func(kid string) int {
+		tok := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"sub": "user1"})
+		tok.Header["kid"] = kid
+		s, _ := tok.SignedString(key)
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		req.Header.Set("Authorization", "Bearer "+s)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := send("k1"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	down.Store(true)
+	if err := cache.Refresh(context.Background()); err == nil {
+		t.Fatal("expected refresh to fail while IdP is down")
+	}
+	if code := send("k1"); code != http.StatusOK {
+		t.Fatalf("expected cached key to keep working, got %d", code)
+	}
+
+	// An unknown kid is rejected; the refresh it would trigger is rate
+	// limited because a fetch was just attempted.
+	before := fetches.Load()
+	if code := send("k2"); code != http.StatusUnauthorized {
+		t.Fatalf("expected unknown kid rejected, got %d", code)
+	}
+	if fetches.Load() != before {
+		t.Fatal("expected unknown-kid refresh to be rate limited")
+	}
+}
+
+func TestJWK_PublicKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pub, err := jwkFromMap(ecJWK("k1", &key.PublicKey)).publicKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !key.PublicKey.Equal(pub) {
+		t.Fatal("expected parsed key to equal original")
+	}
+
+	for _, bad := range []jwk{
+		{Kty: "EC", Crv: "P-192"},
+		{Kty: "OKP", Crv: "X25519"},
+		{Kty: "oct"},
+	} {
+		if _, err := bad.publicKey(); err == nil {
+			t.Fatalf("expected error for %+v", bad)
+		}
+	}
+}
+
+func jwkFromMap(m map[string]string) jwk {
+	b, _ := json.Marshal(m)
+	var k jwk
+	json.Unmarshal(b, &k)
+	return k
+}
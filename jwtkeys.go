@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms JWTAuth can verify. HS256 uses the shared secret; the
+// others use public keys from JWT_PUBLIC_KEY_FILE or JWT_JWKS_URL.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgES384 = "ES384"
+	AlgES512 = "ES512"
+	AlgEdDSA = "EdDSA"
+)
+
+var supportedAlgorithms = []string{AlgHS256, AlgRS256, AlgES256, AlgES384, AlgES512, AlgEdDSA}
+
+// usesSecret reports whether any of algs is verified with the shared secret.
+func usesSecret(algs []string) bool {
+	return slices.Contains(algs, AlgHS256)
+}
+
+// usesPublicKeys reports whether any of algs is verified with a public key.
+func usesPublicKeys(algs []string) bool {
+	return slices.ContainsFunc(algs, func(a string) bool { return a != AlgHS256 })
+}
+
+// LoadPublicKeys reads PEM-encoded RSA, ECDSA, and Ed25519 public keys (PKIX
+// "PUBLIC KEY" blocks or certificates) from path. Several keys may be listed
+// to cover a rotation.
+func LoadPublicKeys(path string) ([]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading public keys: %w", err)
+	}
+
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key any
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key in %s: %w", path, err)
+		}
+
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T in %s", key, path)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found in %s", path)
+	}
+	return keys, nil
+}
+
+// keyFunc selects verification keys by the token's signing method. The
+// parser has already checked the algorithm against the allowed list.
+func (o JWTOptions) keyFunc(t *jwt.Token) (any, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if o.SecretFunc != nil {
+			return []byte(o.SecretFunc()), nil
+		}
+		return []byte(o.Secret), nil
+	case *jwt.SigningMethodRSA:
+		return o.keySet(t, func(k crypto.PublicKey) bool { _, ok := k.(*rsa.PublicKey); return ok })
+	case *jwt.SigningMethodECDSA:
+		return o.keySet(t, func(k crypto.PublicKey) bool { _, ok := k.(*ecdsa.PublicKey); return ok })
+	case *jwt.SigningMethodEd25519:
+		return o.keySet(t, func(k crypto.PublicKey) bool { _, ok := k.(ed25519.PublicKey); return ok })
+	}
+	return nil, fmt.Errorf("unsupported signing method %s", t.Method.Alg())
+}
+
+// keySet gathers the configured public keys of the right type, plus JWKS
+// keys matching the token's kid.
+func (o JWTOptions) keySet(t *jwt.Token, match func(crypto.PublicKey) bool) (any, error) {
+	candidates := o.PublicKeys
+	if o.JWKS != nil {
+		kid, _ := t.Header["kid"].(string)
+		candidates = append(slices.Clone(candidates), o.JWKS.candidates(kid)...)
+	}
+
+	var set jwt.VerificationKeySet
+	for _, k := range candidates {
+		if match(k) {
+			set.Keys = append(set.Keys, k)
+		}
+	}
+	if len(set.Keys) == 0 {
+		return nil, errors.New("no public key configured for token algorithm")
+	}
+	return set, nil
+}
+
+// algorithms returns the allowed signing algorithms, defaulting to HS256.
+func (o JWTOptions) algorithms() []string {
+	if len(o.Algorithms) == 0 {
+		return []string{AlgHS256}
+	}
+	return o.Algorithms
+}
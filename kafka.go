@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// SASL mechanisms accepted in KAFKA_SASL_MECHANISM.
+const (
+	KafkaSASLPlain       = "PLAIN"
+	KafkaSASLScramSHA256 = "SCRAM-SHA-256"
+	KafkaSASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// kafkaWriter is the subset of kafka.Writer used, for testing.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaPublisher produces change events to a Kafka topic, keyed by user ID
+// so each user's events land on one partition, in order. The event type
+// and ID are also sent as headers.
+type KafkaPublisher struct {
+	writer kafkaWriter
+	source string
+}
+
+// NewKafkaPublisher produces through writer, as the CloudEvents source
+// source.
+func NewKafkaPublisher(writer kafkaWriter, source string) *KafkaPublisher {
+	return &KafkaPublisher{writer: writer, source: source}
+}
+
+// NewKafkaWriter creates a writer for the configured brokers and topic.
+// Writes wait for all in-sync replicas; AsyncSink does the retrying, so the
+// writer tries once. password is called for every connection, so a
+// refreshed secret is picked up.
+func NewKafkaWriter(cfg Config, password func() string) *kafka.Writer {
+	transport := &kafka.Transport{ClientID: "user-prefs"}
+	if cfg.KafkaTLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if cfg.KafkaSASLMechanism != "" {
+		transport.SASL = &kafkaSASL{mechanism: cfg.KafkaSASLMechanism, username: cfg.KafkaSASLUsername, password: password}
+	}
+	return &kafka.Writer{
+		Addr:         kafka.TCP(cfg.KafkaBrokers...),
+		Topic:        cfg.KafkaTopic,
+		Balancer:     &kafka.Hash{},
+		MaxAttempts:  1,
+		BatchTimeout: 10 * time.Millisecond,
+		RequiredAcks: kafka.RequireAll,
+		Transport:    transport,
+	}
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, e ChangeEvent) error {
+	body, err := encodeEvent(e, p.source)
+	if err != nil {
+		return err
+	}
+	err = p.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(e.UserID),
+		Value: body,
+		Time:  e.At,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte(cloudEventsContentType)},
+			{Key: "eventType", Value: []byte(e.Type)},
+			{Key: "eventId", Value: []byte(e.ID)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("WriteMessages (kafka): %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the writer.
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// kafkaSASL authenticates with the current password each time a connection
+// starts.
+type kafkaSASL struct {
+	mechanism string
+	username  string
+	password  func() string
+}
+
+func (m *kafkaSASL) Name() string { return m.mechanism }
+
+func (m *kafkaSASL) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	var mech sasl.Mechanism
+	switch m.mechanism {
+	case KafkaSASLPlain:
+		mech = plain.Mechanism{Username: m.username, Password: m.password()}
+	case KafkaSASLScramSHA256, KafkaSASLScramSHA512:
+		algo := scram.SHA256
+		if m.mechanism == KafkaSASLScramSHA512 {
+			algo = scram.SHA512
+		}
+		var err error
+		if mech, err = scram.Mechanism(algo, m.username, m.password()); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported SASL mechanism %q", m.mechanism)
+	}
+	return mech.Start(ctx)
+}
+
+// parseKafkaSASL validates a KAFKA_SASL_MECHANISM value.
+func parseKafkaSASL(s string) (string, error) {
+	switch m := strings.ToUpper(s); m {
+	case "", KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512:
+		return m, nil
+	}
+	return "", fmt.Errorf("KAFKA_SASL_MECHANISM must be %s, %s or %s", KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512)
+}
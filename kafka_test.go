@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeKafkaWriter records produced messages.
+type fakeKafkaWriter struct {
+	msgs   []kafka.Message
+	closed bool
+}
+
+func (f *fakeKafkaWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	f.msgs = append(f.msgs, msgs...)
+	return nil
+}
+
+func (f *fakeKafkaWriter) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	writer := &fakeKafkaWriter{}
+	sink := NewAsyncSink("kafka", NewKafkaPublisher(writer, "user-prefs"), testLogger())
+	store := NewChangePublisher(newMockStore(), nil, testLogger(), sink)
+	ctx := context.Background()
+	store.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark"}, Precondition{})
+	store.ReplaceAll(ctx, "user2", map[string]any{"theme": "light"}, Precondition{})
+	sink.Close(ctx)
+
+	if len(writer.msgs) != 2 || !writer.closed {
+		t.Fatalf("expected two messages and the writer closed, got %d, closed %v", len(writer.msgs), writer.closed)
+	}
+	msg := writer.msgs[1]
+	var ce CloudEvent
+	if err := json.Unmarshal(msg.Value, &ce); err != nil || ce.Subject != "user2" || ce.Data.UserID != "user2" {
+		t.Fatalf("unexpected value %s (%v)", msg.Value, err)
+	}
+	e := ce.Data
+	if string(msg.Key) != "user2" {
+		t.Fatalf("expected the user ID as key, got %q", msg.Key)
+	}
+	if len(msg.Headers) != 3 || string(msg.Headers[0].Value) != cloudEventsContentType ||
+		string(msg.Headers[1].Value) != EventPreferencesUpdated || string(msg.Headers[2].Value) != e.ID {
+		t.Fatalf("unexpected headers %+v", msg.Headers)
+	}
+}
+
+func TestKafkaSASL(t *testing.T) {
+	password := "old"
+	m := &kafkaSASL{mechanism: KafkaSASLPlain, username: "prefs", password: func() string { return password }}
+	password = "rotated"
+	_, ir, err := m.Start(context.Background())
+	if err != nil || string(ir) != "\x00prefs\x00rotated" {
+		t.Fatalf("expected the current password, got %q (%v)", ir, err)
+	}
+
+	m.mechanism = KafkaSASLScramSHA512
+	if _, ir, err := m.Start(context.Background()); err != nil || !strings.HasPrefix(string(ir), "n,,n=prefs,r=") {
+		t.Fatalf("unexpected SCRAM first message %q (%v)", ir, err)
+	}
+
+	if _, err := parseKafkaSASL("gssapi"); err == nil {
+		t.Fatal("expected an unsupported mechanism to be rejected")
+	}
+	if m, err := parseKafkaSASL("scram-sha-256"); err != nil || m != KafkaSASLScramSHA256 {
+		t.Fatalf("expected the mechanism normalized, got %q (%v)", m, err)
+	}
+}
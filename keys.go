@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// maxKeyBytes is the structural limit on key names; MAX_KEY_LENGTH can set
+// a lower quota.
+const maxKeyBytes = 255
+
+// routeKeyNames are the key names taken by literal routes under
+// /preferences/, which would shadow the single-key routes for them.
+var routeKeyNames = []string{"export", "import", "history", "stream", "changes"}
+
+// Key names are ASCII letters, digits, '_', '-' and '.', starting with a
+// letter or digit. Dots separate namespaces (see ?prefix=) and so cannot be
+// doubled or trailing. Dotted names are safe in DynamoDB paths because the
+// store always passes key names as expression attribute name placeholders.
+// The routeKeyNames are reserved.
+func keyViolation(key string, reserved []string) string {
+	if key == "" {
+		return "key is empty"
+	}
+	if len(key) > maxKeyBytes {
+		return fmt.Sprintf("key is longer than %d bytes", maxKeyBytes)
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case i > 0 && (c == '_' || c == '-'):
+		case i > 0 && c == '.':
+			if key[i-1] == '.' || i == len(key)-1 {
+				return "key has an empty namespace segment"
+			}
+		default:
+			return fmt.Sprintf("key contains invalid character %q at byte %d", c, i)
+		}
+	}
+	if slices.Contains(routeKeyNames, key) {
+		return fmt.Sprintf("key name %q is reserved for a route", key)
+	}
+	for _, p := range reserved {
+		if strings.HasPrefix(key, p) {
+			return fmt.Sprintf("key prefix %q is reserved", p)
+		}
+	}
+	return ""
+}
+
+// validatePrefs writes a 422 listing each invalid key, and each value that
+// fails its key definition or schema, among those written, and reports
+// whether they are all valid. Only keys being set are checked, so stored keys
+// that predate the rules can still be removed.
+func (h *PreferencesHandler) validatePrefs(w http.ResponseWriter, prefs map[string]any) bool {
+	if out := h.prefViolations(prefs); len(out) > 0 {
+		writeViolations(w, ErrCodeValidationFailed, "invalid preferences", out)
+		return false
+	}
+	return true
+}
+
+// prefViolations lists the violations validatePrefs reports, in key order.
+func (h *PreferencesHandler) prefViolations(prefs map[string]any) []Violation {
+	var out []Violation
+	for _, k := range slices.Sorted(maps.Keys(prefs)) {
+		if reason := keyViolation(k, h.opts.ReservedKeyPrefixes); reason != "" {
+			out = append(out, Violation{Key: k, Code: ErrCodeKeyInvalid, Reason: reason})
+			continue
+		}
+		if !h.opts.keyAllowed(k) {
+			out = append(out, Violation{Key: k, Code: ErrCodeKeyNotAllowed, Reason: "key is not allowed"})
+			continue
+		}
+		if reason := h.deprecationViolation(k); reason != "" {
+			out = append(out, Violation{Key: k, Code: ErrCodeKeyDeprecated, Reason: reason})
+			continue
+		}
+		if reason := valueViolation(prefs[k]); reason != "" {
+			out = append(out, Violation{Key: k, Code: ErrCodeValueInvalid, Reason: reason})
+			continue
+		}
+		if schema := h.opts.Schema.Get(); schema != nil {
+			if def, ok := schema.Lookup(k); ok {
+				if reason := def.check(prefs[k]); reason != "" {
+					out = append(out, Violation{Key: k, Code: ErrCodeValueInvalid, Reason: reason})
+					continue
+				}
+			}
+		}
+		out = append(out, h.opts.ValueSchemas.violations(k, prefs[k])...)
+	}
+	return out
+}
+
+// keyAllowed reports whether key may be written. With AllowedKeys set, key
+// must be listed or fall under a listed namespace ending in ".".
+func (o HandlerOptions) keyAllowed(key string) bool {
+	if len(o.AllowedKeys) == 0 {
+		return true
+	}
+	for _, a := range o.AllowedKeys {
+		if key == a || strings.HasSuffix(a, ".") && strings.HasPrefix(key, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropUnknownKeys removes keys that are not allowed from prefs when
+// DropUnknownKeys is set; otherwise validatePrefs rejects them. Single-key
+// writes do not call it, as dropping their key would leave nothing to write.
+func (h *PreferencesHandler) dropUnknownKeys(prefs map[string]any) {
+	if !h.opts.DropUnknownKeys {
+		return
+	}
+	var dropped []string
+	for k := range prefs {
+		if !h.opts.keyAllowed(k) {
+			delete(prefs, k)
+			dropped = append(dropped, k)
+		}
+	}
+	if len(dropped) > 0 {
+		h.logger.Debug("dropped unknown preference keys", "keys", dropped)
+	}
+}
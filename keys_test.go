@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKeyViolation(t *testing.T) {
+	reserved := []string{"system."}
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"theme", true},
+		{"notifications.email", true},
+		{"items_per_page", true},
+		{"a-b.c_d.9", true},
+		{"", false},
+		{"_private", false},
+		{".theme", false},
+		{"theme.", false},
+		{"a..b", false},
+		{"has space", false},
+		{"emoji😀", false},
+		{"system.flags", false},
+		{"export", false},
+		{"export.format", true},
+		{strings.Repeat("k", maxKeyBytes+1), false},
+	}
+	for _, tt := range tests {
+		if got := keyViolation(tt.key, reserved) == ""; got != tt.valid {
+			t.Errorf("%q: expected valid=%v, got %q", tt.key, tt.valid, keyViolation(tt.key, reserved))
+		}
+	}
+}
+
+func TestValidatePrefs_Keys(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"bad key": "legacy"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{ReservedKeyPrefixes: []string{"system."}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", h.PatchPrefs)
+	patch := func(body string) (int, APIError) {
+		req := httptest.NewRequest("PATCH", "/api/v1/users/user1/preferences", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", mergePatchType)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		var resp APIError
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := patch(`{"theme":"dark","system.flag":"x","a b":"y"}`)
+	if code != http.StatusUnprocessableEntity || len(resp.Violations) != 2 {
+		t.Fatalf("expected 422 with two violations, got %d %+v", code, resp)
+	}
+	if resp.Violations[0].Key != "a b" || resp.Violations[1].Key != "system.flag" {
+		t.Fatalf("unexpected violations %+v", resp.Violations)
+	}
+	if _, ok := store.prefs["user1"]["theme"]; ok {
+		t.Fatal("expected the write to be rejected as a whole")
+	}
+
+	// Values DynamoDB would refuse are rejected naming their key.
+	code, resp = patch(`{"limit":1E200}`)
+	if code != http.StatusUnprocessableEntity || len(resp.Violations) != 1 || resp.Violations[0].Key != "limit" || resp.Violations[0].Code != ErrCodeValueInvalid {
+		t.Fatalf("expected 422 for an out-of-range number, got %d %+v", code, resp)
+	}
+
+	// Keys stored before validation can still be removed.
+	if code, _ := patch(`{"bad key":null}`); code != http.StatusOK {
+		t.Fatalf("expected removing a legacy key to pass, got %d", code)
+	}
+}
+
+func TestAllowedKeys(t *testing.T) {
+	opts := HandlerOptions{AllowedKeys: []string{"theme", "notifications."}}
+	for key, want := range map[string]bool{
+		"theme":               true,
+		"themes":              false,
+		"notifications.email": true,
+		"notifications":       false,
+		"language":            false,
+	} {
+		if got := opts.keyAllowed(key); got != want {
+			t.Errorf("%q: expected allowed=%v, got %v", key, want, got)
+		}
+	}
+}
+
+func TestAllowedKeys_Write(t *testing.T) {
+	for _, drop := range []bool{false, true} {
+		store := newMockStore()
+		h := NewPreferencesHandler(store, testLogger(), HandlerOptions{AllowedKeys: []string{"theme"}, DropUnknownKeys: drop})
+		mux := http.NewServeMux()
+		mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", h.PatchPrefs)
+		mux.HandleFunc("PUT /api/v1/users/{userId}/preferences/{key}", h.SetOne)
+		send := func(method, path, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, withClaims(req, "user1"))
+			return w
+		}
+
+		w := send("PATCH", "/api/v1/users/user1/preferences", `{"theme":"dark","invented":1}`)
+		if drop {
+			if w.Code != http.StatusOK || store.prefs["user1"]["theme"] != "dark" {
+				t.Fatalf("drop: expected 200, got %d", w.Code)
+			}
+			if _, ok := store.prefs["user1"]["invented"]; ok {
+				t.Fatal("drop: expected the unknown key to be dropped")
+			}
+		} else if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), `"invented"`) {
+			t.Fatalf("reject: expected 422 naming the key, got %d %s", w.Code, w.Body.String())
+		}
+
+		// A single-key write has nothing left to drop to, so it is rejected
+		// in both modes.
+		if w := send("PUT", "/api/v1/users/user1/preferences/invented", `{"value":1}`); w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("drop=%v: expected 422 for a single unknown key, got %d", drop, w.Code)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// lakeExportPartBytes is the uncompressed size at which an export part is
+// closed and a new one started.
+const lakeExportPartBytes = 64 << 20
+
+// RecordScanner scans every user's stored record. Segment and segments
+// split the scan so it can run in parallel, as DynamoDB's parallel scan
+// does; each segment visits a disjoint share of the users.
+type RecordScanner interface {
+	ScanRecords(ctx context.Context, segment, segments int, fn func(userID string, rec Record) error) error
+}
+
+// objectPutter is the part of the S3 client the export uses.
+type objectPutter interface {
+	PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// LakeExportRow is one line of an export: a user's preferences as stored.
+type LakeExportRow struct {
+	UserID      string         `json:"userId"`
+	Preferences map[string]any `json:"preferences"`
+	Version     int64          `json:"version"`
+	CreatedAt   time.Time      `json:"createdAt,omitzero"`
+	UpdatedAt   time.Time      `json:"updatedAt,omitzero"`
+	SnapshotAt  time.Time      `json:"snapshotAt"`
+}
+
+// LakeExportManifest is written as _SUCCESS once every part of a snapshot is
+// in place. A re-run in the same hour overwrites the partition, so the
+// manifest lists the parts that belong to it.
+type LakeExportManifest struct {
+	SnapshotAt time.Time `json:"snapshotAt"`
+	Users      int       `json:"users"`
+	Files      []string  `json:"files"`
+}
+
+// LakeExportJob snapshots all users' preferences to S3 as gzipped JSON lines,
+// partitioned by snapshot date and hour for a data lake to ingest:
+// <prefix>dt=YYYY-MM-DD/hr=HH/part-<segment>-<n>.jsonl.gz. Sensitive keys,
+// which are stored encrypted, are left out.
+type LakeExportJob struct {
+	source    RecordScanner
+	s3        objectPutter
+	bucket    string
+	prefix    string
+	segments  int
+	sensitive []string
+	logger    *slog.Logger
+	partBytes int
+}
+
+// NewLakeExportJob creates an export job writing under prefix in bucket,
+// scanning with segments workers.
+func NewLakeExportJob(source RecordScanner, client objectPutter, bucket, prefix string, segments int, sensitive []string, logger *slog.Logger) *LakeExportJob {
+	return &LakeExportJob{
+		source:    source,
+		s3:        client,
+		bucket:    bucket,
+		prefix:    prefix,
+		segments:  max(segments, 1),
+		sensitive: sensitive,
+		logger:    logger,
+		partBytes: lakeExportPartBytes,
+	}
+}
+
+// Partition returns the key prefix a snapshot taken at t is written under.
+func (j *LakeExportJob) Partition(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("%sdt=%s/hr=%02d/", j.prefix, t.Format(time.DateOnly), t.Hour())
+}
+
+// Run takes one snapshot, timed at now. Segments are scanned in parallel;
+// the first to fail cancels the rest, and no manifest is written.
+func (j *LakeExportJob) Run(ctx context.Context, now time.Time) (LakeExportManifest, error) {
+	now = now.UTC().Truncate(time.Second)
+	partition := j.Partition(now)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		manifest = LakeExportManifest{SnapshotAt: now, Files: []string{}}
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for segment := range j.segments {
+		wg.Go(func() {
+			users, files, err := j.exportSegment(ctx, partition, segment, now)
+			mu.Lock()
+			defer mu.Unlock()
+			manifest.Users += users
+			manifest.Files = append(manifest.Files, files...)
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("segment %d: %w", segment, err)
+				cancel()
+			}
+		})
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return LakeExportManifest{}, firstErr
+	}
+
+	slices.Sort(manifest.Files)
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return LakeExportManifest{}, err
+	}
+	if err := j.put(ctx, partition+"_SUCCESS", body, "application/json", ""); err != nil {
+		return LakeExportManifest{}, err
+	}
+	j.logger.Info("export written", "bucket", j.bucket, "partition", partition, "users", manifest.Users, "files", len(manifest.Files))
+	return manifest, nil
+}
+
+// exportSegment writes one scan segment's users as parts of at most
+// partBytes uncompressed, returning how many users and which files it
+// wrote.
+func (j *LakeExportJob) exportSegment(ctx context.Context, partition string, segment int, now time.Time) (int, []string, error) {
+	var (
+		users int
+		files []string
+		buf   bytes.Buffer
+		zw    = gzip.NewWriter(&buf)
+		size  int
+	)
+	flush := func() error {
+		if size == 0 {
+			return nil
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%spart-%04d-%05d.jsonl.gz", partition, segment, len(files))
+		if err := j.put(ctx, key, buf.Bytes(), "application/x-ndjson", "gzip"); err != nil {
+			return err
+		}
+		files = append(files, path.Base(key))
+		buf.Reset()
+		zw.Reset(&buf)
+		size = 0
+		return nil
+	}
+
+	err := j.source.ScanRecords(ctx, segment, j.segments, func(userID string, rec Record) error {
+		for _, k := range j.sensitive {
+			delete(rec.Prefs, k)
+		}
+		line, err := json.Marshal(LakeExportRow{
+			UserID:      userID,
+			Preferences: rec.Prefs,
+			Version:     rec.Version,
+			CreatedAt:   rec.CreatedAt,
+			UpdatedAt:   rec.UpdatedAt,
+			SnapshotAt:  now,
+		})
+		if err != nil {
+			return fmt.Errorf("user %s: %w", userID, err)
+		}
+		line = append(line, '\n')
+		if _, err := zw.Write(line); err != nil {
+			return err
+		}
+		users++
+		if size += len(line); size >= j.partBytes {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return users, files, err
+}
+
+func (j *LakeExportJob) put(ctx context.Context, key string, body []byte, contentType, encoding string) error {
+	in := &s3.PutObjectInput{
+		Bucket:      aws.String(j.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	}
+	if encoding != "" {
+		in.ContentEncoding = aws.String(encoding)
+	}
+	if _, err := j.s3.PutObject(ctx, in); err != nil {
+		return fmt.Errorf("PutObject %s: %w", key, err)
+	}
+	return nil
+}
+
+// runExportAll implements "user-prefs export-all": it snapshots every
+// user's preferences to S3 once, or every -every until SIGINT or SIGTERM.
+func runExportAll(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("export-all", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	bucket := fs.String("bucket", os.Getenv("EXPORT_S3_BUCKET"), "S3 bucket to write to")
+	prefix := fs.String("prefix", envOrDefault("EXPORT_S3_PREFIX", "user-preferences/"), "key prefix the date partitions go under")
+	segments := fs.Int("segments", 4, "parallel scan segments")
+	every := fs.Duration("every", 0, "run repeatedly at this interval instead of once")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *bucket == "" {
+		fmt.Fprintln(stderr, "export-all: -bucket or EXPORT_S3_BUCKET is required")
+		return 2
+	}
+	if *segments < 1 || *segments > 1000 {
+		fmt.Fprintln(stderr, "export-all: -segments must be between 1 and 1000")
+		return 2
+	}
+	if *prefix != "" && !strings.HasSuffix(*prefix, "/") {
+		*prefix += "/"
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "export-all: %v\n", err)
+		return 1
+	}
+	logger := slog.New(slog.NewJSONHandler(stdout, &slog.HandlerOptions{Level: cfg.LogLevel}))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	store, err := NewDynamoStore(ctx, cfg)
+	if err != nil {
+		logger.Error("failed to create DynamoDB store", "error", err)
+		return 1
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.AWSRegion))
+	if err != nil {
+		logger.Error("failed to load AWS config", "error", err)
+		return 1
+	}
+	job := NewLakeExportJob(store, s3.NewFromConfig(awsCfg), *bucket, *prefix, *segments, cfg.SensitiveKeys, logger)
+
+	if *every <= 0 {
+		if _, err := job.Run(ctx, time.Now()); err != nil {
+			logger.Error("export failed", "error", err)
+			return 1
+		}
+		return 0
+	}
+
+	// As a worker, a failed run is logged and retried at the next tick.
+	ticker := time.NewTicker(*every)
+	defer ticker.Stop()
+	for {
+		if _, err := job.Run(ctx, time.Now()); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("export failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			logger.Info("export worker stopped")
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
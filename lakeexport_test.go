@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeBucket keeps put objects in memory.
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	fail    string
+}
+
+func (b *fakeBucket) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if b.fail != "" && strings.Contains(*in.Key, b.fail) {
+		return nil, errors.New("access denied")
+	}
+	body, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[*in.Key] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+// scanSource serves the mock store's users as a segmented scan, by
+// position in the sorted user list.
+type scanSource struct{ store *mockStore }
+
+func (s scanSource) ScanRecords(ctx context.Context, segment, segments int, fn func(string, Record) error) error {
+	for i, user := range slices.Sorted(maps.Keys(s.store.prefs)) {
+		if i%segments != segment {
+			continue
+		}
+		rec, err := s.store.GetAll(ctx, user)
+		if err != nil {
+			return err
+		}
+		if err := fn(user, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readLakeExportPart(t *testing.T, data []byte) []LakeExportRow {
+	t.Helper()
+	zr, err := gzip.NewReader(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []LakeExportRow
+	sc := bufio.NewScanner(zr)
+	for sc.Scan() {
+		var row LakeExportRow
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatalf("bad line %q: %v", sc.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestLakeExportJob_Run(t *testing.T) {
+	store := newMockStore()
+	for _, user := range []string{"user1", "user2", "user3", "user4", "user5"} {
+		store.prefs[user] = map[string]any{"theme": "dark", "secret": "ciphertext"}
+	}
+	bucket := &fakeBucket{objects: map[string][]byte{}}
+	job := NewLakeExportJob(scanSource{store}, bucket, "lake", "prefs/", 2, []string{"secret"}, testLogger())
+	job.partBytes = 100 // a part per couple of users
+
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))
+	manifest, err := job.Run(context.Background(), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Partition(at) != "prefs/dt=2026-03-04/hr=04/" || manifest.Users != 5 || len(manifest.Files) < 3 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	written := map[string]bool{}
+	for _, file := range manifest.Files {
+		data, ok := bucket.objects["prefs/dt=2026-03-04/hr=04/"+file]
+		if !ok {
+			t.Fatalf("manifest lists %s, which was not written", file)
+		}
+		for _, row := range readLakeExportPart(t, data) {
+			if row.Preferences["theme"] != "dark" || row.Preferences["secret"] != nil || !row.SnapshotAt.Equal(at.Truncate(time.Second)) {
+				t.Fatalf("unexpected row %+v", row)
+			}
+			written[row.UserID] = true
+		}
+	}
+	if len(written) != 5 {
+		t.Fatalf("expected every user exported once, got %v", written)
+	}
+	var success LakeExportManifest
+	if err := json.Unmarshal(bucket.objects["prefs/dt=2026-03-04/hr=04/_SUCCESS"], &success); err != nil || success.Users != 5 {
+		t.Fatalf("unexpected _SUCCESS %+v %v", success, err)
+	}
+
+	// A failed part leaves the partition without a manifest.
+	bucket = &fakeBucket{objects: map[string][]byte{}, fail: "part-0001"}
+	job = NewLakeExportJob(scanSource{store}, bucket, "lake", "prefs/", 2, nil, testLogger())
+	if _, err := job.Run(context.Background(), at); err == nil || !strings.Contains(err.Error(), "segment 1") {
+		t.Fatalf("expected the failing segment reported, got %v", err)
+	}
+	if _, ok := bucket.objects["prefs/dt=2026-03-04/hr=04/_SUCCESS"]; ok {
+		t.Fatal("expected no _SUCCESS after a failure")
+	}
+}
+
+func TestRunExportAll_Usage(t *testing.T) {
+	t.Setenv("EXPORT_S3_BUCKET", "")
+	for _, args := range [][]string{{}, {"-bucket", "b", "-segments", "0"}} {
+		var stdout, stderr strings.Builder
+		if code := runExportAll(args, &stdout, &stderr); code != 2 {
+			t.Errorf("%v: expected usage error, got %d (%s)", args, code, stderr.String())
+		}
+	}
+}
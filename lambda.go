@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// checkLambdaConfig rejects settings a Lambda function cannot honour: it
+// has no listeners of its own, and API Gateway or the function URL
+// terminates TLS.
+func checkLambdaConfig(cfg Config) error {
+	switch {
+	case cfg.AdminPort != "":
+		return errors.New("ADMIN_PORT is not supported on Lambda; deploy the admin API as its own function")
+	case cfg.GRPCPort != "":
+		return errors.New("GRPC_PORT is not supported on Lambda")
+	case cfg.TLSCertFile != "":
+		return errors.New("TLS_CERT_FILE is not supported on Lambda; TLS ends at API Gateway")
+	case cfg.AuthMode == AuthModeMTLS:
+		return errors.New("AUTH_MODE=mtls is not supported on Lambda; client certificates end at API Gateway")
+	}
+	return nil
+}
+
+// lambdaHandler adapts h to Lambda proxy integrations: API Gateway REST
+// APIs (payload format 1.0), and HTTP APIs and function URLs (2.0), told
+// apart by the payload's version. Each invocation is served as one
+// request whose response is buffered, so streaming routes cannot be
+// served.
+func lambdaHandler(h http.Handler) func(ctx context.Context, payload json.RawMessage) (any, error) {
+	return func(ctx context.Context, payload json.RawMessage) (any, error) {
+		var probe struct {
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal(payload, &probe); err != nil {
+			return nil, err
+		}
+		if probe.Version == "2.0" {
+			var in events.APIGatewayV2HTTPRequest
+			if err := json.Unmarshal(payload, &in); err != nil {
+				return nil, err
+			}
+			r, err := lambdaRequestV2(ctx, in)
+			if err != nil {
+				return nil, err
+			}
+			return lambdaResponseV2(serveBuffered(h, r)), nil
+		}
+		var in events.APIGatewayProxyRequest
+		if err := json.Unmarshal(payload, &in); err != nil {
+			return nil, err
+		}
+		r, err := lambdaRequestV1(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		return lambdaResponseV1(serveBuffered(h, r)), nil
+	}
+}
+
+func lambdaRequestV1(ctx context.Context, in events.APIGatewayProxyRequest) (*http.Request, error) {
+	query := url.Values{}
+	for k, vs := range in.MultiValueQueryStringParameters {
+		query[k] = vs
+	}
+	if len(query) == 0 {
+		for k, v := range in.QueryStringParameters {
+			query.Set(k, v)
+		}
+	}
+	r, err := newLambdaRequest(ctx, in.HTTPMethod, in.Path, query.Encode(), in.Body, in.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range in.MultiValueHeaders {
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	if len(in.MultiValueHeaders) == 0 {
+		for k, v := range in.Headers {
+			r.Header.Set(k, v)
+		}
+	}
+	r.RemoteAddr = net.JoinHostPort(in.RequestContext.Identity.SourceIP, "0")
+	return finishLambdaRequest(r), nil
+}
+
+func lambdaRequestV2(ctx context.Context, in events.APIGatewayV2HTTPRequest) (*http.Request, error) {
+	r, err := newLambdaRequest(ctx, in.RequestContext.HTTP.Method, in.RawPath, in.RawQueryString, in.Body, in.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+	// Repeated headers arrive joined with commas.
+	for k, v := range in.Headers {
+		r.Header.Set(k, v)
+	}
+	if len(in.Cookies) > 0 {
+		r.Header.Set("Cookie", strings.Join(in.Cookies, "; "))
+	}
+	r.RemoteAddr = net.JoinHostPort(in.RequestContext.HTTP.SourceIP, "0")
+	return finishLambdaRequest(r), nil
+}
+
+func newLambdaRequest(ctx context.Context, method, path, query, body string, b64 bool) (*http.Request, error) {
+	raw := []byte(body)
+	if b64 {
+		var err error
+		if raw, err = base64.StdEncoding.DecodeString(body); err != nil {
+			return nil, err
+		}
+	}
+	u := &url.URL{Path: path, RawQuery: query}
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	r.RequestURI = u.RequestURI()
+	return r, nil
+}
+
+// finishLambdaRequest sets what a server would from the headers.
+func finishLambdaRequest(r *http.Request) *http.Request {
+	r.Host = r.Header.Get("Host")
+	r.URL.Host = r.Host
+	r.Header.Del("Host")
+	return r
+}
+
+// bufferedResponse is an http.ResponseWriter keeping the response in
+// memory.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+func serveBuffered(h http.Handler, r *http.Request) *bufferedResponse {
+	w := &bufferedResponse{header: make(http.Header)}
+	h.ServeHTTP(w, r)
+	w.WriteHeader(http.StatusOK)
+	// As net/http's server does.
+	if _, ok := w.header["Content-Type"]; !ok && w.body.Len() > 0 {
+		w.header.Set("Content-Type", http.DetectContentType(w.body.Bytes()))
+	}
+	return w
+}
+
+// lambdaBody returns the response body as the integration expects it:
+// base64-encoded unless it is UTF-8 text.
+func lambdaBody(w *bufferedResponse) (string, bool) {
+	if utf8.Valid(w.body.Bytes()) {
+		return w.body.String(), false
+	}
+	return base64.StdEncoding.EncodeToString(w.body.Bytes()), true
+}
+
+func lambdaResponseV1(w *bufferedResponse) events.APIGatewayProxyResponse {
+	body, b64 := lambdaBody(w)
+	return events.APIGatewayProxyResponse{
+		StatusCode:        w.status,
+		MultiValueHeaders: w.header,
+		Body:              body,
+		IsBase64Encoded:   b64,
+	}
+}
+
+func lambdaResponseV2(w *bufferedResponse) events.APIGatewayV2HTTPResponse {
+	body, b64 := lambdaBody(w)
+	// Payload 2.0 returns cookies apart, since headers are single-valued.
+	cookies := w.header.Values("Set-Cookie")
+	w.header.Del("Set-Cookie")
+	headers := make(map[string]string, len(w.header))
+	for k, vs := range w.header {
+		headers[k] = strings.Join(vs, ",")
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode:      w.status,
+		Headers:         headers,
+		Cookies:         cookies,
+		Body:            body,
+		IsBase64Encoded: b64,
+	}
+}
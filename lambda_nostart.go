@@ -0,0 +1,11 @@
+//go:build !lambda
+
+package main
+
+import "net/http"
+
+const lambdaBuild = false
+
+func startLambda(http.Handler) {
+	panic("not built with -tags lambda")
+}
@@ -0,0 +1,18 @@
+//go:build lambda
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// lambdaBuild is set in binaries built with -tags lambda, which serve
+// Lambda invocations instead of listening.
+const lambdaBuild = true
+
+// startLambda serves h to invocations until the runtime ends the process.
+func startLambda(h http.Handler) {
+	lambda.Start(lambdaHandler(h))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// lambdaEcho answers with what it received, plus a cookie and a binary
+// body for ?binary=true.
+var lambdaEcho = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Add("Set-Cookie", "a=1")
+	w.Header().Add("Set-Cookie", "b=2")
+	if r.URL.Query().Get("binary") == "true" {
+		w.Write([]byte{0xff, 0xfe})
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"method": r.Method, "path": r.URL.Path, "keys": r.URL.Query()["keys"], "host": r.Host,
+		"remote": r.RemoteAddr, "auth": r.Header.Get("Authorization"), "cookie": r.Header.Get("Cookie"), "body": string(body),
+	})
+})
+
+func invokeLambda(t *testing.T, event any) json.RawMessage {
+	t.Helper()
+	payload, _ := json.Marshal(event)
+	out, err := lambdaHandler(lambdaEcho)(context.Background(), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := json.Marshal(out)
+	return resp
+}
+
+func TestLambdaHandler_V1(t *testing.T) {
+	raw := invokeLambda(t, events.APIGatewayProxyRequest{
+		HTTPMethod:                      "PUT",
+		Path:                            "/api/v1/users/user1/preferences",
+		MultiValueQueryStringParameters: map[string][]string{"keys": {"a", "b"}},
+		MultiValueHeaders:               map[string][]string{"Authorization": {"Bearer t"}, "Host": {"prefs.example.com"}},
+		Body:                            base64.StdEncoding.EncodeToString([]byte(`{"theme":"dark"}`)),
+		IsBase64Encoded:                 true,
+		RequestContext:                  events.APIGatewayProxyRequestContext{Identity: events.APIGatewayRequestIdentity{SourceIP: "203.0.113.7"}},
+	})
+	var resp events.APIGatewayProxyResponse
+	json.Unmarshal(raw, &resp)
+	var got map[string]any
+	json.Unmarshal([]byte(resp.Body), &got)
+	if resp.StatusCode != http.StatusCreated || resp.IsBase64Encoded || len(resp.MultiValueHeaders["Set-Cookie"]) != 2 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if got["method"] != "PUT" || got["path"] != "/api/v1/users/user1/preferences" || len(got["keys"].([]any)) != 2 ||
+		got["host"] != "prefs.example.com" || got["remote"] != "203.0.113.7:0" || got["auth"] != "Bearer t" || got["body"] != `{"theme":"dark"}` {
+		t.Fatalf("unexpected request %+v", got)
+	}
+}
+
+func TestLambdaHandler_V2(t *testing.T) {
+	in := events.APIGatewayV2HTTPRequest{
+		Version:        "2.0",
+		RawPath:        "/api/v1/users/user1/preferences",
+		RawQueryString: "keys=a&keys=b",
+		Cookies:        []string{"session=x", "csrf=y"},
+		Headers:        map[string]string{"authorization": "Bearer t", "host": "prefs.example.com"},
+		Body:           `{"theme":"dark"}`,
+	}
+	in.RequestContext.HTTP.Method = "PATCH"
+	in.RequestContext.HTTP.SourceIP = "2001:db8::1"
+	var resp events.APIGatewayV2HTTPResponse
+	json.Unmarshal(invokeLambda(t, in), &resp)
+	var got map[string]any
+	json.Unmarshal([]byte(resp.Body), &got)
+	if resp.StatusCode != http.StatusCreated || len(resp.Cookies) != 2 || resp.Headers["Set-Cookie"] != "" || resp.Headers["Content-Type"] == "" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if got["method"] != "PATCH" || len(got["keys"].([]any)) != 2 || got["remote"] != "[2001:db8::1]:0" ||
+		got["auth"] != "Bearer t" || got["cookie"] != "session=x; csrf=y" || got["body"] != `{"theme":"dark"}` {
+		t.Fatalf("unexpected request %+v", got)
+	}
+
+	in.RawQueryString = "binary=true"
+	json.Unmarshal(invokeLambda(t, in), &resp)
+	if body, _ := base64.StdEncoding.DecodeString(resp.Body); resp.StatusCode != http.StatusOK || !resp.IsBase64Encoded || string(body) != "\xff\xfe" {
+		t.Fatalf("expected a base64 binary body, got %+v", resp)
+	}
+}
+
+func TestCheckLambdaConfig(t *testing.T) {
+	if err := checkLambdaConfig(Config{AuthMode: AuthModeJWT}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkLambdaConfig(Config{AuthMode: AuthModeJWT, AdminPort: "8081"}); err == nil {
+		t.Fatal("expected ADMIN_PORT rejected")
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+)
+
+// Preference layers, lowest precedence first. Each layer's values override
+// those of the layers before it.
+const (
+	LayerDefault = "default"
+	LayerOrg     = "org"
+	LayerTeam    = "team"
+	LayerUser    = "user"
+)
+
+// Membership places a user in an org and team, whose preference layers
+// apply beneath the user's own.
+type Membership struct {
+	OrgID  string `json:"orgId,omitempty"`
+	TeamID string `json:"teamId,omitempty"`
+}
+
+// LayerStore holds the org and team preference layers and user
+// memberships.
+type LayerStore interface {
+	// LayerPreferences returns the store for a layer kind (LayerOrg or
+	// LayerTeam), keyed by org or team ID.
+	LayerPreferences(kind string) Store
+	// GetMembership returns a user's membership; the zero value if unset.
+	GetMembership(ctx context.Context, userID string) (Membership, error)
+	PutMembership(ctx context.Context, userID string, m Membership) error
+	// DeleteMembership removes a user's membership item, if any.
+	DeleteMembership(ctx context.Context, userID string) error
+}
+
+// ResolvedPreference is a key's effective value and the layer it came from.
+type ResolvedPreference struct {
+	Value any    `json:"value"`
+	Layer string `json:"layer"`
+}
+
+// ResolveResponse is returned by the resolution endpoint.
+type ResolveResponse struct {
+	UserID      string                        `json:"userId"`
+	OrgID       string                        `json:"orgId,omitempty"`
+	TeamID      string                        `json:"teamId,omitempty"`
+	Preferences map[string]ResolvedPreference `json:"preferences"`
+}
+
+// LayerResponse is returned by the org and team layer endpoints.
+type LayerResponse struct {
+	Layer       string         `json:"layer"`
+	ID          string         `json:"id"`
+	Preferences map[string]any `json:"preferences"`
+}
+
+// LayersHandler serves layered preference resolution and the admin
+// endpoints managing org and team layers.
+type LayersHandler struct {
+	prefs  *PreferencesHandler
+	layers LayerStore
+}
+
+// NewLayersHandler creates a layers handler sharing prefs' store and
+// options.
+func NewLayersHandler(prefs *PreferencesHandler, layers LayerStore) *LayersHandler {
+	return &LayersHandler{prefs: prefs, layers: layers}
+}
+
+// Resolve merges the default, org, team and user layers and reports, per
+// key, the effective value and the layer that supplied it.
+func (h *LayersHandler) Resolve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	resp, err := resolveLayers(r.Context(), h.prefs, h.layers, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to resolve preferences")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// resolveLayers merges a user's layers, logging failures. Without layers,
+// only the defaults and the user's own map apply.
+func resolveLayers(ctx context.Context, prefs *PreferencesHandler, layers LayerStore, userID string) (ResolveResponse, error) {
+	var m Membership
+	if layers != nil {
+		var err error
+		if m, err = layers.GetMembership(ctx, userID); err != nil {
+			prefs.logger.Error("layers.GetMembership failed", "error", err, "userId", userID)
+			return ResolveResponse{}, err
+		}
+	}
+
+	resolved := make(map[string]ResolvedPreference)
+	apply := func(layer string, values map[string]any) {
+		for k, v := range values {
+			resolved[k] = ResolvedPreference{Value: v, Layer: layer}
+		}
+	}
+
+	defaults, _ := prefs.opts.Defaults.Get()
+	apply(LayerDefault, defaults)
+	for _, l := range []struct{ kind, id string }{{LayerOrg, m.OrgID}, {LayerTeam, m.TeamID}} {
+		if l.id == "" {
+			continue
+		}
+		rec, err := layers.LayerPreferences(l.kind).GetAll(ctx, l.id)
+		if err != nil {
+			prefs.logger.Error("layer GetAll failed", "error", err, "layer", l.kind, "id", l.id)
+			return ResolveResponse{}, err
+		}
+		apply(l.kind, rec.Prefs)
+	}
+	rec, err := prefs.store.GetAll(ctx, userID)
+	if err != nil {
+		prefs.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+		return ResolveResponse{}, err
+	}
+	apply(LayerUser, rec.Prefs)
+
+	return ResolveResponse{
+		UserID:      userID,
+		OrgID:       m.OrgID,
+		TeamID:      m.TeamID,
+		Preferences: resolved,
+	}, nil
+}
+
+// GetLayer returns an org or team layer.
+func (h *LayersHandler) GetLayer(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		rec, err := h.layers.LayerPreferences(kind).GetAll(r.Context(), id)
+		if err != nil {
+			h.prefs.logger.Error("layer GetAll failed", "error", err, "layer", kind, "id", id)
+			writeError(w, http.StatusInternalServerError, "failed to retrieve preferences")
+			return
+		}
+		setValidators(w, rec)
+		if notModified(r, rec) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		h.writeLayer(w, kind, id, rec)
+	}
+}
+
+// ReplaceLayer replaces an org or team layer. It honors If-Match.
+func (h *LayersHandler) ReplaceLayer(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		cond, ok := h.prefs.precondition(w, r)
+		if !ok {
+			return
+		}
+		var prefs map[string]any
+		if err := decodeJSON(r.Body, &prefs); err != nil {
+			writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+			return
+		}
+		h.prefs.dropUnknownKeys(prefs)
+		if !h.checkKeys(w, prefs) || !h.prefs.validatePrefs(w, prefs) {
+			return
+		}
+		if prefs == nil {
+			prefs = map[string]any{}
+		}
+
+		rec, err := h.layers.LayerPreferences(kind).ReplaceAll(r.Context(), id, prefs, cond)
+		h.writeResult(w, kind, id, rec, err)
+	}
+}
+
+// PatchLayer merges into an org or team layer. With a merge patch
+// Content-Type, a null value deletes that key. It honors If-Match.
+func (h *LayersHandler) PatchLayer(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		cond, ok := h.prefs.precondition(w, r)
+		if !ok {
+			return
+		}
+		var patch map[string]any
+		if err := decodeJSON(r.Body, &patch); err != nil {
+			writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+			return
+		}
+		prefs := make(map[string]any, len(patch))
+		var remove []string
+		mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		for k, v := range patch {
+			if v == nil && mt == mergePatchType {
+				remove = append(remove, k)
+			} else {
+				prefs[k] = v
+			}
+		}
+		h.prefs.dropUnknownKeys(prefs)
+		if len(prefs) == 0 && len(remove) == 0 {
+			writeError(w, http.StatusBadRequest, "empty preferences")
+			return
+		}
+		if !h.checkKeys(w, prefs) || !h.prefs.validatePrefs(w, prefs) {
+			return
+		}
+
+		rec, err := h.layers.LayerPreferences(kind).Update(r.Context(), id, prefs, remove, cond)
+		h.writeResult(w, kind, id, rec, err)
+	}
+}
+
+// DeleteLayer removes an org or team layer.
+func (h *LayersHandler) DeleteLayer(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if err := h.layers.LayerPreferences(kind).DeleteAll(r.Context(), id); err != nil {
+			h.prefs.logger.Error("layer DeleteAll failed", "error", err, "layer", kind, "id", id)
+			writeError(w, http.StatusInternalServerError, "failed to delete preferences")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetMembership returns a user's org and team.
+func (h *LayersHandler) GetMembership(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userId")
+	m, err := h.layers.GetMembership(r.Context(), userID)
+	if err != nil {
+		h.prefs.logger.Error("layers.GetMembership failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve membership")
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// PutMembership sets a user's org and team; empty IDs remove the user from
+// that layer.
+func (h *LayersHandler) PutMembership(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userId")
+	var m Membership
+	if err := decodeJSON(r.Body, &m); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if err := h.layers.PutMembership(r.Context(), userID, m); err != nil {
+		h.prefs.logger.Error("layers.PutMembership failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to update membership")
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// checkKeys rejects sensitive keys: layers are shared and not encrypted.
+func (h *LayersHandler) checkKeys(w http.ResponseWriter, prefs map[string]any) bool {
+	for k := range prefs {
+		if slices.Contains(h.prefs.opts.SensitiveKeys, k) {
+			writeError(w, http.StatusBadRequest, "sensitive key "+k+" cannot be set on a layer")
+			return false
+		}
+	}
+	return true
+}
+
+func (h *LayersHandler) writeResult(w http.ResponseWriter, kind, id string, rec Record, err error) {
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, http.StatusPreconditionFailed, "preferences have been modified")
+		return
+	}
+	if err != nil {
+		h.prefs.logger.Error("layer write failed", "error", err, "layer", kind, "id", id)
+		writeError(w, http.StatusInternalServerError, "failed to update preferences")
+		return
+	}
+	setValidators(w, rec)
+	h.writeLayer(w, kind, id, rec)
+}
+
+func (h *LayersHandler) writeLayer(w http.ResponseWriter, kind, id string, rec Record) {
+	prefs := maps.Clone(rec.Prefs)
+	if prefs == nil {
+		prefs = map[string]any{}
+	}
+	writeJSON(w, http.StatusOK, LayerResponse{Layer: kind, ID: id, Preferences: prefs})
+}
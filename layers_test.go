@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// memLayers implements LayerStore for testing.
+type memLayers struct {
+	mu          sync.Mutex
+	stores      map[string]*mockStore
+	memberships map[string]Membership
+}
+
+func newMemLayers() *memLayers {
+	return &memLayers{
+		stores:      map[string]*mockStore{LayerOrg: newMockStore(), LayerTeam: newMockStore()},
+		memberships: make(map[string]Membership),
+	}
+}
+
+func (m *memLayers) LayerPreferences(kind string) Store { return m.stores[kind] }
+
+func (m *memLayers) GetMembership(_ context.Context, userID string) (Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.memberships[userID], nil
+}
+
+func (m *memLayers) PutMembership(_ context.Context, userID string, ms Membership) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ms == (Membership{}) {
+		delete(m.memberships, userID)
+		return nil
+	}
+	m.memberships[userID] = ms
+	return nil
+}
+
+func (m *memLayers) DeleteMembership(_ context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.memberships, userID)
+	return nil
+}
+
+func TestLayersHandler_Resolve(t *testing.T) {
+	store, layers := newMockStore(), newMemLayers()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	layers.stores[LayerOrg].prefs["acme"] = map[string]any{"theme": "light", "lang": "en", "tz": "UTC"}
+	layers.stores[LayerTeam].prefs["web"] = map[string]any{"lang": "fr"}
+	layers.memberships["user1"] = Membership{OrgID: "acme", TeamID: "web"}
+	defaults := NewDefaults(staticDefaults{"tz": "GMT", "font": "sans"}, 0, testLogger())
+	defaults.Refresh(context.Background())
+	h := NewLayersHandler(NewPreferencesHandler(store, testLogger(), HandlerOptions{Defaults: defaults}), layers)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences:resolve", h.Resolve)
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences:resolve", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var resp ResolveResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	want := map[string]ResolvedPreference{
+		"theme": {"dark", LayerUser},
+		"lang":  {"fr", LayerTeam},
+		"tz":    {"UTC", LayerOrg},
+		"font":  {"sans", LayerDefault},
+	}
+	if len(resp.Preferences) != len(want) || resp.OrgID != "acme" || resp.TeamID != "web" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	for k, v := range want {
+		if resp.Preferences[k] != v {
+			t.Fatalf("%s: expected %+v, got %+v", k, v, resp.Preferences[k])
+		}
+	}
+}
+
+func TestLayersHandler_Admin(t *testing.T) {
+	layers := newMemLayers()
+	h := NewLayersHandler(NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{SensitiveKeys: []string{"secret"}}), layers)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/admin/orgs/{id}/preferences", h.ReplaceLayer(LayerOrg))
+	mux.HandleFunc("PATCH /api/v1/admin/orgs/{id}/preferences", h.PatchLayer(LayerOrg))
+	mux.HandleFunc("GET /api/v1/admin/orgs/{id}/preferences", h.GetLayer(LayerOrg))
+	mux.HandleFunc("PUT /api/v1/admin/users/{userId}/membership", h.PutMembership)
+	send := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "apikey:ops"))
+		return w
+	}
+
+	if w := send("PUT", "/api/v1/admin/orgs/acme/preferences", "", `{"theme":"light","lang":"en"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	w := send("PATCH", "/api/v1/admin/orgs/acme/preferences", mergePatchType, `{"lang":null,"tz":"UTC"}`)
+	var resp LayerResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Layer != LayerOrg || resp.ID != "acme" || len(resp.Preferences) != 2 || resp.Preferences["tz"] != "UTC" {
+		t.Fatalf("unexpected patch result %d %+v", w.Code, resp)
+	}
+	if w := send("GET", "/api/v1/admin/orgs/acme/preferences", "", ""); w.Header().Get("ETag") != `"2.g1"` {
+		t.Fatalf("expected ETag \"2.g1\", got %q", w.Header().Get("ETag"))
+	}
+	if w := send("PUT", "/api/v1/admin/orgs/acme/preferences", "", `{"secret":"x"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a sensitive key, got %d", w.Code)
+	}
+
+	if w := send("PUT", "/api/v1/admin/users/user1/membership", "", `{"orgId":"acme"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if m := layers.memberships["user1"]; m.OrgID != "acme" || m.TeamID != "" {
+		t.Fatalf("unexpected membership %+v", m)
+	}
+}
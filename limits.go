@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LimitOptions configures the server-wide load limits.
+type LimitOptions struct {
+	// RPS is the sustained request rate; zero disables rate limiting.
+	RPS   float64
+	Burst int
+	// MaxInFlight caps concurrently executing requests; zero disables.
+	MaxInFlight int
+	// LatencyTarget is the smoothed request latency above which low and
+	// normal priority traffic is shed; zero disables latency-based shedding.
+	LatencyTarget time.Duration
+}
+
+// priority orders traffic for load shedding; lower priorities go first.
+type priority int
+
+const (
+	priorityLow    priority = iota // admin, internal batch, export/import
+	priorityNormal                 // interactive writes
+	priorityHigh                   // interactive reads
+)
+
+// Saturation levels at which each priority starts being shed. High priority
+// traffic is only rejected at the hard in-flight cap.
+var shedAt = map[priority]float64{
+	priorityLow:    0.6,
+	priorityNormal: 0.85,
+	priorityHigh:   1.0,
+}
+
+// retryAfter hints lower priority callers to back off for longer.
+var retryAfter = map[priority]time.Duration{
+	priorityLow:    5 * time.Second,
+	priorityNormal: 2 * time.Second,
+	priorityHigh:   time.Second,
+}
+
+// requestPriority classifies a request by route and method.
+func requestPriority(r *http.Request) priority {
+	p := r.URL.Path
+	switch {
+	case strings.HasPrefix(p, "/api/v1/admin/"),
+		strings.HasPrefix(p, "/api/v1/internal/"),
+		strings.HasSuffix(p, "/export"),
+		strings.HasSuffix(p, "/import"):
+		return priorityLow
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return priorityHigh
+	default:
+		return priorityNormal
+	}
+}
+
+// longLived reports whether a request opens a stream (SSE or WebSocket)
+// rather than completing like a normal request.
+func longLived(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/preferences/stream") ||
+		strings.HasSuffix(r.URL.Path, "/preferences:subscribe")
+}
+
+// tokenBucket is a minimal token-bucket rate limiter.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(max(burst, 1))
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// allow takes a token if one is available. Otherwise it reports how long
+// until the next token accrues.
+func (b *tokenBucket) allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// wait takes n tokens, sleeping until each accrues. A nil bucket is
+// unlimited.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	for b != nil && n > 0 {
+		ok, d := b.allow(time.Now())
+		if ok {
+			n--
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+	return nil
+}
+
+// latencyHalfLife is how quickly the smoothed latency decays while no
+// request completes. Shedding by latency stops requests from completing, so
+// without the decay the average would never fall and shedding never end.
+const latencyHalfLife = 5 * time.Second
+
+// loadShedder tracks in-flight requests and smoothed latency to decide which
+// requests to admit.
+type loadShedder struct {
+	opts     LimitOptions
+	bucket   *tokenBucket
+	inFlight atomic.Int64
+
+	mu        sync.Mutex
+	latency   time.Duration // exponentially weighted moving average
+	sampledAt time.Time     // when latency last took a sample
+}
+
+func newLoadShedder(opts LimitOptions) *loadShedder {
+	s := &loadShedder{opts: opts}
+	if opts.RPS > 0 {
+		s.bucket = newTokenBucket(opts.RPS, opts.Burst)
+	}
+	return s
+}
+
+// tracking reports whether requests must be admitted and marked done.
+func (s *loadShedder) tracking() bool {
+	return s.opts.MaxInFlight > 0 || s.opts.LatencyTarget > 0
+}
+
+// smoothed returns the average latency as of now, decayed for the time
+// since the last sample. Callers must hold s.mu.
+func (s *loadShedder) smoothed(now time.Time) time.Duration {
+	idle := now.Sub(s.sampledAt)
+	if idle <= 0 {
+		return s.latency
+	}
+	return time.Duration(float64(s.latency) * math.Exp2(-float64(idle)/float64(latencyHalfLife)))
+}
+
+// admit reserves an in-flight slot for a request of priority p.
+func (s *loadShedder) admit(p priority, now time.Time) bool {
+	n := s.inFlight.Add(1)
+
+	var saturation float64
+	if s.opts.MaxInFlight > 0 {
+		// n includes this request, so compare the load it joins.
+		saturation = float64(n-1) / float64(s.opts.MaxInFlight)
+	}
+	if s.opts.LatencyTarget > 0 && p != priorityHigh {
+		s.mu.Lock()
+		saturation = math.Max(saturation, float64(s.smoothed(now))/float64(s.opts.LatencyTarget))
+		s.mu.Unlock()
+	}
+
+	if saturation >= shedAt[p] {
+		s.inFlight.Add(-1)
+		return false
+	}
+	return true
+}
+
+// done releases the slot and folds the latency of a request completed at
+// now into the average.
+func (s *loadShedder) done(elapsed time.Duration, now time.Time) {
+	s.inFlight.Add(-1)
+	if s.opts.LatencyTarget <= 0 {
+		return
+	}
+	s.mu.Lock()
+	avg := s.smoothed(now)
+	s.latency, s.sampledAt = avg+(elapsed-avg)/10, now
+	s.mu.Unlock()
+}
+
+// LoadLimit sheds requests with 503 once the global request rate, the number
+// of in-flight requests, or the smoothed latency exceeds its limit, protecting
+// DynamoDB during spikes. Low priority traffic (admin, batch, export) is shed
+// first so interactive reads stay fast. Health checks are never shed.
+func LoadLimit(opts LimitOptions) func(http.Handler) http.Handler {
+	s := newLoadShedder(opts)
+	tracking := s.tracking()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if s.bucket != nil {
+				if ok, wait := s.bucket.allow(time.Now()); !ok {
+					shed(w, wait)
+					return
+				}
+			}
+
+			// Streams stay open by design, so they would hold a slot
+			// and skew the latency for as long as they are connected.
+			if tracking && !longLived(r) {
+				p := requestPriority(r)
+				if !s.admit(p, time.Now()) {
+					shed(w, retryAfter[p])
+					return
+				}
+				start := time.Now()
+				defer func() { s.done(time.Since(start), time.Now()) }()
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// shed rejects a request as overloaded, hinting when to retry.
+func shed(w http.ResponseWriter, retryAfter time.Duration) {
+	secs := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+	writeError(w, http.StatusServiceUnavailable, "server overloaded, retry later")
+}
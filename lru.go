@@ -0,0 +1,75 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache is a cache of at most size entries that also expire. Adding to
+// a full cache evicts the least recently used entry, and an expired entry
+// is dropped when it is next looked up, so no call does more than constant
+// work under the lock. It is safe for concurrent use.
+type lruCache[V any] struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds *lruEntry values, most recently used first.
+	order *list.List
+}
+
+type lruEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+func newLRUCache[V any](size int) *lruCache[V] {
+	return &lruCache[V]{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns the value cached under key if it has not expired by now.
+func (c *lruCache[V]) get(key string, now time.Time) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	e := el.Value.(*lruEntry[V])
+	if !now.Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// put caches value under key until expires.
+func (c *lruCache[V]) put(key string, value V, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*lruEntry[V])
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[V]).key)
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value, expires: expires})
+}
+
+// len returns the number of entries, expired ones included.
+func (c *lruCache[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Now()
+	c := newLRUCache[int](2)
+	c.put("a", 1, now.Add(time.Minute))
+	c.put("b", 2, now.Add(time.Minute))
+	if v, ok := c.get("a", now); !ok || v != 1 {
+		t.Fatalf("expected a cached, got %d %v", v, ok)
+	}
+
+	// b is now the least recently used.
+	c.put("c", 3, now.Add(time.Minute))
+	if _, ok := c.get("b", now); ok || c.len() != 2 {
+		t.Fatalf("expected b evicted, leaving 2 entries, got %d", c.len())
+	}
+
+	c.put("a", 4, now.Add(time.Second))
+	if v, ok := c.get("a", now); !ok || v != 4 {
+		t.Fatalf("expected a replaced, got %d %v", v, ok)
+	}
+	if _, ok := c.get("a", now.Add(time.Second)); ok || c.len() != 1 {
+		t.Fatalf("expected a expired and dropped on lookup, leaving %d", c.len())
+	}
+}
@@ -2,15 +2,28 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
 func main() {
+	// Installed under the name prefsctl, the binary is the support CLI.
+	if filepath.Base(os.Args[0]) == "prefsctl" {
+		os.Exit(runCtl(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] != "serve" {
+		os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	cfg, err := LoadConfig()
 	if err != nil {
 		slog.Error("failed to load config", "error", err)
@@ -21,14 +34,286 @@ func main() {
 		Level: cfg.LogLevel,
 	}))
 
+	if lambdaBuild {
+		if err := checkLambdaConfig(cfg); err != nil {
+			logger.Error("invalid configuration for Lambda", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.DevBypassAuth {
+		nets := "loopback and private networks"
+		if len(cfg.DevBypassNets) > 0 {
+			nets = fmt.Sprint(cfg.DevBypassNets)
+		}
+		logger.Warn("DEV_BYPASS_AUTH IS ENABLED: requests from " + nets + " are NOT authenticated. Never run this in production.")
+	}
+
+	if cfg.JWTPreflight != PreflightOff {
+		report := RunPreflight(context.Background(), cfg, &http.Client{Timeout: 5 * time.Second})
+		for _, c := range report.Checks {
+			if c.OK {
+				logger.Info("auth preflight passed", "check", c.Name)
+			} else {
+				logger.Warn("AUTH PREFLIGHT FAILED: tokens will likely be rejected with 401", "check", c.Name, "detail", c.Detail)
+			}
+		}
+		if !report.OK && cfg.JWTPreflight == PreflightStrict {
+			logger.Error("refusing to start: auth preflight failed (JWT_PREFLIGHT=strict)")
+			os.Exit(1)
+		}
+	}
+
 	store, err := NewDynamoStore(context.Background(), cfg)
 	if err != nil {
 		logger.Error("failed to create DynamoDB store", "error", err)
 		os.Exit(1)
 	}
 
-	handler := NewPreferencesHandler(store, logger)
-	router := NewRouter(handler, cfg, logger)
+	runCtx, stopRun := context.WithCancel(context.Background())
+	defer stopRun()
+
+	go RefreshSecrets(runCtx, cfg, logger)
+
+	if cfg.JWKSURL != "" && cfg.AuthMode == AuthModeJWT {
+		cfg.JWKS = NewJWKSCache(JWKSOptions{URL: cfg.JWKSURL, RefreshInterval: cfg.JWKSRefresh, Logger: logger})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := cfg.JWKS.Refresh(ctx); err != nil {
+			// Keep starting: the background refresh retries, and until it
+			// succeeds tokens signed with JWKS keys are rejected.
+			logger.Error("initial JWKS fetch failed", "error", err)
+		}
+		cancel()
+		go cfg.JWKS.Run(runCtx)
+	}
+
+	var prefsStore Store = store
+	var failover *FailoverStore
+	var standbyStore Store
+	if cfg.StandbyTableName != "" {
+		standby, err := newStandbyStore(context.Background(), cfg)
+		if err != nil {
+			logger.Error("failed to create standby store", "error", err)
+			os.Exit(1)
+		}
+
+		replication := NewStandbyReplicator(standby.client, standby.tableName, logger)
+		failover = NewFailoverStore(store, standby, replication, FailoverPolicy{
+			Auto:              cfg.FailoverAuto,
+			Threshold:         cfg.FailoverThreshold,
+			RetryPrimaryAfter: cfg.FailoverRetry,
+		}, logger)
+		go failover.Run(runCtx)
+		prefsStore, standbyStore = failover, standby
+		logger.Info("standby store enabled", "table", cfg.StandbyTableName, "region", cfg.StandbyRegion, "auto", cfg.FailoverAuto)
+	}
+
+	var trash Store
+	if cfg.SoftDeleteRetention > 0 {
+		trash = store.TrashPreferences(cfg.SoftDeleteRetention)
+		logger.Info("soft delete enabled", "retention", cfg.SoftDeleteRetention)
+	}
+	var undo Store
+	if cfg.UndoWindow > 0 {
+		undo = store.UndoSnapshots(cfg.UndoWindow)
+		logger.Info("undo enabled", "window", cfg.UndoWindow)
+	}
+	devices := store.DevicePreferences()
+
+	if len(cfg.SensitiveKeys) > 0 {
+		kmsClient, err := NewKMSClient(context.Background(), cfg)
+		if err != nil {
+			logger.Error("failed to create KMS client", "error", err)
+			os.Exit(1)
+		}
+		prefsStore = NewEncryptingStore(prefsStore, kmsClient, cfg.KMSKeyID, cfg.SensitiveKeys)
+		if trash != nil {
+			trash = NewEncryptingStore(trash, kmsClient, cfg.KMSKeyID, cfg.SensitiveKeys)
+		}
+		if undo != nil {
+			undo = NewEncryptingStore(undo, kmsClient, cfg.KMSKeyID, cfg.SensitiveKeys)
+		}
+		devices = NewEncryptingStore(devices, kmsClient, cfg.KMSKeyID, cfg.SensitiveKeys)
+		logger.Info("field encryption enabled", "keys", cfg.SensitiveKeys)
+	}
+
+	var history *DynamoHistory
+	if cfg.HistoryTableName != "" {
+		// Outside the encrypting store, so sensitive keys are seen in
+		// plaintext and must be excluded.
+		history = NewDynamoHistory(store, cfg.HistoryTableName, cfg.HistoryRetention)
+		prefsStore = NewHistoryRecorder(prefsStore, history, cfg.SensitiveKeys)
+		logger.Info("preference history enabled", "table", cfg.HistoryTableName, "retention", cfg.HistoryRetention)
+	}
+
+	hooks, err := NewWebhookStore(context.Background(), cfg, store)
+	if err != nil {
+		logger.Error("failed to create webhook store", "error", err)
+		os.Exit(1)
+	}
+	if cfg.KMSKeyID == "" {
+		logger.Warn("KMS_KEY_ID is not set; webhook secrets are stored unencrypted")
+	}
+	webhooks := NewWebhookDispatcher(hooks, cfg.WebhookRefresh, cfg.EventSource, logger)
+	changeBus := NewChangeBus()
+	sinks := []ChangeSink{changeBus}
+	var brokers []*AsyncSink
+	// Correction events always come from here (see NewCorrectionsHandler).
+	webhooks.Start(runCtx)
+	// With CHANGE_EVENTS=stream, the stream worker delivers preference
+	// changes to webhooks and brokers instead.
+	if cfg.ChangeEvents == ChangeEventsAPI {
+		brokers, err = NewBrokerSinks(context.Background(), cfg, logger)
+		if err != nil {
+			logger.Error("failed to set up change event publishing", "error", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, webhooks)
+		for _, b := range brokers {
+			sinks = append(sinks, b)
+		}
+	}
+	// Also outside the encrypting store; sensitive keys are not published.
+	prefsStore = NewChangePublisher(prefsStore, cfg.SensitiveKeys, logger, sinks...)
+
+	var defaults *Defaults
+	switch {
+	case cfg.DefaultsFile != "":
+		defaults = NewDefaults(FileDefaults{Path: cfg.DefaultsFile}, 0, logger)
+	case cfg.DefaultsFromTable:
+		defaults = NewDefaults(store, cfg.DefaultsRefresh, logger)
+	}
+	if defaults != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := defaults.Refresh(ctx)
+		cancel()
+		if err != nil {
+			logger.Error("failed to load default preferences", "error", err)
+			os.Exit(1)
+		}
+		go defaults.Run(runCtx)
+	}
+
+	var schema *SchemaRegistry
+	switch {
+	case cfg.SchemaFile != "":
+		schema = NewSchemaRegistry(FileSchema{Path: cfg.SchemaFile}, 0, logger)
+	case cfg.SchemaFromTable:
+		schema = NewSchemaRegistry(StoredSchema{Store: store.SchemaPreferences()}, cfg.SchemaRefresh, logger)
+	}
+	if schema != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := schema.Refresh(ctx)
+		cancel()
+		if err != nil {
+			logger.Error("failed to load preference schema", "error", err)
+			os.Exit(1)
+		}
+		go schema.Run(runCtx)
+	}
+	var valueSchemas *ValueSchemas
+	if cfg.ValueSchemaFile != "" {
+		if valueSchemas, err = LoadValueSchemas(cfg.ValueSchemaFile); err != nil {
+			logger.Error("failed to load value schemas", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	var templates map[string]map[string]any
+	if cfg.TemplatesFile != "" {
+		if templates, err = LoadTemplates(cfg.TemplatesFile); err != nil {
+			logger.Error("failed to load templates", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	handler := NewPreferencesHandler(prefsStore, logger, HandlerOptions{
+		MaxResponseBytes:       cfg.MaxResponseBytes,
+		SensitiveKeys:          cfg.SensitiveKeys,
+		ConcealForbidden:       cfg.ConcealForbidden,
+		RequireIfMatch:         cfg.RequireIfMatch,
+		MaxBatchUsers:          cfg.BatchMaxUsers,
+		MaxPatchKeys:           cfg.PatchMaxKeys,
+		ReservedKeyPrefixes:    cfg.ReservedKeyPrefixes,
+		AllowedKeys:            cfg.AllowedKeys,
+		DropUnknownKeys:        cfg.DropUnknownKeys,
+		UnknownUsersNotFound:   cfg.UnknownUsersNotFound,
+		RejectDeprecatedWrites: cfg.RejectDeprecatedWrites,
+		Quota: Quota{
+			MaxKeys:       cfg.MaxKeysPerUser,
+			MaxKeyLength:  cfg.MaxKeyLength,
+			MaxValueBytes: cfg.MaxValueBytes,
+			MaxItemBytes:  cfg.MaxItemBytes,
+		},
+		Defaults:       defaults,
+		Schema:         schema,
+		ValueSchemas:   valueSchemas,
+		Trash:          trash,
+		TrashRetention: cfg.SoftDeleteRetention,
+		Undo:           undo,
+		UndoWindow:     cfg.UndoWindow,
+		Templates:      templates,
+	})
+	if err := handler.checkTemplates(); err != nil {
+		logger.Error("invalid template", "error", err)
+		os.Exit(1)
+	}
+	audit, err := OpenAuditLog(cfg.AuditLogFile)
+	if err != nil {
+		logger.Error("failed to open audit log", "error", err)
+		os.Exit(1)
+	}
+
+	hs := Handlers{
+		Prefs:       handler,
+		Corrections: NewCorrectionsHandler(handler, store, webhooks),
+		Audit:       audit,
+		Layers:      NewLayersHandler(handler, store),
+		Devices:     NewDevicesHandler(handler, devices, store.DeviceIndex(), store),
+		Webhooks:    NewWebhooksHandler(handler, hooks, webhooks),
+		Stream:      NewStreamHandler(handler, changeBus),
+		Sync:        NewSyncHandler(handler, changeBus, cfg.CORSAllowOrigin, cfg.JWTCookieName != ""),
+		Search:      NewSearchHandler(handler, store),
+		Stats:       NewStatsHandler(store, logger),
+	}
+	if failover != nil {
+		hs.Failover = NewFailoverHandler(failover)
+	}
+	if history != nil {
+		hs.History = NewHistoryHandler(handler, history, cfg.HistoryRetention)
+		hs.Erase = NewEraseHandler(handler, store, store, store, history, hs.Devices, standbyStore)
+	} else {
+		hs.Erase = NewEraseHandler(handler, store, store, store, nil, hs.Devices, standbyStore)
+	}
+	if cfg.IdempotencyTTL > 0 {
+		hs.Idempotency = store
+	}
+	if cfg.ExperimentsFile != "" {
+		experiments, err := LoadExperiments(cfg.ExperimentsFile)
+		if err != nil {
+			logger.Error("failed to load experiments", "error", err)
+			os.Exit(1)
+		}
+		hs.Experiments = NewExperimentsHandler(handler, experiments)
+	}
+	if cfg.SchemaFromTable {
+		hs.Schema = NewSchemaHandler(handler, store.SchemaPreferences(), schema)
+	}
+	if lambdaBuild {
+		// Responses are buffered, and each instance only sees its own
+		// invocations' writes.
+		hs.Stream, hs.Sync = nil, nil
+	}
+	router := NewRouter(hs, cfg, logger)
+
+	if lambdaBuild {
+		if cfg.ChangeEvents == ChangeEventsAPI && (webhooks.Listening() || len(brokers) > 0) {
+			logger.Warn("change events are delivered in the background, which Lambda freezes between invocations; set CHANGE_EVENTS=stream and run the stream worker")
+		}
+		logger.Info("serving Lambda invocations", "authMode", cfg.AuthMode)
+		startLambda(router)
+		return
+	}
 
 	srv := &http.Server{
 		Addr:         ":" + cfg.ServerPort,
@@ -38,14 +323,65 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
-	// Start server in a goroutine
-	go func() {
-		logger.Info("server starting", "port", cfg.ServerPort)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error("server failed", "error", err)
+	useTLS := cfg.TLSCertFile != ""
+	if useTLS {
+		tlsCfg, err := serverTLSConfig(cfg)
+		if err != nil {
+			logger.Error("failed to configure TLS", "error", err)
+			os.Exit(1)
+		}
+		srv.TLSConfig = tlsCfg
+	}
+	// End open change streams so Shutdown does not wait for them.
+	srv.RegisterOnShutdown(changeBus.Close)
+
+	servers := []*http.Server{srv}
+	if cfg.AdminPort != "" {
+		adminSrv := &http.Server{
+			Addr:         ":" + cfg.AdminPort,
+			Handler:      NewAdminRouter(hs, cfg, logger),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			IdleTimeout:  60 * time.Second,
+			TLSConfig:    srv.TLSConfig,
+		}
+		servers = append(servers, adminSrv)
+	}
+
+	// Start servers in goroutines
+	for _, s := range servers {
+		go func() {
+			logger.Info("server starting", "addr", s.Addr, "tls", useTLS, "authMode", cfg.AuthMode)
+			var err error
+			if useTLS {
+				err = s.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+			} else {
+				err = s.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				logger.Error("server failed", "addr", s.Addr, "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// The gRPC API shares the preferences handler and auth, on its own listener
+	var grpcSrv *grpc.Server
+	if cfg.GRPCPort != "" {
+		lis, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+		if err != nil {
+			logger.Error("failed to listen for gRPC", "error", err)
 			os.Exit(1)
 		}
-	}()
+		grpcSrv = NewGRPCListener(NewGRPCServer(hs, cfg, logger), srv.TLSConfig)
+		go func() {
+			logger.Info("gRPC server starting", "addr", lis.Addr().String(), "tls", useTLS)
+			if err := grpcSrv.Serve(lis); err != nil {
+				logger.Error("gRPC server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	// Graceful shutdown on SIGINT/SIGTERM
 	quit := make(chan os.Signal, 1)
@@ -56,9 +392,18 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		logger.Error("shutdown error", "error", err)
-		os.Exit(1)
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			logger.Error("shutdown error", "addr", s.Addr, "error", err)
+			os.Exit(1)
+		}
+	}
+	// No more writes: publish what is queued.
+	for _, b := range brokers {
+		b.Close(ctx)
 	}
 
 	logger.Info("server stopped")
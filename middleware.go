@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"crypto/tls"
+	"errors"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,11 +17,222 @@ import (
 
 type contextKey int
 
-const claimsKey contextKey = iota
+const (
+	claimsKey contextKey = iota
+	identityKey
+	auditKey
+)
+
+// Scopes granted to service principals.
+const (
+	ScopeRead  = "prefs:read"
+	ScopeWrite = "prefs:write"
+	ScopeAdmin = "prefs:admin"
+)
+
+// PrincipalKind distinguishes end-user (browser) tokens from service tokens.
+type PrincipalKind int
+
+const (
+	PrincipalUser PrincipalKind = iota
+	PrincipalService
+)
 
 // Claims holds the JWT claims we care about.
 type Claims struct {
 	Subject string
+	Kind    PrincipalKind
+	Scopes  []string
+
+	// Actor is the party acting on Subject's behalf, from an RFC 8693 "act"
+	// claim (e.g. a support agent). Empty for ordinary tokens.
+	Actor string
+}
+
+// HasScope reports whether the claims grant the given scope.
+func (c Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// AudiencePolicy maps JWT audiences to principal kinds. Tokens for a browser
+// audience must act on their own userId; tokens for a service audience are
+// authorized by scope instead. When both lists are empty, audiences are not
+// checked and every token is treated as a browser token.
+type AudiencePolicy struct {
+	Browser []string
+	Service []string
+}
+
+func (p AudiencePolicy) enabled() bool {
+	return len(p.Browser) > 0 || len(p.Service) > 0
+}
+
+// classify returns the principal kind for the given token audiences.
+func (p AudiencePolicy) classify(aud []string) (PrincipalKind, bool) {
+	if !p.enabled() {
+		return PrincipalUser, true
+	}
+	for _, a := range aud {
+		if slices.Contains(p.Service, a) {
+			return PrincipalService, true
+		}
+	}
+	for _, a := range aud {
+		if slices.Contains(p.Browser, a) {
+			return PrincipalUser, true
+		}
+	}
+	return 0, false
+}
+
+// JWTOptions configures the JWTAuth middleware.
+type JWTOptions struct {
+	Secret    string
+	Issuer    string
+	DevBypass bool
+	// DevBypassNets limits DevBypass to clients in these networks; empty
+	// means loopback and private (RFC 1918 / RFC 4193) addresses.
+	DevBypassNets []netip.Prefix
+	Audiences     AudiencePolicy
+	// CookieName, if set, names a cookie read for the token when the request
+	// has no Authorization header (e.g. an HttpOnly session cookie).
+	CookieName string
+	// Actors lists the subjects allowed to act on a user's behalf via an
+	// RFC 8693 "act" claim; "*" allows any. Delegated tokens are rejected
+	// when empty.
+	Actors []string
+	// SecretFunc, if set, is consulted on every request in place of Secret
+	// so a rotated secret takes effect immediately.
+	SecretFunc func() string
+	// Algorithms lists the accepted signing algorithms; empty means HS256
+	// only. PublicKeys and JWKS verify the asymmetric algorithms.
+	Algorithms []string
+	PublicKeys []crypto.PublicKey
+	JWKS       *JWKSCache
+}
+
+func (o JWTOptions) actorAllowed(actor string) bool {
+	if actor == "" {
+		return false
+	}
+	return slices.Contains(o.Actors, "*") || slices.Contains(o.Actors, actor)
+}
+
+// defaultDevBypassNets are the networks DevBypass trusts unless configured.
+var defaultDevBypassNets = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+// bypassAllowed reports whether a client at remoteAddr is on a network
+// trusted for DevBypass.
+func (o JWTOptions) bypassAllowed(remoteAddr string) bool {
+	addr, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	nets := o.DevBypassNets
+	if len(nets) == 0 {
+		nets = defaultDevBypassNets
+	}
+	ip := addr.Addr().Unmap()
+	return slices.ContainsFunc(nets, func(p netip.Prefix) bool { return p.Contains(ip) })
+}
+
+// Credentials are what a caller presents to authenticate, read from an HTTP
+// request (httpCredentials) or from a gRPC call (grpcCredentials).
+type Credentials struct {
+	// Authorization is the Authorization header or metadata.
+	Authorization string
+	// Cookie returns the value of the named cookie, or "". It is nil for
+	// gRPC calls, which carry no cookies.
+	Cookie func(name string) string
+	// TLS is the connection state, with any verified client certificate.
+	TLS        *tls.ConnectionState
+	RemoteAddr string
+	// UserID is the user acted on, which DevBypass takes as the subject.
+	UserID string
+}
+
+// httpCredentials reads the credentials of a request.
+func httpCredentials(r *http.Request) Credentials {
+	return Credentials{
+		Authorization: r.Header.Get("Authorization"),
+		Cookie: func(name string) string {
+			if c, err := r.Cookie(name); err == nil {
+				return c.Value
+			}
+			return ""
+		},
+		TLS:        r.TLS,
+		RemoteAddr: r.RemoteAddr,
+		UserID:     r.PathValue("userId"),
+	}
+}
+
+// bearer extracts the raw token from the Authorization header, falling back
+// to the named cookie when the header is absent.
+func (c Credentials) bearer(cookieName string) (string, error) {
+	if c.Authorization == "" {
+		if cookieName != "" && c.Cookie != nil {
+			if v := c.Cookie(cookieName); v != "" {
+				return v, nil
+			}
+		}
+		return "", errors.New("missing authorization header")
+	}
+
+	parts := strings.SplitN(c.Authorization, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", errors.New("invalid authorization header format")
+	}
+
+	return parts[1], nil
+}
+
+// Authenticator verifies a caller's credentials and returns its claims. A
+// caller that is rejected gets an *authError; any other error means the
+// credentials could not be checked.
+type Authenticator func(ctx context.Context, c Credentials) (Claims, error)
+
+// authError rejects a caller with Status (401 or 403). Subject and Actor
+// name a principal that was identified but is not accepted, for the audit
+// log.
+type authError struct {
+	Status         int
+	Reason         string
+	Subject, Actor string
+}
+
+func (e *authError) Error() string { return e.Reason }
+
+func unauthenticated(reason string) error {
+	return &authError{Status: http.StatusUnauthorized, Reason: reason}
+}
+
+// authenticate serves an Authenticator as middleware, storing the caller's
+// claims in context. Rejected callers are denied and audited; credentials
+// that cannot be checked are a 503.
+func authenticate(auth Authenticator) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, err := auth(r.Context(), httpCredentials(r))
+			var denied *authError
+			if errors.As(err, &denied) {
+				denyPrincipal(w, r, denied.Status, denied.Reason, denied.Subject, denied.Actor)
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(contextWithClaims(r.Context(), claims)))
+		}
+	}
 }
 
 // ClaimsFromContext extracts JWT claims stored by the auth middleware.
@@ -25,6 +241,15 @@ func ClaimsFromContext(ctx context.Context) (Claims, bool) {
 	return c, ok
 }
 
+// contextWithClaims stores the authenticated claims for handlers and records
+// them for the enclosing RequestLogging entry.
+func contextWithClaims(ctx context.Context, c Claims) context.Context {
+	if slot, ok := ctx.Value(identityKey).(*Claims); ok {
+		*slot = c
+	}
+	return context.WithValue(ctx, claimsKey, c)
+}
+
 // Recovery catches panics and returns 500 instead of crashing.
 func Recovery(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -46,7 +271,8 @@ func CORS(allowOrigin string) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-CSRF-Token, If-Match, If-None-Match, If-Modified-Since, Idempotency-Key, Prefer")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Idempotent-Replayed, Undo-Token, Preference-Applied")
 
 			if r.Method == http.MethodOptions {
 				w.WriteHeader(http.StatusNoContent)
@@ -69,74 +295,149 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streams.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // RequestLogging logs every request with method, path, status, and duration.
 func RequestLogging(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 			rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			identity := new(Claims)
 
-			next.ServeHTTP(rw, r)
+			next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), identityKey, identity)))
 
-			logger.Info("request",
+			attrs := []any{
 				"method", r.Method,
 				"path", r.URL.Path,
 				"status", rw.statusCode,
 				"duration", time.Since(start).String(),
-			)
+			}
+			if identity.Subject != "" {
+				attrs = append(attrs, "subject", identity.Subject)
+			}
+			if identity.Actor != "" {
+				attrs = append(attrs, "actor", identity.Actor)
+			}
+			logger.Info("request", attrs...)
 		})
 	}
 }
 
 // JWTAuth wraps a handler to validate Bearer tokens and store claims in context.
-// When devBypass is true, authentication is skipped and the userId path param
-// is used as the subject claim (for local development only).
-func JWTAuth(secret string, issuer string, devBypass bool) func(http.HandlerFunc) http.HandlerFunc {
-	return func(next http.HandlerFunc) http.HandlerFunc {
-		return func(w http.ResponseWriter, r *http.Request) {
-			if devBypass {
-				userID := r.PathValue("userId")
-				ctx := context.WithValue(r.Context(), claimsKey, Claims{Subject: userID})
-				next.ServeHTTP(w, r.WithContext(ctx))
-				return
-			}
+// When opts.DevBypass is true, authentication is skipped for clients on a
+// local network and the userId path param is used as the subject claim (for
+// local development only). Other clients must still present a token.
+func JWTAuth(opts JWTOptions) func(http.HandlerFunc) http.HandlerFunc {
+	return authenticate(opts.authenticate)
+}
 
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				writeError(w, http.StatusUnauthorized, "missing authorization header")
-				return
-			}
+// authenticate is the Authenticator behind JWTAuth.
+func (o JWTOptions) authenticate(_ context.Context, c Credentials) (Claims, error) {
+	if o.DevBypass && o.bypassAllowed(c.RemoteAddr) {
+		return Claims{Subject: c.UserID}, nil
+	}
 
-			parts := strings.SplitN(authHeader, " ", 2)
-			if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-				writeError(w, http.StatusUnauthorized, "invalid authorization header format")
-				return
-			}
+	tokenStr, err := c.bearer(o.CookieName)
+	if err != nil {
+		return Claims{}, unauthenticated(err.Error())
+	}
 
-			tokenStr := parts[1]
+	parserOpts := []jwt.ParserOption{jwt.WithValidMethods(o.algorithms())}
+	if o.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(o.Issuer))
+	}
 
-			parserOpts := []jwt.ParserOption{jwt.WithValidMethods([]string{"HS256"})}
-			if issuer != "" {
-				parserOpts = append(parserOpts, jwt.WithIssuer(issuer))
-			}
+	token, err := jwt.Parse(tokenStr, o.keyFunc, parserOpts...)
+
+	if err != nil || !token.Valid {
+		return Claims{}, unauthenticated("invalid or expired token")
+	}
 
-			token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (any, error) {
-				return []byte(secret), nil
-			}, parserOpts...)
+	sub, err := token.Claims.GetSubject()
+	if err != nil || sub == "" {
+		return Claims{}, unauthenticated("token missing subject claim")
+	}
 
-			if err != nil || !token.Valid {
-				writeError(w, http.StatusUnauthorized, "invalid or expired token")
+	aud, _ := token.Claims.GetAudience()
+	kind, ok := o.Audiences.classify(aud)
+	if !ok {
+		return Claims{}, unauthenticated("token audience not accepted")
+	}
+
+	claims := Claims{Subject: sub, Kind: kind}
+	if kind == PrincipalService {
+		claims.Scopes = scopesFromToken(token)
+	}
+
+	if actor, ok := actorFromToken(token); ok {
+		if !o.actorAllowed(actor) {
+			return Claims{}, &authError{Status: http.StatusUnauthorized, Reason: "delegated token actor not accepted", Subject: sub, Actor: actor}
+		}
+		claims.Actor = actor
+	}
+	return claims, nil
+}
+
+// RequireScope restricts a handler to service principals holding scope.
+// Browser tokens are always rejected, whatever their claims.
+func RequireScope(scope string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				deny(w, r, http.StatusUnauthorized, "missing claims")
 				return
 			}
-
-			sub, err := token.Claims.GetSubject()
-			if err != nil || sub == "" {
-				writeError(w, http.StatusUnauthorized, "token missing subject claim")
+			if claims.Kind != PrincipalService || !claims.HasScope(scope) {
+				deny(w, r, http.StatusForbidden, "insufficient scope")
 				return
 			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
 
-			ctx := context.WithValue(r.Context(), claimsKey, Claims{Subject: sub})
-			next.ServeHTTP(w, r.WithContext(ctx))
+// scopesFromToken reads the space-delimited "scope" claim, falling back to
+// the array-valued "scp" claim used by some identity providers.
+func scopesFromToken(token *jwt.Token) []string {
+	mc, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	if s, ok := mc["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+	if arr, ok := mc["scp"].([]any); ok {
+		scopes := make([]string, 0, len(arr))
+		for _, v := range arr {
+			if s, ok := v.(string); ok {
+				scopes = append(scopes, s)
+			}
 		}
+		return scopes
+	}
+	return nil
+}
+
+// actorFromToken returns the current actor from an RFC 8693 "act" claim. Nested
+// "act" objects name prior actors in the delegation chain and are not
+// consulted. ok is true whenever the claim is present, so a malformed claim
+// yields ("", true) and is rejected rather than ignored.
+func actorFromToken(token *jwt.Token) (string, bool) {
+	mc, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", false
+	}
+	raw, ok := mc["act"]
+	if !ok {
+		return "", false
 	}
+	act, _ := raw.(map[string]any)
+	sub, _ := act["sub"].(string)
+	return sub, true
 }
@@ -2,9 +2,27 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -27,6 +45,12 @@ func makeTokenWithExp(sub string, secret string, exp time.Time) string {
 	return s
 }
 
+func makeTokenWithClaims(claims jwt.MapClaims, secret string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	s, _ := token.SignedString([]byte(secret))
+	return s
+}
+
 // jwtTestMux creates a mux with a single route so that PathValue is populated.
 func jwtTestMux(auth func(http.HandlerFunc) http.HandlerFunc, inner http.HandlerFunc) *http.ServeMux {
 	mux := http.NewServeMux()
@@ -36,7 +60,7 @@ func jwtTestMux(auth func(http.HandlerFunc) http.HandlerFunc, inner http.Handler
 
 func TestJWTAuth_ValidToken(t *testing.T) {
 	token := makeToken("user1", testSecret, jwt.SigningMethodHS256)
-	auth := JWTAuth(testSecret, "", false)
+	auth := JWTAuth(JWTOptions{Secret: testSecret})
 
 	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		claims, ok := ClaimsFromContext(r.Context())
@@ -61,7 +85,7 @@ func TestJWTAuth_ValidToken(t *testing.T) {
 }
 
 func TestJWTAuth_MissingHeader(t *testing.T) {
-	auth := JWTAuth(testSecret, "", false)
+	auth := JWTAuth(JWTOptions{Secret: testSecret})
 
 	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Fatal("handler should not be called")
@@ -78,7 +102,7 @@ func TestJWTAuth_MissingHeader(t *testing.T) {
 }
 
 func TestJWTAuth_InvalidToken(t *testing.T) {
-	auth := JWTAuth(testSecret, "", false)
+	auth := JWTAuth(JWTOptions{Secret: testSecret})
 
 	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Fatal("handler should not be called")
@@ -97,7 +121,7 @@ func TestJWTAuth_InvalidToken(t *testing.T) {
 
 func TestJWTAuth_WrongSecret(t *testing.T) {
 	token := makeToken("user1", "wrong-secret", jwt.SigningMethodHS256)
-	auth := JWTAuth(testSecret, "", false)
+	auth := JWTAuth(JWTOptions{Secret: testSecret})
 
 	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Fatal("handler should not be called")
@@ -116,7 +140,7 @@ func TestJWTAuth_WrongSecret(t *testing.T) {
 
 func TestJWTAuth_ExpiredToken(t *testing.T) {
 	token := makeTokenWithExp("user1", testSecret, time.Now().Add(-1*time.Hour))
-	auth := JWTAuth(testSecret, "", false)
+	auth := JWTAuth(JWTOptions{Secret: testSecret})
 
 	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Fatal("handler should not be called")
@@ -134,7 +158,7 @@ func TestJWTAuth_ExpiredToken(t *testing.T) {
 }
 
 func TestJWTAuth_BadFormat(t *testing.T) {
-	auth := JWTAuth(testSecret, "", false)
+	auth := JWTAuth(JWTOptions{Secret: testSecret})
 
 	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Fatal("handler should not be called")
@@ -157,7 +181,7 @@ func TestJWTAuth_IssuerValidation(t *testing.T) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenStr, _ := token.SignedString([]byte(testSecret))
 
-	auth := JWTAuth(testSecret, "expected-issuer", false)
+	auth := JWTAuth(JWTOptions{Secret: testSecret, Issuer: "expected-issuer"})
 
 	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Fatal("handler should not be called")
@@ -175,7 +199,7 @@ func TestJWTAuth_IssuerValidation(t *testing.T) {
 }
 
 func TestJWTAuth_DevBypass(t *testing.T) {
-	auth := JWTAuth(testSecret, "", true)
+	auth := JWTAuth(JWTOptions{Secret: testSecret, DevBypass: true})
 
 	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		claims, ok := ClaimsFromContext(r.Context())
@@ -190,6 +214,7 @@ func TestJWTAuth_DevBypass(t *testing.T) {
 
 	mux := jwtTestMux(auth, inner)
 	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
 	// No Authorization header — bypass should skip validation
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
@@ -199,6 +224,351 @@ func TestJWTAuth_DevBypass(t *testing.T) {
 	}
 }
 
+func TestJWTAuth_DevBypassRestrictedToLocalNetworks(t *testing.T) {
+	inner := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	cases := []struct {
+		nets   []netip.Prefix
+		remote string
+		want   int
+	}{
+		{nil, "10.1.2.3:5000", http.StatusOK},
+		{nil, "[::1]:5000", http.StatusOK},
+		{nil, "203.0.113.7:5000", http.StatusUnauthorized},
+		{[]netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}, "203.0.113.7:5000", http.StatusOK},
+		{[]netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}, "127.0.0.1:5000", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		mux := jwtTestMux(JWTAuth(JWTOptions{Secret: testSecret, DevBypass: true, DevBypassNets: tc.nets}), inner)
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		req.RemoteAddr = tc.remote
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("nets %v, remote %s: expected %d, got %d", tc.nets, tc.remote, tc.want, w.Code)
+		}
+	}
+}
+
+func TestJWTAuth_CookieFallback(t *testing.T) {
+	token := makeToken("user1", testSecret, jwt.SigningMethodHS256)
+	auth := JWTAuth(JWTOptions{Secret: testSecret, CookieName: "session"})
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok || claims.Subject != "user1" {
+			t.Fatalf("expected sub=user1 from cookie, got %+v", claims)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux := jwtTestMux(auth, inner)
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: token})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+}
+
+func TestJWTAuth_ServiceAudience(t *testing.T) {
+	token := makeTokenWithClaims(jwt.MapClaims{
+		"sub":   "notifier",
+		"aud":   "prefs-internal",
+		"scope": "prefs:read prefs:write",
+	}, testSecret)
+	auth := JWTAuth(JWTOptions{
+		Secret:    testSecret,
+		Audiences: AudiencePolicy{Browser: []string{"prefs-web"}, Service: []string{"prefs-internal"}},
+	})
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		if claims.Kind != PrincipalService {
+			t.Fatalf("expected service principal, got %v", claims.Kind)
+		}
+		if !claims.HasScope(ScopeRead) || !claims.HasScope(ScopeWrite) {
+			t.Fatalf("expected read and write scopes, got %v", claims.Scopes)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux := jwtTestMux(auth, inner)
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+}
+
+func TestJWTAuth_UnknownAudience(t *testing.T) {
+	token := makeTokenWithClaims(jwt.MapClaims{"sub": "user1", "aud": "someone-else"}, testSecret)
+	auth := JWTAuth(JWTOptions{
+		Secret:    testSecret,
+		Audiences: AudiencePolicy{Browser: []string{"prefs-web"}},
+	})
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called")
+	})
+
+	mux := jwtTestMux(auth, inner)
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestRequireScope_RejectsBrowserToken(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called")
+	})
+	handler := RequireScope(ScopeAdmin)(inner)
+
+	// A browser token is rejected even if it somehow carries the scope.
+	req := httptest.NewRequest("GET", "/api/v1/admin/test", nil)
+	req = req.WithContext(context.WithValue(req.Context(), claimsKey, Claims{
+		Subject: "user1",
+		Kind:    PrincipalUser,
+		Scopes:  []string{ScopeAdmin},
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+}
+
+func TestMTLSAuth(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://mesh/ns/prod/sa/notifier")
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "notifier"}, URIs: []*url.URL{spiffe}}
+
+	auth := MTLSAuth(MTLSOptions{
+		AllowedIdentities: []string{"spiffe://mesh/ns/prod/sa/notifier"},
+		Scopes:            []string{ScopeRead},
+	})
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		if claims.Kind != PrincipalService || claims.Subject != spiffe.String() {
+			t.Fatalf("unexpected claims: %+v", claims)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux := jwtTestMux(auth, inner)
+
+	// Verified certificate with an allowed identity
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	// No client certificate
+	req = httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without certificate, got %d", w.Code)
+	}
+
+	// Certificate identity not on the allow-list
+	other := &x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}}
+	req = httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{other}}}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for unlisted identity, got %d", w.Code)
+	}
+}
+
+func TestIntrospectionAuth_CachesResults(t *testing.T) {
+	var calls atomic.Int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		r.ParseForm()
+		if r.PostForm.Get("token") == "good-token" {
+			fmt.Fprint(w, `{"active":true,"sub":"user1","exp":`+strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)+`}`)
+			return
+		}
+		fmt.Fprint(w, `{"active":false}`)
+	}))
+	defer idp.Close()
+
+	auth := IntrospectionAuth(NewIntrospector(IntrospectionOptions{URL: idp.URL, CacheTTL: time.Minute}))
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		if claims.Subject != "user1" {
+			t.Fatalf("expected sub=user1, got %s", claims.Subject)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux := jwtTestMux(auth, inner)
+
+	for range 2 {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		req.Header.Set("Authorization", "Bearer good-token")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 introspection call with caching, got %d", calls.Load())
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req.Header.Set("Authorization", "Bearer revoked-token")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for inactive token, got %d", w.Code)
+	}
+}
+
+// rewriteTransport sends every request to a test server, keeping the path and query.
+type rewriteTransport struct{ target *url.URL }
+
+func (rt rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = rt.target.Scheme, rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestSigV4Auth(t *testing.T) {
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("X-Amz-Signature") != "good" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"GetCallerIdentityResponse":{"GetCallerIdentityResult":{"Arn":"arn:aws:sts::123456789012:assumed-role/notifier-lambda/session-1"}}}`)
+	}))
+	defer sts.Close()
+	target, _ := url.Parse(sts.URL)
+
+	fallbackCalled := false
+	fallback := func(context.Context, Credentials) (Claims, error) {
+		fallbackCalled = true
+		return Claims{}, &authError{Status: http.StatusTeapot, Reason: "fallback"}
+	}
+	auth := SigV4Auth(SigV4Options{
+		AllowedPrincipals: []string{"arn:aws:iam::123456789012:role/notifier-lambda"},
+		Scopes:            []string{ScopeRead},
+		HTTPClient:        &http.Client{Transport: rewriteTransport{target: target}},
+	}, fallback)
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		if claims.Subject != "arn:aws:iam::123456789012:role/notifier-lambda" {
+			t.Fatalf("unexpected subject %s", claims.Subject)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux := jwtTestMux(auth, inner)
+
+	presigned := func(host, sig string) string {
+		q := url.Values{
+			"Action":              {"GetCallerIdentity"},
+			"Version":             {"2011-06-15"},
+			"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+			"X-Amz-Date":          {time.Now().UTC().Format("20060102T150405Z")},
+			"X-Amz-Expires":       {"60"},
+			"X-Amz-SignedHeaders": {"host"},
+			"X-Amz-Signature":     {sig},
+		}
+		return base64.RawURLEncoding.EncodeToString([]byte("https://" + host + "/?" + q.Encode()))
+	}
+
+	cases := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"valid signature", "AWS-IAM " + presigned("sts.amazonaws.com", "good"), http.StatusOK},
+		{"regional endpoint", "AWS-IAM " + presigned("sts.eu-west-1.amazonaws.com", "good"), http.StatusOK},
+		{"China endpoint", "AWS-IAM " + presigned("sts.cn-north-1.amazonaws.com.cn", "good"), http.StatusOK},
+		{"rejected by STS", "AWS-IAM " + presigned("sts.amazonaws.com", "bad"), http.StatusUnauthorized},
+		{"other AWS service host", "AWS-IAM " + presigned("sts.s3.amazonaws.com", "good"), http.StatusUnauthorized},
+		{"unknown region", "AWS-IAM " + presigned("sts.evil-1.amazonaws.com", "good"), http.StatusUnauthorized},
+		{"non-STS host", "AWS-IAM " + base64.RawURLEncoding.EncodeToString([]byte("https://evil.example.com/?Action=GetCallerIdentity")), http.StatusUnauthorized},
+		{"bearer falls through", "Bearer token", http.StatusTeapot},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		req.Header.Set("Authorization", tc.header)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d", tc.name, tc.want, w.Code)
+		}
+	}
+	if !fallbackCalled {
+		t.Fatal("expected non-IAM request to reach fallback auth")
+	}
+}
+
+func TestCSRFProtect(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := CSRFProtect("session", "csrf_token")(inner)
+
+	cases := []struct {
+		name   string
+		setup  func(r *http.Request)
+		method string
+		want   int
+	}{
+		{"safe method", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: "jwt"})
+		}, "GET", http.StatusOK},
+		{"bearer token", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer jwt")
+		}, "PUT", http.StatusOK},
+		{"cookie without CSRF token", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: "jwt"})
+		}, "PUT", http.StatusForbidden},
+		{"mismatched CSRF token", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: "jwt"})
+			r.AddCookie(&http.Cookie{Name: "csrf_token", Value: "abc"})
+			r.Header.Set("X-CSRF-Token", "xyz")
+		}, "DELETE", http.StatusForbidden},
+		{"matching CSRF token", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: "jwt"})
+			r.AddCookie(&http.Cookie{Name: "csrf_token", Value: "abc"})
+			r.Header.Set("X-CSRF-Token", "abc")
+		}, "PATCH", http.StatusOK},
+		{"cross-site fetch", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: "jwt"})
+			r.AddCookie(&http.Cookie{Name: "csrf_token", Value: "abc"})
+			r.Header.Set("X-CSRF-Token", "abc")
+			r.Header.Set("Sec-Fetch-Site", "cross-site")
+		}, "POST", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, "/api/v1/users/user1/preferences", nil)
+		tc.setup(req)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, w.Code)
+		}
+	}
+}
+
 func TestCORS(t *testing.T) {
 	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -268,6 +638,354 @@ func TestRequestLogging(t *testing.T) {
 	}
 }
 
+func TestJWTAuth_DelegatedToken(t *testing.T) {
+	token := makeTokenWithClaims(jwt.MapClaims{
+		"sub": "user1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"act": map[string]any{"sub": "support-agent", "act": map[string]any{"sub": "earlier"}},
+	}, testSecret)
+
+	var got Claims
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ClaimsFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		actors []string
+		want   int
+	}{
+		{nil, http.StatusUnauthorized},
+		{[]string{"other-agent"}, http.StatusUnauthorized},
+		{[]string{"support-agent"}, http.StatusOK},
+		{[]string{"*"}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, nil))
+		mux := jwtTestMux(JWTAuth(JWTOptions{Secret: testSecret, Actors: tc.actors}), inner)
+		handler := RequestLogging(logger)(mux)
+
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tc.want {
+			t.Fatalf("actors %v: expected %d, got %d", tc.actors, tc.want, w.Code)
+		}
+		if tc.want != http.StatusOK {
+			continue
+		}
+		if got.Subject != "user1" || got.Actor != "support-agent" {
+			t.Fatalf("expected user1 acted on by support-agent, got %+v", got)
+		}
+		if !contains(buf.String(), "subject=user1") || !contains(buf.String(), "actor=support-agent") {
+			t.Fatalf("expected both identities in request log, got: %s", buf.String())
+		}
+	}
+}
+
+func TestAuthThrottle_BlocksRepeatedFailures(t *testing.T) {
+	auth := JWTAuth(JWTOptions{Secret: testSecret})
+	mux := jwtTestMux(auth, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := AuthThrottle(ThrottleOptions{
+		MaxFailures: 3,
+		Window:      time.Minute,
+		BaseBlock:   time.Minute,
+		MaxBlock:    time.Hour,
+	})(mux)
+
+	send := func(remote, secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("Authorization", "Bearer "+makeToken("attacker", secret, jwt.SigningMethodHS256))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := send("10.0.0.1:1234", "guess"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i, w.Code)
+		}
+	}
+	w := send("10.0.0.1:1234", testSecret)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once blocked, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After header")
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	req.Header.Set("Authorization", "Bearer "+makeToken("user1", testSecret, jwt.SigningMethodHS256))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected other clients unaffected, got %d", w.Code)
+	}
+}
+
+func TestAuthThrottle_Escalates(t *testing.T) {
+	th := newAuthThrottle(ThrottleOptions{MaxFailures: 1, Window: time.Minute, BaseBlock: time.Minute, MaxBlock: 3 * time.Minute})
+	now := time.Now()
+
+	var blocks []time.Duration
+	for i := 0; i < 4; i++ {
+		th.fail([]string{"ip:x"}, now)
+		blocks = append(blocks, th.blocked("ip:x", now))
+		now = now.Add(blocks[i] + time.Second)
+	}
+
+	want := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute}
+	for i := range want {
+		if blocks[i] != want[i] {
+			t.Fatalf("block %d: expected %s, got %s", i, want[i], blocks[i])
+		}
+	}
+}
+
+func TestAuthThrottle_PersistentFailures(t *testing.T) {
+	th := newAuthThrottle(ThrottleOptions{MaxFailures: 1, Window: time.Minute, BaseBlock: time.Minute, MaxBlock: time.Hour})
+	now := time.Now()
+	want := time.Minute
+	for i := 0; i < 200; i++ {
+		th.fail([]string{"ip:x"}, now)
+		if block := th.blocked("ip:x", now); block != want {
+			t.Fatalf("failure %d: expected a block of %s, got %s", i, want, block)
+		}
+		want = min(2*want, time.Hour)
+		now = now.Add(time.Hour + time.Second)
+	}
+}
+
+func TestAuthThrottle_BoundedRecords(t *testing.T) {
+	th := &authThrottle{
+		opts:    ThrottleOptions{MaxFailures: 1, Window: time.Minute, BaseBlock: time.Minute, MaxBlock: time.Hour},
+		records: newLRUCache[*failureRecord](2),
+	}
+	now := time.Now()
+	for _, k := range []string{"sub:a", "sub:b", "sub:c"} {
+		th.fail([]string{k}, now)
+	}
+	if n := th.records.len(); n != 2 {
+		t.Fatalf("expected 2 records, got %d", n)
+	}
+	if th.blocked("sub:a", now) != 0 || th.blocked("sub:c", now) == 0 {
+		t.Fatal("expected the least recent key evicted and the newest kept")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	cases := []struct {
+		remote, forwarded, want string
+	}{
+		{"203.0.113.7:1234", "", "203.0.113.7"},
+		// Untrusted sources cannot claim another address.
+		{"203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"10.0.0.5:1234", "198.51.100.1", "198.51.100.1"},
+		// Entries left of the first untrusted one may be forged.
+		{"10.0.0.5:1234", "192.0.2.9, 198.51.100.1, 10.0.0.6", "198.51.100.1"},
+		{"10.0.0.5:1234", "", "10.0.0.5"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if got := clientIP(req, trusted); got != tc.want {
+			t.Errorf("%s via %q: expected %s, got %s", tc.remote, tc.forwarded, tc.want, got)
+		}
+	}
+}
+
+func TestJWTAuth_AsymmetricAlgorithms(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	sign := func(method jwt.SigningMethod, key any) string {
+		s, err := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "user1"}).SignedString(key)
+		if err != nil {
+			t.Fatalf("signing: %v", err)
+		}
+		return s
+	}
+	otherEC, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	opts := JWTOptions{
+		Secret:     testSecret,
+		Algorithms: []string{AlgES256, AlgEdDSA},
+		PublicKeys: []crypto.PublicKey{&ecKey.PublicKey, edPub},
+	}
+	mux := jwtTestMux(JWTAuth(opts), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"ES256", sign(jwt.SigningMethodES256, ecKey), http.StatusOK},
+		{"EdDSA", sign(jwt.SigningMethodEdDSA, edKey), http.StatusOK},
+		{"ES256 unknown key", sign(jwt.SigningMethodES256, otherEC), http.StatusUnauthorized},
+		{"HS256 not allowed", makeToken("user1", testSecret, jwt.SigningMethodHS256), http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, w.Code)
+		}
+	}
+}
+
+func TestLoadPublicKeys(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	var buf bytes.Buffer
+	for _, k := range []any{&ecKey.PublicKey, edPub} {
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		pem.Encode(&buf, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+	path := filepath.Join(t.TempDir(), "keys.pem")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadPublicKeys(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a key"), 0o600)
+	if _, err := LoadPublicKeys(empty); err == nil {
+		t.Fatal("expected error for file without keys")
+	}
+}
+
+func TestLoadLimit_RateLimit(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := LoadLimit(LimitOptions{RPS: 1, Burst: 2})(inner)
+
+	codes := make([]int, 3)
+	for i := range codes {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		codes[i] = w.Code
+		if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+			t.Fatal("expected Retry-After on shed request")
+		}
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusServiceUnavailable {
+		t.Fatalf("expected burst of 2 then 503, got %v", codes)
+	}
+
+	// Health checks bypass the limiter.
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected healthz to bypass limits, got %d", w.Code)
+	}
+}
+
+func TestLoadLimit_MaxInFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := LoadLimit(LimitOptions{MaxInFlight: 1})(inner)
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	<-started
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	close(release)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while at concurrency limit, got %d", w.Code)
+	}
+}
+
+func TestLoadShedder_LatencyRecovers(t *testing.T) {
+	s := &loadShedder{opts: LimitOptions{LatencyTarget: 100 * time.Millisecond}}
+	now := time.Now()
+	for i := 0; i < 50; i++ {
+		s.admit(priorityHigh, now)
+		s.done(time.Second, now)
+	}
+	if s.admit(priorityNormal, now) {
+		t.Fatal("expected normal priority shed while latency is over target")
+	}
+
+	// Shed requests never complete, so nothing samples the latency; it
+	// must decay on its own for them to be admitted again.
+	if !s.admit(priorityNormal, now.Add(30*time.Second)) {
+		t.Fatal("expected normal priority admitted once the latency decayed")
+	}
+}
+
+func TestLoadLimit_ShedsLowPriorityFirst(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("block") {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := LoadLimit(LimitOptions{MaxInFlight: 4})(inner)
+	defer close(release)
+
+	// Three slow interactive reads put the server at 75% of capacity.
+	for range 3 {
+		go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/users/u/preferences?block", nil))
+		<-started
+	}
+
+	cases := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/api/v1/admin/corrections", http.StatusServiceUnavailable},
+		{"GET", "/api/v1/users/u/preferences/export", http.StatusServiceUnavailable},
+		{"PUT", "/api/v1/users/u/preferences", http.StatusOK},
+		{"GET", "/api/v1/users/u/preferences", http.StatusOK},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d", tc.method, tc.path, tc.want, w.Code)
+		}
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && searchString(s, substr)
 }
@@ -1,13 +1,65 @@
 package main
 
-// PreferencesResponse is returned for full preference lookups.
+import (
+	"encoding/json"
+	"time"
+)
+
+// PreferencesResponse is returned for full preference lookups. NextCursor is
+// set when the response was truncated to stay within the size limit.
+// Metadata is only included on request (?include=metadata). The timestamps
+// are omitted for users with no stored preferences.
 type PreferencesResponse struct {
-	UserID      string            `json:"userId"`
-	Preferences map[string]string `json:"preferences"`
+	UserID      string                  `json:"userId"`
+	Preferences map[string]any          `json:"preferences"`
+	Metadata    map[string]PrefMetadata `json:"metadata,omitempty"`
+	CreatedAt   *time.Time              `json:"createdAt,omitempty"`
+	UpdatedAt   *time.Time              `json:"updatedAt,omitempty"`
+	NextCursor  string                  `json:"nextCursor,omitempty"`
+}
+
+// newPreferencesResponse returns the response for prefs, taking the
+// timestamps from rec.
+func newPreferencesResponse(userID string, prefs map[string]any, rec Record) PreferencesResponse {
+	resp := PreferencesResponse{UserID: userID, Preferences: prefs}
+	if !rec.CreatedAt.IsZero() {
+		resp.CreatedAt = &rec.CreatedAt
+	}
+	if !rec.UpdatedAt.IsZero() {
+		resp.UpdatedAt = &rec.UpdatedAt
+	}
+	return resp
+}
+
+// Values of PrefMetadata.Source.
+const (
+	SourceUser    = "user"
+	SourceDefault = "default"
+)
+
+// PrefMetadata describes where a preference value came from and when it was
+// last written. UpdatedAt and LastUpdatedBy are omitted for keys last
+// written before metadata was recorded.
+type PrefMetadata struct {
+	UpdatedAt     *time.Time `json:"updatedAt,omitempty"`
+	LastUpdatedBy string     `json:"lastUpdatedBy,omitempty"`
+	Source        string     `json:"source"`
+}
+
+// SinglePrefRequest is the body for setting a single key. Value is kept raw
+// so that a missing value can be told apart from null.
+type SinglePrefRequest struct {
+	Value json.RawMessage `json:"value"`
+}
+
+// DeleteKeysRequest is the optional body of DELETE /preferences, naming the
+// keys to remove instead of the whole map.
+type DeleteKeysRequest struct {
+	Keys []string `json:"keys"`
 }
 
 // SinglePrefResponse is returned for single-key lookups.
 type SinglePrefResponse struct {
 	Key   string `json:"key"`
-	Value string `json:"value"`
+	Value any    `json:"value"`
 }
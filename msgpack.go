@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackContentType is the media type of MessagePack bodies;
+// application/x-msgpack is accepted as well.
+const msgpackContentType = "application/msgpack"
+
+func isMsgpack(mediaType string) bool {
+	return mediaType == msgpackContentType || mediaType == "application/x-msgpack"
+}
+
+// MessagePack lets clients exchange MessagePack instead of JSON, for mobile
+// clients on metered connections. A MessagePack request body is converted
+// to JSON before the handlers see it, and when Accept prefers MessagePack,
+// JSON responses are converted on the way out. Handlers only ever deal in
+// JSON. Problem details (application/problem+json) and other media types
+// pass through unchanged.
+func MessagePack(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); isMsgpack(mt) {
+			body, err := msgpackToJSON(r.Body)
+			if err != nil {
+				writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid MessagePack body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Set("Content-Type", "application/json")
+		}
+
+		w.Header().Add("Vary", "Accept")
+		if !prefersMsgpack(r.Header.Get("Accept")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bw := &bufferingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(bw, r)
+		body := bw.body.Bytes()
+		if mt, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mt == "application/json" && len(body) > 0 {
+			if packed, err := jsonToMsgpack(body); err == nil {
+				body = packed
+				w.Header().Set("Content-Type", msgpackContentType)
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+		}
+		w.WriteHeader(bw.status)
+		w.Write(body)
+	})
+}
+
+// prefersMsgpack reports whether an Accept header ranks MessagePack above
+// JSON. Ties, wildcards and absent headers keep JSON.
+func prefersMsgpack(accept string) bool {
+	return prefersOverJSON(accept, isMsgpack)
+}
+
+// prefersOverJSON reports whether an Accept header ranks a media type
+// matching alt above JSON.
+func prefersOverJSON(accept string, alt func(mediaType string) bool) bool {
+	var altQ, jsonQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case alt(mt):
+			altQ = max(altQ, q)
+		case mt == "application/json", mt == "application/*", mt == "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return altQ > jsonQ
+}
+
+// msgpackToJSON re-encodes a MessagePack document as JSON.
+func msgpackToJSON(r io.Reader) ([]byte, error) {
+	dec := msgpack.NewDecoder(r)
+	dec.SetMapDecoder(func(d *msgpack.Decoder) (any, error) {
+		return d.DecodeUntypedMap()
+	})
+	v, err := dec.DecodeInterface()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonCompatible(v))
+}
+
+// jsonCompatible replaces the map[any]any MessagePack produces for
+// non-string keys, which encoding/json cannot marshal.
+func jsonCompatible(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			ks, ok := k.(string)
+			if !ok {
+				b, _ := json.Marshal(k)
+				ks = string(b)
+			}
+			out[ks] = jsonCompatible(e)
+		}
+		return out
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonCompatible(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = jsonCompatible(e)
+		}
+	}
+	return v
+}
+
+// jsonToMsgpack re-encodes a JSON document as MessagePack, with integers as
+// MessagePack integers and other numbers as float64.
+func jsonToMsgpack(body []byte) ([]byte, error) {
+	var v any
+	if err := decodeJSON(bytes.NewReader(body), &v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetSortMapKeys(true)
+	if err := enc.Encode(packNumbers(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func packNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = packNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = packNumbers(e)
+		}
+	}
+	return v
+}
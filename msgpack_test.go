@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestMessagePack(t *testing.T) {
+	store := newMockStore()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", h.ReplaceAll)
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/{key}", h.GetOne)
+	handler := MessagePack(mux)
+
+	body, _ := msgpack.Marshal(map[string]any{"theme": "dark", "columns": 3, "ratio": 1.5})
+	req := httptest.NewRequest("PUT", "/api/v1/users/user1/preferences", bytes.NewReader(body))
+	req.Header.Set("Content-Type", msgpackContentType)
+	req.Header.Set("Accept", msgpackContentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != msgpackContentType {
+		t.Fatalf("expected a MessagePack 201, got %d %v", w.Code, w.Header())
+	}
+	var resp struct {
+		UserID      string `msgpack:"userId"`
+		Preferences struct {
+			Theme   string  `msgpack:"theme"`
+			Columns int     `msgpack:"columns"`
+			Ratio   float64 `msgpack:"ratio"`
+		} `msgpack:"preferences"`
+	}
+	if err := msgpack.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.UserID != "user1" || resp.Preferences.Theme != "dark" || resp.Preferences.Columns != 3 || resp.Preferences.Ratio != 1.5 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	// Clients that do not ask for MessagePack get JSON.
+	req = httptest.NewRequest("GET", "/api/v1/users/user1/preferences/theme", nil)
+	req.Header.Set("Accept", "application/json, application/msgpack;q=0.5")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Header().Get("Content-Type") != "application/json" || w.Body.String() != "{\"key\":\"theme\",\"value\":\"dark\"}\n" {
+		t.Fatalf("expected JSON, got %v %q", w.Header(), w.Body.String())
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v1/users/user1/preferences", bytes.NewBufferString("\xc1"))
+	req.Header.Set("Content-Type", msgpackContentType)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, withClaims(req, "user1"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid body, got %d", w.Code)
+	}
+}
+
+func TestPrefersMsgpack(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                                      false,
+		"*/*":                                   false,
+		"application/msgpack":                   true,
+		"application/x-msgpack, */*;q=0.1":      true,
+		"application/json, application/msgpack": false,
+		"application/msgpack;q=0":               false,
+	} {
+		if got := prefersMsgpack(accept); got != want {
+			t.Errorf("prefersMsgpack(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// Authentication modes selectable via AUTH_MODE.
+const (
+	AuthModeJWT  = "jwt"
+	AuthModeMTLS = "mtls"
+)
+
+// MTLSOptions configures the MTLSAuth middleware.
+type MTLSOptions struct {
+	// AllowedIdentities restricts which certificate identities are accepted.
+	// Empty means any certificate that chains to the client CA.
+	AllowedIdentities []string
+	// Scopes are granted to every authenticated service identity.
+	Scopes []string
+}
+
+// MTLSAuth authenticates callers by their verified client certificate and
+// stores the certificate identity in context as a service principal. The TLS
+// listener must be configured to request and verify client certificates.
+func MTLSAuth(opts MTLSOptions) func(http.HandlerFunc) http.HandlerFunc {
+	return authenticate(opts.authenticate)
+}
+
+// authenticate is the Authenticator behind MTLSAuth.
+func (o MTLSOptions) authenticate(_ context.Context, c Credentials) (Claims, error) {
+	if c.TLS == nil || len(c.TLS.VerifiedChains) == 0 || len(c.TLS.VerifiedChains[0]) == 0 {
+		return Claims{}, unauthenticated("client certificate required")
+	}
+
+	identity := certIdentity(c.TLS.VerifiedChains[0][0])
+	if identity == "" {
+		return Claims{}, unauthenticated("client certificate has no identity")
+	}
+
+	if len(o.AllowedIdentities) > 0 && !slices.Contains(o.AllowedIdentities, identity) {
+		return Claims{}, &authError{Status: http.StatusForbidden, Reason: "client identity not allowed", Subject: identity}
+	}
+
+	return Claims{
+		Subject: identity,
+		Kind:    PrincipalService,
+		Scopes:  o.Scopes,
+	}, nil
+}
+
+// certIdentity maps a client certificate to a service identity, preferring a
+// URI SAN (e.g. a SPIFFE ID), then a DNS SAN, then the subject CN.
+func certIdentity(cert *x509.Certificate) string {
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// serverTLSConfig builds the listener TLS config. In mTLS mode, client
+// certificates are required and verified against the configured CA bundle.
+func serverTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.AuthMode != AuthModeMTLS {
+		return tlsCfg, nil
+	}
+
+	pem, err := os.ReadFile(cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.TLSClientCAFile)
+	}
+
+	tlsCfg.ClientCAs = pool
+	tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsCfg, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsMaxBatch bounds how many events are published per flush.
+const natsMaxBatch = 100
+
+// natsConn is the subset of nats.Conn used, for testing.
+type natsConn interface {
+	PublishMsg(m *nats.Msg) error
+	FlushWithContext(ctx context.Context) error
+	Drain() error
+}
+
+// NATSPublisher publishes change events on NATS subjects
+// <prefix>.<userId>, e.g. prefs.changed.user1, with the event type and ID
+// as headers; Nats-Msg-Id lets JetStream streams on the subjects drop
+// duplicates. Each batch is flushed, so a publish only succeeds once the
+// server has the messages.
+type NATSPublisher struct {
+	conn   natsConn
+	prefix string
+	source string
+}
+
+// NewNATSPublisher publishes on conn under prefix, as the CloudEvents source
+// source.
+func NewNATSPublisher(conn natsConn, prefix, source string) *NATSPublisher {
+	return &NATSPublisher{conn: conn, prefix: prefix, source: source}
+}
+
+// NewNATSConn connects to the configured servers, retrying in the
+// background when they are unreachable at startup and reconnecting for
+// as long as the process runs.
+func NewNATSConn(cfg Config) (*nats.Conn, error) {
+	opts := []nats.Option{
+		nats.Name("user-prefs"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	}
+	if cfg.NATSCredsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.NATSCredsFile))
+	}
+	nc, err := nats.Connect(cfg.NATSURL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to NATS: %w", err)
+	}
+	return nc, nil
+}
+
+func (p *NATSPublisher) MaxBatch() int { return natsMaxBatch }
+
+func (p *NATSPublisher) Publish(ctx context.Context, e ChangeEvent) error {
+	_, err := p.PublishBatch(ctx, []ChangeEvent{e})
+	return err
+}
+
+func (p *NATSPublisher) PublishBatch(ctx context.Context, events []ChangeEvent) ([]ChangeEvent, error) {
+	for i, e := range events {
+		body, err := encodeEvent(e, p.source)
+		if err != nil {
+			return events[i:], err
+		}
+		msg := nats.NewMsg(p.prefix + "." + natsToken(e.UserID))
+		msg.Data = body
+		msg.Header.Set("Content-Type", cloudEventsContentType)
+		msg.Header.Set("eventType", e.Type)
+		msg.Header.Set("eventId", e.ID)
+		msg.Header.Set(nats.MsgIdHdr, e.ID)
+		if err := p.conn.PublishMsg(msg); err != nil {
+			return events[i:], fmt.Errorf("PublishMsg (nats): %w", err)
+		}
+	}
+	// Until flushed, messages may sit in the client's buffer; all of them
+	// are resent if the flush fails.
+	if err := p.conn.FlushWithContext(ctx); err != nil {
+		return events, fmt.Errorf("Flush (nats): %w", err)
+	}
+	return nil, nil
+}
+
+// Close drains the connection, sending any buffered messages.
+func (p *NATSPublisher) Close() error {
+	return p.conn.Drain()
+}
+
+// natsToken escapes s for use as one subject token: '.', '*', '>', '%'
+// and whitespace become %XX, so user IDs containing them neither split the
+// subject nor act as wildcards. Other IDs are used as they are.
+func natsToken(s string) string {
+	if !strings.ContainsFunc(s, natsEscaped) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if natsEscaped(r) {
+			fmt.Fprintf(&b, "%%%02X", r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func natsEscaped(r rune) bool {
+	switch r {
+	case '.', '*', '>', '%', ' ', '\t', '\r', '\n', '\f', '\v':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+// fakeNATS records published messages; flushes fail while down is set.
+type fakeNATS struct {
+	msgs    []*nats.Msg
+	flushed int
+	down    bool
+	drained bool
+}
+
+func (f *fakeNATS) PublishMsg(m *nats.Msg) error {
+	f.msgs = append(f.msgs, m)
+	return nil
+}
+
+func (f *fakeNATS) FlushWithContext(context.Context) error {
+	if f.down {
+		return errors.New("disconnected")
+	}
+	f.flushed = len(f.msgs)
+	return nil
+}
+
+func (f *fakeNATS) Drain() error {
+	f.drained = true
+	return nil
+}
+
+func TestNATSPublisher(t *testing.T) {
+	conn := &fakeNATS{}
+	sink := NewAsyncSink("nats", NewNATSPublisher(conn, "prefs.changed", "user-prefs"), testLogger())
+	store := NewChangePublisher(newMockStore(), nil, testLogger(), sink)
+	ctx := context.Background()
+	store.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark"}, Precondition{})
+	store.ReplaceAll(ctx, "jane.doe@example.com", map[string]any{"theme": "dark"}, Precondition{})
+	sink.Close(ctx)
+
+	if len(conn.msgs) != 2 || conn.flushed != 2 || !conn.drained {
+		t.Fatalf("expected two flushed messages and a drain, got %d (%d flushed), drained %v", len(conn.msgs), conn.flushed, conn.drained)
+	}
+	msg := conn.msgs[0]
+	var ce CloudEvent
+	if err := json.Unmarshal(msg.Data, &ce); err != nil || ce.Data.UserID != "user1" {
+		t.Fatalf("unexpected data %s (%v)", msg.Data, err)
+	}
+	e := ce.Data
+	if msg.Subject != "prefs.changed.user1" || msg.Header.Get("eventType") != EventPreferencesUpdated || msg.Header.Get(nats.MsgIdHdr) != e.ID {
+		t.Fatalf("unexpected message %s %v", msg.Subject, msg.Header)
+	}
+	if subject := conn.msgs[1].Subject; subject != "prefs.changed.jane%2Edoe@example%2Ecom" {
+		t.Fatalf("expected the user ID escaped to one token, got %s", subject)
+	}
+
+	conn.down = true
+	failed, err := NewNATSPublisher(conn, "prefs.changed", "user-prefs").PublishBatch(ctx, []ChangeEvent{{ID: "1", UserID: "user1"}})
+	if err == nil || len(failed) != 1 {
+		t.Fatalf("expected an unflushed batch to fail, got %v %v", failed, err)
+	}
+}
+
+func TestNATSToken(t *testing.T) {
+	for in, want := range map[string]string{
+		"user1":         "user1",
+		"auth0|abc-123": "auth0|abc-123",
+		"a.b":           "a%2Eb",
+		"*":             "%2A",
+		"> x":           "%3E%20x",
+		"100%":          "100%25",
+		"user:ünïcødé":  "user:ünïcødé",
+	} {
+		if got := natsToken(in); got != want {
+			t.Errorf("natsToken(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Conflict resolution strategies for offline sync.
+const (
+	// SyncLastWriteWins keeps whichever of the client's and the server's
+	// changes was made later; ties go to the server.
+	SyncLastWriteWins = "last-write-wins"
+	// SyncClientWins applies the client's change regardless.
+	SyncClientWins = "client-wins"
+)
+
+// syncAttempts bounds how often a sync is re-merged when another write
+// lands between reading the map and writing the merge.
+const syncAttempts = 3
+
+// SyncRequest is the body of POST /preferences:sync: the changes a client
+// made offline, on top of the map at BaseVersion (0 for a client that has
+// never synced).
+type SyncRequest struct {
+	BaseVersion int64        `json:"baseVersion"`
+	Changes     []SyncChange `json:"changes"`
+	// Strategy resolves conflicts on keys whose change names none; the
+	// default is last-write-wins.
+	Strategy string `json:"strategy,omitempty"`
+}
+
+// SyncChange is one key a client set or deleted. ChangedAt is when, by the
+// client's clock; without it the server wins last-write-wins conflicts.
+type SyncChange struct {
+	Key       string     `json:"key"`
+	Value     any        `json:"value,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	ChangedAt *time.Time `json:"changedAt,omitempty"`
+	Strategy  string     `json:"strategy,omitempty"`
+}
+
+// SyncConflict reports a key both the client and the server changed since
+// the base version, to different values, and whose change was kept.
+type SyncConflict struct {
+	Key      string `json:"key"`
+	Strategy string `json:"strategy"`
+	// Winner is "client" or "server".
+	Winner          string     `json:"winner"`
+	ClientValue     any        `json:"clientValue,omitempty"`
+	ClientDeleted   bool       `json:"clientDeleted,omitempty"`
+	ServerValue     any        `json:"serverValue,omitempty"`
+	ServerDeleted   bool       `json:"serverDeleted,omitempty"`
+	ServerChangedAt *time.Time `json:"serverChangedAt,omitempty"`
+}
+
+// SyncResponse is the merged map, which the client adopts as its new base
+// at Version. Applied lists the client's keys that were written.
+type SyncResponse struct {
+	UserID      string         `json:"userId"`
+	Preferences map[string]any `json:"preferences"`
+	Version     int64          `json:"version"`
+	Applied     []string       `json:"applied"`
+	Conflicts   []SyncConflict `json:"conflicts"`
+}
+
+// Sync merges a client's offline changes into the stored map:
+// POST .../preferences:sync. Keys changed on the server since the base
+// version, to a value other than the client's, are conflicts, resolved per
+// key by the change's strategy or the request's: last-write-wins compares
+// the client's changedAt (capped at now) with when the server's change was
+// recorded in history, and client-wins keeps the client's change. Other
+// changes are applied as they are. Which keys the server changed comes from
+// history, walked back to the base version; when it cannot be (a base
+// older than the history kept, or 0), every key the client changed counts
+// as changed on the server, timed by its metadata, and so do keys the
+// client changed that the server no longer has, unless the base is 0.
+// Stored sensitive keys, which history leaves out, count as changed
+// whenever the version has moved. The merge is written conditionally on
+// the version it was made against and redone if another write lands first.
+func (h *HistoryHandler) Sync(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	var req SyncRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if err := validateSyncRequest(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.prefs.checkPatchSize(w, len(req.Changes)) {
+		return
+	}
+
+	for range syncAttempts {
+		cur, err := h.prefs.store.GetAll(r.Context(), userID)
+		if err != nil {
+			h.prefs.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+			writeError(w, http.StatusInternalServerError, "failed to sync preferences")
+			return
+		}
+		changed, err := h.changedSince(r.Context(), userID, req.BaseVersion, cur, req.Changes)
+		if err != nil {
+			h.prefs.logger.Error("history.ListHistory failed", "error", err, "userId", userID)
+			writeError(w, http.StatusInternalServerError, "failed to sync preferences")
+			return
+		}
+
+		resp := SyncResponse{UserID: userID, Applied: []string{}, Conflicts: []SyncConflict{}}
+		set, remove := map[string]any{}, []string{}
+		for _, c := range req.Changes {
+			serverValue, serverSet := cur.Prefs[c.Key]
+			if (!c.Deleted && serverSet && sameValue(serverValue, c.Value)) || (c.Deleted && !serverSet) {
+				continue // already as the client has it
+			}
+			if at, ok := changed[c.Key]; ok {
+				conflict := resolveSyncConflict(c, req.Strategy, serverValue, !serverSet, at)
+				resp.Conflicts = append(resp.Conflicts, conflict)
+				if conflict.Winner != "client" {
+					continue
+				}
+			}
+			resp.Applied = append(resp.Applied, c.Key)
+			if c.Deleted {
+				remove = append(remove, c.Key)
+			} else {
+				set[c.Key] = c.Value
+			}
+		}
+
+		rec := cur
+		if len(set) > 0 || len(remove) > 0 {
+			h.prefs.dropUnknownKeys(set)
+			resp.Applied = slices.DeleteFunc(resp.Applied, func(k string) bool {
+				_, ok := set[k]
+				return !ok && !slices.Contains(remove, k)
+			})
+			remove = h.prefs.mirrorAliases(set, remove)
+			if !h.prefs.validatePrefs(w, set) || !h.prefs.checkQuota(w, r, userID, set, remove, false) {
+				return
+			}
+			h.prefs.warnDeprecated(w, append(slices.Collect(maps.Keys(set)), remove...))
+
+			cond := Precondition{Versions: []int64{cur.Version}, Generation: cur.Generation}
+			if cur.Prefs == nil {
+				cond = Precondition{MustNotExist: true}
+			}
+			rec, err = h.prefs.store.Update(r.Context(), userID, set, remove, cond)
+			if errors.Is(err, ErrPreconditionFailed) {
+				continue
+			}
+			if err != nil {
+				h.prefs.logger.Error("store.Update failed", "error", err, "userId", userID)
+				writeError(w, http.StatusInternalServerError, "failed to sync preferences")
+				return
+			}
+		}
+
+		resp.Preferences, resp.Version = rec.Prefs, rec.Version
+		if resp.Preferences == nil {
+			resp.Preferences = map[string]any{}
+		}
+		if len(resp.Conflicts) > 0 {
+			h.prefs.logger.Info("sync conflicts resolved", "userId", userID, "conflicts", len(resp.Conflicts))
+		}
+		setValidators(w, rec)
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	writeErrorCode(w, http.StatusConflict, ErrCodeConflict, "preferences are being modified concurrently; retry the sync")
+}
+
+func validateSyncRequest(req *SyncRequest) error {
+	if req.BaseVersion < 0 {
+		return errors.New("baseVersion must not be negative")
+	}
+	if len(req.Changes) == 0 {
+		return errors.New("changes must not be empty")
+	}
+	if req.Strategy == "" {
+		req.Strategy = SyncLastWriteWins
+	}
+	strategies := []string{SyncLastWriteWins, SyncClientWins}
+	if !slices.Contains(strategies, req.Strategy) {
+		return fmt.Errorf("strategy must be %s or %s", SyncLastWriteWins, SyncClientWins)
+	}
+	seen := make(map[string]bool, len(req.Changes))
+	for _, c := range req.Changes {
+		switch {
+		case c.Key == "":
+			return errors.New("each change needs a key")
+		case seen[c.Key]:
+			return fmt.Errorf("key %q is changed twice", c.Key)
+		case c.Deleted && c.Value != nil:
+			return fmt.Errorf("key %q is both deleted and given a value", c.Key)
+		case !c.Deleted && c.Value == nil:
+			return fmt.Errorf("key %q needs a value or deleted", c.Key)
+		case c.Strategy != "" && !slices.Contains(strategies, c.Strategy):
+			return fmt.Errorf("key %q: strategy must be %s or %s", c.Key, SyncLastWriteWins, SyncClientWins)
+		}
+		seen[c.Key] = true
+	}
+	return nil
+}
+
+// resolveSyncConflict decides a conflicting key; serverAt is when the
+// server's change was made, zero if unknown.
+func resolveSyncConflict(c SyncChange, strategy string, serverValue any, serverDeleted bool, serverAt time.Time) SyncConflict {
+	if c.Strategy != "" {
+		strategy = c.Strategy
+	}
+	conflict := SyncConflict{
+		Key:           c.Key,
+		Strategy:      strategy,
+		Winner:        "server",
+		ClientValue:   c.Value,
+		ClientDeleted: c.Deleted,
+		ServerValue:   serverValue,
+		ServerDeleted: serverDeleted,
+	}
+	if !serverAt.IsZero() {
+		conflict.ServerChangedAt = &serverAt
+	}
+	switch strategy {
+	case SyncClientWins:
+		conflict.Winner = "client"
+	case SyncLastWriteWins:
+		// A clock running ahead cannot win every conflict for long.
+		if c.ChangedAt != nil {
+			at := *c.ChangedAt
+			if now := time.Now(); at.After(now) {
+				at = now
+			}
+			if at.After(serverAt) {
+				conflict.Winner = "client"
+			}
+		}
+	}
+	return conflict
+}
+
+// changedSince returns the keys changed since the map was at version base,
+// each with when it last changed. It walks history newest first down to
+// an entry at or below base; when it cannot reach one within
+// maxDeltaLimit entries, every key in the current map and every key that
+// history shows changing is returned, timed by metadata where there is
+// any. Keys the server may have deleted since then are returned too,
+// untimed: those with metadata but no value, and, for a base above 0,
+// those in changes that the map no longer holds.
+func (h *HistoryHandler) changedSince(ctx context.Context, userID string, base int64, cur Record, changes []SyncChange) (map[string]time.Time, error) {
+	changed := map[string]time.Time{}
+	if base > 0 && base == cur.Version {
+		return changed, nil
+	}
+	mark := func(keys map[string]any, at time.Time) {
+		for k := range keys {
+			if _, ok := changed[k]; !ok {
+				changed[k] = at
+			}
+		}
+	}
+
+	reached := false
+	if base > 0 && base <= cur.Version {
+		before := ""
+	walk:
+		for seen := 0; seen < maxDeltaLimit; {
+			entries, err := h.store.ListHistory(ctx, userID, maxHistoryLimit, before)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				// A DeleteAll resets the version; its entry is at 0.
+				if e.Version <= base && e.Op != HistoryDeleteAll {
+					reached = true
+					break walk
+				}
+				mark(e.Before, e.At)
+				mark(e.After, e.At)
+				before = e.ID
+			}
+			seen += len(entries)
+			if len(entries) < maxHistoryLimit {
+				break
+			}
+		}
+	}
+	if !reached {
+		for k := range cur.Prefs {
+			if _, ok := changed[k]; !ok {
+				changed[k] = cur.Meta[k].UpdatedAt
+			}
+		}
+		// A deletion leaves nothing in the map to show it, so a key the
+		// client had at base and the server no longer holds may have been
+		// deleted since.
+		for k := range cur.Meta {
+			if _, ok := changed[k]; !ok && cur.Prefs[k] == nil {
+				changed[k] = time.Time{}
+			}
+		}
+		if base > 0 {
+			for _, c := range changes {
+				if _, ok := changed[c.Key]; !ok && cur.Prefs[c.Key] == nil {
+					changed[c.Key] = time.Time{}
+				}
+			}
+		}
+	}
+	// History leaves sensitive keys out.
+	for _, k := range h.prefs.opts.SensitiveKeys {
+		if _, ok := changed[k]; !ok && cur.Prefs[k] != nil {
+			changed[k] = cur.Meta[k].UpdatedAt
+		}
+	}
+	return changed, nil
+}
+
+// sameValue reports whether two preference values are the same JSON, so
+// 3 and 3.0 match however each was decoded.
+func sameValue(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	ca, errA := canonicalJSON(ja)
+	cb, errB := canonicalJSON(jb)
+	return errA == nil && errB == nil && bytes.Equal(ca, cb)
+}
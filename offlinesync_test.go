@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHistoryHandler_Sync(t *testing.T) {
+	hist := newMemHistory()
+	store := NewHistoryRecorder(newMockStore(), hist, []string{"secret"})
+	prefs := NewPreferencesHandler(store, testLogger(), HandlerOptions{SensitiveKeys: []string{"secret"}})
+	h := NewHistoryHandler(prefs, hist, time.Hour)
+	ctx := context.Background()
+	sync := func(req SyncRequest) (int, SyncResponse) {
+		body, _ := json.Marshal(req)
+		r := withClaims(httptest.NewRequest("POST", "/api/v1/users/user1/preferences:sync", bytes.NewReader(body)), "user1")
+		r.SetPathValue("userId", "user1")
+		w := httptest.NewRecorder()
+		h.Sync(w, r)
+		var resp SyncResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	at := func(d time.Duration) *time.Time {
+		t := time.Now().Add(d)
+		return &t
+	}
+
+	// A first sync from a client with nothing stored applies everything.
+	code, resp := sync(SyncRequest{Changes: []SyncChange{{Key: "theme", Value: "dark"}, {Key: "lang", Value: "en"}}})
+	if code != http.StatusOK || resp.Version != 1 || len(resp.Applied) != 2 || len(resp.Conflicts) != 0 || resp.Preferences["theme"] != "dark" {
+		t.Fatalf("unexpected first sync %d %+v", code, resp)
+	}
+	base := resp.Version
+
+	// Meanwhile another device changes theme and volume.
+	store.Update(ctx, "user1", map[string]any{"theme": "light", "volume": 3.0}, nil, Precondition{})
+
+	// theme conflicts: the client's older change loses, its newer one wins.
+	code, resp = sync(SyncRequest{BaseVersion: base, Changes: []SyncChange{
+		{Key: "theme", Value: "blue", ChangedAt: at(-time.Hour)},
+		{Key: "lang", Deleted: true, ChangedAt: at(-time.Hour)},
+		{Key: "volume", Value: 3.0},
+	}})
+	if code != http.StatusOK || len(resp.Conflicts) != 1 {
+		t.Fatalf("expected one conflict, got %d %+v", code, resp)
+	}
+	c := resp.Conflicts[0]
+	if c.Key != "theme" || c.Winner != "server" || c.Strategy != SyncLastWriteWins || c.ServerValue != "light" || c.ClientValue != "blue" || c.ServerChangedAt == nil {
+		t.Fatalf("unexpected conflict %+v", c)
+	}
+	if len(resp.Applied) != 1 || resp.Applied[0] != "lang" || resp.Preferences["theme"] != "light" || resp.Preferences["lang"] != nil {
+		t.Fatalf("expected only the unconflicted delete applied, got %+v", resp)
+	}
+	base = resp.Version
+
+	store.Update(ctx, "user1", map[string]any{"theme": "dark"}, nil, Precondition{})
+	_, resp = sync(SyncRequest{BaseVersion: base, Changes: []SyncChange{{Key: "theme", Value: "blue", ChangedAt: at(time.Hour)}}})
+	if len(resp.Conflicts) != 1 || resp.Conflicts[0].Winner != "client" || resp.Preferences["theme"] != "blue" {
+		t.Fatalf("expected the later client change to win, got %+v", resp)
+	}
+	base = resp.Version
+
+	// client-wins per key, whatever the times; other keys keep the default.
+	store.Update(ctx, "user1", map[string]any{"theme": "dark", "volume": 5.0}, nil, Precondition{})
+	_, resp = sync(SyncRequest{BaseVersion: base, Changes: []SyncChange{
+		{Key: "theme", Value: "red", Strategy: SyncClientWins},
+		{Key: "volume", Value: 1.0},
+	}})
+	if len(resp.Conflicts) != 2 || resp.Preferences["theme"] != "red" || resp.Preferences["volume"] != 5.0 {
+		t.Fatalf("expected theme to the client and volume to the server, got %+v", resp)
+	}
+
+	// A base history no longer reaches treats every stored key as changed.
+	_, resp = sync(SyncRequest{Changes: []SyncChange{{Key: "volume", Value: 2.0, ChangedAt: at(-time.Hour)}, {Key: "font", Value: "serif"}}})
+	if len(resp.Conflicts) != 1 || resp.Conflicts[0].Key != "volume" || resp.Conflicts[0].Winner != "server" || resp.Preferences["font"] != "serif" {
+		t.Fatalf("expected volume to conflict without a base, got %+v", resp)
+	}
+	base = resp.Version
+
+	// Sensitive keys are not in history, so they conflict once the version moves.
+	store.Update(ctx, "user1", map[string]any{"other": true, "secret": "old"}, nil, Precondition{})
+	_, resp = sync(SyncRequest{BaseVersion: base, Changes: []SyncChange{{Key: "secret", Value: "s", ChangedAt: at(0)}}})
+	if len(resp.Conflicts) != 1 || resp.Conflicts[0].Winner != "client" || resp.Preferences["secret"] != "s" {
+		t.Fatalf("expected a resolved conflict on the sensitive key, got %+v", resp)
+	}
+
+	for name, req := range map[string]SyncRequest{
+		"no changes":     {},
+		"duplicate":      {Changes: []SyncChange{{Key: "a", Value: 1}, {Key: "a", Value: 2}}},
+		"no value":       {Changes: []SyncChange{{Key: "a"}}},
+		"value and gone": {Changes: []SyncChange{{Key: "a", Value: 1, Deleted: true}}},
+		"strategy":       {Strategy: "server-wins", Changes: []SyncChange{{Key: "a", Value: 1}}},
+		"negative base":  {BaseVersion: -1, Changes: []SyncChange{{Key: "a", Value: 1}}},
+	} {
+		if code, _ := sync(req); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, code)
+		}
+	}
+}
+
+// racingStore fails the first conditional Update as if another write had
+// landed first.
+type racingStore struct {
+	*mockStore
+	raced bool
+}
+
+func (s *racingStore) Update(ctx context.Context, userID string, prefs map[string]any, remove []string, cond Precondition) (Record, error) {
+	if !s.raced && len(cond.Versions) > 0 {
+		s.raced = true
+		s.mockStore.Update(ctx, userID, map[string]any{"theme": "light"}, nil, Precondition{})
+		return Record{}, ErrPreconditionFailed
+	}
+	return s.mockStore.Update(ctx, userID, prefs, remove, cond)
+}
+
+func TestHistoryHandler_SyncRetriesRacingWrites(t *testing.T) {
+	store := &racingStore{mockStore: newMockStore()}
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	h := NewHistoryHandler(NewPreferencesHandler(store, testLogger(), HandlerOptions{}), newMemHistory(), 0)
+
+	body := `{"baseVersion":0,"changes":[{"key":"lang","value":"fr"}]}`
+	r := withClaims(httptest.NewRequest("POST", "/api/v1/users/user1/preferences:sync", bytes.NewBufferString(body)), "user1")
+	r.SetPathValue("userId", "user1")
+	w := httptest.NewRecorder()
+	h.Sync(w, r)
+	var resp SyncResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || !store.raced || resp.Preferences["theme"] != "light" || resp.Preferences["lang"] != "fr" {
+		t.Fatalf("expected the merge redone over the racing write, got %d %+v", w.Code, resp)
+	}
+}
+
+func TestHistoryHandler_SyncServerDeletionBeyondHistory(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	store.versions["user1"] = 5
+	h := NewHistoryHandler(NewPreferencesHandler(store, testLogger(), HandlerOptions{}), newMemHistory(), 0)
+
+	// History is empty, so whether "lang" was deleted since version 2 is
+	// unknown: the client's edit conflicts rather than silently reviving it.
+	body := `{"baseVersion":2,"strategy":"client-wins","changes":[{"key":"lang","value":"fr"}]}`
+	r := withClaims(httptest.NewRequest("POST", "/api/v1/users/user1/preferences:sync", bytes.NewBufferString(body)), "user1")
+	r.SetPathValue("userId", "user1")
+	w := httptest.NewRecorder()
+	h.Sync(w, r)
+	var resp SyncResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Conflicts) != 1 || !resp.Conflicts[0].ServerDeleted || resp.Preferences["lang"] != "fr" {
+		t.Fatalf("expected the server deletion reported as a conflict, got %d %+v", w.Code, resp)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiOperation documents one route in the OpenAPI document. Request and
+// response bodies are given as values of the Go types the handlers encode,
+// and their schemas are derived from those types, so the document follows
+// the code. TestOpenAPI_CoversRoutes checks it against server.go.
+type apiOperation struct {
+	method, path string
+	summary      string
+	query        []string
+	request      any
+	response     any
+	// status is the success status; 0 means 200. A nil response with
+	// status 204 has no body.
+	status int
+	// contentType is the success response's media type when it is not
+	// JSON; response then describes each message.
+	contentType string
+}
+
+// userOperations are served to users, internal services and admins on the
+// main listener.
+var userOperations = []apiOperation{
+	{method: "GET", path: "/healthz", summary: "Health check", response: map[string]string{}},
+	{method: "GET", path: "/openapi.json", summary: "This OpenAPI document", response: map[string]any{}},
+	{method: "GET", path: "/api/v1/csrf-token", summary: "Issue a CSRF token for cookie-authenticated clients", response: map[string]string{}},
+	{method: "GET", path: "/api/v1/preference-keys", summary: "List the well-known preference keys", response: PreferenceKeysResponse{}},
+
+	{method: "GET", path: "/api/v1/users/{userId}/preferences", summary: "Get a user's preferences",
+		query: []string{"keys", "prefix", "cursor", "include", "view", "fields"}, response: PreferencesResponse{}},
+	{method: "PUT", path: "/api/v1/users/{userId}/preferences", summary: "Replace a user's preferences",
+		request: map[string]any{}, response: PreferencesResponse{}},
+	{method: "POST", path: "/api/v1/users/{userId}/preferences", summary: "Replace a user's preferences",
+		request: map[string]any{}, response: PreferencesResponse{}},
+	{method: "PATCH", path: "/api/v1/users/{userId}/preferences", summary: "Merge into a user's preferences (JSON Merge Patch supported)",
+		request: map[string]any{}, response: PreferencesResponse{}},
+	{method: "DELETE", path: "/api/v1/users/{userId}/preferences", summary: "Delete all, or the listed, preferences",
+		query: []string{"keys"}, request: DeleteKeysRequest{}, status: http.StatusNoContent},
+	{method: "GET", path: "/api/v1/users/{userId}/preferences/{key}", summary: "Get one preference", response: SinglePrefResponse{}},
+	{method: "PUT", path: "/api/v1/users/{userId}/preferences/{key}", summary: "Set one preference",
+		request: SinglePrefRequest{}, response: SinglePrefResponse{}},
+	{method: "POST", path: "/api/v1/users/{userId}/preferences/{key}", summary: "Create one preference if unset",
+		request: SinglePrefRequest{}, response: SinglePrefResponse{}, status: http.StatusCreated},
+	{method: "DELETE", path: "/api/v1/users/{userId}/preferences/{key}", summary: "Delete one preference", status: http.StatusNoContent},
+	{method: "POST", path: "/api/v1/users/{userId}/preferences:restore", summary: "Restore preferences removed by the last delete",
+		response: PreferencesResponse{}},
+	{method: "POST", path: "/api/v1/users/{userId}/preferences:undo", summary: "Undo a delete or replace with its Undo-Token",
+		request: UndoRequest{}, response: PreferencesResponse{}},
+	{method: "GET", path: "/api/v1/users/{userId}/preferences:resolve", summary: "Resolve preferences across default, org, team and user layers",
+		response: ResolveResponse{}},
+	{method: "GET", path: "/api/v1/users/{userId}/devices", summary: "List the devices with stored preferences",
+		response: DevicesResponse{}},
+	{method: "GET", path: "/api/v1/users/{userId}/devices/{deviceId}/preferences", summary: "Get a device's preferences",
+		response: DevicePreferencesResponse{}},
+	{method: "PUT", path: "/api/v1/users/{userId}/devices/{deviceId}/preferences", summary: "Replace a device's preferences",
+		request: map[string]any{}, response: DevicePreferencesResponse{}},
+	{method: "PATCH", path: "/api/v1/users/{userId}/devices/{deviceId}/preferences", summary: "Merge into a device's preferences (JSON Merge Patch supported)",
+		request: map[string]any{}, response: DevicePreferencesResponse{}},
+	{method: "DELETE", path: "/api/v1/users/{userId}/devices/{deviceId}/preferences", summary: "Delete a device's preferences",
+		status: http.StatusNoContent},
+	{method: "GET", path: "/api/v1/users/{userId}/devices/{deviceId}/preferences:resolve", summary: "Resolve a device's preferences over the account's layers",
+		response: DeviceResolveResponse{}},
+	{method: "GET", path: "/api/v1/users/{userId}/experiments", summary: "Get the user's bucket in every experiment, assigning on first read",
+		response: ExperimentsResponse{}},
+	{method: "GET", path: "/api/v1/users/{userId}/experiments/{experiment}", summary: "Get the user's bucket in one experiment, assigning on first read",
+		response: ExperimentResponse{}},
+	{method: "GET", path: "/api/v1/users/{userId}/preferences/export", summary: "Download preferences as JSON or CSV",
+		query: []string{"format"}, response: ExportDocument{}},
+	{method: "POST", path: "/api/v1/users/{userId}/preferences/import", summary: "Import an export document",
+		query: []string{"mode", "partial"}, request: ExportDocument{}, response: ImportResponse{}},
+	{method: "GET", path: "/api/v1/users/{userId}/preferences/stream", summary: "Stream change events as Server-Sent Events (each data is a ChangeEvent)",
+		query: []string{"keys"}, response: ChangeEvent{}, contentType: "text/event-stream"},
+	{method: "GET", path: "/api/v1/users/{userId}/preferences:subscribe", summary: "Open a WebSocket receiving change events (SyncMessage frames)",
+		query: []string{"keys"}, response: SyncMessage{}, status: http.StatusSwitchingProtocols},
+	{method: "GET", path: "/api/v1/users/{userId}/preferences/history", summary: "List preference history, newest first",
+		query: []string{"limit", "cursor"}, response: HistoryResponse{}},
+	{method: "GET", path: "/api/v1/users/{userId}/preferences/changes", summary: "Get the changes since a cursor or time as a merge patch (delta sync)",
+		query: []string{"since", "limit"}, response: DeltaResponse{}},
+	{method: "POST", path: "/api/v1/users/{userId}/preferences:sync", summary: "Merge offline changes, resolving conflicts per key",
+		request: SyncRequest{}, response: SyncResponse{}},
+	{method: "POST", path: "/api/v1/users/{userId}/preferences/versions/{version}", summary: "Restore a history entry ({id}:restore)",
+		response: PreferencesResponse{}},
+	{method: "GET", path: "/api/v1/users/{userId}/preferences/versions/{a}/diff/{b}", summary: "Compare two versions", response: DiffResponse{}},
+	{method: "POST", path: "/api/v1/users/{userId}/corrections", summary: "Report a wrong preference value",
+		request: createCorrectionRequest{}, response: CorrectionRequest{}, status: http.StatusCreated},
+	{method: "GET", path: "/api/v1/users/{userId}/corrections", summary: "List the user's correction requests", response: CorrectionsResponse{}},
+
+	{method: "GET", path: "/api/v2/users/{userId}/preferences", summary: "Get a user's preferences",
+		query: []string{"keys", "prefix", "cursor", "include", "view", "fields"}, response: PreferencesEnvelope{}},
+	{method: "PUT", path: "/api/v2/users/{userId}/preferences", summary: "Replace a user's preferences",
+		request: map[string]any{}, response: PreferencesEnvelope{}},
+	{method: "PATCH", path: "/api/v2/users/{userId}/preferences", summary: "Merge into a user's preferences (JSON Merge Patch supported)",
+		request: map[string]any{}, response: PreferencesEnvelope{}},
+	{method: "DELETE", path: "/api/v2/users/{userId}/preferences", summary: "Delete all, or the listed, preferences",
+		query: []string{"keys"}, request: DeleteKeysRequest{}, status: http.StatusNoContent},
+	{method: "GET", path: "/api/v2/users/{userId}/preferences/{key}", summary: "Get one preference",
+		query: []string{"fields"}, response: PreferenceEnvelope{}},
+	{method: "PUT", path: "/api/v2/users/{userId}/preferences/{key}", summary: "Set one preference",
+		request: SinglePrefRequest{}, response: PreferenceEnvelope{}},
+	{method: "POST", path: "/api/v2/users/{userId}/preferences/{key}", summary: "Create one preference if unset",
+		request: SinglePrefRequest{}, response: PreferenceEnvelope{}, status: http.StatusCreated},
+	{method: "DELETE", path: "/api/v2/users/{userId}/preferences/{key}", summary: "Delete one preference", status: http.StatusNoContent},
+
+	{method: "POST", path: "/api/v1/internal/preferences:batchGet", summary: "Read many users' preferences",
+		request: BatchGetRequest{}, response: BatchGetResponse{}},
+	{method: "POST", path: "/api/v1/internal/preferences:batchSet", summary: "Set one key for many users",
+		request: BatchSetRequest{}, response: BatchResponse{}},
+	{method: "POST", path: "/api/v1/internal/preferences:batchDelete", summary: "Delete keys for many users",
+		request: BatchDeleteRequest{}, response: BatchResponse{}},
+	{method: "POST", path: "/api/v1/internal/users/{userId}/preferences:applyTemplate", summary: "Seed a new user's preferences from a template",
+		request: ApplyTemplateRequest{}, response: PreferencesResponse{}, status: http.StatusCreated},
+	{method: "POST", path: "/api/v1/internal/webhooks", summary: "Register a webhook for preference change events",
+		request: WebhookRequest{}, response: Webhook{}, status: http.StatusCreated},
+	{method: "GET", path: "/api/v1/internal/webhooks", summary: "List the caller's webhooks", response: WebhooksResponse{}},
+	{method: "GET", path: "/api/v1/internal/webhooks/{id}", summary: "Get one of the caller's webhooks", response: Webhook{}},
+	{method: "PUT", path: "/api/v1/internal/webhooks/{id}", summary: "Replace one of the caller's webhooks",
+		request: WebhookRequest{}, response: Webhook{}},
+	{method: "DELETE", path: "/api/v1/internal/webhooks/{id}", summary: "Delete one of the caller's webhooks", status: http.StatusNoContent},
+	{method: "GET", path: "/api/v1/internal/webhooks/{id}/deliveries", summary: "List deliveries to one of the caller's webhooks",
+		query: []string{"status"}, response: WebhookDeliveriesResponse{}},
+	{method: "POST", path: "/api/v1/internal/webhooks/{id}/deliveries:redrive", summary: "Send failed deliveries to one of the caller's webhooks again",
+		request: WebhookRedriveRequest{}, response: WebhookRedriveResponse{}, status: http.StatusAccepted},
+}
+
+// adminOperations are served under /api/v1/admin, on the main listener or
+// ADMIN_PORT.
+var adminOperations = []apiOperation{
+	{method: "GET", path: "/api/v1/admin/corrections", summary: "List correction requests",
+		query: []string{"userId", "status"}, response: CorrectionsResponse{}},
+	{method: "POST", path: "/api/v1/admin/corrections/{id}/resolve", summary: "Resolve a correction request",
+		request: resolveCorrectionRequest{}, response: CorrectionRequest{}},
+	{method: "GET", path: "/api/v1/admin/webhooks", summary: "List webhooks across owners",
+		query: []string{"owner"}, response: WebhooksResponse{}},
+	{method: "DELETE", path: "/api/v1/admin/webhooks/{id}", summary: "Delete any webhook", status: http.StatusNoContent},
+	{method: "GET", path: "/api/v1/admin/webhooks/{id}/deliveries", summary: "List deliveries to any webhook",
+		query: []string{"status"}, response: WebhookDeliveriesResponse{}},
+	{method: "POST", path: "/api/v1/admin/webhooks/{id}/deliveries:redrive", summary: "Send failed deliveries to any webhook again",
+		request: WebhookRedriveRequest{}, response: WebhookRedriveResponse{}, status: http.StatusAccepted},
+	{method: "DELETE", path: "/api/v1/admin/users/{userId}", summary: "Erase all data held for a user", response: ErasureResponse{}},
+	{method: "POST", path: "/api/v1/admin/users/{userId}/preferences:copyTo", summary: "Copy preferences to another user",
+		request: CopyRequest{}, response: CopyResponse{}},
+	{method: "GET", path: "/api/v1/admin/users", summary: "List the users with stored preferences",
+		query: []string{"limit", "cursor"}, response: UsersResponse{}},
+	{method: "GET", path: "/api/v1/admin/users/{userId}/preferences", summary: "Get any user's preferences",
+		query: []string{"keys", "prefix", "cursor", "include", "view"}, response: PreferencesResponse{}},
+	{method: "PUT", path: "/api/v1/admin/users/{userId}/preferences", summary: "Replace any user's preferences",
+		request: map[string]any{}, response: PreferencesResponse{}},
+	{method: "PATCH", path: "/api/v1/admin/users/{userId}/preferences", summary: "Merge into any user's preferences",
+		request: map[string]any{}, response: PreferencesResponse{}},
+	{method: "DELETE", path: "/api/v1/admin/users/{userId}/preferences", summary: "Delete all, or the listed, preferences of any user",
+		query: []string{"keys"}, request: DeleteKeysRequest{}, status: http.StatusNoContent},
+	{method: "GET", path: "/api/v1/admin/users/{userId}/preferences/{key}", summary: "Get one of any user's preferences", response: SinglePrefResponse{}},
+	{method: "PUT", path: "/api/v1/admin/users/{userId}/preferences/{key}", summary: "Set one of any user's preferences",
+		request: SinglePrefRequest{}, response: SinglePrefResponse{}},
+	{method: "DELETE", path: "/api/v1/admin/users/{userId}/preferences/{key}", summary: "Delete one of any user's preferences", status: http.StatusNoContent},
+	{method: "GET", path: "/api/v1/admin/users/{userId}/preferences/export", summary: "Download any user's preferences as JSON or CSV",
+		query: []string{"format"}, response: ExportDocument{}},
+	{method: "POST", path: "/api/v1/admin/users/{userId}/preferences/import", summary: "Import an export document for any user",
+		query: []string{"mode", "partial"}, request: ExportDocument{}, response: ImportResponse{}},
+	{method: "GET", path: "/api/v1/admin/users/{userId}/preferences/stream", summary: "Stream any user's change events as Server-Sent Events",
+		query: []string{"keys"}, response: ChangeEvent{}, contentType: "text/event-stream"},
+	{method: "GET", path: "/api/v1/admin/orgs/{id}/preferences", summary: "Get an org layer", response: LayerResponse{}},
+	{method: "PUT", path: "/api/v1/admin/orgs/{id}/preferences", summary: "Replace an org layer", request: map[string]any{}, response: LayerResponse{}},
+	{method: "PATCH", path: "/api/v1/admin/orgs/{id}/preferences", summary: "Merge into an org layer", request: map[string]any{}, response: LayerResponse{}},
+	{method: "DELETE", path: "/api/v1/admin/orgs/{id}/preferences", summary: "Delete an org layer", status: http.StatusNoContent},
+	{method: "GET", path: "/api/v1/admin/teams/{id}/preferences", summary: "Get a team layer", response: LayerResponse{}},
+	{method: "PUT", path: "/api/v1/admin/teams/{id}/preferences", summary: "Replace a team layer", request: map[string]any{}, response: LayerResponse{}},
+	{method: "PATCH", path: "/api/v1/admin/teams/{id}/preferences", summary: "Merge into a team layer", request: map[string]any{}, response: LayerResponse{}},
+	{method: "DELETE", path: "/api/v1/admin/teams/{id}/preferences", summary: "Delete a team layer", status: http.StatusNoContent},
+	{method: "GET", path: "/api/v1/admin/users/{userId}/membership", summary: "Get a user's org and team", response: Membership{}},
+	{method: "PUT", path: "/api/v1/admin/users/{userId}/membership", summary: "Set a user's org and team", request: Membership{}, response: Membership{}},
+	{method: "GET", path: "/api/v1/admin/stats", summary: "Aggregate preference statistics", query: []string{"refresh"}, response: PreferenceStats{}},
+	{method: "GET", path: "/api/v1/admin/preferences/search", summary: "Find users by preference key or value",
+		query: []string{"key", "value", "limit", "cursor"}, response: SearchResponse{}},
+	{method: "GET", path: "/api/v1/admin/schema", summary: "Get the preference schema", response: PreferenceSchema{}},
+	{method: "PUT", path: "/api/v1/admin/schema", summary: "Replace the preference schema", request: PreferenceSchema{}, response: PreferenceSchema{}},
+	{method: "GET", path: "/api/v1/admin/schema/keys/{name}", summary: "Get a key definition", response: KeyDef{}},
+	{method: "PUT", path: "/api/v1/admin/schema/keys/{name}", summary: "Declare or redefine a key", request: KeyDef{}, response: PreferenceSchema{}},
+	{method: "DELETE", path: "/api/v1/admin/schema/keys/{name}", summary: "Remove a key definition", response: PreferenceSchema{}},
+	{method: "GET", path: "/api/v1/admin/users/{userId}/preferences/history", summary: "List any user's preference history",
+		query: []string{"limit", "cursor"}, response: HistoryResponse{}},
+	{method: "GET", path: "/api/v1/admin/users/{userId}/preferences/versions/{a}/diff/{b}", summary: "Compare two versions of any user's preferences",
+		response: DiffResponse{}},
+	{method: "GET", path: "/api/v1/admin/auth/preflight", summary: "Re-run the auth configuration preflight", response: PreflightReport{}},
+	{method: "GET", path: "/api/v1/admin/failover", summary: "Get failover status", response: FailoverStatus{}},
+	{method: "POST", path: "/api/v1/admin/failover", summary: "Switch reads between primary and standby",
+		request: struct {
+			Mode string `json:"mode"`
+		}{}, response: FailoverStatus{}},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  []byte
+)
+
+// OpenAPI serves the OpenAPI 3 document describing every route the service
+// can expose; routes for disabled features are included.
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIDoc, _ = json.Marshal(buildOpenAPI())
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDoc)
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// buildOpenAPI assembles the document from the operation tables.
+func buildOpenAPI() map[string]any {
+	g := &schemaGen{components: map[string]any{}}
+	paths := map[string]map[string]any{}
+	add := func(ops []apiOperation, tag string, security []map[string][]string) {
+		for _, op := range ops {
+			item := paths[op.path]
+			if item == nil {
+				item = map[string]any{}
+				paths[op.path] = item
+			}
+			status := op.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			var params []map[string]any
+			for _, m := range pathParam.FindAllStringSubmatch(op.path, -1) {
+				params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+			}
+			for _, q := range op.query {
+				params = append(params, map[string]any{"name": q, "in": "query", "schema": map[string]any{"type": "string"}})
+			}
+
+			success := map[string]any{"description": http.StatusText(status)}
+			if op.response != nil {
+				success["content"] = jsonContent(g.schema(reflect.TypeOf(op.response)))
+				if op.contentType != "" {
+					success["content"] = map[string]any{op.contentType: map[string]any{"schema": g.schema(reflect.TypeOf(op.response))}}
+				}
+				// Internal endpoints also answer in protobuf (writeResponse).
+				if _, ok := op.response.(protoResponse); ok && strings.HasPrefix(op.path, "/api/v1/internal/") {
+					success["content"].(map[string]any)[protobufContentType] = map[string]any{"schema": map[string]any{
+						"description": "userprefs.v1." + reflect.TypeOf(op.response).Name() + " (proto/userprefs/v1/responses.proto)",
+					}}
+				}
+			}
+			failure := map[string]any{"description": "Error", "content": jsonContent(g.schema(reflect.TypeOf(APIError{})))}
+			if strings.HasPrefix(op.path, "/api/v2/") {
+				failure["content"] = map[string]any{problemContentType: map[string]any{"schema": g.schema(reflect.TypeOf(Problem{}))}}
+			}
+			operation := map[string]any{
+				"operationId": operationID(op.method, op.path),
+				"summary":     op.summary,
+				"tags":        []string{tag},
+				"responses": map[string]any{
+					strconv.Itoa(status): success,
+					"default":            failure,
+				},
+			}
+			if params != nil {
+				operation["parameters"] = params
+			}
+			if op.request != nil {
+				operation["requestBody"] = map[string]any{"content": jsonContent(g.schema(reflect.TypeOf(op.request)))}
+			}
+			if strings.HasPrefix(op.path, "/api/") && op.path != "/api/v1/csrf-token" {
+				operation["security"] = security
+			}
+			item[strings.ToLower(op.method)] = operation
+		}
+	}
+	add(userOperations, "preferences", []map[string][]string{{"bearerAuth": {}}})
+	add(adminOperations, "admin", []map[string][]string{{"adminApiKey": {}}, {"bearerAuth": {}}})
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "user-prefs",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth":  map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"adminApiKey": map[string]any{"type": "apiKey", "in": "header", "name": "Authorization", "description": "ApiKey <key>"},
+			},
+		},
+	}
+}
+
+// operationID names an operation for generated clients: the method and
+// the path's literal segments, with a final parameter as By<Param>. For
+// example, GET /api/v1/users/{userId}/preferences/{key} is
+// getUsersPreferencesByKey; v2 routes end in V2.
+func operationID(method, path string) string {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	id, suffix := strings.ToLower(method), ""
+	for i, seg := range segs {
+		switch {
+		case i == 0 && seg == "api", i == 1 && seg == "v1":
+		case i == 1 && seg == "v2":
+			suffix = "V2"
+		case strings.HasPrefix(seg, "{"):
+			if i == len(segs)-1 {
+				id += "By" + goIdent(strings.Trim(seg, "{}"))
+			}
+		default:
+			id += goIdent(seg)
+		}
+	}
+	return id + suffix
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// schemaGen derives JSON Schemas from Go types, collecting named structs
+// as components.
+type schemaGen struct {
+	components map[string]any
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawMessageType, t.Kind() == reflect.Interface:
+		// Any JSON value.
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := g.components[name]; !ok {
+			// Reserve the name first in case the type refers to itself.
+			g.components[name] = nil
+			g.components[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// object describes a struct's JSON fields.
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		// Embedded structs' fields are promoted, as in encoding/json.
+		if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			embedded := g.object(f.Type)
+			maps.Copy(props, embedded["properties"].(map[string]any))
+			if r, ok := embedded["required"].([]string); ok {
+				required = append(required, r...)
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+		// Fields without omitempty are always encoded, if only as null.
+		if tagOpts := strings.Split(opts, ","); !slices.Contains(tagOpts, "omitempty") && !slices.Contains(tagOpts, "omitzero") {
+			required = append(required, name)
+		}
+	}
+	obj := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		slices.Sort(required)
+		obj["required"] = required
+	}
+	return obj
+}
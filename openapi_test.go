@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPI_Serve(t *testing.T) {
+	w := httptest.NewRecorder()
+	OpenAPI(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected 200 JSON, got %d %v", w.Code, w.Header())
+	}
+
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("expected an OpenAPI 3 document, got %q", doc.OpenAPI)
+	}
+	op := doc.Paths["/api/v1/users/{userId}/preferences/{key}"]["put"]
+	if op == nil || op["requestBody"] == nil || op["security"] == nil {
+		t.Fatalf("expected a secured PUT with a body, got %v", op)
+	}
+
+	// Every referenced schema is defined, with its JSON field names.
+	for _, name := range []string{"PreferencesResponse", "APIError", "KeyDef", "CreateCorrectionRequest"} {
+		if doc.Components.Schemas[name] == nil {
+			t.Errorf("missing component %s", name)
+		}
+	}
+	ids := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			id, _ := op["operationId"].(string)
+			if id == "" || ids[id] {
+				t.Errorf("%s %s: missing or duplicate operationId %q", method, path, id)
+			}
+			ids[id] = true
+		}
+	}
+	if !ids["getUsersPreferencesByKey"] || !ids["postInternalPreferencesBatchGet"] || !ids["getUsersPreferencesV2"] {
+		t.Errorf("unexpected operationIds %v", ids)
+	}
+	if req := doc.Components.Schemas["APIError"]["required"]; len(req.([]any)) != 3 {
+		t.Errorf("expected the fields without omitempty required, got %v", req)
+	}
+
+	props := doc.Components.Schemas["SinglePrefResponse"]["properties"].(map[string]any)
+	if _, ok := props["value"]; !ok {
+		t.Fatalf("expected json field names, got %v", props)
+	}
+}
+
+// TestOpenAPI_CoversRoutes keeps the operation tables in step with the routes
+// registered in server.go.
+func TestOpenAPI_CoversRoutes(t *testing.T) {
+	src, err := os.ReadFile("server.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	documented := map[string]bool{}
+	for _, op := range append(userOperations, adminOperations...) {
+		key := op.method + " " + op.path
+		if documented[key] {
+			t.Errorf("%s documented twice", key)
+		}
+		documented[key] = true
+	}
+
+	registered := map[string]bool{}
+	for _, m := range regexp.MustCompile(`"((?:GET|PUT|POST|PATCH|DELETE) /[^"]*)"`).FindAllSubmatch(src, -1) {
+		registered[string(m[1])] = true
+	}
+	// Layer routes are registered in a loop over the layer kinds.
+	for _, kind := range []string{"orgs", "teams"} {
+		for _, method := range []string{"GET", "PUT", "PATCH", "DELETE"} {
+			registered[method+" /api/v1/admin/"+kind+"/{id}/preferences"] = true
+		}
+	}
+
+	for route := range registered {
+		if !documented[route] {
+			t.Errorf("%s is not in the OpenAPI document", route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			t.Errorf("%s is documented but not registered", route)
+		}
+	}
+}
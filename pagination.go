@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// truncatedHeader marks a response that holds only part of the preference map.
+const truncatedHeader = "X-Preferences-Truncated"
+
+// encodeCursor turns the last key of a page into an opaque continuation cursor.
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeCursor returns the key a page ended on.
+func decodeCursor(cursor string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("decoding cursor: %w", err)
+	}
+	return string(b), nil
+}
+
+// pagePrefs returns, in key order, the preferences after the key `after`
+// that fit within budget bytes of encoded JSON, plus the cursor for the next
+// page ("" when the page reaches the end). A page always holds at least one
+// key so that clients make progress even if a single entry exceeds the budget.
+func pagePrefs(prefs map[string]any, after string, budget int) (map[string]any, string) {
+	keys := make([]string, 0, len(prefs))
+	for k := range prefs {
+		if k > after {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	page := make(map[string]any)
+	used := 0
+	for i, k := range keys {
+		n := pairSize(k, prefs[k])
+		if used+n > budget && len(page) > 0 {
+			return page, encodeCursor(keys[i-1])
+		}
+		page[k] = prefs[k]
+		used += n
+	}
+	return page, ""
+}
+
+// pageBudget is the number of bytes left for preference entries once the
+// response envelope, including a worst-case cursor, is accounted for.
+func pageBudget(maxBytes int, envelope PreferencesResponse, prefs map[string]any) int {
+	if maxBytes <= 0 {
+		return math.MaxInt
+	}
+	longest := 0
+	for k := range prefs {
+		longest = max(longest, len(k))
+	}
+	envelope.Preferences = map[string]any{}
+	envelope.NextCursor = encodeCursor(string(make([]byte, longest)))
+	b, _ := json.Marshal(envelope)
+	return maxBytes - len(b)
+}
+
+// pairSize is the encoded size of one "key":"value", entry.
+func pairSize(k string, v any) int {
+	kb, _ := json.Marshal(k)
+	vb, _ := json.Marshal(v)
+	return len(kb) + len(vb) + 2
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Preflight modes selectable via JWT_PREFLIGHT.
+const (
+	PreflightOff    = "off"
+	PreflightWarn   = "warn"
+	PreflightStrict = "strict"
+)
+
+// PreflightCheck is the outcome of one auth configuration check.
+type PreflightCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// PreflightReport summarizes whether the JWT configuration can actually
+// validate tokens from the identity provider.
+type PreflightReport struct {
+	OK     bool             `json:"ok"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+func (r *PreflightReport) add(name string, err error) {
+	c := PreflightCheck{Name: name, OK: err == nil}
+	if err != nil {
+		c.Detail = err.Error()
+		r.OK = false
+	}
+	r.Checks = append(r.Checks, c)
+}
+
+// RunPreflight checks the JWT settings against the identity provider: the
+// configured issuer must match the IdP's OIDC discovery document, and the
+// optional sample token (JWT_PREFLIGHT_TOKEN) must pass signature, issuer, and
+// audience checks. Misconfigurations otherwise surface only as opaque 401s.
+func RunPreflight(ctx context.Context, cfg Config, client *http.Client) PreflightReport {
+	report := PreflightReport{OK: true, Checks: []PreflightCheck{}}
+	if cfg.AuthMode != AuthModeJWT {
+		return report
+	}
+
+	if strings.HasPrefix(cfg.JWTIssuer, "https://") || strings.HasPrefix(cfg.JWTIssuer, "http://") {
+		report.add("issuer_discovery", checkDiscovery(ctx, cfg.JWTIssuer, client))
+	}
+
+	if cfg.JWTPreflightToken != "" {
+		report.add("sample_token", checkSampleToken(cfg))
+	}
+
+	return report
+}
+
+// checkDiscovery compares the configured issuer with the discovery document.
+func checkDiscovery(ctx context.Context, issuer string, client *http.Client) error {
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return fmt.Errorf("building discovery request: %w", err)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching %s: %w", discoveryURL, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: status %d", discoveryURL, res.StatusCode)
+	}
+
+	var doc struct {
+		Issuer string `json:"issuer"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("decoding discovery document: %w", err)
+	}
+
+	if doc.Issuer != issuer {
+		hint := ""
+		if strings.TrimSuffix(doc.Issuer, "/") == strings.TrimSuffix(issuer, "/") {
+			hint = " (trailing slash differs; tokens carry the IdP's exact value)"
+		}
+		return fmt.Errorf("JWT_ISSUER is %q but the IdP advertises %q%s", issuer, doc.Issuer, hint)
+	}
+	return nil
+}
+
+// checkSampleToken validates the sample token with the server's settings,
+// ignoring expiry so a long-lived fixture token keeps working, and reports the
+// specific reason for any failure.
+func checkSampleToken(cfg Config) error {
+	opts := jwtOptions(cfg)
+	token, err := jwt.Parse(cfg.JWTPreflightToken, opts.keyFunc,
+		jwt.WithValidMethods(opts.algorithms()), jwt.WithoutClaimsValidation())
+	if err != nil {
+		switch {
+		case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+			return errors.New("signature does not verify with JWT_SECRET or JWT_PUBLIC_KEY_FILE")
+		case errors.Is(err, jwt.ErrTokenUnverifiable):
+			return fmt.Errorf("token algorithm is not accepted: %w", err)
+		default:
+			return fmt.Errorf("token does not parse: %w", err)
+		}
+	}
+
+	if cfg.JWTIssuer != "" {
+		iss, _ := token.Claims.GetIssuer()
+		if iss != cfg.JWTIssuer {
+			return fmt.Errorf("token issuer %q does not match JWT_ISSUER %q", iss, cfg.JWTIssuer)
+		}
+	}
+
+	aud, _ := token.Claims.GetAudience()
+	if _, ok := cfg.JWTAudiences.classify(aud); !ok {
+		return fmt.Errorf("token audience %v matches neither JWT_BROWSER_AUDIENCES nor JWT_SERVICE_AUDIENCES", []string(aud))
+	}
+
+	if sub, _ := token.Claims.GetSubject(); sub == "" {
+		return errors.New("token has no subject claim")
+	}
+	return nil
+}
+
+// PreflightHandler re-runs the preflight checks on demand.
+func PreflightHandler(cfg Config) http.HandlerFunc {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := RunPreflight(r.Context(), cfg, client)
+		status := http.StatusOK
+		if !report.OK {
+			status = http.StatusConflict
+		}
+		writeJSON(w, status, report)
+	}
+}
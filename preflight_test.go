@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRunPreflight_IssuerMismatch(t *testing.T) {
+	var idp *httptest.Server
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"issuer":%q}`, idp.URL+"/")
+	}))
+	defer idp.Close()
+
+	cfg := Config{AuthMode: AuthModeJWT, JWTSecret: testSecret, JWTIssuer: idp.URL}
+	report := RunPreflight(context.Background(), cfg, idp.Client())
+
+	if report.OK {
+		t.Fatal("expected preflight to fail on issuer mismatch")
+	}
+	if !strings.Contains(report.Checks[0].Detail, "trailing slash") {
+		t.Fatalf("expected trailing slash hint, got %q", report.Checks[0].Detail)
+	}
+}
+
+func TestRunPreflight_SampleToken(t *testing.T) {
+	cfg := Config{
+		AuthMode:     AuthModeJWT,
+		JWTSecret:    testSecret,
+		JWTIssuer:    "prefs-idp",
+		JWTAudiences: AudiencePolicy{Browser: []string{"prefs-web"}},
+	}
+
+	cases := []struct {
+		name   string
+		token  string
+		ok     bool
+		detail string
+	}{
+		{"valid", makeTokenWithClaims(jwt.MapClaims{"sub": "u", "iss": "prefs-idp", "aud": "prefs-web"}, testSecret), true, ""},
+		{"wrong secret", makeTokenWithClaims(jwt.MapClaims{"sub": "u", "iss": "prefs-idp", "aud": "prefs-web"}, "other"), false, "JWT_SECRET"},
+		{"wrong issuer", makeTokenWithClaims(jwt.MapClaims{"sub": "u", "iss": "elsewhere", "aud": "prefs-web"}, testSecret), false, "JWT_ISSUER"},
+		{"wrong audience", makeTokenWithClaims(jwt.MapClaims{"sub": "u", "iss": "prefs-idp", "aud": "x"}, testSecret), false, "audience"},
+	}
+	for _, tc := range cases {
+		cfg.JWTPreflightToken = tc.token
+		report := RunPreflight(context.Background(), cfg, http.DefaultClient)
+		if report.OK != tc.ok {
+			t.Errorf("%s: expected ok=%v, got %+v", tc.name, tc.ok, report)
+			continue
+		}
+		if !tc.ok && !strings.Contains(report.Checks[0].Detail, tc.detail) {
+			t.Errorf("%s: expected detail mentioning %q, got %q", tc.name, tc.detail, report.Checks[0].Detail)
+		}
+	}
+}
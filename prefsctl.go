@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+const ctlUsage = `usage: prefsctl [-url URL] [-token TOKEN] <command> [args]
+
+Support commands against the admin API, as user-prefs ctl or a binary
+named prefsctl. -url and -token default to PREFSCTL_URL and PREFSCTL_TOKEN;
+JWTs are sent as bearer tokens, other tokens as admin API keys.
+
+Commands:
+  get <userId> [key]                       print a user's preferences, or one key
+  set <userId> <key> [--] <value>          set a key; value is JSON, or else a string
+  delete <userId> [key]                    delete a user's preferences, or one key
+  export <userId> [-format json|csv]       print a user's export document
+  import <userId> [-mode merge|replace] [-file PATH]
+                                           import an export document (default stdin)
+  users                                    list the users with stored preferences
+  tail <userId> [-keys a,b]                print a user's change events as they happen
+  import-flags -source launchdarkly|unleash [-env ENV] [-namespace flags.] [-file PATH] [-dry-run]
+                                           set the per-user values of a feature-flag export
+                                           as preferences (default stdin)
+`
+
+// ctlClient calls the admin API for prefsctl.
+type ctlClient struct {
+	baseURL string
+	auth    string
+	client  *http.Client
+}
+
+// runCtl implements "user-prefs ctl" (prefsctl), which gives support the
+// admin API's access to any user's preferences instead of editing table
+// items by hand.
+func runCtl(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("prefsctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, ctlUsage) }
+	baseURL := fs.String("url", envOrDefault("PREFSCTL_URL", "http://localhost:8080"), "base URL of the service or its admin listener")
+	token := fs.String("token", os.Getenv("PREFSCTL_TOKEN"), "admin API key or admin JWT")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprint(stderr, ctlUsage)
+		return 2
+	}
+	if *token == "" {
+		fmt.Fprintln(stderr, "prefsctl: -token or PREFSCTL_TOKEN is required")
+		return 2
+	}
+
+	c := &ctlClient{baseURL: strings.TrimRight(*baseURL, "/"), auth: ctlAuthorization(*token), client: http.DefaultClient}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	err := c.run(ctx, cmd, rest, stdin, stdout, stderr)
+	var usageErr ctlUsageError
+	switch {
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(stderr, "prefsctl %s: %v\n\n%s", cmd, err, ctlUsage)
+		return 2
+	case err != nil:
+		fmt.Fprintf(stderr, "prefsctl %s: %v\n", cmd, err)
+		return 1
+	}
+	return 0
+}
+
+// ctlUsageError reports a command used wrongly.
+type ctlUsageError string
+
+func (e ctlUsageError) Error() string { return string(e) }
+
+// ctlAuthorization is the Authorization header for token.
+func ctlAuthorization(token string) string {
+	if strings.Count(token, ".") == 2 {
+		return "Bearer " + token
+	}
+	return "ApiKey " + token
+}
+
+func (c *ctlClient) run(ctx context.Context, cmd string, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("prefsctl "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	format := fs.String("format", "json", "export format (export)")
+	mode := fs.String("mode", "merge", "merge into or replace the stored preferences (import)")
+	file := fs.String("file", "-", "export document to import, - for stdin (import)")
+	keys := fs.String("keys", "", "comma-separated keys to follow (tail)")
+	source := fs.String("source", "", "feature-flag system the export is from: launchdarkly or unleash (import-flags)")
+	env := fs.String("env", "production", "environment whose targeting to import (import-flags)")
+	namespace := fs.String("namespace", "flags.", "prefix of the imported keys (import-flags)")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without writing (import-flags)")
+
+	// Flags may follow the positional arguments.
+	var pos []string
+	for len(args) > 0 {
+		if err := fs.Parse(args); err != nil {
+			return ctlUsageError(err.Error())
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	nargs := func(lo, hi int) error {
+		if len(pos) < lo || len(pos) > hi {
+			return ctlUsageError("wrong number of arguments")
+		}
+		return nil
+	}
+	user := func() string { return "/api/v1/admin/users/" + url.PathEscape(pos[0]) + "/preferences" }
+	key := func() string { return user() + "/" + url.PathEscape(pos[1]) }
+
+	switch cmd {
+	case "get":
+		if err := nargs(1, 2); err != nil {
+			return err
+		}
+		path := user()
+		if len(pos) == 2 {
+			path = key()
+		}
+		return c.printJSON(ctx, http.MethodGet, path, nil, stdout)
+	case "set":
+		if err := nargs(3, 3); err != nil {
+			return err
+		}
+		value := json.RawMessage(pos[2])
+		if !json.Valid(value) {
+			value, _ = json.Marshal(pos[2])
+		}
+		body, _ := json.Marshal(SinglePrefRequest{Value: value})
+		return c.printJSON(ctx, http.MethodPut, key(), body, stdout)
+	case "delete":
+		if err := nargs(1, 2); err != nil {
+			return err
+		}
+		path := user()
+		if len(pos) == 2 {
+			path = key()
+		}
+		resp, err := c.do(ctx, http.MethodDelete, path, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	case "export":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		resp, err := c.do(ctx, http.MethodGet, user()+"/export?format="+url.QueryEscape(*format), nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(stdout, resp.Body)
+		return err
+	case "import":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		doc, err := readCtlFile(*file, stdin)
+		if err != nil {
+			return err
+		}
+		return c.printJSON(ctx, http.MethodPost, user()+"/import?mode="+url.QueryEscape(*mode), doc, stdout)
+	case "users":
+		if err := nargs(0, 0); err != nil {
+			return err
+		}
+		return c.users(ctx, stdout)
+	case "tail":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		path := user() + "/stream"
+		if *keys != "" {
+			path += "?keys=" + url.QueryEscape(*keys)
+		}
+		return c.tail(ctx, path, stdout)
+	case "import-flags":
+		if err := nargs(0, 0); err != nil {
+			return err
+		}
+		if *source == "" {
+			return ctlUsageError("-source is required")
+		}
+		data, err := readCtlFile(*file, stdin)
+		if err != nil {
+			return err
+		}
+		fi, err := ParseFlagExport(*source, data, *env, *namespace)
+		if err != nil {
+			return err
+		}
+		fi.Report(stdout)
+		if *dryRun {
+			fmt.Fprintln(stdout, "Dry run: nothing written.")
+			return nil
+		}
+		return c.importFlags(ctx, fi, stdout, stderr)
+	}
+	return ctlUsageError(fmt.Sprintf("unknown command %q", cmd))
+}
+
+func readCtlFile(path string, stdin io.Reader) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// do sends a request, returning an error for non-2xx responses.
+func (c *ctlClient) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", c.auth)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e APIError
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("%s (%d %s)", e.Error, resp.StatusCode, e.ErrorCode)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp, nil
+}
+
+// printJSON sends a request and prints its JSON response indented.
+func (c *ctlClient) printJSON(ctx context.Context, method, path string, body []byte, stdout io.Writer) error {
+	resp, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, bytes.TrimSpace(raw), "", "  "); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(stdout)
+	return err
+}
+
+// users prints every user ID, one per line, following the cursor.
+func (c *ctlClient) users(ctx context.Context, stdout io.Writer) error {
+	cursor := ""
+	for {
+		path := fmt.Sprintf("/api/v1/admin/users?limit=%d", maxSearchLimit)
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+		resp, err := c.do(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return err
+		}
+		var page UsersResponse
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+		for _, id := range page.Users {
+			fmt.Fprintln(stdout, id)
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// tail prints the data of each Server-Sent Event, a ChangeEvent per line,
+// until interrupted or the server closes the stream.
+func (c *ctlClient) tail(ctx context.Context, path string, stdout io.Writer) error {
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			fmt.Fprintln(stdout, data)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return sc.Err()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pagedSearcher lists users a page of one at a time.
+type pagedSearcher struct{ users []string }
+
+func (p *pagedSearcher) SearchUsers(_ context.Context, _ SearchQuery, _ int, after string) ([]SearchMatch, string, error) {
+	for i, u := range p.users {
+		if "USER#"+u > after || after == "" {
+			next := ""
+			if i < len(p.users)-1 {
+				next = "USER#" + u
+			}
+			return []SearchMatch{{UserID: u}}, next, nil
+		}
+	}
+	return nil, "", nil
+}
+
+func TestRunCtl(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	prefs := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+	hs := Handlers{Prefs: prefs, Corrections: NewCorrectionsHandler(prefs, newMockCorrectionStore()),
+		Search: NewSearchHandler(prefs, &pagedSearcher{users: []string{"user1", "user2"}})}
+	keys, _ := parseAdminKeys([]string{"support:0123456789abcdef0123"})
+	srv := httptest.NewServer(NewRouter(hs, Config{AuthMode: AuthModeJWT, JWTSecret: testSecret, AdminAPIKeys: keys}, testLogger()))
+	defer srv.Close()
+
+	ctl := func(stdin string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runCtl(append([]string{"-url", srv.URL, "-token", "0123456789abcdef0123"}, args...), strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	if code, out, errOut := ctl("", "set", "user1", "fontSize", "14"); code != 0 || !strings.Contains(out, `"value": 14`) {
+		t.Fatalf("set: %d %s %s", code, out, errOut)
+	}
+	if code, _, _ := ctl("", "set", "user1", "greeting", "hello there"); code != 0 || store.prefs["user1"]["greeting"] != "hello there" {
+		t.Fatalf("expected a non-JSON value set as a string, got %d %+v", code, store.prefs["user1"])
+	}
+	if code, out, _ := ctl("", "get", "user1"); code != 0 || !strings.Contains(out, `"theme": "dark"`) {
+		t.Fatalf("get: %d %s", code, out)
+	}
+	if code, _, _ := ctl("", "delete", "user1", "theme"); code != 0 || store.prefs["user1"]["theme"] != nil {
+		t.Fatalf("expected theme deleted, got %d %+v", code, store.prefs["user1"])
+	}
+	if code, _, errOut := ctl("", "get", "user1", "theme"); code != 1 || !strings.Contains(errOut, "404") {
+		t.Fatalf("expected a 404 reported, got %d %s", code, errOut)
+	}
+
+	code, doc, _ := ctl("", "export", "user1")
+	if code != 0 || !strings.Contains(doc, "fontSize") {
+		t.Fatalf("export: %d %s", code, doc)
+	}
+	if code, _, errOut := ctl(doc, "import", "user2", "-mode", "replace"); code != 0 || store.prefs["user2"]["fontSize"] == nil {
+		t.Fatalf("expected the export imported for user2, got %d %s %+v", code, errOut, store.prefs["user2"])
+	}
+
+	if code, out, _ := ctl("", "users"); code != 0 || out != "user1\nuser2\n" {
+		t.Fatalf("expected every page of users, got %d %q", code, out)
+	}
+
+	if code, _, _ := ctl("", "get"); code != 2 {
+		t.Fatalf("expected a usage error, got %d", code)
+	}
+	var stderr bytes.Buffer
+	if code := runCtl([]string{"-url", srv.URL, "-token", "wrong-key-wrong-key", "get", "user1"}, nil, &bytes.Buffer{}, &stderr); code != 1 || !strings.Contains(stderr.String(), "401") {
+		t.Fatalf("expected a wrong key rejected, got %d %s", code, stderr.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCtlClient_Tail(t *testing.T) {
+	bus := NewChangeBus()
+	store := NewChangePublisher(newMockStore(), nil, testLogger(), bus)
+	prefs := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+	hs := Handlers{Prefs: prefs, Corrections: NewCorrectionsHandler(prefs, newMockCorrectionStore()), Stream: NewStreamHandler(prefs, bus)}
+	keys, _ := parseAdminKeys([]string{"support:0123456789abcdef0123"})
+	srv := httptest.NewServer(NewRouter(hs, Config{AuthMode: AuthModeJWT, JWTSecret: testSecret, AdminAPIKeys: keys}, testLogger()))
+	defer srv.Close()
+
+	c := &ctlClient{baseURL: srv.URL, auth: ctlAuthorization("0123456789abcdef0123"), client: http.DefaultClient}
+	ctx, cancel := context.WithCancel(context.Background())
+	var out syncBuffer
+	done := make(chan error)
+	go func() { done <- c.tail(ctx, "/api/v1/admin/users/user1/preferences/stream", &out) }()
+
+	// Write until the stream is subscribed and an event printed; each
+	// write changes the value, since unchanged values publish nothing.
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; !strings.Contains(out.String(), `"fontSize"`); i++ {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("no event printed, got %q (%v)", out.String(), <-done)
+		}
+		store.Update(context.Background(), "user1", map[string]any{"fontSize": i}, nil, Precondition{})
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("expected an interrupted tail to end cleanly, got %v", err)
+	}
+}
+
+func TestCtlAuthorization(t *testing.T) {
+	if got := ctlAuthorization("a.b.c"); got != "Bearer a.b.c" {
+		t.Fatalf("expected a JWT sent as a bearer token, got %q", got)
+	}
+	if got := ctlAuthorization("0123456789abcdef"); got != "ApiKey 0123456789abcdef" {
+		t.Fatalf("expected an API key, got %q", got)
+	}
+}
@@ -0,0 +1,671 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: userprefs/v1/prefs.proto
+
+// The preferences CRUD API over gRPC. It mirrors the REST routes under
+// /api/v1/users/{userId}/preferences: the same auth, validation and error
+// cases apply, mapped to gRPC status codes.
+
+package userprefsv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Preferences struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	UserId      string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Preferences *structpb.Struct       `protobuf:"bytes,2,opt,name=preferences,proto3" json:"preferences,omitempty"`
+	// version is the map's version, for if_version on later writes.
+	Version       int64 `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Preferences) Reset() {
+	*x = Preferences{}
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Preferences) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Preferences) ProtoMessage() {}
+
+func (x *Preferences) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Preferences.ProtoReflect.Descriptor instead.
+func (*Preferences) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_prefs_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Preferences) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Preferences) GetPreferences() *structpb.Struct {
+	if x != nil {
+		return x.Preferences
+	}
+	return nil
+}
+
+func (x *Preferences) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+type Preference struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Value         *structpb.Value        `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Preference) Reset() {
+	*x = Preference{}
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Preference) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Preference) ProtoMessage() {}
+
+func (x *Preference) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Preference.ProtoReflect.Descriptor instead.
+func (*Preference) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_prefs_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Preference) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Preference) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Preference) GetValue() *structpb.Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type GetPreferencesRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	UserId string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	// keys limits the response to these keys; empty returns all.
+	Keys          []string `protobuf:"bytes,2,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPreferencesRequest) Reset() {
+	*x = GetPreferencesRequest{}
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPreferencesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPreferencesRequest) ProtoMessage() {}
+
+func (x *GetPreferencesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPreferencesRequest.ProtoReflect.Descriptor instead.
+func (*GetPreferencesRequest) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_prefs_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetPreferencesRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *GetPreferencesRequest) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type ReplacePreferencesRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	UserId      string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Preferences *structpb.Struct       `protobuf:"bytes,2,opt,name=preferences,proto3" json:"preferences,omitempty"`
+	// if_version, when set, makes the write fail with FAILED_PRECONDITION
+	// unless the stored map is at this version.
+	IfVersion     *int64 `protobuf:"varint,3,opt,name=if_version,json=ifVersion,proto3,oneof" json:"if_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplacePreferencesRequest) Reset() {
+	*x = ReplacePreferencesRequest{}
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplacePreferencesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplacePreferencesRequest) ProtoMessage() {}
+
+func (x *ReplacePreferencesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplacePreferencesRequest.ProtoReflect.Descriptor instead.
+func (*ReplacePreferencesRequest) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_prefs_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ReplacePreferencesRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *ReplacePreferencesRequest) GetPreferences() *structpb.Struct {
+	if x != nil {
+		return x.Preferences
+	}
+	return nil
+}
+
+func (x *ReplacePreferencesRequest) GetIfVersion() int64 {
+	if x != nil && x.IfVersion != nil {
+		return *x.IfVersion
+	}
+	return 0
+}
+
+type UpdatePreferencesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Preferences   *structpb.Struct       `protobuf:"bytes,2,opt,name=preferences,proto3" json:"preferences,omitempty"`
+	Remove        []string               `protobuf:"bytes,3,rep,name=remove,proto3" json:"remove,omitempty"`
+	IfVersion     *int64                 `protobuf:"varint,4,opt,name=if_version,json=ifVersion,proto3,oneof" json:"if_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdatePreferencesRequest) Reset() {
+	*x = UpdatePreferencesRequest{}
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdatePreferencesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdatePreferencesRequest) ProtoMessage() {}
+
+func (x *UpdatePreferencesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdatePreferencesRequest.ProtoReflect.Descriptor instead.
+func (*UpdatePreferencesRequest) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_prefs_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UpdatePreferencesRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *UpdatePreferencesRequest) GetPreferences() *structpb.Struct {
+	if x != nil {
+		return x.Preferences
+	}
+	return nil
+}
+
+func (x *UpdatePreferencesRequest) GetRemove() []string {
+	if x != nil {
+		return x.Remove
+	}
+	return nil
+}
+
+func (x *UpdatePreferencesRequest) GetIfVersion() int64 {
+	if x != nil && x.IfVersion != nil {
+		return *x.IfVersion
+	}
+	return 0
+}
+
+type DeletePreferencesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeletePreferencesRequest) Reset() {
+	*x = DeletePreferencesRequest{}
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeletePreferencesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeletePreferencesRequest) ProtoMessage() {}
+
+func (x *DeletePreferencesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeletePreferencesRequest.ProtoReflect.Descriptor instead.
+func (*DeletePreferencesRequest) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_prefs_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeletePreferencesRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type GetPreferenceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPreferenceRequest) Reset() {
+	*x = GetPreferenceRequest{}
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPreferenceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPreferenceRequest) ProtoMessage() {}
+
+func (x *GetPreferenceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPreferenceRequest.ProtoReflect.Descriptor instead.
+func (*GetPreferenceRequest) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_prefs_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetPreferenceRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *GetPreferenceRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+type SetPreferenceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Value         *structpb.Value        `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetPreferenceRequest) Reset() {
+	*x = SetPreferenceRequest{}
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetPreferenceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetPreferenceRequest) ProtoMessage() {}
+
+func (x *SetPreferenceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetPreferenceRequest.ProtoReflect.Descriptor instead.
+func (*SetPreferenceRequest) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_prefs_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SetPreferenceRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *SetPreferenceRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *SetPreferenceRequest) GetValue() *structpb.Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type DeletePreferenceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeletePreferenceRequest) Reset() {
+	*x = DeletePreferenceRequest{}
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeletePreferenceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeletePreferenceRequest) ProtoMessage() {}
+
+func (x *DeletePreferenceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_prefs_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeletePreferenceRequest.ProtoReflect.Descriptor instead.
+func (*DeletePreferenceRequest) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_prefs_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DeletePreferenceRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *DeletePreferenceRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+var File_userprefs_v1_prefs_proto protoreflect.FileDescriptor
+
+const file_userprefs_v1_prefs_proto_rawDesc = "" +
+	"\n" +
+	"\x18userprefs/v1/prefs.proto\x12\fuserprefs.v1\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1cgoogle/protobuf/struct.proto\"{\n" +
+	"\vPreferences\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x129\n" +
+	"\vpreferences\x18\x02 \x01(\v2\x17.google.protobuf.StructR\vpreferences\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\x03R\aversion\"e\n" +
+	"\n" +
+	"Preference\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\x12,\n" +
+	"\x05value\x18\x03 \x01(\v2\x16.google.protobuf.ValueR\x05value\"D\n" +
+	"\x15GetPreferencesRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x12\n" +
+	"\x04keys\x18\x02 \x03(\tR\x04keys\"\xa2\x01\n" +
+	"\x19ReplacePreferencesRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x129\n" +
+	"\vpreferences\x18\x02 \x01(\v2\x17.google.protobuf.StructR\vpreferences\x12\"\n" +
+	"\n" +
+	"if_version\x18\x03 \x01(\x03H\x00R\tifVersion\x88\x01\x01B\r\n" +
+	"\v_if_version\"\xb9\x01\n" +
+	"\x18UpdatePreferencesRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x129\n" +
+	"\vpreferences\x18\x02 \x01(\v2\x17.google.protobuf.StructR\vpreferences\x12\x16\n" +
+	"\x06remove\x18\x03 \x03(\tR\x06remove\x12\"\n" +
+	"\n" +
+	"if_version\x18\x04 \x01(\x03H\x00R\tifVersion\x88\x01\x01B\r\n" +
+	"\v_if_version\"3\n" +
+	"\x18DeletePreferencesRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\"A\n" +
+	"\x14GetPreferenceRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\"o\n" +
+	"\x14SetPreferenceRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\x12,\n" +
+	"\x05value\x18\x03 \x01(\v2\x16.google.protobuf.ValueR\x05value\"D\n" +
+	"\x17DeletePreferenceRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key2\xde\x04\n" +
+	"\x12PreferencesService\x12P\n" +
+	"\x0eGetPreferences\x12#.userprefs.v1.GetPreferencesRequest\x1a\x19.userprefs.v1.Preferences\x12X\n" +
+	"\x12ReplacePreferences\x12'.userprefs.v1.ReplacePreferencesRequest\x1a\x19.userprefs.v1.Preferences\x12V\n" +
+	"\x11UpdatePreferences\x12&.userprefs.v1.UpdatePreferencesRequest\x1a\x19.userprefs.v1.Preferences\x12S\n" +
+	"\x11DeletePreferences\x12&.userprefs.v1.DeletePreferencesRequest\x1a\x16.google.protobuf.Empty\x12M\n" +
+	"\rGetPreference\x12\".userprefs.v1.GetPreferenceRequest\x1a\x18.userprefs.v1.Preference\x12M\n" +
+	"\rSetPreference\x12\".userprefs.v1.SetPreferenceRequest\x1a\x18.userprefs.v1.Preference\x12Q\n" +
+	"\x10DeletePreference\x12%.userprefs.v1.DeletePreferenceRequest\x1a\x16.google.protobuf.EmptyB@Z>github.com/wozniakbe/user-prefs/proto/userprefs/v1;userprefsv1b\x06proto3"
+
+var (
+	file_userprefs_v1_prefs_proto_rawDescOnce sync.Once
+	file_userprefs_v1_prefs_proto_rawDescData []byte
+)
+
+func file_userprefs_v1_prefs_proto_rawDescGZIP() []byte {
+	file_userprefs_v1_prefs_proto_rawDescOnce.Do(func() {
+		file_userprefs_v1_prefs_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_userprefs_v1_prefs_proto_rawDesc), len(file_userprefs_v1_prefs_proto_rawDesc)))
+	})
+	return file_userprefs_v1_prefs_proto_rawDescData
+}
+
+var file_userprefs_v1_prefs_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_userprefs_v1_prefs_proto_goTypes = []any{
+	(*Preferences)(nil),               // 0: userprefs.v1.Preferences
+	(*Preference)(nil),                // 1: userprefs.v1.Preference
+	(*GetPreferencesRequest)(nil),     // 2: userprefs.v1.GetPreferencesRequest
+	(*ReplacePreferencesRequest)(nil), // 3: userprefs.v1.ReplacePreferencesRequest
+	(*UpdatePreferencesRequest)(nil),  // 4: userprefs.v1.UpdatePreferencesRequest
+	(*DeletePreferencesRequest)(nil),  // 5: userprefs.v1.DeletePreferencesRequest
+	(*GetPreferenceRequest)(nil),      // 6: userprefs.v1.GetPreferenceRequest
+	(*SetPreferenceRequest)(nil),      // 7: userprefs.v1.SetPreferenceRequest
+	(*DeletePreferenceRequest)(nil),   // 8: userprefs.v1.DeletePreferenceRequest
+	(*structpb.Struct)(nil),           // 9: google.protobuf.Struct
+	(*structpb.Value)(nil),            // 10: google.protobuf.Value
+	(*emptypb.Empty)(nil),             // 11: google.protobuf.Empty
+}
+var file_userprefs_v1_prefs_proto_depIdxs = []int32{
+	9,  // 0: userprefs.v1.Preferences.preferences:type_name -> google.protobuf.Struct
+	10, // 1: userprefs.v1.Preference.value:type_name -> google.protobuf.Value
+	9,  // 2: userprefs.v1.ReplacePreferencesRequest.preferences:type_name -> google.protobuf.Struct
+	9,  // 3: userprefs.v1.UpdatePreferencesRequest.preferences:type_name -> google.protobuf.Struct
+	10, // 4: userprefs.v1.SetPreferenceRequest.value:type_name -> google.protobuf.Value
+	2,  // 5: userprefs.v1.PreferencesService.GetPreferences:input_type -> userprefs.v1.GetPreferencesRequest
+	3,  // 6: userprefs.v1.PreferencesService.ReplacePreferences:input_type -> userprefs.v1.ReplacePreferencesRequest
+	4,  // 7: userprefs.v1.PreferencesService.UpdatePreferences:input_type -> userprefs.v1.UpdatePreferencesRequest
+	5,  // 8: userprefs.v1.PreferencesService.DeletePreferences:input_type -> userprefs.v1.DeletePreferencesRequest
+	6,  // 9: userprefs.v1.PreferencesService.GetPreference:input_type -> userprefs.v1.GetPreferenceRequest
+	7,  // 10: userprefs.v1.PreferencesService.SetPreference:input_type -> userprefs.v1.SetPreferenceRequest
+	8,  // 11: userprefs.v1.PreferencesService.DeletePreference:input_type -> userprefs.v1.DeletePreferenceRequest
+	0,  // 12: userprefs.v1.PreferencesService.GetPreferences:output_type -> userprefs.v1.Preferences
+	0,  // 13: userprefs.v1.PreferencesService.ReplacePreferences:output_type -> userprefs.v1.Preferences
+	0,  // 14: userprefs.v1.PreferencesService.UpdatePreferences:output_type -> userprefs.v1.Preferences
+	11, // 15: userprefs.v1.PreferencesService.DeletePreferences:output_type -> google.protobuf.Empty
+	1,  // 16: userprefs.v1.PreferencesService.GetPreference:output_type -> userprefs.v1.Preference
+	1,  // 17: userprefs.v1.PreferencesService.SetPreference:output_type -> userprefs.v1.Preference
+	11, // 18: userprefs.v1.PreferencesService.DeletePreference:output_type -> google.protobuf.Empty
+	12, // [12:19] is the sub-list for method output_type
+	5,  // [5:12] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_userprefs_v1_prefs_proto_init() }
+func file_userprefs_v1_prefs_proto_init() {
+	if File_userprefs_v1_prefs_proto != nil {
+		return
+	}
+	file_userprefs_v1_prefs_proto_msgTypes[3].OneofWrappers = []any{}
+	file_userprefs_v1_prefs_proto_msgTypes[4].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_userprefs_v1_prefs_proto_rawDesc), len(file_userprefs_v1_prefs_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_userprefs_v1_prefs_proto_goTypes,
+		DependencyIndexes: file_userprefs_v1_prefs_proto_depIdxs,
+		MessageInfos:      file_userprefs_v1_prefs_proto_msgTypes,
+	}.Build()
+	File_userprefs_v1_prefs_proto = out.File
+	file_userprefs_v1_prefs_proto_goTypes = nil
+	file_userprefs_v1_prefs_proto_depIdxs = nil
+}
@@ -0,0 +1,368 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: userprefs/v1/prefs.proto
+
+// The preferences CRUD API over gRPC. It mirrors the REST routes under
+// /api/v1/users/{userId}/preferences: the same auth, validation and error
+// cases apply, mapped to gRPC status codes.
+
+package userprefsv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PreferencesService_GetPreferences_FullMethodName     = "/userprefs.v1.PreferencesService/GetPreferences"
+	PreferencesService_ReplacePreferences_FullMethodName = "/userprefs.v1.PreferencesService/ReplacePreferences"
+	PreferencesService_UpdatePreferences_FullMethodName  = "/userprefs.v1.PreferencesService/UpdatePreferences"
+	PreferencesService_DeletePreferences_FullMethodName  = "/userprefs.v1.PreferencesService/DeletePreferences"
+	PreferencesService_GetPreference_FullMethodName      = "/userprefs.v1.PreferencesService/GetPreference"
+	PreferencesService_SetPreference_FullMethodName      = "/userprefs.v1.PreferencesService/SetPreference"
+	PreferencesService_DeletePreference_FullMethodName   = "/userprefs.v1.PreferencesService/DeletePreference"
+)
+
+// PreferencesServiceClient is the client API for PreferencesService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PreferencesServiceClient interface {
+	// GetPreferences returns a user's preferences, optionally only some keys.
+	GetPreferences(ctx context.Context, in *GetPreferencesRequest, opts ...grpc.CallOption) (*Preferences, error)
+	// ReplacePreferences replaces the whole map.
+	ReplacePreferences(ctx context.Context, in *ReplacePreferencesRequest, opts ...grpc.CallOption) (*Preferences, error)
+	// UpdatePreferences merges keys into the map and removes others.
+	UpdatePreferences(ctx context.Context, in *UpdatePreferencesRequest, opts ...grpc.CallOption) (*Preferences, error)
+	// DeletePreferences deletes all of a user's preferences.
+	DeletePreferences(ctx context.Context, in *DeletePreferencesRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// GetPreference returns a single key.
+	GetPreference(ctx context.Context, in *GetPreferenceRequest, opts ...grpc.CallOption) (*Preference, error)
+	// SetPreference sets a single key.
+	SetPreference(ctx context.Context, in *SetPreferenceRequest, opts ...grpc.CallOption) (*Preference, error)
+	// DeletePreference deletes a single key.
+	DeletePreference(ctx context.Context, in *DeletePreferenceRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+type preferencesServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPreferencesServiceClient(cc grpc.ClientConnInterface) PreferencesServiceClient {
+	return &preferencesServiceClient{cc}
+}
+
+func (c *preferencesServiceClient) GetPreferences(ctx context.Context, in *GetPreferencesRequest, opts ...grpc.CallOption) (*Preferences, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Preferences)
+	err := c.cc.Invoke(ctx, PreferencesService_GetPreferences_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *preferencesServiceClient) ReplacePreferences(ctx context.Context, in *ReplacePreferencesRequest, opts ...grpc.CallOption) (*Preferences, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Preferences)
+	err := c.cc.Invoke(ctx, PreferencesService_ReplacePreferences_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *preferencesServiceClient) UpdatePreferences(ctx context.Context, in *UpdatePreferencesRequest, opts ...grpc.CallOption) (*Preferences, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Preferences)
+	err := c.cc.Invoke(ctx, PreferencesService_UpdatePreferences_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *preferencesServiceClient) DeletePreferences(ctx context.Context, in *DeletePreferencesRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, PreferencesService_DeletePreferences_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *preferencesServiceClient) GetPreference(ctx context.Context, in *GetPreferenceRequest, opts ...grpc.CallOption) (*Preference, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Preference)
+	err := c.cc.Invoke(ctx, PreferencesService_GetPreference_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *preferencesServiceClient) SetPreference(ctx context.Context, in *SetPreferenceRequest, opts ...grpc.CallOption) (*Preference, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Preference)
+	err := c.cc.Invoke(ctx, PreferencesService_SetPreference_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *preferencesServiceClient) DeletePreference(ctx context.Context, in *DeletePreferenceRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, PreferencesService_DeletePreference_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PreferencesServiceServer is the server API for PreferencesService service.
+// All implementations must embed UnimplementedPreferencesServiceServer
+// for forward compatibility.
+type PreferencesServiceServer interface {
+	// GetPreferences returns a user's preferences, optionally only some keys.
+	GetPreferences(context.Context, *GetPreferencesRequest) (*Preferences, error)
+	// ReplacePreferences replaces the whole map.
+	ReplacePreferences(context.Context, *ReplacePreferencesRequest) (*Preferences, error)
+	// UpdatePreferences merges keys into the map and removes others.
+	UpdatePreferences(context.Context, *UpdatePreferencesRequest) (*Preferences, error)
+	// DeletePreferences deletes all of a user's preferences.
+	DeletePreferences(context.Context, *DeletePreferencesRequest) (*emptypb.Empty, error)
+	// GetPreference returns a single key.
+	GetPreference(context.Context, *GetPreferenceRequest) (*Preference, error)
+	// SetPreference sets a single key.
+	SetPreference(context.Context, *SetPreferenceRequest) (*Preference, error)
+	// DeletePreference deletes a single key.
+	DeletePreference(context.Context, *DeletePreferenceRequest) (*emptypb.Empty, error)
+	mustEmbedUnimplementedPreferencesServiceServer()
+}
+
+// UnimplementedPreferencesServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPreferencesServiceServer struct{}
+
+func (UnimplementedPreferencesServiceServer) GetPreferences(context.Context, *GetPreferencesRequest) (*Preferences, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetPreferences not implemented")
+}
+func (UnimplementedPreferencesServiceServer) ReplacePreferences(context.Context, *ReplacePreferencesRequest) (*Preferences, error) {
+	return nil, status.Error(codes.Unimplemented, "method ReplacePreferences not implemented")
+}
+func (UnimplementedPreferencesServiceServer) UpdatePreferences(context.Context, *UpdatePreferencesRequest) (*Preferences, error) {
+	return nil, status.Error(codes.Unimplemented, "method UpdatePreferences not implemented")
+}
+func (UnimplementedPreferencesServiceServer) DeletePreferences(context.Context, *DeletePreferencesRequest) (*emptypb.Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method DeletePreferences not implemented")
+}
+func (UnimplementedPreferencesServiceServer) GetPreference(context.Context, *GetPreferenceRequest) (*Preference, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetPreference not implemented")
+}
+func (UnimplementedPreferencesServiceServer) SetPreference(context.Context, *SetPreferenceRequest) (*Preference, error) {
+	return nil, status.Error(codes.Unimplemented, "method SetPreference not implemented")
+}
+func (UnimplementedPreferencesServiceServer) DeletePreference(context.Context, *DeletePreferenceRequest) (*emptypb.Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method DeletePreference not implemented")
+}
+func (UnimplementedPreferencesServiceServer) mustEmbedUnimplementedPreferencesServiceServer() {}
+func (UnimplementedPreferencesServiceServer) testEmbeddedByValue()                            {}
+
+// UnsafePreferencesServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PreferencesServiceServer will
+// result in compilation errors.
+type UnsafePreferencesServiceServer interface {
+	mustEmbedUnimplementedPreferencesServiceServer()
+}
+
+func RegisterPreferencesServiceServer(s grpc.ServiceRegistrar, srv PreferencesServiceServer) {
+	// If the following call panics, it indicates UnimplementedPreferencesServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PreferencesService_ServiceDesc, srv)
+}
+
+func _PreferencesService_GetPreferences_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPreferencesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PreferencesServiceServer).GetPreferences(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PreferencesService_GetPreferences_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PreferencesServiceServer).GetPreferences(ctx, req.(*GetPreferencesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PreferencesService_ReplacePreferences_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplacePreferencesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PreferencesServiceServer).ReplacePreferences(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PreferencesService_ReplacePreferences_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PreferencesServiceServer).ReplacePreferences(ctx, req.(*ReplacePreferencesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PreferencesService_UpdatePreferences_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdatePreferencesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PreferencesServiceServer).UpdatePreferences(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PreferencesService_UpdatePreferences_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PreferencesServiceServer).UpdatePreferences(ctx, req.(*UpdatePreferencesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PreferencesService_DeletePreferences_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeletePreferencesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PreferencesServiceServer).DeletePreferences(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PreferencesService_DeletePreferences_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PreferencesServiceServer).DeletePreferences(ctx, req.(*DeletePreferencesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PreferencesService_GetPreference_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPreferenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PreferencesServiceServer).GetPreference(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PreferencesService_GetPreference_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PreferencesServiceServer).GetPreference(ctx, req.(*GetPreferenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PreferencesService_SetPreference_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPreferenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PreferencesServiceServer).SetPreference(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PreferencesService_SetPreference_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PreferencesServiceServer).SetPreference(ctx, req.(*SetPreferenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PreferencesService_DeletePreference_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeletePreferenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PreferencesServiceServer).DeletePreference(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PreferencesService_DeletePreference_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PreferencesServiceServer).DeletePreference(ctx, req.(*DeletePreferenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PreferencesService_ServiceDesc is the grpc.ServiceDesc for PreferencesService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PreferencesService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "userprefs.v1.PreferencesService",
+	HandlerType: (*PreferencesServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetPreferences",
+			Handler:    _PreferencesService_GetPreferences_Handler,
+		},
+		{
+			MethodName: "ReplacePreferences",
+			Handler:    _PreferencesService_ReplacePreferences_Handler,
+		},
+		{
+			MethodName: "UpdatePreferences",
+			Handler:    _PreferencesService_UpdatePreferences_Handler,
+		},
+		{
+			MethodName: "DeletePreferences",
+			Handler:    _PreferencesService_DeletePreferences_Handler,
+		},
+		{
+			MethodName: "GetPreference",
+			Handler:    _PreferencesService_GetPreference_Handler,
+		},
+		{
+			MethodName: "SetPreference",
+			Handler:    _PreferencesService_SetPreference_Handler,
+		},
+		{
+			MethodName: "DeletePreference",
+			Handler:    _PreferencesService_DeletePreference_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "userprefs/v1/prefs.proto",
+}
@@ -0,0 +1,588 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: userprefs/v1/responses.proto
+
+// Protobuf forms of the REST responses, served to internal callers that
+// send Accept: application/x-protobuf. Fields mirror the JSON bodies of the
+// same names; absent optional JSON members are unset fields.
+
+package userprefsv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// PreferencesResponse is a user's preference map, as returned by
+// applyTemplate and, per user, by batchGet.
+type PreferencesResponse struct {
+	state         protoimpl.MessageState   `protogen:"open.v1"`
+	UserId        string                   `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Preferences   *structpb.Struct         `protobuf:"bytes,2,opt,name=preferences,proto3" json:"preferences,omitempty"`
+	Metadata      map[string]*PrefMetadata `protobuf:"bytes,3,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	CreatedAt     *timestamppb.Timestamp   `protobuf:"bytes,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt     *timestamppb.Timestamp   `protobuf:"bytes,5,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	NextCursor    string                   `protobuf:"bytes,6,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PreferencesResponse) Reset() {
+	*x = PreferencesResponse{}
+	mi := &file_userprefs_v1_responses_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PreferencesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PreferencesResponse) ProtoMessage() {}
+
+func (x *PreferencesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_responses_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PreferencesResponse.ProtoReflect.Descriptor instead.
+func (*PreferencesResponse) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_responses_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PreferencesResponse) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *PreferencesResponse) GetPreferences() *structpb.Struct {
+	if x != nil {
+		return x.Preferences
+	}
+	return nil
+}
+
+func (x *PreferencesResponse) GetMetadata() map[string]*PrefMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *PreferencesResponse) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *PreferencesResponse) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *PreferencesResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+type PrefMetadata struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UpdatedAt     *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	LastUpdatedBy string                 `protobuf:"bytes,2,opt,name=last_updated_by,json=lastUpdatedBy,proto3" json:"last_updated_by,omitempty"`
+	Source        string                 `protobuf:"bytes,3,opt,name=source,proto3" json:"source,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PrefMetadata) Reset() {
+	*x = PrefMetadata{}
+	mi := &file_userprefs_v1_responses_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PrefMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PrefMetadata) ProtoMessage() {}
+
+func (x *PrefMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_responses_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PrefMetadata.ProtoReflect.Descriptor instead.
+func (*PrefMetadata) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_responses_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PrefMetadata) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *PrefMetadata) GetLastUpdatedBy() string {
+	if x != nil {
+		return x.LastUpdatedBy
+	}
+	return ""
+}
+
+func (x *PrefMetadata) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+// SinglePrefResponse is a single key and its value.
+type SinglePrefResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         *structpb.Value        `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SinglePrefResponse) Reset() {
+	*x = SinglePrefResponse{}
+	mi := &file_userprefs_v1_responses_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SinglePrefResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SinglePrefResponse) ProtoMessage() {}
+
+func (x *SinglePrefResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_responses_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SinglePrefResponse.ProtoReflect.Descriptor instead.
+func (*SinglePrefResponse) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_responses_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SinglePrefResponse) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *SinglePrefResponse) GetValue() *structpb.Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+// BatchGetResponse lists preferences in request order.
+type BatchGetResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Users         []*PreferencesResponse `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchGetResponse) Reset() {
+	*x = BatchGetResponse{}
+	mi := &file_userprefs_v1_responses_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchGetResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchGetResponse) ProtoMessage() {}
+
+func (x *BatchGetResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_responses_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchGetResponse.ProtoReflect.Descriptor instead.
+func (*BatchGetResponse) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_responses_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BatchGetResponse) GetUsers() []*PreferencesResponse {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+type Violation struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Code          string                 `protobuf:"bytes,2,opt,name=code,proto3" json:"code,omitempty"`
+	Reason        string                 `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Violation) Reset() {
+	*x = Violation{}
+	mi := &file_userprefs_v1_responses_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Violation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Violation) ProtoMessage() {}
+
+func (x *Violation) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_responses_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Violation.ProtoReflect.Descriptor instead.
+func (*Violation) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_responses_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Violation) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Violation) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *Violation) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type BatchResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	ErrorCode     string                 `protobuf:"bytes,5,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	Violations    []*Violation           `protobuf:"bytes,6,rep,name=violations,proto3" json:"violations,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchResult) Reset() {
+	*x = BatchResult{}
+	mi := &file_userprefs_v1_responses_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchResult) ProtoMessage() {}
+
+func (x *BatchResult) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_responses_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchResult.ProtoReflect.Descriptor instead.
+func (*BatchResult) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_responses_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BatchResult) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *BatchResult) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *BatchResult) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *BatchResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *BatchResult) GetErrorCode() string {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ""
+}
+
+func (x *BatchResult) GetViolations() []*Violation {
+	if x != nil {
+		return x.Violations
+	}
+	return nil
+}
+
+// BatchResponse is the result of batchSet and batchDelete.
+type BatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*BatchResult         `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	Counts        map[string]int32       `protobuf:"bytes,2,rep,name=counts,proto3" json:"counts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchResponse) Reset() {
+	*x = BatchResponse{}
+	mi := &file_userprefs_v1_responses_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchResponse) ProtoMessage() {}
+
+func (x *BatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_userprefs_v1_responses_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchResponse.ProtoReflect.Descriptor instead.
+func (*BatchResponse) Descriptor() ([]byte, []int) {
+	return file_userprefs_v1_responses_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *BatchResponse) GetResults() []*BatchResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *BatchResponse) GetCounts() map[string]int32 {
+	if x != nil {
+		return x.Counts
+	}
+	return nil
+}
+
+var File_userprefs_v1_responses_proto protoreflect.FileDescriptor
+
+const file_userprefs_v1_responses_proto_rawDesc = "" +
+	"\n" +
+	"\x1cuserprefs/v1/responses.proto\x12\fuserprefs.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xa6\x03\n" +
+	"\x13PreferencesResponse\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x129\n" +
+	"\vpreferences\x18\x02 \x01(\v2\x17.google.protobuf.StructR\vpreferences\x12K\n" +
+	"\bmetadata\x18\x03 \x03(\v2/.userprefs.v1.PreferencesResponse.MetadataEntryR\bmetadata\x129\n" +
+	"\n" +
+	"created_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12\x1f\n" +
+	"\vnext_cursor\x18\x06 \x01(\tR\n" +
+	"nextCursor\x1aW\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x120\n" +
+	"\x05value\x18\x02 \x01(\v2\x1a.userprefs.v1.PrefMetadataR\x05value:\x028\x01\"\x89\x01\n" +
+	"\fPrefMetadata\x129\n" +
+	"\n" +
+	"updated_at\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12&\n" +
+	"\x0flast_updated_by\x18\x02 \x01(\tR\rlastUpdatedBy\x12\x16\n" +
+	"\x06source\x18\x03 \x01(\tR\x06source\"T\n" +
+	"\x12SinglePrefResponse\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12,\n" +
+	"\x05value\x18\x02 \x01(\v2\x16.google.protobuf.ValueR\x05value\"K\n" +
+	"\x10BatchGetResponse\x127\n" +
+	"\x05users\x18\x01 \x03(\v2!.userprefs.v1.PreferencesResponseR\x05users\"I\n" +
+	"\tViolation\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\tR\x04code\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\"\xbe\x01\n" +
+	"\vBatchResult\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\x05 \x01(\tR\terrorCode\x127\n" +
+	"\n" +
+	"violations\x18\x06 \x03(\v2\x17.userprefs.v1.ViolationR\n" +
+	"violations\"\xc0\x01\n" +
+	"\rBatchResponse\x123\n" +
+	"\aresults\x18\x01 \x03(\v2\x19.userprefs.v1.BatchResultR\aresults\x12?\n" +
+	"\x06counts\x18\x02 \x03(\v2'.userprefs.v1.BatchResponse.CountsEntryR\x06counts\x1a9\n" +
+	"\vCountsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x05R\x05value:\x028\x01B@Z>github.com/wozniakbe/user-prefs/proto/userprefs/v1;userprefsv1b\x06proto3"
+
+var (
+	file_userprefs_v1_responses_proto_rawDescOnce sync.Once
+	file_userprefs_v1_responses_proto_rawDescData []byte
+)
+
+func file_userprefs_v1_responses_proto_rawDescGZIP() []byte {
+	file_userprefs_v1_responses_proto_rawDescOnce.Do(func() {
+		file_userprefs_v1_responses_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_userprefs_v1_responses_proto_rawDesc), len(file_userprefs_v1_responses_proto_rawDesc)))
+	})
+	return file_userprefs_v1_responses_proto_rawDescData
+}
+
+var file_userprefs_v1_responses_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_userprefs_v1_responses_proto_goTypes = []any{
+	(*PreferencesResponse)(nil),   // 0: userprefs.v1.PreferencesResponse
+	(*PrefMetadata)(nil),          // 1: userprefs.v1.PrefMetadata
+	(*SinglePrefResponse)(nil),    // 2: userprefs.v1.SinglePrefResponse
+	(*BatchGetResponse)(nil),      // 3: userprefs.v1.BatchGetResponse
+	(*Violation)(nil),             // 4: userprefs.v1.Violation
+	(*BatchResult)(nil),           // 5: userprefs.v1.BatchResult
+	(*BatchResponse)(nil),         // 6: userprefs.v1.BatchResponse
+	nil,                           // 7: userprefs.v1.PreferencesResponse.MetadataEntry
+	nil,                           // 8: userprefs.v1.BatchResponse.CountsEntry
+	(*structpb.Struct)(nil),       // 9: google.protobuf.Struct
+	(*timestamppb.Timestamp)(nil), // 10: google.protobuf.Timestamp
+	(*structpb.Value)(nil),        // 11: google.protobuf.Value
+}
+var file_userprefs_v1_responses_proto_depIdxs = []int32{
+	9,  // 0: userprefs.v1.PreferencesResponse.preferences:type_name -> google.protobuf.Struct
+	7,  // 1: userprefs.v1.PreferencesResponse.metadata:type_name -> userprefs.v1.PreferencesResponse.MetadataEntry
+	10, // 2: userprefs.v1.PreferencesResponse.created_at:type_name -> google.protobuf.Timestamp
+	10, // 3: userprefs.v1.PreferencesResponse.updated_at:type_name -> google.protobuf.Timestamp
+	10, // 4: userprefs.v1.PrefMetadata.updated_at:type_name -> google.protobuf.Timestamp
+	11, // 5: userprefs.v1.SinglePrefResponse.value:type_name -> google.protobuf.Value
+	0,  // 6: userprefs.v1.BatchGetResponse.users:type_name -> userprefs.v1.PreferencesResponse
+	4,  // 7: userprefs.v1.BatchResult.violations:type_name -> userprefs.v1.Violation
+	5,  // 8: userprefs.v1.BatchResponse.results:type_name -> userprefs.v1.BatchResult
+	8,  // 9: userprefs.v1.BatchResponse.counts:type_name -> userprefs.v1.BatchResponse.CountsEntry
+	1,  // 10: userprefs.v1.PreferencesResponse.MetadataEntry.value:type_name -> userprefs.v1.PrefMetadata
+	11, // [11:11] is the sub-list for method output_type
+	11, // [11:11] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_userprefs_v1_responses_proto_init() }
+func file_userprefs_v1_responses_proto_init() {
+	if File_userprefs_v1_responses_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_userprefs_v1_responses_proto_rawDesc), len(file_userprefs_v1_responses_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_userprefs_v1_responses_proto_goTypes,
+		DependencyIndexes: file_userprefs_v1_responses_proto_depIdxs,
+		MessageInfos:      file_userprefs_v1_responses_proto_msgTypes,
+	}.Build()
+	File_userprefs_v1_responses_proto = out.File
+	file_userprefs_v1_responses_proto_goTypes = nil
+	file_userprefs_v1_responses_proto_depIdxs = nil
+}
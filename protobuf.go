@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	userprefsv1 "github.com/wozniakbe/user-prefs/proto/userprefs/v1"
+)
+
+// protobufContentType is the media type of protobuf responses;
+// application/protobuf is accepted as well.
+const protobufContentType = "application/x-protobuf"
+
+func isProtobuf(mediaType string) bool {
+	return mediaType == protobufContentType || mediaType == "application/protobuf"
+}
+
+// protoResponse is a response body with a protobuf form
+// (proto/userprefs/v1/responses.proto).
+type protoResponse interface {
+	protoMessage() (proto.Message, error)
+}
+
+// writeResponse writes v as protobuf when Accept ranks protobuf above JSON,
+// and as JSON otherwise. The internal endpoints answer with it: their
+// callers are services, for which encoding cost adds up at high request
+// rates. Errors stay JSON, and so do bodies without a protobuf form (a
+// value structpb cannot hold), since Accept is only a preference. The
+// MessagePack middleware already sets Vary: Accept.
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, v protoResponse) {
+	if prefersOverJSON(r.Header.Get("Accept"), isProtobuf) {
+		if m, err := v.protoMessage(); err == nil {
+			if body, err := proto.Marshal(m); err == nil {
+				w.Header().Set("Content-Type", protobufContentType)
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.WriteHeader(status)
+				w.Write(body)
+				return
+			}
+		}
+	}
+	writeJSON(w, status, v)
+}
+
+func (p PreferencesResponse) protoMessage() (proto.Message, error) { return p.toProto() }
+
+func (p PreferencesResponse) toProto() (*userprefsv1.PreferencesResponse, error) {
+	prefs, err := structpb.NewStruct(p.Preferences)
+	if err != nil {
+		return nil, err
+	}
+	out := &userprefsv1.PreferencesResponse{
+		UserId:      p.UserID,
+		Preferences: prefs,
+		CreatedAt:   protoTime(p.CreatedAt),
+		UpdatedAt:   protoTime(p.UpdatedAt),
+		NextCursor:  p.NextCursor,
+	}
+	if len(p.Metadata) > 0 {
+		out.Metadata = make(map[string]*userprefsv1.PrefMetadata, len(p.Metadata))
+		for k, m := range p.Metadata {
+			out.Metadata[k] = &userprefsv1.PrefMetadata{
+				UpdatedAt:     protoTime(m.UpdatedAt),
+				LastUpdatedBy: m.LastUpdatedBy,
+				Source:        m.Source,
+			}
+		}
+	}
+	return out, nil
+}
+
+func (p SinglePrefResponse) protoMessage() (proto.Message, error) {
+	value, err := structpb.NewValue(p.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &userprefsv1.SinglePrefResponse{Key: p.Key, Value: value}, nil
+}
+
+func (b BatchGetResponse) protoMessage() (proto.Message, error) {
+	out := &userprefsv1.BatchGetResponse{Users: make([]*userprefsv1.PreferencesResponse, len(b.Users))}
+	for i, u := range b.Users {
+		var err error
+		if out.Users[i], err = u.toProto(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (b BatchResponse) protoMessage() (proto.Message, error) {
+	out := &userprefsv1.BatchResponse{
+		Results: make([]*userprefsv1.BatchResult, len(b.Results)),
+		Counts:  make(map[string]int32, len(b.Counts)),
+	}
+	for i, res := range b.Results {
+		r := &userprefsv1.BatchResult{
+			UserId:    res.UserID,
+			Key:       res.Key,
+			Status:    res.Status,
+			Error:     res.Error,
+			ErrorCode: res.ErrorCode,
+		}
+		for _, v := range res.Violations {
+			r.Violations = append(r.Violations, &userprefsv1.Violation{Key: v.Key, Code: v.Code, Reason: v.Reason})
+		}
+		out.Results[i] = r
+	}
+	for status, n := range b.Counts {
+		out.Counts[status] = int32(n)
+	}
+	return out, nil
+}
+
+func protoTime(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
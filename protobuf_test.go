@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	userprefsv1 "github.com/wozniakbe/user-prefs/proto/userprefs/v1"
+)
+
+func TestBatchGet_Protobuf(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "fontSize": 14.0}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+
+	post := func(accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/internal/preferences:batchGet", bytes.NewBufferString(body))
+		req = req.WithContext(context.WithValue(req.Context(), claimsKey, Claims{Subject: "notifier", Kind: PrincipalService, Scopes: []string{ScopeRead}}))
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.BatchGet(w, req)
+		return w
+	}
+
+	w := post("application/x-protobuf", `{"userIds":["user1","nobody"]}`)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != protobufContentType {
+		t.Fatalf("expected a protobuf 200, got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	var resp userprefsv1.BatchGetResponse
+	if err := proto.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 2 || resp.Users[0].UserId != "user1" ||
+		resp.Users[0].Preferences.Fields["theme"].GetStringValue() != "dark" ||
+		resp.Users[0].Preferences.Fields["fontSize"].GetNumberValue() != 14 || len(resp.Users[1].Preferences.GetFields()) != 0 {
+		t.Fatalf("unexpected response %v", &resp)
+	}
+
+	if w := post("application/json, application/x-protobuf;q=0.5", `{"userIds":["user1"]}`); w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON when ranked higher, got %q", w.Header().Get("Content-Type"))
+	}
+	if w := post("application/x-protobuf", `{`); w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a JSON error, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestProtoMessages(t *testing.T) {
+	at := time.Date(2026, 3, 2, 10, 15, 0, 0, time.UTC)
+	m, err := PreferencesResponse{
+		UserID:      "user1",
+		Preferences: map[string]any{"tags": []any{"a", "b"}},
+		Metadata:    map[string]PrefMetadata{"tags": {UpdatedAt: &at, LastUpdatedBy: "user1", Source: SourceUser}},
+		UpdatedAt:   &at,
+	}.protoMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefs := m.(*userprefsv1.PreferencesResponse)
+	if prefs.CreatedAt != nil || !prefs.UpdatedAt.AsTime().Equal(at) || prefs.Metadata["tags"].Source != SourceUser ||
+		len(prefs.Preferences.Fields["tags"].GetListValue().GetValues()) != 2 {
+		t.Fatalf("unexpected preferences %v", prefs)
+	}
+
+	m, _ = BatchResponse{
+		Results: []BatchResult{{UserID: "user1", Status: BatchInvalid, Violations: []Violation{{Key: "theme", Code: ErrCodeValueInvalid}}}},
+		Counts:  map[string]int{BatchInvalid: 1},
+	}.protoMessage()
+	batch := m.(*userprefsv1.BatchResponse)
+	if batch.Counts[BatchInvalid] != 1 || batch.Results[0].Violations[0].Code != ErrCodeValueInvalid {
+		t.Fatalf("unexpected batch response %v", batch)
+	}
+
+	if _, err := (SinglePrefResponse{Key: "k", Value: struct{}{}}).protoMessage(); err == nil {
+		t.Fatal("expected a value structpb cannot hold rejected")
+	}
+}
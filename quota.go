@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// Quota limits what one user may store; zero fields are unlimited. Sizes are
+// of the JSON encoding, with a per-key allowance for the metadata map, and
+// approximate DynamoDB's accounting; MaxItemBytes should leave headroom below
+// its 400 KB item limit, more so when sensitive keys are encrypted.
+type Quota struct {
+	MaxKeys       int
+	MaxKeyLength  int
+	MaxValueBytes int
+	MaxItemBytes  int
+}
+
+// metaOverheadBytes approximates a key's metadata entry beyond its name.
+const metaOverheadBytes = 64
+
+func valueSize(v any) int {
+	b, _ := json.Marshal(v)
+	return len(b)
+}
+
+func itemSize(prefs map[string]any) int {
+	n := 0
+	for k, v := range prefs {
+		n += 2*len(k) + valueSize(v) + metaOverheadBytes
+	}
+	return n
+}
+
+// checkQuota writes a 422 listing the violations when a write would exceed
+// the quota, and reports whether the write may proceed.
+func (h *PreferencesHandler) checkQuota(w http.ResponseWriter, r *http.Request, userID string, prefs map[string]any, remove []string, replace bool) bool {
+	v, err := h.quotaViolations(r.Context(), userID, prefs, remove, replace)
+	if err != nil {
+		h.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to save preferences")
+		return false
+	}
+	if len(v) > 0 {
+		writeViolations(w, ErrCodeQuotaExceeded, "preference quota exceeded", v)
+		return false
+	}
+	return true
+}
+
+// quotaViolations checks a write of prefs that also removes remove, or with
+// replace set replaces the whole map with prefs. Only the keys written are
+// checked for length and size. Totals are checked on the resulting map,
+// which for merges is read first; a merge that leaves a user over a lowered
+// limit is allowed as long as it does not grow the map.
+func (h *PreferencesHandler) quotaViolations(ctx context.Context, userID string, prefs map[string]any, remove []string, replace bool) ([]Violation, error) {
+	q := h.opts.Quota
+	var out []Violation
+	for _, k := range slices.Sorted(maps.Keys(prefs)) {
+		if q.MaxKeyLength > 0 && len(k) > q.MaxKeyLength {
+			out = append(out, Violation{Key: k, Code: ErrCodeQuotaExceeded, Reason: fmt.Sprintf("key is longer than %d bytes", q.MaxKeyLength)})
+		}
+		if q.MaxValueBytes > 0 && valueSize(prefs[k]) > q.MaxValueBytes {
+			out = append(out, Violation{Key: k, Code: ErrCodeQuotaExceeded, Reason: fmt.Sprintf("value is larger than %d bytes", q.MaxValueBytes)})
+		}
+	}
+	if q.MaxKeys <= 0 && q.MaxItemBytes <= 0 {
+		return out, nil
+	}
+
+	result := prefs
+	var current map[string]any
+	if !replace {
+		rec, err := h.store.GetAll(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		current = rec.Prefs
+		result = maps.Clone(current)
+		if result == nil {
+			result = make(map[string]any)
+		}
+		maps.Copy(result, prefs)
+		for _, k := range remove {
+			delete(result, k)
+		}
+	}
+	if q.MaxKeys > 0 && len(result) > q.MaxKeys && (replace || len(result) > len(current)) {
+		out = append(out, Violation{Code: ErrCodeQuotaExceeded, Reason: fmt.Sprintf("more than %d keys", q.MaxKeys)})
+	}
+	if q.MaxItemBytes > 0 {
+		if size := itemSize(result); size > q.MaxItemBytes && (replace || size > itemSize(current)) {
+			out = append(out, Violation{Code: ErrCodeQuotaExceeded, Reason: fmt.Sprintf("preferences are larger than %d bytes", q.MaxItemBytes)})
+		}
+	}
+	return out, nil
+}
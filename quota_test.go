@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"a": "1", "b": "2"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Quota: Quota{MaxKeys: 3, MaxKeyLength: 8, MaxValueBytes: 16}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", h.ReplaceAll)
+	mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", h.PatchPrefs)
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences/{key}", h.SetOne)
+	send := func(method, path, contentType, body string) (int, APIError) {
+		req := httptest.NewRequest(method, "/api/v1/users/user1/preferences"+path, bytes.NewBufferString(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		var resp APIError
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	if code, _ := send("PUT", "/c", "", `{"value":"3"}`); code != http.StatusOK {
+		t.Fatalf("expected the third key to fit, got %d", code)
+	}
+	code, resp := send("PATCH", "", "", `{"d":"4","averyverylongkey":"x","e":"`+strings.Repeat("x", 20)+`"}`)
+	if code != http.StatusUnprocessableEntity || len(resp.Violations) != 3 || resp.ErrorCode != ErrCodeQuotaExceeded {
+		t.Fatalf("expected 422 with three violations, got %d %+v", code, resp)
+	}
+	if v := resp.Violations[0]; v.Key != "averyverylongkey" || v.Code != ErrCodeQuotaExceeded || !strings.Contains(v.Reason, "longer than 8") {
+		t.Fatalf("unexpected first violation %+v", v)
+	}
+	if v := resp.Violations[2]; v.Key != "" || !strings.Contains(v.Reason, "more than 3 keys") {
+		t.Fatalf("unexpected total violation %+v", v)
+	}
+	if len(store.prefs["user1"]) != 3 {
+		t.Fatal("expected a rejected write not to be stored")
+	}
+
+	// Swapping one key for another does not grow the map.
+	if code, _ := send("PATCH", "", mergePatchType, `{"a":null,"d":"4"}`); code != http.StatusOK {
+		t.Fatalf("expected a same-size merge to pass, got %d", code)
+	}
+	if code, _ := send("PUT", "", "", `{"a":"1","b":"2","c":"3","d":"4"}`); code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a replace over the key limit, got %d", code)
+	}
+
+	// A lowered limit does not block writes that keep the map the same size.
+	h.opts.Quota = Quota{MaxKeys: 1}
+	if code, _ := send("PUT", "/b", "", `{"value":"22"}`); code != http.StatusOK {
+		t.Fatalf("expected an overwrite to pass under a lowered limit, got %d", code)
+	}
+}
+
+func TestQuota_ItemBytes(t *testing.T) {
+	store := newMockStore()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Quota: Quota{MaxItemBytes: 200}})
+	if v, _ := h.quotaViolations(t.Context(), "user1", map[string]any{"k": strings.Repeat("x", 100)}, nil, false); len(v) != 0 {
+		t.Fatalf("expected a small map to fit, got %+v", v)
+	}
+	if v, _ := h.quotaViolations(t.Context(), "user1", map[string]any{"k": strings.Repeat("x", 200)}, nil, false); len(v) != 1 {
+		t.Fatalf("expected an oversized map to be rejected, got %+v", v)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The main table is keyed by PK alone. Items that are listed rather than
+// fetched by ID are also written to listIndex, a sparse global secondary
+// index shared by every such kind: each sets listIndexPK to the partition
+// it is listed in and listIndexSK to its order there (see indexKeys).
+// queueIndex is a second one, for items that also wait in a work queue
+// while in some state. userIndex (keys only) collects, by user, the items
+// other than the user's own that hold their values, so erasure can find
+// them.
+const (
+	listIndex    = "GSI1"
+	listIndexPK  = "GSI1PK"
+	listIndexSK  = "GSI1SK"
+	queueIndex   = "GSI2"
+	queueIndexPK = "GSI2PK"
+	queueIndexSK = "GSI2SK"
+	userIndex    = "GSI3"
+	userIndexPK  = "GSI3PK"
+	userIndexSK  = "GSI3SK"
+)
+
+// Index partitions: every webhook subscription, each subscription's
+// delivery records, and its pending deliveries by when they are due; each
+// user's correction requests, and every request in a status, oldest first.
+const (
+	webhooksPartition        = "WEBHOOKS"
+	webhookDeliveryPartition = "WEBHOOKDELIVERIES#"
+	webhookPendingPartition  = "WEBHOOKPENDING#"
+	correctionUserPartition  = "CORRECTIONS#"
+	correctionQueuePartition = "CORRECTIONSTATUS#"
+)
+
+// userDataPartition is the userIndex partition of a user's items; each
+// is sorted by its PK.
+const userDataPartition = "USERDATA#"
+
+// indexTimeLayout formats times in index sort keys: fixed width, so they
+// sort in time order as strings.
+const indexTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// indexTime formats t for an index sort key.
+func indexTime(t time.Time) string {
+	return t.UTC().Format(indexTimeLayout)
+}
+
+// indexedPrefixes are the PK prefixes of the item kinds indexKeys covers.
+var indexedPrefixes = []string{webhookPrefix, webhookDeliveryPrefix, correctionPrefix, idempotencyPrefix}
+
+// indexKeys returns the index key attributes item should carry, derived
+// from its other attributes, or nil for kinds that are not indexed.
+// Writers add them to each item they put, and reindex backfills them.
+func indexKeys(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	str := func(name string) string {
+		if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	at := func(name string) string {
+		t, _ := time.Parse(time.RFC3339Nano, str(name))
+		return indexTime(t)
+	}
+	s := func(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+	// byUser adds the userIndex keys of an item holding userID's values.
+	byUser := func(keys map[string]types.AttributeValue, userID string) map[string]types.AttributeValue {
+		if userID != "" {
+			keys[userIndexPK] = s(userDataPartition + userID)
+			keys[userIndexSK] = s(str("PK"))
+		}
+		return keys
+	}
+
+	switch pk := str("PK"); {
+	case strings.HasPrefix(pk, webhookPrefix):
+		return map[string]types.AttributeValue{
+			listIndexPK: s(webhooksPartition),
+			listIndexSK: s(at("createdAt") + "#" + str("id")),
+		}
+	case strings.HasPrefix(pk, webhookDeliveryPrefix):
+		keys := map[string]types.AttributeValue{
+			listIndexPK: s(webhookDeliveryPartition + str("webhookId")),
+			listIndexSK: s(at("createdAt") + "#" + str("id")),
+		}
+		if str("status") == DeliveryPending {
+			keys[queueIndexPK] = s(webhookPendingPartition + str("webhookId"))
+			keys[queueIndexSK] = s(at("nextAttemptAt") + "#" + str("id"))
+		}
+		var event struct {
+			UserID string `json:"userId"`
+		}
+		json.Unmarshal([]byte(str("event")), &event)
+		return byUser(keys, event.UserID)
+	case strings.HasPrefix(pk, correctionPrefix):
+		return map[string]types.AttributeValue{
+			listIndexPK:  s(correctionUserPartition + str("userId")),
+			listIndexSK:  s(at("createdAt") + "#" + str("id")),
+			queueIndexPK: s(correctionQueuePartition + str("status")),
+			queueIndexSK: s(at("createdAt") + "#" + str("id")),
+		}
+	case strings.HasPrefix(pk, idempotencyPrefix):
+		// Results saved before they named their user expire unindexed.
+		return byUser(map[string]types.AttributeValue{}, str("userId"))
+	}
+	return nil
+}
+
+// withIndexKeys adds item's index key attributes to it and returns it.
+func withIndexKeys(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	maps.Copy(item, indexKeys(item))
+	return item
+}
+
+// reindexAPI is the subset of the DynamoDB client reindexing uses.
+type reindexAPI interface {
+	Scan(ctx context.Context, in *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// Reindex sets the index key attributes of every indexed item in table
+// that lacks them or has stale ones, as items written before an index
+// existed do, and returns how many it updated. Only the index attributes
+// are written, so it is safe while the service runs.
+func Reindex(ctx context.Context, client reindexAPI, table string) (int, error) {
+	var filter []string
+	values := map[string]types.AttributeValue{}
+	for i, prefix := range indexedPrefixes {
+		name := fmt.Sprintf(":p%d", i)
+		filter = append(filter, "begins_with(PK, "+name+")")
+		values[name] = &types.AttributeValueMemberS{Value: prefix}
+	}
+	in := &dynamodb.ScanInput{
+		TableName:                 aws.String(table),
+		FilterExpression:          aws.String(strings.Join(filter, " OR ")),
+		ExpressionAttributeValues: values,
+	}
+
+	updated := 0
+	for {
+		page, err := client.Scan(ctx, in)
+		if err != nil {
+			return updated, fmt.Errorf("Scan: %w", err)
+		}
+		for _, item := range page.Items {
+			keys := indexKeys(item)
+			if len(keys) == 0 || indexed(item, keys) {
+				continue
+			}
+			var set []string
+			names := map[string]string{}
+			values := map[string]types.AttributeValue{}
+			for name, v := range keys {
+				set = append(set, "#"+name+" = :"+name)
+				names["#"+name] = name
+				values[":"+name] = v
+			}
+			_, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 aws.String(table),
+				Key:                       map[string]types.AttributeValue{"PK": item["PK"]},
+				UpdateExpression:          aws.String("SET " + strings.Join(set, ", ")),
+				ConditionExpression:       aws.String("attribute_exists(PK)"),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			})
+			var ccf *types.ConditionalCheckFailedException
+			if err != nil && !errors.As(err, &ccf) {
+				return updated, fmt.Errorf("UpdateItem: %w", err)
+			}
+			if err == nil {
+				updated++
+			}
+		}
+		if len(page.LastEvaluatedKey) == 0 {
+			return updated, nil
+		}
+		in.ExclusiveStartKey = page.LastEvaluatedKey
+	}
+}
+
+// indexed reports whether item already carries keys.
+func indexed(item, keys map[string]types.AttributeValue) bool {
+	for name, v := range keys {
+		cur, ok := item[name].(*types.AttributeValueMemberS)
+		if !ok || cur.Value != v.(*types.AttributeValueMemberS).Value {
+			return false
+		}
+	}
+	return true
+}
+
+func runReindex(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	table := fs.String("table", envOrDefault("DYNAMODB_TABLE_NAME", "user-preferences"), "DynamoDB table")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	store, err := NewDynamoStore(ctx, Config{AWSRegion: envOrDefault("AWS_REGION", "us-east-1"), DynamoEndpoint: os.Getenv("DYNAMODB_ENDPOINT")})
+	if err != nil {
+		fmt.Fprintf(stderr, "reindex: %v\n", err)
+		return 1
+	}
+	n, err := Reindex(ctx, store.client, *table)
+	if err != nil {
+		fmt.Fprintf(stderr, "reindex: %v (%d item(s) updated)\n", err, n)
+		return 1
+	}
+	fmt.Fprintf(stdout, "Reindexed %d item(s) in %s.\n", n, *table)
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeReindexData serves scans from fakeDynamoData, ignoring the filter,
+// and applies SET-only updates.
+type fakeReindexData struct {
+	*fakeDynamoData
+	updates int
+}
+
+func (f *fakeReindexData) UpdateItem(_ context.Context, in *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	item := f.items[pkOf(in.Key)]
+	if item == nil {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	for name, attr := range in.ExpressionAttributeNames {
+		item[attr] = in.ExpressionAttributeValues[":"+name[1:]]
+	}
+	f.updates++
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func TestReindex(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeReindexData{fakeDynamoData: newFakeDynamoData()}
+	hook := marshalWebhook(Webhook{ID: "a", Owner: "crm", URL: "https://crm.example.com/hooks"})
+	f.items[pkOf(hook)] = hook
+	legacy := marshalWebhook(Webhook{ID: "b", Owner: "crm", URL: "https://crm.example.com/hooks"})
+	delete(legacy, listIndexPK)
+	delete(legacy, listIndexSK)
+	f.items[pkOf(legacy)] = legacy
+	f.items["USER#u1"] = map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: "USER#u1"}}
+	// A delivery left pending before deliveries were queued by due time.
+	f.items["WEBHOOKDELIVERY#d"] = map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: "WEBHOOKDELIVERY#d"},
+		"id":        &types.AttributeValueMemberS{Value: "d"},
+		"webhookId": &types.AttributeValueMemberS{Value: "b"},
+		"status":    &types.AttributeValueMemberS{Value: DeliveryPending},
+		"event":     &types.AttributeValueMemberS{Value: `{"userId":"u1"}`},
+	}
+	// A correction request from when they were listed by scan.
+	f.items["CORRECTION#c"] = map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: "CORRECTION#c"},
+		"id":        &types.AttributeValueMemberS{Value: "c"},
+		"userId":    &types.AttributeValueMemberS{Value: "u1"},
+		"status":    &types.AttributeValueMemberS{Value: CorrectionOpen},
+		"createdAt": &types.AttributeValueMemberS{Value: "2026-03-01T12:00:00Z"},
+	}
+
+	n, err := Reindex(ctx, f, "prefs")
+	if err != nil || n != 3 || f.updates != 3 {
+		t.Fatalf("expected the legacy webhook, delivery and correction updated, got %d %v", n, err)
+	}
+	if pk := f.items["WEBHOOKDELIVERY#d"][userIndexPK].(*types.AttributeValueMemberS).Value; pk != userDataPartition+"u1" {
+		t.Fatalf("expected the delivery in its user's data partition, got %q", pk)
+	}
+	correction := f.items["CORRECTION#c"]
+	if pk := correction[listIndexPK].(*types.AttributeValueMemberS).Value; pk != correctionUserPartition+"u1" {
+		t.Fatalf("expected the correction in its user's partition, got %q", pk)
+	}
+	if pk := correction[queueIndexPK].(*types.AttributeValueMemberS).Value; pk != correctionQueuePartition+CorrectionOpen {
+		t.Fatalf("expected the correction in the open queue, got %q", pk)
+	}
+	due := f.items["WEBHOOKDELIVERY#d"][queueIndexSK].(*types.AttributeValueMemberS).Value
+	if want := indexTime(time.Time{}) + "#d"; due != want {
+		t.Fatalf("expected the pending delivery due at once, got %q", due)
+	}
+	if pk, _ := legacy[listIndexPK].(*types.AttributeValueMemberS); pk == nil || pk.Value != webhooksPartition {
+		t.Fatalf("expected the webhook in the webhooks partition, got %v", legacy[listIndexPK])
+	}
+	if _, ok := f.items["USER#u1"][listIndexPK]; ok {
+		t.Fatal("expected unindexed kinds left alone")
+	}
+	if n, _ := Reindex(ctx, f, "prefs"); n != 0 {
+		t.Fatalf("expected a second run to change nothing, got %d", n)
+	}
+}
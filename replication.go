@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// replicaAPI is the subset of the DynamoDB client standby replication
+// uses, on the standby table and, to resync, the primary.
+type replicaAPI interface {
+	GetItem(ctx context.Context, in *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, in *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Scan(ctx context.Context, in *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// replicationStatusPK is the standby table item recording how far the
+// standby is up to date.
+const replicationStatusPK = "REPLICATION#standby"
+
+// Standby writes are conditional on the item's version, so replaying a
+// change the standby already has, or one older than it has, changes
+// nothing. A delete removes the version the stream record removed or an
+// older one; resync deletes only the version it saw.
+const (
+	replicaPutCondition    = "attribute_not_exists(PK) OR version < :v"
+	replicaDeleteCondition = "attribute_not_exists(PK) OR version <= :v"
+	resyncDeleteCondition  = "version = :v"
+)
+
+// ReplicationStatus is how far the standby is known to be up to date.
+type ReplicationStatus struct {
+	// SyncedAt is when the standby last held every earlier change, or zero
+	// if replication has not reported yet.
+	SyncedAt time.Time
+	// Diverged is set when changes were lost before they were applied, so
+	// the standby needs a resync; Reason says why.
+	Diverged bool
+	Reason   string
+}
+
+// StandbyReplicator keeps the standby table's copy of every user's
+// preferences item up to date from the primary table's stream: the stream
+// worker applies each record with Apply before checkpointing past it, so
+// changes survive restarts and are applied in order, at least once. It
+// records its progress and any divergence in the standby table, where the
+// API's FailoverStore reads them.
+type StandbyReplicator struct {
+	client replicaAPI
+	table  string
+	logger *slog.Logger
+
+	mu sync.Mutex
+	// synced holds, for each shard being read, when the standby last held
+	// all of its earlier changes.
+	synced map[string]time.Time
+}
+
+// NewStandbyReplicator creates a replicator writing to table through
+// client.
+func NewStandbyReplicator(client replicaAPI, table string, logger *slog.Logger) *StandbyReplicator {
+	return &StandbyReplicator{client: client, table: table, logger: logger, synced: make(map[string]time.Time)}
+}
+
+// Apply writes the change in a stream record of a user's preferences item
+// to the standby. Records of other items are skipped.
+func (r *StandbyReplicator) Apply(ctx context.Context, rec streamtypes.Record) error {
+	sr := rec.Dynamodb
+	pk, _ := sr.Keys["PK"].(*streamtypes.AttributeValueMemberS)
+	if pk == nil || !strings.HasPrefix(pk.Value, "USER#") {
+		return nil
+	}
+	var err error
+	if rec.EventName == streamtypes.OperationTypeRemove {
+		err = r.delete(ctx, fromStreamItem(sr.Keys), fromStreamItem(sr.OldImage)["version"], replicaDeleteCondition)
+	} else {
+		err = r.put(ctx, fromStreamItem(sr.NewImage))
+	}
+	if err != nil {
+		return fmt.Errorf("replicating %s: %w", pk.Value, err)
+	}
+	return nil
+}
+
+// put writes item to the standby unless it holds the same or a later
+// version. Items without a version are written as they are.
+func (r *StandbyReplicator) put(ctx context.Context, item map[string]types.AttributeValue) error {
+	in := &dynamodb.PutItemInput{TableName: aws.String(r.table), Item: item}
+	if v, ok := item["version"]; ok {
+		in.ConditionExpression = aws.String(replicaPutCondition)
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{":v": v}
+	}
+	_, err := r.client.PutItem(ctx, in)
+	var ccf *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &ccf) {
+		return fmt.Errorf("PutItem (standby): %w", err)
+	}
+	return nil
+}
+
+// delete removes the standby's item under key on condition cond over
+// version, or unconditionally without a version.
+func (r *StandbyReplicator) delete(ctx context.Context, key map[string]types.AttributeValue, version types.AttributeValue, cond string) error {
+	in := &dynamodb.DeleteItemInput{TableName: aws.String(r.table), Key: map[string]types.AttributeValue{"PK": key["PK"]}}
+	if version != nil {
+		in.ConditionExpression = aws.String(cond)
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{":v": version}
+	}
+	_, err := r.client.DeleteItem(ctx, in)
+	var ccf *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &ccf) {
+		return fmt.Errorf("DeleteItem (standby): %w", err)
+	}
+	return nil
+}
+
+// Synced records that every change in shardID made before at has been
+// applied.
+func (r *StandbyReplicator) Synced(shardID string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.synced[shardID] = at
+}
+
+// ShardDone stops tracking a shard that has been read to its end.
+func (r *StandbyReplicator) ShardDone(shardID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.synced, shardID)
+}
+
+// Report saves when the standby last held every change, as of the shard
+// furthest behind. It saves nothing until a shard has been read.
+func (r *StandbyReplicator) Report(ctx context.Context) error {
+	r.mu.Lock()
+	var synced time.Time
+	for _, at := range r.synced {
+		if synced.IsZero() || at.Before(synced) {
+			synced = at
+		}
+	}
+	r.mu.Unlock()
+	if synced.IsZero() {
+		return nil
+	}
+	_, err := r.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(r.table),
+		Key:              map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: replicationStatusPK}},
+		UpdateExpression: aws.String("SET syncedAt = :at"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at": &types.AttributeValueMemberS{Value: synced.UTC().Format(time.RFC3339Nano)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateItem (replication status): %w", err)
+	}
+	return nil
+}
+
+// Diverge records that changes were lost before being applied, so the
+// standby needs a resync.
+func (r *StandbyReplicator) Diverge(ctx context.Context, reason string) error {
+	r.logger.Error("standby has diverged from the primary and needs a resync", "reason", reason)
+	_, err := r.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(r.table),
+		Key:              map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: replicationStatusPK}},
+		UpdateExpression: aws.String("SET diverged = :true, divergedReason = :reason, divergedAt = :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true":   &types.AttributeValueMemberBOOL{Value: true},
+			":reason": &types.AttributeValueMemberS{Value: reason},
+			":now":    &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339Nano)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateItem (replication status): %w", err)
+	}
+	return nil
+}
+
+// ReplicationStatus reads the replication status from the standby table.
+func (r *StandbyReplicator) ReplicationStatus(ctx context.Context) (ReplicationStatus, error) {
+	out, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(r.table),
+		Key:       map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: replicationStatusPK}},
+	})
+	if err != nil {
+		return ReplicationStatus{}, fmt.Errorf("GetItem (replication status): %w", err)
+	}
+	var st ReplicationStatus
+	if v, ok := out.Item["syncedAt"].(*types.AttributeValueMemberS); ok {
+		st.SyncedAt, _ = time.Parse(time.RFC3339Nano, v.Value)
+	}
+	if v, ok := out.Item["diverged"].(*types.AttributeValueMemberBOOL); ok {
+		st.Diverged = v.Value
+	}
+	if v, ok := out.Item["divergedReason"].(*types.AttributeValueMemberS); ok {
+		st.Reason = v.Value
+	}
+	return st, nil
+}
+
+// Resync brings the standby level with the primary table: it copies every
+// user's preferences item, then deletes the standby's items for users the
+// primary no longer has. Both are version-conditional, so it can run while
+// the stream worker replicates. Finally it clears a divergence recorded
+// before it started. It returns how many items it copied and deleted.
+func (r *StandbyReplicator) Resync(ctx context.Context, primary replicaAPI, primaryTable string) (copied, deleted int, err error) {
+	started := time.Now().UTC()
+	err = scanUsers(ctx, primary, primaryTable, func(item map[string]types.AttributeValue) error {
+		copied++
+		return r.put(ctx, item)
+	})
+	if err != nil {
+		return copied, deleted, err
+	}
+
+	err = scanUsers(ctx, r.client, r.table, func(item map[string]types.AttributeValue) error {
+		out, err := primary.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:            aws.String(primaryTable),
+			Key:                  map[string]types.AttributeValue{"PK": item["PK"]},
+			ProjectionExpression: aws.String("PK"),
+			ConsistentRead:       aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("GetItem (primary): %w", err)
+		}
+		if out.Item != nil {
+			return nil
+		}
+		deleted++
+		return r.delete(ctx, item, item["version"], resyncDeleteCondition)
+	})
+	if err != nil {
+		return copied, deleted, err
+	}
+
+	_, err = r.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(r.table),
+		Key:                 map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: replicationStatusPK}},
+		UpdateExpression:    aws.String("REMOVE diverged, divergedReason, divergedAt"),
+		ConditionExpression: aws.String("attribute_not_exists(divergedAt) OR divergedAt <= :started"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":started": &types.AttributeValueMemberS{Value: started.Format(time.RFC3339Nano)},
+		},
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return copied, deleted, fmt.Errorf("the standby diverged again during the resync; run it again")
+	}
+	if err != nil {
+		return copied, deleted, fmt.Errorf("UpdateItem (replication status): %w", err)
+	}
+	return copied, deleted, nil
+}
+
+// scanUsers calls fn with every user's preferences item in table.
+func scanUsers(ctx context.Context, client replicaAPI, table string, fn func(map[string]types.AttributeValue) error) error {
+	in := &dynamodb.ScanInput{
+		TableName:                 aws.String(table),
+		FilterExpression:          aws.String("begins_with(PK, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":prefix": &types.AttributeValueMemberS{Value: "USER#"}},
+		ConsistentRead:            aws.Bool(true),
+	}
+	for {
+		page, err := client.Scan(ctx, in)
+		if err != nil {
+			return fmt.Errorf("Scan (%s): %w", table, err)
+		}
+		for _, item := range page.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if len(page.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		in.ExclusiveStartKey = page.LastEvaluatedKey
+	}
+}
+
+// newStandbyStore connects to the standby table configured in cfg.
+func newStandbyStore(ctx context.Context, cfg Config) (*DynamoStore, error) {
+	standbyCfg := cfg
+	standbyCfg.DynamoTableName = cfg.StandbyTableName
+	standbyCfg.DynamoEndpoint = cfg.StandbyEndpoint
+	standbyCfg.AWSRegion = cfg.StandbyRegion
+	return NewDynamoStore(ctx, standbyCfg)
+}
+
+// runResyncStandby implements "user-prefs resync-standby": it copies the
+// primary table's users to the standby and clears a recorded divergence.
+func runResyncStandby(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("resync-standby", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "resync-standby: %v\n", err)
+		return 1
+	}
+	if cfg.StandbyTableName == "" {
+		fmt.Fprintln(stderr, "resync-standby: STANDBY_DYNAMODB_TABLE_NAME is not set")
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	primary, err := NewDynamoStore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "resync-standby: %v\n", err)
+		return 1
+	}
+	standby, err := newStandbyStore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "resync-standby: %v\n", err)
+		return 1
+	}
+
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel}))
+	r := NewStandbyReplicator(standby.client, standby.tableName, logger)
+	copied, deleted, err := r.Resync(ctx, primary.client, primary.tableName)
+	if err != nil {
+		fmt.Fprintf(stderr, "resync-standby: %v (%d copied, %d deleted)\n", err, copied, deleted)
+		return 1
+	}
+	fmt.Fprintf(stdout, "Resynced %s: %d item(s) copied, %d deleted.\n", standby.tableName, copied, deleted)
+	return 0
+}
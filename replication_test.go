@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// fakeReplica is a table keyed by PK that evaluates the conditions
+// replication uses.
+type fakeReplica struct {
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+	puts  int
+}
+
+func newFakeReplica(items ...map[string]types.AttributeValue) *fakeReplica {
+	f := &fakeReplica{items: map[string]map[string]types.AttributeValue{}}
+	for _, item := range items {
+		f.items[pkOf(item)] = item
+	}
+	return f
+}
+
+// userItem is a user's preferences item at version.
+func userItem(userID string, version int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK":      &types.AttributeValueMemberS{Value: "USER#" + userID},
+		"version": &types.AttributeValueMemberN{Value: strconv.Itoa(version)},
+	}
+}
+
+func itemVersion(item map[string]types.AttributeValue) int {
+	v, _ := item["version"].(*types.AttributeValueMemberN)
+	if v == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(v.Value)
+	return n
+}
+
+func (f *fakeReplica) check(cond *string, cur map[string]types.AttributeValue, values map[string]types.AttributeValue) error {
+	if cond == nil {
+		return nil
+	}
+	v := 0
+	if n, ok := values[":v"].(*types.AttributeValueMemberN); ok {
+		v, _ = strconv.Atoi(n.Value)
+	}
+	var ok bool
+	switch *cond {
+	case replicaPutCondition:
+		ok = cur == nil || itemVersion(cur) < v
+	case replicaDeleteCondition:
+		ok = cur == nil || itemVersion(cur) <= v
+	case resyncDeleteCondition:
+		ok = cur != nil && itemVersion(cur) == v
+	case "attribute_not_exists(divergedAt) OR divergedAt <= :started":
+		at, _ := cur["divergedAt"].(*types.AttributeValueMemberS)
+		ok = at == nil || at.Value <= values[":started"].(*types.AttributeValueMemberS).Value
+	default:
+		return fmt.Errorf("unexpected condition %q", *cond)
+	}
+	if !ok {
+		return &types.ConditionalCheckFailedException{}
+	}
+	return nil
+}
+
+func (f *fakeReplica) GetItem(_ context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: maps.Clone(f.items[pkOf(in.Key)])}, nil
+}
+
+func (f *fakeReplica) PutItem(_ context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(in.ConditionExpression, f.items[pkOf(in.Item)], in.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	f.items[pkOf(in.Item)] = in.Item
+	f.puts++
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeReplica) DeleteItem(_ context.Context, in *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(in.ConditionExpression, f.items[pkOf(in.Key)], in.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	delete(f.items, pkOf(in.Key))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// UpdateItem supports "SET a = :a, ..." and "REMOVE a, ...".
+func (f *fakeReplica) UpdateItem(_ context.Context, in *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pk := pkOf(in.Key)
+	if err := f.check(in.ConditionExpression, f.items[pk], in.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	item := maps.Clone(f.items[pk])
+	if item == nil {
+		item = maps.Clone(in.Key)
+	}
+	expr := *in.UpdateExpression
+	if names, ok := strings.CutPrefix(expr, "REMOVE "); ok {
+		for _, name := range strings.Split(names, ", ") {
+			delete(item, name)
+		}
+	} else {
+		for _, set := range strings.Split(strings.TrimPrefix(expr, "SET "), ", ") {
+			name, value, _ := strings.Cut(set, " = ")
+			item[name] = in.ExpressionAttributeValues[value]
+		}
+	}
+	f.items[pk] = item
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// Scan returns the items in one page, applying replication's USER# filter.
+func (f *fakeReplica) Scan(_ context.Context, in *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out dynamodb.ScanOutput
+	for pk, item := range f.items {
+		if strings.HasPrefix(pk, "USER#") {
+			out.Items = append(out.Items, maps.Clone(item))
+		}
+	}
+	return &out, nil
+}
+
+func TestStandbyReplicator_Apply(t *testing.T) {
+	standby := newFakeReplica()
+	r := NewStandbyReplicator(standby, "standby", testLogger())
+	ctx := context.Background()
+	dark := map[string]streamtypes.AttributeValue{"theme": &streamtypes.AttributeValueMemberS{Value: "dark"}}
+	records := []streamtypes.Record{
+		streamRecord(1, streamtypes.OperationTypeInsert, "USER#user1", nil, dark),
+		streamRecord(2, streamtypes.OperationTypeInsert, "WEBHOOK#wh1", nil, dark),
+		streamRecord(3, streamtypes.OperationTypeModify, "USER#user2", dark, dark),
+		streamRecord(4, streamtypes.OperationTypeModify, "USER#user1", dark, dark),
+		streamRecord(5, streamtypes.OperationTypeRemove, "USER#user2", dark, nil),
+	}
+	for _, rec := range records {
+		if err := r.Apply(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(standby.items) != 1 || itemVersion(standby.items["USER#user1"]) != 4 {
+		t.Fatalf("expected only user1 at version 4, got %v", standby.items)
+	}
+
+	// Replaying from an earlier checkpoint, as after a restart, leaves the
+	// standby as it was: older versions are not written over newer ones.
+	for _, rec := range records[:1] {
+		if err := r.Apply(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if itemVersion(standby.items["USER#user1"]) != 4 {
+		t.Fatalf("expected a replayed older version to be ignored, got %v", standby.items["USER#user1"])
+	}
+}
+
+func TestStandbyReplicator_Report(t *testing.T) {
+	standby := newFakeReplica()
+	r := NewStandbyReplicator(standby, "standby", testLogger())
+	ctx := context.Background()
+	if err := r.Report(ctx); err != nil || len(standby.items) != 0 {
+		t.Fatalf("expected nothing reported before a shard is read, got %v (%v)", standby.items, err)
+	}
+
+	t0 := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	r.Synced("shard-1", t0.Add(time.Minute))
+	r.Synced("shard-2", t0)
+	r.Report(ctx)
+	if st, err := r.ReplicationStatus(ctx); err != nil || !st.SyncedAt.Equal(t0) || st.Diverged {
+		t.Fatalf("expected the shard furthest behind reported, got %+v (%v)", st, err)
+	}
+	r.ShardDone("shard-2")
+	r.Report(ctx)
+	if st, _ := r.ReplicationStatus(ctx); !st.SyncedAt.Equal(t0.Add(time.Minute)) {
+		t.Fatalf("expected finished shards ignored, got %+v", st)
+	}
+}
+
+func TestStandbyReplicator_Resync(t *testing.T) {
+	primary := newFakeReplica(userItem("a", 2), userItem("c", 1))
+	standby := newFakeReplica(userItem("a", 3), userItem("b", 1))
+	r := NewStandbyReplicator(standby, "standby", testLogger())
+	ctx := context.Background()
+	r.Diverge(ctx, "stream records were trimmed before being replicated")
+
+	copied, deleted, err := r.Resync(ctx, primary, "primary")
+	if err != nil || copied != 2 || deleted != 1 {
+		t.Fatalf("expected 2 copied and 1 deleted, got %d %d (%v)", copied, deleted, err)
+	}
+	// a's newer version on the standby was replicated after the scan read
+	// the primary, so it stays.
+	if itemVersion(standby.items["USER#a"]) != 3 || itemVersion(standby.items["USER#c"]) != 1 || standby.items["USER#b"] != nil {
+		t.Fatalf("unexpected standby items %v", standby.items)
+	}
+	if st, err := r.ReplicationStatus(ctx); err != nil || st.Diverged || st.Reason != "" {
+		t.Fatalf("expected the divergence cleared, got %+v (%v)", st, err)
+	}
+
+	// A divergence recorded after the resync started is kept.
+	standby.items[replicationStatusPK]["divergedAt"] = &types.AttributeValueMemberS{Value: time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)}
+	if _, _, err := r.Resync(ctx, primary, "primary"); err == nil {
+		t.Fatal("expected a later divergence to fail the resync")
+	}
+}
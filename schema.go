@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// Preference value types declared in the schema.
+const (
+	TypeString  = "string"
+	TypeBoolean = "boolean"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+)
+
+// KeyDef declares one well-known preference key. When the server loads the
+// schema, written values must have the declared type and meet its
+// constraints.
+type KeyDef struct {
+	Name string   `json:"name"`
+	Type string   `json:"type"`
+	Enum []string `json:"enum,omitempty"`
+	// Pattern is a regular expression string values must match in full.
+	Pattern string `json:"pattern,omitempty"`
+	// Minimum and Maximum bound integer and number values, inclusively.
+	Minimum     *float64 `json:"minimum,omitempty"`
+	Maximum     *float64 `json:"maximum,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+	// ReplacedBy names the key superseding a deprecated key, making this
+	// key an alias of it (see aliases.go). It implies Deprecated.
+	ReplacedBy string `json:"replacedBy,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// PreferenceSchema is the registry of well-known preference keys, loaded from
+// the file named by PREFERENCE_SCHEMA_FILE.
+type PreferenceSchema struct {
+	Keys []KeyDef `json:"keys"`
+}
+
+// LoadSchema reads and validates a schema file.
+func LoadSchema(path string) (*PreferenceSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema: %w", err)
+	}
+
+	var schema PreferenceSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+	if err := schema.Validate(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// Validate checks the schema for duplicate or malformed key definitions.
+func (s *PreferenceSchema) Validate() error {
+	seen := make(map[string]bool, len(s.Keys))
+	for i, k := range s.Keys {
+		if k.Name == "" {
+			return fmt.Errorf("schema key %d: missing name", i)
+		}
+		if seen[k.Name] {
+			return fmt.Errorf("schema key %q: declared twice", k.Name)
+		}
+		seen[k.Name] = true
+
+		switch k.Type {
+		case "":
+			s.Keys[i].Type = TypeString
+		case TypeString, TypeBoolean, TypeInteger, TypeNumber:
+		default:
+			return fmt.Errorf("schema key %q: unknown type %q", k.Name, k.Type)
+		}
+		if len(k.Enum) > 0 && s.Keys[i].Type != TypeString {
+			return fmt.Errorf("schema key %q: enum is only supported for string keys", k.Name)
+		}
+		if k.Default != "" && len(k.Enum) > 0 && !slices.Contains(k.Enum, k.Default) {
+			return fmt.Errorf("schema key %q: default %q is not in enum", k.Name, k.Default)
+		}
+		if k.Pattern != "" {
+			if s.Keys[i].Type != TypeString {
+				return fmt.Errorf("schema key %q: pattern is only supported for string keys", k.Name)
+			}
+			re, err := regexp.Compile("^(?:" + k.Pattern + ")$")
+			if err != nil {
+				return fmt.Errorf("schema key %q: invalid pattern: %w", k.Name, err)
+			}
+			s.Keys[i].re = re
+		}
+		if k.Minimum != nil || k.Maximum != nil {
+			if s.Keys[i].Type != TypeInteger && s.Keys[i].Type != TypeNumber {
+				return fmt.Errorf("schema key %q: minimum and maximum are only supported for numeric keys", k.Name)
+			}
+			if k.Minimum != nil && k.Maximum != nil && *k.Minimum > *k.Maximum {
+				return fmt.Errorf("schema key %q: minimum is above maximum", k.Name)
+			}
+		}
+		if k.ReplacedBy != "" {
+			if k.ReplacedBy == k.Name {
+				return fmt.Errorf("schema key %q: replaced by itself", k.Name)
+			}
+			if reason := keyViolation(k.ReplacedBy, nil); reason != "" {
+				return fmt.Errorf("schema key %q: replacedBy: %s", k.Name, reason)
+			}
+			s.Keys[i].Deprecated = true
+		}
+		if k.Default != "" {
+			if reason := s.Keys[i].check(defaultValue(s.Keys[i])); reason != "" {
+				return fmt.Errorf("schema key %q: default %q: %s", k.Name, k.Default, reason)
+			}
+		}
+	}
+	for _, k := range s.Keys {
+		if target, ok := s.Lookup(k.ReplacedBy); ok && target.ReplacedBy != "" {
+			return fmt.Errorf("schema key %q: replacedBy %q is itself replaced", k.Name, k.ReplacedBy)
+		}
+	}
+	return nil
+}
+
+// defaultValue returns k.Default as the JSON value a client would write.
+func defaultValue(k KeyDef) any {
+	switch k.Type {
+	case TypeBoolean:
+		if v, err := strconv.ParseBool(k.Default); err == nil {
+			return v
+		}
+	case TypeInteger, TypeNumber:
+		return json.Number(k.Default)
+	}
+	return k.Default
+}
+
+// check returns why value does not satisfy k, or "" if it does. Numbers
+// are json.Number, as decoded by decodeJSON.
+func (k KeyDef) check(value any) string {
+	switch k.Type {
+	case TypeString:
+		s, ok := value.(string)
+		if !ok {
+			return "value must be a string"
+		}
+		if len(k.Enum) > 0 && !slices.Contains(k.Enum, s) {
+			return fmt.Sprintf("value must be one of %q", k.Enum)
+		}
+		if k.re != nil && !k.re.MatchString(s) {
+			return fmt.Sprintf("value must match %q", k.Pattern)
+		}
+	case TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return "value must be a boolean"
+		}
+	case TypeInteger, TypeNumber:
+		n, ok := value.(json.Number)
+		if !ok {
+			return "value must be a " + k.Type
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return "value must be a " + k.Type
+		}
+		if k.Type == TypeInteger {
+			if _, err := n.Int64(); err != nil {
+				return "value must be an integer"
+			}
+		}
+		if k.Minimum != nil && f < *k.Minimum {
+			return "value must be at least " + strconv.FormatFloat(*k.Minimum, 'g', -1, 64)
+		}
+		if k.Maximum != nil && f > *k.Maximum {
+			return "value must be at most " + strconv.FormatFloat(*k.Maximum, 'g', -1, 64)
+		}
+	}
+	return ""
+}
+
+// Lookup returns the definition for a key.
+func (s *PreferenceSchema) Lookup(name string) (KeyDef, bool) {
+	for _, k := range s.Keys {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return KeyDef{}, false
+}
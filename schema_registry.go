@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// schemaID is the record holding the stored preference schema: one
+// preference per declared key, whose value is the KeyDef.
+const schemaID = "global"
+
+// SchemaSource supplies the preference schema enforced on writes.
+type SchemaSource interface {
+	LoadSchema(ctx context.Context) (*PreferenceSchema, error)
+}
+
+// FileSchema reads the schema from a file (PREFERENCE_SCHEMA_FILE).
+type FileSchema struct {
+	Path string
+}
+
+func (f FileSchema) LoadSchema(context.Context) (*PreferenceSchema, error) {
+	return LoadSchema(f.Path)
+}
+
+// StoredSchema reads the schema managed through the admin schema API.
+type StoredSchema struct {
+	Store Store
+}
+
+func (s StoredSchema) LoadSchema(ctx context.Context) (*PreferenceSchema, error) {
+	rec, err := s.Store.GetAll(ctx, schemaID)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := schemaFromPrefs(rec.Prefs)
+	if err != nil {
+		return nil, err
+	}
+	if err := schema.Validate(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// schemaFromPrefs decodes a stored schema record, ordered by key name.
+func schemaFromPrefs(prefs map[string]any) (*PreferenceSchema, error) {
+	schema := &PreferenceSchema{Keys: []KeyDef{}}
+	for _, name := range slices.Sorted(maps.Keys(prefs)) {
+		data, err := json.Marshal(prefs[name])
+		if err != nil {
+			return nil, fmt.Errorf("schema key %q: %w", name, err)
+		}
+		var def KeyDef
+		if err := json.Unmarshal(data, &def); err != nil {
+			return nil, fmt.Errorf("schema key %q: %w", name, err)
+		}
+		def.Name = name
+		schema.Keys = append(schema.Keys, def)
+	}
+	return schema, nil
+}
+
+// keyDefValue encodes def as a stored preference value.
+func keyDefValue(def KeyDef) (any, error) {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]any
+	if err := decodeJSON(bytes.NewReader(data), &v); err != nil {
+		return nil, err
+	}
+	delete(v, "name")
+	return v, nil
+}
+
+// SchemaRegistry caches the schema from a SchemaSource, so writes do not
+// read it on every request.
+type SchemaRegistry struct {
+	source  SchemaSource
+	refresh time.Duration
+	logger  *slog.Logger
+
+	mu     sync.RWMutex
+	schema *PreferenceSchema
+}
+
+// NewSchemaRegistry creates an empty registry; call Refresh before serving.
+// refresh is how often Run reloads the source; zero loads it only once.
+func NewSchemaRegistry(source SchemaSource, refresh time.Duration, logger *slog.Logger) *SchemaRegistry {
+	return &SchemaRegistry{source: source, refresh: refresh, logger: logger}
+}
+
+// Refresh reloads the schema. On failure the cached schema is left
+// untouched.
+func (s *SchemaRegistry) Refresh(ctx context.Context) error {
+	schema, err := s.source.LoadSchema(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.schema = schema
+	s.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the schema until ctx is cancelled.
+func (s *SchemaRegistry) Run(ctx context.Context) {
+	if s.refresh <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				s.logger.Warn("schema refresh failed; enforcing cached schema", "error", err)
+			}
+		}
+	}
+}
+
+// Get returns the current schema, or nil if none is loaded. It must not be
+// modified.
+func (s *SchemaRegistry) Get() *PreferenceSchema {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.schema
+}
+
+// SchemaHandler serves the admin endpoints managing the stored schema.
+// Changes take effect on this instance at once and on others at their next
+// refresh.
+type SchemaHandler struct {
+	prefs    *PreferencesHandler
+	store    Store
+	registry *SchemaRegistry
+}
+
+// NewSchemaHandler creates a schema handler over the store the registry
+// loads from.
+func NewSchemaHandler(prefs *PreferencesHandler, store Store, registry *SchemaRegistry) *SchemaHandler {
+	return &SchemaHandler{prefs: prefs, store: store, registry: registry}
+}
+
+// Get returns the stored schema, with its version as the ETag.
+func (h *SchemaHandler) Get(w http.ResponseWriter, r *http.Request) {
+	rec, err := h.store.GetAll(r.Context(), schemaID)
+	if err != nil {
+		h.prefs.logger.Error("schema GetAll failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve schema")
+		return
+	}
+	schema, err := schemaFromPrefs(rec.Prefs)
+	if err != nil {
+		h.prefs.logger.Error("stored schema is invalid", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve schema")
+		return
+	}
+	setValidators(w, rec)
+	writeJSON(w, http.StatusOK, schema)
+}
+
+// Replace replaces the whole schema. It honors If-Match.
+func (h *SchemaHandler) Replace(w http.ResponseWriter, r *http.Request) {
+	cond, ok := h.prefs.precondition(w, r)
+	if !ok {
+		return
+	}
+	var schema PreferenceSchema
+	if err := decodeJSON(r.Body, &schema); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if err := schema.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	prefs := make(map[string]any, len(schema.Keys))
+	for _, def := range schema.Keys {
+		if !h.checkName(w, def.Name) {
+			return
+		}
+		v, err := keyDefValue(def)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid key definition "+def.Name)
+			return
+		}
+		prefs[def.Name] = v
+	}
+
+	rec, err := h.store.ReplaceAll(r.Context(), schemaID, prefs, cond)
+	h.writeResult(w, r, rec, err)
+}
+
+// GetKey returns one key definition.
+func (h *SchemaHandler) GetKey(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	rec, err := h.store.GetAll(r.Context(), schemaID)
+	if err != nil {
+		h.prefs.logger.Error("schema GetAll failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve schema")
+		return
+	}
+	v, ok := rec.Prefs[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "key not declared")
+		return
+	}
+	schema, err := schemaFromPrefs(map[string]any{name: v})
+	if err != nil {
+		h.prefs.logger.Error("stored schema is invalid", "error", err, "key", name)
+		writeError(w, http.StatusInternalServerError, "failed to retrieve schema")
+		return
+	}
+	setValidators(w, rec)
+	writeJSON(w, http.StatusOK, schema.Keys[0])
+}
+
+// PutKey declares or redefines one key. The name comes from the path. It
+// honors If-Match against the whole schema's version.
+func (h *SchemaHandler) PutKey(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	cond, ok := h.prefs.precondition(w, r)
+	if !ok {
+		return
+	}
+	var def KeyDef
+	if err := decodeJSON(r.Body, &def); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	if def.Name != "" && def.Name != name {
+		writeError(w, http.StatusBadRequest, "name does not match the path")
+		return
+	}
+	def.Name = name
+	if !h.checkName(w, name) {
+		return
+	}
+	single := PreferenceSchema{Keys: []KeyDef{def}}
+	if err := single.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	v, err := keyDefValue(single.Keys[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid key definition")
+		return
+	}
+
+	rec, err := h.store.Update(r.Context(), schemaID, map[string]any{name: v}, nil, cond)
+	h.writeResult(w, r, rec, err)
+}
+
+// DeleteKey removes one key definition. It honors If-Match.
+func (h *SchemaHandler) DeleteKey(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	cond, ok := h.prefs.precondition(w, r)
+	if !ok {
+		return
+	}
+	_, found, err := h.store.Stat(r.Context(), schemaID, name)
+	if err != nil {
+		h.prefs.logger.Error("schema Stat failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to update schema")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "key not declared")
+		return
+	}
+
+	cond.MustExist = true
+	rec, err := h.store.Update(r.Context(), schemaID, nil, []string{name}, cond)
+	h.writeResult(w, r, rec, err)
+}
+
+// checkName applies the key name rules to a declared key, since a key
+// that cannot be written has no use in the schema.
+func (h *SchemaHandler) checkName(w http.ResponseWriter, name string) bool {
+	if reason := keyViolation(name, nil); reason != "" {
+		writeViolations(w, ErrCodeSchemaInvalid, "invalid key definitions", []Violation{{Key: name, Code: ErrCodeSchemaInvalid, Reason: reason}})
+		return false
+	}
+	return true
+}
+
+// writeResult answers a schema write with the resulting schema and reloads
+// the registry.
+func (h *SchemaHandler) writeResult(w http.ResponseWriter, r *http.Request, rec Record, err error) {
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, http.StatusPreconditionFailed, "schema has been modified")
+		return
+	}
+	if err != nil {
+		h.prefs.logger.Error("schema write failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to update schema")
+		return
+	}
+	claims, _ := ClaimsFromContext(r.Context())
+	h.prefs.logger.Info("preference schema updated", "sub", claims.Subject, "version", rec.Version)
+	if err := h.registry.Refresh(r.Context()); err != nil {
+		h.prefs.logger.Warn("schema reload failed; enforcing cached schema", "error", err)
+	}
+
+	schema, err := schemaFromPrefs(rec.Prefs)
+	if err != nil {
+		h.prefs.logger.Error("stored schema is invalid", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to update schema")
+		return
+	}
+	setValidators(w, rec)
+	writeJSON(w, http.StatusOK, schema)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSchemaHandler(t *testing.T) {
+	schemaStore := newMockStore()
+	registry := NewSchemaRegistry(StoredSchema{Store: schemaStore}, 0, testLogger())
+	if err := registry.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	prefs := NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{Schema: registry})
+	h := NewSchemaHandler(prefs, schemaStore, registry)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/admin/schema", h.Get)
+	mux.HandleFunc("PUT /api/v1/admin/schema", h.Replace)
+	mux.HandleFunc("GET /api/v1/admin/schema/keys/{name}", h.GetKey)
+	mux.HandleFunc("PUT /api/v1/admin/schema/keys/{name}", h.PutKey)
+	mux.HandleFunc("DELETE /api/v1/admin/schema/keys/{name}", h.DeleteKey)
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", prefs.ReplaceAll)
+	send := func(method, path, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "admin"))
+		return w
+	}
+
+	w := send("PUT", "/api/v1/admin/schema", `{"keys":[{"name":"theme","type":"string","enum":["light","dark"]},{"name":"beta","type":"boolean"}]}`, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if _, ok := registry.Get().Lookup("theme"); !ok {
+		t.Fatal("expected the registry to be reloaded after a write")
+	}
+
+	w = send("PUT", "/api/v1/admin/schema/keys/items_per_page", `{"type":"integer","minimum":10,"maximum":100,"deprecated":true}`, etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if w := send("PUT", "/api/v1/admin/schema/keys/beta", `{"type":"string"}`, etag); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for a stale If-Match, got %d", w.Code)
+	}
+	if w := send("PUT", "/api/v1/admin/schema/keys/x", `{"type":"string","pattern":"("}`, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid definition, got %d", w.Code)
+	}
+
+	w = send("GET", "/api/v1/admin/schema/keys/items_per_page", "", "")
+	var def KeyDef
+	json.NewDecoder(w.Body).Decode(&def)
+	if w.Code != http.StatusOK || def.Name != "items_per_page" || def.Maximum == nil || *def.Maximum != 100 || !def.Deprecated {
+		t.Fatalf("unexpected key definition %d %+v", w.Code, def)
+	}
+
+	// The stored rules are enforced on user writes.
+	if w := send("PUT", "/api/v1/users/admin/preferences", `{"items_per_page":500}`, ""); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 from the stored schema, got %d", w.Code)
+	}
+
+	if w := send("DELETE", "/api/v1/admin/schema/keys/items_per_page", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if w := send("DELETE", "/api/v1/admin/schema/keys/items_per_page", "", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an undeclared key, got %d", w.Code)
+	}
+
+	w = send("GET", "/api/v1/admin/schema", "", "")
+	var schema PreferenceSchema
+	json.NewDecoder(w.Body).Decode(&schema)
+	if len(schema.Keys) != 2 || schema.Keys[0].Name != "beta" || schema.Keys[1].Enum[1] != "dark" {
+		t.Fatalf("unexpected schema %+v", schema)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadSchema_Example(t *testing.T) {
+	if _, err := LoadSchema("schema.example.json"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchemaValidate_Constraints(t *testing.T) {
+	ten, five := 10.0, 5.0
+	for _, k := range []KeyDef{
+		{Name: "a", Type: TypeBoolean, Pattern: "x"},
+		{Name: "a", Type: TypeString, Pattern: "("},
+		{Name: "a", Type: TypeString, Minimum: &ten},
+		{Name: "a", Type: TypeInteger, Minimum: &ten, Maximum: &five},
+		{Name: "a", Type: TypeInteger, Minimum: &ten, Default: "5"},
+		{Name: "a", Type: TypeString, Pattern: "[a-z]+", Default: "A"},
+		{Name: "a", Type: TypeBoolean, Default: "yes"},
+	} {
+		s := &PreferenceSchema{Keys: []KeyDef{k}}
+		if err := s.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", k)
+		}
+	}
+}
+
+func TestKeyDefCheck(t *testing.T) {
+	s, err := LoadSchema("schema.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key   string
+		value any
+		valid bool
+	}{
+		{"theme", "dark", true},
+		{"theme", "purple", false},
+		{"theme", json.Number("1"), false},
+		{"lang", "en-GB", true},
+		{"lang", "English", false},
+		{"items_per_page", json.Number("10"), true},
+		{"items_per_page", json.Number("100"), true},
+		{"items_per_page", json.Number("101"), false},
+		{"items_per_page", json.Number("25.5"), false},
+		{"items_per_page", "25", false},
+		{"notifications.email", false, true},
+		{"notifications.email", "false", false},
+	}
+	for _, tt := range tests {
+		def, _ := s.Lookup(tt.key)
+		if got := def.check(tt.value) == ""; got != tt.valid {
+			t.Errorf("%s=%v: expected valid=%v, got %q", tt.key, tt.value, tt.valid, def.check(tt.value))
+		}
+	}
+}
+
+func TestValidatePrefs_Schema(t *testing.T) {
+	s := NewSchemaRegistry(FileSchema{Path: "schema.example.json"}, 0, testLogger())
+	if err := s.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	store := newMockStore()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Schema: s})
+
+	req := httptest.NewRequest("PUT", "/api/v1/users/user1/preferences", bytes.NewBufferString(`{"theme":"purple","items_per_page":500,"custom":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetPathValue("userId", "user1")
+	w := httptest.NewRecorder()
+	h.ReplaceAll(w, withClaims(req, "user1"))
+
+	var resp APIError
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusUnprocessableEntity || len(resp.Violations) != 2 {
+		t.Fatalf("expected 422 with two violations, got %d %+v", w.Code, resp)
+	}
+	if resp.Violations[0].Key != "items_per_page" || resp.Violations[1].Key != "theme" {
+		t.Fatalf("unexpected violations %+v", resp.Violations)
+	}
+	if _, ok := store.prefs["user1"]; ok {
+		t.Fatal("expected nothing to be stored")
+	}
+}
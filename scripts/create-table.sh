@@ -11,7 +11,37 @@ aws dynamodb create-table \
   --endpoint-url "${ENDPOINT}" \
   --region "${REGION}" \
   --table-name "${TABLE_NAME}" \
-  --attribute-definitions AttributeName=PK,AttributeType=S \
+  --attribute-definitions AttributeName=PK,AttributeType=S AttributeName=GSI1PK,AttributeType=S AttributeName=GSI1SK,AttributeType=S AttributeName=GSI2PK,AttributeType=S AttributeName=GSI2SK,AttributeType=S AttributeName=GSI3PK,AttributeType=S AttributeName=GSI3SK,AttributeType=S \
   --key-schema AttributeName=PK,KeyType=HASH \
+  --global-secondary-indexes "IndexName=GSI1,KeySchema=[{AttributeName=GSI1PK,KeyType=HASH},{AttributeName=GSI1SK,KeyType=RANGE}],Projection={ProjectionType=ALL}" "IndexName=GSI2,KeySchema=[{AttributeName=GSI2PK,KeyType=HASH},{AttributeName=GSI2SK,KeyType=RANGE}],Projection={ProjectionType=ALL}" "IndexName=GSI3,KeySchema=[{AttributeName=GSI3PK,KeyType=HASH},{AttributeName=GSI3SK,KeyType=RANGE}],Projection={ProjectionType=KEYS_ONLY}" \
   --billing-mode PAY_PER_REQUEST \
   2>/dev/null && echo "Table created." || echo "Table already exists or creation failed."
+
+# Idempotency-Key results expire via TTL.
+aws dynamodb update-time-to-live \
+  --endpoint-url "${ENDPOINT}" \
+  --region "${REGION}" \
+  --table-name "${TABLE_NAME}" \
+  --time-to-live-specification Enabled=true,AttributeName=expiresAt \
+  >/dev/null 2>&1 || echo "Enabling TTL on table failed."
+
+HISTORY_TABLE_NAME="${HISTORY_TABLE_NAME:-}"
+if [ -n "${HISTORY_TABLE_NAME}" ]; then
+  echo "Creating history table '${HISTORY_TABLE_NAME}' at ${ENDPOINT}..."
+
+  aws dynamodb create-table \
+    --endpoint-url "${ENDPOINT}" \
+    --region "${REGION}" \
+    --table-name "${HISTORY_TABLE_NAME}" \
+    --attribute-definitions AttributeName=PK,AttributeType=S AttributeName=SK,AttributeType=S \
+    --key-schema AttributeName=PK,KeyType=HASH AttributeName=SK,KeyType=RANGE \
+    --billing-mode PAY_PER_REQUEST \
+    2>/dev/null && echo "History table created." || echo "History table already exists or creation failed."
+
+  aws dynamodb update-time-to-live \
+    --endpoint-url "${ENDPOINT}" \
+    --region "${REGION}" \
+    --table-name "${HISTORY_TABLE_NAME}" \
+    --time-to-live-specification Enabled=true,AttributeName=expiresAt \
+    >/dev/null 2>&1 || echo "Enabling TTL on history table failed."
+fi
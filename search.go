@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// SearchQuery selects users by preference: those with Key set, or, when
+// Values is non-empty, set to one of Values. An empty Key selects every
+// user with stored preferences.
+type SearchQuery struct {
+	Key    string
+	Values []any
+}
+
+// SearchMatch is one user found by a search, with their value for the key.
+type SearchMatch struct {
+	UserID string `json:"userId"`
+	Value  any    `json:"value"`
+}
+
+// UserSearcher finds users by preference across the table.
+type UserSearcher interface {
+	// SearchUsers returns up to limit matches, resuming after the cursor
+	// position after. next is empty once the table is exhausted; it may be
+	// set with fewer than limit matches when the scan budget ran out.
+	SearchUsers(ctx context.Context, q SearchQuery, limit int, after string) (matches []SearchMatch, next string, err error)
+}
+
+// defaultSearchLimit and maxSearchLimit bound ?limit= on searches.
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+// SearchResponse is a page of search results.
+type SearchResponse struct {
+	Key        string        `json:"key"`
+	Matches    []SearchMatch `json:"matches"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// SearchHandler serves the admin preference search, for support and
+// rollout analysis.
+type SearchHandler struct {
+	prefs    *PreferencesHandler
+	searcher UserSearcher
+}
+
+// NewSearchHandler creates a search handler sharing prefs' options.
+func NewSearchHandler(prefs *PreferencesHandler, searcher UserSearcher) *SearchHandler {
+	return &SearchHandler{prefs: prefs, searcher: searcher}
+}
+
+// Search finds users with ?key= set, or set to ?value=. The value is parsed
+// as JSON when it can be and also matched as a plain string, since items
+// written before typed values hold only strings: value=true finds both true
+// and "true". Results come a page at a time with ?limit= and ?cursor=.
+// Sensitive keys are encrypted at rest and cannot be searched.
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := SearchQuery{Key: q.Get("key")}
+	if reason := keyViolation(query.Key, nil); reason != "" {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeKeyInvalid, "invalid key: "+reason)
+		return
+	}
+	if slices.Contains(h.prefs.opts.SensitiveKeys, query.Key) {
+		writeError(w, http.StatusBadRequest, "sensitive keys cannot be searched")
+		return
+	}
+	if q.Has("value") {
+		raw := q.Get("value")
+		var v any
+		if err := decodeJSON(bytes.NewReader([]byte(raw)), &v); err == nil && v != nil {
+			query.Values = append(query.Values, v)
+		}
+		if _, ok := v.(string); !ok {
+			query.Values = append(query.Values, raw)
+		}
+	}
+
+	limit, after, ok := searchPage(w, r)
+	if !ok {
+		return
+	}
+
+	matches, next, err := h.searcher.SearchUsers(r.Context(), query, limit, after)
+	if err != nil {
+		h.prefs.logger.Error("SearchUsers failed", "error", err, "key", query.Key)
+		writeError(w, http.StatusInternalServerError, "failed to search preferences")
+		return
+	}
+	resp := SearchResponse{Key: query.Key, Matches: matches}
+	if next != "" {
+		resp.NextCursor = encodeCursor(next)
+	}
+	if resp.Matches == nil {
+		resp.Matches = []SearchMatch{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// UsersResponse is a page of the users with stored preferences.
+type UsersResponse struct {
+	Users      []string `json:"users"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+}
+
+// ListUsers lists the users with stored preferences, for support tooling
+// (prefsctl users). Like a search, it scans the table, a page at a time
+// with ?limit= and ?cursor=.
+func (h *SearchHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	limit, after, ok := searchPage(w, r)
+	if !ok {
+		return
+	}
+	matches, next, err := h.searcher.SearchUsers(r.Context(), SearchQuery{}, limit, after)
+	if err != nil {
+		h.prefs.logger.Error("SearchUsers failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list users")
+		return
+	}
+	resp := UsersResponse{Users: make([]string, len(matches))}
+	for i, m := range matches {
+		resp.Users[i] = m.UserID
+	}
+	if next != "" {
+		resp.NextCursor = encodeCursor(next)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// searchPage parses ?limit= and ?cursor=, writing the error response if
+// they are invalid.
+func searchPage(w http.ResponseWriter, r *http.Request) (limit int, after string, ok bool) {
+	q := r.URL.Query()
+	limit = defaultSearchLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be 1 to %d", maxSearchLimit))
+			return 0, "", false
+		}
+		limit = n
+	}
+	if cursor := q.Get("cursor"); cursor != "" {
+		var err error
+		if after, err = decodeCursor(cursor); err != nil {
+			writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidCursor, "invalid cursor")
+			return 0, "", false
+		}
+	}
+	return limit, after, true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeSearcher implements UserSearcher for testing, recording the last
+// query.
+type fakeSearcher struct {
+	query SearchQuery
+	limit int
+	after string
+}
+
+func (f *fakeSearcher) SearchUsers(_ context.Context, q SearchQuery, limit int, after string) ([]SearchMatch, string, error) {
+	f.query, f.limit, f.after = q, limit, after
+	return []SearchMatch{{UserID: "user1", Value: true}}, "USER#user1", nil
+}
+
+func TestSearch(t *testing.T) {
+	searcher := &fakeSearcher{}
+	prefs := NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{SensitiveKeys: []string{"secret"}})
+	h := NewSearchHandler(prefs, searcher)
+	search := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.Search(w, httptest.NewRequest("GET", "/api/v1/admin/preferences/search?"+query, nil))
+		return w
+	}
+
+	w := search("key=beta_features&value=true&limit=10")
+	var resp SearchResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Matches) != 1 || resp.NextCursor == "" {
+		t.Fatalf("unexpected response %d %+v", w.Code, resp)
+	}
+	if !reflect.DeepEqual(searcher.query.Values, []any{true, "true"}) || searcher.limit != 10 {
+		t.Fatalf("expected the typed and string values, got %+v limit %d", searcher.query, searcher.limit)
+	}
+
+	search("key=beta_features&cursor=" + resp.NextCursor)
+	if searcher.after != "USER#user1" || searcher.query.Values != nil {
+		t.Fatalf("expected a key-only search resuming at the cursor, got %+v after %q", searcher.query, searcher.after)
+	}
+
+	search("key=theme&value=dark")
+	if !reflect.DeepEqual(searcher.query.Values, []any{"dark"}) {
+		t.Fatalf("expected a plain string value, got %+v", searcher.query.Values)
+	}
+
+	for _, q := range []string{"", "key=a..b", "key=secret", "key=theme&limit=0", "key=theme&cursor=!!"} {
+		if w := search(q); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	searcher := &fakeSearcher{}
+	h := NewSearchHandler(NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{}), searcher)
+	w := httptest.NewRecorder()
+	h.ListUsers(w, httptest.NewRequest("GET", "/api/v1/admin/users?limit=5", nil))
+	var resp UsersResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || !reflect.DeepEqual(resp.Users, []string{"user1"}) || resp.NextCursor == "" {
+		t.Fatalf("unexpected response %d %+v", w.Code, resp)
+	}
+	if searcher.query.Key != "" || searcher.limit != 5 {
+		t.Fatalf("expected a query for every user, got %+v limit %d", searcher.query, searcher.limit)
+	}
+}
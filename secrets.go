@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// SecretRef is a secret held in AWS Secrets Manager or SSM Parameter Store,
+// identified by ARN. The current value is swapped atomically on refresh, so
+// readers always see a complete value.
+type SecretRef struct {
+	ARN   string
+	value atomic.Pointer[string]
+}
+
+// Value returns the most recently fetched secret value.
+func (s *SecretRef) Value() string {
+	if v := s.value.Load(); v != nil {
+		return *v
+	}
+	return ""
+}
+
+// secretFetcher resolves a secret ARN to its current value.
+type secretFetcher interface {
+	fetch(ctx context.Context, arn string) (string, error)
+}
+
+// awsSecrets fetches from Secrets Manager or SSM depending on the ARN's
+// service, in the region named by the ARN.
+type awsSecrets struct {
+	sm  *secretsmanager.Client
+	ssm *ssm.Client
+}
+
+func newAWSSecrets(ctx context.Context, region string) (*awsSecrets, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &awsSecrets{
+		sm:  secretsmanager.NewFromConfig(awsCfg),
+		ssm: ssm.NewFromConfig(awsCfg),
+	}, nil
+}
+
+func (a *awsSecrets) fetch(ctx context.Context, arn string) (string, error) {
+	// arn:partition:service:region:account:resource
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return "", fmt.Errorf("invalid secret ARN %q", arn)
+	}
+	service, region := parts[2], parts[3]
+
+	switch service {
+	case "secretsmanager":
+		out, err := a.sm.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(arn),
+		}, func(o *secretsmanager.Options) { o.Region = region })
+		if err != nil {
+			return "", fmt.Errorf("fetching %s: %w", arn, err)
+		}
+		if out.SecretString == nil {
+			return "", fmt.Errorf("secret %s has no string value", arn)
+		}
+		return *out.SecretString, nil
+	case "ssm":
+		out, err := a.ssm.GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(arn),
+			WithDecryption: aws.Bool(true),
+		}, func(o *ssm.Options) { o.Region = region })
+		if err != nil {
+			return "", fmt.Errorf("fetching %s: %w", arn, err)
+		}
+		return aws.ToString(out.Parameter.Value), nil
+	default:
+		return "", fmt.Errorf("secret ARN %q is neither secretsmanager nor ssm", arn)
+	}
+}
+
+// loadSecrets fetches every ref once, failing if any cannot be read.
+func loadSecrets(ctx context.Context, f secretFetcher, refs []*SecretRef) error {
+	for _, ref := range refs {
+		v, err := f.fetch(ctx, ref.ARN)
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			return fmt.Errorf("secret %s is empty", ref.ARN)
+		}
+		ref.value.Store(&v)
+	}
+	return nil
+}
+
+// RefreshSecrets re-fetches the config's secret refs every interval until ctx
+// is cancelled, so rotated secrets take effect without a restart. A failed
+// refresh keeps the previous value.
+func RefreshSecrets(ctx context.Context, cfg Config, logger *slog.Logger) {
+	refs := cfg.secretRefs()
+	if len(refs) == 0 || cfg.SecretsRefresh <= 0 {
+		return
+	}
+
+	f, err := newAWSSecrets(ctx, cfg.AWSRegion)
+	if err != nil {
+		logger.Error("secret refresh disabled", "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(cfg.SecretsRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, ref := range refs {
+			fctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			v, err := f.fetch(fctx, ref.ARN)
+			cancel()
+			if err != nil || v == "" {
+				logger.Warn("secret refresh failed; keeping previous value", "arn", ref.ARN, "error", err)
+				continue
+			}
+			if v != ref.Value() {
+				logger.Info("secret rotated", "arn", ref.ARN)
+			}
+			ref.value.Store(&v)
+		}
+	}
+}
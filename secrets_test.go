@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) fetch(_ context.Context, arn string) (string, error) {
+	v, ok := f[arn]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return v, nil
+}
+
+func TestLoadSecrets(t *testing.T) {
+	ref := &SecretRef{ARN: "arn:aws:secretsmanager:us-east-1:123456789012:secret:jwt"}
+	if err := loadSecrets(context.Background(), fakeSecrets{ref.ARN: "s3cret"}, []*SecretRef{ref}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ref.Value() != "s3cret" {
+		t.Fatalf("expected s3cret, got %q", ref.Value())
+	}
+
+	missing := &SecretRef{ARN: "arn:aws:ssm:us-east-1:123456789012:parameter/missing"}
+	if err := loadSecrets(context.Background(), fakeSecrets{}, []*SecretRef{missing}); err == nil {
+		t.Fatal("expected error for missing secret")
+	}
+}
+
+func TestAWSSecrets_RejectsUnknownARN(t *testing.T) {
+	a := &awsSecrets{}
+	for _, arn := range []string{"not-an-arn", "arn:aws:s3:::bucket/key"} {
+		if _, err := a.fetch(context.Background(), arn); err == nil {
+			t.Fatalf("expected error for %q", arn)
+		}
+	}
+}
+
+func TestJWTAuth_SecretFuncRotation(t *testing.T) {
+	ref := &SecretRef{}
+	old := "old-secret"
+	ref.value.Store(&old)
+
+	mux := jwtTestMux(JWTAuth(JWTOptions{SecretFunc: ref.Value}), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	send := func(secret string) int {
+		req := httptest.NewRequest("GET", "/api/v1/users/user1/preferences", nil)
+		req.Header.Set("Authorization", "Bearer "+makeToken("user1", secret, jwt.SigningMethodHS256))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := send("old-secret"); code != http.StatusOK {
+		t.Fatalf("expected 200 before rotation, got %d", code)
+	}
+	rotated := "new-secret"
+	ref.value.Store(&rotated)
+	if code := send("old-secret"); code != http.StatusUnauthorized {
+		t.Fatalf("expected old secret rejected after rotation, got %d", code)
+	}
+	if code := send("new-secret"); code != http.StatusOK {
+		t.Fatalf("expected 200 with rotated secret, got %d", code)
+	}
+}
@@ -3,32 +3,437 @@ package main
 import (
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 )
 
+// Handlers groups the HTTP handlers served by the router.
+type Handlers struct {
+	Prefs       *PreferencesHandler
+	Corrections *CorrectionsHandler
+	// Failover is nil unless a standby store is configured.
+	Failover *FailoverHandler
+	// Audit receives auth denials and admin actions; nil disables auditing.
+	Audit *AuditLog
+	// History is nil unless preference history is configured.
+	History *HistoryHandler
+	// Layers serves org and team preference layers; nil disables them.
+	Layers *LayersHandler
+	// Devices serves device-scoped preferences; nil disables them.
+	Devices *DevicesHandler
+	// Experiments serves experiment bucket assignments; nil unless
+	// experiments are configured.
+	Experiments *ExperimentsHandler
+	// Stream serves change events as Server-Sent Events; nil disables it.
+	Stream *StreamHandler
+	// Sync pushes change events over WebSocket; nil disables it.
+	Sync *SyncHandler
+	// Webhooks manages webhook subscriptions; nil disables the endpoints.
+	Webhooks *WebhooksHandler
+	// Erase deletes all data held about a user.
+	Erase *EraseHandler
+	// Stats reports aggregate preference statistics; nil disables it.
+	Stats *StatsHandler
+	// Search finds users by preference; nil disables it.
+	Search *SearchHandler
+	// Schema manages the stored preference schema; nil unless the schema
+	// is kept in the table.
+	Schema *SchemaHandler
+	// Idempotency stores Idempotency-Key results; nil disables the header.
+	Idempotency IdempotencyStore
+}
+
 // NewRouter registers all routes and wraps them with the middleware chain.
-func NewRouter(h *PreferencesHandler, cfg Config, logger *slog.Logger) http.Handler {
+func NewRouter(hs Handlers, cfg Config, logger *slog.Logger) http.Handler {
 	mux := http.NewServeMux()
-	auth := JWTAuth(cfg.JWTSecret, cfg.JWTIssuer, cfg.DevBypassAuth)
+	auth := newAuth(cfg)
+	h := hs.Prefs
 
 	// Health check (no auth required)
 	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 	})
+	mux.HandleFunc("GET /openapi.json", OpenAPI)
+
+	// CSRF token for cookie-authenticated browser clients
+	if cfg.JWTCookieName != "" {
+		mux.HandleFunc("GET /api/v1/csrf-token", CSRFToken(cfg.CSRFCookieName))
+	}
+
+	// Catalog of well-known keys, for building settings screens
+	mux.HandleFunc("GET /api/v1/preference-keys", auth(h.Catalog))
 
-	// Preferences CRUD
+	// Preferences CRUD; writes honor Idempotency-Key when it is enabled
+	idem := idempotent(hs, cfg, logger)
+	write := func(next http.HandlerFunc) http.HandlerFunc { return auth(idem(next)) }
 	mux.HandleFunc("GET /api/v1/users/{userId}/preferences", auth(h.GetAll))
 	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/{key}", auth(h.GetOne))
-	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", auth(h.ReplaceAll))
-	mux.HandleFunc("POST /api/v1/users/{userId}/preferences", auth(h.ReplaceAll))
-	mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", auth(h.PatchPrefs))
-	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences", auth(h.DeleteAll))
-	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences/{key}", auth(h.DeleteOne))
-
-	// Middleware chain: Recovery → CORS → RequestLogging → mux
-	var handler http.Handler = mux
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", write(h.ReplaceAll))
+	mux.HandleFunc("POST /api/v1/users/{userId}/preferences", write(h.ReplaceAll))
+	mux.HandleFunc("PATCH /api/v1/users/{userId}/preferences", write(h.PatchPrefs))
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences/{key}", write(h.SetOne))
+	mux.HandleFunc("POST /api/v1/users/{userId}/preferences/{key}", write(h.CreateOne))
+	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences", write(h.DeleteAll))
+	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences/{key}", write(h.DeleteOne))
+
+	// API v2: the same preferences with versioned envelopes and RFC 7807
+	// errors
+	mux.HandleFunc("GET /api/v2/users/{userId}/preferences", APIv2(auth(h.GetAll)))
+	mux.HandleFunc("GET /api/v2/users/{userId}/preferences/{key}", APIv2(auth(h.GetOne)))
+	mux.HandleFunc("PUT /api/v2/users/{userId}/preferences", APIv2(write(h.ReplaceAll)))
+	mux.HandleFunc("PATCH /api/v2/users/{userId}/preferences", APIv2(write(h.PatchPrefs)))
+	mux.HandleFunc("PUT /api/v2/users/{userId}/preferences/{key}", APIv2(write(h.SetOne)))
+	mux.HandleFunc("POST /api/v2/users/{userId}/preferences/{key}", APIv2(write(h.CreateOne)))
+	mux.HandleFunc("DELETE /api/v2/users/{userId}/preferences", APIv2(write(h.DeleteAll)))
+	mux.HandleFunc("DELETE /api/v2/users/{userId}/preferences/{key}", APIv2(write(h.DeleteOne)))
+
+	// Restoring soft-deleted preferences
+	if h.opts.Trash != nil {
+		mux.HandleFunc("POST /api/v1/users/{userId}/preferences:restore", write(h.RestoreDeleted))
+	}
+	// Undoing the last DeleteAll or ReplaceAll
+	if h.opts.Undo != nil {
+		mux.HandleFunc("POST /api/v1/users/{userId}/preferences:undo", write(h.Undo))
+	}
+
+	// Layered org/team/user resolution
+	if hs.Layers != nil {
+		mux.HandleFunc("GET /api/v1/users/{userId}/preferences:resolve", auth(hs.Layers.Resolve))
+	}
+
+	// Device-scoped preferences
+	if d := hs.Devices; d != nil {
+		mux.HandleFunc("GET /api/v1/users/{userId}/devices", auth(d.List))
+		mux.HandleFunc("GET /api/v1/users/{userId}/devices/{deviceId}/preferences", auth(d.Get))
+		mux.HandleFunc("PUT /api/v1/users/{userId}/devices/{deviceId}/preferences", write(d.Replace))
+		mux.HandleFunc("PATCH /api/v1/users/{userId}/devices/{deviceId}/preferences", write(d.Patch))
+		mux.HandleFunc("DELETE /api/v1/users/{userId}/devices/{deviceId}/preferences", write(d.Delete))
+		mux.HandleFunc("GET /api/v1/users/{userId}/devices/{deviceId}/preferences:resolve", auth(d.Resolve))
+	}
+
+	// Experiment bucket assignments
+	if hs.Experiments != nil {
+		mux.HandleFunc("GET /api/v1/users/{userId}/experiments", auth(hs.Experiments.List))
+		mux.HandleFunc("GET /api/v1/users/{userId}/experiments/{experiment}", auth(hs.Experiments.Get))
+	}
+
+	// Account data download and restore
+	mux.HandleFunc("GET /api/v1/users/{userId}/preferences/export", auth(h.Export))
+	mux.HandleFunc("POST /api/v1/users/{userId}/preferences/import", write(h.Import))
+
+	// Live change events for keeping open clients in sync
+	if hs.Stream != nil {
+		mux.HandleFunc("GET /api/v1/users/{userId}/preferences/stream", auth(hs.Stream.Stream))
+	}
+	if hs.Sync != nil {
+		mux.HandleFunc("GET /api/v1/users/{userId}/preferences:subscribe", auth(hs.Sync.Subscribe))
+	}
+
+	// Change history
+	if hs.History != nil {
+		mux.HandleFunc("GET /api/v1/users/{userId}/preferences/history", auth(hs.History.List))
+		mux.HandleFunc("GET /api/v1/users/{userId}/preferences/changes", auth(hs.History.Changes))
+		mux.HandleFunc("POST /api/v1/users/{userId}/preferences:sync", write(hs.History.Sync))
+		mux.HandleFunc("POST /api/v1/users/{userId}/preferences/versions/{version}", write(hs.History.Restore))
+		mux.HandleFunc("GET /api/v1/users/{userId}/preferences/versions/{a}/diff/{b}", auth(hs.History.Diff))
+	}
+
+	// Internal batch API for service principals
+	mux.HandleFunc("POST /api/v1/internal/preferences:batchGet", auth(RequireScope(ScopeRead)(h.BatchGet)))
+	mux.HandleFunc("POST /api/v1/internal/preferences:batchSet", auth(RequireScope(ScopeWrite)(h.BatchSet)))
+	mux.HandleFunc("POST /api/v1/internal/preferences:batchDelete", auth(RequireScope(ScopeWrite)(h.BatchDelete)))
+	if len(h.opts.Templates) > 0 {
+		mux.HandleFunc("POST /api/v1/internal/users/{userId}/preferences:applyTemplate", auth(RequireScope(ScopeWrite)(h.ApplyTemplate)))
+	}
+
+	// Webhook subscriptions, owned by the registering principal; changing
+	// them needs the write scope
+	if wh := hs.Webhooks; wh != nil {
+		mux.HandleFunc("POST /api/v1/internal/webhooks", auth(RequireScope(ScopeWrite)(wh.Create)))
+		mux.HandleFunc("GET /api/v1/internal/webhooks", auth(RequireScope(ScopeRead)(wh.List)))
+		mux.HandleFunc("GET /api/v1/internal/webhooks/{id}", auth(RequireScope(ScopeRead)(wh.Get)))
+		mux.HandleFunc("PUT /api/v1/internal/webhooks/{id}", auth(RequireScope(ScopeWrite)(wh.Replace)))
+		mux.HandleFunc("DELETE /api/v1/internal/webhooks/{id}", auth(RequireScope(ScopeWrite)(wh.Delete)))
+		mux.HandleFunc("GET /api/v1/internal/webhooks/{id}/deliveries", auth(RequireScope(ScopeRead)(wh.Deliveries)))
+		mux.HandleFunc("POST /api/v1/internal/webhooks/{id}/deliveries:redrive", auth(RequireScope(ScopeWrite)(wh.Redrive)))
+	}
+
+	// Data correction requests
+	mux.HandleFunc("POST /api/v1/users/{userId}/corrections", auth(hs.Corrections.Create))
+	mux.HandleFunc("GET /api/v1/users/{userId}/corrections", auth(hs.Corrections.ListOwn))
+
+	// Admin API, unless it is served on its own port
+	if cfg.AdminPort == "" {
+		registerAdminRoutes(mux, hs, cfg, logger)
+	}
+
+	// Middleware chain: Recovery → CORS → RequestLogging → MessagePack → CanonicalJSON → [Audit] → LoadLimit → AuthThrottle → [CSRFProtect] → [StalenessHeaders] → mux
+	var handler http.Handler = routeErrors(mux)
+	if hs.Failover != nil {
+		handler = StalenessHeaders(hs.Failover.store)(handler)
+	}
+	if cfg.JWTCookieName != "" {
+		handler = CSRFProtect(cfg.JWTCookieName, cfg.CSRFCookieName)(handler)
+	}
+	handler = AuthThrottle(ThrottleOptions{
+		MaxFailures:    cfg.AuthThrottleMaxFailures,
+		Window:         cfg.AuthThrottleWindow,
+		BaseBlock:      cfg.AuthThrottleBlock,
+		MaxBlock:       cfg.AuthThrottleMaxBlock,
+		CookieName:     cfg.JWTCookieName,
+		TrustedProxies: cfg.TrustedProxies,
+	})(handler)
+	handler = LoadLimit(LimitOptions{
+		RPS:           cfg.RateLimitRPS,
+		Burst:         cfg.RateLimitBurst,
+		MaxInFlight:   cfg.MaxInFlight,
+		LatencyTarget: cfg.ShedLatency,
+	})(handler)
+	if hs.Audit != nil {
+		handler = Audit(hs.Audit)(handler)
+	}
+	handler = CanonicalJSON(cfg.CanonicalJSON)(handler)
+	handler = MessagePack(handler)
 	handler = RequestLogging(logger)(handler)
 	handler = CORS(cfg.CORSAllowOrigin)(handler)
 	handler = Recovery(logger)(handler)
 
 	return handler
 }
+
+// routeErrors answers requests that match no route with the API's error
+// body instead of ServeMux's plain text: 404, or 405 when the path exists
+// for other methods. ServeMux still sets the Allow header on 405s.
+func routeErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		notFound := func(w http.ResponseWriter, r *http.Request) {
+			bw := &bufferingWriter{ResponseWriter: w, status: http.StatusOK}
+			h.ServeHTTP(bw, r)
+			if bw.status != http.StatusNotFound && bw.status != http.StatusMethodNotAllowed {
+				w.WriteHeader(bw.status)
+				w.Write(bw.body.Bytes())
+				return
+			}
+			w.Header().Del("X-Content-Type-Options")
+			writeError(w, bw.status, strings.ToLower(http.StatusText(bw.status)))
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/v2/") {
+			notFound = APIv2(notFound)
+		}
+		notFound(w, r)
+	})
+}
+
+// idempotent returns the Idempotency middleware for writes, or passes
+// requests through when Idempotency-Key is disabled. It reads the token
+// subject, so it goes inside authentication.
+func idempotent(hs Handlers, cfg Config, logger *slog.Logger) func(http.HandlerFunc) http.HandlerFunc {
+	if hs.Idempotency == nil {
+		return func(next http.HandlerFunc) http.HandlerFunc { return next }
+	}
+	return Idempotency(IdempotencyOptions{
+		Store:         hs.Idempotency,
+		TTL:           cfg.IdempotencyTTL,
+		SensitiveKeys: cfg.SensitiveKeys,
+		Logger:        logger,
+	})
+}
+
+// NewAdminRouter serves the admin API on its own listener (ADMIN_PORT), so it
+// can be kept off the public network entirely.
+func NewAdminRouter(hs Handlers, cfg Config, logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /openapi.json", OpenAPI)
+	registerAdminRoutes(mux, hs, cfg, logger)
+
+	// Middleware chain: Recovery → RequestLogging → [Audit] → AuthThrottle → mux
+	var handler http.Handler = routeErrors(mux)
+	handler = AuthThrottle(ThrottleOptions{
+		MaxFailures:    cfg.AuthThrottleMaxFailures,
+		Window:         cfg.AuthThrottleWindow,
+		BaseBlock:      cfg.AuthThrottleBlock,
+		MaxBlock:       cfg.AuthThrottleMaxBlock,
+		TrustedProxies: cfg.TrustedProxies,
+	})(handler)
+	if hs.Audit != nil {
+		handler = Audit(hs.Audit)(handler)
+	}
+	handler = RequestLogging(logger)(handler)
+	handler = Recovery(logger)(handler)
+
+	return handler
+}
+
+// registerAdminRoutes registers the /api/v1/admin routes behind admin auth.
+func registerAdminRoutes(mux *http.ServeMux, hs Handlers, cfg Config, logger *slog.Logger) {
+	admin := newAdminAuth(cfg)
+
+	// Data correction review
+	mux.HandleFunc("GET /api/v1/admin/corrections", admin(hs.Corrections.AdminList))
+	mux.HandleFunc("POST /api/v1/admin/corrections/{id}/resolve", admin(hs.Corrections.AdminResolve))
+
+	// Webhook subscriptions
+	if hs.Webhooks != nil {
+		mux.HandleFunc("GET /api/v1/admin/webhooks", admin(hs.Webhooks.AdminList))
+		mux.HandleFunc("DELETE /api/v1/admin/webhooks/{id}", admin(hs.Webhooks.AdminDelete))
+		mux.HandleFunc("GET /api/v1/admin/webhooks/{id}/deliveries", admin(hs.Webhooks.AdminDeliveries))
+		mux.HandleFunc("POST /api/v1/admin/webhooks/{id}/deliveries:redrive", admin(hs.Webhooks.AdminRedrive))
+	}
+
+	// Account deletion
+	if hs.Erase != nil {
+		mux.HandleFunc("DELETE /api/v1/admin/users/{userId}", admin(hs.Erase.AdminDeleteUser))
+	}
+
+	// Duplicate account merges
+	mux.HandleFunc("POST /api/v1/admin/users/{userId}/preferences:copyTo", admin(hs.Prefs.AdminCopy))
+
+	// Support access to any user's preferences (prefsctl)
+	support := func(next http.HandlerFunc) http.HandlerFunc { return admin(supportAccess(next)) }
+	mux.HandleFunc("GET /api/v1/admin/users/{userId}/preferences", support(hs.Prefs.GetAll))
+	mux.HandleFunc("PUT /api/v1/admin/users/{userId}/preferences", support(hs.Prefs.ReplaceAll))
+	mux.HandleFunc("PATCH /api/v1/admin/users/{userId}/preferences", support(hs.Prefs.PatchPrefs))
+	mux.HandleFunc("DELETE /api/v1/admin/users/{userId}/preferences", support(hs.Prefs.DeleteAll))
+	mux.HandleFunc("GET /api/v1/admin/users/{userId}/preferences/{key}", support(hs.Prefs.GetOne))
+	mux.HandleFunc("PUT /api/v1/admin/users/{userId}/preferences/{key}", support(hs.Prefs.SetOne))
+	mux.HandleFunc("DELETE /api/v1/admin/users/{userId}/preferences/{key}", support(hs.Prefs.DeleteOne))
+	mux.HandleFunc("GET /api/v1/admin/users/{userId}/preferences/export", support(hs.Prefs.Export))
+	idem := idempotent(hs, cfg, logger)
+	mux.HandleFunc("POST /api/v1/admin/users/{userId}/preferences/import", support(idem(hs.Prefs.Import)))
+	if hs.Stream != nil {
+		mux.HandleFunc("GET /api/v1/admin/users/{userId}/preferences/stream", support(hs.Stream.Stream))
+	}
+
+	// Org and team preference layers
+	if hs.Layers != nil {
+		for _, l := range []struct{ kind, path string }{{LayerOrg, "orgs"}, {LayerTeam, "teams"}} {
+			base := "/api/v1/admin/" + l.path + "/{id}/preferences"
+			mux.HandleFunc("GET "+base, admin(hs.Layers.GetLayer(l.kind)))
+			mux.HandleFunc("PUT "+base, admin(hs.Layers.ReplaceLayer(l.kind)))
+			mux.HandleFunc("PATCH "+base, admin(hs.Layers.PatchLayer(l.kind)))
+			mux.HandleFunc("DELETE "+base, admin(hs.Layers.DeleteLayer(l.kind)))
+		}
+		mux.HandleFunc("GET /api/v1/admin/users/{userId}/membership", admin(hs.Layers.GetMembership))
+		mux.HandleFunc("PUT /api/v1/admin/users/{userId}/membership", admin(hs.Layers.PutMembership))
+	}
+
+	// Capacity planning
+	if hs.Stats != nil {
+		mux.HandleFunc("GET /api/v1/admin/stats", admin(hs.Stats.Get))
+	}
+
+	// Cross-user preference search
+	if hs.Search != nil {
+		mux.HandleFunc("GET /api/v1/admin/preferences/search", admin(hs.Search.Search))
+		mux.HandleFunc("GET /api/v1/admin/users", admin(hs.Search.ListUsers))
+	}
+
+	// Preference schema registry
+	if hs.Schema != nil {
+		mux.HandleFunc("GET /api/v1/admin/schema", admin(hs.Schema.Get))
+		mux.HandleFunc("PUT /api/v1/admin/schema", admin(hs.Schema.Replace))
+		mux.HandleFunc("GET /api/v1/admin/schema/keys/{name}", admin(hs.Schema.GetKey))
+		mux.HandleFunc("PUT /api/v1/admin/schema/keys/{name}", admin(hs.Schema.PutKey))
+		mux.HandleFunc("DELETE /api/v1/admin/schema/keys/{name}", admin(hs.Schema.DeleteKey))
+	}
+
+	// Preference history for support
+	if hs.History != nil {
+		mux.HandleFunc("GET /api/v1/admin/users/{userId}/preferences/history", admin(hs.History.AdminList))
+		mux.HandleFunc("GET /api/v1/admin/users/{userId}/preferences/versions/{a}/diff/{b}", admin(hs.History.AdminDiff))
+	}
+
+	// Auth configuration preflight
+	mux.HandleFunc("GET /api/v1/admin/auth/preflight", admin(PreflightHandler(cfg)))
+
+	// Standby failover control
+	if hs.Failover != nil {
+		mux.HandleFunc("GET /api/v1/admin/failover", admin(hs.Failover.Status))
+		mux.HandleFunc("POST /api/v1/admin/failover", admin(hs.Failover.SetMode))
+	}
+}
+
+// supportAccess serves an admin caller from a user route's handler. The
+// handlers authorize service principals by scope, so the admin acts as a
+// service principal holding read and write for the request; writes are
+// attributed to the admin's subject.
+func supportAccess(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		claims.Kind = PrincipalService
+		claims.Scopes = append(slices.Clone(claims.Scopes), ScopeRead, ScopeWrite)
+		next(w, r.WithContext(contextWithClaims(r.Context(), claims)))
+	}
+}
+
+// newAuth returns the authentication middleware for the configured mode,
+// fronted by AWS IAM authentication when SigV4 principals are configured.
+func newAuth(cfg Config) func(http.HandlerFunc) http.HandlerFunc {
+	return authenticate(newAuthenticator(cfg))
+}
+
+// newAuthenticator returns the Authenticator behind newAuth, which the gRPC
+// listener shares.
+func newAuthenticator(cfg Config) Authenticator {
+	auth := primaryAuth(cfg)
+	if len(cfg.SigV4AllowedPrincipals) > 0 {
+		auth = sigV4Authenticator(SigV4Options{
+			AllowedPrincipals: cfg.SigV4AllowedPrincipals,
+			Scopes:            cfg.SigV4Scopes,
+			Audience:          cfg.SigV4Audience,
+		}, auth)
+	}
+	return auth
+}
+
+func primaryAuth(cfg Config) Authenticator {
+	switch cfg.AuthMode {
+	case AuthModeMTLS:
+		return MTLSOptions{
+			AllowedIdentities: cfg.MTLSAllowedIdents,
+			Scopes:            cfg.MTLSScopes,
+		}.authenticate
+	case AuthModeIntrospection:
+		opts := IntrospectionOptions{
+			URL:          cfg.IntrospectionURL,
+			ClientID:     cfg.IntrospectionClientID,
+			ClientSecret: cfg.IntrospectionClientSecret,
+			CacheTTL:     cfg.IntrospectionCacheTTL,
+			Audiences:    cfg.JWTAudiences,
+			CookieName:   cfg.JWTCookieName,
+		}
+		if cfg.IntrospectionSecretRef != nil {
+			opts.ClientSecretFunc = cfg.IntrospectionSecretRef.Value
+		}
+		return NewIntrospector(opts).authenticate
+	}
+
+	return jwtOptions(cfg).authenticate
+}
+
+// jwtOptions builds the JWTAuth options from config.
+func jwtOptions(cfg Config) JWTOptions {
+	opts := JWTOptions{
+		Secret:        cfg.JWTSecret,
+		Issuer:        cfg.JWTIssuer,
+		DevBypass:     cfg.DevBypassAuth,
+		DevBypassNets: cfg.DevBypassNets,
+		Audiences:     cfg.JWTAudiences,
+		CookieName:    cfg.JWTCookieName,
+		Actors:        cfg.JWTAllowedActors,
+		Algorithms:    cfg.JWTAlgorithms,
+		PublicKeys:    cfg.JWTPublicKeys,
+	}
+	if cfg.JWTSecretRef != nil {
+		opts.SecretFunc = cfg.JWTSecretRef.Value
+	}
+	return opts
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestNewRouter_RouteErrors(t *testing.T) {
+	prefs := NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{})
+	router := NewRouter(Handlers{Prefs: prefs, Corrections: NewCorrectionsHandler(prefs, newMockCorrectionStore())},
+		Config{AuthMode: AuthModeJWT, JWTSecret: testSecret}, testLogger())
+
+	tests := []struct {
+		method, path string
+		want         int
+		allow        string
+		contentType  string
+	}{
+		{"TRACE", "/api/v1/users/user1/preferences", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, PATCH, POST, PUT", "application/json"},
+		{"PATCH", "/api/v1/users/user1/preferences/theme", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, POST, PUT", "application/json"},
+		{"POST", "/healthz", http.StatusMethodNotAllowed, "GET, HEAD", "application/json"},
+		{"GET", "/api/v1/nope", http.StatusNotFound, "", "application/json"},
+		{"POST", "/api/v2/users/user1/preferences", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, PATCH, PUT", problemContentType},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.want || w.Header().Get("Allow") != tt.allow || w.Header().Get("Content-Type") != tt.contentType {
+			t.Fatalf("%s %s: expected %d Allow %q as %s, got %d %v", tt.method, tt.path, tt.want, tt.allow, tt.contentType, w.Code, w.Header())
+		}
+		var body map[string]any
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("%s %s: expected a JSON body: %v", tt.method, tt.path, err)
+		}
+	}
+
+	// Redirects to the clean path are left to ServeMux.
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1//healthz", nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected a redirect, got %d", w.Code)
+	}
+}
+
+func TestNewRouter_ImportIsIdempotent(t *testing.T) {
+	prefs := NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{})
+	router := NewRouter(Handlers{Prefs: prefs, Corrections: NewCorrectionsHandler(prefs, newMockCorrectionStore()), Idempotency: newMemIdempotency()},
+		Config{AuthMode: AuthModeJWT, JWTSecret: testSecret}, testLogger())
+
+	doc := `{"format":"user-prefs-export","version":1,"preferences":{"theme":"light"}}`
+	var replayed []string
+	for range 2 {
+		req := httptest.NewRequest("POST", "/api/v1/users/user1/preferences/import?mode=replace", strings.NewReader(doc))
+		req.Header.Set("Authorization", "Bearer "+makeToken("user1", testSecret, jwt.SigningMethodHS256))
+		req.Header.Set("Idempotency-Key", "import-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		replayed = append(replayed, w.Header().Get("Idempotent-Replayed"))
+	}
+	if replayed[0] != "" || replayed[1] != "true" {
+		t.Fatalf("expected the retried import replayed, got %q", replayed)
+	}
+}
+
+func TestNewRouter_HistoryAndCorrections(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user1"] = map[string]any{"plan": "free"}
+	prefs := NewPreferencesHandler(store, testLogger(), HandlerOptions{})
+	// Registering a route that conflicts with another panics.
+	router := NewRouter(Handlers{Prefs: prefs, Corrections: NewCorrectionsHandler(prefs, newMockCorrectionStore()), History: NewHistoryHandler(prefs, newMemHistory(), 0)},
+		Config{AuthMode: AuthModeJWT, JWTSecret: testSecret}, testLogger())
+
+	req := httptest.NewRequest("POST", "/api/v1/users/user1/corrections", strings.NewReader(`{"key":"plan","reason":"I upgraded"}`))
+	req.Header.Set("Authorization", "Bearer "+makeToken("user1", testSecret, jwt.SigningMethodHS256))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sigV4Scheme is the Authorization scheme carrying a presigned STS request.
+//
+// Callers prove their IAM identity the same way aws-iam-authenticator and
+// Vault's IAM auth do: they presign an sts:GetCallerIdentity request with
+// their own credentials (e.g. sts.NewPresignClient(...).PresignGetCallerIdentity)
+// and send the base64url-encoded URL as "Authorization: AWS-IAM <token>".
+// The server replays it to STS, which verifies the SigV4 signature and
+// reports the caller's ARN. No shared secret is needed on either side.
+const sigV4Scheme = "AWS-IAM"
+
+// sigV4AudienceHeader, when configured, must be among the presigned headers so
+// a token minted for this service cannot be replayed against another.
+const sigV4AudienceHeader = "x-user-prefs-audience"
+
+// SigV4Options configures the SigV4Auth middleware.
+type SigV4Options struct {
+	// AllowedPrincipals lists IAM ARNs allowed to call the API. Assumed-role
+	// sessions match on their role ARN (arn:aws:iam::<acct>:role/<name>).
+	AllowedPrincipals []string
+	Scopes            []string
+	Audience          string
+	HTTPClient        *http.Client
+}
+
+// stsRegions are the regions whose STS endpoints presigned requests may
+// name, besides the global sts.amazonaws.com; China's are under
+// amazonaws.com.cn. A pattern over amazonaws.com would also accept other
+// services' hosts, such as sts.s3.amazonaws.com.
+var stsRegions = []string{
+	"af-south-1", "ap-east-1", "ap-northeast-1", "ap-northeast-2", "ap-northeast-3",
+	"ap-south-1", "ap-south-2", "ap-southeast-1", "ap-southeast-2", "ap-southeast-3",
+	"ap-southeast-4", "ap-southeast-5", "ap-southeast-7", "ca-central-1", "ca-west-1",
+	"cn-north-1", "cn-northwest-1", "eu-central-1", "eu-central-2", "eu-north-1",
+	"eu-south-1", "eu-south-2", "eu-west-1", "eu-west-2", "eu-west-3",
+	"il-central-1", "me-central-1", "me-south-1", "mx-central-1", "sa-east-1",
+	"us-east-1", "us-east-2", "us-gov-east-1", "us-gov-west-1", "us-west-1", "us-west-2",
+}
+
+// stsHosts is the set of STS endpoint hosts, from stsRegions.
+var stsHosts = func() map[string]bool {
+	hosts := map[string]bool{"sts.amazonaws.com": true}
+	for _, region := range stsRegions {
+		domain := "amazonaws.com"
+		if strings.HasPrefix(region, "cn-") {
+			domain = "amazonaws.com.cn"
+		}
+		hosts["sts."+region+"."+domain] = true
+	}
+	return hosts
+}()
+
+// sigV4CacheSize bounds the cached identities; past it the least recently
+// used are evicted.
+const sigV4CacheSize = 10000
+
+type sigV4Verifier struct {
+	opts SigV4Options
+	// cache maps presigned URL hashes to caller ARNs until the URL expires.
+	cache *lruCache[string]
+}
+
+// SigV4Auth authenticates requests using the AWS-IAM scheme and passes every
+// other request on to fallback, so it can sit in front of JWT or mTLS auth.
+func SigV4Auth(opts SigV4Options, fallback Authenticator) func(http.HandlerFunc) http.HandlerFunc {
+	return authenticate(sigV4Authenticator(opts, fallback))
+}
+
+// sigV4Authenticator is the Authenticator behind SigV4Auth.
+func sigV4Authenticator(opts SigV4Options, fallback Authenticator) Authenticator {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	v := &sigV4Verifier{opts: opts, cache: newLRUCache[string](sigV4CacheSize)}
+
+	return func(ctx context.Context, c Credentials) (Claims, error) {
+		scheme, token, _ := strings.Cut(c.Authorization, " ")
+		if !strings.EqualFold(scheme, sigV4Scheme) {
+			return fallback(ctx, c)
+		}
+
+		arn, err := v.verify(ctx, token)
+		if err != nil {
+			return Claims{}, unauthenticated("invalid IAM credentials")
+		}
+
+		principal := canonicalPrincipal(arn)
+		if !slices.Contains(opts.AllowedPrincipals, principal) && !slices.Contains(opts.AllowedPrincipals, arn) {
+			return Claims{}, &authError{Status: http.StatusForbidden, Reason: "IAM principal not allowed", Subject: arn}
+		}
+
+		return Claims{
+			Subject: principal,
+			Kind:    PrincipalService,
+			Scopes:  opts.Scopes,
+		}, nil
+	}
+}
+
+// verify replays the presigned GetCallerIdentity request and returns the
+// caller ARN. Results are cached until the presigned URL expires.
+func (v *sigV4Verifier) verify(ctx context.Context, token string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(token, "="))
+	if err != nil {
+		return "", fmt.Errorf("decoding token: %w", err)
+	}
+	u, err := url.Parse(string(raw))
+	if err != nil {
+		return "", fmt.Errorf("parsing presigned URL: %w", err)
+	}
+
+	expires, err := v.validateURL(u)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(raw)
+	key := hex.EncodeToString(sum[:])
+	if arn, ok := v.cache.get(key, time.Now()); ok {
+		return arn, nil
+	}
+
+	arn, err := v.callSTS(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	v.cache.put(key, arn, expires)
+
+	return arn, nil
+}
+
+// validateURL guards against replaying arbitrary URLs: only signed
+// GetCallerIdentity calls to an STS endpoint, still within their validity
+// window, are accepted. It returns when the presigned URL expires.
+func (v *sigV4Verifier) validateURL(u *url.URL) (time.Time, error) {
+	if u.Scheme != "https" || !stsHosts[u.Hostname()] || u.Port() != "" {
+		return time.Time{}, fmt.Errorf("presigned URL is not an STS endpoint")
+	}
+
+	q := u.Query()
+	if q.Get("Action") != "GetCallerIdentity" {
+		return time.Time{}, fmt.Errorf("presigned URL is not GetCallerIdentity")
+	}
+	if q.Get("X-Amz-Algorithm") != "AWS4-HMAC-SHA256" || q.Get("X-Amz-Signature") == "" {
+		return time.Time{}, fmt.Errorf("presigned URL is not SigV4 signed")
+	}
+
+	signed := strings.Split(q.Get("X-Amz-SignedHeaders"), ";")
+	if v.opts.Audience != "" && !slices.Contains(signed, sigV4AudienceHeader) {
+		return time.Time{}, fmt.Errorf("presigned URL does not bind the audience header")
+	}
+
+	date, err := time.Parse("20060102T150405Z", q.Get("X-Amz-Date"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid X-Amz-Date: %w", err)
+	}
+	ttl, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	if err != nil || ttl <= 0 || ttl > 900 {
+		ttl = 900
+	}
+	expires := date.Add(time.Duration(ttl) * time.Second)
+	if time.Now().After(expires) {
+		return time.Time{}, fmt.Errorf("presigned URL expired")
+	}
+
+	return expires, nil
+}
+
+func (v *sigV4Verifier) callSTS(ctx context.Context, u *url.URL) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("building STS request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if v.opts.Audience != "" {
+		req.Header.Set(sigV4AudienceHeader, v.opts.Audience)
+	}
+
+	res, err := v.opts.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("calling STS: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("STS returned %d", res.StatusCode)
+	}
+
+	var body struct {
+		GetCallerIdentityResponse struct {
+			GetCallerIdentityResult struct {
+				Arn string `json:"Arn"`
+			} `json:"GetCallerIdentityResult"`
+		} `json:"GetCallerIdentityResponse"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding STS response: %w", err)
+	}
+
+	arn := body.GetCallerIdentityResponse.GetCallerIdentityResult.Arn
+	if arn == "" {
+		return "", fmt.Errorf("STS response missing ARN")
+	}
+	return arn, nil
+}
+
+// canonicalPrincipal maps an assumed-role session ARN
+// (arn:aws:sts::<acct>:assumed-role/<role>/<session>) to its role ARN
+// (arn:aws:iam::<acct>:role/<role>). Other ARNs are returned unchanged.
+func canonicalPrincipal(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "sts" {
+		return arn
+	}
+	resource := strings.Split(parts[5], "/")
+	if len(resource) != 3 || resource[0] != "assumed-role" {
+		return arn
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", parts[1], parts[4], resource[1])
+}
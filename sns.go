@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// snsAPI is the subset of the SNS client used, for testing.
+type snsAPI interface {
+	Publish(ctx context.Context, in *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// SNSPublisher publishes change events to an SNS topic. The event type and
+// user ID are also sent as message attributes, for subscription filter
+// policies. On FIFO topics each user's events form a message group, so
+// they are delivered in order, deduplicated by event ID.
+type SNSPublisher struct {
+	client   snsAPI
+	topicARN string
+	source   string
+	fifo     bool
+}
+
+// NewSNSPublisher publishes to the topic topicARN, as the CloudEvents source
+// source.
+func NewSNSPublisher(client snsAPI, topicARN, source string) *SNSPublisher {
+	return &SNSPublisher{client: client, topicARN: topicARN, source: source, fifo: strings.HasSuffix(topicARN, ".fifo")}
+}
+
+// NewSNSClient creates an SNS client for the configured region.
+func NewSNSClient(ctx context.Context, cfg Config) (*sns.Client, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.AWSRegion))
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return sns.NewFromConfig(awsCfg), nil
+}
+
+func (p *SNSPublisher) Publish(ctx context.Context, e ChangeEvent) error {
+	body, err := encodeEvent(e, p.source)
+	if err != nil {
+		return err
+	}
+	in := &sns.PublishInput{
+		TopicArn: aws.String(p.topicARN),
+		Message:  aws.String(string(body)),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"eventType": {DataType: aws.String("String"), StringValue: aws.String(e.Type)},
+			"userId":    {DataType: aws.String("String"), StringValue: aws.String(e.UserID)},
+		},
+	}
+	if p.fifo {
+		in.MessageGroupId = aws.String(e.UserID)
+		in.MessageDeduplicationId = aws.String(e.ID)
+	}
+	if _, err := p.client.Publish(ctx, in); err != nil {
+		return fmt.Errorf("Publish (sns): %w", err)
+	}
+	return nil
+}
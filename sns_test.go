@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// fakeSNS records published messages, failing the first failures calls.
+type fakeSNS struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	inputs   []*sns.PublishInput
+}
+
+func (f *fakeSNS) Publish(_ context.Context, in *sns.PublishInput, _ ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errors.New("throttled")
+	}
+	f.inputs = append(f.inputs, in)
+	return &sns.PublishOutput{}, nil
+}
+
+func TestSNSPublisher(t *testing.T) {
+	defer func(d time.Duration) { asyncBackoff = d }(asyncBackoff)
+	asyncBackoff = time.Millisecond
+
+	client := &fakeSNS{failures: 1}
+	sink := NewAsyncSink("sns", NewSNSPublisher(client, "arn:aws:sns:us-east-1:123456789012:prefs.fifo", "prefs-service"), testLogger())
+	store := NewChangePublisher(newMockStore(), nil, testLogger(), sink)
+	ctx := context.Background()
+	store.ReplaceAll(ctx, "user1", map[string]any{"theme": "dark"}, Precondition{})
+	store.Delete(ctx, "user1", "theme")
+	sink.Close(ctx)
+
+	if client.calls != 3 || len(client.inputs) != 2 {
+		t.Fatalf("expected a retry then both events, got %d calls, %d published", client.calls, len(client.inputs))
+	}
+	in := client.inputs[0]
+	var ce CloudEvent
+	if err := json.Unmarshal([]byte(*in.Message), &ce); err != nil || ce.Source != "prefs-service" || ce.Data.UserID != "user1" || ce.Data.Changes["theme"] != "dark" {
+		t.Fatalf("unexpected message %q (%v)", *in.Message, err)
+	}
+	e := ce.Data
+	if *in.MessageAttributes["userId"].StringValue != "user1" || *in.MessageAttributes["eventType"].StringValue != EventPreferencesUpdated {
+		t.Fatalf("unexpected attributes %+v", in.MessageAttributes)
+	}
+	if *in.MessageGroupId != "user1" || *in.MessageDeduplicationId != e.ID {
+		t.Fatal("expected FIFO group and deduplication IDs")
+	}
+
+	// Once closed, events are ignored.
+	sink.PublishChange(ctx, ChangeEvent{ID: "late"})
+}
+
+func TestAsyncSink_GivesUp(t *testing.T) {
+	defer func(d time.Duration) { asyncBackoff = d }(asyncBackoff)
+	asyncBackoff = time.Millisecond
+
+	client := &fakeSNS{failures: asyncMaxAttempts}
+	sink := NewAsyncSink("sns", NewSNSPublisher(client, "arn:aws:sns:us-east-1:123456789012:prefs", "user-prefs"), testLogger())
+	sink.PublishChange(context.Background(), ChangeEvent{ID: "1", UserID: "user1"})
+	sink.PublishChange(context.Background(), ChangeEvent{ID: "2", UserID: "user1"})
+	sink.Close(context.Background())
+
+	if client.calls != asyncMaxAttempts+1 || len(client.inputs) != 1 || client.inputs[0].MessageGroupId != nil {
+		t.Fatalf("expected the first event dropped and the second sent, got %d calls %+v", client.calls, client.inputs)
+	}
+}
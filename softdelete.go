@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// trash copies prefs, the map DeleteAll is about to remove, to the trash,
+// logging any failure. An empty map leaves the trash as it is, so deleting
+// twice does not lose the first copy.
+func (h *PreferencesHandler) trash(ctx context.Context, userID string, prefs map[string]any) error {
+	if len(prefs) == 0 {
+		return nil
+	}
+	if _, err := h.opts.Trash.ReplaceAll(ctx, userID, prefs, Precondition{}); err != nil {
+		h.logger.Error("moving preferences to trash failed", "error", err, "userId", userID)
+		return err
+	}
+	return nil
+}
+
+// RestoreDeleted brings back the map removed by the user's last DeleteAll,
+// within TrashRetention. It is 404 when there is nothing to restore and 409
+// when preferences have been set since, rather than overwrite them.
+func (h *PreferencesHandler) RestoreDeleted(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	deleted, err := h.opts.Trash.GetAll(ctx, userID)
+	if err != nil {
+		h.logger.Error("reading trash failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to restore preferences")
+		return
+	}
+	// DynamoDB removes expired items lazily, so the window is checked here.
+	if len(deleted.Prefs) == 0 || time.Since(deleted.UpdatedAt) > h.opts.TrashRetention {
+		writeErrorCode(w, http.StatusNotFound, ErrCodeNothingToRestore, "no deleted preferences to restore")
+		return
+	}
+
+	current, err := h.store.GetAll(ctx, userID)
+	if err != nil {
+		h.logger.Error("store.GetAll failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to restore preferences")
+		return
+	}
+	if len(current.Prefs) > 0 {
+		writeError(w, http.StatusConflict, "preferences have been set since they were deleted")
+		return
+	}
+	// Without a record, a first write made since the check must not be
+	// overwritten.
+	cond := Precondition{MustNotExist: true}
+	if current.Prefs != nil {
+		cond = Precondition{Versions: []int64{current.Version}, Generation: current.Generation}
+	}
+
+	rec, err := h.store.ReplaceAll(ctx, userID, deleted.Prefs, cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, http.StatusConflict, "preferences have been set since they were deleted")
+		return
+	}
+	if err != nil {
+		h.logger.Error("store.ReplaceAll failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to restore preferences")
+		return
+	}
+	if err := h.opts.Trash.DeleteAll(ctx, userID); err != nil {
+		// The restore stands; a later restore would find the map unchanged.
+		h.logger.Warn("emptying trash failed", "error", err, "userId", userID)
+	}
+
+	h.logger.Info("preferences restored", "userId", userID, "keys", len(rec.Prefs))
+	setValidators(w, rec)
+	writeJSON(w, http.StatusOK, newPreferencesResponse(userID, rec.Prefs, rec))
+}
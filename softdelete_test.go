@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSoftDelete(t *testing.T) {
+	store, trash := newMockStore(), newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark", "lang": "en"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Trash: trash, TrashRetention: time.Hour})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", h.ReplaceAll)
+	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences", h.DeleteAll)
+	mux.HandleFunc("POST /api/v1/users/{userId}/preferences:restore", h.RestoreDeleted)
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/users/user1/preferences"+path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+
+	if w := send("DELETE", "", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if _, ok := store.prefs["user1"]; ok || trash.prefs["user1"]["theme"] != "dark" {
+		t.Fatalf("expected the map moved to the trash, got %v %v", store.prefs, trash.prefs)
+	}
+	// Deleting again keeps the first copy.
+	send("DELETE", "", "")
+	if len(trash.prefs["user1"]) != 2 {
+		t.Fatalf("expected the trash kept, got %v", trash.prefs["user1"])
+	}
+
+	w := send("POST", ":restore", "")
+	var resp PreferencesResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Preferences["lang"] != "en" || w.Header().Get("ETag") == "" {
+		t.Fatalf("expected the map restored, got %d %+v", w.Code, resp)
+	}
+	if _, ok := trash.prefs["user1"]; ok {
+		t.Fatal("expected the trash emptied")
+	}
+	if w := send("POST", ":restore", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with nothing to restore, got %d", w.Code)
+	}
+
+	// Preferences set since the delete are not overwritten.
+	send("DELETE", "", "")
+	send("PUT", "", `{"theme":"light"}`)
+	if w := send("POST", ":restore", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+
+	// Past the retention window the map is gone.
+	send("DELETE", "", "")
+	trash.updated["user1"] = time.Now().Add(-2 * time.Hour)
+	var apiErr APIError
+	w = send("POST", ":restore", "")
+	json.NewDecoder(w.Body).Decode(&apiErr)
+	if w.Code != http.StatusNotFound || apiErr.ErrorCode != ErrCodeNothingToRestore {
+		t.Fatalf("expected 404 past retention, got %d %+v", w.Code, apiErr)
+	}
+}
+
+// getAllRacingStore writes a record for a user right after GetAll finds
+// none, as a first write landing between the check and the write would.
+type getAllRacingStore struct {
+	*mockStore
+	raced bool
+}
+
+func (s *getAllRacingStore) GetAll(ctx context.Context, userID string) (Record, error) {
+	rec, err := s.mockStore.GetAll(ctx, userID)
+	if rec.Prefs == nil && err == nil && !s.raced {
+		s.raced = true
+		s.mockStore.ReplaceAll(ctx, userID, map[string]any{"lang": "fr"}, Precondition{})
+	}
+	return rec, err
+}
+
+func TestSoftDelete_RestoreRacesFirstWrite(t *testing.T) {
+	store, trash := &getAllRacingStore{mockStore: newMockStore()}, newMockStore()
+	trash.ReplaceAll(context.Background(), "user1", map[string]any{"theme": "dark"}, Precondition{})
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Trash: trash, TrashRetention: time.Hour})
+
+	r := withClaims(httptest.NewRequest("POST", "/api/v1/users/user1/preferences:restore", nil), "user1")
+	r.SetPathValue("userId", "user1")
+	w := httptest.NewRecorder()
+	h.RestoreDeleted(w, r)
+	if w.Code != http.StatusConflict || !store.raced {
+		t.Fatalf("expected 409 when a first write lands during restore, got %d", w.Code)
+	}
+	if got := store.prefs["user1"]; got["lang"] != "fr" || got["theme"] != nil {
+		t.Fatalf("expected the first write kept, got %v", got)
+	}
+}
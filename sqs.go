@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// sqsMaxBatch is the most messages SendMessageBatch takes.
+const sqsMaxBatch = 10
+
+// sqsAPI is the subset of the SQS client used, for testing.
+type sqsAPI interface {
+	SendMessageBatch(ctx context.Context, in *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+// SQSPublisher enqueues change events on an SQS queue, up to ten per
+// request. The event type and user ID are also sent as message attributes.
+// On FIFO queues each user's events form a message group, deduplicated by
+// event ID. Events that cannot be enqueued go to the dead-letter queue, if
+// one is configured, with the failure in a deadLetterReason attribute.
+type SQSPublisher struct {
+	client   sqsAPI
+	queueURL string
+	dlqURL   string
+	source   string
+}
+
+// NewSQSPublisher enqueues on queueURL, as the CloudEvents source source,
+// dead-lettering on dlqURL unless it is empty.
+func NewSQSPublisher(client sqsAPI, queueURL, dlqURL, source string) *SQSPublisher {
+	return &SQSPublisher{client: client, queueURL: queueURL, dlqURL: dlqURL, source: source}
+}
+
+// NewSQSClient creates an SQS client for the configured region.
+func NewSQSClient(ctx context.Context, cfg Config) (*sqs.Client, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.AWSRegion))
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return sqs.NewFromConfig(awsCfg), nil
+}
+
+func (p *SQSPublisher) MaxBatch() int { return sqsMaxBatch }
+
+func (p *SQSPublisher) Publish(ctx context.Context, e ChangeEvent) error {
+	_, err := p.PublishBatch(ctx, []ChangeEvent{e})
+	return err
+}
+
+func (p *SQSPublisher) PublishBatch(ctx context.Context, events []ChangeEvent) ([]ChangeEvent, error) {
+	return p.send(ctx, p.queueURL, events, nil)
+}
+
+// DeadLetter enqueues events on the dead-letter queue. Without one it
+// fails, so AsyncSink drops them.
+func (p *SQSPublisher) DeadLetter(ctx context.Context, events []ChangeEvent, cause error) error {
+	if p.dlqURL == "" {
+		return fmt.Errorf("no dead-letter queue configured")
+	}
+	reason := map[string]types.MessageAttributeValue{
+		"deadLetterReason": {DataType: aws.String("String"), StringValue: aws.String(cause.Error())},
+	}
+	_, err := p.send(ctx, p.dlqURL, events, reason)
+	return err
+}
+
+// send enqueues events on queueURL in one request, returning those not
+// enqueued.
+func (p *SQSPublisher) send(ctx context.Context, queueURL string, events []ChangeEvent, attrs map[string]types.MessageAttributeValue) ([]ChangeEvent, error) {
+	fifo := strings.HasSuffix(queueURL, ".fifo")
+	entries := make([]types.SendMessageBatchRequestEntry, len(events))
+	for i, e := range events {
+		body, err := encodeEvent(e, p.source)
+		if err != nil {
+			return events, err
+		}
+		entry := types.SendMessageBatchRequestEntry{
+			Id:          aws.String(strconv.Itoa(i)),
+			MessageBody: aws.String(string(body)),
+			MessageAttributes: map[string]types.MessageAttributeValue{
+				"eventType": {DataType: aws.String("String"), StringValue: aws.String(e.Type)},
+				"userId":    {DataType: aws.String("String"), StringValue: aws.String(e.UserID)},
+			},
+		}
+		maps.Copy(entry.MessageAttributes, attrs)
+		if fifo {
+			entry.MessageGroupId = aws.String(e.UserID)
+			entry.MessageDeduplicationId = aws.String(e.ID)
+		}
+		entries[i] = entry
+	}
+	out, err := p.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{QueueUrl: aws.String(queueURL), Entries: entries})
+	if err != nil {
+		return events, fmt.Errorf("SendMessageBatch: %w", err)
+	}
+	if len(out.Failed) == 0 {
+		return nil, nil
+	}
+	// Retried in their original order.
+	ids := make(map[string]bool, len(out.Failed))
+	for _, f := range out.Failed {
+		ids[aws.ToString(f.Id)] = true
+	}
+	var failed []ChangeEvent
+	for i, e := range events {
+		if ids[strconv.Itoa(i)] {
+			failed = append(failed, e)
+		}
+	}
+	f := out.Failed[0]
+	return failed, fmt.Errorf("SendMessageBatch: %d of %d failed, e.g. %s: %s", len(out.Failed), len(events), aws.ToString(f.Code), aws.ToString(f.Message))
+}
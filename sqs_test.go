@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fakeSQS records enqueued message bodies per queue. It fails the entry
+// with ID failID once, and everything sent to a queue in down.
+type fakeSQS struct {
+	mu     sync.Mutex
+	failID string
+	down   map[string]bool
+	calls  int
+	queued map[string][]types.SendMessageBatchRequestEntry
+}
+
+func (f *fakeSQS) SendMessageBatch(_ context.Context, in *sqs.SendMessageBatchInput, _ ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.down[*in.QueueUrl] {
+		return nil, errors.New("unavailable")
+	}
+	out := &sqs.SendMessageBatchOutput{}
+	for _, entry := range in.Entries {
+		if *entry.Id == f.failID {
+			f.failID = ""
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError")})
+			continue
+		}
+		f.queued[*in.QueueUrl] = append(f.queued[*in.QueueUrl], entry)
+	}
+	return out, nil
+}
+
+func queuedUsers(t *testing.T, entries []types.SendMessageBatchRequestEntry) []string {
+	t.Helper()
+	var users []string
+	for _, entry := range entries {
+		var ce CloudEvent
+		if err := json.Unmarshal([]byte(*entry.MessageBody), &ce); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, ce.Data.UserID)
+	}
+	return users
+}
+
+func TestSQSPublisher(t *testing.T) {
+	defer func(d time.Duration) { asyncBackoff = d }(asyncBackoff)
+	asyncBackoff = time.Millisecond
+
+	client := &fakeSQS{failID: "1", queued: map[string][]types.SendMessageBatchRequestEntry{}}
+	pub := NewSQSPublisher(client, "https://sqs.us-east-1.amazonaws.com/123456789012/prefs.fifo", "", "user-prefs")
+	sink := NewAsyncSink("sqs", pub, testLogger())
+	// Only the entries that failed are returned, for AsyncSink to retry.
+	failed, err := pub.PublishBatch(context.Background(), []ChangeEvent{{ID: "a", UserID: "user1"}, {ID: "b", UserID: "user2"}, {ID: "c", UserID: "user3"}})
+	if err == nil || len(failed) != 1 || failed[0].ID != "b" {
+		t.Fatalf("expected the second entry to fail, got %+v (%v)", failed, err)
+	}
+	sink.PublishChange(context.Background(), failed[0])
+	sink.Close(context.Background())
+
+	entries := client.queued[pub.queueURL]
+	if users := queuedUsers(t, entries); len(users) != 3 || users[2] != "user2" {
+		t.Fatalf("expected the failed entry enqueued on retry, got %v", users)
+	}
+	if *entries[0].MessageGroupId != "user1" || *entries[0].MessageDeduplicationId != "a" || *entries[0].MessageAttributes["userId"].StringValue != "user1" {
+		t.Fatalf("unexpected FIFO entry %+v", entries[0])
+	}
+}
+
+func TestSQSPublisher_DeadLetter(t *testing.T) {
+	defer func(d time.Duration) { asyncBackoff = d }(asyncBackoff)
+	asyncBackoff = time.Millisecond
+
+	const queue, dlq = "https://sqs/prefs", "https://sqs/prefs-dlq"
+	client := &fakeSQS{down: map[string]bool{queue: true}, queued: map[string][]types.SendMessageBatchRequestEntry{}}
+	sink := NewAsyncSink("sqs", NewSQSPublisher(client, queue, dlq, "user-prefs"), testLogger())
+	store := NewChangePublisher(newMockStore(), nil, testLogger(), sink)
+	for _, user := range []string{"user1", "user2", "user3"} {
+		store.ReplaceAll(context.Background(), user, map[string]any{"theme": "dark"}, Precondition{})
+	}
+	sink.Close(context.Background())
+
+	entries := client.queued[dlq]
+	if users := queuedUsers(t, entries); len(users) != 3 || users[0] != "user1" {
+		t.Fatalf("expected every event dead-lettered in order, got %v", users)
+	}
+	if reason := entries[0].MessageAttributes["deadLetterReason"].StringValue; reason == nil || *reason == "" {
+		t.Fatal("expected the failure recorded on the dead letter")
+	}
+	if client.calls >= 3*asyncMaxAttempts {
+		t.Fatalf("expected queued events to be sent in batches, got %d calls", client.calls)
+	}
+}
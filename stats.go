@@ -0,0 +1,99 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PreferenceStats aggregates the stored preferences of all users, for
+// capacity planning. Sizes are estimated as for the quota (see itemSize).
+type PreferenceStats struct {
+	Users          int        `json:"users"`
+	Keys           int        `json:"keys"`
+	AvgKeysPerUser float64    `json:"avgKeysPerUser"`
+	TotalBytes     int        `json:"totalBytes"`
+	Largest        []ItemSize `json:"largest"`
+	ComputedAt     time.Time  `json:"computedAt"`
+}
+
+// ItemSize describes one user's stored item.
+type ItemSize struct {
+	UserID string `json:"userId"`
+	Keys   int    `json:"keys"`
+	Bytes  int    `json:"bytes"`
+}
+
+// largestItems is how many of the largest items stats report.
+const largestItems = 10
+
+// add counts one user's preferences; users without any are skipped.
+func (s *PreferenceStats) add(userID string, prefs map[string]any) {
+	if len(prefs) == 0 {
+		return
+	}
+	size := ItemSize{UserID: userID, Keys: len(prefs), Bytes: itemSize(prefs)}
+	s.Users++
+	s.Keys += size.Keys
+	s.TotalBytes += size.Bytes
+	s.AvgKeysPerUser = float64(s.Keys) / float64(s.Users)
+
+	i, _ := slices.BinarySearchFunc(s.Largest, size, func(a, b ItemSize) int {
+		return cmp.Compare(b.Bytes, a.Bytes)
+	})
+	if i < largestItems {
+		s.Largest = slices.Insert(s.Largest, i, size)
+		s.Largest = s.Largest[:min(len(s.Largest), largestItems)]
+	}
+}
+
+// StatsSource computes preference statistics over every user.
+type StatsSource interface {
+	PreferenceStats(ctx context.Context) (PreferenceStats, error)
+}
+
+// statsCacheTTL is how long computed statistics are served before the
+// table is scanned again.
+const statsCacheTTL = 5 * time.Minute
+
+// StatsHandler serves aggregate preference statistics. Computing them scans
+// the table, so results are cached for statsCacheTTL and concurrent requests
+// share one computation.
+type StatsHandler struct {
+	source StatsSource
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	cached PreferenceStats
+}
+
+// NewStatsHandler creates a stats handler over source.
+func NewStatsHandler(source StatsSource, logger *slog.Logger) *StatsHandler {
+	return &StatsHandler{source: source, logger: logger}
+}
+
+// Get returns the statistics, recomputing them when the cache is stale or
+// ?refresh=true is given.
+func (h *StatsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.cached.ComputedAt.IsZero() || time.Since(h.cached.ComputedAt) > statsCacheTTL || r.URL.Query().Get("refresh") == "true" {
+		stats, err := h.source.PreferenceStats(r.Context())
+		if err != nil {
+			h.logger.Error("PreferenceStats failed", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to compute stats")
+			return
+		}
+		if stats.Largest == nil {
+			stats.Largest = []ItemSize{}
+		}
+		stats.ComputedAt = time.Now().UTC()
+		h.cached = stats
+	}
+	writeJSON(w, http.StatusOK, h.cached)
+}
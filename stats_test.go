@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPreferenceStats_Add(t *testing.T) {
+	var s PreferenceStats
+	for i := range 15 {
+		prefs := map[string]any{"k": strings.Repeat("x", i)}
+		if i%2 == 0 {
+			prefs["extra"] = true
+		}
+		s.add(fmt.Sprintf("user%d", i), prefs)
+	}
+	s.add("empty", map[string]any{})
+
+	if s.Users != 15 || s.Keys != 23 {
+		t.Fatalf("expected 15 users and 23 keys, got %+v", s)
+	}
+	if s.AvgKeysPerUser < 1.53 || s.AvgKeysPerUser > 1.54 {
+		t.Fatalf("unexpected average %v", s.AvgKeysPerUser)
+	}
+	if len(s.Largest) != largestItems || s.Largest[0].UserID != "user14" {
+		t.Fatalf("expected the %d largest, biggest first, got %+v", largestItems, s.Largest)
+	}
+	for i := 1; i < len(s.Largest); i++ {
+		if s.Largest[i].Bytes > s.Largest[i-1].Bytes {
+			t.Fatalf("expected descending sizes, got %+v", s.Largest)
+		}
+	}
+}
+
+// countingStats implements StatsSource for testing.
+type countingStats struct{ calls int }
+
+func (c *countingStats) PreferenceStats(context.Context) (PreferenceStats, error) {
+	c.calls++
+	var s PreferenceStats
+	s.add("user1", map[string]any{"theme": "dark"})
+	return s, nil
+}
+
+func TestStatsHandler(t *testing.T) {
+	source := &countingStats{}
+	h := NewStatsHandler(source, testLogger())
+	get := func(query string) PreferenceStats {
+		w := httptest.NewRecorder()
+		h.Get(w, httptest.NewRequest("GET", "/api/v1/admin/stats"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		var s PreferenceStats
+		json.NewDecoder(w.Body).Decode(&s)
+		return s
+	}
+
+	if s := get(""); s.Users != 1 || len(s.Largest) != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	get("")
+	if source.calls != 1 {
+		t.Fatalf("expected the cached stats to be reused, got %d computations", source.calls)
+	}
+	get("?refresh=true")
+	if source.calls != 2 {
+		t.Fatalf("expected refresh to recompute, got %d computations", source.calls)
+	}
+}
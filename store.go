@@ -1,13 +1,100 @@
 package main
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
-// Store defines the persistence interface for user preferences.
+// Sentinel errors returned by stores.
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Record is a user's stored preferences. Values are arbitrary JSON (see
+// values.go). Version increases with every write and is zero for items
+// written before versioning. Prefs is nil when the user has no stored
+// preferences.
+type Record struct {
+	Prefs   map[string]any
+	Version int64
+	// Generation is a random token set when the item is created. Deleting
+	// the item resets the version, so the generation tells a recreated item
+	// from the one before it. Empty for items not written since
+	// generations were introduced.
+	Generation string
+	CreatedAt  time.Time // zero if unknown
+	UpdatedAt  time.Time // zero if unknown
+	// Meta holds per-key write metadata. Keys last written before metadata
+	// was recorded have no entry.
+	Meta map[string]KeyMeta
+	// Created is set on the record a ReplaceAll or Update returns when the
+	// write created the user's item.
+	Created bool
+}
+
+// KeyMeta records the last write to one key.
+type KeyMeta struct {
+	UpdatedAt time.Time
+	UpdatedBy string // principal from the request context; "" if unknown
+}
+
+// writerFrom returns the principal a write is attributed to: the actor of a
+// delegated token, otherwise its subject.
+func writerFrom(ctx context.Context) string {
+	c, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if c.Actor != "" {
+		return c.Actor
+	}
+	return c.Subject
+}
+
+// Precondition makes a write conditional on the stored version. The zero
+// value is unconditional.
+type Precondition struct {
+	// Versions, if non-empty, requires the stored version to be one of
+	// these; a write to a user with no record fails.
+	Versions []int64
+	// Generation, if set along with Versions, also requires the stored
+	// item to be of this generation, so a version read before the item was
+	// deleted and recreated does not match.
+	Generation string
+	// MustExist requires the user to have a record.
+	MustExist bool
+	// MustNotExist requires the user to have no record.
+	MustNotExist bool
+	// Absent lists keys that must not be set; the write fails with
+	// ErrConflict if one is.
+	Absent []string
+}
+
+// Store defines the persistence interface for user preferences. Conditional
+// writes return ErrPreconditionFailed when their precondition does not hold.
+// Update merges prefs into the stored map and deletes the keys in remove in
+// the same write.
 type Store interface {
-	GetAll(ctx context.Context, userID string) (map[string]string, error)
-	Get(ctx context.Context, userID string, key string) (value string, found bool, err error)
-	ReplaceAll(ctx context.Context, userID string, prefs map[string]string) error
-	Update(ctx context.Context, userID string, prefs map[string]string) (merged map[string]string, err error)
+	GetAll(ctx context.Context, userID string) (Record, error)
+	// Get reads one key by projection. rec carries just the version,
+	// generation and updatedAt of the user's record, for validators; its
+	// Prefs and Meta are nil.
+	Get(ctx context.Context, userID string, key string) (value any, found bool, rec Record, err error)
+	// GetKeys is GetAll restricted to the given keys; absent keys are
+	// omitted.
+	GetKeys(ctx context.Context, userID string, keys []string) (Record, error)
+	// BatchGet reads several users at once, keyed by userID; users with no
+	// stored preferences are omitted. userIDs must not repeat.
+	BatchGet(ctx context.Context, userIDs []string) (map[string]Record, error)
+	// Stat reads a user's version and timestamps without their values. rec
+	// has empty, non-nil Prefs when the user has a record. found reports
+	// whether key is set, or with key "", whether the record exists.
+	Stat(ctx context.Context, userID string, key string) (rec Record, found bool, err error)
+	ReplaceAll(ctx context.Context, userID string, prefs map[string]any, cond Precondition) (Record, error)
+	Update(ctx context.Context, userID string, prefs map[string]any, remove []string, cond Precondition) (merged Record, err error)
 	DeleteAll(ctx context.Context, userID string) error
 	Delete(ctx context.Context, userID string, key string) error
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxStreamsPerUser caps the open change streams of one user, and
+// streamBuffer is how many events a stream may fall behind before it is
+// closed.
+const (
+	maxStreamsPerUser = 16
+	streamBuffer      = 32
+)
+
+// streamHeartbeat is how often an idle stream sends a comment, so proxies
+// do not time the connection out.
+var streamHeartbeat = 30 * time.Second
+
+// ChangeBus is the in-process change notification bus: a ChangeSink that
+// hands each event to the subscribers for the event's user. It only sees
+// writes served by this instance.
+type ChangeBus struct {
+	mu     sync.Mutex
+	subs   map[string]map[*changeSub]struct{}
+	closed bool
+}
+
+type changeSub struct {
+	ch chan ChangeEvent
+}
+
+// NewChangeBus creates a bus with no subscribers.
+func NewChangeBus() *ChangeBus {
+	return &ChangeBus{subs: make(map[string]map[*changeSub]struct{})}
+}
+
+// Subscribe registers for the user's events. The channel is closed when the
+// subscriber falls more than streamBuffer events behind or the bus is
+// closed; cancel unsubscribes. It reports false when the user already has
+// maxStreamsPerUser subscriptions or the bus is closed.
+func (b *ChangeBus) Subscribe(userID string) (events <-chan ChangeEvent, cancel func(), ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || len(b.subs[userID]) >= maxStreamsPerUser {
+		return nil, nil, false
+	}
+	sub := &changeSub{ch: make(chan ChangeEvent, streamBuffer)}
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[*changeSub]struct{})
+	}
+	b.subs[userID][sub] = struct{}{}
+	return sub.ch, func() { b.remove(userID, sub) }, true
+}
+
+// remove drops a subscriber, closing its channel unless that was done.
+func (b *ChangeBus) remove(userID string, sub *changeSub) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[userID][sub]; !ok {
+		return
+	}
+	delete(b.subs[userID], sub)
+	if len(b.subs[userID]) == 0 {
+		delete(b.subs, userID)
+	}
+	close(sub.ch)
+}
+
+// Close ends every subscription and refuses new ones, so open streams
+// finish during shutdown.
+func (b *ChangeBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for userID, subs := range b.subs {
+		for sub := range subs {
+			close(sub.ch)
+		}
+		delete(b.subs, userID)
+	}
+}
+
+// Listening reports whether anyone is subscribed.
+func (b *ChangeBus) Listening() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs) > 0
+}
+
+// PublishChange hands e to the user's subscribers without blocking. A
+// subscriber whose buffer is full is dropped: its client has missed events
+// and must reconnect and re-read.
+func (b *ChangeBus) PublishChange(_ context.Context, e ChangeEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs[e.UserID] {
+		select {
+		case sub.ch <- e:
+		default:
+			delete(b.subs[e.UserID], sub)
+			close(sub.ch)
+		}
+	}
+	if len(b.subs[e.UserID]) == 0 {
+		delete(b.subs, e.UserID)
+	}
+}
+
+// StreamHandler serves a user's change events as Server-Sent Events.
+type StreamHandler struct {
+	prefs *PreferencesHandler
+	bus   *ChangeBus
+}
+
+// NewStreamHandler creates a stream handler sharing prefs' auth and logger.
+func NewStreamHandler(prefs *PreferencesHandler, bus *ChangeBus) *StreamHandler {
+	return &StreamHandler{prefs: prefs, bus: bus}
+}
+
+// Stream sends an SSE event for each change to the user's preferences,
+// named by the event type, with the event ID as id and the ChangeEvent as
+// data. ?keys= limits it to keys or namespaces ending in ".". Events are
+// not replayed: a client reconnecting (or whose stream was closed for
+// falling behind) should re-read the preferences.
+func (h *StreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseKeyFilter(splitList(r.URL.Query().Get("keys")))
+	if err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeKeyInvalid, err.Error())
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// Streams outlive the server's read and write timeouts (an expired
+	// read deadline would cancel the request). Writers that cannot clear
+	// them have none.
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	events, cancel, ok := h.bus.Subscribe(userID)
+	if !ok {
+		writeError(w, http.StatusTooManyRequests, fmt.Sprintf("at most %d streams may be open per user", maxStreamsPerUser))
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		h.prefs.logger.Error("stream flush failed", "error", err, "userId", userID)
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e, open := <-events:
+			if !open {
+				return
+			}
+			if e.Changes = filterChanges(filter, e.Changes); len(e.Changes) == 0 {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
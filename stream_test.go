@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamHandler(t *testing.T) {
+	bus := NewChangeBus()
+	store := NewChangePublisher(newMockStore(), nil, testLogger(), bus)
+	h := NewStreamHandler(NewPreferencesHandler(store, testLogger(), HandlerOptions{}), bus)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("userId", "user1")
+		h.Stream(w, withClaims(r, r.URL.Query().Get("sub")))
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?sub=user1&keys=notifications.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	if line := <-lines; line != ": connected" {
+		t.Fatalf("expected the connected comment, got %q", line)
+	}
+
+	ctx := context.Background()
+	store.Update(ctx, "user1", map[string]any{"theme": "dark"}, nil, Precondition{})
+	store.Update(ctx, "user2", map[string]any{"notifications.email": true}, nil, Precondition{})
+	store.Update(ctx, "user1", map[string]any{"notifications.email": false}, nil, Precondition{})
+
+	var event, data string
+	timeout := time.After(5 * time.Second)
+	for data == "" {
+		select {
+		case line := <-lines:
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				event = v
+			}
+			if v, ok := strings.CutPrefix(line, "data: "); ok {
+				data = v
+			}
+		case <-timeout:
+			t.Fatal("expected an event")
+		}
+	}
+	var e ChangeEvent
+	json.Unmarshal([]byte(data), &e)
+	if event != EventPreferencesUpdated || e.UserID != "user1" || len(e.Changes) != 1 || e.Changes["notifications.email"] != false {
+		t.Fatalf("expected only the user's filtered change, got %s %+v", event, e)
+	}
+
+	// Another user's stream is refused.
+	if resp, err := http.Get(srv.URL + "?sub=user2"); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for another user, got %v %v", resp.StatusCode, err)
+	}
+
+	bus.Close()
+	for range lines {
+	}
+	if bus.Listening() {
+		t.Fatal("expected no subscribers after Close")
+	}
+}
+
+func TestChangeBus_DropsSlowSubscribers(t *testing.T) {
+	bus := NewChangeBus()
+	events, cancel, ok := bus.Subscribe("user1")
+	if !ok {
+		t.Fatal("expected to subscribe")
+	}
+	defer cancel()
+	for i := 0; i <= streamBuffer; i++ {
+		bus.PublishChange(context.Background(), ChangeEvent{UserID: "user1"})
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != streamBuffer || bus.Listening() {
+		t.Fatalf("expected the subscriber dropped after %d events, got %d", streamBuffer, n)
+	}
+
+	for range maxStreamsPerUser {
+		bus.Subscribe("user2")
+	}
+	if _, _, ok := bus.Subscribe("user2"); ok {
+		t.Fatal("expected the per-user stream limit")
+	}
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// Sources of change events selectable via CHANGE_EVENTS.
+const (
+	ChangeEventsAPI    = "api"
+	ChangeEventsStream = "stream"
+)
+
+// Where the stream worker starts reading shards it has no checkpoint for,
+// selectable via STREAM_START.
+const (
+	StreamStartLatest      = "latest"
+	StreamStartTrimHorizon = "trim_horizon"
+)
+
+// streamRecordsLimit is the most records read per GetRecords call.
+const streamRecordsLimit = 1000
+
+// Stream worker timing: shards are listed every streamShardRefresh, an
+// idle shard is polled every streamPollInterval, and a checkpoint is saved
+// at least every streamCheckpointInterval while records are read.
+var (
+	streamShardRefresh       = 10 * time.Second
+	streamPollInterval       = time.Second
+	streamCheckpointInterval = time.Minute
+)
+
+// streamsAPI is the subset of the DynamoDB Streams client used, for
+// testing.
+type streamsAPI interface {
+	DescribeStream(ctx context.Context, in *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, in *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, in *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+}
+
+// ShardCheckpoint is how far the stream worker has read a shard: up to and
+// including Sequence, or all of it when Done.
+type ShardCheckpoint struct {
+	Sequence string
+	Done     bool
+}
+
+// StreamCheckpoints persists shard checkpoints, so a restarted worker
+// resumes where it stopped.
+type StreamCheckpoints interface {
+	GetCheckpoint(ctx context.Context, shardID string) (ShardCheckpoint, bool, error)
+	PutCheckpoint(ctx context.Context, shardID string, cp ShardCheckpoint) error
+}
+
+// StreamWorker turns the table's DynamoDB Stream into change events for
+// its sinks, so writes that bypass the API (scripts, other services,
+// console edits) are published too. It reads every shard, children only
+// after their parents so each user's events stay in order, and saves
+// checkpoints as it goes. Delivery is at least once: events since the last
+// checkpoint are published again after a restart, with the same IDs.
+// Run one worker per stream; shards are not leased. With a replica, each
+// record is also applied to the standby before the checkpoint passes it.
+type StreamWorker struct {
+	streams     streamsAPI
+	streamARN   string
+	checkpoints StreamCheckpoints
+	replica     *StandbyReplicator
+	sinks       []ChangeSink
+	exclude     []string
+	start       string
+	logger      *slog.Logger
+
+	mu      sync.Mutex
+	started map[string]bool
+	done    map[string]bool
+}
+
+// NewStreamWorker reads the stream streamARN, publishing to sinks without
+// the values of the exclude (sensitive) keys. start is StreamStartLatest or
+// StreamStartTrimHorizon. replica, if not nil, replicates the changes to
+// the standby table.
+func NewStreamWorker(streams streamsAPI, streamARN string, checkpoints StreamCheckpoints, replica *StandbyReplicator, exclude []string, start string, logger *slog.Logger, sinks ...ChangeSink) *StreamWorker {
+	return &StreamWorker{
+		streams:     streams,
+		streamARN:   streamARN,
+		checkpoints: checkpoints,
+		replica:     replica,
+		sinks:       sinks,
+		exclude:     exclude,
+		start:       start,
+		logger:      logger,
+		started:     make(map[string]bool),
+		done:        make(map[string]bool),
+	}
+}
+
+// Run reads the stream until ctx is cancelled, then saves checkpoints and
+// returns.
+func (w *StreamWorker) Run(ctx context.Context) error {
+	if err := w.checkStream(ctx); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	initial := true
+	ticker := time.NewTicker(streamShardRefresh)
+	defer ticker.Stop()
+	for {
+		shards, err := w.listShards(ctx)
+		if err != nil && ctx.Err() == nil {
+			w.logger.Error("listing stream shards failed", "error", err)
+		}
+		for _, id := range w.ready(shards) {
+			wg.Add(1)
+			go func(initial bool) {
+				defer wg.Done()
+				w.readShard(ctx, id, initial)
+			}(initial)
+		}
+		if err == nil {
+			initial = false
+		}
+		if w.replica != nil {
+			if err := w.replica.Report(ctx); err != nil && ctx.Err() == nil {
+				w.logger.Error("saving standby replication status failed", "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkStream fails unless records carry both images.
+func (w *StreamWorker) checkStream(ctx context.Context) error {
+	out, err := w.streams.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{StreamArn: &w.streamARN, Limit: aws.Int32(1)})
+	if err != nil {
+		return fmt.Errorf("DescribeStream: %w", err)
+	}
+	if view := out.StreamDescription.StreamViewType; view != types.StreamViewTypeNewAndOldImages {
+		return fmt.Errorf("stream view type is %s; NEW_AND_OLD_IMAGES is required", view)
+	}
+	return nil
+}
+
+// listShards returns all of the stream's shards.
+func (w *StreamWorker) listShards(ctx context.Context) ([]types.Shard, error) {
+	var shards []types.Shard
+	in := &dynamodbstreams.DescribeStreamInput{StreamArn: &w.streamARN}
+	for {
+		out, err := w.streams.DescribeStream(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("DescribeStream: %w", err)
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+		if out.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		in.ExclusiveStartShardId = out.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// ready marks and returns the shards to start reading: those not started
+// whose parent is done or no longer in the stream.
+func (w *StreamWorker) ready(shards []types.Shard) []string {
+	listed := make(map[string]bool, len(shards))
+	for _, sh := range shards {
+		listed[aws.ToString(sh.ShardId)] = true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var ids []string
+	for _, sh := range shards {
+		id, parent := aws.ToString(sh.ShardId), aws.ToString(sh.ParentShardId)
+		if w.started[id] || (listed[parent] && !w.done[parent]) {
+			continue
+		}
+		w.started[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (w *StreamWorker) markDone(shardID string) {
+	w.mu.Lock()
+	w.done[shardID] = true
+	w.mu.Unlock()
+	if w.replica != nil {
+		w.replica.ShardDone(shardID)
+	}
+}
+
+// readShard publishes the shard's records until it ends or ctx is
+// cancelled. Shards without a checkpoint start at the configured position
+// when listed at startup, and at their beginning when created since.
+func (w *StreamWorker) readShard(ctx context.Context, shardID string, initial bool) {
+	logger := w.logger.With("shardId", shardID)
+	var cp ShardCheckpoint
+	for {
+		var err error
+		if cp, _, err = w.checkpoints.GetCheckpoint(ctx, shardID); err == nil {
+			break
+		}
+		logger.Error("reading shard checkpoint failed", "error", err)
+		if !sleepCtx(ctx, streamPollInterval) {
+			return
+		}
+	}
+	if cp.Done {
+		w.markDone(shardID)
+		return
+	}
+	position := types.ShardIteratorTypeTrimHorizon
+	if initial && w.start == StreamStartLatest {
+		position = types.ShardIteratorTypeLatest
+		if w.replica != nil && cp.Sequence == "" {
+			w.diverge(ctx, "replication started at the latest stream position")
+		}
+	}
+
+	saved, lastSave := cp, time.Now()
+	save := func(cp ShardCheckpoint) {
+		if cp == saved {
+			return
+		}
+		// Saved even while shutting down.
+		sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := w.checkpoints.PutCheckpoint(sctx, shardID, cp); err != nil {
+			logger.Error("saving shard checkpoint failed", "error", err)
+			return
+		}
+		saved, lastSave = cp, time.Now()
+	}
+	defer func() { save(cp) }()
+
+	var iter *string
+	for ctx.Err() == nil {
+		if iter == nil {
+			var err error
+			if iter, err = w.iterator(ctx, shardID, cp.Sequence, position); err != nil {
+				if ctx.Err() == nil {
+					logger.Error("getting shard iterator failed", "error", err)
+				}
+				sleepCtx(ctx, streamPollInterval)
+				continue
+			}
+		}
+		readAt := time.Now()
+		out, err := w.streams.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: iter, Limit: aws.Int32(streamRecordsLimit)})
+		var expired *types.ExpiredIteratorException
+		var trimmed *types.TrimmedDataAccessException
+		switch {
+		case errors.As(err, &expired):
+			iter = nil
+			continue
+		case errors.As(err, &trimmed):
+			logger.Warn("shard records were trimmed before being read; resuming at the oldest", "after", cp.Sequence)
+			if w.replica != nil {
+				w.diverge(ctx, "stream records were trimmed before being replicated")
+			}
+			iter, cp.Sequence, position = nil, "", types.ShardIteratorTypeTrimHorizon
+			continue
+		case err != nil:
+			if ctx.Err() == nil {
+				logger.Error("reading shard records failed", "error", err)
+			}
+			sleepCtx(ctx, streamPollInterval)
+			continue
+		}
+
+		published := false
+		for _, rec := range out.Records {
+			if !w.replicate(ctx, logger, rec) {
+				return
+			}
+			if w.handle(ctx, rec) {
+				published = true
+			}
+			cp.Sequence = aws.ToString(rec.Dynamodb.SequenceNumber)
+		}
+		if w.replica != nil {
+			// Changes up to the last record read, or up to the read when
+			// there were none, are on the standby.
+			synced := readAt
+			if n := len(out.Records); n > 0 {
+				synced = aws.ToTime(out.Records[n-1].Dynamodb.ApproximateCreationDateTime)
+			}
+			w.replica.Synced(shardID, synced)
+		}
+		if out.NextShardIterator == nil {
+			cp.Done = true
+			save(cp)
+			w.markDone(shardID)
+			return
+		}
+		iter = out.NextShardIterator
+		// Checkpoints are themselves table writes, and so stream records:
+		// saving after every read would never let the stream go idle.
+		if published || time.Since(lastSave) >= streamCheckpointInterval {
+			save(cp)
+		}
+		if len(out.Records) == 0 {
+			sleepCtx(ctx, streamPollInterval)
+		}
+	}
+}
+
+// replicate applies rec to the standby, if there is one, retrying with
+// backoff so the standby never skips a change. It reports false if ctx is
+// cancelled first.
+func (w *StreamWorker) replicate(ctx context.Context, logger *slog.Logger, rec types.Record) bool {
+	if w.replica == nil {
+		return true
+	}
+	for backoff := 100 * time.Millisecond; ; backoff = min(backoff*2, 10*time.Second) {
+		err := w.replica.Apply(ctx, rec)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		logger.Warn("standby replication failed", "error", err, "eventId", aws.ToString(rec.EventID))
+		if !sleepCtx(ctx, backoff) {
+			return false
+		}
+	}
+}
+
+// diverge records that the standby missed changes and needs a resync.
+func (w *StreamWorker) diverge(ctx context.Context, reason string) {
+	if err := w.replica.Diverge(ctx, reason); err != nil && ctx.Err() == nil {
+		w.logger.Error("saving standby replication status failed", "error", err)
+	}
+}
+
+// iterator returns an iterator after sequence, or at position without one.
+func (w *StreamWorker) iterator(ctx context.Context, shardID, sequence string, position types.ShardIteratorType) (*string, error) {
+	in := &dynamodbstreams.GetShardIteratorInput{StreamArn: &w.streamARN, ShardId: &shardID, ShardIteratorType: position}
+	if sequence != "" {
+		in.ShardIteratorType, in.SequenceNumber = types.ShardIteratorTypeAfterSequenceNumber, &sequence
+	}
+	out, err := w.streams.GetShardIterator(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("GetShardIterator: %w", err)
+	}
+	return out.ShardIterator, nil
+}
+
+// handle publishes the change event for a stream record of a user's
+// preferences item, reporting whether there was one. Other items are
+// skipped.
+func (w *StreamWorker) handle(ctx context.Context, rec types.Record) bool {
+	sr := rec.Dynamodb
+	pk, _ := sr.Keys["PK"].(*types.AttributeValueMemberS)
+	if pk == nil || !strings.HasPrefix(pk.Value, "USER#") {
+		return false
+	}
+	userID := strings.TrimPrefix(pk.Value, "USER#")
+	old, err := unmarshalRecord(fromStreamItem(sr.OldImage))
+	if err != nil {
+		w.logger.Error("invalid stream record image", "error", err, "userId", userID, "eventId", aws.ToString(rec.EventID))
+		return false
+	}
+	typ, cur := EventPreferencesUpdated, Record{}
+	if rec.EventName == types.OperationTypeRemove {
+		typ = EventPreferencesDeleted
+	} else if cur, err = unmarshalRecord(fromStreamItem(sr.NewImage)); err != nil {
+		w.logger.Error("invalid stream record image", "error", err, "userId", userID, "eventId", aws.ToString(rec.EventID))
+		return false
+	}
+
+	e, ok := newChangeEvent(typ, userID, old.Prefs, cur.Prefs, w.exclude)
+	if !ok {
+		return false
+	}
+	e.ID, e.Version = aws.ToString(rec.EventID), cur.Version
+	e.At = aws.ToTime(sr.ApproximateCreationDateTime).UTC()
+	// The writer is recorded per key; take it from the first changed key
+	// that has it.
+	keys := make([]string, 0, len(e.Changes))
+	for k := range e.Changes {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if by := cur.Meta[k].UpdatedBy; by != "" {
+			e.By = by
+			break
+		}
+	}
+	publishChange(ctx, w.sinks, e)
+	return true
+}
+
+// fromStreamItem converts a stream record image to a table item.
+func fromStreamItem(item map[string]types.AttributeValue) map[string]ddbtypes.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	out := make(map[string]ddbtypes.AttributeValue, len(item))
+	for k, v := range item {
+		out[k] = fromStreamValue(v)
+	}
+	return out
+}
+
+func fromStreamValue(v types.AttributeValue) ddbtypes.AttributeValue {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		return &ddbtypes.AttributeValueMemberS{Value: v.Value}
+	case *types.AttributeValueMemberN:
+		return &ddbtypes.AttributeValueMemberN{Value: v.Value}
+	case *types.AttributeValueMemberB:
+		return &ddbtypes.AttributeValueMemberB{Value: v.Value}
+	case *types.AttributeValueMemberBOOL:
+		return &ddbtypes.AttributeValueMemberBOOL{Value: v.Value}
+	case *types.AttributeValueMemberNULL:
+		return &ddbtypes.AttributeValueMemberNULL{Value: v.Value}
+	case *types.AttributeValueMemberSS:
+		return &ddbtypes.AttributeValueMemberSS{Value: v.Value}
+	case *types.AttributeValueMemberNS:
+		return &ddbtypes.AttributeValueMemberNS{Value: v.Value}
+	case *types.AttributeValueMemberBS:
+		return &ddbtypes.AttributeValueMemberBS{Value: v.Value}
+	case *types.AttributeValueMemberL:
+		l := make([]ddbtypes.AttributeValue, len(v.Value))
+		for i, e := range v.Value {
+			l[i] = fromStreamValue(e)
+		}
+		return &ddbtypes.AttributeValueMemberL{Value: l}
+	case *types.AttributeValueMemberM:
+		return &ddbtypes.AttributeValueMemberM{Value: fromStreamItem(v.Value)}
+	}
+	return &ddbtypes.AttributeValueMemberNULL{Value: true}
+}
+
+// sleepCtx waits for d, reporting false if ctx is cancelled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// streamARN returns the configured stream, or the table's latest one.
+func streamARN(ctx context.Context, store *DynamoStore, cfg Config) (string, error) {
+	if cfg.DynamoStreamARN != "" {
+		return cfg.DynamoStreamARN, nil
+	}
+	out, err := store.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &store.tableName})
+	if err != nil {
+		return "", fmt.Errorf("DescribeTable: %w", err)
+	}
+	if out.Table.LatestStreamArn == nil {
+		return "", fmt.Errorf("table %s has no stream; enable one with NEW_AND_OLD_IMAGES", store.tableName)
+	}
+	return *out.Table.LatestStreamArn, nil
+}
+
+// runStreamWorker implements "user-prefs stream-worker": it publishes the
+// table's changes to webhooks and brokers, and replicates them to the
+// standby table if one is configured, until SIGINT or SIGTERM.
+func runStreamWorker(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("stream-worker", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "stream-worker: %v\n", err)
+		return 1
+	}
+	logger := slog.New(slog.NewJSONHandler(stdout, &slog.HandlerOptions{Level: cfg.LogLevel}))
+	// With a standby the worker is needed to replicate it; it leaves
+	// publishing to the API unless CHANGE_EVENTS says otherwise.
+	publish := cfg.ChangeEvents == ChangeEventsStream || cfg.StandbyTableName == ""
+	if cfg.ChangeEvents != ChangeEventsStream {
+		if publish {
+			logger.Warn("CHANGE_EVENTS is not \"stream\": the API publishes change events too, so each is delivered twice")
+		} else {
+			logger.Info("CHANGE_EVENTS is not \"stream\": replicating the standby without publishing change events")
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go RefreshSecrets(ctx, cfg, logger)
+
+	store, err := NewDynamoStore(ctx, cfg)
+	if err != nil {
+		logger.Error("failed to create DynamoDB store", "error", err)
+		return 1
+	}
+	arn, err := streamARN(ctx, store, cfg)
+	if err != nil {
+		logger.Error("failed to find the table's stream", "error", err)
+		return 1
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.AWSRegion))
+	if err != nil {
+		logger.Error("failed to load AWS config", "error", err)
+		return 1
+	}
+	streams := dynamodbstreams.NewFromConfig(awsCfg, func(o *dynamodbstreams.Options) {
+		if cfg.DynamoEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.DynamoEndpoint)
+		}
+	})
+
+	var replica *StandbyReplicator
+	if cfg.StandbyTableName != "" {
+		standby, err := newStandbyStore(ctx, cfg)
+		if err != nil {
+			logger.Error("failed to create standby store", "error", err)
+			return 1
+		}
+		replica = NewStandbyReplicator(standby.client, standby.tableName, logger)
+		logger.Info("replicating to standby", "table", cfg.StandbyTableName, "region", cfg.StandbyRegion)
+	}
+
+	var sinks []ChangeSink
+	var brokers []*AsyncSink
+	if publish {
+		hooks, err := NewWebhookStore(ctx, cfg, store)
+		if err != nil {
+			logger.Error("failed to create webhook store", "error", err)
+			return 1
+		}
+		webhooks := NewWebhookDispatcher(hooks, cfg.WebhookRefresh, cfg.EventSource, logger)
+		webhooks.Start(ctx)
+		if brokers, err = NewBrokerSinks(ctx, cfg, logger); err != nil {
+			logger.Error("failed to set up change event publishing", "error", err)
+			return 1
+		}
+		sinks = append(sinks, webhooks)
+		for _, b := range brokers {
+			sinks = append(sinks, b)
+		}
+	}
+
+	logger.Info("stream worker starting", "stream", arn, "start", cfg.StreamStart)
+	worker := NewStreamWorker(streams, arn, store, replica, cfg.SensitiveKeys, cfg.StreamStart, logger, sinks...)
+	err = worker.Run(ctx)
+
+	sctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	for _, b := range brokers {
+		b.Close(sctx)
+	}
+	if err != nil {
+		logger.Error("stream worker failed", "error", err)
+		return 1
+	}
+	logger.Info("stream worker stopped")
+	return 0
+}
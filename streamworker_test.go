@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// fakeStreams serves one closed shard holding records, one per GetRecords
+// call; the iterator is the index of the next record.
+type fakeStreams struct {
+	records []types.Record
+	// expire makes the first GetRecords call fail as expired.
+	expire bool
+}
+
+func (f *fakeStreams) DescribeStream(_ context.Context, _ *dynamodbstreams.DescribeStreamInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: &types.StreamDescription{
+		StreamViewType: types.StreamViewTypeNewAndOldImages,
+		Shards:         []types.Shard{{ShardId: aws.String("shard-1")}},
+	}}, nil
+}
+
+func (f *fakeStreams) GetShardIterator(_ context.Context, in *dynamodbstreams.GetShardIteratorInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	next := 0
+	if in.ShardIteratorType == types.ShardIteratorTypeAfterSequenceNumber {
+		next, _ = strconv.Atoi(*in.SequenceNumber)
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(strconv.Itoa(next))}, nil
+}
+
+func (f *fakeStreams) GetRecords(_ context.Context, in *dynamodbstreams.GetRecordsInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	if f.expire {
+		f.expire = false
+		return nil, &types.ExpiredIteratorException{}
+	}
+	i, _ := strconv.Atoi(*in.ShardIterator)
+	if i >= len(f.records) {
+		return &dynamodbstreams.GetRecordsOutput{}, nil
+	}
+	return &dynamodbstreams.GetRecordsOutput{
+		Records:           f.records[i : i+1],
+		NextShardIterator: aws.String(strconv.Itoa(i + 1)),
+	}, nil
+}
+
+// memCheckpoints keeps checkpoints in memory.
+type memCheckpoints struct {
+	mu  sync.Mutex
+	cps map[string]ShardCheckpoint
+}
+
+func (m *memCheckpoints) GetCheckpoint(_ context.Context, shardID string) (ShardCheckpoint, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp, ok := m.cps[shardID]
+	return cp, ok, nil
+}
+
+func (m *memCheckpoints) PutCheckpoint(_ context.Context, shardID string, cp ShardCheckpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cps[shardID] = cp
+	return nil
+}
+
+// streamRecord builds a record of a write to pk, numbered seq, with
+// preferences old and cur (nil: no image).
+func streamRecord(seq int, name types.OperationType, pk string, old, cur map[string]types.AttributeValue) types.Record {
+	image := func(prefs map[string]types.AttributeValue) map[string]types.AttributeValue {
+		if prefs == nil {
+			return nil
+		}
+		return map[string]types.AttributeValue{
+			"PK":          &types.AttributeValueMemberS{Value: pk},
+			"preferences": &types.AttributeValueMemberM{Value: prefs},
+			"version":     &types.AttributeValueMemberN{Value: strconv.Itoa(seq)},
+			"meta": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"theme": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+					"updatedBy": &types.AttributeValueMemberS{Value: "script"},
+				}},
+			}},
+		}
+	}
+	return types.Record{
+		EventID:   aws.String("evt" + strconv.Itoa(seq)),
+		EventName: name,
+		Dynamodb: &types.StreamRecord{
+			Keys:                        map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: pk}},
+			OldImage:                    image(old),
+			NewImage:                    image(cur),
+			SequenceNumber:              aws.String(strconv.Itoa(seq)),
+			ApproximateCreationDateTime: aws.Time(time.Date(2026, 3, 2, 10, 15, 0, 0, time.UTC)),
+		},
+	}
+}
+
+func TestStreamWorker(t *testing.T) {
+	defer func(d time.Duration) { streamPollInterval = d }(streamPollInterval)
+	streamPollInterval = time.Millisecond
+
+	dark := map[string]types.AttributeValue{"theme": &types.AttributeValueMemberS{Value: "dark"}}
+	light := map[string]types.AttributeValue{
+		"theme": &types.AttributeValueMemberS{Value: "light"},
+		"ssn":   &types.AttributeValueMemberS{Value: "123"},
+	}
+	streams := &fakeStreams{expire: true, records: []types.Record{
+		streamRecord(1, types.OperationTypeInsert, "USER#user1", nil, dark),
+		streamRecord(2, types.OperationTypeInsert, "WEBHOOK#wh1", nil, dark),
+		streamRecord(3, types.OperationTypeModify, "USER#user1", dark, light),
+		streamRecord(4, types.OperationTypeRemove, "USER#user1", light, nil),
+	}}
+	checkpoints := &memCheckpoints{cps: map[string]ShardCheckpoint{}}
+	sink := &recordingSink{}
+	standby := newFakeReplica()
+	w := NewStreamWorker(streams, "arn:stream", checkpoints, NewStandbyReplicator(standby, "standby", testLogger()), []string{"ssn"}, StreamStartTrimHorizon, testLogger(), sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if cp, _, _ := checkpoints.GetCheckpoint(ctx, "shard-1"); cp.Done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("shard was never read to its end")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.events) != 3 {
+		t.Fatalf("expected an event per user record, got %+v", sink.events)
+	}
+	if e := sink.events[0]; e.ID != "evt1" || e.Type != EventPreferencesUpdated || e.UserID != "user1" || e.Version != 1 ||
+		e.By != "script" || e.Changes["theme"] != "dark" || !e.At.Equal(time.Date(2026, 3, 2, 10, 15, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected insert event %+v", e)
+	}
+	if e := sink.events[1]; e.Changes["theme"] != "light" || e.Previous["theme"] != "dark" || len(e.Sensitive) != 1 || e.Changes["ssn"] != nil {
+		t.Fatalf("unexpected modify event %+v", e)
+	}
+	if e := sink.events[2]; e.Type != EventPreferencesDeleted || e.Version != 0 || len(e.Changes) != 1 {
+		t.Fatalf("unexpected remove event %+v", e)
+	}
+	if cp := checkpoints.cps["shard-1"]; cp.Sequence != "4" {
+		t.Fatalf("expected the last sequence number checkpointed, got %+v", cp)
+	}
+	if standby.puts != 2 || len(standby.items) != 0 {
+		t.Fatalf("expected the user's writes and removal replicated, got %d puts leaving %v", standby.puts, standby.items)
+	}
+
+	// A restarted worker skips finished shards.
+	sink.events = nil
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	w = NewStreamWorker(streams, "arn:stream", checkpoints, nil, []string{"ssn"}, StreamStartTrimHorizon, testLogger(), sink)
+	if err := w.Run(ctx); err != nil || len(sink.events) != 0 {
+		t.Fatalf("expected nothing republished, got %+v (%v)", sink.events, err)
+	}
+}
+
+func TestStreamWorker_RequiresBothImages(t *testing.T) {
+	w := NewStreamWorker(&keysOnlyStream{}, "arn:stream", &memCheckpoints{}, nil, nil, StreamStartLatest, testLogger())
+	if err := w.Run(context.Background()); err == nil {
+		t.Fatal("expected a stream without old and new images to be rejected")
+	}
+}
+
+type keysOnlyStream struct{ *fakeStreams }
+
+func (keysOnlyStream) DescribeStream(_ context.Context, _ *dynamodbstreams.DescribeStreamInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: &types.StreamDescription{StreamViewType: types.StreamViewTypeKeysOnly}}, nil
+}
+
+func TestFromStreamItem(t *testing.T) {
+	item := fromStreamItem(map[string]types.AttributeValue{
+		"m": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"l": &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberN{Value: "1"}, &types.AttributeValueMemberBOOL{Value: true}}},
+		}},
+	})
+	l := item["m"].(*ddbtypes.AttributeValueMemberM).Value["l"].(*ddbtypes.AttributeValueMemberL).Value
+	if n, ok := l[0].(*ddbtypes.AttributeValueMemberN); !ok || n.Value != "1" {
+		t.Fatalf("unexpected number %#v", l[0])
+	}
+	if b, ok := l[1].(*ddbtypes.AttributeValueMemberBOOL); !ok || !b.Value {
+		t.Fatalf("unexpected bool %#v", l[1])
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// LoadTemplates reads a preference templates file (TEMPLATES_FILE): a JSON
+// object mapping each template name, such as "trial-user", to the
+// preference map it seeds.
+func LoadTemplates(path string) (map[string]map[string]any, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading templates: %w", err)
+	}
+	defer file.Close()
+
+	var templates map[string]map[string]any
+	if err := decodeJSON(file, &templates); err != nil {
+		return nil, fmt.Errorf("parsing templates: %w", err)
+	}
+	for name, prefs := range templates {
+		for k := range prefs {
+			if reason := keyViolation(k, nil); reason != "" {
+				return nil, fmt.Errorf("template %q: key %q: %s", name, k, reason)
+			}
+		}
+	}
+	return templates, nil
+}
+
+// checkTemplates validates every template as a PUT of its map would be, so
+// a template cannot seed what users themselves may not write. main runs it
+// at startup; ApplyTemplate checks again, since the schema may be reloaded.
+func (h *PreferencesHandler) checkTemplates() error {
+	for _, name := range slices.Sorted(maps.Keys(h.opts.Templates)) {
+		if v := h.prefViolations(h.templatePrefs(h.opts.Templates[name])); len(v) > 0 {
+			return fmt.Errorf("template %q: key %q: %s", name, v[0].Key, v[0].Reason)
+		}
+	}
+	return nil
+}
+
+// templatePrefs is the map a template writes: a copy, with unknown keys
+// dropped and aliases mirrored as for a PUT.
+func (h *PreferencesHandler) templatePrefs(template map[string]any) map[string]any {
+	prefs := maps.Clone(template)
+	if prefs == nil {
+		prefs = map[string]any{}
+	}
+	h.dropUnknownKeys(prefs)
+	h.mirrorAliases(prefs, nil)
+	return prefs
+}
+
+// ApplyTemplateRequest is the body of POST
+// /internal/users/{userId}/preferences:applyTemplate.
+type ApplyTemplateRequest struct {
+	Template string `json:"template"`
+}
+
+// ApplyTemplate seeds a new user's preferences from a named template, for
+// the service provisioning the account. The map is written in one
+// conditional write that fails if the user already has a record, so a
+// template never overwrites or half-merges into preferences the user has
+// set; that answers 409. It is mounted behind RequireScope(ScopeWrite).
+func (h *PreferencesHandler) ApplyTemplate(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userId")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, "userId is required")
+		return
+	}
+	var req ApplyTemplateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	template, ok := h.opts.Templates[req.Template]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown template %q", req.Template))
+		return
+	}
+	prefs := h.templatePrefs(template)
+	if !h.validatePrefs(w, prefs) || !h.checkQuota(w, r, userID, prefs, nil, true) {
+		return
+	}
+
+	rec, err := h.store.ReplaceAll(r.Context(), userID, prefs, Precondition{MustNotExist: true})
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, http.StatusConflict, "user already has preferences")
+		return
+	}
+	if err != nil {
+		h.logger.Error("store.ReplaceAll failed", "error", err, "userId", userID, "template", req.Template)
+		writeError(w, http.StatusInternalServerError, "failed to save preferences")
+		return
+	}
+	setValidators(w, rec)
+	w.Header().Set("Location", "/api/v1/users/"+url.PathEscape(userID)+"/preferences")
+	writeResponse(w, r, http.StatusCreated, newPreferencesResponse(userID, prefs, rec))
+}
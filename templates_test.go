@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	os.WriteFile(path, []byte(`{"trial-user":{"theme":"light","notifications.email":true}}`), 0o600)
+	templates, err := LoadTemplates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if templates["trial-user"]["notifications.email"] != true {
+		t.Fatalf("unexpected templates %v", templates)
+	}
+
+	os.WriteFile(path, []byte(`{"trial-user":{"bad key":1}}`), 0o600)
+	if _, err := LoadTemplates(path); err == nil {
+		t.Fatal("expected an error for an invalid key")
+	}
+}
+
+func TestApplyTemplate(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user2"] = map[string]any{"theme": "dark"}
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Templates: map[string]map[string]any{
+		"enterprise-user": {"theme": "light", "sso": true},
+	}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/internal/users/{userId}/preferences:applyTemplate", RequireScope(ScopeWrite)(h.ApplyTemplate))
+	post := func(user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/internal/users/"+user+"/preferences:applyTemplate", bytes.NewBufferString(body))
+		svc := Claims{Subject: "provisioner", Kind: PrincipalService, Scopes: []string{ScopeWrite}}
+		req = req.WithContext(context.WithValue(req.Context(), claimsKey, svc))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("user1", `{"template":"enterprise-user"}`)
+	var resp PreferencesResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/api/v1/users/user1/preferences" || resp.Preferences["sso"] != true {
+		t.Fatalf("unexpected response %d %v %+v", w.Code, w.Header(), resp)
+	}
+	if len(store.prefs["user1"]) != 2 {
+		t.Fatalf("unexpected stored prefs %v", store.prefs["user1"])
+	}
+
+	// The template is not shared with the stored map.
+	store.prefs["user1"]["theme"] = "dark"
+	if h.opts.Templates["enterprise-user"]["theme"] != "light" {
+		t.Fatal("expected the template unchanged")
+	}
+
+	if w := post("user2", `{"template":"enterprise-user"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for an existing user, got %d", w.Code)
+	}
+	if store.prefs["user2"]["theme"] != "dark" || len(store.prefs["user2"]) != 1 {
+		t.Fatalf("expected existing prefs untouched, got %v", store.prefs["user2"])
+	}
+	if w := post("user3", `{"template":"nobody"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown template, got %d", w.Code)
+	}
+}
+
+func TestApplyTemplate_Validates(t *testing.T) {
+	store := newMockStore()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{
+		AllowedKeys: []string{"theme"},
+		Templates:   map[string]map[string]any{"trial-user": {"theme": "light", "sso": true}},
+	})
+	if err := h.checkTemplates(); err == nil || !strings.Contains(err.Error(), `"sso"`) {
+		t.Fatalf("expected the disallowed key reported, got %v", err)
+	}
+
+	r := httptest.NewRequest("POST", "/api/v1/internal/users/user1/preferences:applyTemplate", bytes.NewBufferString(`{"template":"trial-user"}`))
+	r.SetPathValue("userId", "user1")
+	w := httptest.NewRecorder()
+	h.ApplyTemplate(w, r)
+	if w.Code != http.StatusUnprocessableEntity || store.prefs["user1"] != nil {
+		t.Fatalf("expected 422 and nothing written, got %d %v", w.Code, store.prefs["user1"])
+	}
+
+	h.opts.DropUnknownKeys = true
+	if err := h.checkTemplates(); err != nil {
+		t.Fatalf("expected unknown keys dropped, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// undoTokenHeader carries the token that undoes a DeleteAll or ReplaceAll.
+const undoTokenHeader = "Undo-Token"
+
+// UndoRequest is the body of POST /preferences:undo.
+type UndoRequest struct {
+	Token string `json:"token"`
+}
+
+// encodeUndoToken names a snapshot by the version of the user's undo item
+// holding it and the version and generation the destructive write left
+// behind (0 and none after a delete), so a token stops working once either
+// moves on.
+func encodeUndoToken(snapshotVersion int64, result Record) string {
+	token := fmt.Sprintf("%d.%d", snapshotVersion, result.Version)
+	if result.Generation != "" {
+		token += "." + result.Generation
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(token))
+}
+
+func decodeUndoToken(token string) (snapshotVersion int64, result Record, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, Record{}, fmt.Errorf("decoding undo token: %w", err)
+	}
+	parts := strings.SplitN(string(b), ".", 3)
+	if len(parts) < 2 {
+		return 0, Record{}, errors.New("parsing undo token: missing versions")
+	}
+	if snapshotVersion, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, Record{}, fmt.Errorf("parsing undo token: %w", err)
+	}
+	if result.Version, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return 0, Record{}, fmt.Errorf("parsing undo token: %w", err)
+	}
+	if len(parts) == 3 {
+		result.Generation = parts[2]
+	}
+	return snapshotVersion, result, nil
+}
+
+// stashUndo keeps prev, the map a DeleteAll or ReplaceAll has just replaced,
+// and hands the client a token for it in the Undo-Token header. Each user
+// has one snapshot, so only their latest destructive write can be undone.
+// The write has already succeeded, so a failure only loses the token.
+func (h *PreferencesHandler) stashUndo(w http.ResponseWriter, r *http.Request, userID string, prev map[string]any, result Record) {
+	if prev == nil {
+		prev = make(map[string]any)
+	}
+	snap, err := h.opts.Undo.ReplaceAll(r.Context(), userID, prev, Precondition{})
+	if err != nil {
+		h.logger.Warn("stashing undo snapshot failed", "error", err, "userId", userID)
+		return
+	}
+	w.Header().Set(undoTokenHeader, encodeUndoToken(snap.Version, result))
+}
+
+// Undo restores the map a DeleteAll or ReplaceAll replaced, given the token
+// it returned, within UndoWindow. It is 404 when the token is unknown,
+// expired, superseded or already used, and 409 when preferences have been
+// written since, rather than overwrite them.
+func (h *PreferencesHandler) Undo(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	var req UndoRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return
+	}
+	snapshotVersion, result, err := decodeUndoToken(req.Token)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid undo token")
+		return
+	}
+
+	snap, err := h.opts.Undo.GetAll(ctx, userID)
+	if err != nil {
+		h.logger.Error("reading undo snapshot failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to undo")
+		return
+	}
+	// DynamoDB removes expired items lazily, so the window is checked here.
+	if snap.Prefs == nil || snap.Version != snapshotVersion || time.Since(snap.UpdatedAt) > h.opts.UndoWindow {
+		writeErrorCode(w, http.StatusNotFound, ErrCodeUndoUnavailable, "undo token is unknown, expired or already used")
+		return
+	}
+
+	current, found, err := h.store.Stat(ctx, userID, "")
+	if err != nil {
+		h.logger.Error("store.Stat failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to undo")
+		return
+	}
+	if !found {
+		current = Record{}
+	}
+	if current.Version != result.Version || current.Generation != result.Generation {
+		writeError(w, http.StatusConflict, "preferences have been modified since")
+		return
+	}
+	// Undoing a DeleteAll must not overwrite a first write made since.
+	cond := Precondition{MustNotExist: true}
+	if result.Version > 0 {
+		cond = Precondition{Versions: []int64{result.Version}, Generation: result.Generation}
+	}
+
+	rec, err := h.store.ReplaceAll(ctx, userID, snap.Prefs, cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, http.StatusConflict, "preferences have been modified since")
+		return
+	}
+	if err != nil {
+		h.logger.Error("store.ReplaceAll failed", "error", err, "userId", userID)
+		writeError(w, http.StatusInternalServerError, "failed to undo")
+		return
+	}
+	if err := h.opts.Undo.DeleteAll(ctx, userID); err != nil {
+		// The token is spent anyway: the version it names has moved on.
+		h.logger.Warn("deleting undo snapshot failed", "error", err, "userId", userID)
+	}
+
+	h.logger.Info("preferences write undone", "userId", userID, "keys", len(rec.Prefs))
+	setValidators(w, rec)
+	writeJSON(w, http.StatusOK, newPreferencesResponse(userID, rec.Prefs, rec))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUndo(t *testing.T) {
+	store, undo := newMockStore(), newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	store.versions["user1"] = 3
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Undo: undo, UndoWindow: time.Minute})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences", h.ReplaceAll)
+	mux.HandleFunc("DELETE /api/v1/users/{userId}/preferences", h.DeleteAll)
+	mux.HandleFunc("POST /api/v1/users/{userId}/preferences:undo", h.Undo)
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/users/user1/preferences"+path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		return w
+	}
+	undoWith := func(token string) *httptest.ResponseRecorder {
+		return send("POST", ":undo", `{"token":"`+token+`"}`)
+	}
+
+	w := send("PUT", "", `{"lang":"en"}`)
+	token := w.Header().Get(undoTokenHeader)
+	if w.Code != http.StatusOK || token == "" {
+		t.Fatalf("expected an undo token, got %d %v", w.Code, w.Header())
+	}
+	w = undoWith(token)
+	var resp PreferencesResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Preferences["theme"] != "dark" || resp.Preferences["lang"] != nil {
+		t.Fatalf("expected the replaced map back, got %d %+v", w.Code, resp)
+	}
+	var apiErr APIError
+	w = undoWith(token)
+	json.NewDecoder(w.Body).Decode(&apiErr)
+	if w.Code != http.StatusNotFound || apiErr.ErrorCode != ErrCodeUndoUnavailable {
+		t.Fatalf("expected a used token to be 404, got %d %+v", w.Code, apiErr)
+	}
+
+	w = send("DELETE", "", "")
+	token = w.Header().Get(undoTokenHeader)
+	if w.Code != http.StatusNoContent || token == "" {
+		t.Fatalf("expected an undo token, got %d %v", w.Code, w.Header())
+	}
+	if w := undoWith(token); w.Code != http.StatusOK || store.prefs["user1"]["theme"] != "dark" {
+		t.Fatalf("expected the deleted map back, got %d %v", w.Code, store.prefs)
+	}
+
+	// Only the latest destructive write can be undone.
+	first := send("PUT", "", `{"lang":"en"}`).Header().Get(undoTokenHeader)
+	second := send("PUT", "", `{"lang":"fr"}`).Header().Get(undoTokenHeader)
+	if w := undoWith(first); w.Code != http.StatusNotFound {
+		t.Fatalf("expected a superseded token to be 404, got %d", w.Code)
+	}
+
+	// Writes since the token block it.
+	store.prefs["user1"]["lang"] = "de"
+	store.versions["user1"]++
+	if w := undoWith(second); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 after a later write, got %d", w.Code)
+	}
+
+	token = send("PUT", "", `{}`).Header().Get(undoTokenHeader)
+	undo.updated["user1"] = time.Now().Add(-2 * time.Minute)
+	if w := undoWith(token); w.Code != http.StatusNotFound {
+		t.Fatalf("expected an expired token to be 404, got %d", w.Code)
+	}
+	if w := undoWith("%%%"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a malformed token to be 400, got %d", w.Code)
+	}
+
+	// Deleting nothing returns no token.
+	store.prefs, store.versions = map[string]map[string]any{}, map[string]int64{}
+	if w := send("DELETE", "", ""); w.Header().Get(undoTokenHeader) != "" {
+		t.Fatal("expected no token for an empty delete")
+	}
+}
+
+// statRacingStore writes a record for a user right after Stat finds none,
+// as a first write landing between the check and the write would.
+type statRacingStore struct {
+	*mockStore
+	raced bool
+}
+
+func (s *statRacingStore) Stat(ctx context.Context, userID, key string) (Record, bool, error) {
+	rec, found, err := s.mockStore.Stat(ctx, userID, key)
+	if !found && err == nil && !s.raced {
+		s.raced = true
+		s.mockStore.ReplaceAll(ctx, userID, map[string]any{"lang": "fr"}, Precondition{})
+	}
+	return rec, found, err
+}
+
+func TestUndo_DeleteRacesFirstWrite(t *testing.T) {
+	store, undo := &statRacingStore{mockStore: newMockStore()}, newMockStore()
+	store.prefs["user1"] = map[string]any{"theme": "dark"}
+	store.versions["user1"] = 3
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{Undo: undo, UndoWindow: time.Minute})
+
+	r := withClaims(httptest.NewRequest("DELETE", "/api/v1/users/user1/preferences", nil), "user1")
+	r.SetPathValue("userId", "user1")
+	w := httptest.NewRecorder()
+	h.DeleteAll(w, r)
+	token := w.Header().Get(undoTokenHeader)
+	if w.Code != http.StatusNoContent || token == "" {
+		t.Fatalf("expected an undo token, got %d %v", w.Code, w.Header())
+	}
+
+	r = withClaims(httptest.NewRequest("POST", "/api/v1/users/user1/preferences:undo", strings.NewReader(`{"token":"`+token+`"}`)), "user1")
+	r.SetPathValue("userId", "user1")
+	w = httptest.NewRecorder()
+	h.Undo(w, r)
+	if w.Code != http.StatusConflict || !store.raced {
+		t.Fatalf("expected 409 when a first write lands during undo, got %d", w.Code)
+	}
+	if got := store.prefs["user1"]; got["lang"] != "fr" || got["theme"] != nil {
+		t.Fatalf("expected the first write kept, got %v", got)
+	}
+}
+
+func TestUndoToken(t *testing.T) {
+	s, r, err := decodeUndoToken(encodeUndoToken(12, Record{}))
+	if err != nil || s != 12 || r.Version != 0 || r.Generation != "" {
+		t.Fatalf("round trip gave %d %+v %v", s, r, err)
+	}
+	s, r, err = decodeUndoToken(encodeUndoToken(3, Record{Version: 7, Generation: "a1b2"}))
+	if err != nil || s != 3 || r.Version != 7 || r.Generation != "a1b2" {
+		t.Fatalf("round trip gave %d %+v %v", s, r, err)
+	}
+	if _, _, err := decodeUndoToken(encodeCursor("nope")); err == nil {
+		t.Fatal("expected an error for a token that is not two versions")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// unknownUserPreference is the Prefer header preference (RFC 7240) that
+// overrides UnknownUsersNotFound for one request: unknown-user=not-found or
+// unknown-user=empty.
+const unknownUserPreference = "unknown-user"
+
+// unknownUserNotFound reports whether a read of the map of a user with no
+// stored preferences should be 404 rather than an empty map, and writes
+// Preference-Applied when the request's Prefer header decided it. Every
+// response this applies to varies on Prefer.
+func (h *PreferencesHandler) unknownUserNotFound(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Prefer")
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			// Parameters after ";" do not apply to this preference.
+			pref, _, _ = strings.Cut(pref, ";")
+			name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if !strings.EqualFold(name, unknownUserPreference) {
+				continue
+			}
+			switch value := strings.ToLower(strings.Trim(value, `"`)); value {
+			case "not-found", "empty":
+				w.Header().Set("Preference-Applied", unknownUserPreference+"="+value)
+				return value == "not-found"
+			}
+		}
+	}
+	return h.opts.UnknownUsersNotFound
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnknownUsers(t *testing.T) {
+	store := newMockStore()
+	store.prefs["user2"] = map[string]any{}
+
+	for _, tc := range []struct {
+		name     string
+		notFound bool
+		prefer   string
+		user     string
+		method   string
+		want     int
+		applied  string
+	}{
+		{name: "default", user: "user1", want: http.StatusOK},
+		{name: "configured", notFound: true, user: "user1", want: http.StatusNotFound},
+		{name: "configured head", notFound: true, user: "user1", method: "HEAD", want: http.StatusNotFound},
+		{name: "empty map is not unknown", notFound: true, user: "user2", want: http.StatusOK},
+		{name: "prefer not found", prefer: "unknown-user=not-found", user: "user1", want: http.StatusNotFound, applied: "unknown-user=not-found"},
+		{name: "prefer empty", notFound: true, prefer: `respond-async, unknown-user="empty"`, user: "user1", want: http.StatusOK, applied: "unknown-user=empty"},
+		{name: "unknown preference value", notFound: true, prefer: "unknown-user=maybe", user: "user1", want: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewPreferencesHandler(store, testLogger(), HandlerOptions{UnknownUsersNotFound: tc.notFound})
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/v1/users/{userId}/preferences", h.GetAll)
+
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := httptest.NewRequest(method, "/api/v1/users/"+tc.user+"/preferences", nil)
+			if tc.prefer != "" {
+				req.Header.Set("Prefer", tc.prefer)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, withClaims(req, tc.user))
+
+			if w.Code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, w.Code)
+			}
+			if got := w.Header().Get("Preference-Applied"); got != tc.applied {
+				t.Fatalf("expected Preference-Applied %q, got %q", tc.applied, got)
+			}
+			if w.Header().Get("Vary") != "Prefer" {
+				t.Fatalf("expected Vary: Prefer, got %v", w.Header())
+			}
+			if w.Code == http.StatusNotFound && method == "GET" {
+				var apiErr APIError
+				json.NewDecoder(w.Body).Decode(&apiErr)
+				if apiErr.ErrorCode != ErrCodeUserNotFound {
+					t.Fatalf("expected %s, got %+v", ErrCodeUserNotFound, apiErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// problemContentType is the media type of v2 error responses.
+const problemContentType = "application/problem+json"
+
+// Problem is a v2 error response (RFC 7807). Its type is the documentation
+// URL of the error code, which is also given as the code extension member;
+// Violations lists each problem with a rejected write (422).
+type Problem struct {
+	Type       string      `json:"type"`
+	Title      string      `json:"title"`
+	Status     int         `json:"status"`
+	Detail     string      `json:"detail,omitempty"`
+	Instance   string      `json:"instance,omitempty"`
+	Code       string      `json:"code"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// PreferencesEnvelope is the v2 form of PreferencesResponse. Version is the
+// record version (0 for users with no record) and ETag the entity tag for
+// If-Match, which for ?view=effective is not a version.
+type PreferencesEnvelope struct {
+	UserID      string                  `json:"userId"`
+	Preferences map[string]any          `json:"preferences"`
+	Metadata    map[string]PrefMetadata `json:"metadata,omitempty"`
+	Version     int64                   `json:"version"`
+	ETag        string                  `json:"etag,omitempty"`
+	CreatedAt   *time.Time              `json:"createdAt,omitempty"`
+	UpdatedAt   *time.Time              `json:"updatedAt,omitempty"`
+	NextCursor  string                  `json:"nextCursor,omitempty"`
+}
+
+// PreferenceEnvelope is the v2 form of SinglePrefResponse. UpdatedAt is
+// when the user's record last changed, with the one-second resolution of
+// Last-Modified.
+type PreferenceEnvelope struct {
+	Key       string     `json:"key"`
+	Value     any        `json:"value"`
+	Version   int64      `json:"version"`
+	ETag      string     `json:"etag,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// APIv2 serves a v1 handler under /api/v2: it buffers the response and
+// rewrites preference bodies into envelopes, taking the version and
+// timestamps from the validators the handler set, and errors into problem
+// details. It wraps the whole middleware chain, so authentication and
+// Idempotency-Key replays answer in the v2 format too. v1 handlers, and
+// their responses, are unchanged.
+func APIv2(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// ?fields= names envelope fields, so it is applied here rather than
+		// by the handler.
+		var zero any = PreferencesEnvelope{}
+		if r.PathValue("key") != "" {
+			zero = PreferenceEnvelope{}
+		}
+		fields, err := requestedFields(r, zero)
+		if err != nil {
+			w.Header().Set("Content-Type", problemContentType)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(newProblem(r, http.StatusBadRequest, ErrCodeBadRequest, err.Error(), nil))
+			return
+		}
+		if fields != nil {
+			q := r.URL.Query()
+			q.Del("fields")
+			r = r.Clone(r.Context())
+			r.URL.RawQuery = q.Encode()
+		}
+
+		bw := &bufferingWriter{ResponseWriter: w, status: http.StatusOK}
+		next(bw, r)
+
+		body := bw.body.Bytes()
+		if len(body) > 0 && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			if v, ok := toV2(r, bw.status, w.Header(), body); ok {
+				if bw.status < 400 {
+					v, _ = selectFields(v, fields)
+				}
+				var buf bytes.Buffer
+				json.NewEncoder(&buf).Encode(v)
+				body = buf.Bytes()
+				if bw.status >= 400 {
+					w.Header().Set("Content-Type", problemContentType)
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+		}
+		w.WriteHeader(bw.status)
+		w.Write(body)
+	}
+}
+
+// toV2 converts a v1 response body. ok is false for bodies it does not
+// recognize, which are passed through.
+func toV2(r *http.Request, status int, header http.Header, body []byte) (v any, ok bool) {
+	if status >= 400 {
+		var e APIError
+		if decodeJSON(bytes.NewReader(body), &e) != nil || e.Code == 0 {
+			return nil, false
+		}
+		return newProblem(r, status, e.ErrorCode, e.Error, e.Violations), true
+	}
+
+	var fields map[string]json.RawMessage
+	if decodeJSON(bytes.NewReader(body), &fields) != nil {
+		return nil, false
+	}
+	etag := header.Get("ETag")
+	version := versionOf(etag)
+	switch {
+	case fields["preferences"] != nil:
+		var resp PreferencesResponse
+		if decodeJSON(bytes.NewReader(body), &resp) != nil {
+			return nil, false
+		}
+		return PreferencesEnvelope{
+			UserID:      resp.UserID,
+			Preferences: resp.Preferences,
+			Metadata:    resp.Metadata,
+			Version:     version,
+			ETag:        etag,
+			CreatedAt:   resp.CreatedAt,
+			UpdatedAt:   resp.UpdatedAt,
+			NextCursor:  resp.NextCursor,
+		}, true
+	case fields["key"] != nil:
+		var resp SinglePrefResponse
+		if decodeJSON(bytes.NewReader(body), &resp) != nil {
+			return nil, false
+		}
+		env := PreferenceEnvelope{Key: resp.Key, Value: resp.Value, Version: version, ETag: etag}
+		if t, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+			env.UpdatedAt = &t
+		}
+		return env, true
+	}
+	return nil, false
+}
+
+func newProblem(r *http.Request, status int, code, detail string, violations []Violation) Problem {
+	return Problem{
+		Type:       errorDocURL(code),
+		Title:      http.StatusText(status),
+		Status:     status,
+		Detail:     detail,
+		Instance:   r.URL.Path,
+		Code:       code,
+		Violations: violations,
+	}
+}
+
+// versionOf returns the record version in an ETag from formatETag or
+// effectiveETag, or 0.
+func versionOf(etag string) int64 {
+	tag, _, _ := strings.Cut(strings.Trim(etag, `"`), "-")
+	tag, _, _ = strings.Cut(tag, ".")
+	n, _ := strconv.ParseInt(tag, 10, 64)
+	return n
+}
+
+// bufferingWriter holds a response back so APIv2 can rewrite it. Headers
+// go straight to the underlying writer.
+type bufferingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *bufferingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+}
+
+func (w *bufferingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAPIv2(t *testing.T) {
+	store := newMockStore()
+	prefs := NewPreferencesHandler(store, testLogger(), HandlerOptions{ReservedKeyPrefixes: []string{"sys."}})
+	hs := Handlers{Prefs: prefs, Corrections: NewCorrectionsHandler(prefs, newMockCorrectionStore())}
+	router := NewRouter(hs, Config{AuthMode: AuthModeJWT, JWTSecret: testSecret}, testLogger())
+	token := "Bearer " + makeToken("user1", testSecret, jwt.SigningMethodHS256)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("PUT", "/api/v2/users/user1/preferences", `{"layout":{"columns":3,"pinned":["inbox"]}}`)
+	var env PreferencesEnvelope
+	if err := json.NewDecoder(w.Body).Decode(&env); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/api/v2/users/user1/preferences" || env.Version != 1 || env.ETag != `"1.g1"` || env.UpdatedAt == nil {
+		t.Fatalf("expected a versioned envelope, got %d %+v", w.Code, env)
+	}
+	if layout, ok := env.Preferences["layout"].(map[string]any); !ok || layout["columns"] != 3.0 {
+		t.Fatalf("expected the structured value back, got %v", env.Preferences)
+	}
+
+	w = send("PUT", "/api/v2/users/user1/preferences/theme", `{"value":"dark"}`)
+	var one PreferenceEnvelope
+	json.NewDecoder(w.Body).Decode(&one)
+	if one.Key != "theme" || one.Value != "dark" || one.Version != 2 || one.ETag != `"2.g1"` {
+		t.Fatalf("unexpected single-key envelope %+v", one)
+	}
+	w = send("GET", "/api/v2/users/user1/preferences/theme", "")
+	one = PreferenceEnvelope{}
+	json.NewDecoder(w.Body).Decode(&one)
+	if w.Header().Get("ETag") != `"2.g1"` || one.Version != 2 || one.UpdatedAt == nil {
+		t.Fatalf("expected the read to carry the version, got %+v %v", one, w.Header())
+	}
+
+	w = send("GET", "/api/v2/users/user1/preferences/theme?fields=value,version", "")
+	var trimmed map[string]any
+	json.NewDecoder(w.Body).Decode(&trimmed)
+	if len(trimmed) != 2 || trimmed["value"] != "dark" || trimmed["version"] != 2.0 {
+		t.Fatalf("expected only value and version, got %v", trimmed)
+	}
+	if w := send("GET", "/api/v2/users/user1/preferences?fields=bogus", ""); w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != problemContentType {
+		t.Fatalf("expected a 400 problem for an unknown field, got %d %v", w.Code, w.Header())
+	}
+
+	// v1 is unchanged.
+	w = send("GET", "/api/v1/users/user1/preferences/theme", "")
+	if got := w.Body.String(); got != "{\"key\":\"theme\",\"value\":\"dark\"}\n" {
+		t.Fatalf("unexpected v1 body %q", got)
+	}
+
+	w = send("PATCH", "/api/v2/users/user1/preferences", `{"sys.flag":true}`)
+	var p Problem
+	json.NewDecoder(w.Body).Decode(&p)
+	if w.Code != http.StatusUnprocessableEntity || w.Header().Get("Content-Type") != problemContentType {
+		t.Fatalf("expected a 422 problem, got %d %v", w.Code, w.Header())
+	}
+	if p.Type != errorDocURL(ErrCodeValidationFailed) || p.Code != ErrCodeValidationFailed || p.Status != 422 || p.Title != "Unprocessable Entity" || p.Instance != "/api/v2/users/user1/preferences" || len(p.Violations) != 1 || p.Violations[0].Code != ErrCodeKeyInvalid {
+		t.Fatalf("unexpected problem %+v", p)
+	}
+
+	// Errors from the middleware chain are problems too.
+	token = "Bearer invalid"
+	w = send("GET", "/api/v2/users/user1/preferences", "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Content-Type") != problemContentType {
+		t.Fatalf("expected a 401 problem, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestVersionOf(t *testing.T) {
+	for etag, want := range map[string]int64{`"7"`: 7, `"7-abc"`: 7, "": 0, `W/"x"`: 0} {
+		if got := versionOf(etag); got != want {
+			t.Errorf("versionOf(%q) = %d, want %d", etag, got, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Preference values are arbitrary JSON: strings, numbers, booleans, null,
+// objects and arrays. Values decoded from requests use json.Number for
+// numbers so they round-trip without float conversion. Preferences stored
+// before typed values are all strings and read back unchanged.
+
+// decodeJSON decodes a request body, keeping numbers as json.Number.
+func decodeJSON(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// DynamoDB limits on stored values. Numbers keep at most 38 significant
+// digits, and nonzero ones lie between 1E-130 and 9.9…9E+125. Items nest at
+// most 32 levels deep; a value sits inside the item's preferences map,
+// which takes two of them.
+const (
+	maxNumberDigits   = 38
+	maxNumberExponent = 125
+	minNumberExponent = -130
+	maxValueDepth     = 30
+)
+
+// valueViolation describes why v cannot be stored in DynamoDB, or returns
+// "" if it can.
+func valueViolation(v any) string {
+	return nestedViolation(v, 0)
+}
+
+func nestedViolation(v any, depth int) string {
+	switch v := v.(type) {
+	case json.Number:
+		return numberViolation(v.String())
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "number is not finite"
+		}
+		return numberViolation(strconv.FormatFloat(v, 'g', -1, 64))
+	case map[string]any:
+		if depth >= maxValueDepth {
+			return fmt.Sprintf("value is nested more than %d levels deep", maxValueDepth)
+		}
+		for _, e := range v {
+			if reason := nestedViolation(e, depth+1); reason != "" {
+				return reason
+			}
+		}
+	case []any:
+		if depth >= maxValueDepth {
+			return fmt.Sprintf("value is nested more than %d levels deep", maxValueDepth)
+		}
+		for _, e := range v {
+			if reason := nestedViolation(e, depth+1); reason != "" {
+				return reason
+			}
+		}
+	}
+	return ""
+}
+
+// numberViolation checks a JSON number against DynamoDB's precision and
+// range.
+func numberViolation(n string) string {
+	mantissa, exp := strings.TrimLeft(n, "+-"), 0
+	if i := strings.IndexAny(mantissa, "eE"); i >= 0 {
+		e, err := strconv.Atoi(mantissa[i+1:])
+		if err != nil {
+			return "number is out of range"
+		}
+		mantissa, exp = mantissa[:i], e
+	}
+	whole, frac, _ := strings.Cut(mantissa, ".")
+	// The value is digits × 10^exp.
+	digits := strings.TrimLeft(whole+frac, "0")
+	if digits == "" {
+		return ""
+	}
+	exp -= len(frac)
+	significant := strings.TrimRight(digits, "0")
+	exp += len(digits) - len(significant)
+	if len(significant) > maxNumberDigits {
+		return fmt.Sprintf("number has more than %d significant digits", maxNumberDigits)
+	}
+	switch top := exp + len(significant) - 1; {
+	case top > maxNumberExponent:
+		return "number is too large"
+	case top < minNumberExponent:
+		return "number is too small"
+	}
+	return ""
+}
+
+// marshalValue converts a preference value to its DynamoDB representation.
+func marshalValue(v any) (types.AttributeValue, error) {
+	switch v := v.(type) {
+	case nil:
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	case string:
+		return &types.AttributeValueMemberS{Value: v}, nil
+	case bool:
+		return &types.AttributeValueMemberBOOL{Value: v}, nil
+	case json.Number:
+		return &types.AttributeValueMemberN{Value: v.String()}, nil
+	case float64:
+		return &types.AttributeValueMemberN{Value: strconv.FormatFloat(v, 'g', -1, 64)}, nil
+	case int:
+		return &types.AttributeValueMemberN{Value: strconv.Itoa(v)}, nil
+	case int64:
+		return &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}, nil
+	case map[string]any:
+		m := make(map[string]types.AttributeValue, len(v))
+		for k, e := range v {
+			av, err := marshalValue(e)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = av
+		}
+		return &types.AttributeValueMemberM{Value: m}, nil
+	case []any:
+		l := make([]types.AttributeValue, len(v))
+		for i, e := range v {
+			av, err := marshalValue(e)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = av
+		}
+		return &types.AttributeValueMemberL{Value: l}, nil
+	}
+	return nil, fmt.Errorf("unsupported preference value type %T", v)
+}
+
+// unmarshalValue converts a stored DynamoDB attribute back to a preference
+// value. ok is false for attribute types preferences never use (sets and
+// binary).
+func unmarshalValue(av types.AttributeValue) (v any, ok bool) {
+	switch av := av.(type) {
+	case *types.AttributeValueMemberS:
+		return av.Value, true
+	case *types.AttributeValueMemberN:
+		return json.Number(av.Value), true
+	case *types.AttributeValueMemberBOOL:
+		return av.Value, true
+	case *types.AttributeValueMemberNULL:
+		return nil, true
+	case *types.AttributeValueMemberM:
+		m := make(map[string]any, len(av.Value))
+		for k, e := range av.Value {
+			if v, ok := unmarshalValue(e); ok {
+				m[k] = v
+			}
+		}
+		return m, true
+	case *types.AttributeValueMemberL:
+		l := make([]any, 0, len(av.Value))
+		for _, e := range av.Value {
+			if v, ok := unmarshalValue(e); ok {
+				l = append(l, v)
+			}
+		}
+		return l, true
+	}
+	return nil, false
+}
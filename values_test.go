@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestValueRoundTrip(t *testing.T) {
+	var v any
+	if err := decodeJSON(strings.NewReader(`{"s":"dark","n":1.50,"big":12345678901234567890,"b":true,"z":null,"l":[1,"a"],"m":{"x":false}}`), &v); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	av, err := marshalValue(v)
+	if err != nil {
+		t.Fatalf("marshalValue: %v", err)
+	}
+	got, ok := unmarshalValue(av)
+	if !ok || !reflect.DeepEqual(got, v) {
+		t.Fatalf("round trip mismatch:\n got %#v\nwant %#v", got, v)
+	}
+
+	m := got.(map[string]any)
+	if m["big"] != json.Number("12345678901234567890") || m["n"] != json.Number("1.50") {
+		t.Fatalf("expected numbers preserved exactly, got %v and %v", m["big"], m["n"])
+	}
+}
+
+func TestValueViolation(t *testing.T) {
+	deep := any("x")
+	for range maxValueDepth + 1 {
+		deep = []any{deep}
+	}
+	tests := []struct {
+		value any
+		valid bool
+	}{
+		{json.Number("0"), true},
+		{json.Number("-0.000"), true},
+		{json.Number("12345678901234567890123456789012345678"), true},
+		{json.Number("123456789012345678901234567890123456789"), false},
+		{json.Number("1234567890123456789012345678901234567800000"), true},
+		{json.Number("0.1234567890123456789012345678901234567"), true},
+		{json.Number("9.9999999999999999999999999999999999999E+125"), true},
+		{json.Number("1E+126"), false},
+		{json.Number("1E-130"), true},
+		{json.Number("1E-131"), false},
+		{json.Number("1e99999999999999999999"), false},
+		{map[string]any{"a": []any{json.Number("1E200")}}, false},
+		{[]any{deep}, false},
+		{deep.([]any)[0], true},
+		{"1E200", true},
+	}
+	for _, tt := range tests {
+		if got := valueViolation(tt.value) == ""; got != tt.valid {
+			t.Errorf("%v: expected valid=%v, got %q", tt.value, tt.valid, valueViolation(tt.value))
+		}
+	}
+}
+
+func TestMarshalValue_Unsupported(t *testing.T) {
+	if _, err := marshalValue(struct{}{}); err == nil {
+		t.Fatal("expected error for unsupported type")
+	}
+}
+
+func TestUnmarshalValue_LegacyString(t *testing.T) {
+	v, ok := unmarshalValue(&types.AttributeValueMemberS{Value: "dark"})
+	if !ok || v != "dark" {
+		t.Fatalf("expected plain string, got %#v", v)
+	}
+	if _, ok := unmarshalValue(&types.AttributeValueMemberSS{Value: []string{"a"}}); ok {
+		t.Fatal("expected string sets to be rejected")
+	}
+}
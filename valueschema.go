@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// ValueSchemas validates preference values against operator-supplied JSON
+// Schemas, loaded from the file named by VALUE_SCHEMA_FILE. The file is a JSON
+// object mapping a key, or a namespace ending in "." such as "notifications.",
+// to the schema for its values. A key's own schema takes precedence over its
+// namespaces, and a longer namespace over a shorter one.
+type ValueSchemas struct {
+	schemas map[string]*jsonschema.Schema
+}
+
+// LoadValueSchemas reads and compiles a value schema file.
+func LoadValueSchemas(path string) (*ValueSchemas, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading value schemas: %w", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing value schemas: %w", err)
+	}
+
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	s := &ValueSchemas{schemas: make(map[string]*jsonschema.Schema, len(raw))}
+	for pattern, doc := range raw {
+		if reason := keyViolation(strings.TrimSuffix(pattern, "."), nil); reason != "" {
+			return nil, fmt.Errorf("value schema %q: %s", pattern, reason)
+		}
+		loc := "mem:///" + url.PathEscape(pattern)
+		if err := c.AddResource(loc, bytes.NewReader(doc)); err != nil {
+			return nil, fmt.Errorf("value schema %q: %w", pattern, err)
+		}
+		if s.schemas[pattern], err = c.Compile(loc); err != nil {
+			return nil, fmt.Errorf("value schema %q: %w", pattern, err)
+		}
+	}
+	return s, nil
+}
+
+// lookup returns the schema governing key, or nil.
+func (s *ValueSchemas) lookup(key string) *jsonschema.Schema {
+	if s == nil {
+		return nil
+	}
+	if sch, ok := s.schemas[key]; ok {
+		return sch
+	}
+	for i := len(key) - 1; i > 0; i-- {
+		if key[i] != '.' {
+			continue
+		}
+		if sch, ok := s.schemas[key[:i+1]]; ok {
+			return sch
+		}
+	}
+	return nil
+}
+
+// violations validates value against key's schema and returns one
+// violation per failed constraint. Keys without a schema accept any value.
+func (s *ValueSchemas) violations(key string, value any) []Violation {
+	sch := s.lookup(key)
+	if sch == nil {
+		return nil
+	}
+	err := sch.Validate(value)
+	if err == nil {
+		return nil
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return []Violation{{Key: key, Code: ErrCodeValueInvalid, Reason: err.Error()}}
+	}
+	var out []Violation
+	for _, leaf := range leafErrors(ve) {
+		reason := leaf.Message
+		if leaf.InstanceLocation != "" {
+			reason = "at " + leaf.InstanceLocation + ": " + reason
+		}
+		out = append(out, Violation{Key: key, Code: ErrCodeValueInvalid, Reason: reason})
+	}
+	return slices.CompactFunc(out, func(a, b Violation) bool { return a == b })
+}
+
+// leafErrors returns the innermost causes of a validation error, which name
+// the constraints that failed; the outer errors only say which schema did.
+func leafErrors(ve *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(ve.Causes) == 0 {
+		return []*jsonschema.ValidationError{ve}
+	}
+	var out []*jsonschema.ValidationError
+	for _, c := range ve.Causes {
+		out = append(out, leafErrors(c)...)
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadTestValueSchemas(t *testing.T, doc string) (*ValueSchemas, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	os.WriteFile(path, []byte(doc), 0o600)
+	return LoadValueSchemas(path)
+}
+
+func TestValueSchemas(t *testing.T) {
+	s, err := loadTestValueSchemas(t, `{
+		"theme": {"enum": ["light", "dark", "system"]},
+		"notifications.": {"type": "boolean"},
+		"notifications.digest.": {"type": "object", "properties": {"hour": {"type": "integer", "minimum": 0, "maximum": 23}}, "required": ["hour"]}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key   string
+		value any
+		valid bool
+	}{
+		{"theme", "dark", true},
+		{"theme", "purple", false},
+		{"notifications.email", true, true},
+		{"notifications.email", "yes", false},
+		{"notifications.digest.daily", map[string]any{"hour": json.Number("8")}, true},
+		{"notifications.digest.daily", map[string]any{"hour": json.Number("24")}, false},
+		{"notifications.digest.daily", map[string]any{}, false},
+		{"language", 42, true},
+	}
+	for _, tt := range tests {
+		if got := len(s.violations(tt.key, tt.value)) == 0; got != tt.valid {
+			t.Errorf("%s=%v: expected valid=%v, got %+v", tt.key, tt.value, tt.valid, s.violations(tt.key, tt.value))
+		}
+	}
+
+	v := s.violations("notifications.digest.daily", map[string]any{"hour": json.Number("24")})
+	if len(v) != 1 || !strings.HasPrefix(v[0].Reason, "at /hour: ") {
+		t.Fatalf("expected the failing location in the reason, got %+v", v)
+	}
+}
+
+func TestLoadValueSchemas_Invalid(t *testing.T) {
+	for _, doc := range []string{
+		`[]`,
+		`{"theme": {"type": "colour"}}`,
+		`{"a..b": {}}`,
+	} {
+		if _, err := loadTestValueSchemas(t, doc); err == nil {
+			t.Errorf("%s: expected an error", doc)
+		}
+	}
+}
+
+func TestValidatePrefs_Values(t *testing.T) {
+	schemas, err := loadTestValueSchemas(t, `{"items_per_page": {"type": "integer", "minimum": 10, "maximum": 100}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMockStore()
+	h := NewPreferencesHandler(store, testLogger(), HandlerOptions{ValueSchemas: schemas})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/users/{userId}/preferences/{key}", h.SetOne)
+	put := func(body string) (int, APIError) {
+		req := httptest.NewRequest("PUT", "/api/v1/users/user1/preferences/items_per_page", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, withClaims(req, "user1"))
+		var resp APIError
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := put(`{"value":500}`)
+	if code != http.StatusUnprocessableEntity || len(resp.Violations) != 1 || resp.Violations[0].Key != "items_per_page" {
+		t.Fatalf("expected 422 naming the key, got %d %+v", code, resp)
+	}
+	if _, ok := store.prefs["user1"]; ok {
+		t.Fatal("expected the invalid value not to be stored")
+	}
+	if code, _ := put(`{"value":25}`); code != http.StatusOK {
+		t.Fatalf("expected a valid value to be stored, got %d", code)
+	}
+}
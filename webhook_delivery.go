@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Webhook delivery tuning: each delivery gets webhookMaxAttempts attempts,
+// webhookBackoff apart at first and doubling up to webhookMaxBackoff, and
+// delivery records expire after webhookDeliveryRetention. Each
+// subscription has webhookWorkers workers taking deliveries from a queue
+// of webhookQueueSize; an instance claims a delivery for webhookClaimTTL
+// before attempting it.
+const (
+	webhookMaxAttempts       = 8
+	webhookMaxBackoff        = 5 * time.Minute
+	webhookCircuitThreshold  = 5
+	webhookDeliveryRetention = 7 * 24 * time.Hour
+	webhookWorkers           = 4
+	webhookQueueSize         = 256
+	webhookClaimTTL          = time.Minute
+)
+
+var (
+	webhookBackoff         = 2 * time.Second
+	webhookCircuitCooldown = time.Minute
+	// webhookSweepInterval is how often pending deliveries that are due
+	// are looked for.
+	webhookSweepInterval = 5 * time.Second
+)
+
+// WebhookDispatcher is the ChangeSink delivering change events to webhook
+// subscriptions. It caches the subscriptions, reloading them every refresh
+// and whenever this instance's webhook API changes one. Each subscription
+// receives only the event types and keys its filters select, and nothing
+// when a write changed none of its keys. Deliveries are signed with the
+// subscription's secret, recorded in the store and can be redriven; a
+// failing endpoint trips its circuit breaker.
+//
+// Every delivery is saved as pending before it is attempted, and each
+// attempt is made by one of the subscription's workers, so a slow endpoint
+// holds up only its own queue. A failed attempt is not retried in memory:
+// the delivery stays pending, due again after its backoff, and the sweep
+// (see Run) claims due deliveries from the store and queues them again. So
+// retries, deliveries a full queue could not take and deliveries an
+// instance did not finish before it stopped are all resumed, by whichever
+// instance claims them.
+type WebhookDispatcher struct {
+	store    WebhookStore
+	client   *http.Client
+	refresh  time.Duration
+	source   string
+	logger   *slog.Logger
+	circuits webhookCircuits
+
+	mu     sync.RWMutex
+	subs   []Webhook
+	queues map[string]chan webhookJob
+}
+
+// webhookJob is a delivery queued for a subscription's workers.
+type webhookJob struct {
+	wh  Webhook
+	del WebhookDelivery
+}
+
+// NewWebhookDispatcher creates a dispatcher with no subscriptions; call
+// Refresh before serving.
+func NewWebhookDispatcher(store WebhookStore, refresh time.Duration, source string, logger *slog.Logger) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		store:   store,
+		client:  newWebhookClient(),
+		refresh: refresh,
+		source:  source,
+		logger:  logger,
+		queues:  make(map[string]chan webhookJob),
+	}
+}
+
+// Refresh reloads the subscriptions, stopping the workers of any that are
+// gone. On failure the cached ones are kept.
+func (d *WebhookDispatcher) Refresh(ctx context.Context) error {
+	subs, err := d.store.ListWebhooks(ctx, "")
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.subs = subs
+	for id, q := range d.queues {
+		if !slices.ContainsFunc(subs, func(wh Webhook) bool { return wh.ID == id }) {
+			close(q)
+			delete(d.queues, id)
+		}
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+// Start loads the subscriptions, then refreshes them and sweeps for due
+// deliveries in the background until ctx is cancelled. A failed first load
+// is logged and left to the refresh.
+func (d *WebhookDispatcher) Start(ctx context.Context) {
+	lctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	if err := d.Refresh(lctx); err != nil {
+		d.logger.Error("initial webhook load failed", "error", err)
+	}
+	cancel()
+	go d.Run(ctx)
+}
+
+// Run refreshes the subscriptions, if refresh is set, and sweeps for due
+// deliveries every webhookSweepInterval until ctx is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	var refresh <-chan time.Time
+	if d.refresh > 0 {
+		ticker := time.NewTicker(d.refresh)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+	sweep := time.NewTicker(webhookSweepInterval)
+	defer sweep.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-refresh:
+			if err := d.Refresh(ctx); err != nil {
+				d.logger.Warn("webhook refresh failed; using cached subscriptions", "error", err)
+			}
+		case <-sweep.C:
+			d.sweep(ctx)
+		}
+	}
+}
+
+// Listening reports whether any subscription is registered.
+func (d *WebhookDispatcher) Listening() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.subs) > 0
+}
+
+// PublishChange saves a pending delivery of e to each matching
+// subscription and queues it for the subscription's workers.
+func (d *WebhookDispatcher) PublishChange(ctx context.Context, e ChangeEvent) {
+	d.mu.RLock()
+	subs := d.subs
+	d.mu.RUnlock()
+	// Deliveries outlive the request that caused them.
+	ctx = context.WithoutCancel(ctx)
+	now := time.Now().UTC()
+	for _, wh := range subs {
+		if !subscribes(wh, e.Type) {
+			continue
+		}
+		filtered := e
+		if filtered.Changes = filterChanges(wh.Keys, e.Changes); len(filtered.Changes) == 0 {
+			continue
+		}
+		del := WebhookDelivery{
+			ID:            newID(),
+			WebhookID:     wh.ID,
+			EventID:       e.ID,
+			EventType:     e.Type,
+			Status:        DeliveryPending,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+			Event:         filtered,
+			NextAttemptAt: now.Add(webhookClaimTTL),
+		}
+		// Saved already claimed by this instance, so the sweep leaves it
+		// alone unless this instance does not get to it.
+		d.record(ctx, del)
+		d.enqueue(wh, del)
+	}
+}
+
+// subscribes reports whether wh receives events of type typ: those it
+// lists, or preference events when it lists none.
+func subscribes(wh Webhook, typ string) bool {
+	if len(wh.Events) == 0 {
+		return typ == EventPreferencesUpdated || typ == EventPreferencesDeleted
+	}
+	return slices.Contains(wh.Events, typ)
+}
+
+// Redrive restarts a delivery to wh, which may have changed since, with a
+// fresh set of attempts, and returns its record. It also closes wh's
+// circuit, so the first attempt reaches the endpoint.
+func (d *WebhookDispatcher) Redrive(ctx context.Context, wh Webhook, del WebhookDelivery) WebhookDelivery {
+	d.circuits.reset(wh.ID)
+	now := time.Now().UTC()
+	del.Status, del.UpdatedAt, del.RoundStart, del.NextAttemptAt = DeliveryPending, now, del.Attempts, now.Add(webhookClaimTTL)
+	d.record(ctx, del)
+	d.enqueue(wh, del)
+	return del
+}
+
+// enqueue hands del to wh's workers, starting them on first use, and
+// reports whether they took it. A delivery the queue has no room for stays
+// pending in the store for the sweep.
+func (d *WebhookDispatcher) enqueue(wh Webhook, del WebhookDelivery) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	q, ok := d.queues[wh.ID]
+	if !ok {
+		q = make(chan webhookJob, webhookQueueSize)
+		d.queues[wh.ID] = q
+		for range webhookWorkers {
+			go d.work(q)
+		}
+	}
+	select {
+	case q <- webhookJob{wh: wh, del: del}:
+		return true
+	default:
+		d.logger.Warn("webhook queue full; delivery left to the sweep", "webhookId", wh.ID, "deliveryId", del.ID)
+		return false
+	}
+}
+
+// work attempts the deliveries of one subscription's queue until Refresh
+// closes it.
+func (d *WebhookDispatcher) work(q <-chan webhookJob) {
+	for job := range q {
+		d.deliver(context.Background(), job.wh, job.del)
+	}
+}
+
+// sweep claims each subscription's pending deliveries that are due and
+// queues them, until a queue is full. Claiming first means only one
+// instance attempts each.
+func (d *WebhookDispatcher) sweep(ctx context.Context) {
+	d.mu.RLock()
+	subs := d.subs
+	d.mu.RUnlock()
+	now := time.Now().UTC()
+	for _, wh := range subs {
+		due, err := d.store.ListDueWebhookDeliveries(ctx, wh.ID, now, webhookQueueSize)
+		if err != nil {
+			d.logger.Warn("store.ListDueWebhookDeliveries failed", "error", err, "webhookId", wh.ID)
+			continue
+		}
+		for _, del := range due {
+			del, err := d.store.ClaimWebhookDelivery(ctx, del, now.Add(webhookClaimTTL))
+			if errors.Is(err, ErrPreconditionFailed) {
+				continue
+			}
+			if err != nil {
+				d.logger.Warn("store.ClaimWebhookDelivery failed", "error", err, "webhookId", wh.ID, "deliveryId", del.ID)
+				break
+			}
+			if !d.enqueue(wh, del) {
+				break
+			}
+		}
+	}
+}
+
+// deliver makes one attempt at del and records the outcome: succeeded,
+// failed once the endpoint rejects it or the round's webhookMaxAttempts
+// attempts are used, or else still pending, due again after a backoff
+// that doubles with each attempt.
+func (d *WebhookDispatcher) deliver(ctx context.Context, wh Webhook, del WebhookDelivery) {
+	code, err := d.attempt(ctx, wh, del)
+	del.Attempts++
+	now := time.Now().UTC()
+	del.LastStatus, del.LastError, del.UpdatedAt = code, "", now
+	switch {
+	case err == nil:
+		del.Status = DeliverySucceeded
+	case del.Attempts-del.RoundStart >= webhookMaxAttempts || !retryableDelivery(code):
+		del.Status, del.LastError = DeliveryFailed, err.Error()
+		d.logger.Warn("webhook delivery failed", "error", err, "webhookId", wh.ID, "deliveryId", del.ID, "eventId", del.EventID, "attempts", del.Attempts)
+	default:
+		del.LastError = err.Error()
+		del.NextAttemptAt = now.Add(webhookRetryDelay(del.Attempts - del.RoundStart))
+	}
+	d.record(ctx, del)
+}
+
+// webhookRetryDelay is the backoff after the nth failed attempt of a round.
+func webhookRetryDelay(n int) time.Duration {
+	delay := webhookBackoff
+	for range n - 1 {
+		if delay *= 2; delay >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return delay
+}
+
+// attempt makes one delivery attempt, returning the endpoint's status code
+// when it answered. While wh's circuit is open the endpoint is not called.
+func (d *WebhookDispatcher) attempt(ctx context.Context, wh Webhook, del WebhookDelivery) (int, error) {
+	if !d.circuits.allow(wh.ID) {
+		return 0, errCircuitOpen
+	}
+	code, err := d.post(ctx, wh, del)
+	// Rejections mean the endpoint is up.
+	d.circuits.done(wh.ID, err == nil || !retryableDelivery(code))
+	return code, err
+}
+
+// record saves a delivery record, logging failures. A record that has
+// been erased is left deleted.
+func (d *WebhookDispatcher) record(ctx context.Context, del WebhookDelivery) {
+	err := d.store.PutWebhookDelivery(ctx, del)
+	if errors.Is(err, ErrNotFound) {
+		d.logger.Info("webhook delivery record was deleted", "webhookId", del.WebhookID, "deliveryId", del.ID)
+		return
+	}
+	if err != nil {
+		d.logger.Error("store.PutWebhookDelivery failed", "error", err, "webhookId", del.WebhookID, "deliveryId", del.ID)
+	}
+}
+
+func (d *WebhookDispatcher) post(ctx context.Context, wh Webhook, del WebhookDelivery) (int, error) {
+	body, err := encodeEvent(del.Event, d.source)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", cloudEventsContentType)
+	req.Header.Set("X-Webhook-Id", wh.ID)
+	req.Header.Set("X-Webhook-Event", del.EventType)
+	req.Header.Set("X-Webhook-Delivery", del.ID)
+	req.Header.Set("X-Webhook-Signature", signWebhook(wh.Secret, time.Now(), body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// newWebhookClient returns the client deliveries are sent with. Its dialer
+// refuses internal addresses (internalAddr) after DNS resolution, so a
+// subscription's hostname cannot be rebound to one after registration, and
+// redirects are dialed the same way. It never goes through a proxy, which
+// would dial on its behalf.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if internalAddr(ap.Addr()) {
+				return fmt.Errorf("webhook address %s is internal", ap.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 5 * time.Second, Transport: transport}
+}
+
+// signWebhook returns the X-Webhook-Signature of body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">". The
+// timestamp lets receivers reject replays.
+func signWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryableDelivery reports whether an attempt ending with code (0: no
+// response) is worth repeating: not when the endpoint rejected the request.
+func retryableDelivery(code int) bool {
+	return code == 0 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+var errCircuitOpen = errors.New("circuit open: endpoint failing")
+
+// webhookCircuits is a circuit breaker per subscription. After
+// webhookCircuitThreshold consecutive failed attempts the circuit opens
+// and attempts fail without calling the endpoint; after
+// webhookCircuitCooldown one attempt is let through, closing the circuit
+// on success and reopening it on failure.
+type webhookCircuits struct {
+	mu     sync.Mutex
+	states map[string]*circuitState
+}
+
+type circuitState struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (c *webhookCircuits) allow(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.states[id]
+	if st == nil || st.openUntil.IsZero() {
+		return true
+	}
+	if st.probing || time.Now().Before(st.openUntil) {
+		return false
+	}
+	st.probing = true
+	return true
+}
+
+func (c *webhookCircuits) done(id string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ok {
+		delete(c.states, id)
+		return
+	}
+	if c.states == nil {
+		c.states = make(map[string]*circuitState)
+	}
+	st := c.states[id]
+	if st == nil {
+		st = &circuitState{}
+		c.states[id] = st
+	}
+	st.failures++
+	if st.probing || st.failures >= webhookCircuitThreshold {
+		st.openUntil, st.probing = time.Now().Add(webhookCircuitCooldown), false
+	}
+}
+
+func (c *webhookCircuits) reset(id string) {
+	c.mu.Lock()
+	delete(c.states, id)
+	c.mu.Unlock()
+}
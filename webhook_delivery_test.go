@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitDelivery waits for webhook id's only delivery to stop being pending.
+func waitDelivery(t *testing.T, store *mockWebhookStore, id string) WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		list, _ := store.ListWebhookDeliveries(context.Background(), id, "")
+		if len(list) == 1 && list[0].Status != DeliveryPending {
+			return list[0]
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("delivery did not finish")
+	return WebhookDelivery{}
+}
+
+func TestWebhookDispatcher_RetriesAndSigns(t *testing.T) {
+	defer func(b, s time.Duration) { webhookBackoff, webhookSweepInterval = b, s }(webhookBackoff, webhookSweepInterval)
+	webhookBackoff, webhookSweepInterval = time.Millisecond, time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls atomic.Int32
+	deliveryIDs := make(chan string, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sig := r.Header.Get("X-Webhook-Signature")
+		ts, _, _ := strings.Cut(strings.TrimPrefix(sig, "t="), ",")
+		unix, _ := strconv.ParseInt(ts, 10, 64)
+		if want := signWebhook("s3cret-s3cret-s3", time.Unix(unix, 0), body); sig != want {
+			t.Errorf("signature %q does not match %q", sig, want)
+		}
+		deliveryIDs <- r.Header.Get("X-Webhook-Delivery")
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	hooks := newMockWebhookStore()
+	hooks.items["a"] = Webhook{ID: "a", URL: srv.URL, Secret: "s3cret-s3cret-s3"}
+	d := NewWebhookDispatcher(hooks, 0, "user-prefs", testLogger())
+	d.client = srv.Client() // the test server is on loopback
+	d.Refresh(ctx)
+	go d.Run(ctx) // retries are picked up by the sweep
+	d.PublishChange(ctx, ChangeEvent{ID: "1", Type: EventPreferencesUpdated, UserID: "user1",
+		Changes: map[string]any{"theme": "dark"}})
+
+	del := waitDelivery(t, hooks, "a")
+	if del.Status != DeliverySucceeded || del.Attempts != 3 || del.LastStatus != http.StatusOK || del.EventID != "1" {
+		t.Fatalf("expected success on the third attempt, got %+v", del)
+	}
+	if first, last := <-deliveryIDs, <-deliveryIDs; first != del.ID || last != del.ID {
+		t.Fatalf("expected every attempt to carry the delivery ID %q, got %q and %q", del.ID, first, last)
+	}
+}
+
+func TestWebhookDispatcher_Redrive(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusGone)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	hooks := newMockWebhookStore()
+	hooks.items["a"] = Webhook{ID: "a", URL: srv.URL}
+	d := NewWebhookDispatcher(hooks, 0, "user-prefs", testLogger())
+	d.client = srv.Client() // the test server is on loopback
+	d.Refresh(context.Background())
+	d.PublishChange(context.Background(), ChangeEvent{ID: "1", Type: EventPreferencesUpdated, UserID: "user1",
+		Changes: map[string]any{"theme": "dark"}})
+
+	// Rejections are not retried.
+	del := waitDelivery(t, hooks, "a")
+	if del.Status != DeliveryFailed || del.Attempts != 1 || del.LastStatus != http.StatusGone || del.LastError == "" {
+		t.Fatalf("expected a failed delivery, got %+v", del)
+	}
+
+	status.Store(http.StatusNoContent)
+	if got := d.Redrive(context.Background(), hooks.items["a"], del); got.Status != DeliveryPending {
+		t.Fatalf("expected the redriven delivery pending, got %+v", got)
+	}
+	del = waitDelivery(t, hooks, "a")
+	if del.Status != DeliverySucceeded || del.Attempts != 2 || del.LastError != "" || del.Event.Changes["theme"] != "dark" {
+		t.Fatalf("expected the redrive to succeed, got %+v", del)
+	}
+}
+
+func TestWebhookDispatcher_SweepResumesPending(t *testing.T) {
+	received := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Webhook-Delivery")
+	}))
+	defer srv.Close()
+
+	// A delivery an instance saved but never finished, and one another
+	// instance has just claimed.
+	hooks := newMockWebhookStore()
+	hooks.items["a"] = Webhook{ID: "a", URL: srv.URL}
+	now := time.Now().UTC()
+	hooks.deliveries["lost"] = WebhookDelivery{ID: "lost", WebhookID: "a", Status: DeliveryPending, CreatedAt: now,
+		NextAttemptAt: now.Add(-time.Second), Event: ChangeEvent{ID: "1", Changes: map[string]any{"theme": "dark"}}}
+	hooks.deliveries["claimed"] = WebhookDelivery{ID: "claimed", WebhookID: "a", Status: DeliveryPending, CreatedAt: now,
+		NextAttemptAt: now.Add(webhookClaimTTL)}
+	d := NewWebhookDispatcher(hooks, 0, "user-prefs", testLogger())
+	d.client = srv.Client()
+	d.Refresh(context.Background())
+
+	d.sweep(context.Background())
+	select {
+	case id := <-received:
+		if id != "lost" {
+			t.Fatalf("expected the lost delivery resumed, got %q", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the lost delivery resumed")
+	}
+	select {
+	case id := <-received:
+		t.Fatalf("expected the claimed delivery left alone, got %q", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Only one of two sweeps racing for a delivery claims it.
+	del, _ := hooks.GetWebhookDelivery(context.Background(), "claimed")
+	if _, err := hooks.ClaimWebhookDelivery(context.Background(), del, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hooks.ClaimWebhookDelivery(context.Background(), del, now); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected the second claim refused, got %v", err)
+	}
+}
+
+func TestSubscribes(t *testing.T) {
+	all := Webhook{}
+	if !subscribes(all, EventPreferencesUpdated) || subscribes(all, EventCorrectionCreated) {
+		t.Fatal("expected a subscription listing no events to get only preference events")
+	}
+	listed := Webhook{Events: []string{EventCorrectionResolved}}
+	if !subscribes(listed, EventCorrectionResolved) || subscribes(listed, EventPreferencesUpdated) {
+		t.Fatal("expected a subscription listing events to get only those")
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	if got := webhookRetryDelay(1); got != webhookBackoff {
+		t.Fatalf("expected the first retry after %v, got %v", webhookBackoff, got)
+	}
+	if got := webhookRetryDelay(3); got != 4*webhookBackoff {
+		t.Fatalf("expected the backoff doubled twice, got %v", got)
+	}
+	if got := webhookRetryDelay(100); got != webhookMaxBackoff {
+		t.Fatalf("expected the backoff capped, got %v", got)
+	}
+}
+
+func TestNewWebhookClient_RefusesInternalAddresses(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	// Whatever the URL's host resolves to, the dialed address is checked.
+	resp, err := newWebhookClient().Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if err == nil || !strings.Contains(err.Error(), "internal") || calls.Load() != 0 {
+		t.Fatalf("expected the loopback server refused, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestWebhookCircuits(t *testing.T) {
+	defer func(d time.Duration) { webhookCircuitCooldown = d }(webhookCircuitCooldown)
+	webhookCircuitCooldown = 20 * time.Millisecond
+
+	var c webhookCircuits
+	for range webhookCircuitThreshold {
+		if !c.allow("a") {
+			t.Fatal("expected attempts allowed below the threshold")
+		}
+		c.done("a", false)
+	}
+	if c.allow("a") || !c.allow("b") {
+		t.Fatal("expected only the failing endpoint's circuit open")
+	}
+
+	time.Sleep(webhookCircuitCooldown)
+	if !c.allow("a") || c.allow("a") {
+		t.Fatal("expected a single probe after the cooldown")
+	}
+	c.done("a", false)
+	if c.allow("a") {
+		t.Fatal("expected a failed probe to reopen the circuit")
+	}
+
+	time.Sleep(webhookCircuitCooldown)
+	c.allow("a")
+	c.done("a", true)
+	if !c.allow("a") || !c.allow("a") {
+		t.Fatal("expected a successful probe to close the circuit")
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Preference change events webhooks can subscribe to.
+const (
+	EventPreferencesUpdated = "preferences.updated"
+	EventPreferencesDeleted = "preferences.deleted"
+)
+
+// Correction request events (see CorrectionNotifier), delivered only to
+// webhooks that list them.
+const (
+	EventCorrectionCreated  = "correction.created"
+	EventCorrectionResolved = "correction.resolved"
+)
+
+var webhookEvents = []string{EventPreferencesUpdated, EventPreferencesDeleted, EventCorrectionCreated, EventCorrectionResolved}
+
+// maxWebhooksPerOwner caps the subscriptions one principal may register,
+// and minWebhookSecretBytes is the shortest signing secret accepted.
+const (
+	maxWebhooksPerOwner   = 25
+	minWebhookSecretBytes = 16
+)
+
+// Webhook is a subscription to preference change events, owned by the
+// principal (a service or tenant) that registered it. Events and Keys
+// filter what is delivered (see WebhookDispatcher); empty means all
+// preference events, or all keys. Keys may name namespaces ending in "."
+// (requests may also write "notifications.*"). Secret signs deliveries and
+// is only returned when the subscription is created.
+type Webhook struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events,omitempty"`
+	Keys      []string  `json:"keys,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// WebhookStore persists webhook subscriptions.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, wh Webhook) error
+	// GetWebhook returns ErrNotFound if the subscription does not exist.
+	GetWebhook(ctx context.Context, id string) (Webhook, error)
+	// ListWebhooks returns the owner's subscriptions, or all with "".
+	ListWebhooks(ctx context.Context, owner string) ([]Webhook, error)
+	// UpdateWebhook replaces an existing subscription, returning
+	// ErrNotFound if it does not exist.
+	UpdateWebhook(ctx context.Context, wh Webhook) error
+	// DeleteWebhook removes a subscription; deleting a missing one
+	// succeeds.
+	DeleteWebhook(ctx context.Context, id string) error
+	// PutWebhookDelivery creates or replaces a delivery record. Once
+	// attempted, a record is only replaced while it exists, so one deleted
+	// by erasure is not written back: that returns ErrNotFound.
+	PutWebhookDelivery(ctx context.Context, del WebhookDelivery) error
+	// GetWebhookDelivery returns ErrNotFound if the record does not exist
+	// or has expired.
+	GetWebhookDelivery(ctx context.Context, id string) (WebhookDelivery, error)
+	// ListWebhookDeliveries returns a subscription's delivery records,
+	// newest first, only those with status unless it is "", and at most
+	// maxWebhookDeliveries of them.
+	ListWebhookDeliveries(ctx context.Context, webhookID, status string) ([]WebhookDelivery, error)
+	// ListDueWebhookDeliveries returns up to limit of a subscription's
+	// pending deliveries whose NextAttemptAt is not after now, oldest due
+	// first.
+	ListDueWebhookDeliveries(ctx context.Context, webhookID string, now time.Time, limit int) ([]WebhookDelivery, error)
+	// ClaimWebhookDelivery moves a pending delivery's NextAttemptAt to
+	// until and returns it, or ErrPreconditionFailed if it is no longer
+	// pending with the NextAttemptAt del has, because another instance
+	// claimed it first.
+	ClaimWebhookDelivery(ctx context.Context, del WebhookDelivery, until time.Time) (WebhookDelivery, error)
+}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+var deliveryStatuses = []string{DeliveryPending, DeliverySucceeded, DeliveryFailed}
+
+// maxWebhookDeliveries caps the delivery records listed, and the failed
+// deliveries redriven, per request.
+const maxWebhookDeliveries = 100
+
+// WebhookDelivery records the delivery of one event to one subscription:
+// pending while attempts remain, then succeeded or failed. Event is the
+// event as filtered for the subscription, resent on redrive. A pending
+// delivery is next attempted at NextAttemptAt, by whichever instance
+// claims it then; RoundStart is Attempts when it was last redriven.
+type WebhookDelivery struct {
+	ID         string      `json:"id"`
+	WebhookID  string      `json:"webhookId"`
+	EventID    string      `json:"eventId"`
+	EventType  string      `json:"eventType"`
+	Status     string      `json:"status"`
+	Attempts   int         `json:"attempts"`
+	LastStatus int         `json:"lastStatus,omitempty"` // HTTP status of the last attempt
+	LastError  string      `json:"lastError,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	UpdatedAt  time.Time   `json:"updatedAt"`
+	Event      ChangeEvent `json:"-"`
+
+	NextAttemptAt time.Time `json:"-"`
+	RoundStart    int       `json:"-"`
+}
+
+// WebhookDeliveriesResponse wraps a list of delivery records.
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookRedriveRequest is the body of a redrive request: the deliveries
+// to send again, or every failed one when empty.
+type WebhookRedriveRequest struct {
+	DeliveryIDs []string `json:"deliveryIds,omitempty"`
+}
+
+// WebhookRedriveResponse reports the deliveries a redrive restarted.
+type WebhookRedriveResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookRequest is the body of webhook create and replace requests. A
+// replace without a secret keeps the current one; a create without one is
+// given a generated secret.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+	Keys   []string `json:"keys,omitempty"`
+}
+
+// WebhooksResponse wraps a list of webhook subscriptions.
+type WebhooksResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// WebhooksHandler serves webhook registration: services manage their own
+// subscriptions under /internal/webhooks, admins see and remove anyone's.
+type WebhooksHandler struct {
+	prefs *PreferencesHandler
+	store WebhookStore
+	// dispatcher, if set, is reloaded after each change so it takes
+	// effect on this instance at once.
+	dispatcher *WebhookDispatcher
+}
+
+// NewWebhooksHandler creates a webhooks handler sharing prefs' logger;
+// dispatcher may be nil.
+func NewWebhooksHandler(prefs *PreferencesHandler, store WebhookStore, dispatcher *WebhookDispatcher) *WebhooksHandler {
+	return &WebhooksHandler{prefs: prefs, store: store, dispatcher: dispatcher}
+}
+
+// Create registers a subscription owned by the caller. It answers 201 with
+// the secret, which later reads leave out.
+func (h *WebhooksHandler) Create(w http.ResponseWriter, r *http.Request) {
+	claims, _ := ClaimsFromContext(r.Context())
+	req, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	existing, err := h.store.ListWebhooks(r.Context(), claims.Subject)
+	if err != nil {
+		h.prefs.logger.Error("store.ListWebhooks failed", "error", err, "owner", claims.Subject)
+		writeError(w, http.StatusInternalServerError, "failed to create webhook")
+		return
+	}
+	if len(existing) >= maxWebhooksPerOwner {
+		writeError(w, http.StatusConflict, fmt.Sprintf("at most %d webhooks may be registered", maxWebhooksPerOwner))
+		return
+	}
+
+	now := time.Now().UTC()
+	wh := Webhook{
+		ID:        newID(),
+		Owner:     claims.Subject,
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.Events,
+		Keys:      req.Keys,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if wh.Secret == "" {
+		wh.Secret = newID()
+	}
+	if err := h.store.CreateWebhook(r.Context(), wh); err != nil {
+		h.prefs.logger.Error("store.CreateWebhook failed", "error", err, "owner", claims.Subject)
+		writeError(w, http.StatusInternalServerError, "failed to create webhook")
+		return
+	}
+	h.reload(r.Context())
+	w.Header().Set("Location", "/api/v1/internal/webhooks/"+wh.ID)
+	writeJSON(w, http.StatusCreated, wh)
+}
+
+// List returns the caller's subscriptions.
+func (h *WebhooksHandler) List(w http.ResponseWriter, r *http.Request) {
+	claims, _ := ClaimsFromContext(r.Context())
+	h.list(w, r, claims.Subject)
+}
+
+// Get returns one of the caller's subscriptions.
+func (h *WebhooksHandler) Get(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.own(w, r)
+	if !ok {
+		return
+	}
+	wh.Secret = ""
+	writeJSON(w, http.StatusOK, wh)
+}
+
+// Replace changes one of the caller's subscriptions.
+func (h *WebhooksHandler) Replace(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.own(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	wh.URL, wh.Events, wh.Keys, wh.UpdatedAt = req.URL, req.Events, req.Keys, time.Now().UTC()
+	if req.Secret != "" {
+		wh.Secret = req.Secret
+	}
+	err := h.store.UpdateWebhook(r.Context(), wh)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		h.prefs.logger.Error("store.UpdateWebhook failed", "error", err, "id", wh.ID)
+		writeError(w, http.StatusInternalServerError, "failed to update webhook")
+		return
+	}
+	h.reload(r.Context())
+	wh.Secret = ""
+	writeJSON(w, http.StatusOK, wh)
+}
+
+// Delete removes one of the caller's subscriptions.
+func (h *WebhooksHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.own(w, r)
+	if !ok {
+		return
+	}
+	h.delete(w, r, wh.ID)
+}
+
+// AdminList returns subscriptions across owners, optionally filtered by
+// ?owner=.
+func (h *WebhooksHandler) AdminList(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, r.URL.Query().Get("owner"))
+}
+
+// AdminDelete removes any subscription.
+func (h *WebhooksHandler) AdminDelete(w http.ResponseWriter, r *http.Request) {
+	h.delete(w, r, r.PathValue("id"))
+}
+
+// Deliveries lists the delivery records of one of the caller's
+// subscriptions, optionally only those with ?status=.
+func (h *WebhooksHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.own(w, r)
+	if !ok {
+		return
+	}
+	h.deliveries(w, r, wh)
+}
+
+// Redrive sends failed deliveries of one of the caller's subscriptions
+// again.
+func (h *WebhooksHandler) Redrive(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.own(w, r)
+	if !ok {
+		return
+	}
+	h.redrive(w, r, wh)
+}
+
+// AdminDeliveries lists the delivery records of any subscription.
+func (h *WebhooksHandler) AdminDeliveries(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.any(w, r)
+	if !ok {
+		return
+	}
+	h.deliveries(w, r, wh)
+}
+
+// AdminRedrive sends failed deliveries of any subscription again.
+func (h *WebhooksHandler) AdminRedrive(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.any(w, r)
+	if !ok {
+		return
+	}
+	h.redrive(w, r, wh)
+}
+
+// own loads the subscription named in the path, answering 404 when it does
+// not exist or belongs to another principal.
+func (h *WebhooksHandler) own(w http.ResponseWriter, r *http.Request) (Webhook, bool) {
+	claims, _ := ClaimsFromContext(r.Context())
+	wh, err := h.store.GetWebhook(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrNotFound) || (err == nil && wh.Owner != claims.Subject) {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return Webhook{}, false
+	}
+	if err != nil {
+		h.prefs.logger.Error("store.GetWebhook failed", "error", err, "id", r.PathValue("id"))
+		writeError(w, http.StatusInternalServerError, "failed to retrieve webhook")
+		return Webhook{}, false
+	}
+	return wh, true
+}
+
+// any loads the subscription named in the path, whoever owns it.
+func (h *WebhooksHandler) any(w http.ResponseWriter, r *http.Request) (Webhook, bool) {
+	wh, err := h.store.GetWebhook(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return Webhook{}, false
+	}
+	if err != nil {
+		h.prefs.logger.Error("store.GetWebhook failed", "error", err, "id", r.PathValue("id"))
+		writeError(w, http.StatusInternalServerError, "failed to retrieve webhook")
+		return Webhook{}, false
+	}
+	return wh, true
+}
+
+func (h *WebhooksHandler) list(w http.ResponseWriter, r *http.Request, owner string) {
+	list, err := h.store.ListWebhooks(r.Context(), owner)
+	if err != nil {
+		h.prefs.logger.Error("store.ListWebhooks failed", "error", err, "owner", owner)
+		writeError(w, http.StatusInternalServerError, "failed to list webhooks")
+		return
+	}
+	if list == nil {
+		list = []Webhook{}
+	}
+	for i := range list {
+		list[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, WebhooksResponse{Webhooks: list})
+}
+
+func (h *WebhooksHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
+	if err := h.store.DeleteWebhook(r.Context(), id); err != nil {
+		h.prefs.logger.Error("store.DeleteWebhook failed", "error", err, "id", id)
+		writeError(w, http.StatusInternalServerError, "failed to delete webhook")
+		return
+	}
+	h.reload(r.Context())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *WebhooksHandler) deliveries(w http.ResponseWriter, r *http.Request, wh Webhook) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(deliveryStatuses, status) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown status %q", status))
+		return
+	}
+	list, err := h.store.ListWebhookDeliveries(r.Context(), wh.ID, status)
+	if err != nil {
+		h.prefs.logger.Error("store.ListWebhookDeliveries failed", "error", err, "id", wh.ID)
+		writeError(w, http.StatusInternalServerError, "failed to list deliveries")
+		return
+	}
+	if list == nil {
+		list = []WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, WebhookDeliveriesResponse{Deliveries: list[:min(len(list), maxWebhookDeliveries)]})
+}
+
+// redrive restarts the listed deliveries of wh, or its failed ones, with a
+// fresh set of attempts, answering 202 with them.
+func (h *WebhooksHandler) redrive(w http.ResponseWriter, r *http.Request, wh Webhook) {
+	if h.dispatcher == nil {
+		writeError(w, http.StatusServiceUnavailable, "webhook delivery is disabled")
+		return
+	}
+	var req WebhookRedriveRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+			return
+		}
+	}
+	if len(req.DeliveryIDs) > maxWebhookDeliveries {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d deliveries may be redriven at once", maxWebhookDeliveries))
+		return
+	}
+
+	var dels []WebhookDelivery
+	if len(req.DeliveryIDs) == 0 {
+		failed, err := h.store.ListWebhookDeliveries(r.Context(), wh.ID, DeliveryFailed)
+		if err != nil {
+			h.prefs.logger.Error("store.ListWebhookDeliveries failed", "error", err, "id", wh.ID)
+			writeError(w, http.StatusInternalServerError, "failed to redrive deliveries")
+			return
+		}
+		dels = failed[:min(len(failed), maxWebhookDeliveries)]
+	}
+	for _, id := range req.DeliveryIDs {
+		del, err := h.store.GetWebhookDelivery(r.Context(), id)
+		if errors.Is(err, ErrNotFound) || (err == nil && del.WebhookID != wh.ID) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("delivery %q not found", id))
+			return
+		}
+		if err != nil {
+			h.prefs.logger.Error("store.GetWebhookDelivery failed", "error", err, "id", id)
+			writeError(w, http.StatusInternalServerError, "failed to redrive deliveries")
+			return
+		}
+		dels = append(dels, del)
+	}
+
+	for i := range dels {
+		dels[i] = h.dispatcher.Redrive(r.Context(), wh, dels[i])
+	}
+	if dels == nil {
+		dels = []WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusAccepted, WebhookRedriveResponse{Deliveries: dels})
+}
+
+// reload refreshes the dispatcher after a change. A failure only delays
+// the change until the next periodic refresh.
+func (h *WebhooksHandler) reload(ctx context.Context) {
+	if h.dispatcher == nil {
+		return
+	}
+	if err := h.dispatcher.Refresh(ctx); err != nil {
+		h.prefs.logger.Warn("webhook refresh failed", "error", err)
+	}
+}
+
+// decodeWebhookRequest reads and validates a create or replace body,
+// writing a 400 on failure. Deliveries carry preference data, so only
+// https URLs are accepted, and never to internal addresses.
+func decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (WebhookRequest, bool) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidBody, "invalid JSON body")
+		return req, false
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		writeError(w, http.StatusBadRequest, "url must be an absolute https URL")
+		return req, false
+	}
+	if err := checkWebhookHost(r.Context(), u.Hostname()); err != nil {
+		writeError(w, http.StatusBadRequest, "url "+err.Error())
+		return req, false
+	}
+	if req.Secret != "" && len(req.Secret) < minWebhookSecretBytes {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("secret must be at least %d bytes", minWebhookSecretBytes))
+		return req, false
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(webhookEvents, e) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown event %q", e))
+			return req, false
+		}
+	}
+	for i, k := range req.Keys {
+		k = strings.TrimSuffix(k, "*")
+		req.Keys[i] = k
+		if reason := keyViolation(strings.TrimSuffix(k, "."), nil); reason != "" {
+			writeErrorCode(w, http.StatusBadRequest, ErrCodeKeyInvalid, fmt.Sprintf("invalid key %q: %s", k, reason))
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// lookupWebhookHost resolves webhook hostnames; tests replace it.
+var lookupWebhookHost = func(ctx context.Context, host string) ([]netip.Addr, error) {
+	return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+}
+
+// checkWebhookHost rejects webhook hosts that are, or resolve to, internal
+// addresses (see internalAddr). A name that does not resolve yet is
+// accepted: the delivery dialer checks every address it connects to, which
+// also defeats names rebound to internal addresses after registration.
+func checkWebhookHost(ctx context.Context, host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("must not point at an internal address")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if internalAddr(addr) {
+			return errors.New("must not point at an internal address")
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	addrs, err := lookupWebhookHost(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if internalAddr(addr) {
+			return fmt.Errorf("host %s resolves to an internal address", host)
+		}
+	}
+	return nil
+}
+
+// internalAddr reports whether addr is one webhooks must not reach:
+// unspecified, loopback, link-local (including the 169.254.169.254
+// metadata endpoint), multicast, RFC 1918 or unique local (fc00::/7), or
+// carrier-grade NAT (100.64.0.0/10).
+func internalAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() || addr.IsPrivate() || cgnatPrefix.Contains(addr)
+}
+
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockWebhookStore is an in-memory WebhookStore for testing. Deliveries
+// are recorded in the background, so they are guarded by mu.
+type mockWebhookStore struct {
+	items map[string]Webhook
+
+	mu         sync.Mutex
+	deliveries map[string]WebhookDelivery
+}
+
+func newMockWebhookStore() *mockWebhookStore {
+	return &mockWebhookStore{items: make(map[string]Webhook), deliveries: make(map[string]WebhookDelivery)}
+}
+
+func (m *mockWebhookStore) CreateWebhook(_ context.Context, wh Webhook) error {
+	m.items[wh.ID] = wh
+	return nil
+}
+
+func (m *mockWebhookStore) GetWebhook(_ context.Context, id string) (Webhook, error) {
+	wh, ok := m.items[id]
+	if !ok {
+		return Webhook{}, ErrNotFound
+	}
+	return wh, nil
+}
+
+func (m *mockWebhookStore) ListWebhooks(_ context.Context, owner string) ([]Webhook, error) {
+	var out []Webhook
+	for _, wh := range m.items {
+		if owner == "" || wh.Owner == owner {
+			out = append(out, wh)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockWebhookStore) UpdateWebhook(_ context.Context, wh Webhook) error {
+	if _, ok := m.items[wh.ID]; !ok {
+		return ErrNotFound
+	}
+	m.items[wh.ID] = wh
+	return nil
+}
+
+func (m *mockWebhookStore) DeleteWebhook(_ context.Context, id string) error {
+	delete(m.items, id)
+	return nil
+}
+
+func (m *mockWebhookStore) PutWebhookDelivery(_ context.Context, del WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries[del.ID] = del
+	return nil
+}
+
+func (m *mockWebhookStore) GetWebhookDelivery(_ context.Context, id string) (WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	del, ok := m.deliveries[id]
+	if !ok {
+		return WebhookDelivery{}, ErrNotFound
+	}
+	return del, nil
+}
+
+func (m *mockWebhookStore) ListWebhookDeliveries(_ context.Context, webhookID, status string) ([]WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []WebhookDelivery
+	for _, del := range m.deliveries {
+		if del.WebhookID == webhookID && (status == "" || del.Status == status) {
+			out = append(out, del)
+		}
+	}
+	slices.SortFunc(out, func(a, b WebhookDelivery) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return out[:min(len(out), maxWebhookDeliveries)], nil
+}
+
+func (m *mockWebhookStore) ListDueWebhookDeliveries(_ context.Context, webhookID string, now time.Time, limit int) ([]WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []WebhookDelivery
+	for _, del := range m.deliveries {
+		if del.WebhookID == webhookID && del.Status == DeliveryPending && !del.NextAttemptAt.After(now) {
+			out = append(out, del)
+		}
+	}
+	slices.SortFunc(out, func(a, b WebhookDelivery) int { return a.NextAttemptAt.Compare(b.NextAttemptAt) })
+	return out[:min(len(out), limit)], nil
+}
+
+func (m *mockWebhookStore) ClaimWebhookDelivery(_ context.Context, del WebhookDelivery, until time.Time) (WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.deliveries[del.ID]
+	if !ok || cur.Status != DeliveryPending || !cur.NextAttemptAt.Equal(del.NextAttemptAt) {
+		return WebhookDelivery{}, ErrPreconditionFailed
+	}
+	cur.NextAttemptAt = until
+	m.deliveries[del.ID] = cur
+	return cur, nil
+}
+
+func TestWebhooks(t *testing.T) {
+	defer func(f func(context.Context, string) ([]netip.Addr, error)) { lookupWebhookHost = f }(lookupWebhookHost)
+	lookupWebhookHost = func(_ context.Context, host string) ([]netip.Addr, error) {
+		if host == "intranet.example.com" {
+			return []netip.Addr{netip.MustParseAddr("93.184.216.34"), netip.MustParseAddr("10.0.0.5")}, nil
+		}
+		return []netip.Addr{netip.MustParseAddr("93.184.216.34")}, nil
+	}
+	store := newMockWebhookStore()
+	h := NewWebhooksHandler(NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{}), store, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/internal/webhooks", h.Create)
+	mux.HandleFunc("GET /api/v1/internal/webhooks", h.List)
+	mux.HandleFunc("GET /api/v1/internal/webhooks/{id}", h.Get)
+	mux.HandleFunc("PUT /api/v1/internal/webhooks/{id}", h.Replace)
+	mux.HandleFunc("DELETE /api/v1/internal/webhooks/{id}", h.Delete)
+	mux.HandleFunc("GET /api/v1/admin/webhooks", h.AdminList)
+	send := func(subject, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		claims := Claims{Subject: subject, Kind: PrincipalService, Scopes: []string{ScopeRead}}
+		req = req.WithContext(context.WithValue(req.Context(), claimsKey, claims))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("crm", "POST", "/api/v1/internal/webhooks", `{"url":"https://crm.example.com/hooks","events":["preferences.updated"],"keys":["notifications.*"]}`)
+	var created Webhook
+	json.NewDecoder(w.Body).Decode(&created)
+	if w.Code != http.StatusCreated || created.Owner != "crm" || created.Secret == "" || created.Keys[0] != "notifications." || w.Header().Get("Location") != "/api/v1/internal/webhooks/"+created.ID {
+		t.Fatalf("unexpected create result %d %+v", w.Code, created)
+	}
+	send("billing", "POST", "/api/v1/internal/webhooks", `{"url":"https://billing.example.com/hooks"}`)
+
+	w = send("crm", "GET", "/api/v1/internal/webhooks", "")
+	var list WebhooksResponse
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Webhooks) != 1 || list.Webhooks[0].ID != created.ID || list.Webhooks[0].Secret != "" {
+		t.Fatalf("expected the caller's webhook without its secret, got %+v", list)
+	}
+
+	w = send("crm", "PUT", "/api/v1/internal/webhooks/"+created.ID, `{"url":"https://crm.example.com/v2/hooks"}`)
+	var replaced Webhook
+	json.NewDecoder(w.Body).Decode(&replaced)
+	if w.Code != http.StatusOK || replaced.URL != "https://crm.example.com/v2/hooks" || len(replaced.Events) != 0 || replaced.Secret != "" {
+		t.Fatalf("unexpected replace result %d %+v", w.Code, replaced)
+	}
+	if store.items[created.ID].Secret != created.Secret {
+		t.Fatal("expected the secret kept")
+	}
+
+	// Other principals cannot see or change the subscription.
+	if w := send("billing", "GET", "/api/v1/internal/webhooks/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for another owner, got %d", w.Code)
+	}
+	if w := send("billing", "DELETE", "/api/v1/internal/webhooks/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for another owner, got %d", w.Code)
+	}
+
+	for _, body := range []string{
+		`{"url":"http://crm.example.com/hooks"}`,
+		`{"url":"https://crm.example.com/hooks","events":["preferences.read"]}`,
+		`{"url":"https://crm.example.com/hooks","keys":["bad key"]}`,
+		`{"url":"https://crm.example.com/hooks","secret":"short"}`,
+		`{"url":"https://127.0.0.1:8443/hooks"}`,
+		`{"url":"https://169.254.169.254/latest/meta-data"}`,
+		`{"url":"https://10.1.2.3/hooks"}`,
+		`{"url":"https://[fd00::1]/hooks"}`,
+		`{"url":"https://[::ffff:192.168.0.1]/hooks"}`,
+		`{"url":"https://localhost/hooks"}`,
+		`{"url":"https://intranet.example.com/hooks"}`,
+	} {
+		if w := send("crm", "POST", "/api/v1/internal/webhooks", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	w = send("ops", "GET", "/api/v1/admin/webhooks", "")
+	list = WebhooksResponse{}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Webhooks) != 2 {
+		t.Fatalf("expected every webhook for admins, got %+v", list)
+	}
+
+	if w := send("crm", "DELETE", "/api/v1/internal/webhooks/"+created.ID, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if _, ok := store.items[created.ID]; ok {
+		t.Fatal("expected the webhook deleted")
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	received := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Webhook-Delivery")
+	}))
+	defer srv.Close()
+
+	store := newMockWebhookStore()
+	store.items["a"] = Webhook{ID: "a", Owner: "crm", URL: srv.URL}
+	now := time.Now().UTC()
+	for i, status := range []string{DeliveryFailed, DeliverySucceeded, DeliveryFailed} {
+		id := strconv.Itoa(i)
+		store.deliveries[id] = WebhookDelivery{ID: id, WebhookID: "a", EventID: "e" + id, Status: status, Attempts: 1,
+			CreatedAt: now.Add(time.Duration(i) * time.Second), Event: ChangeEvent{ID: "e" + id, Changes: map[string]any{"theme": "dark"}}}
+	}
+	d := NewWebhookDispatcher(store, 0, "user-prefs", testLogger())
+	d.client = srv.Client()
+	h := NewWebhooksHandler(NewPreferencesHandler(newMockStore(), testLogger(), HandlerOptions{}), store, d)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/internal/webhooks/{id}/deliveries", h.Deliveries)
+	mux.HandleFunc("POST /api/v1/internal/webhooks/{id}/deliveries:redrive", h.Redrive)
+	send := func(subject, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		claims := Claims{Subject: subject, Kind: PrincipalService, Scopes: []string{ScopeRead}}
+		req = req.WithContext(context.WithValue(req.Context(), claimsKey, claims))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("crm", "GET", "/api/v1/internal/webhooks/a/deliveries?status=failed", "")
+	var list WebhookDeliveriesResponse
+	json.NewDecoder(w.Body).Decode(&list)
+	if w.Code != http.StatusOK || len(list.Deliveries) != 2 || list.Deliveries[0].ID != "2" {
+		t.Fatalf("expected the failed deliveries newest first, got %d %+v", w.Code, list)
+	}
+	if w := send("crm", "GET", "/api/v1/internal/webhooks/a/deliveries?status=lost", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown status, got %d", w.Code)
+	}
+	if w := send("billing", "POST", "/api/v1/internal/webhooks/a/deliveries:redrive", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for another owner, got %d", w.Code)
+	}
+	if w := send("crm", "POST", "/api/v1/internal/webhooks/a/deliveries:redrive", `{"deliveryIds":["9"]}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown delivery, got %d", w.Code)
+	}
+
+	w = send("crm", "POST", "/api/v1/internal/webhooks/a/deliveries:redrive", "")
+	var redriven WebhookRedriveResponse
+	json.NewDecoder(w.Body).Decode(&redriven)
+	if w.Code != http.StatusAccepted || len(redriven.Deliveries) != 2 || redriven.Deliveries[0].Status != DeliveryPending {
+		t.Fatalf("expected both failed deliveries redriven, got %d %+v", w.Code, redriven)
+	}
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case id := <-received:
+			got[id] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the redriven deliveries sent")
+		}
+	}
+	if !got["0"] || !got["2"] {
+		t.Fatalf("expected deliveries 0 and 2 resent, got %v", got)
+	}
+}
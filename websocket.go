@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// Tuning for sync connections: how often the server pings, the largest
+// client message, and how long a connection may live before the client
+// must reconnect (and so re-authenticate).
+var (
+	syncPingInterval = 30 * time.Second
+	syncReadLimit    = int64(16 << 10)
+	syncMaxLifetime  = time.Hour
+)
+
+// Sync message types. Clients send "filter"; the server sends "filter" to
+// acknowledge one, "change" for each event and "error" for a rejected
+// message.
+const (
+	SyncMessageFilter = "filter"
+	SyncMessageChange = "change"
+	SyncMessageError  = "error"
+)
+
+// SyncMessage is a JSON text message on a sync connection.
+type SyncMessage struct {
+	Type string `json:"type"`
+	// Keys is the key filter of a filter message: keys or namespaces
+	// ending in "." ("notifications.*" is accepted); empty means all.
+	Keys  []string     `json:"keys,omitempty"`
+	Event *ChangeEvent `json:"event,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// SyncHandler pushes a user's change events to their connected clients
+// over WebSocket, so every device sees a change made on another one.
+type SyncHandler struct {
+	prefs  *PreferencesHandler
+	bus    *ChangeBus
+	accept websocket.AcceptOptions
+}
+
+// NewSyncHandler creates a sync handler. Cross-origin connections are
+// allowed from allowOrigin (the CORS origin); with "*" any origin may
+// connect unless cookieAuth is set, since a cookie would let any site
+// connect as the user.
+func NewSyncHandler(prefs *PreferencesHandler, bus *ChangeBus, allowOrigin string, cookieAuth bool) *SyncHandler {
+	h := &SyncHandler{prefs: prefs, bus: bus}
+	switch {
+	case allowOrigin != "*":
+		h.accept.OriginPatterns = []string{allowOrigin}
+	case !cookieAuth:
+		h.accept.InsecureSkipVerify = true
+	}
+	return h
+}
+
+// Subscribe upgrades to a WebSocket carrying the user's change events as
+// "change" messages. Auth happens on the upgrade request, like any other
+// route; the connection is closed after syncMaxLifetime so revoked access
+// does not last. ?keys= sets the initial key filter, and the client may
+// replace it at any time with a "filter" message. Events are not replayed:
+// clients re-read the preferences after reconnecting.
+func (h *SyncHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.prefs.authorize(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseKeyFilter(splitList(r.URL.Query().Get("keys")))
+	if err != nil {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeKeyInvalid, err.Error())
+		return
+	}
+	events, cancel, ok := h.bus.Subscribe(userID)
+	if !ok {
+		writeError(w, http.StatusTooManyRequests, fmt.Sprintf("at most %d streams may be open per user", maxStreamsPerUser))
+		return
+	}
+	defer cancel()
+
+	// The hijacked connection keeps the server's read and write
+	// deadlines; pings detect dead peers instead.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	conn, err := websocket.Accept(w, r, &h.accept)
+	if err != nil {
+		// Accept has answered the request.
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(syncReadLimit)
+
+	ctx, stop := context.WithTimeout(r.Context(), syncMaxLifetime)
+	defer stop()
+	updates := make(chan filterUpdate)
+	go h.read(ctx, conn, updates)
+
+	ping := time.NewTicker(syncPingInterval)
+	defer ping.Stop()
+	for {
+		var msg SyncMessage
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				conn.Close(websocket.StatusGoingAway, "connection lifetime reached; reconnect")
+			}
+			return
+		case <-ping.C:
+			if err := conn.Ping(ctx); err != nil {
+				return
+			}
+			continue
+		case u, open := <-updates:
+			if !open {
+				return
+			}
+			if u.err != "" {
+				msg = SyncMessage{Type: SyncMessageError, Error: u.err}
+				break
+			}
+			filter = u.keys
+			msg = SyncMessage{Type: SyncMessageFilter, Keys: filter}
+		case e, open := <-events:
+			if !open {
+				conn.Close(websocket.StatusTryAgainLater, "events missed; reconnect")
+				return
+			}
+			if e.Changes = filterChanges(filter, e.Changes); len(e.Changes) == 0 {
+				continue
+			}
+			msg = SyncMessage{Type: SyncMessageChange, Event: &e}
+		}
+		if err := wsjson.Write(ctx, conn, msg); err != nil {
+			return
+		}
+	}
+}
+
+// filterUpdate is a client's filter message as seen by the writer: the new
+// filter, or why the message was rejected.
+type filterUpdate struct {
+	keys []string
+	err  string
+}
+
+// read passes each message the client sends to the writer, closing updates
+// when the connection ends. Malformed JSON closes the connection.
+func (h *SyncHandler) read(ctx context.Context, conn *websocket.Conn, updates chan<- filterUpdate) {
+	defer close(updates)
+	for {
+		var msg SyncMessage
+		if err := wsjson.Read(ctx, conn, &msg); err != nil {
+			return
+		}
+		var u filterUpdate
+		if msg.Type != SyncMessageFilter {
+			u.err = fmt.Sprintf("unknown message type %q", msg.Type)
+		} else if keys, err := parseKeyFilter(msg.Keys); err != nil {
+			u.err = err.Error()
+		} else {
+			u.keys = keys
+		}
+		select {
+		case updates <- u:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
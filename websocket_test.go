@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+func TestSyncHandler(t *testing.T) {
+	bus := NewChangeBus()
+	store := NewChangePublisher(newMockStore(), nil, testLogger(), bus)
+	h := NewSyncHandler(NewPreferencesHandler(store, testLogger(), HandlerOptions{}), bus, "https://app.example.com", true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("userId", "user1")
+		h.Subscribe(w, withClaims(r, r.URL.Query().Get("sub")))
+	}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, resp, err := websocket.Dial(ctx, url+"?sub=user2", nil); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 on upgrade for another user, got %v", err)
+	}
+	if _, _, err := websocket.Dial(ctx, url+"?sub=user1", &websocket.DialOptions{
+		HTTPHeader: http.Header{"Origin": {"https://evil.example.com"}},
+	}); err == nil {
+		t.Fatal("expected a foreign origin refused")
+	}
+
+	conn, _, err := websocket.Dial(ctx, url+"?sub=user1&keys=theme", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+	// The subscription is registered before the upgrade completes.
+	store.Update(ctx, "user1", map[string]any{"lang": "en"}, nil, Precondition{})
+	store.Update(ctx, "user1", map[string]any{"theme": "dark", "lang": "fr"}, nil, Precondition{})
+
+	read := func() (SyncMessage, error) {
+		var msg SyncMessage
+		err := wsjson.Read(ctx, conn, &msg)
+		return msg, err
+	}
+	msg, err := read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != SyncMessageChange || len(msg.Event.Changes) != 1 || msg.Event.Changes["theme"] != "dark" {
+		t.Fatalf("expected the filtered change, got %+v", msg)
+	}
+
+	wsjson.Write(ctx, conn, SyncMessage{Type: SyncMessageFilter, Keys: []string{"bad key"}})
+	if msg, err := read(); err != nil || msg.Type != SyncMessageError {
+		t.Fatalf("expected an error for an invalid filter, got %+v %v", msg, err)
+	}
+	wsjson.Write(ctx, conn, SyncMessage{Type: SyncMessageFilter, Keys: []string{"notifications.*"}})
+	if msg, err := read(); err != nil || msg.Type != SyncMessageFilter || msg.Keys[0] != "notifications." {
+		t.Fatalf("expected the filter acknowledged, got %+v %v", msg, err)
+	}
+	store.Update(ctx, "user1", map[string]any{"theme": "light", "notifications.email": true}, nil, Precondition{})
+	if msg, err := read(); err != nil || msg.Type != SyncMessageChange || len(msg.Event.Changes) != 1 || msg.Event.Changes["notifications.email"] != true {
+		t.Fatalf("expected the new filter applied, got %+v %v", msg, err)
+	}
+
+	bus.Close()
+	if _, _, err := conn.Read(ctx); websocket.CloseStatus(err) != websocket.StatusTryAgainLater {
+		t.Fatalf("expected the connection closed when events end, got %v", err)
+	}
+}